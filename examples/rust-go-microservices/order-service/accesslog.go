@@ -0,0 +1,64 @@
+package main
+
+import (
+    "sync/atomic"
+    "time"
+)
+
+// Why a request got an access log line, recorded on the line as log_reason.
+const (
+    logReasonError   = "error"
+    logReasonSlow    = "slow"
+    logReasonSampled = "sampled"
+)
+
+// AccessLogSampling decides which requests get an access log line. Errors
+// and slow requests always do; of the rest, one in Rate does. Each line
+// carries the sample_rate it was chosen at, so counting lines weighted by
+// it estimates the traffic that was seen.
+type AccessLogSampling struct {
+    // Rate of 0 or 1 logs every request.
+    Rate int
+    // SlowThreshold, if set, is the latency at which a request counts as
+    // slow.
+    SlowThreshold time.Duration
+
+    // successes counts the requests eligible for sampling, so every Rate-th
+    // is logged.
+    successes atomic.Uint64
+}
+
+// accessLogSampling logs every request unless configured otherwise.
+var accessLogSampling = &AccessLogSampling{}
+
+// accessLogSamplingFromEnv reads ACCESS_LOG_SAMPLE_RATE and
+// ACCESS_LOG_SLOW_THRESHOLD.
+func accessLogSamplingFromEnv() (*AccessLogSampling, error) {
+    rate, err := envInt("ACCESS_LOG_SAMPLE_RATE", 1)
+    if err != nil {
+        return nil, err
+    }
+    slow, err := envDuration("ACCESS_LOG_SLOW_THRESHOLD", 0)
+    if err != nil {
+        return nil, err
+    }
+    return &AccessLogSampling{Rate: rate, SlowThreshold: slow}, nil
+}
+
+// decide reports whether a request that finished with status after latency
+// is logged, why, and the sample rate to record with it.
+func (s *AccessLogSampling) decide(status int, latency time.Duration) (reason string, rate int, ok bool) {
+    switch {
+    case status >= 400:
+        return logReasonError, 1, true
+    case s.SlowThreshold > 0 && latency >= s.SlowThreshold:
+        return logReasonSlow, 1, true
+    case s.Rate <= 1:
+        return logReasonSampled, 1, true
+    }
+    // The first success is logged, then every Rate-th after it.
+    if (s.successes.Add(1)-1)%uint64(s.Rate) != 0 {
+        return "", s.Rate, false
+    }
+    return logReasonSampled, s.Rate, true
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+    "time"
+)
+
+// useAccessLogSampling replaces accessLogSampling for the duration of the
+// test.
+func useAccessLogSampling(t *testing.T, s *AccessLogSampling) {
+    t.Helper()
+
+    prev := accessLogSampling
+    accessLogSampling = s
+    t.Cleanup(func() { accessLogSampling = prev })
+}
+
+func TestAccessLogSamplesSuccessesAndKeepsErrors(t *testing.T) {
+    logs := captureLogs(t)
+    useAccessLogSampling(t, &AccessLogSampling{Rate: 3})
+    r := setupRouter()
+
+    for i := 0; i < 6; i++ {
+        doRequest(r, http.MethodGet, "/health", "")
+        if i%2 == 0 {
+            doRequest(r, http.MethodGet, "/orders/not-a-uuid", "")
+        }
+    }
+
+    var successes, errors int
+    for _, line := range logLines(t, logs) {
+        if line["msg"] != "request" {
+            continue
+        }
+        switch line["status"] {
+        case float64(http.StatusOK):
+            successes++
+            if line["log_reason"] != logReasonSampled || line["sample_rate"] != float64(3) {
+                t.Errorf("sampled line records %v at rate %v, want sampled at 3", line["log_reason"], line["sample_rate"])
+            }
+        case float64(http.StatusBadRequest):
+            errors++
+            if line["log_reason"] != logReasonError || line["sample_rate"] != float64(1) {
+                t.Errorf("error line records %v at rate %v, want error at 1", line["log_reason"], line["sample_rate"])
+            }
+        }
+    }
+    if successes != 2 || errors != 3 {
+        t.Fatalf("logged %d successes and %d errors, want 2 of 6 and all 3", successes, errors)
+    }
+}
+
+func TestAccessLogKeepsSlowRequests(t *testing.T) {
+    s := &AccessLogSampling{Rate: 100, SlowThreshold: time.Second}
+
+    if reason, rate, ok := s.decide(http.StatusOK, 2*time.Second); !ok || reason != logReasonSlow || rate != 1 {
+        t.Fatalf("slow request: got %q at %d, logged %v; want slow at 1", reason, rate, ok)
+    }
+    // The first fast success is sampled; the next 99 aren't.
+    if _, _, ok := s.decide(http.StatusOK, time.Millisecond); !ok {
+        t.Fatal("first success not logged")
+    }
+    for i := 0; i < 99; i++ {
+        if _, _, ok := s.decide(http.StatusCreated, time.Millisecond); ok {
+            t.Fatalf("success %d logged at a rate of 100", i+2)
+        }
+    }
+    if _, _, ok := s.decide(http.StatusServiceUnavailable, time.Millisecond); !ok {
+        t.Fatal("error not logged")
+    }
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+    "strings"
+)
+
+// Address is a postal address. Country is an ISO 3166-1 alpha-2 code;
+// PostalCode and Region are optional, since not every country uses them.
+type Address struct {
+    Name       string `json:"name"`
+    Line1      string `json:"line1"`
+    Line2      string `json:"line2,omitempty"`
+    City       string `json:"city"`
+    Region     string `json:"region,omitempty"`
+    PostalCode string `json:"postal_code,omitempty"`
+    Country    string `json:"country"`
+}
+
+// countryCodes is every officially assigned ISO 3166-1 alpha-2 code.
+var countryCodes = func() map[string]bool {
+    const codes = "AD AE AF AG AI AL AM AO AQ AR AS AT AU AW AX AZ BA BB BD BE BF BG BH BI BJ BL BM BN BO BQ BR BS BT BV BW BY BZ " +
+        "CA CC CD CF CG CH CI CK CL CM CN CO CR CU CV CW CX CY CZ DE DJ DK DM DO DZ EC EE EG EH ER ES ET FI FJ FK FM FO FR " +
+        "GA GB GD GE GF GG GH GI GL GM GN GP GQ GR GS GT GU GW GY HK HM HN HR HT HU ID IE IL IM IN IO IQ IR IS IT JE JM JO JP " +
+        "KE KG KH KI KM KN KP KR KW KY KZ LA LB LC LI LK LR LS LT LU LV LY MA MC MD ME MF MG MH MK ML MM MN MO MP MQ MR MS MT MU MV MW MX MY MZ " +
+        "NA NC NE NF NG NI NL NO NP NR NU NZ OM PA PE PF PG PH PK PL PM PN PR PS PT PW PY QA RE RO RS RU RW " +
+        "SA SB SC SD SE SG SH SI SJ SK SL SM SN SO SR SS ST SV SX SY SZ TC TD TF TG TH TJ TK TL TM TN TO TR TT TV TW TZ " +
+        "UA UG UM US UY UZ VA VC VE VG VI VN VU WF WS YE YT ZA ZM ZW"
+    set := make(map[string]bool)
+    for _, code := range strings.Fields(codes) {
+        set[code] = true
+    }
+    return set
+}()
+
+// normalize trims every field and upper-cases the country code.
+func (a *Address) normalize() {
+    if a == nil {
+        return
+    }
+    for _, f := range []*string{&a.Name, &a.Line1, &a.Line2, &a.City, &a.Region, &a.PostalCode} {
+        *f = strings.TrimSpace(*f)
+    }
+    a.Country = strings.ToUpper(strings.TrimSpace(a.Country))
+}
+
+// validateAddress records the problems with a, if any, under field.
+func validateAddress(verr *ValidationError, field string, a *Address) {
+    if a == nil {
+        return
+    }
+    required := []struct{ name, value string }{{"name", a.Name}, {"line1", a.Line1}, {"city", a.City}}
+    for _, f := range required {
+        if f.value == "" {
+            verr.add(field+"."+f.name, "is required")
+        }
+    }
+    switch {
+    case a.Country == "":
+        verr.add(field+".country", "is required")
+    case !countryCodes[a.Country]:
+        verr.add(field+".country", "%q is not an ISO 3166-1 alpha-2 country code", a.Country)
+    }
+}
+
+// normalizeAddresses tidies the order's addresses and, if the client asked
+// for it, copies the shipping address over any billing address.
+func normalizeAddresses(order *Order) {
+    order.ShippingAddress.normalize()
+    if order.SameAsShipping && order.ShippingAddress != nil {
+        billing := *order.ShippingAddress
+        order.BillingAddress = &billing
+    }
+    order.BillingAddress.normalize()
+}
+
+// validateAddresses checks the order's addresses; see normalizeAddresses.
+func validateAddresses(verr *ValidationError, order *Order) {
+    if order.SameAsShipping && order.ShippingAddress == nil {
+        verr.add("same_as_shipping", "requires a shipping_address")
+    }
+    validateAddress(verr, "shipping_address", order.ShippingAddress)
+    validateAddress(verr, "billing_address", order.BillingAddress)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "path/filepath"
+    "reflect"
+    "testing"
+
+    "github.com/shopspring/decimal"
+)
+
+func testAddress() *Address {
+    return &Address{Name: "Ada Lovelace", Line1: "12 St James's Square", City: "London", PostalCode: "SW1Y 4JH", Country: "GB"}
+}
+
+func TestValidateAddresses(t *testing.T) {
+    tests := []struct {
+        name   string
+        modify func(o *Order)
+        want   []string
+    }{
+        {"no addresses", func(o *Order) {}, nil},
+        {"shipping and billing", func(o *Order) { o.ShippingAddress, o.BillingAddress = testAddress(), testAddress() }, nil},
+        {"same as shipping", func(o *Order) { o.ShippingAddress, o.SameAsShipping = testAddress(), true }, nil},
+        {"same as shipping without shipping", func(o *Order) { o.SameAsShipping = true }, []string{"same_as_shipping"}},
+        {"missing fields", func(o *Order) { o.ShippingAddress = &Address{Country: "US"} },
+            []string{"shipping_address.name", "shipping_address.line1", "shipping_address.city"}},
+        {"missing country", func(o *Order) { a := testAddress(); a.Country = ""; o.BillingAddress = a }, []string{"billing_address.country"}},
+        {"unknown country", func(o *Order) { a := testAddress(); a.Country = "UK"; o.ShippingAddress = a }, []string{"shipping_address.country"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            order := validOrder()
+            tt.modify(order)
+            normalizeAddresses(order)
+            got := fieldsOf(validateOrder(order))
+            if len(got) == 0 && len(tt.want) == 0 {
+                return
+            }
+            if !reflect.DeepEqual(got, tt.want) {
+                t.Fatalf("got fields %v, want %v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestNormalizeAddresses(t *testing.T) {
+    order := validOrder()
+    order.ShippingAddress = &Address{Name: " Ada ", Line1: "1 Main St ", City: "Paris", Country: " fr"}
+    order.BillingAddress = testAddress()
+    order.SameAsShipping = true
+    normalizeAddresses(order)
+
+    want := Address{Name: "Ada", Line1: "1 Main St", City: "Paris", Country: "FR"}
+    if *order.ShippingAddress != want || *order.BillingAddress != want {
+        t.Fatalf("got shipping %+v, billing %+v", order.ShippingAddress, order.BillingAddress)
+    }
+    order.BillingAddress.City = "Lyon"
+    if order.ShippingAddress.City != "Paris" {
+        t.Fatal("billing address shares storage with shipping")
+    }
+}
+
+func TestCreateOrderWithAddresses(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    usePricing(t, TaxRates{Default: decimal.RequireFromString("0.08"), ByDestination: map[string]decimal.Decimal{"DE": decimal.RequireFromString("0.19")}}, ShippingRates{})
+    r := setupRouter()
+
+    body := `{"customer_id":"cust_123","items":[{"product_id":"prod_456","quantity":1,"price":"100"}],"destination":"US",` +
+        `"shipping_address":{"name":"Max Mustermann","line1":"Unter den Linden 1","city":"Berlin","postal_code":"10117","country":"de"},` +
+        `"same_as_shipping":true}`
+    w := doRequest(r, http.MethodPost, "/orders", body)
+    if w.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    var created Order
+    json.Unmarshal(w.Body.Bytes(), &created)
+    if created.Destination != "DE" || !created.Tax.Equal(decimal.RequireFromString("19")) {
+        t.Fatalf("got destination %q, tax %s; want DE, 19", created.Destination, created.Tax)
+    }
+    if created.SameAsShipping {
+        t.Fatal("same_as_shipping echoed in the response")
+    }
+
+    w = doRequest(r, http.MethodGet, "/orders/"+created.OrderID.String(), "")
+    var got Order
+    json.Unmarshal(w.Body.Bytes(), &got)
+    if got.ShippingAddress == nil || got.BillingAddress == nil || *got.BillingAddress != *got.ShippingAddress || got.ShippingAddress.Country != "DE" {
+        t.Fatalf("got shipping %+v, billing %+v", got.ShippingAddress, got.BillingAddress)
+    }
+}
+
+func TestCreateOrderRejectsBadAddress(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    body := `{"customer_id":"cust_123","items":[{"product_id":"prod_456","quantity":1,"price":"10"}],` +
+        `"billing_address":{"name":"Ada","line1":"1 Main St","city":"Springfield","country":"XX"}}`
+    w := doRequest(r, http.MethodPost, "/orders", body)
+    if w.Code != http.StatusUnprocessableEntity {
+        t.Fatalf("got status %d, want 422", w.Code)
+    }
+    if verr := decodeValidationError(w); len(verr.Fields) != 1 || verr.Fields[0].Field != "billing_address.country" {
+        t.Fatalf("got fields %+v", verr.Fields)
+    }
+    if n := fake.charges.Load(); n != 0 {
+        t.Fatalf("got %d charges, want none", n)
+    }
+}
+
+func TestSQLiteStoresAddresses(t *testing.T) {
+    repo := openTestSQLite(t, filepath.Join(t.TempDir(), "orders.db"))
+    withAddresses := newOutboxOrder()
+    withAddresses.ShippingAddress = testAddress()
+    withAddresses.BillingAddress = &Address{Name: "Accounts", Line1: "1 Infinite Loop", City: "Cupertino", Region: "CA", Country: "US"}
+    without := newOutboxOrder()
+    for _, o := range []*Order{withAddresses, without} {
+        if err := repo.Save(o); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    got, err := repo.FindByID(withAddresses.OrderID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(got.ShippingAddress, withAddresses.ShippingAddress) || !reflect.DeepEqual(got.BillingAddress, withAddresses.BillingAddress) {
+        t.Fatalf("got shipping %+v, billing %+v", got.ShippingAddress, got.BillingAddress)
+    }
+    if got, _ := repo.FindByID(without.OrderID); got.ShippingAddress != nil || got.BillingAddress != nil {
+        t.Fatalf("got addresses on an order without any: %+v %+v", got.ShippingAddress, got.BillingAddress)
+    }
+}
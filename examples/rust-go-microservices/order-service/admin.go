@@ -0,0 +1,64 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+)
+
+// ForceStatusRequest is the body of POST /admin/orders/:id/status.
+type ForceStatusRequest struct {
+    Status string `json:"status" binding:"required"`
+    // Reason is recorded in the status history with the admin as actor.
+    Reason string `json:"reason" binding:"required"`
+}
+
+// forceOrderStatus lets support staff move a stuck order to another status,
+// within what canTransition allows an admin. Beyond releasing the stock of
+// an order that won't be fulfilled, nothing else happens: no payment is
+// taken or refunded, so the reason should say what was done outside the
+// service.
+func forceOrderStatus(c *gin.Context) {
+    var req ForceStatusRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        respondBindError(c, err)
+        return
+    }
+    req.Status = strings.TrimSpace(req.Status)
+    req.Reason = strings.TrimSpace(req.Reason)
+    if req.Reason == "" {
+        verr := &ValidationError{}
+        verr.add("reason", "must not be empty")
+        respondValidationError(c, verr)
+        return
+    }
+
+    // If-Match only guards against other requests; the lock keeps a charge
+    // or background job from changing the order underneath this one.
+    unlock := lockOrderParam(c)
+    defer unlock()
+    order := loadOrder(c)
+    if order == nil {
+        return
+    }
+    if !checkIfMatch(c, order) {
+        return
+    }
+    if !canTransition(order.Status, req.Status, true) {
+        respondError(c, http.StatusConflict, CodeInvalidStatusTransition,
+            fmt.Sprintf("Order cannot be moved from status %q to %q", order.Status, req.Status))
+        return
+    }
+
+    loggerFrom(c.Request.Context()).Warn("admin forced order status",
+        "order_id", order.OrderID, "from", order.Status, "to", req.Status, "reason", req.Reason)
+    transitionStatusBy(order, req.Status, req.Reason, ActorAdmin)
+    releaseStock(c.Request.Context(), order)
+    if err := orders.Save(order); err != nil {
+        respondSaveError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, withLinks(order))
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+)
+
+func adminHeaders(key string, order *Order) map[string]string {
+    headers := ifMatch(order)
+    headers["Authorization"] = "Bearer " + key
+    return headers
+}
+
+func TestCanTransitionAdminOverride(t *testing.T) {
+    tests := []struct {
+        from, to string
+        want     bool
+    }{
+        {StatusPaymentFailed, StatusConfirmed, true},
+        {StatusExpired, StatusCancelled, true},
+        {StatusShipped, StatusConfirmed, true},
+        // Normal transitions are still allowed.
+        {StatusPending, StatusConfirmed, true},
+        {StatusConfirmed, StatusPending, false},
+        {StatusRefunded, StatusConfirmed, false},
+        {StatusCancelled, StatusConfirmed, false},
+        {StatusConfirmed, StatusConfirmed, false},
+        {StatusConfirmed, "lost", false},
+    }
+    for _, tt := range tests {
+        if got := canTransition(tt.from, tt.to, true); got != tt.want {
+            t.Errorf("canTransition(%q, %q, admin) = %v, want %v", tt.from, tt.to, got, tt.want)
+        }
+    }
+    if canTransition(StatusPaymentFailed, StatusConfirmed, false) {
+        t.Error("override allowed without admin")
+    }
+}
+
+func TestAdminForcesOrderStatus(t *testing.T) {
+    resetOrders(t)
+    useAPIKeys(t, "root:@admin,plain")
+    order := saveOrderWithStatus(StatusPaymentFailed)
+    r := setupRouter()
+    path := "/admin/orders/" + order.OrderID.String() + "/status"
+    body := `{"status":"confirmed","reason":"payment captured manually, ticket 4411"}`
+
+    w := doRequestWithHeaders(r, http.MethodPost, path, body, adminHeaders("root", order))
+    if w.Code != http.StatusOK {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    var got Order
+    json.Unmarshal(w.Body.Bytes(), &got)
+    last := got.StatusHistory[len(got.StatusHistory)-1]
+    if got.Status != StatusConfirmed || last.From != StatusPaymentFailed || last.Actor != ActorAdmin || last.Reason != "payment captured manually, ticket 4411" {
+        t.Fatalf("got status %q, last change %+v", got.Status, last)
+    }
+    if stored, _ := orders.FindByID(order.OrderID); stored.Status != StatusConfirmed {
+        t.Fatalf("stored status %q", stored.Status)
+    }
+}
+
+func TestAdminForceStatusWaitsForOrderLock(t *testing.T) {
+    resetOrders(t)
+    useAPIKeys(t, "root:@admin")
+    order := saveOrderWithStatus(StatusPending)
+    r := setupRouter()
+    path := "/admin/orders/" + order.OrderID.String() + "/status"
+    body := `{"status":"cancelled","reason":"customer called to cancel"}`
+
+    assertWaitsForOrderLock(t, order, func() {
+        doRequestWithHeaders(r, http.MethodPost, path, body, adminHeaders("root", order))
+    })
+    if stored, _ := orders.FindByID(order.OrderID); stored.Status != StatusCancelled {
+        t.Fatalf("stored status %q, want cancelled", stored.Status)
+    }
+}
+
+func TestAdminForceStatusRejected(t *testing.T) {
+    resetOrders(t)
+    useAPIKeys(t, "root:@admin,plain,scoped:cust_123")
+    order := saveOrderWithStatus(StatusRefunded)
+    r := setupRouter()
+    path := "/admin/orders/" + order.OrderID.String() + "/status"
+
+    tests := []struct {
+        name, key, body string
+        want            int
+    }{
+        {"nonsense transition", "root", `{"status":"confirmed","reason":"undo refund"}`, http.StatusConflict},
+        {"unknown status", "root", `{"status":"teleported","reason":"x"}`, http.StatusConflict},
+        {"blank reason", "root", `{"status":"confirmed","reason":"  "}`, http.StatusUnprocessableEntity},
+        {"missing reason", "root", `{"status":"confirmed"}`, http.StatusUnprocessableEntity},
+        {"unrestricted key", "plain", `{"status":"confirmed","reason":"x"}`, http.StatusForbidden},
+        {"customer key", "scoped", `{"status":"confirmed","reason":"x"}`, http.StatusForbidden},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            w := doRequestWithHeaders(r, http.MethodPost, path, tt.body, adminHeaders(tt.key, order))
+            if w.Code != tt.want {
+                t.Fatalf("got status %d, want %d: %s", w.Code, tt.want, w.Body)
+            }
+        })
+    }
+    if stored, _ := orders.FindByID(order.OrderID); stored.Status != StatusRefunded || stored.Version != order.Version {
+        t.Fatalf("rejected requests changed the order: %+v", stored)
+    }
+}
+
+func TestAdminCannotConfirmCancelledOrder(t *testing.T) {
+    resetOrders(t)
+    useAPIKeys(t, "root:@admin")
+    order := saveOrderWithStatus(StatusCancelled)
+    r := setupRouter()
+    path := "/admin/orders/" + order.OrderID.String() + "/status"
+
+    w := doRequestWithHeaders(r, http.MethodPost, path, `{"status":"confirmed","reason":"customer changed their mind"}`, adminHeaders("root", order))
+    if w.Code != http.StatusConflict {
+        t.Fatalf("got status %d, want 409: %s", w.Code, w.Body)
+    }
+    if stored, _ := orders.FindByID(order.OrderID); stored.Status != StatusCancelled {
+        t.Fatalf("stored status %q", stored.Status)
+    }
+}
+
+func TestAdminKeyIsUnrestricted(t *testing.T) {
+    resetOrders(t)
+    useAPIKeys(t, "root:@admin")
+    order := saveOrderWithStatus(StatusConfirmed)
+    r := setupRouter()
+
+    if w := doRequestWithHeaders(r, http.MethodGet, "/orders/"+order.OrderID.String(), "", bearer("root")); w.Code != http.StatusOK {
+        t.Fatalf("got status %d", w.Code)
+    }
+}
+
+func TestAdminRoutesClosedWithoutAuth(t *testing.T) {
+    resetOrders(t)
+    prev := apiKeys
+    apiKeys = nil
+    t.Cleanup(func() { apiKeys = prev })
+    order := saveOrderWithStatus(StatusPaymentFailed)
+    r := setupRouter()
+
+    paths := []struct{ path, body string }{
+        {"/admin/orders/" + order.OrderID.String() + "/status", `{"status":"confirmed","reason":"x"}`},
+        {"/admin/events/replay", ""},
+        {"/admin/drain", ""},
+    }
+    for _, tt := range paths {
+        w := doRequestWithHeaders(r, http.MethodPost, tt.path, tt.body, ifMatch(order))
+        if w.Code != http.StatusForbidden {
+            t.Errorf("POST %s with authentication off got status %d, want 403", tt.path, w.Code)
+        }
+    }
+    if stored, _ := orders.FindByID(order.OrderID); stored.Status != StatusPaymentFailed {
+        t.Fatalf("stored status %q", stored.Status)
+    }
+    if drainer.Draining() {
+        t.Fatal("instance started draining")
+    }
+}
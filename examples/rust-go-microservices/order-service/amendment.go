@@ -0,0 +1,99 @@
+package main
+
+import (
+    "reflect"
+    "time"
+
+    "github.com/shopspring/decimal"
+)
+
+// Amendment records what one PATCH of an order changed, so a dispute can
+// be settled from what the order looked like before it.
+type Amendment struct {
+    At      time.Time    `json:"at"`
+    Added   []OrderItem  `json:"added,omitempty"`
+    Removed []OrderItem  `json:"removed,omitempty"`
+    Changed []ItemChange `json:"changed,omitempty"`
+    // TotalBefore and TotalAfter are the order's total either side of the
+    // change; they are equal unless its items changed.
+    TotalBefore decimal.Decimal `json:"total_before"`
+    TotalAfter  decimal.Decimal `json:"total_after"`
+    // Fields names whatever else changed, such as metadata or notes. Their
+    // values aren't copied here, where they would escape encryption.
+    Fields []string `json:"fields,omitempty"`
+}
+
+// ItemChange is a line item whose quantity, price, currency or discount was
+// changed.
+type ItemChange struct {
+    ProductID string    `json:"product_id"`
+    Before    OrderItem `json:"before"`
+    After     OrderItem `json:"after"`
+}
+
+// empty reports whether a records no change at all.
+func (a Amendment) empty() bool {
+    return len(a.Added) == 0 && len(a.Removed) == 0 && len(a.Changed) == 0 && len(a.Fields) == 0 &&
+        a.TotalBefore.Equal(a.TotalAfter)
+}
+
+// diffOrders works out how after differs from before. Items are matched by
+// product ID; when an order has the same product on several lines, they
+// are matched in order. The result's At is left for the caller to set.
+func diffOrders(before, after *Order) Amendment {
+    diff := Amendment{TotalBefore: before.TotalAmount, TotalAfter: after.TotalAmount}
+
+    // remaining holds, for each product, before's lines not yet matched.
+    remaining := make(map[string][]OrderItem)
+    for _, item := range before.Items {
+        remaining[item.ProductID] = append(remaining[item.ProductID], item)
+    }
+    for _, item := range after.Items {
+        lines := remaining[item.ProductID]
+        if len(lines) == 0 {
+            diff.Added = append(diff.Added, item)
+            continue
+        }
+        old := lines[0]
+        remaining[item.ProductID] = lines[1:]
+        if !sameItem(old, item) {
+            diff.Changed = append(diff.Changed, ItemChange{ProductID: item.ProductID, Before: old, After: item})
+        }
+    }
+    // Walk before again so removals come out in its order.
+    for _, item := range before.Items {
+        if lines := remaining[item.ProductID]; len(lines) > 0 {
+            diff.Removed = append(diff.Removed, lines[0])
+            remaining[item.ProductID] = lines[1:]
+        }
+    }
+
+    if !reflect.DeepEqual(before.Metadata, after.Metadata) {
+        diff.Fields = append(diff.Fields, "metadata")
+    }
+    if before.Notes != after.Notes {
+        diff.Fields = append(diff.Fields, "notes")
+    }
+    return diff
+}
+
+// sameItem reports whether a and b would be charged the same.
+func sameItem(a, b OrderItem) bool {
+    if a.Quantity != b.Quantity || !a.Price.Equal(b.Price) || a.Currency != b.Currency {
+        return false
+    }
+    if a.Discount == nil || b.Discount == nil {
+        return a.Discount == b.Discount
+    }
+    return a.Discount.Type == b.Discount.Type && a.Discount.Value.Equal(b.Discount.Value)
+}
+
+// recordAmendment notes on order what changed since before, if anything.
+func recordAmendment(before, order *Order) {
+    diff := diffOrders(before, order)
+    if diff.empty() {
+        return
+    }
+    diff.At = clock.Now()
+    order.Amendments = append(order.Amendments[:len(order.Amendments):len(order.Amendments)], diff)
+}
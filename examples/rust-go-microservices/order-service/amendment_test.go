@@ -0,0 +1,112 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "reflect"
+    "testing"
+
+    "github.com/shopspring/decimal"
+)
+
+func lineItem(productID string, quantity int, price string) OrderItem {
+    return OrderItem{ProductID: productID, Quantity: quantity, Price: decimal.RequireFromString(price)}
+}
+
+func productIDs(items []OrderItem) []string {
+    ids := make([]string, 0, len(items))
+    for _, item := range items {
+        ids = append(ids, item.ProductID)
+    }
+    return ids
+}
+
+func TestDiffOrders(t *testing.T) {
+    before := &Order{
+        Items:       []OrderItem{lineItem("keep", 1, "5.00"), lineItem("more", 1, "2.00"), lineItem("drop", 3, "1.00")},
+        TotalAmount: decimal.RequireFromString("10.00"),
+    }
+    after := &Order{
+        Items:       []OrderItem{lineItem("keep", 1, "5.00"), lineItem("more", 4, "2.00"), lineItem("new", 1, "7.50")},
+        TotalAmount: decimal.RequireFromString("20.50"),
+    }
+
+    diff := diffOrders(before, after)
+    if got := productIDs(diff.Added); !reflect.DeepEqual(got, []string{"new"}) {
+        t.Errorf("added %v, want [new]", got)
+    }
+    if got := productIDs(diff.Removed); !reflect.DeepEqual(got, []string{"drop"}) || diff.Removed[0].Quantity != 3 {
+        t.Errorf("removed %+v, want the 3 drop", diff.Removed)
+    }
+    if len(diff.Changed) != 1 || diff.Changed[0].ProductID != "more" ||
+        diff.Changed[0].Before.Quantity != 1 || diff.Changed[0].After.Quantity != 4 {
+        t.Errorf("changed %+v, want more going from 1 to 4", diff.Changed)
+    }
+    if diff.TotalBefore.String() != "10" || diff.TotalAfter.String() != "20.5" {
+        t.Errorf("totals %s -> %s, want 10 -> 20.5", diff.TotalBefore, diff.TotalAfter)
+    }
+    if len(diff.Fields) != 0 {
+        t.Errorf("fields %v, want none", diff.Fields)
+    }
+}
+
+func TestDiffOrdersMatchesRepeatedProductsInOrder(t *testing.T) {
+    before := &Order{Items: []OrderItem{lineItem("p", 1, "1.00"), lineItem("p", 2, "1.00")}}
+    after := &Order{Items: []OrderItem{lineItem("p", 1, "1.00")}}
+
+    diff := diffOrders(before, after)
+    if len(diff.Changed) != 0 || len(diff.Removed) != 1 || diff.Removed[0].Quantity != 2 {
+        t.Fatalf("got %+v, want only the second line removed", diff)
+    }
+}
+
+func TestDiffOrdersNamesOtherFields(t *testing.T) {
+    before := &Order{Notes: "old", Metadata: map[string]string{"a": "1"}}
+    after := &Order{Notes: "new", Metadata: map[string]string{"a": "2"}}
+
+    if got := diffOrders(before, after).Fields; !reflect.DeepEqual(got, []string{"metadata", "notes"}) {
+        t.Fatalf("got fields %v, want metadata and notes", got)
+    }
+    if diff := diffOrders(before, before); !diff.empty() {
+        t.Fatalf("an order compared with itself has changes: %+v", diff)
+    }
+}
+
+func TestUpdateRecordsAmendmentOnTimeline(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+    order := saveOrderWithStatus(StatusPending)
+
+    body := `{"items":[{"product_id":"prod_456","quantity":3,"price":"29.99"}],"notes":"gift"}`
+    w := doRequestWithHeaders(r, http.MethodPatch, "/orders/"+order.OrderID.String(), body, ifMatch(order))
+    if w.Code != http.StatusOK {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+
+    var timeline OrderTimeline
+    json.Unmarshal(doRequest(r, http.MethodGet, "/orders/"+order.OrderID.String()+"/events", "").Body.Bytes(), &timeline)
+    var amendments []*Amendment
+    for _, event := range timeline.Events {
+        if event.Type == TimelineAmended {
+            amendments = append(amendments, event.Amendment)
+        }
+    }
+    if len(amendments) != 1 {
+        t.Fatalf("got events %+v, want one amendment", timeline.Events)
+    }
+    got := amendments[0]
+    if got.TotalBefore.String() != "59.98" || got.TotalAfter.String() != "89.97" {
+        t.Fatalf("totals %s -> %s, want 59.98 -> 89.97", got.TotalBefore, got.TotalAfter)
+    }
+    if ids := productIDs(got.Added); !reflect.DeepEqual(ids, []string{"prod_456"}) || !reflect.DeepEqual(got.Fields, []string{"notes"}) {
+        t.Fatalf("got %+v, want prod_456 added and notes changed", got)
+    }
+
+    // A PATCH that changes nothing leaves no amendment.
+    stored, _ := orders.FindByID(order.OrderID)
+    doRequestWithHeaders(r, http.MethodPatch, "/orders/"+order.OrderID.String(), `{"notes":"gift"}`, ifMatch(stored))
+    if stored, _ := orders.FindByID(order.OrderID); len(stored.Amendments) != 1 {
+        t.Fatalf("got %d amendments, want 1", len(stored.Amendments))
+    }
+}
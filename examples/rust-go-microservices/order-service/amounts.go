@@ -0,0 +1,190 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+    "github.com/gin-gonic/gin/binding"
+    "github.com/google/uuid"
+    "github.com/shopspring/decimal"
+)
+
+// Codes of the warnings about amounts sent as JSON numbers.
+const (
+    WarningFloatAmount     = "FLOAT_AMOUNT"
+    WarningImpreciseAmount = "IMPRECISE_FLOAT_AMOUNT"
+)
+
+// legacyAmountsHeader lets a client that still sends amounts as JSON
+// numbers have them accepted while rejectFloatAmounts is on.
+const legacyAmountsHeader = "X-Legacy-Amounts"
+
+// rejectFloatAmounts turns away orders with amounts sent as JSON numbers
+// rather than decimal strings, unless the request sends legacyAmountsHeader.
+// It is off by default, so older clients keep working: their amounts are
+// read exactly as written, and the order comes back with a warning.
+var rejectFloatAmounts bool
+
+// amountKeys are the keys of an order or refund body whose values are
+// money.
+var amountKeys = map[string]bool{
+    "amount":         true,
+    "price":          true,
+    "value":          true,
+    "expected_total": true,
+    "total_amount":   true,
+    "subtotal":       true,
+    "tax":            true,
+    "shipping":       true,
+}
+
+// numericAmount is an amount a request body gives as a JSON number.
+type numericAmount struct {
+    Field string
+    Text  string
+}
+
+// numericAmounts returns the amounts in a JSON order body that are numbers,
+// sorted by field. A body that isn't JSON has none; binding it
+// reports the error.
+func numericAmounts(body []byte) []numericAmount {
+    dec := json.NewDecoder(bytes.NewReader(body))
+    dec.UseNumber()
+    var v interface{}
+    if dec.Decode(&v) != nil {
+        return nil
+    }
+    var amounts []numericAmount
+    var walk func(path string, v interface{})
+    walk = func(path string, v interface{}) {
+        switch v := v.(type) {
+        case map[string]interface{}:
+            for _, key := range sortedKeys(v) {
+                field := key
+                if path != "" {
+                    field = path + "." + key
+                }
+                if n, ok := v[key].(json.Number); ok && amountKeys[key] {
+                    amounts = append(amounts, numericAmount{Field: field, Text: string(n)})
+                    continue
+                }
+                walk(field, v[key])
+            }
+        case []interface{}:
+            for i, e := range v {
+                walk(fmt.Sprintf("%s[%d]", path, i), e)
+            }
+        }
+    }
+    walk("", v)
+    return amounts
+}
+
+// sortedKeys returns m's keys in order, so warnings come out the same way
+// every time.
+func sortedKeys(m map[string]interface{}) []string {
+    keys := make([]string, 0, len(m))
+    for k := range m {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// floatAmountWarnings warns about each amount sent as a number. One with
+// more digits than a float64 holds is called out separately: the client
+// most likely computed it in floating point, so it may not be what was
+// meant, though it is kept exactly as sent.
+func floatAmountWarnings(amounts []numericAmount) []Warning {
+    warnings := make([]Warning, 0, len(amounts))
+    for _, a := range amounts {
+        exact, err := decimal.NewFromString(a.Text)
+        if err != nil {
+            continue
+        }
+        if f, err := strconv.ParseFloat(a.Text, 64); err != nil || !decimal.NewFromFloat(f).Equal(exact) {
+            warnings = append(warnings, Warning{
+                Code:    WarningImpreciseAmount,
+                Field:   a.Field,
+                Message: fmt.Sprintf("%s has more digits than a float holds; it was kept as sent, but send amounts as decimal strings", a.Text),
+            })
+            continue
+        }
+        warnings = append(warnings, Warning{
+            Code:    WarningFloatAmount,
+            Field:   a.Field,
+            Message: "amount was sent as a number; send amounts as decimal strings, e.g. \"29.99\"",
+        })
+    }
+    return warnings
+}
+
+// checkAmounts looks at how the amounts in body were sent. It rejects
+// numbers if rejectFloatAmounts is on and c doesn't send
+// legacyAmountsHeader, and otherwise returns a warning for each.
+func checkAmounts(c *gin.Context, body []byte) ([]Warning, *requestError) {
+    amounts := numericAmounts(body)
+    if len(amounts) == 0 {
+        return nil, nil
+    }
+    if rejectFloatAmounts {
+        legacy, _ := strconv.ParseBool(c.GetHeader(legacyAmountsHeader))
+        if !legacy {
+            verr := &ValidationError{}
+            for _, a := range amounts {
+                verr.add(a.Field, "must be a decimal string, e.g. \"29.99\"")
+            }
+            return nil, validationFailed(verr)
+        }
+    }
+    return floatAmountWarnings(amounts), nil
+}
+
+// bindOrder binds the request body to order as ShouldBindJSON would,
+// writing the error response if it can't. It returns a context for
+// preparing the order that carries the warnings about its amounts. An
+// order whose client picked its ID keeps the body's fingerprint too.
+func bindOrder(c *gin.Context, order *Order) (context.Context, bool) {
+    body, err := c.GetRawData()
+    if err != nil {
+        respondBindError(c, err)
+        return nil, false
+    }
+    warnings, rerr := checkAmounts(c, body)
+    if rerr == nil {
+        rerr = checkClientOrderID(body)
+    }
+    if rerr != nil {
+        rerr.respond(c)
+        return nil, false
+    }
+    if err := binding.JSON.BindBody(body, order); err != nil {
+        respondBindError(c, err)
+        return nil, false
+    }
+    if order.OrderID != uuid.Nil {
+        order.RequestHash = requestFingerprint(body)
+    }
+    return withAmountWarnings(c.Request.Context(), warnings), true
+}
+
+type amountWarningsKey struct{}
+
+// withAmountWarnings returns a context that has prepareOrder add warnings
+// to the order.
+func withAmountWarnings(ctx context.Context, warnings []Warning) context.Context {
+    if len(warnings) == 0 {
+        return ctx
+    }
+    return context.WithValue(ctx, amountWarningsKey{}, warnings)
+}
+
+func amountWarnings(ctx context.Context) []Warning {
+    warnings, _ := ctx.Value(amountWarningsKey{}).([]Warning)
+    return warnings
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func useRejectFloatAmounts(t *testing.T) {
+    t.Helper()
+
+    prev := rejectFloatAmounts
+    rejectFloatAmounts = true
+    t.Cleanup(func() { rejectFloatAmounts = prev })
+}
+
+// createWithoutRules posts body with the warning rules off, so the only
+// warnings are about how its amounts were sent.
+func createWithoutRules(t *testing.T, body string, headers map[string]string) (int, Order, map[string]interface{}) {
+    t.Helper()
+
+    newPaymentServer(t)
+    resetOrders(t)
+    prev := warningRules
+    warningRules = nil
+    t.Cleanup(func() { warningRules = prev })
+
+    w := doRequestWithHeaders(setupRouter(), http.MethodPost, "/orders", body, headers)
+    var order Order
+    var doc map[string]interface{}
+    json.Unmarshal(w.Body.Bytes(), &order)
+    json.Unmarshal(w.Body.Bytes(), &doc)
+    return w.Code, order, doc
+}
+
+func TestStringAmountsHaveNoWarnings(t *testing.T) {
+    code, order, _ := createWithoutRules(t, sampleOrder, nil)
+    if code != http.StatusCreated || len(order.Warnings) != 0 {
+        t.Fatalf("got status %d with warnings %+v, want 201 without", code, order.Warnings)
+    }
+}
+
+func TestNumericAmountsAcceptedWithWarning(t *testing.T) {
+    body := `{"customer_id":"cust_123","items":[{"product_id":"prod_456","quantity":2,"price":29.99}],"expected_total":59.98}`
+    code, order, doc := createWithoutRules(t, body, nil)
+    if code != http.StatusCreated {
+        t.Fatalf("got status %d, want 201", code)
+    }
+    if order.TotalAmount.String() != "59.98" || doc["total_amount"] != "59.98" {
+        t.Fatalf("got total_amount %#v, want the decimal string \"59.98\"", doc["total_amount"])
+    }
+    if len(order.Warnings) != 2 {
+        t.Fatalf("got warnings %+v, want one per numeric amount", order.Warnings)
+    }
+    for i, field := range []string{"expected_total", "items[0].price"} {
+        if w := order.Warnings[i]; w.Code != WarningFloatAmount || w.Field != field {
+            t.Fatalf("warning %d = %+v, want %s about %s", i, w, WarningFloatAmount, field)
+        }
+    }
+}
+
+func TestImpreciseFloatAmountFlagged(t *testing.T) {
+    // 0.3 printed with %.17g: past what a float64 holds, so the client
+    // most likely meant 0.3.
+    body := `{"customer_id":"cust_123","items":[{"product_id":"prod_456","quantity":1,"price":0.30000000000000001}]}`
+    code, order, _ := createWithoutRules(t, body, nil)
+    if code != http.StatusCreated {
+        t.Fatalf("got status %d, want 201", code)
+    }
+    if len(order.Warnings) != 1 || order.Warnings[0].Code != WarningImpreciseAmount || order.Warnings[0].Field != "items[0].price" {
+        t.Fatalf("got warnings %+v, want %s about items[0].price", order.Warnings, WarningImpreciseAmount)
+    }
+    if got := order.Items[0].Price.String(); got != "0.30000000000000001" {
+        t.Fatalf("stored price %s, want it exactly as sent", got)
+    }
+}
+
+func TestRejectFloatAmounts(t *testing.T) {
+    useRejectFloatAmounts(t)
+    body := `{"customer_id":"cust_123","items":[{"product_id":"prod_456","quantity":2,"price":29.99}]}`
+
+    code, _, doc := createWithoutRules(t, body, nil)
+    if code != http.StatusUnprocessableEntity || !strings.Contains(jsonString(doc), "items[0].price") {
+        t.Fatalf("got status %d: %v; want 422 about items[0].price", code, doc)
+    }
+
+    code, order, _ := createWithoutRules(t, body, map[string]string{legacyAmountsHeader: "true"})
+    if code != http.StatusCreated || len(order.Warnings) != 1 || order.Warnings[0].Code != WarningFloatAmount {
+        t.Fatalf("with %s: got status %d with warnings %+v, want 201 with a warning", legacyAmountsHeader, code, order.Warnings)
+    }
+
+    if code, _, _ := createWithoutRules(t, sampleOrder, nil); code != http.StatusCreated {
+        t.Fatalf("string amounts: got status %d, want 201", code)
+    }
+}
+
+func TestNumericSplitAmountAcceptedWithWarning(t *testing.T) {
+    body := `{"customer_id":"cust_123","items":[{"product_id":"prod_456","quantity":2,"price":"29.99"}],
+        "payment_splits":[{"amount":20},{"amount":"39.98"}]}`
+    code, order, _ := createWithoutRules(t, body, nil)
+    if code != http.StatusCreated || order.Status != StatusConfirmed {
+        t.Fatalf("got status %d, order %q; want a confirmed order", code, order.Status)
+    }
+    if len(order.Warnings) != 1 || order.Warnings[0].Code != WarningFloatAmount || order.Warnings[0].Field != "payment_splits[0].amount" {
+        t.Fatalf("got warnings %+v, want %s about payment_splits[0].amount", order.Warnings, WarningFloatAmount)
+    }
+    if got := order.PaymentSplits[0].Amount.String(); got != "20" {
+        t.Fatalf("split amount %s, want 20", got)
+    }
+}
+
+func TestNumericRefundAmount(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    order := saveOrderWithStatus(StatusConfirmed)
+    r := setupRouter()
+
+    code, got := refund(t, r, order, `{"amount":10}`)
+    if code != http.StatusOK || got.RefundedAmount.String() != "10" {
+        t.Fatalf("got status %d, refunded %s; want 10 refunded", code, got.RefundedAmount)
+    }
+    if len(got.Warnings) != 1 || got.Warnings[0].Field != "amount" {
+        t.Fatalf("got warnings %+v, want one about amount", got.Warnings)
+    }
+    if stored, _ := orders.FindByID(order.OrderID); len(stored.Warnings) != 0 {
+        t.Fatalf("refund warnings stored with the order: %+v", stored.Warnings)
+    }
+
+    useRejectFloatAmounts(t)
+    if code, _ := refund(t, r, order, `{"amount":10}`); code != http.StatusUnprocessableEntity {
+        t.Fatalf("with float amounts rejected: got status %d, want 422", code)
+    }
+}
+
+func TestBatchChecksAmountsPerOrder(t *testing.T) {
+    useRejectFloatAmounts(t)
+    newPaymentServer(t)
+    resetOrders(t)
+
+    body := `[` + sampleOrder + `,{"customer_id":"cust_123","items":[{"product_id":"prod_456","quantity":1,"price":1}]}]`
+    w := doRequest(setupRouter(), http.MethodPost, "/orders/batch", body)
+    var resp BatchResponse
+    json.Unmarshal(w.Body.Bytes(), &resp)
+    if resp.Succeeded != 1 || resp.Results[1].Status != http.StatusUnprocessableEntity {
+        t.Fatalf("got %s, want the second order rejected", w.Body)
+    }
+}
+
+func jsonString(v interface{}) string {
+    data, _ := json.Marshal(v)
+    return string(data)
+}
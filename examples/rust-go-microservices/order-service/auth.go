@@ -0,0 +1,144 @@
+package main
+
+import (
+    "crypto/sha256"
+    "fmt"
+    "net/http"
+    "os"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+)
+
+// customerScopeKey is the gin context key holding the customer a request's
+// API key is restricted to; adminKey is set for requests made with an admin
+// key.
+const (
+    customerScopeKey = "customer_scope"
+    adminKey         = "admin"
+)
+
+// adminScope is the scope that marks an admin key: unrestricted, and
+// allowed to use the /admin endpoints. The "@" keeps it apart from
+// customer IDs.
+const adminScope = "@admin"
+
+// APIKeys maps API keys, by SHA-256 digest, to the customer each is scoped
+// to ("" for unrestricted keys, adminScope for admin keys). Looking keys
+// up by digest means request timing reveals nothing about how close a
+// guess was.
+type APIKeys struct {
+    scopes map[[sha256.Size]byte]string
+}
+
+// ParseAPIKeys parses a comma-separated list of keys, each optionally
+// followed by ":customer_id" to restrict it to that customer's orders, or
+// by ":@admin" to make it an admin key.
+func ParseAPIKeys(spec string) (*APIKeys, error) {
+    keys := &APIKeys{scopes: make(map[[sha256.Size]byte]string)}
+    for _, entry := range strings.Split(spec, ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+        key, scope, _ := strings.Cut(entry, ":")
+        if key == "" {
+            return nil, fmt.Errorf("API key entry %q has no key", entry)
+        }
+        keys.scopes[sha256.Sum256([]byte(key))] = scope
+    }
+    if len(keys.scopes) == 0 {
+        return nil, fmt.Errorf("no API keys configured")
+    }
+    return keys, nil
+}
+
+// lookup returns key's customer scope, and whether key is valid at all.
+func (k *APIKeys) lookup(key string) (scope string, ok bool) {
+    scope, ok = k.scopes[sha256.Sum256([]byte(key))]
+    return scope, ok
+}
+
+// apiKeys authenticates the order API; nil disables authentication.
+var apiKeys *APIKeys
+
+// newAPIKeysFromEnv returns the keys in API_KEYS when AUTH_ENABLED is
+// "true", and nil otherwise.
+func newAPIKeysFromEnv() (*APIKeys, error) {
+    if os.Getenv("AUTH_ENABLED") != "true" {
+        return nil, nil
+    }
+    keys, err := ParseAPIKeys(os.Getenv("API_KEYS"))
+    if err != nil {
+        return nil, fmt.Errorf("API_KEYS: %w", err)
+    }
+    return keys, nil
+}
+
+// authenticate requires an "Authorization: Bearer <key>" header carrying one
+// of keys, recording the key's customer scope for the handlers. A nil keys
+// lets every request through.
+func authenticate(keys *APIKeys) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if keys == nil {
+            c.Next()
+            return
+        }
+        key, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+        scope, valid := keys.lookup(key)
+        if !ok || key == "" || !valid {
+            c.Header("WWW-Authenticate", `Bearer realm="order-service"`)
+            respondError(c, http.StatusUnauthorized, CodeUnauthorized, "Missing or invalid API key")
+            return
+        }
+        switch scope {
+        case adminScope:
+            c.Set(adminKey, true)
+        case "":
+        default:
+            c.Set(customerScopeKey, scope)
+        }
+        c.Next()
+    }
+}
+
+// requireAdmin rejects requests not made with an admin key. Unlike the rest
+// of the API, it fails closed: with keys nil and authentication off there is
+// no admin key to present, so every request is rejected.
+func requireAdmin(keys *APIKeys) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if keys == nil {
+            respondError(c, http.StatusForbidden, CodeForbidden, "Admin endpoints require authentication to be enabled")
+            return
+        }
+        if !c.GetBool(adminKey) {
+            respondError(c, http.StatusForbidden, CodeForbidden, "API key is not an admin key")
+            return
+        }
+        c.Next()
+    }
+}
+
+// customerScope returns the customer the request is restricted to, if any.
+func customerScope(c *gin.Context) (string, bool) {
+    scope := c.GetString(customerScopeKey)
+    return scope, scope != ""
+}
+
+// requireUnscoped rejects requests whose API key is restricted to a
+// customer, for endpoints that reach beyond any one customer's orders.
+func requireUnscoped() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if _, scoped := customerScope(c); scoped {
+            respondError(c, http.StatusForbidden, CodeForbidden, "API key is restricted to a customer")
+            return
+        }
+        c.Next()
+    }
+}
+
+// canAccess reports whether the request may see customerID's orders.
+func canAccess(c *gin.Context, customerID string) bool {
+    scope, scoped := customerScope(c)
+    return !scoped || scope == customerID
+}
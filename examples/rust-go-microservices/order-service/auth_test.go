@@ -0,0 +1,125 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+    "time"
+)
+
+// useAPIKeys enables authentication with the given key spec for the
+// duration of the test.
+func useAPIKeys(t *testing.T, spec string) {
+    t.Helper()
+
+    keys, err := ParseAPIKeys(spec)
+    if err != nil {
+        t.Fatal(err)
+    }
+    prev := apiKeys
+    apiKeys = keys
+    t.Cleanup(func() { apiKeys = prev })
+}
+
+func bearer(key string) map[string]string {
+    return map[string]string{"Authorization": "Bearer " + key}
+}
+
+func TestAuthRejectsMissingAndInvalidKeys(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    useAPIKeys(t, "admin-key")
+    r := setupRouter()
+
+    for name, headers := range map[string]map[string]string{
+        "missing":    nil,
+        "invalid":    bearer("wrong-key"),
+        "empty":      bearer(""),
+        "not bearer": {"Authorization": "Basic admin-key"},
+        "bare key":   {"Authorization": "admin-key"},
+    } {
+        w := doRequestWithHeaders(r, http.MethodGet, "/orders", "", headers)
+        if w.Code != http.StatusUnauthorized || decodeError(t, w).Code != CodeUnauthorized {
+            t.Fatalf("%s: got %d %s, want 401", name, w.Code, w.Body)
+        }
+        if w.Header().Get("WWW-Authenticate") == "" {
+            t.Fatalf("%s: 401 without WWW-Authenticate", name)
+        }
+    }
+
+    if w := doRequest(r, http.MethodGet, "/health/live", ""); w.Code != http.StatusOK {
+        t.Fatalf("health check requires auth: got %d", w.Code)
+    }
+}
+
+func TestAuthAcceptsValidKey(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    useAPIKeys(t, "admin-key, other-key:cust_999")
+    r := setupRouter()
+
+    w := doRequestWithHeaders(r, http.MethodPost, "/orders", sampleOrder, bearer("admin-key"))
+    if w.Code != http.StatusCreated {
+        t.Fatalf("create: got status %d: %s", w.Code, w.Body)
+    }
+    if w := doRequestWithHeaders(r, http.MethodGet, w.Header().Get("Location"), "", bearer("admin-key")); w.Code != http.StatusOK {
+        t.Fatalf("get: got status %d", w.Code)
+    }
+}
+
+func TestAuthEnforcesCustomerScope(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    useAPIKeys(t, "admin-key,scoped-key:cust_123")
+    r := setupRouter()
+    own := saveOrderWithStatus(StatusPending)
+    other := saveCustomerOrder("cust_999", StatusPending, time.Now())
+    scoped := bearer("scoped-key")
+
+    if w := doRequestWithHeaders(r, http.MethodGet, "/orders/"+own.OrderID.String(), "", scoped); w.Code != http.StatusOK {
+        t.Fatalf("own order: got status %d", w.Code)
+    }
+    if w := doRequestWithHeaders(r, http.MethodGet, "/orders/"+other.OrderID.String(), "", scoped); w.Code != http.StatusNotFound {
+        t.Fatalf("other customer's order: got status %d, want 404", w.Code)
+    }
+    if w := doRequestWithHeaders(r, http.MethodPost, "/orders/"+other.OrderID.String()+"/cancel", "", scoped); w.Code != http.StatusNotFound {
+        t.Fatalf("cancel other customer's order: got status %d, want 404", w.Code)
+    }
+
+    list := decodeList(t, doRequestWithHeaders(r, http.MethodGet, "/orders", "", scoped).Body.Bytes())
+    if list.Total != 1 || list.Orders[0].OrderID != own.OrderID {
+        t.Fatalf("scoped list returned %+v", list.Orders)
+    }
+    if w := doRequestWithHeaders(r, http.MethodGet, "/customers/cust_999/orders", "", scoped); w.Code != http.StatusForbidden {
+        t.Fatalf("other customer's list: got status %d, want 403", w.Code)
+    }
+
+    otherOrder := `{"customer_id":"cust_999","items":[{"product_id":"p","quantity":1,"price":"1"}]}`
+    if w := doRequestWithHeaders(r, http.MethodPost, "/orders", otherOrder, scoped); w.Code != http.StatusForbidden {
+        t.Fatalf("create for other customer: got status %d, want 403", w.Code)
+    }
+    if w := doRequestWithHeaders(r, http.MethodPost, "/orders", sampleOrder, scoped); w.Code != http.StatusCreated {
+        t.Fatalf("create for own customer: got status %d", w.Code)
+    }
+
+    if list := decodeList(t, doRequestWithHeaders(r, http.MethodGet, "/orders", "", bearer("admin-key")).Body.Bytes()); list.Total != 3 {
+        t.Fatalf("unscoped key sees %d orders, want 3", list.Total)
+    }
+}
+
+func TestParseAPIKeys(t *testing.T) {
+    keys, err := ParseAPIKeys("a, b:cust_1 ,")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if scope, ok := keys.lookup("a"); !ok || scope != "" {
+        t.Fatalf("a: got %q, %v", scope, ok)
+    }
+    if scope, ok := keys.lookup("b"); !ok || scope != "cust_1" {
+        t.Fatalf("b: got %q, %v", scope, ok)
+    }
+    for _, spec := range []string{"", " , ", ":cust_1"} {
+        if _, err := ParseAPIKeys(spec); err == nil {
+            t.Fatalf("ParseAPIKeys(%q) accepted a spec without keys", spec)
+        }
+    }
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "math"
+    "net/http"
+    "strconv"
+    "sync/atomic"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+const (
+    defaultOrderQueueDepth   = 100
+    defaultOrderQueueMaxWait = 2 * time.Second
+)
+
+var (
+    // ErrQueueFull is returned when a creation arrives with the queue
+    // already holding as many as it may.
+    ErrQueueFull = errors.New("order creation queue full")
+    // ErrQueueTimeout is returned when no slot frees up within the wait.
+    ErrQueueTimeout = errors.New("timed out waiting in the order creation queue")
+)
+
+// Results used as the order_queue_wait_seconds label.
+const (
+    queueAdmitted = "admitted"
+    queueTimedOut = "timed_out"
+    queueFull     = "full"
+)
+
+// CreationQueue lets only so many order creations run at once and queues a
+// bounded number more, so a burst is smoothed out rather than turned away
+// or let through all at once. Creations are admitted in the order they
+// arrived.
+type CreationQueue struct {
+    slots *Semaphore
+    // limit is how many may be inside at once, running or waiting.
+    limit   int64
+    maxWait time.Duration
+    inside  atomic.Int64
+}
+
+// NewCreationQueue runs up to concurrency creations at once, with up to
+// depth more waiting as long as maxWait for a turn.
+func NewCreationQueue(concurrency, depth int, maxWait time.Duration) *CreationQueue {
+    return &CreationQueue{
+        slots:   NewSemaphore(int64(concurrency)),
+        limit:   int64(concurrency + depth),
+        maxWait: maxWait,
+    }
+}
+
+// creationQueue is nil unless configured, in which case creations aren't
+// queued or limited.
+var creationQueue *CreationQueue
+
+// creationQueueFromEnv builds the queue from ORDER_QUEUE_CONCURRENCY,
+// ORDER_QUEUE_DEPTH and ORDER_QUEUE_MAX_WAIT, returning nil if the first
+// is unset or zero.
+func creationQueueFromEnv() (*CreationQueue, error) {
+    concurrency, err := envInt("ORDER_QUEUE_CONCURRENCY", 0)
+    if err != nil || concurrency == 0 {
+        return nil, err
+    }
+    depth, err := envInt("ORDER_QUEUE_DEPTH", defaultOrderQueueDepth)
+    if err != nil {
+        return nil, err
+    }
+    maxWait, err := envDuration("ORDER_QUEUE_MAX_WAIT", defaultOrderQueueMaxWait)
+    if err != nil {
+        return nil, err
+    }
+    return NewCreationQueue(concurrency, depth, maxWait), nil
+}
+
+// Enter waits for a turn to create an order, for at most the queue's wait
+// or until ctx is done, and returns the function to call once finished. A
+// nil CreationQueue lets everyone straight in.
+func (q *CreationQueue) Enter(ctx context.Context) (func(), error) {
+    if q == nil {
+        return func() {}, nil
+    }
+    if q.inside.Add(1) > q.limit {
+        q.inside.Add(-1)
+        orderQueueWait.WithLabelValues(queueFull).Observe(0)
+        return nil, ErrQueueFull
+    }
+
+    start := time.Now()
+    ctx, cancel := context.WithTimeout(ctx, q.maxWait)
+    defer cancel()
+    if err := q.slots.Acquire(ctx, 1); err != nil {
+        q.inside.Add(-1)
+        orderQueueWait.WithLabelValues(queueTimedOut).Observe(time.Since(start).Seconds())
+        return nil, fmt.Errorf("%w: %w", ErrQueueTimeout, err)
+    }
+    orderQueueWait.WithLabelValues(queueAdmitted).Observe(time.Since(start).Seconds())
+    return func() {
+        q.slots.Release(1)
+        q.inside.Add(-1)
+    }, nil
+}
+
+// Depth returns how many creations are waiting for a turn.
+func (q *CreationQueue) Depth() int64 {
+    if q == nil {
+        return 0
+    }
+    if waiting := q.inside.Load() - q.slots.Held(); waiting > 0 {
+        return waiting
+    }
+    return 0
+}
+
+// queueOrderCreation holds a creation in creationQueue until its turn,
+// turning it away with a 503 if the queue is full or the wait runs out.
+func queueOrderCreation(c *gin.Context) {
+    done, err := creationQueue.Enter(c.Request.Context())
+    if err != nil {
+        retryAfter := int(math.Ceil(creationQueue.maxWait.Seconds()))
+        c.Header("Retry-After", strconv.Itoa(retryAfter))
+        respondError(c, http.StatusServiceUnavailable, CodeOverloaded, "Too many orders are being placed; try again shortly")
+        return
+    }
+    defer done()
+    c.Next()
+}
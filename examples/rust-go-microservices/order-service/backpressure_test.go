@@ -0,0 +1,93 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "testing"
+    "time"
+)
+
+// burst sends n order creations at once and returns their responses.
+func burst(r http.Handler, n int) []*httptest.ResponseRecorder {
+    var wg sync.WaitGroup
+    responses := make([]*httptest.ResponseRecorder, n)
+    for i := range responses {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            responses[i] = doRequest(r, http.MethodPost, "/orders", sampleOrder)
+        }(i)
+    }
+    wg.Wait()
+    return responses
+}
+
+func TestCreationQueueDrainsBurst(t *testing.T) {
+    useServerGlobals(t)
+    fake := newPaymentServer(t)
+    fake.delay = 20 * time.Millisecond
+    resetOrders(t)
+    creationQueue = NewCreationQueue(1, 4, 2*time.Second)
+    r := setupRouter()
+
+    for i, w := range burst(r, 5) {
+        if w.Code != http.StatusCreated {
+            t.Fatalf("request %d: got status %d: %s", i, w.Code, w.Body)
+        }
+    }
+    if n := fake.charges.Load(); n != 5 {
+        t.Fatalf("got %d charges, want 5", n)
+    }
+    if depth := creationQueue.Depth(); depth != 0 {
+        t.Fatalf("queue depth %d after the burst, want 0", depth)
+    }
+}
+
+func TestCreationQueueOverflow(t *testing.T) {
+    useServerGlobals(t)
+    fake := newPaymentServer(t)
+    fake.delay = 200 * time.Millisecond
+    resetOrders(t)
+    creationQueue = NewCreationQueue(1, 1, 2*time.Second)
+    r := setupRouter()
+
+    created, rejected := 0, 0
+    for i, w := range burst(r, 5) {
+        switch w.Code {
+        case http.StatusCreated:
+            created++
+        case http.StatusServiceUnavailable:
+            rejected++
+            if code := decodeError(t, w).Code; code != CodeOverloaded {
+                t.Fatalf("request %d: got code %s, want %s", i, code, CodeOverloaded)
+            }
+            if w.Header().Get("Retry-After") != "2" {
+                t.Fatalf("request %d: got Retry-After %q, want 2", i, w.Header().Get("Retry-After"))
+            }
+        default:
+            t.Fatalf("request %d: got status %d: %s", i, w.Code, w.Body)
+        }
+    }
+    if created != 2 || rejected != 3 {
+        t.Fatalf("created %d and rejected %d, want one running, one queued and three turned away", created, rejected)
+    }
+}
+
+func TestCreationQueueWaitRunsOut(t *testing.T) {
+    q := NewCreationQueue(1, 1, 20*time.Millisecond)
+    done, err := q.Enter(context.Background())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer done()
+
+    if _, err := q.Enter(context.Background()); !errors.Is(err, ErrQueueTimeout) {
+        t.Fatalf("got %v, want ErrQueueTimeout", err)
+    }
+    if depth := q.Depth(); depth != 0 {
+        t.Fatalf("queue depth %d after timing out, want 0", depth)
+    }
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sync"
+
+    "github.com/gin-gonic/gin"
+    "github.com/gin-gonic/gin/binding"
+    "github.com/google/uuid"
+)
+
+const (
+    defaultMaxBatchSize = 100
+    // batchWorkers bounds how many orders of a batch are charged at once.
+    batchWorkers = 8
+)
+
+// maxBatchSize is the most orders accepted by one batch request.
+var maxBatchSize = defaultMaxBatchSize
+
+// BatchResult is the outcome of one order in a batch. Index is its position
+// in the request; Status is the HTTP status it would have got on its own.
+type BatchResult struct {
+    Index  int       `json:"index"`
+    Status int       `json:"status"`
+    Order  *Order    `json:"order,omitempty"`
+    Error  *APIError `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+    Results   []BatchResult `json:"results"`
+    Succeeded int           `json:"succeeded"`
+    Failed    int           `json:"failed"`
+}
+
+// createOrderBatch creates each order in a JSON array independently, as if
+// it had been posted to /orders, and reports a result per order. One order
+// failing does not stop the others.
+func createOrderBatch(c *gin.Context) {
+    ctx, span := startSpan(c.Request.Context(), "createOrderBatch")
+    defer span.End()
+    c.Request = c.Request.WithContext(ctx)
+
+    var items []json.RawMessage
+    if err := c.ShouldBindJSON(&items); err != nil {
+        respondBindError(c, err)
+        return
+    }
+    if len(items) == 0 {
+        verr := &ValidationError{}
+        verr.add("orders", "must not be empty")
+        respondValidationError(c, verr)
+        return
+    }
+    if len(items) > maxBatchSize {
+        respondError(c, http.StatusRequestEntityTooLarge, CodeRequestTooLarge,
+            fmt.Sprintf("Batch of %d orders exceeds the limit of %d", len(items), maxBatchSize))
+        return
+    }
+
+    results := make([]BatchResult, len(items))
+    work := make(chan int)
+    var wg sync.WaitGroup
+    for w := 0; w < min(batchWorkers, len(items)); w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := range work {
+                results[i] = createBatchItem(c, i, items[i])
+            }
+        }()
+    }
+    for i := range items {
+        work <- i
+    }
+    close(work)
+    wg.Wait()
+
+    resp := BatchResponse{Results: results}
+    for _, r := range results {
+        if r.Error == nil {
+            resp.Succeeded++
+        } else {
+            resp.Failed++
+        }
+    }
+    c.JSON(http.StatusOK, resp)
+}
+
+// createBatchItem decodes, checks and submits the order at index i.
+func createBatchItem(c *gin.Context, i int, raw json.RawMessage) BatchResult {
+    fail := func(rerr *requestError) BatchResult {
+        return BatchResult{Index: i, Status: rerr.Status, Error: &rerr.APIError}
+    }
+
+    warnings, rerr := checkAmounts(c, raw)
+    if rerr == nil {
+        rerr = checkClientOrderID(raw)
+    }
+    if rerr != nil {
+        return fail(rerr)
+    }
+    var order Order
+    if err := json.Unmarshal(raw, &order); err != nil {
+        return fail(newRequestError(http.StatusBadRequest, CodeInvalidRequest, err.Error()))
+    }
+    if order.OrderID != uuid.Nil {
+        order.RequestHash = requestFingerprint(raw)
+    }
+    if err := binding.Validator.ValidateStruct(&order); err != nil {
+        verr, ok := bindingFieldErrors(err)
+        if !ok {
+            return fail(newRequestError(http.StatusBadRequest, CodeInvalidRequest, err.Error()))
+        }
+        return fail(validationFailed(verr))
+    }
+    if rerr := channelFromHeader(c, &order); rerr != nil {
+        return fail(rerr)
+    }
+    if !canAccess(c, order.CustomerID) {
+        return fail(newRequestError(http.StatusForbidden, CodeForbidden, "API key may not create orders for this customer"))
+    }
+    existing, rerr := resubmittedOrder(c.Request.Context(), &order)
+    if rerr != nil {
+        return fail(rerr)
+    }
+    if existing != nil {
+        return BatchResult{Index: i, Status: http.StatusOK, Order: withLinks(existing)}
+    }
+    if rerr := submitOrder(withAmountWarnings(c.Request.Context(), warnings), &order); rerr != nil {
+        return fail(rerr)
+    }
+    return BatchResult{Index: i, Status: http.StatusCreated, Order: withLinks(&order)}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func decodeBatch(t *testing.T, body []byte) BatchResponse {
+    t.Helper()
+
+    var resp BatchResponse
+    if err := json.Unmarshal(body, &resp); err != nil {
+        t.Fatalf("invalid batch response %q: %v", body, err)
+    }
+    return resp
+}
+
+func batchOf(orders ...string) string {
+    return "[" + strings.Join(orders, ",") + "]"
+}
+
+func TestCreateOrderBatch(t *testing.T) {
+    resetOrders(t)
+    fake := newPaymentServer(t)
+    r := setupRouter()
+
+    orders := make([]string, 20)
+    for i := range orders {
+        orders[i] = sampleOrder
+    }
+    w := doRequest(r, http.MethodPost, "/orders/batch", batchOf(orders...))
+    if w.Code != http.StatusOK {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+
+    resp := decodeBatch(t, w.Body.Bytes())
+    if resp.Succeeded != 20 || resp.Failed != 0 {
+        t.Fatalf("got %d succeeded, %d failed; want 20, 0", resp.Succeeded, resp.Failed)
+    }
+    seen := map[string]bool{}
+    for i, res := range resp.Results {
+        if res.Index != i || res.Status != http.StatusCreated || res.Order == nil {
+            t.Fatalf("result %d: got %+v", i, res)
+        }
+        if res.Order.Status != StatusConfirmed {
+            t.Errorf("result %d: got status %q, want confirmed", i, res.Order.Status)
+        }
+        seen[res.Order.OrderID.String()] = true
+    }
+    if len(seen) != 20 {
+        t.Fatalf("got %d distinct order IDs, want 20", len(seen))
+    }
+    if got := fake.charges.Load(); got != 20 {
+        t.Fatalf("got %d charges, want 20", got)
+    }
+}
+
+func TestCreateOrderBatchPartialFailure(t *testing.T) {
+    resetOrders(t)
+    newPaymentServer(t)
+    r := setupRouter()
+
+    body := batchOf(
+        sampleOrder,
+        `{"items":[{"product_id":"prod_456","quantity":2,"price":"29.99"}]}`,
+        `{"customer_id":"cust_123","items":"not a list"}`,
+        `{"customer_id":"cust_123","items":[{"product_id":"prod_456","quantity":0,"price":"29.99"}]}`,
+    )
+    w := doRequest(r, http.MethodPost, "/orders/batch", body)
+    if w.Code != http.StatusOK {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+
+    resp := decodeBatch(t, w.Body.Bytes())
+    if resp.Succeeded != 1 || resp.Failed != 3 {
+        t.Fatalf("got %d succeeded, %d failed; want 1, 3", resp.Succeeded, resp.Failed)
+    }
+    want := []struct {
+        status int
+        code   string
+    }{
+        {http.StatusCreated, ""},
+        {http.StatusUnprocessableEntity, CodeValidationFailed},
+        {http.StatusBadRequest, CodeInvalidRequest},
+        {http.StatusUnprocessableEntity, CodeValidationFailed},
+    }
+    for i, res := range resp.Results {
+        if res.Index != i || res.Status != want[i].status {
+            t.Errorf("result %d: got index %d status %d, want status %d", i, res.Index, res.Status, want[i].status)
+        }
+        if want[i].code == "" {
+            if res.Error != nil || res.Order == nil {
+                t.Errorf("result %d: got error %+v, want an order", i, res.Error)
+            }
+            continue
+        }
+        if res.Error == nil || res.Error.Code != want[i].code {
+            t.Errorf("result %d: got error %+v, want code %s", i, res.Error, want[i].code)
+        }
+    }
+
+    if list, _ := orders.List(); len(list) != 1 {
+        t.Fatalf("stored %d orders, want only the valid one", len(list))
+    }
+}
+
+func TestCreateOrderBatchTooLarge(t *testing.T) {
+    resetOrders(t)
+    fake := newPaymentServer(t)
+    prev := maxBatchSize
+    maxBatchSize = 3
+    t.Cleanup(func() { maxBatchSize = prev })
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders/batch", batchOf(sampleOrder, sampleOrder, sampleOrder, sampleOrder))
+    if w.Code != http.StatusRequestEntityTooLarge {
+        t.Fatalf("got status %d, want 413", w.Code)
+    }
+    if got := decodeError(t, w).Code; got != CodeRequestTooLarge {
+        t.Fatalf("got code %s, want %s", got, CodeRequestTooLarge)
+    }
+    if got := fake.charges.Load(); got != 0 {
+        t.Fatalf("got %d charges for a rejected batch", got)
+    }
+}
+
+func TestCreateOrderBatchRejectsEmptyAndNonArray(t *testing.T) {
+    r := setupRouter()
+
+    if w := doRequest(r, http.MethodPost, "/orders/batch", `[]`); w.Code != http.StatusUnprocessableEntity {
+        t.Errorf("empty batch: got status %d, want 422", w.Code)
+    }
+    if w := doRequest(r, http.MethodPost, "/orders/batch", sampleOrder); w.Code != http.StatusBadRequest {
+        t.Errorf("single order: got status %d, want 400", w.Code)
+    }
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+)
+
+const defaultMaxBodyBytes = 1 << 20
+
+// maxJSONDepth is how deeply arrays and objects may nest in a request body.
+// No request the API accepts goes past a handful of levels.
+const maxJSONDepth = 32
+
+// maxBodyBytes is the largest request body accepted.
+var maxBodyBytes int64 = defaultMaxBodyBytes
+
+// limitRequestBody caps request bodies at max bytes. A body declared
+// larger is rejected before it is read; one that turns out larger fails
+// with *http.MaxBytesError when read, which respondBindError reports as
+// 413.
+func limitRequestBody(max int64) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if c.Request.ContentLength > max {
+            respondBodyTooLarge(c, max)
+            return
+        }
+        c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, max)
+        c.Next()
+    }
+}
+
+// limitJSONDepth rejects request bodies whose arrays and objects nest more
+// than max levels deep, before any handler decodes them. It reads the whole
+// body, so it must run after limitRequestBody.
+func limitJSONDepth(max int) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if c.Request.Body == nil || c.Request.Body == http.NoBody {
+            c.Next()
+            return
+        }
+        body, err := io.ReadAll(c.Request.Body)
+        if err != nil {
+            respondBindError(c, err)
+            return
+        }
+        if jsonDepth(body) > max {
+            respondError(c, http.StatusBadRequest, CodeInvalidRequest,
+                fmt.Sprintf("Request body nests deeper than %d levels", max))
+            return
+        }
+        c.Request.Body = io.NopCloser(bytes.NewReader(body))
+        c.Next()
+    }
+}
+
+// jsonDepth returns the deepest nesting of arrays and objects in data,
+// ignoring brackets inside strings. It doesn't check that data is valid
+// JSON; the decoder does that.
+func jsonDepth(data []byte) int {
+    depth, deepest := 0, 0
+    inString, escaped := false, false
+    for _, b := range data {
+        switch {
+        case escaped:
+            escaped = false
+        case inString:
+            switch b {
+            case '\\':
+                escaped = true
+            case '"':
+                inString = false
+            }
+        case b == '"':
+            inString = true
+        case b == '[' || b == '{':
+            depth++
+            if depth > deepest {
+                deepest = depth
+            }
+        case b == ']' || b == '}':
+            depth--
+        }
+    }
+    return deepest
+}
+
+func respondBodyTooLarge(c *gin.Context, max int64) {
+    respondError(c, http.StatusRequestEntityTooLarge, CodeRequestTooLarge,
+        fmt.Sprintf("Request body exceeds the %d byte limit", max))
+}
+
+// respondBindError reports a failure to read or bind a request body: 413
+// for a body over the size limit, 422 for one failing validation tags and
+// 400 for anything else, such as malformed JSON.
+func respondBindError(c *gin.Context, err error) {
+    var tooLarge *http.MaxBytesError
+    if errors.As(err, &tooLarge) {
+        respondBodyTooLarge(c, tooLarge.Limit)
+        return
+    }
+    if verr, ok := bindingFieldErrors(err); ok {
+        respondValidationError(c, verr)
+        return
+    }
+    respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+}
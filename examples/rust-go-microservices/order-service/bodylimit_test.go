@@ -0,0 +1,127 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func withMaxBodyBytes(t *testing.T, n int64) {
+    t.Helper()
+
+    prev := maxBodyBytes
+    maxBodyBytes = n
+    t.Cleanup(func() { maxBodyBytes = prev })
+}
+
+// oversizedOrder is a valid order padded past limit bytes.
+func oversizedOrder(limit int) string {
+    return `{"customer_id":"` + strings.Repeat("c", limit) + `","items":[{"product_id":"p","quantity":1,"price":"1"}]}`
+}
+
+func TestOversizedBodyRejected(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    withMaxBodyBytes(t, 256)
+    r := setupRouter()
+    order := saveOrderWithStatus(StatusPending)
+
+    tests := []struct {
+        method, path string
+    }{
+        {http.MethodPost, "/orders"},
+        {http.MethodPatch, "/orders/" + order.OrderID.String()},
+    }
+    for _, tt := range tests {
+        w := doRequest(r, tt.method, tt.path, oversizedOrder(256))
+        if w.Code != http.StatusRequestEntityTooLarge {
+            t.Fatalf("%s %s: got status %d, want 413: %s", tt.method, tt.path, w.Code, w.Body)
+        }
+        if code := decodeError(t, w).Code; code != CodeRequestTooLarge {
+            t.Fatalf("%s %s: got code %s", tt.method, tt.path, code)
+        }
+    }
+    if n := fake.charges.Load(); n != 0 {
+        t.Fatalf("oversized order charged %d times", n)
+    }
+}
+
+func TestOversizedChunkedBodyRejected(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    withMaxBodyBytes(t, 256)
+    prev := createLimiter
+    createLimiter = NewRateLimiter(100)
+    t.Cleanup(func() { createLimiter = prev })
+    r := setupRouter()
+
+    // Without a Content-Length the limit is only hit while reading, after
+    // the rate limiter has already buffered the body.
+    req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(oversizedOrder(256)))
+    req.ContentLength = -1
+    w := httptest.NewRecorder()
+    r.ServeHTTP(w, req)
+    if w.Code != http.StatusRequestEntityTooLarge {
+        t.Fatalf("got status %d, want 413: %s", w.Code, w.Body)
+    }
+}
+
+func TestBodyWithinLimitAccepted(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    withMaxBodyBytes(t, 256)
+    r := setupRouter()
+
+    if w := doRequest(r, http.MethodPost, "/orders", sampleOrder); w.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    w := doRequest(r, http.MethodPost, "/orders", `{"customer_id":`)
+    if w.Code != http.StatusBadRequest || decodeError(t, w).Code != CodeInvalidRequest {
+        t.Fatalf("malformed JSON: got %d %s, want 400 %s", w.Code, w.Body, CodeInvalidRequest)
+    }
+}
+
+func TestJSONDepth(t *testing.T) {
+    tests := []struct {
+        body string
+        want int
+    }{
+        {``, 0},
+        {`"flat"`, 0},
+        {sampleOrder, 3},
+        {`{"a":"[[[{{{"}`, 1},
+        {`{"a":"quote \" [[["}`, 1},
+        {`{"a":"backslash \\"}` + `[[`, 2},
+        {strings.Repeat("[", 50) + strings.Repeat("]", 50), 50},
+    }
+    for _, tt := range tests {
+        if got := jsonDepth([]byte(tt.body)); got != tt.want {
+            t.Errorf("jsonDepth(%.40q) = %d, want %d", tt.body, got, tt.want)
+        }
+    }
+}
+
+func TestDeeplyNestedBodyRejected(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    nested := strings.Repeat(`{"a":`, maxJSONDepth) + `1` + strings.Repeat(`}`, maxJSONDepth)
+    body := `{"customer_id":"cust_123","items":[{"product_id":"p","quantity":1,"price":"1"}],"metadata":` + nested + `}`
+    w := doRequest(r, http.MethodPost, "/orders", body)
+    if w.Code != http.StatusBadRequest {
+        t.Fatalf("got status %d, want 400: %s", w.Code, w.Body)
+    }
+    if code := decodeError(t, w).Code; code != CodeInvalidRequest {
+        t.Fatalf("got code %s", code)
+    }
+    if n := fake.charges.Load(); n != 0 {
+        t.Fatalf("nested order charged %d times", n)
+    }
+
+    // Depth limits don't change how ordinary bodies are handled.
+    if w := doRequest(r, http.MethodPost, "/orders", sampleOrder); w.Code != http.StatusCreated {
+        t.Fatalf("got status %d for a plain order: %s", w.Code, w.Body)
+    }
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+    "errors"
+    "sync"
+    "time"
+)
+
+const (
+    BreakerClosed   = "closed"
+    BreakerOpen     = "open"
+    BreakerHalfOpen = "half-open"
+
+    defaultBreakerThreshold = 5
+    defaultBreakerCooldown  = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned instead of calling a dependency whose breaker
+// is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitBreaker stops calls to a failing dependency. After threshold
+// consecutive failures it opens and rejects calls for cooldown; then it lets
+// a single probe through and closes again only if that probe succeeds.
+type CircuitBreaker struct {
+    mu        sync.Mutex
+    threshold int
+    cooldown  time.Duration
+    now       func() time.Time
+
+    state    string
+    failures int
+    openedAt time.Time
+    probing  bool
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+    return &CircuitBreaker{
+        threshold: threshold,
+        cooldown:  cooldown,
+        now:       currentTime,
+        state:     BreakerClosed,
+    }
+}
+
+// Allow reports whether a call may proceed. A caller that is allowed must
+// report the outcome with Record.
+func (b *CircuitBreaker) Allow() bool {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    switch b.state {
+    case BreakerOpen:
+        if b.now().Sub(b.openedAt) < b.cooldown {
+            return false
+        }
+        b.state = BreakerHalfOpen
+        b.probing = true
+        return true
+    case BreakerHalfOpen:
+        if b.probing {
+            return false
+        }
+        b.probing = true
+        return true
+    default:
+        return true
+    }
+}
+
+// Record reports the outcome of a call admitted by Allow.
+func (b *CircuitBreaker) Record(success bool) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if success {
+        b.state = BreakerClosed
+        b.failures = 0
+        b.probing = false
+        return
+    }
+
+    b.failures++
+    if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+        b.state = BreakerOpen
+        b.openedAt = b.now()
+        b.probing = false
+    }
+}
+
+// Release ends a call admitted by Allow without reporting an outcome, for
+// calls abandoned by their caller. A half-open breaker may then probe again.
+func (b *CircuitBreaker) Release() {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    b.probing = false
+}
+
+// State returns the breaker's current state. An open breaker whose cooldown
+// has elapsed reports half-open, since the next call will be let through.
+func (b *CircuitBreaker) State() string {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+        return BreakerHalfOpen
+    }
+    return b.state
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+    now := time.Now()
+    b := NewCircuitBreaker(3, time.Minute)
+    b.now = func() time.Time { return now }
+
+    for i := 0; i < 2; i++ {
+        if !b.Allow() {
+            t.Fatalf("closed breaker rejected call %d", i)
+        }
+        b.Record(false)
+    }
+    if got := b.State(); got != BreakerClosed {
+        t.Fatalf("after 2 failures state = %q, want closed", got)
+    }
+
+    b.Allow()
+    b.Record(false)
+    if got := b.State(); got != BreakerOpen {
+        t.Fatalf("after 3 failures state = %q, want open", got)
+    }
+    if b.Allow() {
+        t.Fatal("open breaker allowed a call")
+    }
+
+    now = now.Add(time.Minute)
+    if got := b.State(); got != BreakerHalfOpen {
+        t.Fatalf("after cooldown state = %q, want half-open", got)
+    }
+    if !b.Allow() {
+        t.Fatal("half-open breaker rejected the probe")
+    }
+    if b.Allow() {
+        t.Fatal("half-open breaker allowed a second call while probing")
+    }
+
+    // A failed probe re-opens for another full cooldown.
+    b.Record(false)
+    if got := b.State(); got != BreakerOpen {
+        t.Fatalf("after failed probe state = %q, want open", got)
+    }
+
+    now = now.Add(time.Minute)
+    b.Allow()
+    b.Record(true)
+    if got := b.State(); got != BreakerClosed {
+        t.Fatalf("after successful probe state = %q, want closed", got)
+    }
+    if !b.Allow() {
+        t.Fatal("closed breaker rejected a call")
+    }
+}
+
+func TestPaymentClientTripsBreaker(t *testing.T) {
+    calls := 0
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        calls++
+        http.Error(w, "down", http.StatusInternalServerError)
+    }))
+    defer srv.Close()
+
+    var delays []time.Duration
+    p := newTestPaymentClient(srv.URL, &delays)
+    p.MaxRetries = 0
+    p.Breaker = NewCircuitBreaker(2, time.Minute)
+
+    for i := 0; i < 2; i++ {
+        if _, err := p.processPayment(context.Background(), PaymentRequest{}); err == nil || errors.Is(err, ErrCircuitOpen) {
+            t.Fatalf("call %d: got %v, want a payment error", i, err)
+        }
+    }
+    if _, err := p.processPayment(context.Background(), PaymentRequest{}); !errors.Is(err, ErrCircuitOpen) {
+        t.Fatalf("got %v, want ErrCircuitOpen", err)
+    }
+    if calls != 2 {
+        t.Fatalf("payment service called %d times, want 2", calls)
+    }
+}
+
+func TestCreateOrderFastFailsWhenBreakerOpen(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    breaker := NewCircuitBreaker(1, time.Minute)
+    breaker.Allow()
+    breaker.Record(false)
+    payments.Breaker = breaker
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", sampleOrder)
+    if w.Code != http.StatusServiceUnavailable {
+        t.Fatalf("got status %d, want 503", w.Code)
+    }
+    if n := fake.charges.Load(); n != 0 {
+        t.Fatalf("payment service called %d times while breaker open", n)
+    }
+
+    var body map[string]interface{}
+    json.Unmarshal(doRequest(r, http.MethodGet, "/health", "").Body.Bytes(), &body)
+    if body["payment_circuit"] != BreakerOpen {
+        t.Fatalf("health reports payment_circuit %v, want open", body["payment_circuit"])
+    }
+}
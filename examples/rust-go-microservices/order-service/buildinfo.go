@@ -0,0 +1,68 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "runtime"
+    "runtime/debug"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+// version and commit identify the build. They are set with -ldflags:
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse --short HEAD)"
+//
+// Without them, commit falls back to the VCS revision the go tool embeds.
+var (
+    version = "dev"
+    commit  = ""
+)
+
+// startTime is when the process started, for reporting uptime.
+var startTime = time.Now()
+
+// BuildInfo describes the running build, so a deploy can be confirmed.
+type BuildInfo struct {
+    Version   string    `json:"version"`
+    Commit    string    `json:"commit"`
+    GoVersion string    `json:"go_version"`
+    StartedAt time.Time `json:"started_at"`
+    // UptimeSeconds is whole seconds since StartedAt.
+    UptimeSeconds int64 `json:"uptime_seconds"`
+}
+
+func currentBuildInfo() BuildInfo {
+    return BuildInfo{
+        Version:       version,
+        Commit:        buildCommit(),
+        GoVersion:     runtime.Version(),
+        StartedAt:     startTime.UTC(),
+        UptimeSeconds: int64(time.Since(startTime).Seconds()),
+    }
+}
+
+func buildCommit() string {
+    if commit != "" {
+        return commit
+    }
+    if info, ok := debug.ReadBuildInfo(); ok {
+        for _, s := range info.Settings {
+            if s.Key == "vcs.revision" {
+                return s.Value
+            }
+        }
+    }
+    return "unknown"
+}
+
+// versionString is the one-line summary printed by -version.
+func versionString() string {
+    info := currentBuildInfo()
+    return fmt.Sprintf("order-service %s (commit %s, %s)", info.Version, info.Commit, info.GoVersion)
+}
+
+func versionHandler(c *gin.Context) {
+    c.JSON(http.StatusOK, currentBuildInfo())
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "os/exec"
+    "path/filepath"
+    "runtime"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestVersionEndpoint(t *testing.T) {
+    prevVersion, prevCommit, prevStart := version, commit, startTime
+    version, commit, startTime = "1.2.3", "abc1234", time.Now().Add(-90*time.Second)
+    t.Cleanup(func() { version, commit, startTime = prevVersion, prevCommit, prevStart })
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodGet, "/version", "")
+    var info BuildInfo
+    if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil || w.Code != http.StatusOK {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    if info.Version != "1.2.3" || info.Commit != "abc1234" || info.GoVersion != runtime.Version() {
+        t.Fatalf("got %+v", info)
+    }
+    if info.UptimeSeconds < 90 || info.UptimeSeconds > 100 {
+        t.Fatalf("got uptime %ds, want about 90s", info.UptimeSeconds)
+    }
+
+    var health map[string]interface{}
+    json.Unmarshal(doRequest(r, http.MethodGet, "/health", "").Body.Bytes(), &health)
+    if health["version"] != "1.2.3" || health["commit"] != "abc1234" || health["uptime_seconds"] == nil {
+        t.Fatalf("health is missing build info: %v", health)
+    }
+}
+
+func TestVersionInjectedWithLdflags(t *testing.T) {
+    if testing.Short() {
+        t.Skip("builds the binary")
+    }
+    bin := filepath.Join(t.TempDir(), "order-service")
+    build := exec.Command("go", "build", "-o", bin, "-ldflags", "-X main.version=v9.8.7-test -X main.commit=feedbee", ".")
+    if out, err := build.CombinedOutput(); err != nil {
+        t.Fatalf("go build: %v\n%s", err, out)
+    }
+
+    out, err := exec.Command(bin, "-version").Output()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := string(out); !strings.Contains(got, "v9.8.7-test") || !strings.Contains(got, "feedbee") {
+        t.Fatalf("got %q, want the injected version and commit", got)
+    }
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "slices"
+    "sort"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+)
+
+// channelHeader names the channel an order comes from, for front-ends that
+// would rather not put it in the body.
+const channelHeader = "X-Channel"
+
+// The channels known when ORDER_CHANNELS is unset. They have no settings
+// of their own, so orders from them behave like orders without a channel.
+const (
+    ChannelWeb     = "web"
+    ChannelMobile  = "mobile"
+    ChannelPartner = "partner"
+)
+
+// ChannelConfig holds what differs between the front-ends orders come from.
+// Every field is optional; an unset one leaves the service-wide behaviour.
+type ChannelConfig struct {
+    // Currency is used for orders that don't name one.
+    Currency string `json:"currency"`
+    // PaymentMethods lists the payment method types the channel accepts.
+    // Orders without a payment method count as card payments.
+    PaymentMethods []string `json:"payment_methods"`
+    // RateLimitPerMinute replaces RATE_LIMIT_PER_MINUTE for orders created
+    // through the channel, which get buckets of their own.
+    RateLimitPerMinute int `json:"rate_limit_per_minute"`
+
+    limiter *RateLimiter
+}
+
+// orderChannels are the channels orders may name. An order without a
+// channel is accepted as before, with no channel settings applied.
+var orderChannels = defaultOrderChannels()
+
+func defaultOrderChannels() map[string]*ChannelConfig {
+    return map[string]*ChannelConfig{
+        ChannelWeb:     {},
+        ChannelMobile:  {},
+        ChannelPartner: {},
+    }
+}
+
+// parseChannels reads a JSON object mapping channel names to their
+// ChannelConfig, such as
+//
+//	{"web": {}, "partner": {"currency": "EUR", "payment_methods": ["bank_transfer"]}}
+func parseChannels(raw string) (map[string]*ChannelConfig, error) {
+    dec := json.NewDecoder(strings.NewReader(raw))
+    dec.DisallowUnknownFields()
+    var channels map[string]*ChannelConfig
+    if err := dec.Decode(&channels); err != nil {
+        return nil, err
+    }
+    if len(channels) == 0 {
+        return nil, fmt.Errorf("no channels configured")
+    }
+    for name, cfg := range channels {
+        if strings.TrimSpace(name) == "" {
+            return nil, fmt.Errorf("channel names must not be empty")
+        }
+        if cfg == nil {
+            cfg = &ChannelConfig{}
+            channels[name] = cfg
+        }
+        if cfg.Currency != "" {
+            cfg.Currency = normalizeCurrency(cfg.Currency)
+            if _, ok := lookupCurrency(cfg.Currency); !ok {
+                return nil, fmt.Errorf("channel %s: %q is not a supported currency", name, cfg.Currency)
+            }
+        }
+        for _, method := range cfg.PaymentMethods {
+            if !paymentMethodTypes[method] {
+                return nil, fmt.Errorf("channel %s: unknown payment method %q", name, method)
+            }
+        }
+        if cfg.RateLimitPerMinute < 0 {
+            return nil, fmt.Errorf("channel %s: rate_limit_per_minute must not be negative", name)
+        }
+        if cfg.RateLimitPerMinute > 0 {
+            cfg.limiter = NewRateLimiter(cfg.RateLimitPerMinute)
+        }
+    }
+    return channels, nil
+}
+
+// channelsFromEnv reads ORDER_CHANNELS, falling back to the default
+// channels when it is unset.
+func channelsFromEnv() (map[string]*ChannelConfig, error) {
+    raw := os.Getenv("ORDER_CHANNELS")
+    if raw == "" {
+        return defaultOrderChannels(), nil
+    }
+    channels, err := parseChannels(raw)
+    if err != nil {
+        return nil, fmt.Errorf("ORDER_CHANNELS: %w", err)
+    }
+    return channels, nil
+}
+
+// channelNames lists the configured channels, for error messages.
+func channelNames() string {
+    names := make([]string, 0, len(orderChannels))
+    for name := range orderChannels {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return strings.Join(names, ", ")
+}
+
+// channelFromHeader sets the order's channel from X-Channel. A body that
+// names a different channel than the header is rejected rather than have
+// one silently win.
+func channelFromHeader(c *gin.Context, order *Order) *requestError {
+    header := strings.TrimSpace(c.GetHeader(channelHeader))
+    if header == "" {
+        return nil
+    }
+    if order.Channel != "" && order.Channel != header {
+        verr := &ValidationError{}
+        verr.add("channel", "%q does not match the %s header %q", order.Channel, channelHeader, header)
+        return validationFailed(verr)
+    }
+    order.Channel = header
+    return nil
+}
+
+// applyChannelDefaults fills in what the order's channel provides and the
+// order leaves out. Unknown channels are left for validateChannel.
+func applyChannelDefaults(order *Order) {
+    order.Channel = strings.TrimSpace(order.Channel)
+    cfg := orderChannels[order.Channel]
+    if cfg == nil {
+        return
+    }
+    if strings.TrimSpace(order.Currency) == "" && cfg.Currency != "" {
+        order.Currency = cfg.Currency
+    }
+}
+
+// validateChannel adds to verr if the order names an unknown channel, or
+// uses a payment method its channel doesn't accept.
+func validateChannel(verr *ValidationError, order *Order) {
+    if order.Channel == "" {
+        return
+    }
+    cfg, ok := orderChannels[order.Channel]
+    if !ok {
+        verr.add("channel", "%q is not a known channel; use one of %s", order.Channel, channelNames())
+        return
+    }
+    if len(cfg.PaymentMethods) == 0 {
+        return
+    }
+    for i, method := range order.paymentMethodTypes() {
+        if slices.Contains(cfg.PaymentMethods, method) {
+            continue
+        }
+        field := "payment_method.type"
+        if len(order.PaymentSplits) > 0 {
+            field = fmt.Sprintf("payment_splits[%d].payment_method.type", i)
+        }
+        verr.add(field, "%q is not accepted on channel %s; use one of %s",
+            method, order.Channel, strings.Join(cfg.PaymentMethods, ", "))
+    }
+}
+
+// channelLimiter picks the limiter for an order creation: its channel's,
+// if that has its own rate limit, or createLimiter.
+func channelLimiter(c *gin.Context) *RateLimiter {
+    name := strings.TrimSpace(c.GetHeader(channelHeader))
+    if name == "" {
+        var req struct {
+            Channel string `json:"channel"`
+        }
+        if body, err := peekBody(c); err == nil && json.Unmarshal(body, &req) == nil {
+            name = strings.TrimSpace(req.Channel)
+        }
+    }
+    if cfg := orderChannels[name]; cfg != nil && cfg.limiter != nil {
+        return cfg.limiter
+    }
+    return createLimiter
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+// useChannels configures the channels in raw ORDER_CHANNELS form for the
+// rest of the test.
+func useChannels(t *testing.T, raw string) {
+    t.Helper()
+
+    channels, err := parseChannels(raw)
+    if err != nil {
+        t.Fatal(err)
+    }
+    prev := orderChannels
+    orderChannels = channels
+    t.Cleanup(func() { orderChannels = prev })
+}
+
+const testChannels = `{
+    "web": {},
+    "mobile": {"currency": "gbp"},
+    "partner": {"currency": "EUR", "payment_methods": ["bank_transfer"], "rate_limit_per_minute": 1}
+}`
+
+const bankTransferOrder = `{"customer_id":"cust_123","items":[{"product_id":"prod_456","quantity":2,"price":"29.99"}],
+    "payment_method":{"type":"bank_transfer","bank_transfer":{"account_holder":"Acme","account_last4":"1234"}}}`
+
+func TestChannelDefaultsApplied(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    useChannels(t, testChannels)
+    r := setupRouter()
+
+    for _, tc := range []struct {
+        name, body string
+        headers    map[string]string
+        channel    string
+        currency   string
+    }{
+        {"no channel", sampleOrder, nil, "", defaultCurrency},
+        {"web", `{"channel":"web",` + sampleOrder[1:], nil, "web", defaultCurrency},
+        {"mobile by field", `{"channel":"mobile",` + sampleOrder[1:], nil, "mobile", "GBP"},
+        {"mobile by header", sampleOrder, map[string]string{channelHeader: "mobile"}, "mobile", "GBP"},
+        {"explicit currency wins", `{"channel":"mobile","currency":"USD",` + sampleOrder[1:], nil, "mobile", "USD"},
+        {"partner", bankTransferOrder, map[string]string{channelHeader: "partner"}, "partner", "EUR"},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            w := doRequestWithHeaders(r, http.MethodPost, "/orders", tc.body, tc.headers)
+            if w.Code != http.StatusCreated {
+                t.Fatalf("got status %d: %s", w.Code, w.Body)
+            }
+            var order Order
+            json.Unmarshal(w.Body.Bytes(), &order)
+            if order.Channel != tc.channel || order.Currency != tc.currency {
+                t.Fatalf("got channel %q, currency %s; want %q, %s", order.Channel, order.Currency, tc.channel, tc.currency)
+            }
+        })
+    }
+}
+
+func TestChannelRejected(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    useChannels(t, testChannels)
+    r := setupRouter()
+
+    for _, tc := range []struct {
+        name, body string
+        headers    map[string]string
+        field      string
+    }{
+        {"unknown field", `{"channel":"kiosk",` + sampleOrder[1:], nil, "channel"},
+        {"unknown header", sampleOrder, map[string]string{channelHeader: "kiosk"}, "channel"},
+        {"header conflicts with field", `{"channel":"web",` + sampleOrder[1:], map[string]string{channelHeader: "mobile"}, "channel"},
+        {"payment method not accepted", sampleOrder, map[string]string{channelHeader: "partner"}, "payment_method.type"},
+    } {
+        t.Run(tc.name, func(t *testing.T) {
+            w := doRequestWithHeaders(r, http.MethodPost, "/orders", tc.body, tc.headers)
+            if w.Code != http.StatusUnprocessableEntity {
+                t.Fatalf("got status %d, want 422: %s", w.Code, w.Body)
+            }
+            if !strings.Contains(w.Body.String(), `"field":"`+tc.field+`"`) {
+                t.Fatalf("error doesn't name field %s: %s", tc.field, w.Body)
+            }
+        })
+    }
+    if all, _ := orders.List(); len(all) != 0 {
+        t.Fatalf("%d orders stored", len(all))
+    }
+}
+
+func TestChannelRateLimit(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    useChannels(t, testChannels)
+    r := setupRouter()
+
+    partner := map[string]string{channelHeader: "partner"}
+    if w := doRequestWithHeaders(r, http.MethodPost, "/orders", bankTransferOrder, partner); w.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    if w := doRequestWithHeaders(r, http.MethodPost, "/orders", bankTransferOrder, partner); w.Code != http.StatusTooManyRequests {
+        t.Fatalf("got status %d past the partner limit, want 429", w.Code)
+    }
+    byField := `{"channel":"partner",` + bankTransferOrder[1:]
+    if w := doRequest(r, http.MethodPost, "/orders", byField); w.Code != http.StatusTooManyRequests {
+        t.Fatalf("got status %d for the channel in the body, want 429", w.Code)
+    }
+    if w := doRequest(r, http.MethodPost, "/orders", bankTransferOrder); w.Code != http.StatusCreated {
+        t.Fatalf("without a channel: got status %d, want 201", w.Code)
+    }
+}
+
+func TestParseChannelsRejectsBadConfig(t *testing.T) {
+    for _, raw := range []string{
+        `{}`,
+        `not json`,
+        `{"web": {"currency": "XXZ"}}`,
+        `{"web": {"payment_methods": ["cash"]}}`,
+        `{"web": {"rate_limit_per_minute": -1}}`,
+        `{"web": {"colour": "blue"}}`,
+    } {
+        if _, err := parseChannels(raw); err == nil {
+            t.Errorf("parseChannels(%s) succeeded", raw)
+        }
+    }
+}
@@ -0,0 +1,252 @@
+// Package client is a typed Go client for the order service's HTTP API.
+//
+//	c := client.New("http://localhost:8002")
+//	c.APIKey = os.Getenv("ORDER_SERVICE_API_KEY")
+//	order, err := c.CreateOrder(ctx, client.CreateOrderRequest{...})
+//	if client.IsCode(err, client.CodeOutOfStock) {
+//	    ...
+//	}
+package client
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/shopspring/decimal"
+)
+
+// DefaultTimeout bounds each call made by a Client from New. It leaves room
+// for the service's own 25 second allowance for batches.
+const DefaultTimeout = 30 * time.Second
+
+// Client calls the order service. Every method takes a context, which
+// cancels the call when done.
+type Client struct {
+    BaseURL string
+    // APIKey, if set, is sent as a bearer token.
+    APIKey     string
+    HTTPClient *http.Client
+}
+
+// New returns a Client for the service at baseURL, such as
+// "http://localhost:8002", with calls bounded by DefaultTimeout.
+func New(baseURL string) *Client {
+    return &Client{
+        BaseURL:    strings.TrimRight(baseURL, "/"),
+        HTTPClient: &http.Client{Timeout: DefaultTimeout},
+    }
+}
+
+// CreateOrder places an order, charging it. A declined payment still
+// returns the order, in status payment_failed.
+func (c *Client) CreateOrder(ctx context.Context, req CreateOrderRequest) (*Order, error) {
+    header := http.Header{}
+    if req.IdempotencyKey != "" {
+        header.Set("Idempotency-Key", req.IdempotencyKey)
+    }
+    var order Order
+    if err := c.do(ctx, http.MethodPost, "/orders", nil, header, req, &order); err != nil {
+        return nil, err
+    }
+    return &order, nil
+}
+
+// GetOrder fetches an order.
+func (c *Client) GetOrder(ctx context.Context, id uuid.UUID) (*Order, error) {
+    var order Order
+    if err := c.do(ctx, http.MethodGet, orderPath(id), nil, nil, nil, &order); err != nil {
+        return nil, err
+    }
+    return &order, nil
+}
+
+// ListOrders lists the orders the API key may see.
+func (c *Client) ListOrders(ctx context.Context, opts ListOptions) (*OrderList, error) {
+    var list OrderList
+    if err := c.do(ctx, http.MethodGet, "/orders", opts.query(), nil, nil, &list); err != nil {
+        return nil, err
+    }
+    return &list, nil
+}
+
+// ListCustomerOrders lists one customer's orders.
+func (c *Client) ListCustomerOrders(ctx context.Context, customerID string, opts ListOptions) (*OrderList, error) {
+    var list OrderList
+    if err := c.do(ctx, http.MethodGet, "/customers/"+url.PathEscape(customerID)+"/orders", opts.query(), nil, nil, &list); err != nil {
+        return nil, err
+    }
+    return &list, nil
+}
+
+// UpdateOrder replaces the items of a pending order. version is the
+// order's Version as last read; if the order has changed since, the call
+// fails with CodeVersionConflict.
+func (c *Client) UpdateOrder(ctx context.Context, id uuid.UUID, version int64, items []OrderItem) (*Order, error) {
+    body := struct {
+        Items []OrderItem `json:"items"`
+    }{items}
+    var order Order
+    if err := c.do(ctx, http.MethodPatch, orderPath(id), nil, ifMatch(version), body, &order); err != nil {
+        return nil, err
+    }
+    return &order, nil
+}
+
+// CancelOrder cancels an order, refunding it first if it was paid. version
+// is checked as for UpdateOrder, except that cancelling an order that is
+// already cancelled returns it unchanged, so a retried cancel is safe.
+func (c *Client) CancelOrder(ctx context.Context, id uuid.UUID, version int64) (*Order, error) {
+    var order Order
+    if err := c.do(ctx, http.MethodPost, orderPath(id)+"/cancel", nil, ifMatch(version), nil, &order); err != nil {
+        return nil, err
+    }
+    return &order, nil
+}
+
+// RefundOrder refunds amount of a paid order, or whatever has not been
+// refunded yet if amount is nil.
+func (c *Client) RefundOrder(ctx context.Context, id uuid.UUID, amount *decimal.Decimal) (*Order, error) {
+    body := struct {
+        Amount *decimal.Decimal `json:"amount,omitempty"`
+    }{amount}
+    var order Order
+    if err := c.do(ctx, http.MethodPost, orderPath(id)+"/refund", nil, nil, body, &order); err != nil {
+        return nil, err
+    }
+    return &order, nil
+}
+
+// RefundItems refunds units of a paid order's items. The amount given back
+// is worked out by the service from what the units cost.
+func (c *Client) RefundItems(ctx context.Context, id uuid.UUID, items []RefundItem) (*Order, error) {
+    body := struct {
+        Items []RefundItem `json:"items"`
+    }{items}
+    var order Order
+    if err := c.do(ctx, http.MethodPost, orderPath(id)+"/refund", nil, nil, body, &order); err != nil {
+        return nil, err
+    }
+    return &order, nil
+}
+
+// DeleteOrder soft-deletes an order and returns it.
+func (c *Client) DeleteOrder(ctx context.Context, id uuid.UUID) (*Order, error) {
+    var order Order
+    if err := c.do(ctx, http.MethodDelete, orderPath(id), nil, nil, nil, &order); err != nil {
+        return nil, err
+    }
+    return &order, nil
+}
+
+// AddTag labels an order with tag, a lowercase operator label such as
+// "vip". Adding a tag the order already has is not an error.
+func (c *Client) AddTag(ctx context.Context, id uuid.UUID, tag string) (*Order, error) {
+    var order Order
+    if err := c.do(ctx, http.MethodPost, orderPath(id)+"/tags/"+url.PathEscape(tag), nil, nil, nil, &order); err != nil {
+        return nil, err
+    }
+    return &order, nil
+}
+
+// RemoveTag takes tag off an order, if it has it.
+func (c *Client) RemoveTag(ctx context.Context, id uuid.UUID, tag string) (*Order, error) {
+    var order Order
+    if err := c.do(ctx, http.MethodDelete, orderPath(id)+"/tags/"+url.PathEscape(tag), nil, nil, nil, &order); err != nil {
+        return nil, err
+    }
+    return &order, nil
+}
+
+func orderPath(id uuid.UUID) string {
+    return "/orders/" + id.String()
+}
+
+func ifMatch(version int64) http.Header {
+    return http.Header{"If-Match": {strconv.FormatInt(version, 10)}}
+}
+
+func (o ListOptions) query() url.Values {
+    q := url.Values{}
+    if o.Limit > 0 {
+        q.Set("limit", strconv.Itoa(o.Limit))
+    }
+    if o.Offset > 0 {
+        q.Set("offset", strconv.Itoa(o.Offset))
+    }
+    if o.IncludeDeleted {
+        q.Set("include_deleted", "true")
+    }
+    if o.Status != "" {
+        q.Set("status", o.Status)
+    }
+    for _, tag := range o.Tags {
+        q.Add("tag", tag)
+    }
+    if o.UpdatedSince != nil {
+        q.Set("updated_since", o.UpdatedSince.Format(time.RFC3339Nano))
+        if o.AfterID != uuid.Nil {
+            q.Set("after_id", o.AfterID.String())
+        }
+    }
+    if len(o.Fields) > 0 {
+        q.Set("fields", strings.Join(o.Fields, ","))
+    }
+    if o.Display {
+        q.Set("format", "display")
+    }
+    return q
+}
+
+// do sends a request with body, if any, as JSON and decodes a successful
+// response into out. An error response is returned as an *Error.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, header http.Header, body, out interface{}) error {
+    var reqBody io.Reader
+    if body != nil {
+        b, err := json.Marshal(body)
+        if err != nil {
+            return err
+        }
+        reqBody = bytes.NewReader(b)
+    }
+    u := c.BaseURL + path
+    if len(query) > 0 {
+        u += "?" + query.Encode()
+    }
+    req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+    if err != nil {
+        return err
+    }
+    for name, values := range header {
+        req.Header[name] = values
+    }
+    req.Header.Set("Accept", "application/json")
+    if body != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    if c.APIKey != "" {
+        req.Header.Set("Authorization", "Bearer "+c.APIKey)
+    }
+
+    resp, err := c.HTTPClient.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode >= 300 {
+        return decodeError(resp)
+    }
+    if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+        return fmt.Errorf("decoding %s %s response: %w", method, path, err)
+    }
+    return nil
+}
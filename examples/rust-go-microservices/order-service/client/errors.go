@@ -0,0 +1,91 @@
+package client
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+)
+
+// Error codes the service returns, for branching on Error.Code.
+const (
+    CodeInvalidRequest          = "INVALID_REQUEST"
+    CodeRequestTooLarge         = "REQUEST_TOO_LARGE"
+    CodeInvalidOrderID          = "INVALID_ORDER_ID"
+    CodeValidationFailed        = "VALIDATION_FAILED"
+    CodeOrderNotFound           = "ORDER_NOT_FOUND"
+    CodeItemNotFound            = "ITEM_NOT_FOUND"
+    CodeInvalidStatusTransition = "INVALID_STATUS_TRANSITION"
+    CodeVersionConflict         = "VERSION_CONFLICT"
+    CodeOrderIDInUse            = "ORDER_ID_IN_USE"
+    CodePreconditionRequired    = "PRECONDITION_REQUIRED"
+    CodeTotalMismatch           = "TOTAL_MISMATCH"
+    CodeInvalidCoupon           = "INVALID_COUPON"
+    CodeOutOfStock              = "OUT_OF_STOCK"
+    CodeInventoryUnavailable    = "INVENTORY_UNAVAILABLE"
+    CodePaymentUnavailable      = "PAYMENT_UNAVAILABLE"
+    CodeIdempotencyUnavailable  = "IDEMPOTENCY_UNAVAILABLE"
+    CodePaymentFailed           = "PAYMENT_FAILED"
+    CodeFraudDeclined           = "FRAUD_DECLINED"
+    CodeExchangeRateUnavailable = "EXCHANGE_RATE_UNAVAILABLE"
+    CodeRefundFailed            = "REFUND_FAILED"
+    CodeRefundExceedsBalance    = "REFUND_EXCEEDS_BALANCE"
+    CodeRateLimited             = "RATE_LIMITED"
+    CodeOverloaded              = "OVERLOADED"
+    CodeUnauthorized            = "UNAUTHORIZED"
+    CodeForbidden               = "FORBIDDEN"
+    CodeTimeout                 = "TIMEOUT"
+    CodeInternal                = "INTERNAL_ERROR"
+)
+
+// Error is an error response from the service.
+type Error struct {
+    // StatusCode is the HTTP status of the response.
+    StatusCode int
+    Code       string
+    Message    string
+    // Details holds the code-specific details, such as the field errors of
+    // CodeValidationFailed, undecoded.
+    Details json.RawMessage
+}
+
+func (e *Error) Error() string {
+    if e.Code == "" {
+        return fmt.Sprintf("order service: status %d: %s", e.StatusCode, e.Message)
+    }
+    return fmt.Sprintf("order service: %s: %s", e.Code, e.Message)
+}
+
+// IsCode reports whether err is an *Error with the given code.
+func IsCode(err error, code string) bool {
+    var e *Error
+    return errors.As(err, &e) && e.Code == code
+}
+
+// decodeError reads an error response. A body that isn't the service's
+// error envelope, say from a proxy in between, becomes the message.
+func decodeError(resp *http.Response) error {
+    body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+    var envelope struct {
+        Error struct {
+            Code    string          `json:"code"`
+            Message string          `json:"message"`
+            Details json.RawMessage `json:"details"`
+        } `json:"error"`
+    }
+    if err := json.Unmarshal(body, &envelope); err != nil || envelope.Error.Code == "" {
+        msg := strings.TrimSpace(string(body))
+        if msg == "" {
+            msg = http.StatusText(resp.StatusCode)
+        }
+        return &Error{StatusCode: resp.StatusCode, Message: msg}
+    }
+    return &Error{
+        StatusCode: resp.StatusCode,
+        Code:       envelope.Error.Code,
+        Message:    envelope.Error.Message,
+        Details:    envelope.Error.Details,
+    }
+}
@@ -0,0 +1,24 @@
+package client
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestErrorWithoutEnvelope(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        http.Error(w, "upstream unavailable", http.StatusBadGateway)
+    }))
+    defer srv.Close()
+
+    _, err := New(srv.URL).ListOrders(context.Background(), ListOptions{})
+    e, ok := err.(*Error)
+    if !ok || e.StatusCode != http.StatusBadGateway || e.Code != "" || e.Message != "upstream unavailable" {
+        t.Fatalf("got %#v", err)
+    }
+    if IsCode(err, CodeInternal) {
+        t.Fatal("IsCode matched an error without a code")
+    }
+}
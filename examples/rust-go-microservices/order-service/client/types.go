@@ -0,0 +1,333 @@
+package client
+
+import (
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/shopspring/decimal"
+)
+
+// Order statuses.
+const (
+    StatusPending          = "pending"
+    StatusConfirmed        = "confirmed"
+    StatusPaymentFailed    = "payment_failed"
+    StatusCancelled        = "cancelled"
+    StatusPartiallyShipped = "partially_shipped"
+    StatusShipped          = "shipped"
+    StatusRefunded         = "refunded"
+    StatusExpired          = "expired"
+    // StatusPaymentMismatch means the payment was approved for a different
+    // amount than the order's; the order needs manual review.
+    StatusPaymentMismatch = "payment_mismatch"
+    // StatusScheduled means the order waits for its ScheduledFor time to be
+    // charged.
+    StatusScheduled = "scheduled"
+    // StatusFraudReview means the fraud check held the order for review
+    // before it is charged.
+    StatusFraudReview = "fraud_review"
+)
+
+// Line item statuses. The order's status follows from its items' once it
+// has been paid for.
+const (
+    ItemPending          = "pending"
+    ItemBackordered      = "backordered"
+    ItemPartiallyShipped = "partially_shipped"
+    ItemShipped          = "shipped"
+    ItemRefunded         = "refunded"
+)
+
+// Order is an order as the service returns it.
+type Order struct {
+    OrderID         uuid.UUID         `json:"order_id"`
+    CustomerID      string            `json:"customer_id"`
+    Items           []OrderItem       `json:"items"`
+    Currency        string            `json:"currency"`
+    Destination     string            `json:"destination,omitempty"`
+    ShippingAddress *Address          `json:"shipping_address,omitempty"`
+    BillingAddress  *Address          `json:"billing_address,omitempty"`
+    Discount        *Discount         `json:"discount,omitempty"`
+    Coupon          *AppliedCoupon    `json:"coupon,omitempty"`
+    Metadata        map[string]string `json:"metadata,omitempty"`
+    Notes           string            `json:"notes,omitempty"`
+    Tags            []string          `json:"tags,omitempty"`
+    TagHistory      []TagChange       `json:"tag_history,omitempty"`
+    Amendments      []Amendment       `json:"amendments,omitempty"`
+    Warnings        []Warning         `json:"warnings,omitempty"`
+    Fraud           *FraudAssessment  `json:"fraud,omitempty"`
+    Channel         string            `json:"channel,omitempty"`
+    PaymentMethod   *PaymentMethod    `json:"payment_method,omitempty"`
+    PaymentSplits   []PaymentSplit    `json:"payment_splits,omitempty"`
+    Subtotal        decimal.Decimal   `json:"subtotal"`
+    Tax             decimal.Decimal   `json:"tax"`
+    Shipping        decimal.Decimal   `json:"shipping"`
+    TotalAmount     decimal.Decimal   `json:"total_amount"`
+    // FormattedTotal is only sent when asked for with Display.
+    FormattedTotal string          `json:"formatted_total,omitempty"`
+    Settlement     *Settlement     `json:"settlement,omitempty"`
+    RefundedAmount decimal.Decimal `json:"refunded_amount"`
+    Refunds        []Refund        `json:"refunds,omitempty"`
+    Status         string          `json:"status"`
+    Shipments      []Shipment      `json:"shipments,omitempty"`
+    StatusHistory  []StatusChange  `json:"status_history,omitempty"`
+    // Version is sent back as If-Match by the methods that change an order.
+    Version   int64      `json:"version"`
+    CreatedAt time.Time  `json:"created_at"`
+    UpdatedAt time.Time  `json:"updated_at"`
+    ExpiresAt *time.Time `json:"expires_at,omitempty"`
+    // ScheduledFor, if set and in the future, has the order charged at that
+    // time rather than when it is placed.
+    ScheduledFor *time.Time `json:"scheduled_for,omitempty"`
+    DeletedAt    *time.Time `json:"deleted_at,omitempty"`
+}
+
+type OrderItem struct {
+    ProductID string          `json:"product_id"`
+    Quantity  int             `json:"quantity"`
+    Price     decimal.Decimal `json:"price"`
+    Currency  string          `json:"currency,omitempty"`
+    Discount  *Discount       `json:"discount,omitempty"`
+    // Status and RefundedQuantity are set by the service; see ItemPending.
+    Status           string `json:"status,omitempty"`
+    RefundedQuantity int    `json:"refunded_quantity,omitempty"`
+}
+
+// Address is a postal address; Country is an ISO 3166-1 alpha-2 code.
+type Address struct {
+    Name       string `json:"name"`
+    Line1      string `json:"line1"`
+    Line2      string `json:"line2,omitempty"`
+    City       string `json:"city"`
+    Region     string `json:"region,omitempty"`
+    PostalCode string `json:"postal_code,omitempty"`
+    Country    string `json:"country"`
+}
+
+// Discount is a "percentage" or "fixed" reduction.
+type Discount struct {
+    Type  string          `json:"type"`
+    Value decimal.Decimal `json:"value"`
+}
+
+// AppliedCoupon is the coupon an order was placed with.
+type AppliedCoupon struct {
+    Code     string   `json:"code"`
+    Discount Discount `json:"discount"`
+}
+
+// Reasons an INVALID_COUPON error gives in its details.
+const (
+    CouponNotFound      = "not_found"
+    CouponExpired       = "expired"
+    CouponExhausted     = "exhausted"
+    CouponNotApplicable = "not_applicable"
+)
+
+// PaymentMethod is how an order is paid; Type picks which details apply.
+type PaymentMethod struct {
+    Type         string               `json:"type"`
+    Card         *CardDetails         `json:"card,omitempty"`
+    Wallet       *WalletDetails       `json:"wallet,omitempty"`
+    BankTransfer *BankTransferDetails `json:"bank_transfer,omitempty"`
+}
+
+type CardDetails struct {
+    Brand    string `json:"brand"`
+    Last4    string `json:"last4"`
+    ExpMonth int    `json:"exp_month"`
+    ExpYear  int    `json:"exp_year"`
+}
+
+type WalletDetails struct {
+    Provider string `json:"provider"`
+}
+
+type BankTransferDetails struct {
+    AccountHolder string `json:"account_holder"`
+    AccountLast4  string `json:"account_last4"`
+}
+
+// PaymentSplit is one part of an order's total paid with its own payment
+// method. Requests set Amount and PaymentMethod; the service fills in the
+// rest once it has charged the part.
+type PaymentSplit struct {
+    Amount        decimal.Decimal `json:"amount"`
+    PaymentMethod *PaymentMethod  `json:"payment_method,omitempty"`
+    PaymentID     *uuid.UUID      `json:"payment_id,omitempty"`
+    // Status is one of the Split constants.
+    Status string `json:"status,omitempty"`
+}
+
+// Statuses of a charged PaymentSplit.
+const (
+    SplitApproved     = "approved"
+    SplitPending      = "pending"
+    SplitDeclined     = "declined"
+    SplitFailed       = "failed"
+    SplitRefunded     = "refunded"
+    SplitRefundFailed = "refund_failed"
+)
+
+// Settlement is what an order was charged in the payment processor's
+// currency, when that differs from the order's.
+type Settlement struct {
+    Amount   decimal.Decimal `json:"amount"`
+    Currency string          `json:"currency"`
+    Rate     decimal.Decimal `json:"rate"`
+}
+
+type Shipment struct {
+    ShipmentID     uuid.UUID      `json:"shipment_id"`
+    Items          []ShipmentItem `json:"items"`
+    TrackingNumber string         `json:"tracking_number,omitempty"`
+    ShippedAt      time.Time      `json:"shipped_at"`
+}
+
+type ShipmentItem struct {
+    ProductID string `json:"product_id"`
+    Quantity  int    `json:"quantity"`
+}
+
+type StatusChange struct {
+    From   string    `json:"from,omitempty"`
+    To     string    `json:"to"`
+    At     time.Time `json:"at"`
+    Reason string    `json:"reason,omitempty"`
+    Actor  string    `json:"actor,omitempty"`
+}
+
+type Refund struct {
+    RefundID uuid.UUID       `json:"refund_id"`
+    Amount   decimal.Decimal `json:"amount"`
+    Items    []RefundItem    `json:"items,omitempty"`
+    // Parts are set for a split order, one for each payment the refund
+    // was spread across.
+    Parts      []RefundPart `json:"parts,omitempty"`
+    RefundedAt time.Time    `json:"refunded_at"`
+}
+
+// RefundPart is the share of a Refund given back to one payment split.
+type RefundPart struct {
+    PaymentID uuid.UUID       `json:"payment_id"`
+    RefundID  uuid.UUID       `json:"refund_id"`
+    Amount    decimal.Decimal `json:"amount"`
+}
+
+// RefundItem is a number of units of a product to refund.
+type RefundItem struct {
+    ProductID string `json:"product_id"`
+    Quantity  int    `json:"quantity"`
+}
+
+// TagChange records a tag being "added" to or "removed" from an order.
+type TagChange struct {
+    Tag    string    `json:"tag"`
+    Action string    `json:"action"`
+    At     time.Time `json:"at"`
+}
+
+// Amendment records what one edit of an order changed.
+type Amendment struct {
+    At          time.Time       `json:"at"`
+    Added       []OrderItem     `json:"added,omitempty"`
+    Removed     []OrderItem     `json:"removed,omitempty"`
+    Changed     []ItemChange    `json:"changed,omitempty"`
+    TotalBefore decimal.Decimal `json:"total_before"`
+    TotalAfter  decimal.Decimal `json:"total_after"`
+    // Fields names the other fields that changed, such as "notes".
+    Fields []string `json:"fields,omitempty"`
+}
+
+// ItemChange is a line item as it was before and after an amendment.
+type ItemChange struct {
+    ProductID string    `json:"product_id"`
+    Before    OrderItem `json:"before"`
+    After     OrderItem `json:"after"`
+}
+
+// Warning flags something unusual about an order, such as code
+// "HIGH_QUANTITY", without stopping it being placed.
+type Warning struct {
+    Code    string `json:"code"`
+    Field   string `json:"field,omitempty"`
+    Message string `json:"message"`
+}
+
+// FraudAssessment is the fraud check's score for an order and its decision:
+// "approve", "review" or "decline".
+type FraudAssessment struct {
+    Score    float64 `json:"score"`
+    Decision string  `json:"decision"`
+}
+
+// CreateOrderRequest is the body of CreateOrder.
+type CreateOrderRequest struct {
+    // OrderID, if set, is the ID to create the order with. Sending the same
+    // request again returns the order already created with it.
+    OrderID         *uuid.UUID  `json:"order_id,omitempty"`
+    CustomerID      string      `json:"customer_id"`
+    Items           []OrderItem `json:"items"`
+    Currency        string      `json:"currency,omitempty"`
+    Destination     string      `json:"destination,omitempty"`
+    ShippingAddress *Address    `json:"shipping_address,omitempty"`
+    BillingAddress  *Address    `json:"billing_address,omitempty"`
+    // SameAsShipping makes the billing address a copy of the shipping one.
+    SameAsShipping bool      `json:"same_as_shipping,omitempty"`
+    Discount       *Discount `json:"discount,omitempty"`
+    // CouponCode, if set, must be a valid coupon; its discount becomes the
+    // order discount.
+    CouponCode string            `json:"coupon_code,omitempty"`
+    Metadata   map[string]string `json:"metadata,omitempty"`
+    Notes      string            `json:"notes,omitempty"`
+    // Channel is the front-end placing the order, one the service is
+    // configured to accept; it picks defaults such as the currency.
+    Channel       string         `json:"channel,omitempty"`
+    PaymentMethod *PaymentMethod `json:"payment_method,omitempty"`
+    // PaymentSplits, instead of PaymentMethod, pays the order in parts
+    // that must add up to its total exactly. It is confirmed only if every
+    // part is approved; parts already charged are refunded otherwise.
+    PaymentSplits []PaymentSplit `json:"payment_splits,omitempty"`
+    // ExpectedTotal, if set, makes the service reject the order unless its
+    // computed total matches.
+    ExpectedTotal *decimal.Decimal `json:"expected_total,omitempty"`
+    // ScheduledFor, if set and in the future, has the order stored as
+    // scheduled and charged at that time.
+    ScheduledFor *time.Time `json:"scheduled_for,omitempty"`
+
+    // IdempotencyKey, if set, is sent as the Idempotency-Key header so a
+    // retried create returns the first order instead of placing another.
+    IdempotencyKey string `json:"-"`
+}
+
+// OrderList is one page of orders.
+type OrderList struct {
+    Orders []*Order `json:"orders"`
+    Total  int      `json:"total"`
+    Limit  int      `json:"limit"`
+    Offset int      `json:"offset"`
+}
+
+// ListOptions page and filter a list of orders. Zero values take the
+// service's defaults.
+type ListOptions struct {
+    Limit          int
+    Offset         int
+    IncludeDeleted bool
+    // Status is only honored by ListCustomerOrders.
+    Status string
+    // Tags, only honored by ListOrders, lists just the orders carrying
+    // every one of them.
+    Tags []string
+    // UpdatedSince, only honored by ListOrders, lists just the orders
+    // saved after it, least recently updated first. To page through them,
+    // send the last order's UpdatedAt and OrderID as UpdatedSince and
+    // AfterID.
+    UpdatedSince *time.Time
+    AfterID      uuid.UUID
+    // Fields, if set, has each order come back with only these fields, such
+    // as "order_id" or "items.product_id"; the rest are left zero.
+    Fields []string
+    // Display, if set, has each order come back with a FormattedTotal.
+    Display bool
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "net/http/httptest"
+    "testing"
+
+    "order-service/client"
+
+    "github.com/google/uuid"
+    "github.com/shopspring/decimal"
+)
+
+// newTestClient serves the real router and returns a client pointed at it.
+func newTestClient(t *testing.T) *client.Client {
+    t.Helper()
+
+    srv := httptest.NewServer(setupRouter())
+    t.Cleanup(srv.Close)
+    return client.New(srv.URL)
+}
+
+func sampleCreateRequest() client.CreateOrderRequest {
+    return client.CreateOrderRequest{
+        CustomerID: "cust_123",
+        Items:      []client.OrderItem{{ProductID: "prod_456", Quantity: 2, Price: decimal.RequireFromString("29.99")}},
+    }
+}
+
+func TestClientOrderLifecycle(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    c := newTestClient(t)
+    ctx := context.Background()
+
+    created, err := c.CreateOrder(ctx, sampleCreateRequest())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if created.Status != client.StatusConfirmed || created.TotalAmount.String() != "59.98" {
+        t.Fatalf("created order %q with total %s", created.Status, created.TotalAmount)
+    }
+
+    got, err := c.GetOrder(ctx, created.OrderID)
+    if err != nil || got.OrderID != created.OrderID || got.Version != created.Version {
+        t.Fatalf("GetOrder: got %+v, %v", got, err)
+    }
+
+    list, err := c.ListOrders(ctx, client.ListOptions{Limit: 10})
+    if err != nil || list.Total != 1 || len(list.Orders) != 1 || list.Limit != 10 {
+        t.Fatalf("ListOrders: got %+v, %v", list, err)
+    }
+    list, err = c.ListCustomerOrders(ctx, "cust_123", client.ListOptions{Status: client.StatusCancelled})
+    if err != nil || list.Total != 0 {
+        t.Fatalf("ListCustomerOrders: got %+v, %v", list, err)
+    }
+
+    cancelled, err := c.CancelOrder(ctx, created.OrderID, got.Version)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if cancelled.Status != client.StatusCancelled || !cancelled.RefundedAmount.Equal(created.TotalAmount) {
+        t.Fatalf("cancelled order %q refunded %s", cancelled.Status, cancelled.RefundedAmount)
+    }
+}
+
+func TestClientIdempotentCreate(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    c := newTestClient(t)
+
+    req := sampleCreateRequest()
+    req.IdempotencyKey = "client-key-1"
+    first, err := c.CreateOrder(context.Background(), req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    second, err := c.CreateOrder(context.Background(), req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if first.OrderID != second.OrderID || fake.charges.Load() != 1 {
+        t.Fatalf("got orders %s and %s after %d charges", first.OrderID, second.OrderID, fake.charges.Load())
+    }
+}
+
+func TestClientDecodesAPIErrors(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    c := newTestClient(t)
+    ctx := context.Background()
+
+    _, err := c.GetOrder(ctx, uuid.New())
+    var apiErr *client.Error
+    if !errors.As(err, &apiErr) || apiErr.StatusCode != 404 || apiErr.Code != client.CodeOrderNotFound {
+        t.Fatalf("GetOrder of a missing order: got %v", err)
+    }
+
+    _, err = c.CreateOrder(ctx, client.CreateOrderRequest{CustomerID: "cust_123"})
+    if !client.IsCode(err, client.CodeValidationFailed) || len(err.(*client.Error).Details) == 0 {
+        t.Fatalf("CreateOrder without items: got %v", err)
+    }
+
+    order := saveOrderWithStatus(StatusPending)
+    if _, err := c.CancelOrder(ctx, order.OrderID, order.Version+1); !client.IsCode(err, client.CodeVersionConflict) {
+        t.Fatalf("CancelOrder with a stale version: got %v", err)
+    }
+}
+
+func TestClientSendsAPIKey(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    useAPIKeys(t, "admin-key")
+    c := newTestClient(t)
+
+    if _, err := c.ListOrders(context.Background(), client.ListOptions{}); !client.IsCode(err, client.CodeUnauthorized) {
+        t.Fatalf("without a key: got %v", err)
+    }
+    c.APIKey = "admin-key"
+    if _, err := c.ListOrders(context.Background(), client.ListOptions{}); err != nil {
+        t.Fatalf("with a key: got %v", err)
+    }
+}
+
+func TestClientHonorsContext(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    c := newTestClient(t)
+
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    if _, err := c.ListOrders(ctx, client.ListOptions{}); !errors.Is(err, context.Canceled) {
+        t.Fatalf("got %v, want context.Canceled", err)
+    }
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "net/http"
+
+    "github.com/google/uuid"
+)
+
+// checkClientOrderID rejects an order body whose order_id, which a client
+// may send to pick the new order's ID itself, isn't a UUID. A body without
+// one gets an ID from the service.
+func checkClientOrderID(body []byte) *requestError {
+    var fields struct {
+        OrderID json.RawMessage `json:"order_id"`
+    }
+    if json.Unmarshal(body, &fields) != nil || len(fields.OrderID) == 0 || string(fields.OrderID) == "null" {
+        return nil
+    }
+    var raw string
+    if json.Unmarshal(fields.OrderID, &raw) == nil {
+        if id, err := uuid.Parse(raw); err == nil && id != uuid.Nil {
+            return nil
+        }
+    }
+    verr := &ValidationError{}
+    verr.add("order_id", "must be a UUID")
+    return validationFailed(verr)
+}
+
+// requestFingerprint hashes an order body, ignoring spacing and key order,
+// to tell a client resending an order from one reusing its ID for another.
+func requestFingerprint(body []byte) string {
+    dec := json.NewDecoder(bytes.NewReader(body))
+    dec.UseNumber()
+    var v interface{}
+    if dec.Decode(&v) == nil {
+        if canonical, err := json.Marshal(v); err == nil {
+            body = canonical
+        }
+    }
+    sum := sha256.Sum256(body)
+    return hex.EncodeToString(sum[:])
+}
+
+// resubmittedOrder looks for the order a client-supplied ID already names.
+// It returns nil if the ID is free or the service is to pick one, and the
+// existing order if it was created from the same body, so a client retrying
+// gets it back rather than a duplicate. Any other use of the ID is a
+// conflict.
+func resubmittedOrder(ctx context.Context, order *Order) (*Order, *requestError) {
+    if order.OrderID == uuid.Nil {
+        return nil, nil
+    }
+    existing, err := orders.FindByID(order.OrderID)
+    if errors.Is(err, ErrOrderNotFound) {
+        return nil, nil
+    }
+    if err != nil {
+        loggerFrom(ctx).Error("failed to look up client order ID", "order_id", order.OrderID, "error", err)
+        return nil, newRequestError(http.StatusInternalServerError, CodeInternal, "Failed to load order")
+    }
+    if existing.DeletedAt != nil || existing.RequestHash == "" || existing.RequestHash != order.RequestHash {
+        return nil, errOrderIDInUse()
+    }
+    return existing, nil
+}
+
+func errOrderIDInUse() *requestError {
+    return newRequestError(http.StatusConflict, CodeOrderIDInUse, "Order ID is already in use by a different order")
+}
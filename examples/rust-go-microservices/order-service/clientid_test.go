@@ -0,0 +1,144 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "path/filepath"
+    "testing"
+
+    "github.com/google/uuid"
+)
+
+const clientOrderID = "7a1f3c2e-5b4d-4e8f-9a6b-1c2d3e4f5a6b"
+
+func clientIDOrder(customerID string) string {
+    return `{"order_id":"` + clientOrderID + `","customer_id":"` + customerID + `","items":[{"product_id":"prod_456","quantity":2,"price":"29.99"}]}`
+}
+
+func TestCreateOrderWithClientID(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+
+    w := doRequest(setupRouter(), http.MethodPost, "/orders", clientIDOrder("cust_123"))
+    if w.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if order.OrderID.String() != clientOrderID {
+        t.Fatalf("created %s, want the client's ID %s", order.OrderID, clientOrderID)
+    }
+    if _, err := orders.FindByID(uuid.MustParse(clientOrderID)); err != nil {
+        t.Fatalf("order not stored under the client's ID: %v", err)
+    }
+
+    // Without one, the service picks the ID as before.
+    w = doRequest(setupRouter(), http.MethodPost, "/orders", sampleOrder)
+    var generated Order
+    json.Unmarshal(w.Body.Bytes(), &generated)
+    if w.Code != http.StatusCreated || generated.OrderID == uuid.Nil || generated.OrderID == order.OrderID {
+        t.Fatalf("got status %d with ID %s, want a new server-generated ID", w.Code, generated.OrderID)
+    }
+}
+
+func TestDuplicateClientIDReturnsExistingOrder(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    first := doRequest(r, http.MethodPost, "/orders", clientIDOrder("cust_123"))
+    if first.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", first.Code, first.Body)
+    }
+    var created Order
+    json.Unmarshal(first.Body.Bytes(), &created)
+
+    // The same order, spaced and ordered differently, is a retry.
+    resent := `{ "customer_id": "cust_123", "order_id": "` + clientOrderID + `",
+        "items": [{"price": "29.99", "quantity": 2, "product_id": "prod_456"}] }`
+    w := doRequest(r, http.MethodPost, "/orders", resent)
+    var again Order
+    json.Unmarshal(w.Body.Bytes(), &again)
+    if w.Code != http.StatusOK || again.OrderID != created.OrderID || again.Version != created.Version {
+        t.Fatalf("got status %d: %s; want 200 with the existing order", w.Code, w.Body)
+    }
+    if n := fake.charges.Load(); n != 1 {
+        t.Fatalf("charged %d times, want once", n)
+    }
+
+    // A different order can't take the ID.
+    w = doRequest(r, http.MethodPost, "/orders", clientIDOrder("cust_999"))
+    if w.Code != http.StatusConflict || decodeError(t, w).Code != CodeOrderIDInUse {
+        t.Fatalf("got status %d: %s; want 409 %s", w.Code, w.Body, CodeOrderIDInUse)
+    }
+    if n := fake.charges.Load(); n != 1 {
+        t.Fatalf("charged %d times, want once", n)
+    }
+}
+
+func TestServerGeneratedIDCannotBeReused(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    var order Order
+    json.Unmarshal(doRequest(r, http.MethodPost, "/orders", sampleOrder).Body.Bytes(), &order)
+    body := `{"order_id":"` + order.OrderID.String() + `","customer_id":"cust_123","items":[{"product_id":"prod_456","quantity":2,"price":"29.99"}]}`
+    if w := doRequest(r, http.MethodPost, "/orders", body); w.Code != http.StatusConflict {
+        t.Fatalf("got status %d: %s; want 409", w.Code, w.Body)
+    }
+}
+
+func TestMalformedClientIDRejected(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    for _, id := range []string{`"not-a-uuid"`, `""`, `"00000000-0000-0000-0000-000000000000"`, `42`} {
+        body := `{"order_id":` + id + `,"customer_id":"cust_123","items":[{"product_id":"prod_456","quantity":1,"price":"1.00"}]}`
+        w := doRequest(r, http.MethodPost, "/orders", body)
+        verr := decodeValidationError(w)
+        if w.Code != http.StatusUnprocessableEntity || len(verr.Fields) != 1 || verr.Fields[0].Field != "order_id" {
+            t.Fatalf("order_id %s: got status %d: %s; want 422 about order_id", id, w.Code, w.Body)
+        }
+    }
+    if stored, _ := orders.List(); len(stored) != 0 {
+        t.Fatalf("stored %d orders, want none", len(stored))
+    }
+}
+
+func TestBatchHonoursClientIDs(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    body := `[` + clientIDOrder("cust_123") + `,` + clientIDOrder("cust_123") + `]`
+    var resp BatchResponse
+    json.Unmarshal(doRequest(r, http.MethodPost, "/orders/batch", body).Body.Bytes(), &resp)
+    statuses := map[int]int{}
+    for _, result := range resp.Results {
+        statuses[result.Status]++
+    }
+    // The batch runs its orders concurrently, so the second may find the
+    // first stored or collide with it mid-flight; either way there is one
+    // order.
+    if statuses[http.StatusCreated] != 1 || statuses[http.StatusOK]+statuses[http.StatusConflict] != 1 {
+        t.Fatalf("got results %+v, want one created and the other a retry", resp.Results)
+    }
+    if stored, _ := orders.List(); len(stored) != 1 {
+        t.Fatalf("stored %d orders, want one", len(stored))
+    }
+}
+
+func TestSQLiteKeepsRequestHash(t *testing.T) {
+    repo := openTestSQLite(t, filepath.Join(t.TempDir(), "orders.db"))
+    order := newOutboxOrder()
+    order.RequestHash = requestFingerprint([]byte(clientIDOrder("cust_123")))
+    if err := repo.Save(order); err != nil {
+        t.Fatal(err)
+    }
+    got, err := repo.FindByID(order.OrderID)
+    if err != nil || got.RequestHash != order.RequestHash {
+        t.Fatalf("got hash %q, %v; want %q", got.RequestHash, err, order.RequestHash)
+    }
+}
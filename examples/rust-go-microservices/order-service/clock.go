@@ -0,0 +1,24 @@
+package main
+
+import "time"
+
+// Clock tells the time. The service reads the time of day from one, so
+// tests can pin it; durations it measures, such as request latency, still
+// come from the real clock.
+type Clock interface {
+    Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// clock is the service's Clock; NewServer installs Config.Clock there.
+var clock Clock = systemClock{}
+
+// currentTime reads clock. The jobs and stores with a now func of their own
+// default to it, so they follow the clock installed when they run rather
+// than the one they were built with.
+func currentTime() time.Time {
+    return clock.Now()
+}
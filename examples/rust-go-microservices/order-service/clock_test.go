@@ -0,0 +1,90 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "sync"
+    "testing"
+    "time"
+)
+
+// fakeClock is a Clock that stands still until told to move.
+type fakeClock struct {
+    mu  sync.Mutex
+    now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    c.now = c.now.Add(d)
+}
+
+// useClock installs a fakeClock pinned at now for the rest of the test.
+func useClock(t *testing.T, now time.Time) *fakeClock {
+    t.Helper()
+
+    fake := &fakeClock{now: now}
+    prev := clock
+    clock = fake
+    t.Cleanup(func() { clock = prev })
+    return fake
+}
+
+func TestPinnedClockStampsNewOrders(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    now := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+    useClock(t, now)
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", sampleOrder)
+    if w.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if !order.CreatedAt.Equal(now) {
+        t.Fatalf("created at %v, want %v", order.CreatedAt, now)
+    }
+    if order.ExpiresAt == nil || !order.ExpiresAt.Equal(now.Add(pendingOrderTTL)) {
+        t.Fatalf("expires at %v, want %v", order.ExpiresAt, now.Add(pendingOrderTTL))
+    }
+    for _, change := range order.StatusHistory {
+        if !change.At.Equal(now) {
+            t.Fatalf("change to %s at %v, want %v", change.To, change.At, now)
+        }
+    }
+}
+
+func TestSweeperFollowsInstalledClock(t *testing.T) {
+    resetOrders(t)
+    fake := useClock(t, time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC))
+    order := saveOrderWithStatus(StatusPending)
+    expiresAt := fake.Now().Add(time.Minute)
+    order.ExpiresAt = &expiresAt
+    orders.Save(order)
+    sweeper := NewExpirySweeper(time.Minute)
+
+    if n, _ := sweeper.sweepOnce(context.Background()); n != 0 {
+        t.Fatalf("expired %d orders before their time", n)
+    }
+    fake.Advance(2 * time.Minute)
+    if n, _ := sweeper.sweepOnce(context.Background()); n != 1 {
+        t.Fatalf("expired %d orders, want 1", n)
+    }
+    stored, _ := orders.FindByID(order.OrderID)
+    last := stored.StatusHistory[len(stored.StatusHistory)-1]
+    if stored.Status != StatusExpired || !last.At.Equal(fake.Now()) {
+        t.Fatalf("got status %s changed at %v, want expired at %v", stored.Status, last.At, fake.Now())
+    }
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+    "fmt"
+    "net/url"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/shopspring/decimal"
+)
+
+// envDuration reads a time.ParseDuration value from the environment, falling
+// back to def when the variable is unset.
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+    v := os.Getenv(name)
+    if v == "" {
+        return def, nil
+    }
+    d, err := time.ParseDuration(v)
+    if err != nil || d <= 0 {
+        return 0, fmt.Errorf("%s must be a positive duration, got %q", name, v)
+    }
+    return d, nil
+}
+
+// envInt reads a non-negative integer from the environment, falling back to
+// def when the variable is unset.
+func envInt(name string, def int) (int, error) {
+    v := os.Getenv(name)
+    if v == "" {
+        return def, nil
+    }
+    n, err := strconv.Atoi(v)
+    if err != nil || n < 0 {
+        return 0, fmt.Errorf("%s must be a non-negative integer, got %q", name, v)
+    }
+    return n, nil
+}
+
+// envBool reads a strconv.ParseBool value from the environment, false when
+// the variable is unset.
+func envBool(name string) (bool, error) {
+    v := os.Getenv(name)
+    if v == "" {
+        return false, nil
+    }
+    b, err := strconv.ParseBool(v)
+    if err != nil {
+        return false, fmt.Errorf("%s must be a boolean, got %q", name, v)
+    }
+    return b, nil
+}
+
+// envDecimal reads a non-negative decimal from the environment, zero if it
+// is unset.
+func envDecimal(name string) (decimal.Decimal, error) {
+    v := os.Getenv(name)
+    if v == "" {
+        return decimal.Zero, nil
+    }
+    d, err := decimal.NewFromString(v)
+    if err != nil || d.IsNegative() {
+        return decimal.Zero, fmt.Errorf("%s must be a non-negative decimal, got %q", name, v)
+    }
+    return d, nil
+}
+
+// parseServiceURL checks that raw is an absolute http(s) URL and returns it
+// without a trailing slash, ready to have paths appended.
+func parseServiceURL(raw string) (string, error) {
+    u, err := url.Parse(raw)
+    if err != nil {
+        return "", err
+    }
+    if u.Scheme != "http" && u.Scheme != "https" {
+        return "", fmt.Errorf("%q must use http or https", raw)
+    }
+    if u.Host == "" {
+        return "", fmt.Errorf("%q has no host", raw)
+    }
+    return strings.TrimRight(raw, "/"), nil
+}
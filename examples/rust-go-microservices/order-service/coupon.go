@@ -0,0 +1,252 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/shopspring/decimal"
+)
+
+// Reasons a coupon code is turned down, in the details of an
+// INVALID_COUPON error.
+const (
+    CouponNotFound      = "not_found"
+    CouponExpired       = "expired"
+    CouponExhausted     = "exhausted"
+    CouponNotApplicable = "not_applicable"
+)
+
+// CouponError says why a coupon code can't be used.
+type CouponError struct {
+    Code   string
+    Reason string
+    // Message explains the reason to a person, e.g. which condition the
+    // order doesn't meet.
+    Message string
+}
+
+func (e *CouponError) Error() string {
+    return fmt.Sprintf("coupon %s: %s", e.Code, e.Message)
+}
+
+// CouponValidator checks coupon codes and keeps count of their uses.
+type CouponValidator interface {
+    // Validate returns the discount code gives order, which is validated
+    // but not yet priced, or a *CouponError if it gives none.
+    Validate(ctx context.Context, code string, order *Order) (*Discount, error)
+    // Redeem records a use of code, failing with a *CouponError with
+    // reason exhausted if it has none left. Release gives the use back,
+    // for an order that wasn't paid for after all.
+    Redeem(ctx context.Context, code string) error
+    Release(ctx context.Context, code string)
+}
+
+// AppliedCoupon records the coupon an order was placed with and the
+// discount it gave.
+type AppliedCoupon struct {
+    Code     string   `json:"code"`
+    Discount Discount `json:"discount"`
+}
+
+// coupons validates the coupon codes orders are placed with.
+var coupons CouponValidator = NewCouponTable(nil)
+
+// Coupon is one code of a CouponTable. Every condition is optional.
+type Coupon struct {
+    Discount
+    // ExpiresAt is when the code stops being accepted.
+    ExpiresAt *time.Time `json:"expires_at,omitempty"`
+    // MaxUses caps how many orders may be paid for with the code.
+    MaxUses int `json:"max_uses,omitempty"`
+    // Currency restricts the code to orders in one currency. Fixed
+    // discounts are in it, so they need one.
+    Currency string `json:"currency,omitempty"`
+    // MinSubtotal is the least the items must come to, after their own
+    // discounts, for the code to apply.
+    MinSubtotal decimal.Decimal `json:"min_subtotal"`
+}
+
+// CouponTable is a CouponValidator over a fixed set of codes, with their
+// uses counted in memory.
+type CouponTable struct {
+    mu      sync.Mutex
+    coupons map[string]Coupon
+    used    map[string]int
+    now     func() time.Time
+}
+
+func NewCouponTable(coupons map[string]Coupon) *CouponTable {
+    t := &CouponTable{coupons: make(map[string]Coupon), used: make(map[string]int), now: currentTime}
+    for code, coupon := range coupons {
+        t.coupons[normalizeCouponCode(code)] = coupon
+    }
+    return t
+}
+
+// normalizeCouponCode makes codes case-insensitive.
+func normalizeCouponCode(code string) string {
+    return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (t *CouponTable) Validate(_ context.Context, code string, order *Order) (*Discount, error) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    coupon, ok := t.coupons[code]
+    if !ok {
+        return nil, &CouponError{code, CouponNotFound, "no such coupon"}
+    }
+    if coupon.ExpiresAt != nil && !t.now().Before(*coupon.ExpiresAt) {
+        return nil, &CouponError{code, CouponExpired, "expired at " + coupon.ExpiresAt.UTC().Format(time.RFC3339)}
+    }
+    if coupon.MaxUses > 0 && t.used[code] >= coupon.MaxUses {
+        return nil, &CouponError{code, CouponExhausted, "has been used as many times as it may be"}
+    }
+    if coupon.Currency != "" && coupon.Currency != order.Currency {
+        return nil, &CouponError{code, CouponNotApplicable, "only applies to orders in " + coupon.Currency}
+    }
+    total := itemsTotal(order.Items)
+    if total.LessThan(coupon.MinSubtotal) {
+        return nil, &CouponError{code, CouponNotApplicable,
+            fmt.Sprintf("only applies to orders of at least %s %s", coupon.MinSubtotal, order.Currency)}
+    }
+    if coupon.Type == DiscountFixed && coupon.Value.GreaterThan(total) {
+        return nil, &CouponError{code, CouponNotApplicable,
+            fmt.Sprintf("takes off %s, more than the order's %s", coupon.Value, total)}
+    }
+    discount := coupon.Discount
+    return &discount, nil
+}
+
+func (t *CouponTable) Redeem(_ context.Context, code string) error {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    if max := t.coupons[code].MaxUses; max > 0 && t.used[code] >= max {
+        return &CouponError{code, CouponExhausted, "has been used as many times as it may be"}
+    }
+    t.used[code]++
+    return nil
+}
+
+func (t *CouponTable) Release(_ context.Context, code string) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    if t.used[code] > 0 {
+        t.used[code]--
+    }
+}
+
+// parseCoupons reads a JSON object mapping codes to their Coupon, such as
+//
+//	{"SAVE10": {"type": "percentage", "value": "10", "expires_at": "2025-01-01T00:00:00Z", "max_uses": 100}}
+func parseCoupons(raw string) (map[string]Coupon, error) {
+    dec := json.NewDecoder(strings.NewReader(raw))
+    dec.DisallowUnknownFields()
+    var table map[string]Coupon
+    if err := dec.Decode(&table); err != nil {
+        return nil, err
+    }
+    for code, coupon := range table {
+        verr := &ValidationError{}
+        // Only the discount's own bounds can be checked without an order.
+        validateDiscount(verr, "discount", &coupon.Discount, coupon.Value)
+        if err := verr.err(); err != nil {
+            return nil, fmt.Errorf("coupon %s: %w", code, err)
+        }
+        if coupon.Currency != "" {
+            coupon.Currency = normalizeCurrency(coupon.Currency)
+            if _, ok := lookupCurrency(coupon.Currency); !ok {
+                return nil, fmt.Errorf("coupon %s: %q is not a supported currency", code, coupon.Currency)
+            }
+        }
+        if coupon.Type == DiscountFixed && coupon.Currency == "" {
+            return nil, fmt.Errorf("coupon %s: a fixed discount needs a currency", code)
+        }
+        if coupon.MaxUses < 0 || coupon.MinSubtotal.IsNegative() {
+            return nil, fmt.Errorf("coupon %s: max_uses and min_subtotal must not be negative", code)
+        }
+        table[code] = coupon
+    }
+    return table, nil
+}
+
+// couponsFromEnv reads COUPONS. Without it no code is valid.
+func couponsFromEnv() (CouponValidator, error) {
+    raw := os.Getenv("COUPONS")
+    if raw == "" {
+        return NewCouponTable(nil), nil
+    }
+    table, err := parseCoupons(raw)
+    if err != nil {
+        return nil, fmt.Errorf("COUPONS: %w", err)
+    }
+    return NewCouponTable(table), nil
+}
+
+// invalidCoupon is the 422 response for a code that can't be used.
+func invalidCoupon(cerr *CouponError) *requestError {
+    rerr := newRequestError(http.StatusUnprocessableEntity, CodeInvalidCoupon,
+        fmt.Sprintf("Coupon %s can't be used: %s", cerr.Code, cerr.Message))
+    rerr.Details = gin.H{"coupon_code": cerr.Code, "reason": cerr.Reason}
+    return rerr
+}
+
+// applyCoupon turns the order's coupon code into its order discount. A
+// coupon replaces the discount the order would otherwise have, so asking
+// for both is refused.
+func applyCoupon(ctx context.Context, order *Order) *requestError {
+    code := normalizeCouponCode(order.CouponCode)
+    order.CouponCode = ""
+    order.Coupon = nil
+    if code == "" {
+        return nil
+    }
+    if order.Discount != nil {
+        verr := &ValidationError{}
+        verr.add("coupon_code", "can't be combined with an order discount")
+        return validationFailed(verr)
+    }
+
+    discount, err := coupons.Validate(ctx, code, order)
+    var cerr *CouponError
+    if errors.As(err, &cerr) {
+        return invalidCoupon(cerr)
+    }
+    if err != nil {
+        loggerFrom(ctx).Error("coupon validation failed", "coupon_code", code, "error", err)
+        return newRequestError(http.StatusInternalServerError, CodeInternal, "Failed to validate coupon")
+    }
+    order.Discount = discount
+    order.Coupon = &AppliedCoupon{Code: code, Discount: *discount}
+    return nil
+}
+
+// redeemCoupon counts a use of the order's coupon, given back by steps'
+// rollback if the order isn't paid for.
+func redeemCoupon(ctx context.Context, order *Order, steps *saga) *requestError {
+    if order.Coupon == nil {
+        return nil
+    }
+    code := order.Coupon.Code
+    err := coupons.Redeem(ctx, code)
+    var cerr *CouponError
+    if errors.As(err, &cerr) {
+        return invalidCoupon(cerr)
+    }
+    if err != nil {
+        loggerFrom(ctx).Error("coupon redemption failed", "coupon_code", code, "error", err)
+        return newRequestError(http.StatusInternalServerError, CodeInternal, "Failed to redeem coupon")
+    }
+    steps.onRollback(func(ctx context.Context) { coupons.Release(ctx, code) })
+    return nil
+}
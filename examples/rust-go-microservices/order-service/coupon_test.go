@@ -0,0 +1,160 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+    "time"
+)
+
+// useCoupons makes the coupons in raw COUPONS form the only valid ones for
+// the rest of the test, as of now.
+func useCoupons(t *testing.T, raw string, now time.Time) *CouponTable {
+    t.Helper()
+
+    parsed, err := parseCoupons(raw)
+    if err != nil {
+        t.Fatal(err)
+    }
+    table := NewCouponTable(parsed)
+    table.now = func() time.Time { return now }
+    prev := coupons
+    coupons = table
+    t.Cleanup(func() { coupons = prev })
+    return table
+}
+
+const testCoupons = `{
+    "save10": {"type": "percentage", "value": "10"},
+    "OLD": {"type": "percentage", "value": "50", "expires_at": "2024-01-01T00:00:00Z"},
+    "ONCE": {"type": "fixed", "value": "5", "currency": "USD", "max_uses": 1},
+    "BIG": {"type": "percentage", "value": "20", "min_subtotal": "100"}
+}`
+
+func couponOrder(code string) string {
+    return `{"customer_id":"cust_123","items":[{"product_id":"prod_456","quantity":2,"price":"29.99"}],"coupon_code":"` + code + `"}`
+}
+
+func decodeCouponError(t *testing.T, body []byte) (code, reason string) {
+    t.Helper()
+
+    var resp struct {
+        Error struct {
+            Code    string `json:"code"`
+            Details struct {
+                Reason string `json:"reason"`
+            } `json:"details"`
+        } `json:"error"`
+    }
+    if err := json.Unmarshal(body, &resp); err != nil {
+        t.Fatal(err)
+    }
+    return resp.Error.Code, resp.Error.Details.Reason
+}
+
+func TestValidCouponDiscountsOrder(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    useCoupons(t, testCoupons, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", couponOrder(" Save10 "))
+    if w.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if order.TotalAmount.String() != "53.98" {
+        t.Fatalf("got total %s, want 53.98 after 10%% off 59.98", order.TotalAmount)
+    }
+    if order.Coupon == nil || order.Coupon.Code != "SAVE10" || order.Coupon.Discount.Type != DiscountPercentage {
+        t.Fatalf("got coupon %+v, want SAVE10 recorded", order.Coupon)
+    }
+    if order.CouponCode != "" {
+        t.Fatalf("coupon_code %q echoed back", order.CouponCode)
+    }
+    if got := fake.lastCharge.Load(); got == nil || got.Amount.String() != "53.98" {
+        t.Fatalf("charged %v, want the discounted total", got)
+    }
+}
+
+func TestCouponRejected(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    useCoupons(t, testCoupons, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+    r := setupRouter()
+
+    for _, tc := range []struct{ code, reason string }{
+        {"NOPE", CouponNotFound},
+        {"OLD", CouponExpired},
+        {"BIG", CouponNotApplicable},
+    } {
+        t.Run(tc.code, func(t *testing.T) {
+            w := doRequest(r, http.MethodPost, "/orders", couponOrder(tc.code))
+            if w.Code != http.StatusUnprocessableEntity {
+                t.Fatalf("got status %d, want 422: %s", w.Code, w.Body)
+            }
+            if code, reason := decodeCouponError(t, w.Body.Bytes()); code != CodeInvalidCoupon || reason != tc.reason {
+                t.Fatalf("got %s/%s, want %s/%s", code, reason, CodeInvalidCoupon, tc.reason)
+            }
+        })
+    }
+    if all, _ := orders.List(); len(all) != 0 {
+        t.Fatalf("%d orders stored", len(all))
+    }
+}
+
+func TestCouponExhaustedAfterPaidUses(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    useCoupons(t, testCoupons, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+    r := setupRouter()
+
+    // A declined payment gives its use back.
+    fake.status = "declined"
+    if w := doRequest(r, http.MethodPost, "/orders", couponOrder("ONCE")); w.Code != http.StatusCreated {
+        t.Fatalf("declined: got status %d: %s", w.Code, w.Body)
+    }
+    fake.status = ""
+    w := doRequest(r, http.MethodPost, "/orders", couponOrder("ONCE"))
+    if w.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if order.Status != StatusConfirmed || order.TotalAmount.String() != "54.98" {
+        t.Fatalf("got %s order of %s, want confirmed 54.98", order.Status, order.TotalAmount)
+    }
+
+    w = doRequest(r, http.MethodPost, "/orders", couponOrder("ONCE"))
+    if code, reason := decodeCouponError(t, w.Body.Bytes()); w.Code != http.StatusUnprocessableEntity || reason != CouponExhausted {
+        t.Fatalf("got status %d, %s/%s; want 422 exhausted", w.Code, code, reason)
+    }
+}
+
+func TestCouponCannotCombineWithDiscount(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    useCoupons(t, testCoupons, time.Now())
+    r := setupRouter()
+
+    body := `{"customer_id":"cust_123","items":[{"product_id":"prod_456","quantity":2,"price":"29.99"}],
+        "discount":{"type":"fixed","value":"1"},"coupon_code":"SAVE10"}`
+    if w := doRequest(r, http.MethodPost, "/orders", body); w.Code != http.StatusUnprocessableEntity {
+        t.Fatalf("got status %d, want 422: %s", w.Code, w.Body)
+    }
+}
+
+func TestParseCouponsRejectsBadConfig(t *testing.T) {
+    for _, raw := range []string{
+        `not json`,
+        `{"X": {"type": "percentage", "value": "150"}}`,
+        `{"X": {"type": "fixed", "value": "5"}}`,
+        `{"X": {"type": "percentage", "value": "10", "max_uses": -1}}`,
+        `{"X": {"type": "percentage", "value": "10", "colour": "red"}}`,
+    } {
+        if _, err := parseCoupons(raw); err == nil {
+            t.Errorf("parseCoupons(%s) succeeded", raw)
+        }
+    }
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+    "strings"
+
+    "github.com/shopspring/decimal"
+)
+
+const defaultCurrency = "USD"
+
+// Currency is an ISO 4217 currency and the number of digits after the
+// decimal point in its minor unit (2 for USD cents, 0 for JPY).
+type Currency struct {
+    Code       string
+    MinorUnits int32
+}
+
+// currencies is the whitelist of accepted order currencies.
+var currencies = map[string]Currency{
+    "AUD": {"AUD", 2},
+    "BHD": {"BHD", 3},
+    "BRL": {"BRL", 2},
+    "CAD": {"CAD", 2},
+    "CHF": {"CHF", 2},
+    "CNY": {"CNY", 2},
+    "DKK": {"DKK", 2},
+    "EUR": {"EUR", 2},
+    "GBP": {"GBP", 2},
+    "HKD": {"HKD", 2},
+    "INR": {"INR", 2},
+    "JPY": {"JPY", 0},
+    "KRW": {"KRW", 0},
+    "KWD": {"KWD", 3},
+    "MXN": {"MXN", 2},
+    "NOK": {"NOK", 2},
+    "NZD": {"NZD", 2},
+    "SEK": {"SEK", 2},
+    "SGD": {"SGD", 2},
+    "USD": {"USD", 2},
+    "ZAR": {"ZAR", 2},
+}
+
+func lookupCurrency(code string) (Currency, bool) {
+    c, ok := currencies[code]
+    return c, ok
+}
+
+// normalizeCurrency upper-cases a client-supplied code, defaulting to
+// defaultCurrency when it is empty.
+func normalizeCurrency(code string) string {
+    code = strings.ToUpper(strings.TrimSpace(code))
+    if code == "" {
+        return defaultCurrency
+    }
+    return code
+}
+
+// normalizeItemCurrencies normalizes the currency of every item that names
+// one.
+func normalizeItemCurrencies(items []OrderItem) {
+    for i := range items {
+        if items[i].Currency != "" {
+            items[i].Currency = normalizeCurrency(items[i].Currency)
+        }
+    }
+}
+
+// fitsMinorUnits reports whether amount can be expressed in the currency's
+// minor units, e.g. 10.50 USD but not 10.505 USD or 10.5 JPY. Trailing zeros
+// don't count against it.
+func (c Currency) fitsMinorUnits(amount decimal.Decimal) bool {
+    // The exponent settles most amounts without any arithmetic.
+    if -amount.Exponent() <= c.MinorUnits {
+        return true
+    }
+    return amount.Equal(amount.Truncate(c.MinorUnits))
+}
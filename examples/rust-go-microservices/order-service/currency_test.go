@@ -0,0 +1,117 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+)
+
+func TestCreateOrderCurrencies(t *testing.T) {
+    tests := []struct {
+        name      string
+        body      string
+        wantCode  int
+        wantField string
+        wantTotal string
+    }{
+        {"USD", `{"customer_id":"c","currency":"USD","items":[{"product_id":"p","quantity":3,"price":"19.99"}]}`, http.StatusCreated, "", "59.97"},
+        {"EUR lower-case", `{"customer_id":"c","currency":"eur","items":[{"product_id":"p","quantity":1,"price":"5.50","currency":"EUR"}]}`, http.StatusCreated, "", "5.5"},
+        {"JPY", `{"customer_id":"c","currency":"JPY","items":[{"product_id":"p","quantity":2,"price":"1500"}]}`, http.StatusCreated, "", "3000"},
+        {"JPY fractional", `{"customer_id":"c","currency":"JPY","items":[{"product_id":"p","quantity":1,"price":"1500.5"}]}`, http.StatusCreated, "", "1500"},
+        {"USD sub-cent", `{"customer_id":"c","currency":"USD","items":[{"product_id":"p","quantity":1,"price":"1.005"}]}`, http.StatusCreated, "", "1"},
+        {"invalid code", `{"customer_id":"c","currency":"XYZ","items":[{"product_id":"p","quantity":1,"price":"1"}]}`, http.StatusUnprocessableEntity, "currency", ""},
+        {"mixed", `{"customer_id":"c","currency":"USD","items":[{"product_id":"p","quantity":1,"price":"1","currency":"EUR"}]}`, http.StatusUnprocessableEntity, "items[0].currency", ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fake := newPaymentServer(t)
+            resetOrders(t)
+            r := setupRouter()
+
+            w := doRequest(r, http.MethodPost, "/orders", tt.body)
+            if w.Code != tt.wantCode {
+                t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+            }
+
+            if tt.wantCode != http.StatusCreated {
+                resp := decodeValidationError(w)
+                if len(resp.Fields) != 1 || resp.Fields[0].Field != tt.wantField {
+                    t.Fatalf("got fields %+v, want %s", resp.Fields, tt.wantField)
+                }
+                return
+            }
+
+            var order Order
+            json.Unmarshal(w.Body.Bytes(), &order)
+            charge := fake.lastCharge.Load()
+            if charge == nil || charge.Currency != order.Currency || order.Currency != tt.name[:3] {
+                t.Fatalf("order currency %q charged as %+v", order.Currency, charge)
+            }
+            if got := order.TotalAmount.String(); got != tt.wantTotal {
+                t.Fatalf("total = %s, want %s", got, tt.wantTotal)
+            }
+        })
+    }
+}
+
+func TestCreateOrderDefaultsToUSD(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    if w := doRequest(r, http.MethodPost, "/orders", sampleOrder); w.Code != http.StatusCreated {
+        t.Fatalf("got status %d", w.Code)
+    }
+    if got := fake.lastCharge.Load().Currency; got != "USD" {
+        t.Fatalf("charged in %q, want USD", got)
+    }
+}
+
+func useStrictPrecision(t *testing.T) {
+    t.Helper()
+
+    prev := strictPrecision
+    strictPrecision = true
+    t.Cleanup(func() { strictPrecision = prev })
+}
+
+func TestStrictPrecision(t *testing.T) {
+    useStrictPrecision(t)
+    tests := []struct {
+        name      string
+        body      string
+        wantField string
+    }{
+        {"two-decimal USD", `{"customer_id":"c","currency":"USD","items":[{"product_id":"p","quantity":3,"price":"19.99"}]}`, ""},
+        {"trailing zeros", `{"customer_id":"c","currency":"USD","items":[{"product_id":"p","quantity":1,"price":"19.9900"}]}`, ""},
+        {"zero-decimal JPY", `{"customer_id":"c","currency":"JPY","items":[{"product_id":"p","quantity":2,"price":"1500"}]}`, ""},
+        {"three-decimal KWD", `{"customer_id":"c","currency":"KWD","items":[{"product_id":"p","quantity":1,"price":"2.125"}]}`, ""},
+        {"over-precise USD", `{"customer_id":"c","currency":"USD","items":[{"product_id":"p","quantity":1,"price":"19.999999"}]}`, "items[0].price"},
+        {"fractional JPY", `{"customer_id":"c","currency":"JPY","items":[{"product_id":"p","quantity":1,"price":"1500.5"}]}`, "items[0].price"},
+        {"over-precise fixed discount", `{"customer_id":"c","items":[{"product_id":"p","quantity":1,"price":"20"}],"discount":{"type":"fixed","value":"0.555"}}`, "discount.value"},
+        {"over-precise item discount", `{"customer_id":"c","items":[{"product_id":"p","quantity":1,"price":"20","discount":{"type":"fixed","value":"1.001"}}]}`, "items[0].discount.value"},
+        {"fractional percentage", `{"customer_id":"c","items":[{"product_id":"p","quantity":1,"price":"20"}],"discount":{"type":"percentage","value":"12.5"}}`, ""},
+        {"over-precise expected total", `{"customer_id":"c","items":[{"product_id":"p","quantity":1,"price":"20"}],"expected_total":"20.001"}`, "expected_total"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            newPaymentServer(t)
+            resetOrders(t)
+            r := setupRouter()
+
+            w := doRequest(r, http.MethodPost, "/orders", tt.body)
+            if tt.wantField == "" {
+                if w.Code != http.StatusCreated {
+                    t.Fatalf("got status %d, want 201: %s", w.Code, w.Body)
+                }
+                return
+            }
+            if w.Code != http.StatusUnprocessableEntity {
+                t.Fatalf("got status %d, want 422: %s", w.Code, w.Body)
+            }
+            if resp := decodeValidationError(w); len(resp.Fields) != 1 || resp.Fields[0].Field != tt.wantField {
+                t.Fatalf("got fields %+v, want %s", resp.Fields, tt.wantField)
+            }
+        })
+    }
+}
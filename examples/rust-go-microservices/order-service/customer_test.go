@@ -0,0 +1,85 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+func saveCustomerOrder(customerID, status string, createdAt time.Time) *Order {
+    order := &Order{OrderID: uuid.New(), CustomerID: customerID, Status: status, CreatedAt: createdAt}
+    orders.Save(order)
+    return order
+}
+
+func TestListCustomerOrders(t *testing.T) {
+    resetOrders(t)
+    base := time.Now()
+    first := saveCustomerOrder("alice", StatusConfirmed, base)
+    second := saveCustomerOrder("alice", StatusCancelled, base.Add(time.Minute))
+    saveCustomerOrder("bob", StatusConfirmed, base)
+    r := setupRouter()
+
+    list := decodeList(t, doRequest(r, http.MethodGet, "/customers/alice/orders", "").Body.Bytes())
+    if list.Total != 2 || len(list.Orders) != 2 {
+        t.Fatalf("got %d of %d orders, want 2", len(list.Orders), list.Total)
+    }
+    if list.Orders[0].OrderID != second.OrderID || list.Orders[1].OrderID != first.OrderID {
+        t.Fatal("customer orders not newest first")
+    }
+
+    paged := decodeList(t, doRequest(r, http.MethodGet, "/customers/alice/orders?limit=1&offset=1", "").Body.Bytes())
+    if paged.Total != 2 || len(paged.Orders) != 1 || paged.Orders[0].OrderID != first.OrderID {
+        t.Fatalf("unexpected page: %+v", paged)
+    }
+}
+
+func TestListCustomerOrdersUnknownCustomer(t *testing.T) {
+    resetOrders(t)
+    saveCustomerOrder("alice", StatusConfirmed, time.Now())
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodGet, "/customers/nobody/orders", "")
+    if w.Code != http.StatusOK {
+        t.Fatalf("got status %d, want 200", w.Code)
+    }
+    list := decodeList(t, w.Body.Bytes())
+    if list.Total != 0 || list.Orders == nil || len(list.Orders) != 0 {
+        t.Fatalf("unexpected list: %+v", list)
+    }
+}
+
+func TestListCustomerOrdersStatusFilter(t *testing.T) {
+    resetOrders(t)
+    confirmed := saveCustomerOrder("alice", StatusConfirmed, time.Now())
+    saveCustomerOrder("alice", StatusCancelled, time.Now())
+    r := setupRouter()
+
+    list := decodeList(t, doRequest(r, http.MethodGet, "/customers/alice/orders?status=confirmed", "").Body.Bytes())
+    if list.Total != 1 || list.Orders[0].OrderID != confirmed.OrderID {
+        t.Fatalf("unexpected list: %+v", list)
+    }
+}
+
+func TestOrderStoreCustomerIndexStaysInSync(t *testing.T) {
+    s := NewOrderStore()
+    order := &Order{OrderID: uuid.New(), CustomerID: "alice"}
+    s.Save(order)
+    s.Save(order) // re-saving must not duplicate the index entry
+
+    order.CustomerID = "bob"
+    s.Save(order)
+    if list, _ := s.ListByCustomer("alice"); len(list) != 0 {
+        t.Fatalf("alice still has %d orders after reassignment", len(list))
+    }
+    if list, _ := s.ListByCustomer("bob"); len(list) != 1 {
+        t.Fatalf("bob has %d orders, want 1", len(list))
+    }
+
+    s.Delete(order.OrderID)
+    if list, _ := s.ListByCustomer("bob"); len(list) != 0 {
+        t.Fatalf("bob still has %d orders after delete", len(list))
+    }
+}
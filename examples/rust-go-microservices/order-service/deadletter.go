@@ -0,0 +1,237 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+)
+
+const (
+    defaultEventDeliveryAttempts = 3
+    defaultEventRetryDelay       = 200 * time.Millisecond
+)
+
+// DeadLetter is an event the publisher gave up delivering, kept so it can
+// be replayed once whatever stopped it is fixed.
+type DeadLetter struct {
+    // ID identifies this letter. An event that fails again after a replay
+    // gets a new letter, so removing the old one can't drop it.
+    ID       uuid.UUID  `json:"id"`
+    Event    OrderEvent `json:"event"`
+    Reason   string     `json:"reason"`
+    Attempts int        `json:"attempts"`
+    FailedAt time.Time  `json:"failed_at"`
+}
+
+// DeadLetterStore keeps undeliverable events until they are replayed.
+type DeadLetterStore interface {
+    Add(letter DeadLetter) error
+    // List returns every letter, oldest first.
+    List() ([]DeadLetter, error)
+    // Remove drops the letters with the given IDs; unknown IDs are ignored.
+    Remove(ids []uuid.UUID) error
+}
+
+// deadLetters is where POST /admin/events/replay replays from; nil when
+// undeliverable events are only logged.
+var deadLetters DeadLetterStore
+
+// MemoryDeadLetters is a DeadLetterStore local to this instance and lost
+// when it stops, for tests.
+type MemoryDeadLetters struct {
+    mu      sync.Mutex
+    letters []DeadLetter
+}
+
+func (s *MemoryDeadLetters) Add(letter DeadLetter) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.letters = append(s.letters, letter)
+    return nil
+}
+
+func (s *MemoryDeadLetters) List() ([]DeadLetter, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return append([]DeadLetter(nil), s.letters...), nil
+}
+
+func (s *MemoryDeadLetters) Remove(ids []uuid.UUID) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.letters = withoutLetters(s.letters, ids)
+    return nil
+}
+
+// withoutLetters returns the letters whose IDs aren't in ids.
+func withoutLetters(letters []DeadLetter, ids []uuid.UUID) []DeadLetter {
+    drop := make(map[uuid.UUID]bool, len(ids))
+    for _, id := range ids {
+        drop[id] = true
+    }
+    kept := make([]DeadLetter, 0, len(letters))
+    for _, letter := range letters {
+        if !drop[letter.ID] {
+            kept = append(kept, letter)
+        }
+    }
+    return kept
+}
+
+// FileDeadLetters keeps letters in a file, one JSON object per line, so
+// they survive a restart. Only one instance should use a given file.
+type FileDeadLetters struct {
+    mu   sync.Mutex
+    path string
+}
+
+func NewFileDeadLetters(path string) *FileDeadLetters {
+    return &FileDeadLetters{path: path}
+}
+
+func (s *FileDeadLetters) Add(letter DeadLetter) error {
+    line, err := json.Marshal(letter)
+    if err != nil {
+        return err
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+    if err != nil {
+        return err
+    }
+    if _, err := f.Write(append(line, '\n')); err != nil {
+        f.Close()
+        return err
+    }
+    return f.Close()
+}
+
+func (s *FileDeadLetters) List() ([]DeadLetter, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return s.read()
+}
+
+// Remove rewrites the file without the removed letters, replacing it in
+// one rename so a crash leaves either the old file or the new one.
+func (s *FileDeadLetters) Remove(ids []uuid.UUID) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    letters, err := s.read()
+    if err != nil {
+        return err
+    }
+    tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+
+    w := bufio.NewWriter(tmp)
+    enc := json.NewEncoder(w)
+    for _, letter := range withoutLetters(letters, ids) {
+        if err := enc.Encode(letter); err != nil {
+            tmp.Close()
+            return err
+        }
+    }
+    if err := w.Flush(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), s.path)
+}
+
+// read returns the file's letters; a missing file has none.
+func (s *FileDeadLetters) read() ([]DeadLetter, error) {
+    f, err := os.Open(s.path)
+    if errors.Is(err, fs.ErrNotExist) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    var letters []DeadLetter
+    dec := json.NewDecoder(f)
+    for dec.More() {
+        var letter DeadLetter
+        if err := dec.Decode(&letter); err != nil {
+            return nil, fmt.Errorf("%s: %w", s.path, err)
+        }
+        letters = append(letters, letter)
+    }
+    return letters, nil
+}
+
+// deadLettersFromEnv returns a FileDeadLetters writing to DEAD_LETTER_FILE,
+// or nil when it is unset.
+func deadLettersFromEnv() DeadLetterStore {
+    if path := os.Getenv("DEAD_LETTER_FILE"); path != "" {
+        return NewFileDeadLetters(path)
+    }
+    return nil
+}
+
+// ReplayResult is the response to POST /admin/events/replay.
+type ReplayResult struct {
+    // Replayed counts the events handed back to the publisher. One that
+    // fails again is dead-lettered again.
+    Replayed int `json:"replayed"`
+    // Remaining counts the letters left, because the publisher couldn't
+    // take them.
+    Remaining int `json:"remaining"`
+}
+
+// replayDeadLetters publishes every dead-lettered event again, oldest
+// first, under its original event ID so consumers that did see it can drop
+// the duplicate.
+func replayDeadLetters(c *gin.Context) {
+    if deadLetters == nil {
+        c.JSON(http.StatusOK, ReplayResult{})
+        return
+    }
+    letters, err := deadLetters.List()
+    if err != nil {
+        respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to read dead-lettered events")
+        return
+    }
+
+    log := loggerFrom(c.Request.Context())
+    var replayed []uuid.UUID
+    for _, letter := range letters {
+        if err := events.Publish(c.Request.Context(), letter.Event); err != nil {
+            log.Warn("failed to replay event", "event_id", letter.Event.EventID, "error", err)
+            continue
+        }
+        replayed = append(replayed, letter.ID)
+    }
+    if err := deadLetters.Remove(replayed); err != nil {
+        respondError(c, http.StatusInternalServerError, CodeInternal, "Replayed events but failed to remove them from the dead letters")
+        return
+    }
+    log.Info("replayed dead-lettered events", "replayed", len(replayed), "remaining", len(letters)-len(replayed))
+    c.JSON(http.StatusOK, ReplayResult{Replayed: len(replayed), Remaining: len(letters) - len(replayed)})
+}
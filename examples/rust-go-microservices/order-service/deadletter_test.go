@@ -0,0 +1,135 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "path/filepath"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// flakyBroker refuses every send while down is set and records the events
+// it accepts.
+type flakyBroker struct {
+    down     atomic.Bool
+    attempts atomic.Int64
+
+    mu       sync.Mutex
+    received []OrderEvent
+}
+
+func (b *flakyBroker) Send(_ context.Context, _ string, payload []byte) error {
+    b.attempts.Add(1)
+    if b.down.Load() {
+        return errors.New("broker unavailable")
+    }
+    var event OrderEvent
+    json.Unmarshal(payload, &event)
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.received = append(b.received, event)
+    return nil
+}
+
+func (b *flakyBroker) Received() []OrderEvent {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return append([]OrderEvent(nil), b.received...)
+}
+
+// waitForLetters waits for store to hold n letters and returns them.
+func waitForLetters(t *testing.T, store DeadLetterStore, n int) []DeadLetter {
+    t.Helper()
+    deadline := time.Now().Add(2 * time.Second)
+    for {
+        letters, err := store.List()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(letters) == n {
+            return letters
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("got %d dead letters, want %d", len(letters), n)
+        }
+        time.Sleep(5 * time.Millisecond)
+    }
+}
+
+func TestUndeliverableEventIsDeadLetteredAndReplayed(t *testing.T) {
+    useServerGlobals(t)
+    useAPIKeys(t, "root:@admin,plain")
+    broker := &flakyBroker{}
+    broker.down.Store(true)
+    deadLetters = &MemoryDeadLetters{}
+    p := NewBrokerPublisher(broker, 8, deadLetters)
+    p.retryDelay = time.Millisecond
+    t.Cleanup(p.Close)
+    events = p
+    r := setupRouter()
+
+    event := newOrderEvent(EventOrderConfirmed, &Order{OrderID: uuid.New(), Status: StatusConfirmed})
+    p.Publish(context.Background(), event)
+    letters := waitForLetters(t, deadLetters, 1)
+    got := letters[0]
+    if got.Event.EventID != event.EventID || got.Reason != "broker unavailable" || got.Attempts != 3 {
+        t.Fatalf("got dead letter %+v, want the event after 3 attempts", got)
+    }
+    if n := broker.attempts.Load(); n != 3 {
+        t.Fatalf("broker saw %d attempts, want 3", n)
+    }
+
+    // Only admins may replay.
+    if w := doRequestWithHeaders(r, http.MethodPost, "/admin/events/replay", "", bearer("plain")); w.Code != http.StatusForbidden {
+        t.Fatalf("non-admin replay got status %d, want 403", w.Code)
+    }
+
+    broker.down.Store(false)
+    w := doRequestWithHeaders(r, http.MethodPost, "/admin/events/replay", "", bearer("root"))
+    var result ReplayResult
+    json.Unmarshal(w.Body.Bytes(), &result)
+    if w.Code != http.StatusOK || result != (ReplayResult{Replayed: 1}) {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    waitForLetters(t, deadLetters, 0)
+    deadline := time.Now().Add(2 * time.Second)
+    for len(broker.Received()) == 0 && time.Now().Before(deadline) {
+        time.Sleep(5 * time.Millisecond)
+    }
+    if received := broker.Received(); len(received) != 1 || received[0].EventID != event.EventID {
+        t.Fatalf("broker received %+v, want the original event", received)
+    }
+}
+
+func TestFileDeadLetters(t *testing.T) {
+    store := NewFileDeadLetters(filepath.Join(t.TempDir(), "dead-letters.jsonl"))
+    if letters, err := store.List(); err != nil || len(letters) != 0 {
+        t.Fatalf("got %v, %v from a missing file, want nothing", letters, err)
+    }
+
+    first := DeadLetter{ID: uuid.New(), Event: OrderEvent{EventID: uuid.New(), Type: EventOrderCreated}, Reason: "first"}
+    second := DeadLetter{ID: uuid.New(), Event: OrderEvent{EventID: uuid.New(), Type: EventOrderConfirmed}, Reason: "second"}
+    for _, letter := range []DeadLetter{first, second} {
+        if err := store.Add(letter); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := store.Remove([]uuid.UUID{first.ID, uuid.New()}); err != nil {
+        t.Fatal(err)
+    }
+
+    // A new store on the same file sees what the first left.
+    letters, err := NewFileDeadLetters(store.path).List()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(letters) != 1 || letters[0].ID != second.ID || letters[0].Event.EventID != second.Event.EventID || letters[0].Reason != "second" {
+        t.Fatalf("got %+v, want only the second letter", letters)
+    }
+}
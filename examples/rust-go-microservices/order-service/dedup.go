@@ -0,0 +1,121 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "sync"
+    "time"
+)
+
+const (
+    // webhookDeliveryHeader carries the payment service's ID for one
+    // delivery of a webhook; a redelivery of the same event keeps it.
+    webhookDeliveryHeader = "X-Payment-Delivery-ID"
+
+    defaultWebhookDedupWindow = 5 * time.Minute
+
+    // sharedDeliveryPrefix namespaces delivery IDs in a shared store.
+    sharedDeliveryPrefix = "order-service:webhook-delivery:"
+)
+
+// DeliveryStore remembers the webhook deliveries handled recently, so a
+// delivery repeated within its window is acknowledged without being
+// processed again.
+type DeliveryStore interface {
+    // Claim records id as being handled and reports whether it was new;
+    // false means it was seen within the window.
+    Claim(ctx context.Context, id string) (bool, error)
+    // Forget drops id, for a delivery that wasn't handled after all, so
+    // that the payment service's retry is.
+    Forget(ctx context.Context, id string)
+}
+
+// MemoryDeliveryStore is a DeliveryStore local to this instance. A window
+// of zero or less remembers nothing.
+type MemoryDeliveryStore struct {
+    mu        sync.Mutex
+    window    time.Duration
+    now       func() time.Time
+    seen      map[string]time.Time
+    lastPrune time.Time
+}
+
+func NewMemoryDeliveryStore(window time.Duration) *MemoryDeliveryStore {
+    return &MemoryDeliveryStore{window: window, now: currentTime, seen: make(map[string]time.Time)}
+}
+
+func (s *MemoryDeliveryStore) Claim(_ context.Context, id string) (bool, error) {
+    if s.window <= 0 {
+        return true, nil
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    now := s.now()
+    if now.Sub(s.lastPrune) >= s.window {
+        for seenID, expiresAt := range s.seen {
+            if !now.Before(expiresAt) {
+                delete(s.seen, seenID)
+            }
+        }
+        s.lastPrune = now
+    }
+    if expiresAt, ok := s.seen[id]; ok && now.Before(expiresAt) {
+        return false, nil
+    }
+    s.seen[id] = now.Add(s.window)
+    return true, nil
+}
+
+func (s *MemoryDeliveryStore) Forget(_ context.Context, id string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    delete(s.seen, id)
+}
+
+// SharedDeliveryStore is a DeliveryStore kept in a store shared by every
+// instance, so a redelivery that lands on another instance is caught too.
+type SharedDeliveryStore struct {
+    shared SharedStore
+    window time.Duration
+}
+
+func NewSharedDeliveryStore(shared SharedStore, window time.Duration) *SharedDeliveryStore {
+    return &SharedDeliveryStore{shared: shared, window: window}
+}
+
+func (s *SharedDeliveryStore) Claim(ctx context.Context, id string) (bool, error) {
+    if s.window <= 0 {
+        return true, nil
+    }
+    return s.shared.SetNX(ctx, sharedDeliveryPrefix+id, "1", s.window)
+}
+
+func (s *SharedDeliveryStore) Forget(ctx context.Context, id string) {
+    if err := s.shared.Del(ctx, sharedDeliveryPrefix+id); err != nil {
+        loggerFrom(ctx).Warn("failed to forget webhook delivery", "delivery_id", id, "error", err)
+    }
+}
+
+// webhookDeliveries catches repeated webhook deliveries.
+var webhookDeliveries DeliveryStore = NewMemoryDeliveryStore(defaultWebhookDedupWindow)
+
+// deliveryStoreFromEnv reads WEBHOOK_DEDUP_WINDOW, and remembers deliveries
+// in the Redis at WEBHOOK_DEDUP_REDIS_URL if it is set.
+func deliveryStoreFromEnv() (DeliveryStore, error) {
+    window, err := envDuration("WEBHOOK_DEDUP_WINDOW", defaultWebhookDedupWindow)
+    if err != nil {
+        return nil, err
+    }
+    raw := os.Getenv("WEBHOOK_DEDUP_REDIS_URL")
+    if raw == "" {
+        return NewMemoryDeliveryStore(window), nil
+    }
+    store, err := parseRedisURL(raw)
+    if err != nil {
+        return nil, fmt.Errorf("WEBHOOK_DEDUP_REDIS_URL: %w", err)
+    }
+    return NewSharedDeliveryStore(store, window), nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+)
+
+// deleteOrder soft-deletes an order: it stays in the store for audit, with
+// DeletedAt set, but the API stops returning it. Orders still holding the
+// customer's money must be refunded or cancelled first.
+func deleteOrder(c *gin.Context) {
+    // Held so a pending order's charge, already under way, finishes first
+    // and the check below sees whether it took the money.
+    unlock := lockOrderParam(c)
+    defer unlock()
+    order := loadOrder(c)
+    if order == nil {
+        return
+    }
+
+    if order.Status == StatusConfirmed || order.Status == StatusPartiallyShipped || order.Status == StatusShipped {
+        respondError(c, http.StatusConflict, CodeInvalidStatusTransition,
+            fmt.Sprintf("Order in status %q must be refunded before it can be deleted", order.Status))
+        return
+    }
+
+    now := clock.Now()
+    order.DeletedAt = &now
+    if err := orders.Save(order); err != nil {
+        respondSaveError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, withLinks(order))
+}
+
+// includeDeleted reports whether the request asked for soft-deleted orders
+// with ?include_deleted=true.
+func includeDeleted(c *gin.Context) bool {
+    return c.Query("include_deleted") == "true"
+}
+
+func excludeDeleted(list []*Order) []*Order {
+    kept := make([]*Order, 0, len(list))
+    for _, order := range list {
+        if order.DeletedAt == nil {
+            kept = append(kept, order)
+        }
+    }
+    return kept
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+    "time"
+)
+
+func TestDeleteOrderHidesIt(t *testing.T) {
+    resetOrders(t)
+    r := setupRouter()
+    order := saveOrderWithStatus(StatusCancelled)
+    kept := saveOrderWithStatus(StatusPending)
+    path := "/orders/" + order.OrderID.String()
+
+    w := doRequest(r, http.MethodDelete, path, "")
+    if w.Code != http.StatusOK {
+        t.Fatalf("delete: got status %d: %s", w.Code, w.Body)
+    }
+
+    stored, err := orders.FindByID(order.OrderID)
+    if err != nil || stored.DeletedAt == nil || stored.Status != StatusCancelled {
+        t.Fatalf("order not soft-deleted: %+v, %v", stored, err)
+    }
+
+    if w := doRequest(r, http.MethodGet, path, ""); w.Code != http.StatusNotFound {
+        t.Fatalf("get deleted: got status %d, want 404", w.Code)
+    }
+    if w := doRequest(r, http.MethodDelete, path, ""); w.Code != http.StatusNotFound {
+        t.Fatalf("delete again: got status %d, want 404", w.Code)
+    }
+    if w := doRequest(r, http.MethodPost, path+"/cancel", ""); w.Code != http.StatusNotFound {
+        t.Fatalf("cancel deleted: got status %d, want 404", w.Code)
+    }
+
+    list := decodeList(t, doRequest(r, http.MethodGet, "/orders", "").Body.Bytes())
+    if list.Total != 1 || list.Orders[0].OrderID != kept.OrderID {
+        t.Fatalf("list includes deleted order: %+v", list.Orders)
+    }
+    customer := decodeList(t, doRequest(r, http.MethodGet, "/customers/cust_123/orders", "").Body.Bytes())
+    if customer.Total != 1 {
+        t.Fatalf("customer list includes deleted order: %+v", customer.Orders)
+    }
+}
+
+func TestIncludeDeleted(t *testing.T) {
+    resetOrders(t)
+    r := setupRouter()
+    order := saveOrderWithStatus(StatusRefunded)
+    saveOrderWithStatus(StatusPending)
+    path := "/orders/" + order.OrderID.String()
+    doRequest(r, http.MethodDelete, path, "")
+
+    w := doRequest(r, http.MethodGet, path+"?include_deleted=true", "")
+    if w.Code != http.StatusOK {
+        t.Fatalf("get with include_deleted: got status %d", w.Code)
+    }
+    var got Order
+    json.Unmarshal(w.Body.Bytes(), &got)
+    if got.DeletedAt == nil {
+        t.Fatal("deleted order rendered without deleted_at")
+    }
+
+    if list := decodeList(t, doRequest(r, http.MethodGet, "/orders?include_deleted=true", "").Body.Bytes()); list.Total != 2 {
+        t.Fatalf("got %d orders with include_deleted, want 2", list.Total)
+    }
+    if list := decodeList(t, doRequest(r, http.MethodGet, "/customers/cust_123/orders?include_deleted=true", "").Body.Bytes()); list.Total != 2 {
+        t.Fatalf("got %d customer orders with include_deleted, want 2", list.Total)
+    }
+}
+
+func TestDeleteChargedOrderRejected(t *testing.T) {
+    resetOrders(t)
+    r := setupRouter()
+
+    for _, status := range []string{StatusConfirmed, StatusShipped} {
+        order := saveOrderWithStatus(status)
+        w := doRequest(r, http.MethodDelete, "/orders/"+order.OrderID.String(), "")
+        if w.Code != http.StatusConflict {
+            t.Fatalf("%s: got status %d, want 409", status, w.Code)
+        }
+        if stored, _ := orders.FindByID(order.OrderID); stored.DeletedAt != nil {
+            t.Fatalf("%s order was deleted", status)
+        }
+    }
+}
+
+func TestDeleteWaitsForChargeInFlight(t *testing.T) {
+    resetOrders(t)
+    r := setupRouter()
+    order := saveOrderWithStatus(StatusPending)
+
+    // A charge holds the order while the delete arrives, and confirms it
+    // before letting go.
+    unlock := orderLocks.lock(order.OrderID)
+    done := make(chan int)
+    go func() {
+        done <- doRequest(r, http.MethodDelete, "/orders/"+order.OrderID.String(), "").Code
+    }()
+    time.Sleep(20 * time.Millisecond)
+    order.Status = StatusConfirmed
+    orders.Save(order)
+    unlock()
+
+    if code := <-done; code != http.StatusConflict {
+        t.Fatalf("got status %d, want 409 for an order confirmed while it waited", code)
+    }
+    if stored, _ := orders.FindByID(order.OrderID); stored.DeletedAt != nil {
+        t.Fatal("paid order was deleted")
+    }
+}
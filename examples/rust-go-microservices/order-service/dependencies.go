@@ -0,0 +1,80 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "time"
+)
+
+const (
+    defaultInventoryTimeout    = 5 * time.Second
+    defaultExchangeRateTimeout = 5 * time.Second
+)
+
+// DependencyTimeouts bound the calls made to each downstream service. Each
+// has its own, so a dependency that is slow by nature can be given longer
+// without every other call waiting as long. Zero fields take the defaults.
+type DependencyTimeouts struct {
+    // Payment bounds one attempt at a payment call; the client's
+    // MaxElapsed bounds the call with its retries.
+    Payment       time.Duration
+    Inventory     time.Duration
+    ExchangeRates time.Duration
+}
+
+// dependencyTimeoutsFromEnv reads PAYMENT_TIMEOUT, INVENTORY_TIMEOUT and
+// EXCHANGE_RATE_TIMEOUT.
+func dependencyTimeoutsFromEnv() (DependencyTimeouts, error) {
+    var t DependencyTimeouts
+    var err error
+    if t.Payment, err = envDuration("PAYMENT_TIMEOUT", paymentAttemptTimeout); err != nil {
+        return t, err
+    }
+    if t.Inventory, err = envDuration("INVENTORY_TIMEOUT", defaultInventoryTimeout); err != nil {
+        return t, err
+    }
+    if t.ExchangeRates, err = envDuration("EXCHANGE_RATE_TIMEOUT", defaultExchangeRateTimeout); err != nil {
+        return t, err
+    }
+    return t, nil
+}
+
+// withDefaults fills in the timeouts left zero.
+func (t DependencyTimeouts) withDefaults() DependencyTimeouts {
+    if t.Payment == 0 {
+        t.Payment = paymentAttemptTimeout
+    }
+    if t.Inventory == 0 {
+        t.Inventory = defaultInventoryTimeout
+    }
+    if t.ExchangeRates == 0 {
+        t.ExchangeRates = defaultExchangeRateTimeout
+    }
+    return t
+}
+
+// check rejects negative timeouts, which would fail every call.
+func (t DependencyTimeouts) check() error {
+    for _, d := range []struct {
+        name    string
+        timeout time.Duration
+    }{
+        {"payment", t.Payment},
+        {"inventory", t.Inventory},
+        {"exchange rate", t.ExchangeRates},
+    } {
+        if d.timeout <= 0 {
+            return fmt.Errorf("%s timeout must be positive, got %s", d.name, d.timeout)
+        }
+    }
+    return nil
+}
+
+// callTimeout bounds ctx by d for one call to a dependency. A d of zero or
+// less leaves ctx as it is.
+func callTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+    if d <= 0 {
+        return ctx, func() {}
+    }
+    return context.WithTimeout(ctx, d)
+}
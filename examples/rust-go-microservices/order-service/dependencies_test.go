@@ -0,0 +1,118 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// slowDependency answers as the payment, inventory and exchange rate
+// services would, each after delay.
+func slowDependency(t *testing.T, delay time.Duration) *httptest.Server {
+    t.Helper()
+
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        select {
+        case <-time.After(delay):
+        case <-r.Context().Done():
+            return
+        }
+        switch r.URL.Path {
+        case "/process":
+            w.Write([]byte(`{"status":"approved","amount":"1.00"}`))
+        case "/reservations":
+            w.Write([]byte(`{"reservation_id":"res_1"}`))
+        case "/rates":
+            w.Write([]byte(`{"rate":"1.08"}`))
+        default:
+            http.NotFound(w, r)
+        }
+    }))
+    t.Cleanup(srv.Close)
+    return srv
+}
+
+func TestDependencyClientsRespectOwnTimeouts(t *testing.T) {
+    const delay = 100 * time.Millisecond
+    srv := slowDependency(t, delay)
+
+    calls := []struct {
+        name string
+        call func(timeout time.Duration) error
+    }{
+        {"payment", func(timeout time.Duration) error {
+            p := NewPaymentClient(srv.URL)
+            p.MaxRetries = 0
+            p.AttemptTimeout = timeout
+            _, err := p.processPayment(context.Background(), PaymentRequest{})
+            return err
+        }},
+        {"inventory", func(timeout time.Duration) error {
+            c := NewInventoryClient(srv.URL)
+            c.Timeout = timeout
+            _, err := c.Reserve(context.Background(), uuid.New(), "prod_1", 1)
+            return err
+        }},
+        {"exchange rates", func(timeout time.Duration) error {
+            c := NewRateClient(srv.URL)
+            c.Timeout = timeout
+            _, err := c.Rate(context.Background(), "EUR", "USD")
+            return err
+        }},
+    }
+    for _, tc := range calls {
+        t.Run(tc.name, func(t *testing.T) {
+            start := time.Now()
+            if err := tc.call(10 * time.Millisecond); err == nil {
+                t.Fatal("call outlasting its timeout succeeded")
+            }
+            if elapsed := time.Since(start); elapsed >= delay {
+                t.Fatalf("timed out call took %s, want it cut off before the %s answer", elapsed, delay)
+            }
+            if err := tc.call(5 * time.Second); err != nil {
+                t.Fatalf("call within its timeout: %v", err)
+            }
+        })
+    }
+}
+
+func TestNewServerInstallsDependencyTimeouts(t *testing.T) {
+    newPaymentServer(t)
+    useServerGlobals(t)
+    inv := NewInventoryClient("http://inventory.invalid")
+
+    _, err := NewServer(Config{Payments: payments, Inventory: inv, Dependencies: DependencyTimeouts{Inventory: 20 * time.Second}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if inv.Timeout != 20*time.Second || payments.AttemptTimeout != paymentAttemptTimeout {
+        t.Fatalf("got inventory %s and payment %s, want 20s and the default", inv.Timeout, payments.AttemptTimeout)
+    }
+
+    if _, err := NewServer(Config{Payments: payments, Dependencies: DependencyTimeouts{Payment: -time.Second}}); err == nil {
+        t.Fatal("negative payment timeout accepted")
+    }
+}
+
+func TestDependencyTimeoutsFromEnv(t *testing.T) {
+    t.Setenv("PAYMENT_TIMEOUT", "2s")
+    t.Setenv("EXCHANGE_RATE_TIMEOUT", "750ms")
+
+    got, err := dependencyTimeoutsFromEnv()
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := DependencyTimeouts{Payment: 2 * time.Second, Inventory: defaultInventoryTimeout, ExchangeRates: 750 * time.Millisecond}
+    if got != want {
+        t.Fatalf("got %+v, want %+v", got, want)
+    }
+
+    t.Setenv("INVENTORY_TIMEOUT", "0s")
+    if _, err := dependencyTimeoutsFromEnv(); err == nil {
+        t.Fatal("zero inventory timeout accepted")
+    }
+}
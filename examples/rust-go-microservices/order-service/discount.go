@@ -0,0 +1,72 @@
+package main
+
+import "github.com/shopspring/decimal"
+
+// Discount types.
+const (
+    DiscountPercentage = "percentage"
+    DiscountFixed      = "fixed"
+)
+
+var hundred = decimal.NewFromInt(100)
+
+// Discount takes money off a line item or a whole order: Value percent of
+// it for a percentage discount, or Value in the order's currency for a
+// fixed one.
+//
+// Discounts apply in this order, all at full precision:
+//
+//  1. Each line's total (price × quantity) is reduced by its own discount.
+//     A fixed line discount comes off the line, not each unit.
+//  2. The discounted lines are summed.
+//  3. The order discount is taken off that sum, so it stacks on top of
+//     line discounts rather than being computed from the undiscounted
+//     prices.
+//  4. The result is rounded to the currency's minor units and becomes the
+//     subtotal, which tax and the free-shipping threshold are based on.
+//
+// Validation rejects a discount that would take a line or the order below
+// zero.
+type Discount struct {
+    Type  string          `json:"type"`
+    Value decimal.Decimal `json:"value"`
+}
+
+// off returns how much d takes off amount; a nil discount takes nothing.
+func (d *Discount) off(amount decimal.Decimal) decimal.Decimal {
+    if d == nil {
+        return decimal.Zero
+    }
+    if d.Type == DiscountPercentage {
+        return amount.Mul(d.Value).Div(hundred)
+    }
+    return d.Value
+}
+
+// lineTotal is the item's price × quantity less its discount.
+func (item OrderItem) lineTotal() decimal.Decimal {
+    gross := item.Price.Mul(decimal.NewFromInt(int64(item.Quantity)))
+    return gross.Sub(item.Discount.off(gross))
+}
+
+// validateDiscount checks d, which applies to amount, reporting problems
+// under field.
+func validateDiscount(verr *ValidationError, field string, d *Discount, amount decimal.Decimal) {
+    if d == nil {
+        return
+    }
+    switch d.Type {
+    case DiscountPercentage:
+        if !d.Value.IsPositive() || d.Value.GreaterThan(hundred) {
+            verr.add(field+".value", "must be more than 0 and at most 100")
+        }
+    case DiscountFixed:
+        if !d.Value.IsPositive() {
+            verr.add(field+".value", "must be positive")
+        } else if d.Value.GreaterThan(amount) {
+            verr.add(field+".value", "%s exceeds the %s it applies to", d.Value, amount)
+        }
+    default:
+        verr.add(field+".type", "must be %q or %q", DiscountPercentage, DiscountFixed)
+    }
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+    "net/http"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/shopspring/decimal"
+)
+
+func percentOff(v string) *Discount {
+    return &Discount{Type: DiscountPercentage, Value: decimal.RequireFromString(v)}
+}
+
+func amountOff(v string) *Discount {
+    return &Discount{Type: DiscountFixed, Value: decimal.RequireFromString(v)}
+}
+
+func TestDiscountedTotal(t *testing.T) {
+    item := func(price string, qty int, d *Discount) OrderItem {
+        return OrderItem{ProductID: "p", Quantity: qty, Price: decimal.RequireFromString(price), Discount: d}
+    }
+    tests := []struct {
+        name     string
+        items    []OrderItem
+        discount *Discount
+        want     string
+    }{
+        {"no discount", []OrderItem{item("29.99", 2, nil)}, nil, "59.98"},
+        {"line percentage", []OrderItem{item("29.99", 2, percentOff("10"))}, nil, "53.98"},
+        {"line fixed comes off the line, not each unit", []OrderItem{item("10", 3, amountOff("5"))}, nil, "25"},
+        {"order percentage", []OrderItem{item("50", 1, nil), item("50", 1, nil)}, percentOff("25"), "75"},
+        {"order fixed", []OrderItem{item("50", 2, nil)}, amountOff("19.99"), "80.01"},
+        // 100 less 10% is 90, plus 40 is 130; the order's 10% then comes
+        // off 130, not off the undiscounted 140.
+        {"stacked", []OrderItem{item("100", 1, percentOff("10")), item("40", 1, nil)}, percentOff("10"), "117"},
+        {"stacked fixed after percentage", []OrderItem{item("100", 1, percentOff("50"))}, amountOff("50"), "0"},
+        {"rounded once at the end", []OrderItem{item("0.10", 1, percentOff("33.3")), item("0.10", 1, percentOff("33.3"))}, nil, "0.13"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            order := &Order{CustomerID: "c", Currency: "USD", Items: tt.items, Discount: tt.discount}
+            if err := validateOrder(order); err != nil {
+                t.Fatalf("validateOrder: %v", err)
+            }
+            if got := calculateTotal(order); !got.Equal(decimal.RequireFromString(tt.want)) {
+                t.Fatalf("got %s, want %s", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestDiscountsCannotGoNegative(t *testing.T) {
+    tests := []struct {
+        name      string
+        body      string
+        wantField string
+    }{
+        {"line fixed over line total", `{"customer_id":"c","items":[{"product_id":"p","quantity":2,"price":"5","discount":{"type":"fixed","value":"10.01"}}]}`, "items[0].discount.value"},
+        {"order fixed over discounted lines", `{"customer_id":"c","items":[{"product_id":"p","quantity":1,"price":"20","discount":{"type":"percentage","value":"50"}}],"discount":{"type":"fixed","value":"10.01"}}`, "discount.value"},
+        {"percentage over 100", `{"customer_id":"c","items":[{"product_id":"p","quantity":1,"price":"20","discount":{"type":"percentage","value":"101"}}]}`, "items[0].discount.value"},
+        {"negative discount", `{"customer_id":"c","items":[{"product_id":"p","quantity":1,"price":"20"}],"discount":{"type":"fixed","value":"-5"}}`, "discount.value"},
+        {"unknown type", `{"customer_id":"c","items":[{"product_id":"p","quantity":1,"price":"20"}],"discount":{"type":"bogo","value":"1"}}`, "discount.type"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            resetOrders(t)
+            fake := newPaymentServer(t)
+            r := setupRouter()
+
+            w := doRequest(r, http.MethodPost, "/orders", tt.body)
+            if w.Code != http.StatusUnprocessableEntity {
+                t.Fatalf("got status %d, want 422: %s", w.Code, w.Body)
+            }
+            verr := decodeValidationError(w)
+            if verr == nil || len(verr.Fields) != 1 || verr.Fields[0].Field != tt.wantField {
+                t.Fatalf("got %+v, want a single error on %s", verr, tt.wantField)
+            }
+            if n := fake.charges.Load(); n != 0 {
+                t.Fatalf("invalid discount was charged %d times", n)
+            }
+        })
+    }
+}
+
+func TestDiscountedOrderIsCharged(t *testing.T) {
+    resetOrders(t)
+    fake := newPaymentServer(t)
+    r := setupRouter()
+
+    body := `{"customer_id":"c","items":[{"product_id":"p","quantity":2,"price":"29.99","discount":{"type":"fixed","value":"9.98"}}],"discount":{"type":"percentage","value":"10"}}`
+    if w := doRequest(r, http.MethodPost, "/orders", body); w.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    if got := fake.lastCharge.Load().Amount.String(); got != "45" {
+        t.Fatalf("charged %s, want 45", got)
+    }
+}
+
+func TestSQLiteRepositoryRoundTripsDiscounts(t *testing.T) {
+    repo := openTestSQLite(t, filepath.Join(t.TempDir(), "orders.db"))
+    order := &Order{
+        OrderID:    orderIDs.NewID(),
+        CustomerID: "c",
+        Currency:   "USD",
+        Items:      []OrderItem{{ProductID: "p", Quantity: 1, Price: decimal.NewFromInt(10), Discount: percentOff("10")}},
+        Discount:   amountOff("1"),
+        Status:     StatusConfirmed,
+        CreatedAt:  time.Now(),
+    }
+    if err := repo.Save(order); err != nil {
+        t.Fatal(err)
+    }
+
+    got, err := repo.FindByID(order.OrderID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got.Discount == nil || got.Discount.Type != DiscountFixed || !got.Discount.Value.Equal(decimal.NewFromInt(1)) {
+        t.Fatalf("got order discount %+v", got.Discount)
+    }
+    if d := got.Items[0].Discount; d == nil || d.Type != DiscountPercentage || !d.Value.Equal(decimal.NewFromInt(10)) {
+        t.Fatalf("got item discount %+v", d)
+    }
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "github.com/shopspring/decimal"
+)
+
+// currencyFormat is how amounts in a currency are written for people in the
+// locale most associated with it.
+type currencyFormat struct {
+    Symbol string
+    // SymbolAfter puts the symbol after the amount, separated by a space.
+    SymbolAfter bool
+    Group       string
+    Decimal     string
+    // Indian groups digits in twos after the first three, as in 12,34,567.
+    Indian bool
+}
+
+// currencyFormats covers the currencies whose customers most often see
+// formatted totals. Others are written with their ISO code after the
+// amount.
+var currencyFormats = map[string]currencyFormat{
+    "AUD": {Symbol: "A$", Group: ",", Decimal: "."},
+    "CAD": {Symbol: "CA$", Group: ",", Decimal: "."},
+    "CHF": {Symbol: "CHF ", Group: "'", Decimal: "."},
+    "EUR": {Symbol: "€", SymbolAfter: true, Group: ".", Decimal: ","},
+    "GBP": {Symbol: "£", Group: ",", Decimal: "."},
+    "INR": {Symbol: "₹", Group: ",", Decimal: ".", Indian: true},
+    "JPY": {Symbol: "¥", Group: ",", Decimal: "."},
+    "USD": {Symbol: "$", Group: ",", Decimal: "."},
+}
+
+// formatAmount writes amount in currency for display, e.g. "$1,234.56" or
+// "1.234,56 €", with as many decimals as the currency's minor units.
+func formatAmount(amount decimal.Decimal, currency string) string {
+    f, known := currencyFormats[currency]
+    if !known {
+        f = currencyFormat{Symbol: currency, SymbolAfter: true, Group: ",", Decimal: "."}
+    }
+
+    digits := amount.Abs().StringFixed(minorUnits(currency))
+    whole, frac, _ := strings.Cut(digits, ".")
+    number := groupDigits(whole, f.Group, f.Indian)
+    if frac != "" {
+        number += f.Decimal + frac
+    }
+
+    var b strings.Builder
+    if amount.IsNegative() {
+        b.WriteByte('-')
+    }
+    if f.SymbolAfter {
+        b.WriteString(number + " " + f.Symbol)
+    } else {
+        b.WriteString(f.Symbol + number)
+    }
+    return b.String()
+}
+
+// groupDigits separates whole into thousands with sep, or Indian style
+// into a thousand and then hundreds.
+func groupDigits(whole, sep string, indian bool) string {
+    if len(whole) <= 3 {
+        return whole
+    }
+    head, tail := whole[:len(whole)-3], whole[len(whole)-3:]
+    size := 3
+    if indian {
+        size = 2
+    }
+    var groups []string
+    for len(head) > size {
+        groups = append([]string{head[len(head)-size:]}, groups...)
+        head = head[:len(head)-size]
+    }
+    groups = append([]string{head}, groups...)
+    return strings.Join(append(groups, tail), sep)
+}
+
+// wantsDisplay reports whether the request asked, with ?format=display, for
+// amounts formatted for people alongside the decimal strings.
+func wantsDisplay(c *gin.Context) bool {
+    return c.Query("format") == "display"
+}
+
+// displayOrder returns order as the request asked to see it: if it asked
+// for display formatting, a copy with the formatted total filled in. The
+// order itself is left alone, since it may be shared with the list cache.
+func displayOrder(c *gin.Context, order *Order) *Order {
+    if !wantsDisplay(c) {
+        return order
+    }
+    shown := *order
+    shown.FormattedTotal = formatAmount(order.TotalAmount, order.Currency)
+    return &shown
+}
+
+// displayList is displayOrder for every order in list.
+func displayList(c *gin.Context, list OrderList) OrderList {
+    if !wantsDisplay(c) {
+        return list
+    }
+    shown := list
+    shown.Orders = make([]*Order, len(list.Orders))
+    for i, order := range list.Orders {
+        shown.Orders[i] = displayOrder(c, order)
+    }
+    return shown
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+
+    "github.com/shopspring/decimal"
+)
+
+func TestFormatAmount(t *testing.T) {
+    tests := []struct {
+        amount   string
+        currency string
+        want     string
+    }{
+        {"1234.56", "USD", "$1,234.56"},
+        {"1234567.5", "USD", "$1,234,567.50"},
+        {"999", "USD", "$999.00"},
+        {"0", "USD", "$0.00"},
+        {"-12.3", "USD", "-$12.30"},
+        {"1234.56", "EUR", "1.234,56 €"},
+        {"0.5", "EUR", "0,50 €"},
+        {"1234567", "JPY", "¥1,234,567"},
+        {"500", "JPY", "¥500"},
+        {"1234567.89", "INR", "₹12,34,567.89"},
+        {"1234.5", "CHF", "CHF 1'234.50"},
+        {"1234.5", "SEK", "1,234.50 SEK"},
+        {"1234.567", "KWD", "1,234.567 KWD"},
+    }
+    for _, tt := range tests {
+        if got := formatAmount(decimal.RequireFromString(tt.amount), tt.currency); got != tt.want {
+            t.Errorf("formatAmount(%s, %s) = %q, want %q", tt.amount, tt.currency, got, tt.want)
+        }
+    }
+}
+
+func TestGetOrderFormatDisplay(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+    body := `{"customer_id":"cust_123","currency":"EUR","items":[{"product_id":"prod_456","quantity":50,"price":"29.99"}]}`
+    w := doRequest(r, http.MethodPost, "/orders", body)
+    if w.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    var created Order
+    json.Unmarshal(w.Body.Bytes(), &created)
+    if created.FormattedTotal != "" {
+        t.Fatalf("got formatted total %q without asking for it", created.FormattedTotal)
+    }
+
+    var order Order
+    json.Unmarshal(doRequest(r, http.MethodGet, "/orders/"+created.OrderID.String()+"?format=display", "").Body.Bytes(), &order)
+    if order.FormattedTotal != "1.499,50 €" || order.TotalAmount.String() != "1499.5" {
+        t.Fatalf("got %q for total %s, want 1.499,50 € for 1499.5", order.FormattedTotal, order.TotalAmount)
+    }
+
+    var list OrderList
+    json.Unmarshal(doRequest(r, http.MethodGet, "/orders?format=display", "").Body.Bytes(), &list)
+    if len(list.Orders) != 1 || list.Orders[0].FormattedTotal != "1.499,50 €" {
+        t.Fatalf("got list %+v, want the formatted total", list)
+    }
+    // The cached list is the same one served without formatting.
+    var plain OrderList
+    json.Unmarshal(doRequest(r, http.MethodGet, "/orders", "").Body.Bytes(), &plain)
+    if plain.Orders[0].FormattedTotal != "" {
+        t.Fatalf("got formatted total %q without asking for it", plain.Orders[0].FormattedTotal)
+    }
+}
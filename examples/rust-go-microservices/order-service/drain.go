@@ -0,0 +1,95 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "sync"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+// HealthDraining is the readiness status of an instance on its way out. It
+// isn't a level of healthRank: draining says nothing about dependencies.
+const HealthDraining = "draining"
+
+// Drainer takes the instance out of its load balancer before it shuts
+// down. Once draining, readiness fails while liveness still passes, so the
+// load balancer stops sending requests without the instance being
+// restarted, and Delay later shutdown may go ahead with nothing new coming
+// in.
+type Drainer struct {
+    Delay time.Duration
+
+    mu        sync.Mutex
+    since     time.Time
+    requested chan struct{}
+}
+
+func NewDrainer(delay time.Duration) *Drainer {
+    return &Drainer{Delay: delay, requested: make(chan struct{})}
+}
+
+// drainer is the running Server's; readiness fails once it is draining.
+var drainer = NewDrainer(0)
+
+// Start begins draining, if it hadn't begun, and reports whether this call
+// began it.
+func (d *Drainer) Start() bool {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+
+    if !d.since.IsZero() {
+        return false
+    }
+    d.since = time.Now()
+    close(d.requested)
+    logger.Info("Draining", "drain_delay", d.Delay.String())
+    return true
+}
+
+// Draining reports whether Start has been called.
+func (d *Drainer) Draining() bool {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+
+    return !d.since.IsZero()
+}
+
+// Requested is closed once draining begins, so main can begin shutting
+// down when it is asked over the API rather than by a signal.
+func (d *Drainer) Requested() <-chan struct{} {
+    return d.requested
+}
+
+// Wait starts draining if it hadn't started and waits until Delay has
+// passed since it did, or until ctx is done.
+func (d *Drainer) Wait(ctx context.Context) {
+    d.Start()
+    d.mu.Lock()
+    remaining := time.Until(d.since.Add(d.Delay))
+    d.mu.Unlock()
+    if remaining <= 0 {
+        return
+    }
+
+    t := time.NewTimer(remaining)
+    defer t.Stop()
+    select {
+    case <-t.C:
+    case <-ctx.Done():
+    }
+}
+
+// drainInstance starts draining ahead of a deploy: readiness fails from
+// now on, and once the drain delay has passed the server shuts down as it
+// would on SIGTERM.
+func drainInstance(c *gin.Context) {
+    if drainer.Start() {
+        loggerFrom(c.Request.Context()).Warn("drain requested over the API")
+    }
+    c.JSON(http.StatusAccepted, gin.H{
+        "status":              HealthDraining,
+        "drain_delay_seconds": drainer.Delay.Seconds(),
+    })
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "testing"
+    "time"
+)
+
+func getStatus(t *testing.T, url string) int {
+    t.Helper()
+    resp, err := http.Get(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    return resp.StatusCode
+}
+
+func TestShutdownFailsReadinessBeforeClosing(t *testing.T) {
+    fake := newPaymentServer(t)
+    useServerGlobals(t)
+    const delay = 300 * time.Millisecond
+    srv, err := NewServer(Config{
+        Addr:       "127.0.0.1:0",
+        Payments:   NewPaymentClient(fake.URL),
+        DrainDelay: delay,
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := srv.Start(); err != nil {
+        t.Fatal(err)
+    }
+    base := "http://" + srv.Addr().String()
+    if code := getStatus(t, base+"/health/ready"); code != http.StatusOK {
+        t.Fatalf("ready before shutdown: got %d", code)
+    }
+
+    start := time.Now()
+    done := make(chan error, 1)
+    go func() { done <- srv.Shutdown(context.Background()) }()
+
+    // Readiness fails straight away, while the server still answers and
+    // liveness still passes.
+    deadline := time.Now().Add(delay / 2)
+    for getStatus(t, base+"/health/ready") != http.StatusServiceUnavailable {
+        if time.Now().After(deadline) {
+            t.Fatal("readiness still passing after shutdown began")
+        }
+        time.Sleep(5 * time.Millisecond)
+    }
+    if code := getStatus(t, base+"/health/live"); code != http.StatusOK {
+        t.Fatalf("liveness while draining: got %d", code)
+    }
+
+    if err := <-done; err != nil {
+        t.Fatal(err)
+    }
+    if elapsed := time.Since(start); elapsed < delay {
+        t.Fatalf("shutdown took %s, want at least the %s drain delay", elapsed, delay)
+    }
+}
+
+func TestAdminDrainRequestsShutdown(t *testing.T) {
+    newPaymentServer(t)
+    useServerGlobals(t)
+    useAPIKeys(t, "root:@admin,plain")
+    drainer = NewDrainer(time.Minute)
+    r := setupRouter()
+
+    if w := doRequestWithHeaders(r, http.MethodPost, "/admin/drain", "", bearer("plain")); w.Code != http.StatusForbidden {
+        t.Fatalf("non-admin drain got status %d, want 403", w.Code)
+    }
+    if w := doRequest(r, http.MethodGet, "/health/ready", ""); w.Code != http.StatusOK {
+        t.Fatalf("ready before draining: got %d", w.Code)
+    }
+
+    if w := doRequestWithHeaders(r, http.MethodPost, "/admin/drain", "", bearer("root")); w.Code != http.StatusAccepted {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    select {
+    case <-drainer.Requested():
+    default:
+        t.Fatal("drain not requested")
+    }
+    if w := doRequest(r, http.MethodGet, "/health/ready", ""); w.Code != http.StatusServiceUnavailable {
+        t.Fatalf("ready while draining: got %d", w.Code)
+    }
+    if w := doRequest(r, http.MethodGet, "/health/live", ""); w.Code != http.StatusOK {
+        t.Fatalf("liveness while draining: got %d", w.Code)
+    }
+}
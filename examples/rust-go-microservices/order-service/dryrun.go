@@ -0,0 +1,58 @@
+package main
+
+import (
+    "net/http"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+)
+
+const dryRunHeader = "X-Dry-Run"
+
+// OrderPreview is the response to a dry run: the order as it would be
+// created or updated, flagged so it can't be mistaken for the real one.
+type OrderPreview struct {
+    *Order
+    DryRun bool `json:"dry_run"`
+}
+
+// isDryRun reports whether the request asked for a preview with
+// ?dry_run=true or an X-Dry-Run: true header.
+func isDryRun(c *gin.Context) bool {
+    for _, v := range []string{c.Query("dry_run"), c.GetHeader(dryRunHeader)} {
+        if on, err := strconv.ParseBool(v); err == nil && on {
+            return true
+        }
+    }
+    return false
+}
+
+// previewOrder validates and prices the order in the request body and
+// returns it without storing it, reserving stock or charging anything. Its
+// ID is synthetic: no order with that ID will exist.
+func previewOrder(c *gin.Context) {
+    var order Order
+    ctx, ok := bindOrder(c, &order)
+    if !ok {
+        return
+    }
+    if rerr := channelFromHeader(c, &order); rerr != nil {
+        rerr.respond(c)
+        return
+    }
+    if !canAccess(c, order.CustomerID) {
+        respondError(c, http.StatusForbidden, CodeForbidden, "API key may not create orders for this customer")
+        return
+    }
+    if rerr := prepareOrder(ctx, &order); rerr != nil {
+        rerr.respond(c)
+        return
+    }
+    respondPreview(c, &order)
+}
+
+// respondPreview renders order as the result of a dry run.
+func respondPreview(c *gin.Context, order *Order) {
+    c.Header(dryRunHeader, "true")
+    c.JSON(http.StatusOK, OrderPreview{Order: order, DryRun: true})
+}
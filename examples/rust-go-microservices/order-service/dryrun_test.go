@@ -0,0 +1,140 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+
+    "github.com/shopspring/decimal"
+)
+
+func TestDryRunPreviewsOrder(t *testing.T) {
+    for name, req := range map[string]struct {
+        path    string
+        headers map[string]string
+    }{
+        "query param": {"/orders?dry_run=true", nil},
+        "header":      {"/orders", map[string]string{dryRunHeader: "true"}},
+    } {
+        t.Run(name, func(t *testing.T) {
+            resetOrders(t)
+            fake := newPaymentServer(t)
+            usePricing(t, TaxRates{Default: decimal.RequireFromString("0.1")}, ShippingRates{})
+            r := setupRouter()
+
+            w := doRequestWithHeaders(r, http.MethodPost, req.path, sampleOrder, req.headers)
+            if w.Code != http.StatusOK {
+                t.Fatalf("got status %d: %s", w.Code, w.Body)
+            }
+            if got := w.Header().Get(dryRunHeader); got != "true" {
+                t.Fatalf("got %s %q, want true", dryRunHeader, got)
+            }
+            if got := w.Header().Get("Location"); got != "" {
+                t.Fatalf("preview has Location %q", got)
+            }
+
+            var preview struct {
+                Order
+                DryRun bool `json:"dry_run"`
+            }
+            if err := json.Unmarshal(w.Body.Bytes(), &preview); err != nil {
+                t.Fatal(err)
+            }
+            if !preview.DryRun {
+                t.Fatal("response is not flagged as a dry run")
+            }
+            if preview.Tax.String() != "6" || preview.TotalAmount.String() != "65.98" {
+                t.Fatalf("got tax %s total %s, want 6 and 65.98", preview.Tax, preview.TotalAmount)
+            }
+
+            if _, err := orders.FindByID(preview.OrderID); err == nil {
+                t.Fatal("dry run stored the order")
+            }
+            if list, _ := orders.List(); len(list) != 0 {
+                t.Fatalf("dry run stored %d orders", len(list))
+            }
+            if got := fake.charges.Load(); got != 0 {
+                t.Fatalf("dry run made %d payment calls", got)
+            }
+        })
+    }
+}
+
+func TestDryRunReportsValidationErrors(t *testing.T) {
+    resetOrders(t)
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders?dry_run=true", `{"customer_id":"c","items":[{"product_id":"p","quantity":0,"price":"1"}]}`)
+    if w.Code != http.StatusUnprocessableEntity {
+        t.Fatalf("got status %d, want 422", w.Code)
+    }
+    if got := decodeError(t, w).Code; got != CodeValidationFailed {
+        t.Fatalf("got code %s", got)
+    }
+}
+
+func TestDryRunFalseCreatesOrder(t *testing.T) {
+    resetOrders(t)
+    newPaymentServer(t)
+    r := setupRouter()
+
+    if w := doRequest(r, http.MethodPost, "/orders?dry_run=false", sampleOrder); w.Code != http.StatusCreated {
+        t.Fatalf("got status %d, want 201", w.Code)
+    }
+}
+
+func TestDryRunPreviewsUpdate(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+    order := saveOrderWithStatus(StatusPending)
+    path := "/orders/" + order.OrderID.String() + "?dry_run=true"
+
+    // No If-Match is needed to preview.
+    body := `{"items":[{"product_id":"prod_456","quantity":3,"price":"29.99"}],"notes":"gift"}`
+    w := doRequest(r, http.MethodPatch, path, body)
+    if w.Code != http.StatusOK || w.Header().Get(dryRunHeader) != "true" {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    var preview struct {
+        Order
+        DryRun bool `json:"dry_run"`
+    }
+    json.Unmarshal(w.Body.Bytes(), &preview)
+    if !preview.DryRun || preview.TotalAmount.String() != "89.97" || len(preview.Items) != 1 || preview.Items[0].Quantity != 3 {
+        t.Fatalf("got preview %+v, want 3 items totalling 89.97", preview)
+    }
+    if len(preview.Amendments) != 1 || preview.Amendments[0].TotalAfter.String() != "89.97" {
+        t.Fatalf("got amendments %+v, want the would-be amendment", preview.Amendments)
+    }
+
+    stored, _ := orders.FindByID(order.OrderID)
+    if stored.Version != order.Version || !stored.TotalAmount.Equal(order.TotalAmount) ||
+        len(stored.Items) != len(order.Items) || stored.Notes != "" || len(stored.Amendments) != 0 {
+        t.Fatalf("dry run changed the stored order: %+v", stored)
+    }
+    if n := fake.charges.Load(); n != 0 {
+        t.Fatalf("got %d charges for a dry run", n)
+    }
+
+    // A stale If-Match is still caught.
+    stale := map[string]string{"If-Match": `"99"`}
+    if w := doRequestWithHeaders(r, http.MethodPatch, path, body, stale); w.Code != http.StatusConflict {
+        t.Fatalf("stale If-Match got status %d, want 409", w.Code)
+    }
+}
+
+func TestDryRunUpdateReportsValidationErrors(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+    order := saveOrderWithStatus(StatusPending)
+
+    w := doRequest(r, http.MethodPatch, "/orders/"+order.OrderID.String()+"?dry_run=true", `{"items":[{"product_id":"prod_456","quantity":0,"price":"29.99"}]}`)
+    if w.Code != http.StatusUnprocessableEntity {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    if fields := decodeValidationError(w).Fields; len(fields) != 1 || fields[0].Field != "items[0].quantity" {
+        t.Fatalf("got fields %+v", fields)
+    }
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "database/sql"
+    "encoding/base64"
+    "encoding/hex"
+    "fmt"
+    "os"
+    "strings"
+)
+
+// encryptedPrefix marks a column value as ciphertext. Values without it
+// were stored before encryption was turned on and are read as they are.
+const encryptedPrefix = "enc:v1:"
+
+// FieldCipher encrypts the sensitive columns of stored orders with AES-GCM:
+// the customer ID, payment method, addresses, metadata and notes. Status,
+// amounts and dates stay in the clear, so they can still be queried.
+//
+// Since the ciphertext of a customer ID differs on every save, orders are
+// found by customer through a keyed hash of it stored alongside.
+type FieldCipher struct {
+    aead     cipher.AEAD
+    indexKey []byte
+}
+
+// NewFieldCipher returns a FieldCipher for a 32-byte key, such as one
+// fetched from a KMS. Separate keys for encryption and the customer
+// index are derived from it.
+func NewFieldCipher(key []byte) (*FieldCipher, error) {
+    if len(key) != 32 {
+        return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+    }
+    block, err := aes.NewCipher(deriveKey(key, "order-service field encryption"))
+    if err != nil {
+        return nil, err
+    }
+    aead, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+    return &FieldCipher{aead: aead, indexKey: deriveKey(key, "order-service customer index")}, nil
+}
+
+func deriveKey(key []byte, purpose string) []byte {
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(purpose))
+    return mac.Sum(nil)
+}
+
+// fieldCipherFromEnv reads ORDER_ENCRYPTION_KEY, a base64-encoded 32-byte
+// key. Without it orders are stored unencrypted.
+func fieldCipherFromEnv() (*FieldCipher, error) {
+    raw := os.Getenv("ORDER_ENCRYPTION_KEY")
+    if raw == "" {
+        return nil, nil
+    }
+    key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(raw))
+    if err != nil {
+        return nil, fmt.Errorf("ORDER_ENCRYPTION_KEY: %w", err)
+    }
+    c, err := NewFieldCipher(key)
+    if err != nil {
+        return nil, fmt.Errorf("ORDER_ENCRYPTION_KEY: %w", err)
+    }
+    return c, nil
+}
+
+// seal encrypts value for column, which is bound into the ciphertext so it
+// can't be moved to another column. A nil FieldCipher leaves it as it is.
+func (c *FieldCipher) seal(column, value string) string {
+    if c == nil {
+        return value
+    }
+    nonce := make([]byte, c.aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        panic(fmt.Sprintf("reading random nonce: %v", err))
+    }
+    sealed := c.aead.Seal(nonce, nonce, []byte(value), []byte(column))
+    return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed)
+}
+
+// open reverses seal. Plaintext values pass through; ciphertext without a
+// FieldCipher, or under another key, is an error.
+func (c *FieldCipher) open(column, stored string) (string, error) {
+    encoded, ok := strings.CutPrefix(stored, encryptedPrefix)
+    if !ok {
+        return stored, nil
+    }
+    if c == nil {
+        return "", fmt.Errorf("column %s is encrypted and no encryption key is configured", column)
+    }
+    sealed, err := base64.StdEncoding.DecodeString(encoded)
+    if err != nil {
+        return "", fmt.Errorf("column %s: %w", column, err)
+    }
+    if len(sealed) < c.aead.NonceSize() {
+        return "", fmt.Errorf("column %s: ciphertext too short", column)
+    }
+    nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+    plain, err := c.aead.Open(nil, nonce, ciphertext, []byte(column))
+    if err != nil {
+        return "", fmt.Errorf("column %s: decryption failed; wrong key?", column)
+    }
+    return string(plain), nil
+}
+
+func (c *FieldCipher) sealNull(column string, value sql.NullString) sql.NullString {
+    if !value.Valid {
+        return value
+    }
+    return sql.NullString{String: c.seal(column, value.String), Valid: true}
+}
+
+func (c *FieldCipher) openNull(column string, stored sql.NullString) (sql.NullString, error) {
+    if !stored.Valid {
+        return stored, nil
+    }
+    value, err := c.open(column, stored.String)
+    return sql.NullString{String: value, Valid: true}, err
+}
+
+// customerIndex is the keyed hash orders are looked up by customer with,
+// or NULL without a FieldCipher.
+func (c *FieldCipher) customerIndex(customerID string) sql.NullString {
+    if c == nil {
+        return sql.NullString{}
+    }
+    mac := hmac.New(sha256.New, c.indexKey)
+    mac.Write([]byte(customerID))
+    return sql.NullString{String: hex.EncodeToString(mac.Sum(nil)), Valid: true}
+}
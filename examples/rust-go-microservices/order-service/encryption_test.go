@@ -0,0 +1,162 @@
+package main
+
+import (
+    "bytes"
+    "encoding/base64"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/shopspring/decimal"
+)
+
+func testFieldCipher(t *testing.T, fill byte) *FieldCipher {
+    t.Helper()
+
+    c, err := NewFieldCipher(bytes.Repeat([]byte{fill}, 32))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return c
+}
+
+func openEncryptedTestSQLite(t *testing.T, path string, c *FieldCipher) *SQLiteRepository {
+    t.Helper()
+
+    repo, err := OpenEncryptedSQLiteRepository(path, c)
+    if err != nil {
+        t.Fatalf("open sqlite: %v", err)
+    }
+    t.Cleanup(func() { repo.Close() })
+    return repo
+}
+
+func sensitiveOrder() *Order {
+    return &Order{
+        OrderID:    uuid.New(),
+        CustomerID: "cust_secret",
+        Items: []OrderItem{
+            {ProductID: "prod_456", Quantity: 2, Price: decimal.RequireFromString("29.99")},
+        },
+        Currency:        "USD",
+        TotalAmount:     decimal.RequireFromString("59.98"),
+        Status:          StatusConfirmed,
+        CreatedAt:       time.Now(),
+        PaymentMethod:   &PaymentMethod{Type: PaymentMethodBankTransfer, BankTransfer: &BankTransferDetails{AccountHolder: "Ada Holder", AccountLast4: "4321"}},
+        ShippingAddress: &Address{Line1: "1 Secret Lane", City: "Hidden", PostalCode: "12345", Country: "US"},
+        Metadata:        map[string]string{"crm": "secret-ref"},
+        Notes:           "leave with the secret neighbour",
+    }
+}
+
+func TestEncryptedSQLiteRoundTrip(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "orders.db")
+    order := sensitiveOrder()
+
+    first := openEncryptedTestSQLite(t, path, testFieldCipher(t, 1))
+    if err := first.Save(order); err != nil {
+        t.Fatalf("Save: %v", err)
+    }
+    first.Close()
+
+    repo := openEncryptedTestSQLite(t, path, testFieldCipher(t, 1))
+    got, err := repo.FindByID(order.OrderID)
+    if err != nil {
+        t.Fatalf("FindByID: %v", err)
+    }
+    if got.CustomerID != order.CustomerID || got.Notes != order.Notes || got.Metadata["crm"] != "secret-ref" {
+        t.Fatalf("got %+v, want %+v", got, order)
+    }
+    if got.PaymentMethod == nil || got.PaymentMethod.BankTransfer == nil || got.PaymentMethod.BankTransfer.AccountHolder != "Ada Holder" {
+        t.Fatalf("payment method not round-tripped: %+v", got.PaymentMethod)
+    }
+    if got.ShippingAddress == nil || got.ShippingAddress.Line1 != "1 Secret Lane" {
+        t.Fatalf("shipping address not round-tripped: %+v", got.ShippingAddress)
+    }
+    list, err := repo.ListByCustomer("cust_secret")
+    if err != nil || len(list) != 1 || list[0].OrderID != order.OrderID {
+        t.Fatalf("ListByCustomer = %v, %v; want the order", list, err)
+    }
+}
+
+func TestEncryptedSQLiteStoresCiphertext(t *testing.T) {
+    repo := openEncryptedTestSQLite(t, filepath.Join(t.TempDir(), "orders.db"), testFieldCipher(t, 1))
+    order := sensitiveOrder()
+    if err := repo.Save(order); err != nil {
+        t.Fatalf("Save: %v", err)
+    }
+
+    var customerID, paymentMethod, shippingAddress, metadata, notes, status, total string
+    err := repo.db.QueryRow(`SELECT customer_id, payment_method, shipping_address, metadata, notes, status, total_amount
+        FROM orders WHERE order_id = ?`, order.OrderID.String()).
+        Scan(&customerID, &paymentMethod, &shippingAddress, &metadata, &notes, &status, &total)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for column, value := range map[string]string{
+        "customer_id": customerID, "payment_method": paymentMethod, "shipping_address": shippingAddress,
+        "metadata": metadata, "notes": notes,
+    } {
+        if !strings.HasPrefix(value, encryptedPrefix) || strings.Contains(value, "ecret") {
+            t.Errorf("%s stored as %q, want ciphertext", column, value)
+        }
+    }
+    if status != StatusConfirmed || total != "59.98" {
+        t.Errorf("got status %q, total %q in the clear; want confirmed, 59.98", status, total)
+    }
+}
+
+func TestEncryptedSQLiteRejectsWrongKey(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "orders.db")
+    order := sensitiveOrder()
+    first := openEncryptedTestSQLite(t, path, testFieldCipher(t, 1))
+    if err := first.Save(order); err != nil {
+        t.Fatalf("Save: %v", err)
+    }
+    first.Close()
+
+    if _, err := openEncryptedTestSQLite(t, path, testFieldCipher(t, 2)).FindByID(order.OrderID); err == nil {
+        t.Fatal("read with the wrong key succeeded")
+    }
+    if _, err := openTestSQLite(t, path).FindByID(order.OrderID); err == nil {
+        t.Fatal("read without a key succeeded")
+    }
+}
+
+func TestEncryptedSQLiteReadsUnencryptedOrders(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "orders.db")
+    order := sensitiveOrder()
+    plain := openTestSQLite(t, path)
+    if err := plain.Save(order); err != nil {
+        t.Fatalf("Save: %v", err)
+    }
+    plain.Close()
+
+    repo := openEncryptedTestSQLite(t, path, testFieldCipher(t, 1))
+    got, err := repo.FindByID(order.OrderID)
+    if err != nil || got.CustomerID != order.CustomerID {
+        t.Fatalf("FindByID = %+v, %v", got, err)
+    }
+    if list, err := repo.ListByCustomer(order.CustomerID); err != nil || len(list) != 1 {
+        t.Fatalf("ListByCustomer = %v, %v; want the unencrypted order", list, err)
+    }
+}
+
+func TestFieldCipherFromEnv(t *testing.T) {
+    t.Setenv("ORDER_ENCRYPTION_KEY", "")
+    if c, err := fieldCipherFromEnv(); c != nil || err != nil {
+        t.Fatalf("unset: got %v, %v; want no cipher", c, err)
+    }
+    for _, raw := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("too short"))} {
+        t.Setenv("ORDER_ENCRYPTION_KEY", raw)
+        if _, err := fieldCipherFromEnv(); err == nil {
+            t.Errorf("%q: expected error", raw)
+        }
+    }
+    t.Setenv("ORDER_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+    if c, err := fieldCipherFromEnv(); c == nil || err != nil {
+        t.Fatalf("got %v, %v; want a cipher", c, err)
+    }
+}
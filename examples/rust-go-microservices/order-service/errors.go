@@ -0,0 +1,77 @@
+package main
+
+import "github.com/gin-gonic/gin"
+
+// Error codes are part of the API: clients branch on them, so existing codes
+// must never change meaning.
+const (
+    CodeInvalidRequest          = "INVALID_REQUEST"
+    CodeRequestTooLarge         = "REQUEST_TOO_LARGE"
+    CodeInvalidOrderID          = "INVALID_ORDER_ID"
+    CodeValidationFailed        = "VALIDATION_FAILED"
+    CodeOrderNotFound           = "ORDER_NOT_FOUND"
+    CodeItemNotFound            = "ITEM_NOT_FOUND"
+    CodeInvalidStatusTransition = "INVALID_STATUS_TRANSITION"
+    CodeVersionConflict         = "VERSION_CONFLICT"
+    CodeOrderIDInUse            = "ORDER_ID_IN_USE"
+    CodePreconditionRequired    = "PRECONDITION_REQUIRED"
+    CodeTotalMismatch           = "TOTAL_MISMATCH"
+    CodeInvalidCoupon           = "INVALID_COUPON"
+    CodeOutOfStock              = "OUT_OF_STOCK"
+    CodeInventoryUnavailable    = "INVENTORY_UNAVAILABLE"
+    CodePaymentUnavailable      = "PAYMENT_UNAVAILABLE"
+    CodeIdempotencyUnavailable  = "IDEMPOTENCY_UNAVAILABLE"
+    CodePaymentFailed           = "PAYMENT_FAILED"
+    CodeFraudDeclined           = "FRAUD_DECLINED"
+    CodeExchangeRateUnavailable = "EXCHANGE_RATE_UNAVAILABLE"
+    CodeRefundFailed            = "REFUND_FAILED"
+    CodeRefundExceedsBalance    = "REFUND_EXCEEDS_BALANCE"
+    CodeRateLimited             = "RATE_LIMITED"
+    CodeOverloaded              = "OVERLOADED"
+    CodeInvalidSignature        = "INVALID_SIGNATURE"
+    CodeUnauthorized            = "UNAUTHORIZED"
+    CodeForbidden               = "FORBIDDEN"
+    CodeRequestCancelled        = "REQUEST_CANCELLED"
+    CodeTimeout                 = "TIMEOUT"
+    CodeInternal                = "INTERNAL_ERROR"
+)
+
+// APIError is the body of every error response, wrapped as {"error": ...}.
+// Message is for humans; Code is what clients should match on.
+type APIError struct {
+    Code    string      `json:"code"`
+    Message string      `json:"message"`
+    Details interface{} `json:"details,omitempty"`
+}
+
+type errorResponse struct {
+    Error APIError `json:"error"`
+}
+
+// respondError writes an error response and stops the handler chain.
+func respondError(c *gin.Context, status int, code, msg string) {
+    respondErrorDetails(c, status, code, msg, nil)
+}
+
+// respondErrorDetails is respondError with structured details, e.g. the
+// invalid fields of a request.
+func respondErrorDetails(c *gin.Context, status int, code, msg string, details interface{}) {
+    c.AbortWithStatusJSON(status, errorResponse{Error: APIError{Code: code, Message: msg, Details: details}})
+}
+
+// requestError is an error response that has not been written yet, for code
+// that reports failures without a request of its own, such as each order in
+// a batch.
+type requestError struct {
+    Status int
+    APIError
+}
+
+func newRequestError(status int, code, msg string) *requestError {
+    return &requestError{Status: status, APIError: APIError{Code: code, Message: msg}}
+}
+
+// respond writes the error response and stops the handler chain.
+func (e *requestError) respond(c *gin.Context) {
+    respondErrorDetails(c, e.Status, e.Code, e.Message, e.Details)
+}
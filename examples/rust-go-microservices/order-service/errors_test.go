@@ -0,0 +1,116 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// decodeError decodes an error response body, failing the test if it
+// doesn't have the APIError shape.
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) APIError {
+    t.Helper()
+
+    var resp errorResponse
+    if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error.Code == "" || resp.Error.Message == "" {
+        t.Fatalf("not an error response: %s", w.Body)
+    }
+    return resp.Error
+}
+
+// decodeValidationError decodes the field errors of a VALIDATION_FAILED
+// response.
+func decodeValidationError(w *httptest.ResponseRecorder) *ValidationError {
+    var resp struct {
+        Error struct {
+            Details ValidationError `json:"details"`
+        } `json:"error"`
+    }
+    json.Unmarshal(w.Body.Bytes(), &resp)
+    return &resp.Error.Details
+}
+
+func TestErrorCodes(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    shipped := saveOrderWithStatus(StatusShipped)
+    pending := saveOrderWithStatus(StatusPending)
+    confirmed := saveOrderWithStatus(StatusConfirmed)
+    items := `{"items":[{"product_id":"p","quantity":1,"price":"1.00"}]}`
+
+    tests := []struct {
+        name       string
+        method     string
+        path       string
+        body       string
+        headers    map[string]string
+        wantStatus int
+        wantCode   string
+    }{
+        {"invalid ID", http.MethodGet, "/orders/not-a-uuid", "", nil, http.StatusBadRequest, CodeInvalidOrderID},
+        {"unknown order", http.MethodGet, "/orders/" + uuid.NewString(), "", nil, http.StatusNotFound, CodeOrderNotFound},
+        {"malformed JSON", http.MethodPost, "/orders", `{"customer_id":`, nil, http.StatusBadRequest, CodeInvalidRequest},
+        {"invalid order", http.MethodPost, "/orders", `{"customer_id":"c","items":[]}`, nil, http.StatusUnprocessableEntity, CodeValidationFailed},
+        {"total mismatch", http.MethodPost, "/orders", `{"customer_id":"c","items":[{"product_id":"p","quantity":1,"price":"1"}],"expected_total":"2"}`, nil, http.StatusConflict, CodeTotalMismatch},
+        {"bad pagination", http.MethodGet, "/orders?limit=0", "", nil, http.StatusBadRequest, CodeInvalidRequest},
+        {"cancel shipped", http.MethodPost, "/orders/" + shipped.OrderID.String() + "/cancel", "", ifMatch(shipped), http.StatusConflict, CodeInvalidStatusTransition},
+        {"refund pending", http.MethodPost, "/orders/" + pending.OrderID.String() + "/refund", "", nil, http.StatusConflict, CodeInvalidStatusTransition},
+        {"refund too much", http.MethodPost, "/orders/" + confirmed.OrderID.String() + "/refund", `{"amount":"100"}`, nil, http.StatusUnprocessableEntity, CodeRefundExceedsBalance},
+        {"update confirmed", http.MethodPatch, "/orders/" + confirmed.OrderID.String(), items, ifMatch(confirmed), http.StatusConflict, CodeInvalidStatusTransition},
+        {"stale version", http.MethodPost, "/orders/" + pending.OrderID.String() + "/cancel", "", map[string]string{"If-Match": "0"}, http.StatusConflict, CodeVersionConflict},
+        {"missing If-Match", http.MethodPost, "/orders/" + pending.OrderID.String() + "/cancel", "", nil, http.StatusPreconditionRequired, CodePreconditionRequired},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            w := doRequestWithHeaders(r, tt.method, tt.path, tt.body, tt.headers)
+            if w.Code != tt.wantStatus {
+                t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+            }
+            if got := decodeError(t, w); got.Code != tt.wantCode {
+                t.Fatalf("got code %s, want %s", got.Code, tt.wantCode)
+            }
+        })
+    }
+
+    t.Run("payment failed", func(t *testing.T) {
+        fake.Close()
+        payments.sleep = func(ctx context.Context, d time.Duration) error { return nil }
+
+        w := doRequest(r, http.MethodPost, "/orders", sampleOrder)
+        if w.Code != http.StatusBadRequest || decodeError(t, w).Code != CodePaymentFailed {
+            t.Fatalf("got %d %s, want 400 %s", w.Code, w.Body, CodePaymentFailed)
+        }
+    })
+
+    t.Run("payment unavailable", func(t *testing.T) {
+        payments.Breaker = NewCircuitBreaker(1, time.Minute)
+        payments.Breaker.Record(false)
+
+        w := doRequest(r, http.MethodPost, "/orders", sampleOrder)
+        if w.Code != http.StatusServiceUnavailable || decodeError(t, w).Code != CodePaymentUnavailable {
+            t.Fatalf("got %d %s, want 503 %s", w.Code, w.Body, CodePaymentUnavailable)
+        }
+    })
+}
+
+func TestValidationErrorDetails(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", `{"customer_id":"c","items":[{"product_id":"p","quantity":0,"price":"1"}]}`)
+    fields := decodeValidationError(w).Fields
+    if decodeError(t, w).Message != "Validation failed" || len(fields) != 1 || fields[0].Field != "items[0].quantity" {
+        t.Fatalf("got %s", w.Body)
+    }
+    if fields[0].Message == "" {
+        t.Fatal("field error has no message")
+    }
+}
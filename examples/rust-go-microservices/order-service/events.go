@@ -0,0 +1,239 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "os"
+    "sync"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+const (
+    EventOrderCreated       = "order.created"
+    EventOrderConfirmed     = "order.confirmed"
+    EventOrderPaymentFailed = "order.payment_failed"
+    // EventOrderPaymentMismatch announces an order whose payment was
+    // approved for the wrong amount.
+    EventOrderPaymentMismatch = "order.payment_mismatch"
+
+    defaultEventBuffer = 1024
+)
+
+// OrderEvent tells downstream services (shipping, notifications) that an
+// order changed.
+type OrderEvent struct {
+    // EventID is unique per event, so consumers can drop the duplicates
+    // that at-least-once delivery allows.
+    EventID   uuid.UUID `json:"event_id"`
+    Type      string    `json:"type"`
+    OrderID   uuid.UUID `json:"order_id"`
+    Status    string    `json:"status"`
+    Timestamp time.Time `json:"timestamp"`
+}
+
+func newOrderEvent(eventType string, order *Order) OrderEvent {
+    return OrderEvent{EventID: uuid.New(), Type: eventType, OrderID: order.OrderID, Status: order.Status, Timestamp: clock.Now()}
+}
+
+// EventPublisher delivers order events. Publishing is best-effort: it must
+// not block the request path, and a failure to publish never fails the
+// request that caused the event.
+type EventPublisher interface {
+    Publish(ctx context.Context, event OrderEvent) error
+}
+
+var events EventPublisher = NoopPublisher{}
+
+// NoopPublisher discards every event.
+type NoopPublisher struct{}
+
+func (NoopPublisher) Publish(context.Context, OrderEvent) error { return nil }
+
+// RecordingPublisher keeps every event in memory, for tests.
+type RecordingPublisher struct {
+    mu     sync.Mutex
+    events []OrderEvent
+}
+
+func (p *RecordingPublisher) Publish(_ context.Context, event OrderEvent) error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    p.events = append(p.events, event)
+    return nil
+}
+
+// Events returns a copy of the events published so far.
+func (p *RecordingPublisher) Events() []OrderEvent {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    return append([]OrderEvent(nil), p.events...)
+}
+
+// Broker is the transport a BrokerPublisher sends events over, e.g. a
+// message queue client.
+type Broker interface {
+    Send(ctx context.Context, topic string, payload []byte) error
+}
+
+// ErrEventQueueFull is returned when an event is dropped because the
+// publisher's buffer is full.
+var ErrEventQueueFull = errors.New("event queue full")
+
+// BrokerPublisher queues events in a buffered channel and sends them to a
+// Broker from a background worker, so a slow broker never delays a request.
+// When the buffer is full, events are dropped. An event the broker keeps
+// refusing is given up on after Attempts tries and kept in deadLetters, if
+// there are any, or else dropped.
+type BrokerPublisher struct {
+    broker      Broker
+    queue       chan OrderEvent
+    done        chan struct{}
+    deadLetters DeadLetterStore
+
+    // Attempts and retryDelay, the wait before the first retry, which
+    // doubles before each one after, may only be changed before the first
+    // Publish.
+    Attempts   int
+    retryDelay time.Duration
+}
+
+func NewBrokerPublisher(broker Broker, buffer int, deadLetters DeadLetterStore) *BrokerPublisher {
+    p := &BrokerPublisher{
+        broker:      broker,
+        queue:       make(chan OrderEvent, buffer),
+        done:        make(chan struct{}),
+        deadLetters: deadLetters,
+        Attempts:    defaultEventDeliveryAttempts,
+        retryDelay:  defaultEventRetryDelay,
+    }
+    go p.run()
+    return p
+}
+
+func (p *BrokerPublisher) Publish(_ context.Context, event OrderEvent) error {
+    select {
+    case p.queue <- event:
+        return nil
+    default:
+        return ErrEventQueueFull
+    }
+}
+
+func (p *BrokerPublisher) run() {
+    defer close(p.done)
+
+    for event := range p.queue {
+        if err := p.deliver(event); err != nil {
+            p.deadLetter(event, err)
+        }
+    }
+}
+
+// deliver sends event, retrying up to Attempts times in all.
+func (p *BrokerPublisher) deliver(event OrderEvent) error {
+    payload, err := json.Marshal(event)
+    if err != nil {
+        return err
+    }
+    delay := p.retryDelay
+    for attempt := 1; ; attempt++ {
+        err = p.broker.Send(context.Background(), event.Type, payload)
+        if err == nil || attempt >= p.Attempts {
+            return err
+        }
+        time.Sleep(delay)
+        delay *= 2
+    }
+}
+
+// deadLetter keeps event, which couldn't be delivered because of err, for
+// replaying.
+func (p *BrokerPublisher) deadLetter(event OrderEvent, err error) {
+    log := logger.With("type", event.Type, "order_id", event.OrderID, "event_id", event.EventID, "error", err)
+    if p.deadLetters == nil {
+        log.Warn("failed to deliver event")
+        return
+    }
+    letter := DeadLetter{ID: uuid.New(), Event: event, Reason: err.Error(), Attempts: p.Attempts, FailedAt: clock.Now()}
+    if err := p.deadLetters.Add(letter); err != nil {
+        log.Error("failed to deliver event or dead-letter it", "dead_letter_error", err)
+        return
+    }
+    log.Warn("failed to deliver event; dead-lettered it")
+}
+
+// Close stops accepting events and waits for queued ones to be sent. No
+// Publish may be called after Close.
+func (p *BrokerPublisher) Close() {
+    close(p.queue)
+    <-p.done
+}
+
+// HTTPBroker posts each event as JSON to a URL, with the topic in the
+// X-Event-Type header; adapters for real message queues can sit behind it.
+type HTTPBroker struct {
+    URL        string
+    HTTPClient *http.Client
+}
+
+func (b *HTTPBroker) Send(ctx context.Context, topic string, payload []byte) error {
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.URL, bytes.NewReader(payload))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("X-Event-Type", topic)
+
+    resp, err := b.HTTPClient.Do(req)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("event broker returned status %d", resp.StatusCode)
+    }
+    return nil
+}
+
+// newEventBrokerFromEnv returns an HTTPBroker posting to EVENT_BROKER_URL,
+// or nil when it is unset.
+func newEventBrokerFromEnv() (*HTTPBroker, error) {
+    raw := os.Getenv("EVENT_BROKER_URL")
+    if raw == "" {
+        return nil, nil
+    }
+    brokerURL, err := parseServiceURL(raw)
+    if err != nil {
+        return nil, fmt.Errorf("EVENT_BROKER_URL: %w", err)
+    }
+    return &HTTPBroker{URL: brokerURL, HTTPClient: &http.Client{Timeout: 5 * time.Second}}, nil
+}
+
+// newEventPublisherFromEnv returns a BrokerPublisher for broker, keeping
+// what it can't deliver in deadLetters, or a NoopPublisher when broker is
+// nil.
+func newEventPublisherFromEnv(broker *HTTPBroker, deadLetters DeadLetterStore) (EventPublisher, error) {
+    if broker == nil {
+        return NoopPublisher{}, nil
+    }
+    buffer, err := envInt("EVENT_BUFFER_SIZE", defaultEventBuffer)
+    if err != nil {
+        return nil, err
+    }
+    attempts, err := envInt("EVENT_DELIVERY_ATTEMPTS", defaultEventDeliveryAttempts)
+    if err != nil {
+        return nil, err
+    }
+    p := NewBrokerPublisher(broker, buffer, deadLetters)
+    p.Attempts = attempts
+    return p, nil
+}
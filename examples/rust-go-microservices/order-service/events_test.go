@@ -0,0 +1,115 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// recordEvents swaps in a RecordingPublisher for the duration of the test.
+func recordEvents(t *testing.T) *RecordingPublisher {
+    t.Helper()
+
+    rec := &RecordingPublisher{}
+    prev := events
+    events = rec
+    t.Cleanup(func() { events = prev })
+    return rec
+}
+
+func eventTypes(evs []OrderEvent) []string {
+    types := make([]string, len(evs))
+    for i, e := range evs {
+        types[i] = e.Type
+    }
+    return types
+}
+
+func TestCreateOrderPublishesEvents(t *testing.T) {
+    tests := []struct {
+        paymentStatus string
+        want          []string
+    }{
+        {"approved", []string{EventOrderCreated, EventOrderConfirmed}},
+        {"declined", []string{EventOrderCreated, EventOrderPaymentFailed}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.paymentStatus, func(t *testing.T) {
+            fake := newPaymentServer(t)
+            fake.status = tt.paymentStatus
+            resetOrders(t)
+            rec := recordEvents(t)
+            r := setupRouter()
+
+            doRequest(r, http.MethodPost, "/orders", sampleOrder)
+
+            evs := rec.Events()
+            got := eventTypes(evs)
+            if len(got) != len(tt.want) || got[0] != tt.want[0] || got[1] != tt.want[1] {
+                t.Fatalf("got events %v, want %v", got, tt.want)
+            }
+            if evs[0].OrderID == uuid.Nil || evs[0].OrderID != evs[1].OrderID || evs[1].Timestamp.IsZero() {
+                t.Fatalf("events missing order details: %+v", evs)
+            }
+        })
+    }
+}
+
+func TestCreateOrderPublishesNothingWhenRejected(t *testing.T) {
+    resetOrders(t)
+    rec := recordEvents(t)
+    r := setupRouter()
+
+    doRequest(r, http.MethodPost, "/orders", `{"customer_id":"c","items":[]}`)
+    if evs := rec.Events(); len(evs) != 0 {
+        t.Fatalf("got events %v for a rejected order", eventTypes(evs))
+    }
+}
+
+// blockingBroker records sends, each of which waits for release.
+type blockingBroker struct {
+    mu      sync.Mutex
+    topics  []string
+    release chan struct{}
+}
+
+func (b *blockingBroker) Send(ctx context.Context, topic string, payload []byte) error {
+    <-b.release
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.topics = append(b.topics, topic)
+    return nil
+}
+
+func TestBrokerPublisherNeverBlocks(t *testing.T) {
+    broker := &blockingBroker{release: make(chan struct{})}
+    p := NewBrokerPublisher(broker, 1, nil)
+
+    // The worker holds the first event while the second fills the buffer;
+    // the third must be dropped immediately rather than wait.
+    event := OrderEvent{Type: EventOrderCreated}
+    p.Publish(context.Background(), event)
+    time.Sleep(20 * time.Millisecond)
+    p.Publish(context.Background(), event)
+
+    done := make(chan error, 1)
+    go func() { done <- p.Publish(context.Background(), event) }()
+    select {
+    case err := <-done:
+        if err != ErrEventQueueFull {
+            t.Fatalf("got %v, want ErrEventQueueFull", err)
+        }
+    case <-time.After(time.Second):
+        t.Fatal("Publish blocked on a full queue")
+    }
+
+    close(broker.release)
+    p.Close()
+    if len(broker.topics) != 2 {
+        t.Fatalf("broker received %d events, want 2", len(broker.topics))
+    }
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+    "context"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+const (
+    defaultPendingOrderTTL     = 30 * time.Minute
+    defaultExpirySweepInterval = time.Minute
+)
+
+// pendingOrderTTL is how long a new order may stay pending before it
+// expires.
+var pendingOrderTTL = defaultPendingOrderTTL
+
+// backgroundJob is a periodic job that runs until its context is done.
+type backgroundJob interface {
+    Run(ctx context.Context)
+}
+
+// ExpirySweeper moves pending orders past their ExpiresAt to expired and
+// releases the stock reserved for them.
+type ExpirySweeper struct {
+    Interval time.Duration
+
+    now   func() time.Time
+    after func(d time.Duration) <-chan time.Time
+}
+
+func NewExpirySweeper(interval time.Duration) *ExpirySweeper {
+    return &ExpirySweeper{Interval: interval, now: currentTime, after: time.After}
+}
+
+// newExpirySweeperFromEnv reads EXPIRY_SWEEP_INTERVAL.
+func newExpirySweeperFromEnv() (*ExpirySweeper, error) {
+    interval, err := envDuration("EXPIRY_SWEEP_INTERVAL", defaultExpirySweepInterval)
+    if err != nil {
+        return nil, err
+    }
+    return NewExpirySweeper(interval), nil
+}
+
+// Run sweeps every Interval until ctx is done.
+func (s *ExpirySweeper) Run(ctx context.Context) {
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-s.after(s.Interval):
+        }
+        if n, err := s.sweepOnce(ctx); err != nil {
+            logger.Error("expiry sweep failed", "error", err)
+        } else if n > 0 {
+            logger.Info("expired pending orders", "expired", n)
+        }
+    }
+}
+
+// sweepOnce expires every pending order past its ExpiresAt and returns how
+// many it expired.
+func (s *ExpirySweeper) sweepOnce(ctx context.Context) (int, error) {
+    all, err := orders.List()
+    if err != nil {
+        return 0, err
+    }
+
+    expired := 0
+    for _, order := range all {
+        if ctx.Err() != nil {
+            return expired, nil
+        }
+        if !s.due(order) {
+            continue
+        }
+        ok, err := s.expire(ctx, order.OrderID)
+        if err != nil {
+            logger.Warn("failed to expire order", "order_id", order.OrderID, "error", err)
+            continue
+        }
+        if ok {
+            expired++
+        }
+    }
+    return expired, nil
+}
+
+func (s *ExpirySweeper) due(order *Order) bool {
+    return order.Status == StatusPending && order.DeletedAt == nil &&
+        order.ExpiresAt != nil && !s.now().Before(*order.ExpiresAt)
+}
+
+// expire expires one order under its lock, re-checking it first in case it
+// was confirmed in the meantime, and reports whether it did.
+func (s *ExpirySweeper) expire(ctx context.Context, id uuid.UUID) (bool, error) {
+    unlock := orderLocks.lock(id)
+    defer unlock()
+
+    order, err := orders.FindByID(id)
+    if err != nil {
+        return false, err
+    }
+    if !s.due(order) {
+        return false, nil
+    }
+
+    transitionStatus(order, StatusExpired, "not paid within "+pendingOrderTTL.String())
+    releaseStock(ctx, order)
+    if err := orders.Save(order); err != nil {
+        return false, err
+    }
+    return true, nil
+}
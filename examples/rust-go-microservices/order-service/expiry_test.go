@@ -0,0 +1,122 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "testing"
+    "time"
+)
+
+func newTestExpirySweeper(now time.Time) *ExpirySweeper {
+    s := NewExpirySweeper(time.Minute)
+    s.now = func() time.Time { return now }
+    return s
+}
+
+// saveReservedPendingOrder stores a pending order expiring at expiresAt that
+// holds a reservation for two units of prod_456.
+func saveReservedPendingOrder(t *testing.T, expiresAt time.Time) *Order {
+    t.Helper()
+
+    order := saveOrderWithStatus(StatusPending)
+    reservationID, err := inventory.Reserve(context.Background(), order.OrderID, "prod_456", 2)
+    if err != nil {
+        t.Fatal(err)
+    }
+    order.ExpiresAt = &expiresAt
+    order.ReservationIDs = []string{reservationID}
+    orders.Save(order)
+    return order
+}
+
+func TestNewOrderExpiresAfterTTL(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    var order Order
+    json.Unmarshal(doRequest(r, http.MethodPost, "/orders", sampleOrder).Body.Bytes(), &order)
+    if order.ExpiresAt == nil || !order.ExpiresAt.Equal(order.CreatedAt.Add(pendingOrderTTL)) {
+        t.Fatalf("got expires_at %v for created_at %v, want %s later", order.ExpiresAt, order.CreatedAt, pendingOrderTTL)
+    }
+}
+
+func TestSweeperExpiresPendingOrder(t *testing.T) {
+    resetOrders(t)
+    fake := newInventoryServer(t, map[string]int{"prod_456": 10})
+    now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+    order := saveReservedPendingOrder(t, now.Add(-time.Second))
+    r := setupRouter()
+
+    n, err := newTestExpirySweeper(now).sweepOnce(context.Background())
+    if err != nil || n != 1 {
+        t.Fatalf("got %d expired, %v; want 1", n, err)
+    }
+
+    var got Order
+    json.Unmarshal(doRequest(r, http.MethodGet, "/orders/"+order.OrderID.String(), "").Body.Bytes(), &got)
+    if got.Status != StatusExpired {
+        t.Fatalf("got status %s, want expired", got.Status)
+    }
+    if fake.released != 1 || fake.stock["prod_456"] != 10 {
+        t.Fatalf("reservation not released: released %d, stock %d", fake.released, fake.stock["prod_456"])
+    }
+}
+
+func TestSweeperLeavesOrdersConfirmedBeforeExpiry(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    fake := newInventoryServer(t, map[string]int{"prod_456": 10})
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", sampleOrder)
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if order.Status != StatusConfirmed {
+        t.Fatalf("got status %s, want confirmed", order.Status)
+    }
+
+    n, err := newTestExpirySweeper(order.ExpiresAt.Add(time.Hour)).sweepOnce(context.Background())
+    if err != nil || n != 0 {
+        t.Fatalf("got %d expired, %v; want 0", n, err)
+    }
+    if stored, _ := orders.FindByID(order.OrderID); stored.Status != StatusConfirmed {
+        t.Fatalf("got status %s, want confirmed", stored.Status)
+    }
+    if fake.released != 0 {
+        t.Fatalf("released %d reservations of a confirmed order", fake.released)
+    }
+}
+
+func TestSweeperLeavesUnexpiredOrders(t *testing.T) {
+    resetOrders(t)
+    newInventoryServer(t, map[string]int{"prod_456": 10})
+    now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+    order := saveReservedPendingOrder(t, now.Add(time.Minute))
+
+    if n, _ := newTestExpirySweeper(now).sweepOnce(context.Background()); n != 0 {
+        t.Fatalf("expired %d orders before their time", n)
+    }
+    if stored, _ := orders.FindByID(order.OrderID); stored.Status != StatusPending {
+        t.Fatalf("got status %s, want pending", stored.Status)
+    }
+}
+
+func TestSweeperRunStopsOnCancel(t *testing.T) {
+    s := newTestExpirySweeper(time.Now())
+    s.after = func(time.Duration) <-chan time.Time { return make(chan time.Time) }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    stopped := make(chan struct{})
+    go func() {
+        defer close(stopped)
+        s.Run(ctx)
+    }()
+    cancel()
+    select {
+    case <-stopped:
+    case <-time.After(time.Second):
+        t.Fatal("Run did not stop after cancel")
+    }
+}
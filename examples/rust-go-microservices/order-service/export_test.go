@@ -0,0 +1,100 @@
+package main
+
+import (
+    "encoding/csv"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/shopspring/decimal"
+)
+
+func decodeCSV(t *testing.T, body string) [][]string {
+    t.Helper()
+
+    rows, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+    if err != nil {
+        t.Fatalf("invalid CSV %q: %v", body, err)
+    }
+    if len(rows) == 0 || strings.Join(rows[0], ",") != strings.Join(exportColumns, ",") {
+        t.Fatalf("got header %v, want %v", rows, exportColumns)
+    }
+    return rows[1:]
+}
+
+func TestExportOrdersCSV(t *testing.T) {
+    resetOrders(t)
+    createdAt := time.Date(2024, 3, 1, 12, 30, 0, 0, time.FixedZone("CET", 3600))
+    order := &Order{
+        OrderID:     orderIDs.NewID(),
+        CustomerID:  "cust_123",
+        Currency:    "EUR",
+        TotalAmount: decimal.RequireFromString("1234567.89"),
+        Status:      StatusConfirmed,
+        CreatedAt:   createdAt,
+    }
+    orders.Save(order)
+    deleted := saveCustomerOrder("cust_123", StatusCancelled, createdAt)
+    deletedAt := time.Now()
+    deleted.DeletedAt = &deletedAt
+    orders.Save(deleted)
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodGet, "/orders/export.csv", "")
+    if w.Code != http.StatusOK {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    if got := w.Header().Get("Content-Type"); got != "text/csv; charset=utf-8" {
+        t.Fatalf("got Content-Type %q", got)
+    }
+
+    rows := decodeCSV(t, w.Body.String())
+    if len(rows) != 1 {
+        t.Fatalf("got %d rows, want only the undeleted order: %v", len(rows), rows)
+    }
+    want := []string{order.OrderID.String(), "cust_123", StatusConfirmed, "1234567.89", "EUR", "2024-03-01T11:30:00Z"}
+    if strings.Join(rows[0], ",") != strings.Join(want, ",") {
+        t.Fatalf("got row %v, want %v", rows[0], want)
+    }
+
+    rows = decodeCSV(t, doRequest(r, http.MethodGet, "/orders/export.csv?include_deleted=true", "").Body.String())
+    if len(rows) != 2 {
+        t.Fatalf("include_deleted: got %d rows, want 2", len(rows))
+    }
+}
+
+func TestExportOrdersCSVIsScopedToAPIKey(t *testing.T) {
+    resetOrders(t)
+    useAPIKeys(t, "alice-key:alice")
+    saveCustomerOrder("alice", StatusConfirmed, time.Now())
+    saveCustomerOrder("bob", StatusConfirmed, time.Now())
+    r := setupRouter()
+
+    rows := decodeCSV(t, doRequestWithHeaders(r, http.MethodGet, "/orders/export.csv", "", bearer("alice-key")).Body.String())
+    if len(rows) != 1 || rows[0][1] != "alice" {
+        t.Fatalf("got rows %v, want only alice's order", rows)
+    }
+}
+
+func TestExportOrdersCSVStreamsManyRows(t *testing.T) {
+    resetOrders(t)
+    seedOrders(t, 3*exportFlushEvery+7)
+    r := setupRouter()
+
+    rows := decodeCSV(t, doRequest(r, http.MethodGet, "/orders/export.csv", "").Body.String())
+    if len(rows) != 3*exportFlushEvery+7 {
+        t.Fatalf("got %d rows, want %d", len(rows), 3*exportFlushEvery+7)
+    }
+}
+
+func TestExportDefusesFormulas(t *testing.T) {
+    resetOrders(t)
+    saveCustomerOrder("=HYPERLINK(\"http://evil\")", StatusConfirmed, time.Now())
+    r := setupRouter()
+
+    rows := decodeCSV(t, doRequest(r, http.MethodGet, "/orders/export.csv", "").Body.String())
+    if got := rows[0][1]; !strings.HasPrefix(got, "'=") {
+        t.Fatalf("got customer cell %q, want it quoted", got)
+    }
+}
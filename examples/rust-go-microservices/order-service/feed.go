@@ -0,0 +1,74 @@
+package main
+
+import (
+    "errors"
+    "sort"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+)
+
+// feedCursor is a position in the feed of order changes: orders updated
+// after Since, or at Since with an ID after AfterID. Clients page through
+// the feed by sending back the updated_at and order_id of the last order
+// they got.
+type feedCursor struct {
+    Since   time.Time
+    AfterID uuid.UUID
+}
+
+// queryFeedCursor reads ?updated_since= (RFC 3339) and ?after_id=. It
+// returns nil if the request isn't for the feed.
+func queryFeedCursor(c *gin.Context) (*feedCursor, error) {
+    raw, ok := c.GetQuery("updated_since")
+    if !ok {
+        if c.Query("after_id") != "" {
+            return nil, errors.New("after_id needs updated_since")
+        }
+        return nil, nil
+    }
+    since, err := time.Parse(time.RFC3339Nano, raw)
+    if err != nil {
+        return nil, errors.New("updated_since must be an RFC 3339 timestamp")
+    }
+    cursor := &feedCursor{Since: since}
+    if rawID := c.Query("after_id"); rawID != "" {
+        if cursor.AfterID, err = uuid.Parse(rawID); err != nil {
+            return nil, errors.New("after_id must be an order ID")
+        }
+    }
+    return cursor, nil
+}
+
+// after reports whether order comes after the cursor in the feed.
+func (f *feedCursor) after(order *Order) bool {
+    if !order.UpdatedAt.Equal(f.Since) {
+        return order.UpdatedAt.After(f.Since)
+    }
+    return f.AfterID != uuid.Nil && order.OrderID.String() > f.AfterID.String()
+}
+
+// updatedAfter returns the orders in list that come after cursor, in feed
+// order.
+func updatedAfter(list []*Order, cursor *feedCursor) []*Order {
+    filtered := make([]*Order, 0, len(list))
+    for _, order := range list {
+        if cursor.after(order) {
+            filtered = append(filtered, order)
+        }
+    }
+    sortByUpdate(filtered)
+    return filtered
+}
+
+// sortByUpdate puts list in feed order: least recently updated first, ties
+// broken by ID.
+func sortByUpdate(list []*Order) {
+    sort.Slice(list, func(i, j int) bool {
+        if !list[i].UpdatedAt.Equal(list[j].UpdatedAt) {
+            return list[i].UpdatedAt.Before(list[j].UpdatedAt)
+        }
+        return list[i].OrderID.String() < list[j].OrderID.String()
+    })
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+    "net/http"
+    "net/url"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+func feedPath(since time.Time, afterID uuid.UUID, limit int) string {
+    q := url.Values{"updated_since": {since.Format(time.RFC3339Nano)}}
+    if afterID != uuid.Nil {
+        q.Set("after_id", afterID.String())
+    }
+    if limit > 0 {
+        q.Set("limit", "1")
+    }
+    return "/orders?" + q.Encode()
+}
+
+func idsOf(list []*Order) []uuid.UUID {
+    ids := make([]uuid.UUID, len(list))
+    for i, order := range list {
+        ids[i] = order.OrderID
+    }
+    return ids
+}
+
+func TestListOrdersUpdatedSince(t *testing.T) {
+    resetOrders(t)
+    start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    fake := useClock(t, start)
+    r := setupRouter()
+
+    first := saveOrderWithStatus(StatusConfirmed)
+    fake.Advance(time.Minute)
+    saveOrderWithStatus(StatusConfirmed)
+    fake.Advance(time.Minute)
+    third := saveOrderWithStatus(StatusConfirmed)
+    fake.Advance(time.Minute)
+    first.Notes = "changed"
+    if err := orders.Save(first); err != nil {
+        t.Fatal(err)
+    }
+    if !first.UpdatedAt.Equal(fake.Now()) {
+        t.Fatalf("updated at %v, want %v", first.UpdatedAt, fake.Now())
+    }
+
+    list := decodeList(t, doRequest(r, http.MethodGet, feedPath(start.Add(time.Minute), uuid.Nil, 0), "").Body.Bytes())
+    got := idsOf(list.Orders)
+    if len(got) != 2 || got[0] != third.OrderID || got[1] != first.OrderID {
+        t.Fatalf("got %v, want the third order then the first", got)
+    }
+}
+
+func TestFeedPagesThroughTiesInOrder(t *testing.T) {
+    resetOrders(t)
+    start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    useClock(t, start)
+    r := setupRouter()
+
+    // Saved at the same instant, so only their IDs order them.
+    want := map[uuid.UUID]bool{}
+    for i := 0; i < 3; i++ {
+        want[saveOrderWithStatus(StatusConfirmed).OrderID] = true
+    }
+
+    since, afterID := start.Add(-time.Second), uuid.Nil
+    var seen []uuid.UUID
+    for page := 0; page < 5; page++ {
+        w := doRequest(r, http.MethodGet, feedPath(since, afterID, 1), "")
+        if w.Code != http.StatusOK {
+            t.Fatalf("got status %d: %s", w.Code, w.Body)
+        }
+        list := decodeList(t, w.Body.Bytes())
+        if len(list.Orders) == 0 {
+            break
+        }
+        last := list.Orders[0]
+        seen = append(seen, last.OrderID)
+        since, afterID = last.UpdatedAt, last.OrderID
+    }
+    if len(seen) != 3 {
+        t.Fatalf("paged through %d orders, want 3", len(seen))
+    }
+    for i, id := range seen {
+        if !want[id] {
+            t.Fatalf("page %d gave unknown order %s", i, id)
+        }
+        if i > 0 && id.String() <= seen[i-1].String() {
+            t.Fatalf("pages out of ID order: %v", seen)
+        }
+    }
+}
+
+func TestFeedRejectsBadCursor(t *testing.T) {
+    resetOrders(t)
+    r := setupRouter()
+
+    for _, query := range []string{"updated_since=yesterday", "updated_since=2024-05-01T12:00:00Z&after_id=nope", "after_id=" + uuid.NewString()} {
+        if w := doRequest(r, http.MethodGet, "/orders?"+query, ""); w.Code != http.StatusBadRequest {
+            t.Fatalf("%s: got status %d, want 400", query, w.Code)
+        }
+    }
+}
+
+func TestSQLiteListUpdatedSince(t *testing.T) {
+    start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    fake := useClock(t, start)
+    repo := openTestSQLite(t, filepath.Join(t.TempDir(), "orders.db"))
+
+    old := &Order{OrderID: uuid.New(), CustomerID: "cust_123", Currency: "USD", Status: StatusConfirmed, CreatedAt: start}
+    recent := &Order{OrderID: uuid.New(), CustomerID: "cust_123", Currency: "USD", Status: StatusConfirmed, CreatedAt: start}
+    repo.Save(old)
+    fake.Advance(time.Minute)
+    repo.Save(recent)
+    fake.Advance(time.Minute)
+    repo.Save(old)
+
+    list, err := repo.ListUpdatedSince(start.Add(time.Second))
+    if err != nil {
+        t.Fatal(err)
+    }
+    got := idsOf(list)
+    if len(got) != 2 || got[0] != recent.OrderID || got[1] != old.OrderID {
+        t.Fatalf("got %v, want the recent order then the resaved old one", got)
+    }
+    if !list[1].UpdatedAt.Equal(fake.Now()) {
+        t.Fatalf("stored updated_at %v, want %v", list[1].UpdatedAt, fake.Now())
+    }
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+)
+
+// fieldTree is a ?fields= selection, such as
+// "order_id,status,items.product_id". Each key is a field to keep, mapped to
+// the selection within it, or to nil to keep all of it.
+type fieldTree map[string]fieldTree
+
+// queryFields reads ?fields=, a comma-separated list of the JSON field names
+// to return, with dots reaching into nested objects and into each element
+// of arrays. It returns nil when the whole response is wanted.
+func queryFields(c *gin.Context) fieldTree {
+    var tree fieldTree
+    for _, path := range strings.Split(c.Query("fields"), ",") {
+        if path = strings.TrimSpace(path); path == "" {
+            continue
+        }
+        if tree == nil {
+            tree = fieldTree{}
+        }
+        node := tree
+        parts := strings.Split(path, ".")
+        for i, part := range parts {
+            sub, seen := node[part]
+            if i == len(parts)-1 {
+                // Asking for a whole field overrides asking for parts of it.
+                node[part] = nil
+                break
+            }
+            if seen && sub == nil {
+                break
+            }
+            if sub == nil {
+                sub = fieldTree{}
+                node[part] = sub
+            }
+            node = sub
+        }
+    }
+    return tree
+}
+
+// project keeps the selected fields of v, a decoded JSON value. Names that
+// aren't there are skipped, so a client asking for a field an older
+// version doesn't have gets the rest.
+func (t fieldTree) project(v interface{}) interface{} {
+    switch v := v.(type) {
+    case map[string]interface{}:
+        out := make(map[string]interface{}, len(t))
+        for name, sub := range t {
+            value, ok := v[name]
+            if !ok {
+                continue
+            }
+            if sub == nil {
+                out[name] = value
+            } else {
+                out[name] = sub.project(value)
+            }
+        }
+        return out
+    case []interface{}:
+        out := make([]interface{}, len(v))
+        for i, e := range v {
+            out[i] = t.project(e)
+        }
+        return out
+    }
+    // A scalar has no fields to pick from.
+    return v
+}
+
+// projectOrder renders order with only the fields in t, or whole if t is
+// nil. The selection works on the JSON form, so it names fields as the
+// default profile's responses do.
+func projectOrder(order *Order, t fieldTree) interface{} {
+    if t == nil {
+        return order
+    }
+    data, err := json.Marshal(order)
+    if err != nil {
+        return order
+    }
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.UseNumber()
+    var doc interface{}
+    if err := dec.Decode(&doc); err != nil {
+        return order
+    }
+    return t.project(doc)
+}
+
+// projectedList is an OrderList whose orders have been cut down to the
+// requested fields.
+type projectedList struct {
+    Orders []interface{} `json:"orders"`
+    Total  int           `json:"total"`
+    Limit  int           `json:"limit"`
+    Offset int           `json:"offset"`
+}
+
+// projectList renders list with each order cut down to the fields in t.
+func projectList(list OrderList, t fieldTree) interface{} {
+    if t == nil {
+        return list
+    }
+    out := projectedList{Orders: make([]interface{}, len(list.Orders)), Total: list.Total, Limit: list.Limit, Offset: list.Offset}
+    for i, order := range list.Orders {
+        out.Orders[i] = projectOrder(order, t)
+    }
+    return out
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "reflect"
+    "sort"
+    "testing"
+)
+
+func keysOf(m map[string]interface{}) []string {
+    keys := make([]string, 0, len(m))
+    for k := range m {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+func createProjectionOrder(t *testing.T) (http.Handler, Order) {
+    t.Helper()
+
+    newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+    w := doRequest(r, http.MethodPost, "/orders", sampleOrder)
+    if w.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    return r, order
+}
+
+func TestFieldsProjectTopLevel(t *testing.T) {
+    r, order := createProjectionOrder(t)
+
+    w := doRequest(r, http.MethodGet, "/orders/"+order.OrderID.String()+"?fields=order_id,status,total_amount", "")
+    var doc map[string]interface{}
+    json.Unmarshal(w.Body.Bytes(), &doc)
+    if w.Code != http.StatusOK || !reflect.DeepEqual(keysOf(doc), []string{"order_id", "status", "total_amount"}) {
+        t.Fatalf("got status %d: %s; want only the three fields", w.Code, w.Body)
+    }
+    if doc["order_id"] != order.OrderID.String() || doc["status"] != StatusConfirmed || doc["total_amount"] != "59.98" {
+        t.Fatalf("got %v, want the order's values", doc)
+    }
+
+    // Without fields the whole order comes back.
+    json.Unmarshal(doRequest(r, http.MethodGet, "/orders/"+order.OrderID.String(), "").Body.Bytes(), &doc)
+    if _, ok := doc["items"]; !ok {
+        t.Fatalf("full order is missing items: %v", doc)
+    }
+}
+
+func TestFieldsProjectNestedItems(t *testing.T) {
+    r, order := createProjectionOrder(t)
+
+    w := doRequest(r, http.MethodGet, "/orders?fields=order_id,items.product_id,items.quantity", "")
+    var list struct {
+        Orders []map[string]interface{} `json:"orders"`
+        Total  int                      `json:"total"`
+    }
+    json.Unmarshal(w.Body.Bytes(), &list)
+    if w.Code != http.StatusOK || list.Total != 1 || len(list.Orders) != 1 {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    got := list.Orders[0]
+    if !reflect.DeepEqual(keysOf(got), []string{"items", "order_id"}) || got["order_id"] != order.OrderID.String() {
+        t.Fatalf("got %v, want order_id and items", got)
+    }
+    items := got["items"].([]interface{})
+    want := map[string]interface{}{"product_id": "prod_456", "quantity": 2.0}
+    if len(items) != 1 || !reflect.DeepEqual(items[0], want) {
+        t.Fatalf("got items %v, want %v", items, want)
+    }
+
+    // Asking for the whole of a field overrides asking for part of it.
+    json.Unmarshal(doRequest(r, http.MethodGet, "/orders?fields=items.product_id,items", "").Body.Bytes(), &list)
+    if item := list.Orders[0]["items"].([]interface{})[0].(map[string]interface{}); item["price"] != "29.99" {
+        t.Fatalf("got item %v, want all its fields", item)
+    }
+}
+
+func TestFieldsIgnoreUnknownNames(t *testing.T) {
+    r, order := createProjectionOrder(t)
+
+    w := doRequest(r, http.MethodGet, "/orders/"+order.OrderID.String()+"?fields=status,no_such_field,items.nope,status.deeper", "")
+    var doc map[string]interface{}
+    json.Unmarshal(w.Body.Bytes(), &doc)
+    if w.Code != http.StatusOK {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    want := map[string]interface{}{"status": StatusConfirmed, "items": []interface{}{map[string]interface{}{}}}
+    if !reflect.DeepEqual(doc, want) {
+        t.Fatalf("got %v, want %v", doc, want)
+    }
+}
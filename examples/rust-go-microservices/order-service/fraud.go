@@ -0,0 +1,61 @@
+package main
+
+import (
+    "context"
+    "net/http"
+)
+
+// What a FraudScorer decides about an order.
+const (
+    FraudApprove = "approve"
+    FraudReview  = "review"
+    FraudDecline = "decline"
+)
+
+// FraudAssessment is a FraudScorer's verdict on an order.
+type FraudAssessment struct {
+    // Score is how risky the scorer thinks the order is; its scale is the
+    // scorer's own.
+    Score    float64 `json:"score"`
+    Decision string  `json:"decision"`
+}
+
+// FraudScorer checks a new order for fraud before it is charged. It sees the
+// order validated and priced, but not yet stored.
+type FraudScorer interface {
+    Score(ctx context.Context, order *Order) (FraudAssessment, error)
+}
+
+// NoopFraudScorer approves every order.
+type NoopFraudScorer struct{}
+
+func (NoopFraudScorer) Score(context.Context, *Order) (FraudAssessment, error) {
+    return FraudAssessment{Decision: FraudApprove}, nil
+}
+
+// fraudScorer scores every new order.
+var fraudScorer FraudScorer = NoopFraudScorer{}
+
+// screenOrder scores order and records the assessment on it. A declined
+// order is rejected. One to be reviewed is moved to fraud_review, for
+// submitOrder to store without charging. A scorer that fails, or decides
+// something it shouldn't, sends the order for review rather than letting
+// it through unchecked.
+func screenOrder(ctx context.Context, order *Order) *requestError {
+    assessment, err := fraudScorer.Score(ctx, order)
+    if err != nil {
+        loggerFrom(ctx).Warn("fraud check failed", "order_id", order.OrderID, "error", err)
+        assessment = FraudAssessment{Decision: FraudReview}
+    }
+    order.Fraud = &assessment
+
+    switch assessment.Decision {
+    case FraudApprove:
+        return nil
+    case FraudDecline:
+        return newRequestError(http.StatusForbidden, CodeFraudDeclined, "Order declined by fraud check")
+    }
+    transitionStatus(order, StatusFraudReview, "held for fraud review")
+    order.ExpiresAt = nil
+    return nil
+}
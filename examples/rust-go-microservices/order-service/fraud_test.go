@@ -0,0 +1,124 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "testing"
+)
+
+// fixedScorer is a FraudScorer that gives every order the same verdict.
+type fixedScorer struct {
+    assessment FraudAssessment
+    err        error
+}
+
+func (s fixedScorer) Score(context.Context, *Order) (FraudAssessment, error) {
+    return s.assessment, s.err
+}
+
+// useFraudScorer installs scorer for the rest of the test.
+func useFraudScorer(t *testing.T, scorer FraudScorer) {
+    t.Helper()
+
+    prev := fraudScorer
+    fraudScorer = scorer
+    t.Cleanup(func() { fraudScorer = prev })
+}
+
+func TestFraudApprovedOrderIsCharged(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    useFraudScorer(t, fixedScorer{assessment: FraudAssessment{Score: 0.1, Decision: FraudApprove}})
+
+    w := doRequest(setupRouter(), http.MethodPost, "/orders", sampleOrder)
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if w.Code != http.StatusCreated || order.Status != StatusConfirmed {
+        t.Fatalf("got status %d: %s; want a confirmed order", w.Code, w.Body)
+    }
+    if fake.charges.Load() != 1 {
+        t.Fatalf("charged %d times, want once", fake.charges.Load())
+    }
+    if order.Fraud == nil || order.Fraud.Score != 0.1 || order.Fraud.Decision != FraudApprove {
+        t.Fatalf("got fraud assessment %+v, want the scorer's", order.Fraud)
+    }
+}
+
+func TestFraudReviewHoldsOrderUncharged(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    useFraudScorer(t, fixedScorer{assessment: FraudAssessment{Score: 0.6, Decision: FraudReview}})
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", sampleOrder)
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if w.Code != http.StatusCreated || order.Status != StatusFraudReview {
+        t.Fatalf("got status %d: %s; want an order in %s", w.Code, w.Body, StatusFraudReview)
+    }
+    if fake.charges.Load() != 0 {
+        t.Fatalf("charged %d times, want none", fake.charges.Load())
+    }
+    if order.ExpiresAt != nil {
+        t.Fatalf("order under review expires at %v", order.ExpiresAt)
+    }
+
+    stored, err := orders.FindByID(order.OrderID)
+    if err != nil || stored.Status != StatusFraudReview || stored.Fraud == nil || stored.Fraud.Score != 0.6 {
+        t.Fatalf("stored %+v, %v; want the order held with its score", stored, err)
+    }
+
+    // A reviewer who turns it down cancels it.
+    w = doRequestWithHeaders(r, http.MethodPost, "/orders/"+order.OrderID.String()+"/cancel", "", ifMatch(stored))
+    if w.Code != http.StatusOK || fake.refunds.Load() != 0 {
+        t.Fatalf("cancel: got status %d: %s", w.Code, w.Body)
+    }
+}
+
+func TestFraudDeclinedOrderRejected(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    useFraudScorer(t, fixedScorer{assessment: FraudAssessment{Score: 0.95, Decision: FraudDecline}})
+
+    w := doRequest(setupRouter(), http.MethodPost, "/orders", sampleOrder)
+    if w.Code != http.StatusForbidden || decodeError(t, w).Code != CodeFraudDeclined {
+        t.Fatalf("got status %d: %s; want 403 %s", w.Code, w.Body, CodeFraudDeclined)
+    }
+    if fake.charges.Load() != 0 {
+        t.Fatalf("charged %d times, want none", fake.charges.Load())
+    }
+    if stored, _ := orders.List(); len(stored) != 0 {
+        t.Fatalf("declined order was stored: %+v", stored)
+    }
+}
+
+func TestFraudScorerFailureHoldsOrderForReview(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    useFraudScorer(t, fixedScorer{err: errors.New("scorer down")})
+
+    w := doRequest(setupRouter(), http.MethodPost, "/orders", sampleOrder)
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if w.Code != http.StatusCreated || order.Status != StatusFraudReview || fake.charges.Load() != 0 {
+        t.Fatalf("got status %d: %s after %d charges; want an uncharged order in %s",
+            w.Code, w.Body, fake.charges.Load(), StatusFraudReview)
+    }
+}
+
+func TestDefaultFraudScorerApproves(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+
+    w := doRequest(setupRouter(), http.MethodPost, "/orders", sampleOrder)
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if w.Code != http.StatusCreated || order.Status != StatusConfirmed || fake.charges.Load() != 1 {
+        t.Fatalf("got status %d: %s; want a charged, confirmed order", w.Code, w.Body)
+    }
+    if order.Fraud == nil || order.Fraud.Decision != FraudApprove {
+        t.Fatalf("got fraud assessment %+v, want approve", order.Fraud)
+    }
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func FuzzCreateOrder(f *testing.F) {
+    for _, seed := range []string{
+        sampleOrder,
+        `{"customer_id":"c","items":[{"product_id":"p","quantity":1,"price":"1e1000000000"}]}`,
+        `{"customer_id":"c","items":[{"product_id":"p","quantity":1,"price":"-0.000000001"}]}`,
+        `{"customer_id":"c","items":[{"product_id":"p","quantity":1,"price":"NaN"}],"discount":{"type":"percentage","value":"1e-999999"}}`,
+        `{"customer_id":"c","items":[{"product_id":"p","quantity":1,"price":1}],"expected_total":"9e99999999"}`,
+        `{"customer_id":"c","items":[],"metadata":{"a":{"b":[[[[]]]]}}}`,
+        strings.Repeat("[", 100000),
+        `{"items":null}`,
+        `null`,
+        ``,
+    } {
+        f.Add(seed)
+    }
+    newPaymentServer(f)
+    resetOrders(f)
+    r := setupRouter()
+
+    f.Fuzz(func(t *testing.T, body string) {
+        w := doRequest(r, http.MethodPost, "/orders", body)
+        if w.Code < 200 || w.Code > 599 || w.Code == http.StatusInternalServerError {
+            t.Fatalf("got status %d for %q: %s", w.Code, body, w.Body)
+        }
+    })
+}
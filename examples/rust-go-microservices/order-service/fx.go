@@ -0,0 +1,177 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/shopspring/decimal"
+)
+
+// ErrRateUnavailable is returned when no exchange rate is known for a
+// currency pair.
+var ErrRateUnavailable = errors.New("exchange rate unavailable")
+
+// ExchangeRateProvider quotes the rate to convert one currency into
+// another: one unit of from buys rate units of to.
+type ExchangeRateProvider interface {
+    Rate(ctx context.Context, from, to string) (decimal.Decimal, error)
+}
+
+// StaticRates is a fixed table of rates keyed "FROM/TO", such as "EUR/USD".
+type StaticRates map[string]decimal.Decimal
+
+func (r StaticRates) Rate(_ context.Context, from, to string) (decimal.Decimal, error) {
+    rate, ok := r[from+"/"+to]
+    if !ok {
+        return decimal.Zero, fmt.Errorf("%s/%s: %w", from, to, ErrRateUnavailable)
+    }
+    return rate, nil
+}
+
+// RateClient fetches live rates from an exchange rate service, which
+// answers GET /rates?from=EUR&to=USD with {"rate": "1.08"}.
+type RateClient struct {
+    BaseURL    string
+    HTTPClient *http.Client
+    // Timeout bounds each call.
+    Timeout time.Duration
+}
+
+func NewRateClient(baseURL string) *RateClient {
+    return &RateClient{
+        BaseURL:    baseURL,
+        HTTPClient: &http.Client{},
+        Timeout:    defaultExchangeRateTimeout,
+    }
+}
+
+type rateResponse struct {
+    Rate decimal.Decimal `json:"rate"`
+}
+
+func (c *RateClient) Rate(ctx context.Context, from, to string) (decimal.Decimal, error) {
+    ctx, cancel := callTimeout(ctx, c.Timeout)
+    defer cancel()
+    query := url.Values{"from": {from}, "to": {to}}
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/rates?"+query.Encode(), nil)
+    if err != nil {
+        return decimal.Zero, err
+    }
+
+    resp, err := c.HTTPClient.Do(req)
+    if err != nil {
+        return decimal.Zero, fmt.Errorf("%s/%s: %w: %v", from, to, ErrRateUnavailable, err)
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return decimal.Zero, fmt.Errorf("%s/%s: %w: rate service returned status %d", from, to, ErrRateUnavailable, resp.StatusCode)
+    }
+    var body rateResponse
+    if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+        return decimal.Zero, fmt.Errorf("%s/%s: %w: %v", from, to, ErrRateUnavailable, err)
+    }
+    if !body.Rate.IsPositive() {
+        return decimal.Zero, fmt.Errorf("%s/%s: %w: rate %s is not positive", from, to, ErrRateUnavailable, body.Rate)
+    }
+    return body.Rate, nil
+}
+
+// settlementCurrency is the currency the payment processor settles in.
+// Orders in any other currency are converted before they are charged; when
+// it is empty, every order is charged in its own currency.
+var settlementCurrency string
+
+// exchangeRates quotes the rates for converting orders into
+// settlementCurrency.
+var exchangeRates ExchangeRateProvider = StaticRates{}
+
+// Settlement is what an order was actually charged when that differs from
+// its own currency, and the rate used to get there.
+type Settlement struct {
+    Amount   decimal.Decimal `json:"amount"`
+    Currency string          `json:"currency"`
+    Rate     decimal.Decimal `json:"rate"`
+}
+
+// convertForPayment records the order total in settlementCurrency, rounded
+// to that currency's minor units. It leaves no settlement when the order is
+// already in the settlement currency.
+func convertForPayment(ctx context.Context, order *Order) error {
+    order.Settlement = nil
+    if settlementCurrency == "" || order.Currency == settlementCurrency {
+        return nil
+    }
+    rate, err := exchangeRates.Rate(ctx, order.Currency, settlementCurrency)
+    if err != nil {
+        return err
+    }
+    order.Settlement = &Settlement{
+        Amount:   convertAmount(order.TotalAmount, rate, settlementCurrency),
+        Currency: settlementCurrency,
+        Rate:     rate,
+    }
+    return nil
+}
+
+// convertAmount converts amount at rate, rounding to the minor units of
+// the currency converted to.
+func convertAmount(amount, rate decimal.Decimal, to string) decimal.Decimal {
+    return roundingMode.round(amount.Mul(rate), minorUnits(to))
+}
+
+// chargeAmount returns amount of the order's currency as the payment
+// service sees it: converted at the rate the order was charged at, if it
+// was converted at all. Refunds use the original rate, so the customer gets
+// back what they paid whatever the rate has done since.
+func (o *Order) chargeAmount(amount decimal.Decimal) (decimal.Decimal, string) {
+    if o.Settlement == nil {
+        return amount, o.Currency
+    }
+    if amount.Equal(o.TotalAmount) {
+        return o.Settlement.Amount, o.Settlement.Currency
+    }
+    return convertAmount(amount, o.Settlement.Rate, o.Settlement.Currency), o.Settlement.Currency
+}
+
+// exchangeRatesFromEnv reads SETTLEMENT_CURRENCY and where rates come from:
+// the service at EXCHANGE_RATE_SERVICE_URL if set, otherwise the fixed
+// EXCHANGE_RATES list, such as "EUR/USD:1.08,GBP/USD:1.27".
+func exchangeRatesFromEnv() (string, ExchangeRateProvider, error) {
+    currency := strings.ToUpper(strings.TrimSpace(os.Getenv("SETTLEMENT_CURRENCY")))
+    if currency != "" {
+        if _, ok := lookupCurrency(currency); !ok {
+            return "", nil, fmt.Errorf("SETTLEMENT_CURRENCY: unknown currency %q", currency)
+        }
+    }
+
+    if raw := os.Getenv("EXCHANGE_RATE_SERVICE_URL"); raw != "" {
+        baseURL, err := parseServiceURL(raw)
+        if err != nil {
+            return "", nil, fmt.Errorf("EXCHANGE_RATE_SERVICE_URL: %w", err)
+        }
+        return currency, NewRateClient(baseURL), nil
+    }
+
+    rates := StaticRates{}
+    for _, entry := range strings.Split(os.Getenv("EXCHANGE_RATES"), ",") {
+        if entry = strings.TrimSpace(entry); entry == "" {
+            continue
+        }
+        pair, raw, ok := strings.Cut(entry, ":")
+        from, to, okPair := strings.Cut(strings.ToUpper(strings.TrimSpace(pair)), "/")
+        rate, err := decimal.NewFromString(strings.TrimSpace(raw))
+        if !ok || !okPair || from == "" || to == "" || err != nil || !rate.IsPositive() {
+            return "", nil, fmt.Errorf("EXCHANGE_RATES: want FROM/TO:rate, got %q", entry)
+        }
+        rates[from+"/"+to] = rate
+    }
+    return currency, rates, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/shopspring/decimal"
+)
+
+func useSettlement(t *testing.T, currency string, rates ExchangeRateProvider) {
+    t.Helper()
+
+    prevCurrency, prevRates := settlementCurrency, exchangeRates
+    settlementCurrency, exchangeRates = currency, rates
+    t.Cleanup(func() { settlementCurrency, exchangeRates = prevCurrency, prevRates })
+}
+
+const euroOrder = `{"customer_id":"cust_123","currency":"EUR","items":[{"product_id":"prod_456","quantity":2,"price":"29.99"}]}`
+
+func TestPaymentSameCurrencyIsNotConverted(t *testing.T) {
+    resetOrders(t)
+    fake := newPaymentServer(t)
+    useSettlement(t, "USD", StaticRates{})
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", sampleOrder)
+    if w.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if order.Settlement != nil {
+        t.Fatalf("got settlement %+v for a USD order", order.Settlement)
+    }
+    if charge := fake.lastCharge.Load(); charge.Currency != "USD" || charge.Amount.String() != "59.98" {
+        t.Fatalf("charged %s %s, want USD 59.98", charge.Amount, charge.Currency)
+    }
+}
+
+func TestPaymentConvertsToSettlementCurrency(t *testing.T) {
+    tests := []struct {
+        settle     string
+        rate       string
+        wantAmount string
+    }{
+        {"USD", "1.085", "65.08"},
+        // 9746.75 rounds half-even to JPY's whole yen.
+        {"JPY", "162.5", "9747"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.settle, func(t *testing.T) {
+            resetOrders(t)
+            fake := newPaymentServer(t)
+            useSettlement(t, tt.settle, StaticRates{"EUR/" + tt.settle: decimal.RequireFromString(tt.rate)})
+            r := setupRouter()
+
+            w := doRequest(r, http.MethodPost, "/orders", euroOrder)
+            if w.Code != http.StatusCreated {
+                t.Fatalf("got status %d: %s", w.Code, w.Body)
+            }
+            var order Order
+            json.Unmarshal(w.Body.Bytes(), &order)
+            if order.Currency != "EUR" || order.TotalAmount.String() != "59.98" {
+                t.Fatalf("got total %s %s, want the original EUR 59.98", order.TotalAmount, order.Currency)
+            }
+            s := order.Settlement
+            if s == nil || s.Currency != tt.settle || s.Amount.String() != tt.wantAmount || s.Rate.String() != tt.rate {
+                t.Fatalf("got settlement %+v, want %s %s at %s", s, tt.wantAmount, tt.settle, tt.rate)
+            }
+            if charge := fake.lastCharge.Load(); charge.Currency != tt.settle || charge.Amount.String() != tt.wantAmount {
+                t.Fatalf("charged %s %s, want %s %s", charge.Amount, charge.Currency, tt.wantAmount, tt.settle)
+            }
+        })
+    }
+}
+
+func TestPaymentRateUnavailable(t *testing.T) {
+    resetOrders(t)
+    fake := newPaymentServer(t)
+    useSettlement(t, "USD", StaticRates{})
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", euroOrder)
+    if w.Code != http.StatusServiceUnavailable || decodeError(t, w).Code != CodeExchangeRateUnavailable {
+        t.Fatalf("got %d %s, want 503 %s", w.Code, w.Body, CodeExchangeRateUnavailable)
+    }
+    if n := fake.charges.Load(); n != 0 {
+        t.Fatalf("got %d charges, want none", n)
+    }
+    if list, _ := orders.List(); len(list) != 0 {
+        t.Fatalf("got %d stored orders, want none", len(list))
+    }
+}
+
+func TestRefundUsesSettlementRate(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    order := saveOrderWithStatus(StatusConfirmed)
+    order.Currency = "EUR"
+    order.Settlement = &Settlement{Amount: decimal.RequireFromString("65.08"), Currency: "USD", Rate: decimal.RequireFromString("1.085")}
+    orders.Save(order)
+    r := setupRouter()
+
+    if code, _ := refund(t, r, order, `{"amount":"10"}`); code != http.StatusOK {
+        t.Fatalf("got status %d", code)
+    }
+    if refunded := fake.lastRefund.Load(); refunded.Currency != "USD" || refunded.Amount.String() != "10.85" {
+        t.Fatalf("refunded %s %s, want USD 10.85", refunded.Amount, refunded.Currency)
+    }
+}
+
+func TestRateClient(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Query().Get("from") != "EUR" || r.URL.Query().Get("to") != "USD" {
+            http.NotFound(w, r)
+            return
+        }
+        w.Write([]byte(`{"rate":"1.085"}`))
+    }))
+    defer srv.Close()
+    client := NewRateClient(srv.URL)
+
+    rate, err := client.Rate(context.Background(), "EUR", "USD")
+    if err != nil || rate.String() != "1.085" {
+        t.Fatalf("got %s, %v; want 1.085", rate, err)
+    }
+    if _, err := client.Rate(context.Background(), "GBP", "USD"); !errors.Is(err, ErrRateUnavailable) {
+        t.Fatalf("got %v, want ErrRateUnavailable", err)
+    }
+}
+
+func TestExchangeRatesFromEnv(t *testing.T) {
+    t.Setenv("SETTLEMENT_CURRENCY", "usd")
+    t.Setenv("EXCHANGE_RATES", "eur/usd:1.085, GBP/USD:1.27")
+    currency, rates, err := exchangeRatesFromEnv()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if currency != "USD" {
+        t.Fatalf("got settlement currency %q", currency)
+    }
+    if rate, err := rates.Rate(context.Background(), "EUR", "USD"); err != nil || rate.String() != "1.085" {
+        t.Fatalf("EUR/USD: got %s, %v", rate, err)
+    }
+
+    for _, bad := range []string{"EUR/USD", "EUR:1.1", "EUR/USD:0", "EUR/USD:x"} {
+        t.Setenv("EXCHANGE_RATES", bad)
+        if _, _, err := exchangeRatesFromEnv(); err == nil {
+            t.Errorf("EXCHANGE_RATES=%q: got no error", bad)
+        }
+    }
+    t.Setenv("EXCHANGE_RATES", "")
+    t.Setenv("SETTLEMENT_CURRENCY", "XXX")
+    if _, _, err := exchangeRatesFromEnv(); err == nil {
+        t.Fatal("unknown SETTLEMENT_CURRENCY: got no error")
+    }
+}
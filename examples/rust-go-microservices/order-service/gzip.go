@@ -0,0 +1,121 @@
+package main
+
+import (
+    "compress/gzip"
+    "strconv"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+)
+
+// gzipMinSize is the smallest response worth compressing; below it the
+// gzip framing costs about as much as it saves.
+const gzipMinSize = 1024
+
+// gzipResponses compresses responses of at least minSize bytes for clients
+// that accept gzip. Responses are buffered until they reach minSize, so a
+// small one is sent as is.
+func gzipResponses(minSize int) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        c.Writer.Header().Add("Vary", "Accept-Encoding")
+        if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+            c.Next()
+            return
+        }
+
+        w := &gzipWriter{ResponseWriter: c.Writer, minSize: minSize}
+        c.Writer = w
+        defer w.finish()
+        c.Next()
+    }
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip.
+func acceptsGzip(header string) bool {
+    for _, part := range strings.Split(header, ",") {
+        coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+        if coding = strings.TrimSpace(coding); coding != "gzip" && coding != "*" {
+            continue
+        }
+        if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+            if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+                return false
+            }
+        }
+        return true
+    }
+    return false
+}
+
+// gzipWriter buffers a response until it is known to be big enough to
+// compress, then streams the rest through gzip.
+type gzipWriter struct {
+    gin.ResponseWriter
+    minSize int
+
+    buf []byte
+    gz  *gzip.Writer
+    // raw is set once the response is being sent uncompressed.
+    raw bool
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+    switch {
+    case w.gz != nil:
+        return w.gz.Write(p)
+    case w.raw:
+        return w.ResponseWriter.Write(p)
+    }
+    w.buf = append(w.buf, p...)
+    if len(w.buf) >= w.minSize {
+        if err := w.start(); err != nil {
+            return 0, err
+        }
+    }
+    return len(p), nil
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+    return w.Write([]byte(s))
+}
+
+// start sends the buffered bytes and switches to compressing, unless the
+// handler already encoded the response itself.
+func (w *gzipWriter) start() error {
+    buf := w.buf
+    w.buf = nil
+    if w.Header().Get("Content-Encoding") != "" {
+        w.raw = true
+        _, err := w.ResponseWriter.Write(buf)
+        return err
+    }
+
+    w.Header().Set("Content-Encoding", "gzip")
+    w.Header().Del("Content-Length")
+    w.gz = gzip.NewWriter(w.ResponseWriter)
+    _, err := w.gz.Write(buf)
+    return err
+}
+
+// Flush sends what has been written so far; a streamed response can't wait
+// to reach minSize, so it is compressed from here on.
+func (w *gzipWriter) Flush() {
+    if w.gz == nil && !w.raw {
+        w.start()
+    }
+    if w.gz != nil {
+        w.gz.Flush()
+    }
+    w.ResponseWriter.Flush()
+}
+
+// finish completes the response: the gzip stream is closed, or a response
+// too small to compress is sent as is.
+func (w *gzipWriter) finish() {
+    switch {
+    case w.gz != nil:
+        w.gz.Close()
+    case !w.raw && len(w.buf) > 0:
+        w.ResponseWriter.Write(w.buf)
+    }
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+    "compress/gzip"
+    "io"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func TestLargeListResponseIsGzipped(t *testing.T) {
+    resetOrders(t)
+    seedOrders(t, 20)
+    r := setupRouter()
+
+    w := doRequestWithHeaders(r, http.MethodGet, "/orders", "", map[string]string{"Accept-Encoding": "gzip"})
+    if w.Code != http.StatusOK {
+        t.Fatalf("got status %d", w.Code)
+    }
+    if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+        t.Fatalf("got Content-Encoding %q, want gzip", got)
+    }
+    if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+        t.Fatalf("got Vary %q, want Accept-Encoding", got)
+    }
+
+    zr, err := gzip.NewReader(w.Body)
+    if err != nil {
+        t.Fatalf("body is not gzip: %v", err)
+    }
+    body, err := io.ReadAll(zr)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if list := decodeList(t, body); list.Total != 20 {
+        t.Fatalf("decompressed list has %d orders, want 20", list.Total)
+    }
+}
+
+func TestSmallResponseIsNotGzipped(t *testing.T) {
+    r := setupRouter()
+
+    w := doRequestWithHeaders(r, http.MethodGet, "/health/live", "", map[string]string{"Accept-Encoding": "gzip"})
+    if got := w.Header().Get("Content-Encoding"); got != "" {
+        t.Fatalf("got Content-Encoding %q for a small response", got)
+    }
+    if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+        t.Fatalf("got Vary %q, want Accept-Encoding", got)
+    }
+    if !strings.Contains(w.Body.String(), `"alive"`) {
+        t.Fatalf("got body %q", w.Body)
+    }
+}
+
+func TestGzipOnlyWhenAccepted(t *testing.T) {
+    resetOrders(t)
+    seedOrders(t, 20)
+    r := setupRouter()
+
+    for _, accept := range []string{"", "identity", "gzip;q=0", "br"} {
+        w := doRequestWithHeaders(r, http.MethodGet, "/orders", "", map[string]string{"Accept-Encoding": accept})
+        if got := w.Header().Get("Content-Encoding"); got != "" {
+            t.Fatalf("Accept-Encoding %q: got Content-Encoding %q", accept, got)
+        }
+    }
+}
+
+func TestAcceptsGzip(t *testing.T) {
+    tests := map[string]bool{
+        "gzip":                true,
+        "deflate, gzip;q=0.5": true,
+        "*":                   true,
+        "gzip;q=0":            false,
+        "deflate":             false,
+        "":                    false,
+    }
+    for header, want := range tests {
+        if got := acceptsGzip(header); got != want {
+            t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+        }
+    }
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "sync"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+const (
+    defaultReadinessCacheTTL = 5 * time.Second
+    defaultReadinessTimeout  = time.Second
+
+    defaultDegradedLatency      = 500 * time.Millisecond
+    defaultDegradedErrorPercent = 10
+)
+
+// Levels of readiness, worst last. A degraded instance still takes traffic,
+// but something about it is worth an alert.
+const (
+    HealthHealthy   = "healthy"
+    HealthDegraded  = "degraded"
+    HealthUnhealthy = "unhealthy"
+)
+
+// healthRank orders the levels, so the worst dependency sets the overall one.
+var healthRank = map[string]int{HealthHealthy: 0, HealthDegraded: 1, HealthUnhealthy: 2}
+
+// DependencyHealth is how one dependency looked when readiness was checked.
+type DependencyHealth struct {
+    Status    string  `json:"status"`
+    LatencyMS float64 `json:"latency_ms"`
+    // ErrorRate is the fraction of recent calls that failed, left out until
+    // there have been enough calls to tell.
+    ErrorRate *float64 `json:"error_rate,omitempty"`
+    Circuit   string   `json:"circuit,omitempty"`
+    // Reasons say why the dependency isn't healthy.
+    Reasons []string `json:"reasons,omitempty"`
+}
+
+// Readiness is the body of GET /health/ready.
+type Readiness struct {
+    Status  string                      `json:"status"`
+    Service string                      `json:"service"`
+    Checks  map[string]DependencyHealth `json:"checks"`
+}
+
+// readinessCheck probes the service's dependencies and caches the result
+// for ttl, so frequent load balancer probes don't load the payment service.
+//
+// The payment service is unhealthy if its health endpoint doesn't answer,
+// and degraded if it answers slower than degradedLatency, its circuit
+// breaker isn't closed, or more than degradedErrorRate of recent charges
+// failed.
+type readinessCheck struct {
+    mu                sync.Mutex
+    ttl               time.Duration
+    timeout           time.Duration
+    degradedLatency   time.Duration
+    degradedErrorRate float64
+    now               func() time.Time
+    probe             func(ctx context.Context) error
+
+    checked   bool
+    checkedAt time.Time
+    result    Readiness
+}
+
+func newReadinessCheck(ttl, timeout time.Duration) *readinessCheck {
+    return &readinessCheck{
+        ttl:               ttl,
+        timeout:           timeout,
+        degradedLatency:   defaultDegradedLatency,
+        degradedErrorRate: defaultDegradedErrorPercent / 100.0,
+        now:               currentTime,
+        probe: func(ctx context.Context) error {
+            return payments.ping(ctx)
+        },
+    }
+}
+
+var readiness = newReadinessCheck(defaultReadinessCacheTTL, defaultReadinessTimeout)
+
+// configureReadinessFromEnv reads HEALTH_DEGRADED_LATENCY and
+// HEALTH_DEGRADED_ERROR_PERCENT.
+func configureReadinessFromEnv() error {
+    latency, err := envDuration("HEALTH_DEGRADED_LATENCY", defaultDegradedLatency)
+    if err != nil {
+        return err
+    }
+    percent, err := envInt("HEALTH_DEGRADED_ERROR_PERCENT", defaultDegradedErrorPercent)
+    if err != nil {
+        return err
+    }
+    if percent < 0 || percent > 100 {
+        return fmt.Errorf("HEALTH_DEGRADED_ERROR_PERCENT must be between 0 and 100, got %d", percent)
+    }
+    readiness.degradedLatency = latency
+    readiness.degradedErrorRate = float64(percent) / 100
+    return nil
+}
+
+// check returns the cached result while it is fresh and probes otherwise.
+// Concurrent callers wait for a single probe rather than each making one.
+func (r *readinessCheck) check(ctx context.Context) Readiness {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if r.checked && r.now().Sub(r.checkedAt) < r.ttl {
+        return r.result
+    }
+    ctx, cancel := context.WithTimeout(ctx, r.timeout)
+    defer cancel()
+
+    checks := map[string]DependencyHealth{"payment_service": r.checkPayments(ctx)}
+    status := HealthHealthy
+    for _, dep := range checks {
+        if healthRank[dep.Status] > healthRank[status] {
+            status = dep.Status
+        }
+    }
+    r.result = Readiness{Status: status, Service: "order-service", Checks: checks}
+    r.checked = true
+    r.checkedAt = r.now()
+    return r.result
+}
+
+// checkPayments probes the payment service and judges it by how long the
+// probe took and how its recent charges went.
+func (r *readinessCheck) checkPayments(ctx context.Context) DependencyHealth {
+    start := time.Now()
+    err := r.probe(ctx)
+    latency := time.Since(start)
+
+    dep := DependencyHealth{
+        Status:    HealthHealthy,
+        LatencyMS: float64(latency.Microseconds()) / 1000,
+        Circuit:   payments.Breaker.State(),
+    }
+    if err != nil {
+        dep.Status = HealthUnhealthy
+        dep.Reasons = []string{err.Error()}
+        return dep
+    }
+    if latency > r.degradedLatency {
+        dep.Reasons = append(dep.Reasons, fmt.Sprintf("health check took %s, more than %s", latency.Round(time.Millisecond), r.degradedLatency))
+    }
+    if dep.Circuit != BreakerClosed {
+        dep.Reasons = append(dep.Reasons, "circuit breaker is "+dep.Circuit)
+    }
+    if rate, ok := payments.Outcomes.ErrorRate(); ok {
+        dep.ErrorRate = &rate
+        if rate > r.degradedErrorRate {
+            dep.Reasons = append(dep.Reasons, fmt.Sprintf("%.0f%% of recent charges failed", rate*100))
+        }
+    }
+    if len(dep.Reasons) > 0 {
+        dep.Status = HealthDegraded
+    }
+    return dep
+}
+
+func health(c *gin.Context) {
+    info := currentBuildInfo()
+    c.JSON(http.StatusOK, gin.H{
+        "status":          "healthy",
+        "service":         "order-service",
+        "payment_circuit": payments.Breaker.State(),
+        "version":         info.Version,
+        "commit":          info.Commit,
+        "uptime_seconds":  info.UptimeSeconds,
+    })
+}
+
+// liveness reports that the process is up and serving; it never checks
+// dependencies, so a payment outage doesn't get the instance restarted.
+func liveness(c *gin.Context) {
+    c.JSON(http.StatusOK, gin.H{"status": "alive", "service": "order-service"})
+}
+
+// readinessHandler reports whether the instance should receive traffic:
+// 200 while it is healthy or degraded, 503 once it is unhealthy, such as
+// while the payment service is unreachable, or draining.
+func readinessHandler(c *gin.Context) {
+    if drainer.Draining() {
+        c.JSON(http.StatusServiceUnavailable, Readiness{Status: HealthDraining, Service: "order-service"})
+        return
+    }
+    result := readiness.check(c.Request.Context())
+    status := http.StatusOK
+    if result.Status == HealthUnhealthy {
+        status = http.StatusServiceUnavailable
+    }
+    c.JSON(status, result)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+)
+
+// resetReadiness swaps in a readiness check with an empty cache and a
+// controllable clock for the duration of the test.
+func resetReadiness(t *testing.T) *time.Time {
+    t.Helper()
+
+    now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    prev := readiness
+    readiness = newReadinessCheck(defaultReadinessCacheTTL, defaultReadinessTimeout)
+    readiness.now = func() time.Time { return now }
+    t.Cleanup(func() { readiness = prev })
+    return &now
+}
+
+func TestLivenessIgnoresDependencies(t *testing.T) {
+    fake := newPaymentServer(t)
+    fake.Close()
+    r := setupRouter()
+
+    if w := doRequest(r, http.MethodGet, "/health/live", ""); w.Code != http.StatusOK {
+        t.Fatalf("got status %d, want 200", w.Code)
+    }
+}
+
+func TestReadinessReportsPaymentService(t *testing.T) {
+    fake := newPaymentServer(t)
+    now := resetReadiness(t)
+    r := setupRouter()
+
+    if w := doRequest(r, http.MethodGet, "/health/ready", ""); w.Code != http.StatusOK {
+        t.Fatalf("got status %d while payment service is up, want 200: %s", w.Code, w.Body)
+    }
+
+    fake.Close()
+    if w := doRequest(r, http.MethodGet, "/health/ready", ""); w.Code != http.StatusOK {
+        t.Fatalf("got status %d before the cached result expired, want 200", w.Code)
+    }
+
+    *now = now.Add(defaultReadinessCacheTTL)
+    if w := doRequest(r, http.MethodGet, "/health/ready", ""); w.Code != http.StatusServiceUnavailable {
+        t.Fatalf("got status %d while payment service is down, want 503", w.Code)
+    }
+}
+
+func TestReadinessCachesProbe(t *testing.T) {
+    fake := newPaymentServer(t)
+    now := resetReadiness(t)
+    r := setupRouter()
+
+    for i := 0; i < 5; i++ {
+        doRequest(r, http.MethodGet, "/health/ready", "")
+    }
+    if n := fake.healthChecks.Load(); n != 1 {
+        t.Fatalf("payment service probed %d times within the cache TTL, want 1", n)
+    }
+
+    *now = now.Add(defaultReadinessCacheTTL)
+    doRequest(r, http.MethodGet, "/health/ready", "")
+    if n := fake.healthChecks.Load(); n != 2 {
+        t.Fatalf("payment service probed %d times after the TTL, want 2", n)
+    }
+}
+
+func getReadiness(t *testing.T, wantCode int) Readiness {
+    t.Helper()
+
+    w := doRequest(setupRouter(), http.MethodGet, "/health/ready", "")
+    if w.Code != wantCode {
+        t.Fatalf("got status %d, want %d: %s", w.Code, wantCode, w.Body)
+    }
+    var result Readiness
+    json.Unmarshal(w.Body.Bytes(), &result)
+    return result
+}
+
+func TestReadinessLevels(t *testing.T) {
+    tests := []struct {
+        name     string
+        setup    func(t *testing.T)
+        code     int
+        level    string
+        contains string
+    }{
+        {
+            name:  "healthy",
+            setup: func(t *testing.T) {},
+            code:  http.StatusOK,
+            level: HealthHealthy,
+        },
+        {
+            name: "slow health check",
+            setup: func(t *testing.T) {
+                readiness.degradedLatency = 10 * time.Millisecond
+                readiness.probe = func(ctx context.Context) error {
+                    time.Sleep(20 * time.Millisecond)
+                    return nil
+                }
+            },
+            code:     http.StatusOK,
+            level:    HealthDegraded,
+            contains: "health check took",
+        },
+        {
+            name: "charges failing",
+            setup: func(t *testing.T) {
+                for i := 0; i < minOutcomesForRate; i++ {
+                    payments.Outcomes.Record(i%4 != 0)
+                }
+            },
+            code:     http.StatusOK,
+            level:    HealthDegraded,
+            contains: "25% of recent charges failed",
+        },
+        {
+            name: "circuit open",
+            setup: func(t *testing.T) {
+                for i := 0; i < defaultBreakerThreshold; i++ {
+                    payments.Breaker.Record(false)
+                }
+            },
+            code:     http.StatusOK,
+            level:    HealthDegraded,
+            contains: "circuit breaker is open",
+        },
+        {
+            name: "unreachable",
+            setup: func(t *testing.T) {
+                readiness.probe = func(ctx context.Context) error { return context.DeadlineExceeded }
+            },
+            code:     http.StatusServiceUnavailable,
+            level:    HealthUnhealthy,
+            contains: "deadline exceeded",
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            newPaymentServer(t)
+            resetReadiness(t)
+            tt.setup(t)
+
+            result := getReadiness(t, tt.code)
+            dep := result.Checks["payment_service"]
+            if result.Status != tt.level || dep.Status != tt.level {
+                t.Fatalf("got %s with payment service %s, want %s", result.Status, dep.Status, tt.level)
+            }
+            if reasons := strings.Join(dep.Reasons, "; "); tt.contains != "" && !strings.Contains(reasons, tt.contains) {
+                t.Fatalf("got reasons %q, want one about %q", reasons, tt.contains)
+            }
+        })
+    }
+}
+
+func TestReadinessReportsErrorRateOnceKnown(t *testing.T) {
+    newPaymentServer(t)
+    resetReadiness(t)
+    payments.Outcomes.Record(false)
+
+    if dep := getReadiness(t, http.StatusOK).Checks["payment_service"]; dep.ErrorRate != nil || dep.Status != HealthHealthy {
+        t.Fatalf("one failed charge: got %+v, want healthy without an error rate", dep)
+    }
+}
+
+func TestOutcomeWindowForgetsOldCalls(t *testing.T) {
+    w := NewOutcomeWindow(minOutcomesForRate)
+    for i := 0; i < minOutcomesForRate; i++ {
+        w.Record(false)
+    }
+    if rate, ok := w.ErrorRate(); !ok || rate != 1 {
+        t.Fatalf("got %v, %v; want every call failed", rate, ok)
+    }
+    for i := 0; i < minOutcomesForRate/2; i++ {
+        w.Record(true)
+    }
+    if rate, _ := w.ErrorRate(); rate != 0.5 {
+        t.Fatalf("got error rate %v, want 0.5 once half the window succeeded", rate)
+    }
+}
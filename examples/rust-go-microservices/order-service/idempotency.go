@@ -0,0 +1,227 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "sync"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+const (
+    idempotencyKeyHeader  = "Idempotency-Key"
+    defaultIdempotencyTTL = 24 * time.Hour
+
+    // sharedKeyPrefix namespaces the service's keys in a shared store.
+    sharedKeyPrefix = "order-service:idempotency:"
+    // sharedPending is the value of a claimed key whose order isn't created
+    // yet. It expires after sharedClaimTTL, so that a key claimed by an
+    // instance that died mid-request doesn't stay locked for good.
+    sharedPending  = "pending"
+    sharedClaimTTL = 5 * time.Minute
+    // sharedPollInterval is how often an instance waiting for another to
+    // finish with a key checks on it.
+    sharedPollInterval = 50 * time.Millisecond
+)
+
+// SharedStore is a key-value store shared by every instance of the service,
+// such as Redis, that coordinates idempotency keys across them. Its methods
+// mirror the Redis commands of the same names.
+type SharedStore interface {
+    // SetNX sets key to value, expiring after ttl, unless key is already
+    // set, and reports whether it did.
+    SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+    Set(ctx context.Context, key, value string, ttl time.Duration) error
+    // Get returns key's value, with ok=false if it isn't set.
+    Get(ctx context.Context, key string) (value string, ok bool, err error)
+    Del(ctx context.Context, key string) error
+}
+
+type idempotencyEntry struct {
+    done      chan struct{}
+    completed bool
+    orderID   uuid.UUID
+    expiresAt time.Time
+}
+
+// IdempotencyStore remembers which order was created for each Idempotency-Key
+// so that retried requests return the original order instead of creating and
+// charging a new one.
+//
+// On its own it only knows the keys this instance has seen. With a shared
+// store, a key is claimed there too, so only one instance behind a load
+// balancer processes it; the local entries then act as a cache, and keep
+// concurrent requests within the instance from all polling the shared store.
+type IdempotencyStore struct {
+    mu        sync.Mutex
+    ttl       time.Duration
+    now       func() time.Time
+    entries   map[string]*idempotencyEntry
+    lastPrune time.Time
+
+    shared       SharedStore
+    pollInterval time.Duration
+}
+
+func NewIdempotencyStore(ttl time.Duration) *IdempotencyStore {
+    return &IdempotencyStore{
+        ttl:     ttl,
+        now:     currentTime,
+        entries: make(map[string]*idempotencyEntry),
+    }
+}
+
+// NewSharedIdempotencyStore returns an IdempotencyStore that coordinates
+// keys with other instances through shared.
+func NewSharedIdempotencyStore(ttl time.Duration, shared SharedStore) *IdempotencyStore {
+    s := NewIdempotencyStore(ttl)
+    s.shared = shared
+    s.pollInterval = sharedPollInterval
+    return s
+}
+
+// Begin claims key for the caller. If an order was already created under key
+// it returns that order's ID and found=true. Otherwise the caller owns the key
+// and must call Finish or Abandon; concurrent Begin calls for the same key
+// block until it does, or until ctx is done. An error means the key could
+// not be claimed, because ctx is done or the shared store failed.
+func (s *IdempotencyStore) Begin(ctx context.Context, key string) (orderID uuid.UUID, found bool, err error) {
+    for {
+        s.mu.Lock()
+        s.pruneLocked()
+        entry, exists := s.entries[key]
+        if exists && entry.completed && !s.now().Before(entry.expiresAt) {
+            delete(s.entries, key)
+            exists = false
+        }
+        if !exists {
+            s.entries[key] = &idempotencyEntry{done: make(chan struct{})}
+            s.mu.Unlock()
+            if s.shared == nil {
+                return uuid.Nil, false, nil
+            }
+            return s.claimShared(ctx, key)
+        }
+        if entry.completed {
+            s.mu.Unlock()
+            return entry.orderID, true, nil
+        }
+        done := entry.done
+        s.mu.Unlock()
+
+        select {
+        case <-done:
+        case <-ctx.Done():
+            return uuid.Nil, false, ctx.Err()
+        }
+    }
+}
+
+// claimShared claims key in the shared store once this instance holds it
+// locally: either the claim succeeds, another instance turns out to have
+// created an order under key already, or the claim fails and key is
+// released locally as well.
+func (s *IdempotencyStore) claimShared(ctx context.Context, key string) (uuid.UUID, bool, error) {
+    for {
+        claimed, err := s.shared.SetNX(ctx, sharedKeyPrefix+key, sharedPending, sharedClaimTTL)
+        if err != nil {
+            s.release(key)
+            return uuid.Nil, false, err
+        }
+        if claimed {
+            return uuid.Nil, false, nil
+        }
+
+        value, ok, err := s.shared.Get(ctx, sharedKeyPrefix+key)
+        if err != nil {
+            s.release(key)
+            return uuid.Nil, false, err
+        }
+        if ok && value != sharedPending {
+            orderID, err := uuid.Parse(value)
+            if err != nil {
+                s.release(key)
+                return uuid.Nil, false, fmt.Errorf("idempotency key %q: stored order ID: %w", key, err)
+            }
+            s.complete(key, orderID)
+            return orderID, true, nil
+        }
+        if !ok {
+            // The other instance gave the key up, or its claim expired.
+            continue
+        }
+
+        select {
+        case <-time.After(s.pollInterval):
+        case <-ctx.Done():
+            s.release(key)
+            return uuid.Nil, false, ctx.Err()
+        }
+    }
+}
+
+// Finish records that the request owning key created orderID. Failing to
+// record it in the shared store is only logged: the order exists either
+// way, and other instances treat the key as new once the claim expires.
+func (s *IdempotencyStore) Finish(ctx context.Context, key string, orderID uuid.UUID) {
+    if s.shared != nil {
+        if err := s.shared.Set(ctx, sharedKeyPrefix+key, orderID.String(), s.ttl); err != nil {
+            loggerFrom(ctx).Warn("failed to record idempotency key", "error", err)
+        }
+    }
+    s.complete(key, orderID)
+}
+
+// complete marks key's local entry as having created orderID.
+func (s *IdempotencyStore) complete(key string, orderID uuid.UUID) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    entry, exists := s.entries[key]
+    if !exists || entry.completed {
+        return
+    }
+    entry.completed = true
+    entry.orderID = orderID
+    entry.expiresAt = s.now().Add(s.ttl)
+    close(entry.done)
+}
+
+// Abandon releases key without recording an order, letting a waiting or
+// future request with the same key try again.
+func (s *IdempotencyStore) Abandon(ctx context.Context, key string) {
+    if s.shared != nil {
+        if err := s.shared.Del(ctx, sharedKeyPrefix+key); err != nil {
+            loggerFrom(ctx).Warn("failed to release idempotency key", "error", err)
+        }
+    }
+    s.release(key)
+}
+
+// release drops key's local entry, waking any requests waiting on it.
+func (s *IdempotencyStore) release(key string) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    entry, exists := s.entries[key]
+    if !exists || entry.completed {
+        return
+    }
+    delete(s.entries, key)
+    close(entry.done)
+}
+
+// pruneLocked drops expired keys, at most once a minute.
+func (s *IdempotencyStore) pruneLocked() {
+    now := s.now()
+    if now.Sub(s.lastPrune) < time.Minute {
+        return
+    }
+    s.lastPrune = now
+    for key, entry := range s.entries {
+        if entry.completed && !now.Before(entry.expiresAt) {
+            delete(s.entries, key)
+        }
+    }
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// resetIdempotency swaps in an empty idempotency store for the duration of
+// the test and returns it.
+func resetIdempotency(t *testing.T) *IdempotencyStore {
+    t.Helper()
+
+    prev := idempotencyKeys
+    idempotencyKeys = NewIdempotencyStore(defaultIdempotencyTTL)
+    t.Cleanup(func() { idempotencyKeys = prev })
+    return idempotencyKeys
+}
+
+func createWithKey(t *testing.T, r http.Handler, key string) (int, Order) {
+    t.Helper()
+
+    w := doRequestWithHeaders(r, http.MethodPost, "/orders", sampleOrder, map[string]string{idempotencyKeyHeader: key})
+    var order Order
+    if err := json.Unmarshal(w.Body.Bytes(), &order); err != nil {
+        t.Errorf("decode: %v", err)
+    }
+    return w.Code, order
+}
+
+func TestIdempotentCreateReturnsOriginal(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    resetIdempotency(t)
+    r := setupRouter()
+
+    code, first := createWithKey(t, r, "key-1")
+    if code != http.StatusCreated {
+        t.Fatalf("first create: got status %d", code)
+    }
+    code, second := createWithKey(t, r, "key-1")
+    if code != http.StatusOK {
+        t.Fatalf("repeat create: got status %d, want 200", code)
+    }
+    if second.OrderID != first.OrderID {
+        t.Fatalf("repeat returned order %s, want %s", second.OrderID, first.OrderID)
+    }
+    if n := fake.charges.Load(); n != 1 {
+        t.Fatalf("customer charged %d times", n)
+    }
+    if list, _ := orders.List(); len(list) != 1 {
+        t.Fatalf("%d orders stored, want 1", len(list))
+    }
+}
+
+func TestIdempotentCreateConcurrentDuplicate(t *testing.T) {
+    fake := newPaymentServer(t)
+    fake.delay = 50 * time.Millisecond
+    resetOrders(t)
+    resetIdempotency(t)
+    r := setupRouter()
+
+    var wg sync.WaitGroup
+    codes := make([]int, 2)
+    results := make([]Order, 2)
+    for i := range codes {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            codes[i], results[i] = createWithKey(t, r, "key-race")
+        }(i)
+    }
+    wg.Wait()
+
+    if results[0].OrderID != results[1].OrderID {
+        t.Fatalf("concurrent duplicates created distinct orders %s and %s", results[0].OrderID, results[1].OrderID)
+    }
+    if codes[0]+codes[1] != http.StatusCreated+http.StatusOK {
+        t.Fatalf("got statuses %v, want one 201 and one 200", codes)
+    }
+    if n := fake.charges.Load(); n != 1 {
+        t.Fatalf("customer charged %d times", n)
+    }
+}
+
+func TestIdempotencyKeyExpires(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    store := resetIdempotency(t)
+    now := time.Now()
+    store.now = func() time.Time { return now }
+    r := setupRouter()
+
+    _, first := createWithKey(t, r, "key-ttl")
+    now = now.Add(defaultIdempotencyTTL + time.Second)
+    code, second := createWithKey(t, r, "key-ttl")
+
+    if code != http.StatusCreated || second.OrderID == first.OrderID {
+        t.Fatalf("expired key was not treated as new: status %d, id %s", code, second.OrderID)
+    }
+    if n := fake.charges.Load(); n != 2 {
+        t.Fatalf("got %d charges, want 2", n)
+    }
+}
+
+// fakeShared is an in-memory SharedStore standing in for Redis between
+// several IdempotencyStores, as if each were a separate instance.
+type fakeShared struct {
+    mu     sync.Mutex
+    values map[string]string
+    fail   error
+}
+
+func newFakeShared() *fakeShared {
+    return &fakeShared{values: make(map[string]string)}
+}
+
+func (f *fakeShared) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if f.fail != nil {
+        return false, f.fail
+    }
+    if _, ok := f.values[key]; ok {
+        return false, nil
+    }
+    f.values[key] = value
+    return true, nil
+}
+
+func (f *fakeShared) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.values[key] = value
+    return f.fail
+}
+
+func (f *fakeShared) Get(ctx context.Context, key string) (string, bool, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    v, ok := f.values[key]
+    return v, ok, f.fail
+}
+
+func (f *fakeShared) Del(ctx context.Context, key string) error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    delete(f.values, key)
+    return f.fail
+}
+
+func newInstances(shared SharedStore, n int) []*IdempotencyStore {
+    instances := make([]*IdempotencyStore, n)
+    for i := range instances {
+        instances[i] = NewSharedIdempotencyStore(defaultIdempotencyTTL, shared)
+        instances[i].pollInterval = time.Millisecond
+    }
+    return instances
+}
+
+func TestSharedIdempotencyInstancesRace(t *testing.T) {
+    instances := newInstances(newFakeShared(), 2)
+    orderID := uuid.New()
+
+    var wg sync.WaitGroup
+    var claims atomic.Int32
+    results := make([]uuid.UUID, len(instances))
+    for i, store := range instances {
+        wg.Add(1)
+        go func(i int, store *IdempotencyStore) {
+            defer wg.Done()
+            id, found, err := store.Begin(context.Background(), "key-shared")
+            if err != nil {
+                t.Errorf("instance %d: %v", i, err)
+                return
+            }
+            if !found {
+                claims.Add(1)
+                time.Sleep(10 * time.Millisecond)
+                store.Finish(context.Background(), "key-shared", orderID)
+                id = orderID
+            }
+            results[i] = id
+        }(i, store)
+    }
+    wg.Wait()
+
+    if n := claims.Load(); n != 1 {
+        t.Fatalf("%d instances claimed the key, want 1", n)
+    }
+    for i, id := range results {
+        if id != orderID {
+            t.Errorf("instance %d got order %s, want %s", i, id, orderID)
+        }
+    }
+}
+
+func TestSharedIdempotencyAbandonLetsAnotherInstanceClaim(t *testing.T) {
+    instances := newInstances(newFakeShared(), 2)
+    ctx := context.Background()
+
+    if _, found, err := instances[0].Begin(ctx, "key-retry"); found || err != nil {
+        t.Fatalf("first claim: found=%v err=%v", found, err)
+    }
+    claimed := make(chan error)
+    go func() {
+        _, found, err := instances[1].Begin(ctx, "key-retry")
+        if err == nil && found {
+            err = errors.New("found an order that was never created")
+        }
+        claimed <- err
+    }()
+
+    select {
+    case err := <-claimed:
+        t.Fatalf("second instance claimed a held key: %v", err)
+    case <-time.After(20 * time.Millisecond):
+    }
+    instances[0].Abandon(ctx, "key-retry")
+    if err := <-claimed; err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestSharedIdempotencyWaitHonoursContext(t *testing.T) {
+    instances := newInstances(newFakeShared(), 2)
+    instances[0].Begin(context.Background(), "key-held")
+
+    ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+    defer cancel()
+    if _, _, err := instances[1].Begin(ctx, "key-held"); !errors.Is(err, context.DeadlineExceeded) {
+        t.Fatalf("got %v, want deadline exceeded", err)
+    }
+    // The failed wait must not leave the key held locally.
+    instances[0].Finish(context.Background(), "key-held", uuid.New())
+    if _, found, err := instances[1].Begin(context.Background(), "key-held"); !found || err != nil {
+        t.Fatalf("after finish: found=%v err=%v", found, err)
+    }
+}
+
+func TestSharedIdempotencyStoreUnavailable(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    shared := newFakeShared()
+    shared.fail = errors.New("connection refused")
+    prev := idempotencyKeys
+    idempotencyKeys = NewSharedIdempotencyStore(defaultIdempotencyTTL, shared)
+    t.Cleanup(func() { idempotencyKeys = prev })
+    r := setupRouter()
+
+    w := doRequestWithHeaders(r, http.MethodPost, "/orders", sampleOrder, map[string]string{idempotencyKeyHeader: "key-down"})
+    if w.Code != http.StatusServiceUnavailable {
+        t.Fatalf("got status %d, want 503", w.Code)
+    }
+    if got := decodeError(t, w).Code; got != CodeIdempotencyUnavailable {
+        t.Fatalf("got code %q, want %q", got, CodeIdempotencyUnavailable)
+    }
+    if n := fake.charges.Load(); n != 0 {
+        t.Fatalf("customer charged %d times", n)
+    }
+}
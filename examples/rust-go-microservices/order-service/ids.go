@@ -0,0 +1,40 @@
+package main
+
+import (
+    "fmt"
+    "os"
+
+    "github.com/google/uuid"
+)
+
+// IDGenerator assigns IDs to new orders.
+type IDGenerator interface {
+    NewID() uuid.UUID
+}
+
+// RandomIDs generates random (version 4) UUIDs.
+type RandomIDs struct{}
+
+func (RandomIDs) NewID() uuid.UUID { return uuid.New() }
+
+// TimeOrderedIDs generates version 7 UUIDs, which sort by creation time and
+// so keep inserts at the end of an index instead of scattered through it.
+type TimeOrderedIDs struct{}
+
+func (TimeOrderedIDs) NewID() uuid.UUID { return uuid.Must(uuid.NewV7()) }
+
+// orderIDs generates the ID of every new order.
+var orderIDs IDGenerator = RandomIDs{}
+
+// idGeneratorFromEnv reads ORDER_ID_VERSION: "v4" (the default) for random
+// IDs or "v7" for time-ordered ones.
+func idGeneratorFromEnv() (IDGenerator, error) {
+    switch v := os.Getenv("ORDER_ID_VERSION"); v {
+    case "", "v4":
+        return RandomIDs{}, nil
+    case "v7":
+        return TimeOrderedIDs{}, nil
+    default:
+        return nil, fmt.Errorf("ORDER_ID_VERSION must be v4 or v7, got %q", v)
+    }
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+    "sync"
+    "testing"
+
+    "github.com/google/uuid"
+)
+
+// sequenceIDs hands out predictable IDs: ...0001, ...0002 and so on.
+type sequenceIDs struct {
+    mu   sync.Mutex
+    next uint16
+}
+
+func (s *sequenceIDs) NewID() uuid.UUID {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.next++
+    var id uuid.UUID
+    id[14], id[15] = byte(s.next>>8), byte(s.next)
+    return id
+}
+
+// useIDs swaps in gen as the order ID generator for the duration of the
+// test.
+func useIDs(t *testing.T, gen IDGenerator) {
+    t.Helper()
+
+    prev := orderIDs
+    orderIDs = gen
+    t.Cleanup(func() { orderIDs = prev })
+}
+
+func TestOrderIDsComeFromGenerator(t *testing.T) {
+    resetOrders(t)
+    newPaymentServer(t)
+    useIDs(t, &sequenceIDs{})
+    r := setupRouter()
+
+    for _, want := range []string{"00000000-0000-0000-0000-000000000001", "00000000-0000-0000-0000-000000000002"} {
+        w := doRequest(r, http.MethodPost, "/orders", sampleOrder)
+        if w.Code != http.StatusCreated {
+            t.Fatalf("got status %d: %s", w.Code, w.Body)
+        }
+        var order Order
+        if err := json.Unmarshal(w.Body.Bytes(), &order); err != nil {
+            t.Fatal(err)
+        }
+        if order.OrderID.String() != want {
+            t.Fatalf("got order ID %s, want %s", order.OrderID, want)
+        }
+        if got := w.Header().Get("Location"); got != "/orders/"+want {
+            t.Fatalf("got Location %q", got)
+        }
+    }
+}
+
+func TestTimeOrderedIDsSort(t *testing.T) {
+    var gen TimeOrderedIDs
+    prev := gen.NewID()
+    if prev.Version() != 7 {
+        t.Fatalf("got version %d, want 7", prev.Version())
+    }
+    for i := 0; i < 1000; i++ {
+        id := gen.NewID()
+        if bytes.Compare(prev[:], id[:]) >= 0 {
+            t.Fatalf("ID %s generated after %s sorts before it", id, prev)
+        }
+        prev = id
+    }
+}
+
+func TestIDGeneratorFromEnv(t *testing.T) {
+    for env, want := range map[string]IDGenerator{"": RandomIDs{}, "v4": RandomIDs{}, "v7": TimeOrderedIDs{}} {
+        t.Setenv("ORDER_ID_VERSION", env)
+        got, err := idGeneratorFromEnv()
+        if err != nil || got != want {
+            t.Errorf("ORDER_ID_VERSION=%q: got %T, %v", env, got, err)
+        }
+    }
+
+    t.Setenv("ORDER_ID_VERSION", "v1")
+    if _, err := idGeneratorFromEnv(); err == nil {
+        t.Fatal("expected an error for ORDER_ID_VERSION=v1")
+    }
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "os"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// ErrOutOfStock is returned when the inventory service can't reserve the
+// requested quantity of a product.
+var ErrOutOfStock = errors.New("insufficient stock")
+
+type ReservationRequest struct {
+    OrderID   uuid.UUID `json:"order_id"`
+    ProductID string    `json:"product_id"`
+    Quantity  int       `json:"quantity"`
+}
+
+type ReservationResponse struct {
+    ReservationID string `json:"reservation_id"`
+}
+
+// InventoryClient reserves and releases stock with the inventory service.
+type InventoryClient struct {
+    BaseURL    string
+    HTTPClient *http.Client
+    // Timeout bounds each call.
+    Timeout time.Duration
+}
+
+func NewInventoryClient(baseURL string) *InventoryClient {
+    return &InventoryClient{
+        BaseURL:    baseURL,
+        HTTPClient: &http.Client{},
+        Timeout:    defaultInventoryTimeout,
+    }
+}
+
+// inventory is nil when no inventory service is configured, in which case
+// orders are not checked against stock.
+var inventory *InventoryClient
+
+// newInventoryClientFromEnv builds the client from INVENTORY_SERVICE_URL,
+// returning nil if it is unset.
+func newInventoryClientFromEnv() (*InventoryClient, error) {
+    raw := os.Getenv("INVENTORY_SERVICE_URL")
+    if raw == "" {
+        return nil, nil
+    }
+    baseURL, err := parseServiceURL(raw)
+    if err != nil {
+        return nil, fmt.Errorf("INVENTORY_SERVICE_URL: %w", err)
+    }
+    return NewInventoryClient(baseURL), nil
+}
+
+// Reserve holds quantity units of productID for orderID, returning the
+// reservation ID. It returns ErrOutOfStock if the stock isn't there.
+func (c *InventoryClient) Reserve(ctx context.Context, orderID uuid.UUID, productID string, quantity int) (string, error) {
+    jsonData, err := json.Marshal(ReservationRequest{OrderID: orderID, ProductID: productID, Quantity: quantity})
+    if err != nil {
+        return "", err
+    }
+    ctx, cancel := callTimeout(ctx, c.Timeout)
+    defer cancel()
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/reservations", bytes.NewReader(jsonData))
+    if err != nil {
+        return "", err
+    }
+    req.Header.Set("Content-Type", "application/json")
+
+    resp, err := c.HTTPClient.Do(req)
+    if err != nil {
+        return "", err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode == http.StatusConflict {
+        return "", fmt.Errorf("%s: %w", productID, ErrOutOfStock)
+    }
+    if resp.StatusCode >= 300 {
+        return "", fmt.Errorf("inventory service returned status %d", resp.StatusCode)
+    }
+
+    var reservation ReservationResponse
+    if err := json.NewDecoder(resp.Body).Decode(&reservation); err != nil {
+        return "", err
+    }
+    return reservation.ReservationID, nil
+}
+
+// Release returns a reservation's stock.
+func (c *InventoryClient) Release(ctx context.Context, reservationID string) error {
+    ctx, cancel := callTimeout(ctx, c.Timeout)
+    defer cancel()
+    req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.BaseURL+"/reservations/"+url.PathEscape(reservationID), nil)
+    if err != nil {
+        return err
+    }
+
+    resp, err := c.HTTPClient.Do(req)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+
+    if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+        return fmt.Errorf("inventory service returned status %d", resp.StatusCode)
+    }
+    return nil
+}
+
+// releasesStock reports whether order, just moved to its status, will
+// never be fulfilled, so the stock reserved for it should go back. A
+// refunded order only is if none of it was shipped.
+func releasesStock(order *Order) bool {
+    switch order.Status {
+    case StatusCancelled, StatusPaymentFailed, StatusExpired:
+        return true
+    case StatusRefunded:
+        return len(order.Shipments) == 0
+    }
+    return false
+}
+
+// releaseStock releases the reservations held for order if its status
+// means it won't be fulfilled, and forgets them so they are released only
+// once. Call it after moving an order to a new status and before saving
+// it. A failed release is logged, leaving the inventory service to expire
+// the reservation.
+func releaseStock(ctx context.Context, order *Order) {
+    if releasesStock(order) {
+        releaseReservations(ctx, order)
+    }
+}
+
+// releaseReservations releases every reservation held for order, whatever
+// its status, and forgets them.
+func releaseReservations(ctx context.Context, order *Order) {
+    if inventory != nil {
+        for _, reservationID := range order.ReservationIDs {
+            if err := inventory.Release(ctx, reservationID); err != nil {
+                loggerFrom(ctx).Error("failed to release reservation", "order_id", order.OrderID, "reservation_id", reservationID, "error", err)
+            }
+        }
+    }
+    order.ReservationIDs = nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// fakeInventory is an inventory service holding a fixed stock level per
+// product.
+type fakeInventory struct {
+    mu           sync.Mutex
+    stock        map[string]int
+    reservations map[string]ReservationRequest
+    released     int
+}
+
+// newInventoryServer starts a fakeInventory with the given stock and points
+// the inventory client at it for the duration of the test.
+func newInventoryServer(t *testing.T, stock map[string]int) *fakeInventory {
+    t.Helper()
+
+    fake := &fakeInventory{stock: stock, reservations: make(map[string]ReservationRequest)}
+    mux := http.NewServeMux()
+    mux.HandleFunc("/reservations", func(w http.ResponseWriter, r *http.Request) {
+        var req ReservationRequest
+        json.NewDecoder(r.Body).Decode(&req)
+
+        fake.mu.Lock()
+        defer fake.mu.Unlock()
+        if fake.stock[req.ProductID] < req.Quantity {
+            w.WriteHeader(http.StatusConflict)
+            return
+        }
+        fake.stock[req.ProductID] -= req.Quantity
+        id := uuid.NewString()
+        fake.reservations[id] = req
+        w.WriteHeader(http.StatusCreated)
+        json.NewEncoder(w).Encode(ReservationResponse{ReservationID: id})
+    })
+    mux.HandleFunc("/reservations/", func(w http.ResponseWriter, r *http.Request) {
+        id := strings.TrimPrefix(r.URL.Path, "/reservations/")
+
+        fake.mu.Lock()
+        defer fake.mu.Unlock()
+        req, ok := fake.reservations[id]
+        if !ok {
+            w.WriteHeader(http.StatusNotFound)
+            return
+        }
+        delete(fake.reservations, id)
+        fake.stock[req.ProductID] += req.Quantity
+        fake.released++
+        w.WriteHeader(http.StatusNoContent)
+    })
+    srv := httptest.NewServer(mux)
+    t.Cleanup(srv.Close)
+
+    prev := inventory
+    inventory = NewInventoryClient(srv.URL)
+    t.Cleanup(func() { inventory = prev })
+
+    return fake
+}
+
+func (f *fakeInventory) snapshot() (stock map[string]int, held, released int) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    stock = make(map[string]int, len(f.stock))
+    for k, v := range f.stock {
+        stock[k] = v
+    }
+    return stock, len(f.reservations), f.released
+}
+
+const twoItemOrder = `{"customer_id":"c","items":[
+    {"product_id":"apple","quantity":2,"price":"1.00"},
+    {"product_id":"pear","quantity":3,"price":"2.00"}]}`
+
+func TestCreateOrderReservesStock(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    inv := newInventoryServer(t, map[string]int{"apple": 5, "pear": 5})
+    r := setupRouter()
+
+    if w := doRequest(r, http.MethodPost, "/orders", twoItemOrder); w.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    stock, held, released := inv.snapshot()
+    if stock["apple"] != 3 || stock["pear"] != 2 || held != 2 || released != 0 {
+        t.Fatalf("stock %v, %d held, %d released", stock, held, released)
+    }
+}
+
+func TestCreateOrderPartialStockRejected(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    inv := newInventoryServer(t, map[string]int{"apple": 5, "pear": 1})
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", twoItemOrder)
+    if w.Code != http.StatusConflict {
+        t.Fatalf("got status %d, want 409", w.Code)
+    }
+    if n := fake.charges.Load(); n != 0 {
+        t.Fatalf("customer charged %d times for an out-of-stock order", n)
+    }
+    stock, held, released := inv.snapshot()
+    if stock["apple"] != 5 || held != 0 || released != 1 {
+        t.Fatalf("apple reservation not released: stock %v, %d held, %d released", stock, held, released)
+    }
+    if list, _ := orders.List(); len(list) != 0 {
+        t.Fatalf("%d orders stored", len(list))
+    }
+}
+
+func TestCreateOrderReleasesStockWhenPaymentFails(t *testing.T) {
+    fake := newPaymentServer(t)
+    fake.status = "declined"
+    resetOrders(t)
+    inv := newInventoryServer(t, map[string]int{"apple": 5, "pear": 5})
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", twoItemOrder)
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if order.Status != StatusPaymentFailed {
+        t.Fatalf("got status %q, want payment_failed", order.Status)
+    }
+    stock, held, released := inv.snapshot()
+    if stock["apple"] != 5 || stock["pear"] != 5 || held != 0 || released != 2 {
+        t.Fatalf("reservations not released: stock %v, %d held, %d released", stock, held, released)
+    }
+}
+
+// saveReservedOrder saves an order with the given status holding a
+// reservation of one apple.
+func saveReservedOrder(t *testing.T, status string) *Order {
+    t.Helper()
+
+    order := saveOrderWithStatus(status)
+    reservationID, err := inventory.Reserve(context.Background(), order.OrderID, "apple", 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    order.ReservationIDs = []string{reservationID}
+    orders.Save(order)
+    return order
+}
+
+func TestOrdersThatWontBeFulfilledReleaseStock(t *testing.T) {
+    useWebhookSecret(t)
+    useAPIKeys(t, "root:@admin")
+    auth := func(order *Order) map[string]string { return adminHeaders("root", order) }
+    now := time.Now()
+
+    tests := []struct {
+        name   string
+        status string
+        settle func(t *testing.T, r http.Handler, order *Order)
+        want   string
+    }{
+        {"cancelled while pending", StatusPending, func(t *testing.T, r http.Handler, order *Order) {
+            doRequestWithHeaders(r, http.MethodPost, "/orders/"+order.OrderID.String()+"/cancel", "", auth(order))
+        }, StatusCancelled},
+        {"cancelled once confirmed", StatusConfirmed, func(t *testing.T, r http.Handler, order *Order) {
+            doRequestWithHeaders(r, http.MethodPost, "/orders/"+order.OrderID.String()+"/cancel", "", auth(order))
+        }, StatusCancelled},
+        {"forced to cancelled", StatusPaymentMismatch, func(t *testing.T, r http.Handler, order *Order) {
+            doRequestWithHeaders(r, http.MethodPost, "/admin/orders/"+order.OrderID.String()+"/status",
+                `{"status":"cancelled","reason":"customer asked"}`, auth(order))
+        }, StatusCancelled},
+        {"forced to payment_failed", StatusConfirmed, func(t *testing.T, r http.Handler, order *Order) {
+            doRequestWithHeaders(r, http.MethodPost, "/admin/orders/"+order.OrderID.String()+"/status",
+                `{"status":"payment_failed","reason":"chargeback"}`, auth(order))
+        }, StatusPaymentFailed},
+        {"declined by callback", StatusPending, func(t *testing.T, r http.Handler, order *Order) {
+            body := paymentCallback(order.OrderID, "declined")
+            postWebhook(r, body, signWebhook([]byte(testWebhookSecret), []byte(body)))
+        }, StatusPaymentFailed},
+        {"failed by the reconciler", StatusPending, func(t *testing.T, r http.Handler, order *Order) {
+            order.CreatedAt = now.Add(-time.Hour)
+            orders.Save(order)
+            newPaymentLookupServer(t, map[uuid.UUID]string{order.OrderID: "declined"})
+            newTestReconciler(now).reconcileOnce(context.Background())
+        }, StatusPaymentFailed},
+        {"refunded before shipping", StatusConfirmed, func(t *testing.T, r http.Handler, order *Order) {
+            doRequestWithHeaders(r, http.MethodPost, "/orders/"+order.OrderID.String()+"/refund", "", auth(order))
+        }, StatusRefunded},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            newPaymentServer(t)
+            resetOrders(t)
+            inv := newInventoryServer(t, map[string]int{"apple": 5})
+            order := saveReservedOrder(t, tt.status)
+            r := setupRouter()
+
+            tt.settle(t, r, order)
+            stored, _ := orders.FindByID(order.OrderID)
+            if stored.Status != tt.want {
+                t.Fatalf("order status %q, want %q", stored.Status, tt.want)
+            }
+            if stock, held, released := inv.snapshot(); stock["apple"] != 5 || held != 0 || released != 1 {
+                t.Fatalf("stock %v, %d held, %d released", stock, held, released)
+            }
+            if len(stored.ReservationIDs) != 0 {
+                t.Fatalf("order still records reservations %v", stored.ReservationIDs)
+            }
+        })
+    }
+}
+
+func TestRefundedShippedOrderKeepsStock(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    inv := newInventoryServer(t, map[string]int{"apple": 5})
+    order := saveReservedOrder(t, StatusShipped)
+    order.Shipments = []Shipment{{ShipmentID: uuid.New(), Items: []ShipmentItem{{ProductID: "apple", Quantity: 1}}, ShippedAt: time.Now()}}
+    orders.Save(order)
+
+    w := doRequest(setupRouter(), http.MethodPost, "/orders/"+order.OrderID.String()+"/refund", "")
+    if stored, _ := orders.FindByID(order.OrderID); w.Code != http.StatusOK || stored.Status != StatusRefunded {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    if _, held, released := inv.snapshot(); held != 1 || released != 0 {
+        t.Fatalf("shipped order has %d reservations held, %d released; want its stock kept", held, released)
+    }
+}
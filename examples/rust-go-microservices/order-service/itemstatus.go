@@ -0,0 +1,209 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+)
+
+// Line item statuses. Items of an order that hasn't been paid for are all
+// pending; once it has, each item moves through fulfilment on its own and
+// the order's status is rolled up from them.
+const (
+    ItemPending = "pending"
+    // ItemBackordered means the item can't ship until it is back in stock.
+    ItemBackordered = "backordered"
+    // ItemPartiallyShipped means some, but not all, of the item's units
+    // have shipped.
+    ItemPartiallyShipped = "partially_shipped"
+    ItemShipped          = "shipped"
+    ItemRefunded         = "refunded"
+)
+
+// itemTransitions lists, for each item status, the statuses an item may
+// move to. Refunded items are terminal.
+var itemTransitions = map[string][]string{
+    ItemPending:          {ItemBackordered, ItemPartiallyShipped, ItemShipped, ItemRefunded},
+    ItemBackordered:      {ItemPending, ItemPartiallyShipped, ItemShipped, ItemRefunded},
+    ItemPartiallyShipped: {ItemShipped, ItemRefunded},
+    ItemShipped:          {ItemRefunded},
+}
+
+// status returns the item's status; items stored before items had one are
+// pending.
+func (item OrderItem) status() string {
+    if item.Status == "" {
+        return ItemPending
+    }
+    return item.Status
+}
+
+func canTransitionItem(from, to string) bool {
+    for _, allowed := range itemTransitions[from] {
+        if allowed == to {
+            return true
+        }
+    }
+    return false
+}
+
+// resetItemStatuses marks every item pending and unrefunded, for a new
+// order or new items: clients can't choose an item's status.
+func resetItemStatuses(items []OrderItem) {
+    for i := range items {
+        items[i].Status = ItemPending
+        items[i].RefundedQuantity = 0
+    }
+}
+
+// rollupStatus returns the status an order in status current should have
+// given the statuses of its items. Only orders in fulfilment, which have
+// been paid for, follow their items: every item refunded makes the order
+// refunded, every remaining item shipped makes it shipped, and some units
+// shipped make it partially shipped. Any other order keeps its status.
+func rollupStatus(current string, items []OrderItem) string {
+    if current != StatusConfirmed && current != StatusPartiallyShipped && current != StatusShipped {
+        return current
+    }
+    var shipped, partial, refunded int
+    for _, item := range items {
+        switch item.status() {
+        case ItemShipped:
+            shipped++
+        case ItemPartiallyShipped:
+            partial++
+        case ItemRefunded:
+            refunded++
+        }
+    }
+    switch {
+    case refunded == len(items):
+        return StatusRefunded
+    case shipped > 0 && shipped+refunded == len(items):
+        return StatusShipped
+    case shipped > 0 || partial > 0:
+        return StatusPartiallyShipped
+    }
+    return StatusConfirmed
+}
+
+// rollUp moves order to the status rolled up from its items, if that is a
+// transition it may make.
+func rollUp(order *Order, reason string) {
+    to := rollupStatus(order.Status, order.Items)
+    if to != order.Status && canTransition(order.Status, to, false) {
+        transitionStatus(order, to, reason)
+    }
+}
+
+// markShippedItems sets the status of order's items from its shipments.
+// Shipments count units per product, so a product listed on several lines
+// fills them in order.
+func markShippedItems(order *Order) {
+    // Copy rather than change in place: copies of an order handed out by
+    // the store may share the items' backing array.
+    order.Items = append([]OrderItem(nil), order.Items...)
+    shipped := make(map[string]int)
+    for _, shipment := range order.Shipments {
+        for _, item := range shipment.Items {
+            shipped[item.ProductID] += item.Quantity
+        }
+    }
+    for i := range order.Items {
+        item := &order.Items[i]
+        units := min(shipped[item.ProductID], item.Quantity)
+        shipped[item.ProductID] -= units
+        to := ItemPartiallyShipped
+        switch {
+        case units == 0:
+            continue
+        case units == item.Quantity:
+            to = ItemShipped
+        }
+        if to != item.status() && canTransitionItem(item.status(), to) {
+            item.Status = to
+        }
+    }
+}
+
+// refundItems marks every item of order refunded, once all its money has
+// gone back.
+func refundItems(order *Order) {
+    items := append([]OrderItem(nil), order.Items...)
+    for i := range items {
+        items[i].Status = ItemRefunded
+        items[i].RefundedQuantity = items[i].Quantity
+    }
+    order.Items = items
+}
+
+// UpdateItemStatusRequest is the body of POST
+// /orders/:id/items/:productID/status.
+type UpdateItemStatusRequest struct {
+    Status string `json:"status" binding:"required"`
+}
+
+// manualItemStatuses are the statuses an item can be given directly. The
+// others follow from what happens to the order: shipments ship items and
+// refunds refund them.
+var manualItemStatuses = map[string]bool{ItemPending: true, ItemBackordered: true}
+
+// updateItemStatus marks a product of a paid order backordered, or pending
+// again once it's back in stock. Every line of the order for the product
+// changes.
+func updateItemStatus(c *gin.Context) {
+    unlock := lockOrderParam(c)
+    defer unlock()
+    order := loadOrder(c)
+    if order == nil {
+        return
+    }
+
+    var req UpdateItemStatusRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        respondBindError(c, err)
+        return
+    }
+    if !manualItemStatuses[req.Status] {
+        verr := &ValidationError{}
+        verr.add("status", "must be %q or %q", ItemPending, ItemBackordered)
+        respondValidationError(c, verr)
+        return
+    }
+
+    if order.Status != StatusConfirmed && order.Status != StatusPartiallyShipped {
+        respondError(c, http.StatusConflict, CodeInvalidStatusTransition,
+            fmt.Sprintf("Items of an order in status %q can't change status", order.Status))
+        return
+    }
+
+    productID := c.Param("productID")
+    items := append([]OrderItem(nil), order.Items...)
+    found := false
+    for i := range items {
+        if items[i].ProductID != productID {
+            continue
+        }
+        found = true
+        from := items[i].status()
+        if from != req.Status && !canTransitionItem(from, req.Status) {
+            respondError(c, http.StatusConflict, CodeInvalidStatusTransition,
+                fmt.Sprintf("Item %s cannot move from %q to %q", productID, from, req.Status))
+            return
+        }
+        items[i].Status = req.Status
+    }
+    if !found {
+        respondError(c, http.StatusNotFound, CodeItemNotFound, fmt.Sprintf("Order has no item %s", productID))
+        return
+    }
+
+    order.Items = items
+    rollUp(order, "item status changed")
+    if err := orders.Save(order); err != nil {
+        respondSaveError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, withLinks(order))
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "slices"
+    "testing"
+)
+
+func itemsWithStatuses(statuses ...string) []OrderItem {
+    items := make([]OrderItem, len(statuses))
+    for i, status := range statuses {
+        items[i] = OrderItem{ProductID: "prod", Quantity: 1, Status: status}
+    }
+    return items
+}
+
+func TestRollupStatus(t *testing.T) {
+    tests := []struct {
+        name    string
+        current string
+        items   []string
+        want    string
+    }{
+        {"nothing shipped", StatusConfirmed, []string{ItemPending, ItemBackordered}, StatusConfirmed},
+        {"unset statuses are pending", StatusConfirmed, []string{"", ""}, StatusConfirmed},
+        {"some units shipped", StatusConfirmed, []string{ItemPartiallyShipped, ItemPending}, StatusPartiallyShipped},
+        {"one item shipped, one backordered", StatusConfirmed, []string{ItemShipped, ItemBackordered}, StatusPartiallyShipped},
+        {"all shipped", StatusPartiallyShipped, []string{ItemShipped, ItemShipped}, StatusShipped},
+        {"shipped apart from refunded items", StatusPartiallyShipped, []string{ItemShipped, ItemRefunded}, StatusShipped},
+        {"refunded and backordered", StatusConfirmed, []string{ItemRefunded, ItemBackordered}, StatusConfirmed},
+        {"all refunded", StatusShipped, []string{ItemRefunded, ItemRefunded}, StatusRefunded},
+        {"unpaid orders keep their status", StatusPending, []string{ItemShipped}, StatusPending},
+        {"cancelled orders keep their status", StatusCancelled, []string{ItemRefunded}, StatusCancelled},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := rollupStatus(tt.current, itemsWithStatuses(tt.items...)); got != tt.want {
+                t.Fatalf("rollupStatus(%q, %v) = %q, want %q", tt.current, tt.items, got, tt.want)
+            }
+        })
+    }
+}
+
+func itemStatuses(order Order) []string {
+    statuses := make([]string, len(order.Items))
+    for i, item := range order.Items {
+        statuses[i] = item.Status
+    }
+    return statuses
+}
+
+func TestCreateOrderItemsStartPending(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders",
+        `{"customer_id":"cust_123","items":[{"product_id":"prod_1","quantity":1,"price":"10.00","status":"shipped"}]}`)
+    if w.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if got := itemStatuses(order); !slices.Equal(got, []string{ItemPending}) {
+        t.Fatalf("item statuses = %v, want pending", got)
+    }
+}
+
+func TestShipmentsSetItemStatuses(t *testing.T) {
+    resetOrders(t)
+    order := saveShippableOrder()
+    r := setupRouter()
+
+    _, got := ship(t, r, order, `{"items":[{"product_id":"prod_1","quantity":1},{"product_id":"prod_2","quantity":1}]}`)
+    if want := []string{ItemPartiallyShipped, ItemShipped}; !slices.Equal(itemStatuses(got), want) {
+        t.Fatalf("after first shipment: item statuses = %v, want %v", itemStatuses(got), want)
+    }
+    _, got = ship(t, r, order, `{"items":[{"product_id":"prod_1","quantity":1}]}`)
+    if want := []string{ItemShipped, ItemShipped}; !slices.Equal(itemStatuses(got), want) || got.Status != StatusShipped {
+        t.Fatalf("after second shipment: order %q, item statuses = %v, want %v", got.Status, itemStatuses(got), want)
+    }
+}
+
+func setItemStatus(r http.Handler, order *Order, productID, status string) (int, Order) {
+    w := doRequest(r, http.MethodPost, "/orders/"+order.OrderID.String()+"/items/"+productID+"/status", `{"status":"`+status+`"}`)
+    var got Order
+    json.Unmarshal(w.Body.Bytes(), &got)
+    return w.Code, got
+}
+
+func TestBackorderedItemKeepsOrderPartiallyShipped(t *testing.T) {
+    resetOrders(t)
+    order := saveShippableOrder()
+    r := setupRouter()
+
+    if code, got := setItemStatus(r, order, "prod_2", ItemBackordered); code != http.StatusOK || got.Items[1].Status != ItemBackordered {
+        t.Fatalf("backorder: status %d, items %+v", code, got.Items)
+    }
+    _, got := ship(t, r, order, `{"items":[{"product_id":"prod_1","quantity":2}]}`)
+    if got.Status != StatusPartiallyShipped {
+        t.Fatalf("order status = %q, want %q while an item is backordered", got.Status, StatusPartiallyShipped)
+    }
+
+    if code, _ := setItemStatus(r, order, "prod_2", ItemPending); code != http.StatusOK {
+        t.Fatalf("back in stock: status %d", code)
+    }
+    _, got = ship(t, r, order, `{"items":[{"product_id":"prod_2","quantity":1}]}`)
+    if got.Status != StatusShipped {
+        t.Fatalf("order status = %q, want %q", got.Status, StatusShipped)
+    }
+}
+
+func TestUpdateItemStatusWaitsForOrderLock(t *testing.T) {
+    resetOrders(t)
+    order := saveShippableOrder()
+    r := setupRouter()
+
+    assertWaitsForOrderLock(t, order, func() { setItemStatus(r, order, "prod_2", ItemBackordered) })
+    if stored, _ := orders.FindByID(order.OrderID); stored.Items[1].Status != ItemBackordered {
+        t.Fatalf("item status %q, want backordered", stored.Items[1].Status)
+    }
+}
+
+func TestUpdateItemStatusRejected(t *testing.T) {
+    resetOrders(t)
+    r := setupRouter()
+    order := saveShippableOrder()
+    ship(t, r, order, `{"items":[{"product_id":"prod_2","quantity":1}]}`)
+
+    tests := []struct {
+        name      string
+        order     *Order
+        productID string
+        status    string
+        wantCode  int
+    }{
+        {"not settable", order, "prod_1", ItemShipped, http.StatusUnprocessableEntity},
+        {"already shipped", order, "prod_2", ItemBackordered, http.StatusConflict},
+        {"unknown item", order, "prod_9", ItemBackordered, http.StatusNotFound},
+        {"unpaid order", saveOrderWithStatus(StatusPending), "prod_1", ItemBackordered, http.StatusConflict},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if code, _ := setItemStatus(r, tt.order, tt.productID, tt.status); code != tt.wantCode {
+                t.Fatalf("got status %d, want %d", code, tt.wantCode)
+            }
+        })
+    }
+}
+
+func TestFullRefundRefundsItems(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    order := saveShippableOrder()
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders/"+order.OrderID.String()+"/refund", "")
+    var got Order
+    json.Unmarshal(w.Body.Bytes(), &got)
+    if want := []string{ItemRefunded, ItemRefunded}; got.Status != StatusRefunded || !slices.Equal(itemStatuses(got), want) {
+        t.Fatalf("order %q, item statuses %v, want refunded", got.Status, itemStatuses(got))
+    }
+}
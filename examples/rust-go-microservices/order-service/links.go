@@ -0,0 +1,38 @@
+package main
+
+import (
+    "os"
+    "strings"
+
+    "github.com/google/uuid"
+)
+
+// Links are the hypermedia links rendered with an order.
+type Links struct {
+    Self string `json:"self"`
+}
+
+// basePath is the path prefix the service is reachable under, e.g. "/api"
+// behind a reverse proxy that strips it before forwarding. It only affects
+// the URLs we hand out, not the routes we serve.
+var basePath = normalizeBasePath(os.Getenv("BASE_PATH"))
+
+// normalizeBasePath returns p with a leading slash and no trailing one, or
+// "" for the root.
+func normalizeBasePath(p string) string {
+    p = strings.Trim(strings.TrimSpace(p), "/")
+    if p == "" {
+        return ""
+    }
+    return "/" + p
+}
+
+func orderPath(id uuid.UUID) string {
+    return basePath + "/orders/" + id.String()
+}
+
+// withLinks fills in order's links for rendering and returns it.
+func withLinks(order *Order) *Order {
+    order.Links = &Links{Self: orderPath(order.OrderID)}
+    return order
+}
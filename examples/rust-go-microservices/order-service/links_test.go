@@ -0,0 +1,47 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+)
+
+func TestCreateOrderSetsLocation(t *testing.T) {
+    tests := []struct {
+        basePath string
+        prefix   string
+    }{
+        {"", ""},
+        {"api/v1/", "/api/v1"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.prefix, func(t *testing.T) {
+            newPaymentServer(t)
+            resetOrders(t)
+            prev := basePath
+            basePath = normalizeBasePath(tt.basePath)
+            t.Cleanup(func() { basePath = prev })
+            r := setupRouter()
+
+            w := doRequest(r, http.MethodPost, "/orders", sampleOrder)
+            if w.Code != http.StatusCreated {
+                t.Fatalf("got status %d", w.Code)
+            }
+            var order Order
+            json.Unmarshal(w.Body.Bytes(), &order)
+
+            want := tt.prefix + "/orders/" + order.OrderID.String()
+            if got := w.Header().Get("Location"); got != want {
+                t.Fatalf("Location = %q, want %q", got, want)
+            }
+            if order.Links == nil || order.Links.Self != want {
+                t.Fatalf("self link = %+v, want %q", order.Links, want)
+            }
+
+            // The link resolves once the proxy strips the prefix.
+            if w := doRequest(r, http.MethodGet, want[len(tt.prefix):], ""); w.Code != http.StatusOK {
+                t.Fatalf("GET self link: status %d", w.Code)
+            }
+        })
+    }
+}
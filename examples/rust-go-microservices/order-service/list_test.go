@@ -0,0 +1,102 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+func seedOrders(t *testing.T, n int) []*Order {
+    t.Helper()
+
+    base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    seeded := make([]*Order, n)
+    for i := 0; i < n; i++ {
+        seeded[i] = &Order{
+            OrderID:    uuid.New(),
+            CustomerID: "cust_123",
+            Status:     "confirmed",
+            CreatedAt:  base.Add(time.Duration(i) * time.Minute),
+        }
+        orders.Save(seeded[i])
+    }
+    return seeded
+}
+
+func decodeList(t *testing.T, body []byte) OrderList {
+    t.Helper()
+
+    var list OrderList
+    if err := json.Unmarshal(body, &list); err != nil {
+        t.Fatalf("decode list: %v", err)
+    }
+    return list
+}
+
+func TestListOrdersEmpty(t *testing.T) {
+    resetOrders(t)
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodGet, "/orders", "")
+    if w.Code != http.StatusOK {
+        t.Fatalf("got status %d", w.Code)
+    }
+    list := decodeList(t, w.Body.Bytes())
+    if list.Total != 0 || len(list.Orders) != 0 || list.Limit != defaultListLimit {
+        t.Fatalf("unexpected list: %+v", list)
+    }
+}
+
+func TestListOrdersNewestFirst(t *testing.T) {
+    resetOrders(t)
+    seeded := seedOrders(t, 3)
+    r := setupRouter()
+
+    list := decodeList(t, doRequest(r, http.MethodGet, "/orders?limit=2&offset=1", "").Body.Bytes())
+    if list.Total != 3 || len(list.Orders) != 2 {
+        t.Fatalf("unexpected list: %+v", list)
+    }
+    if list.Orders[0].OrderID != seeded[1].OrderID || list.Orders[1].OrderID != seeded[0].OrderID {
+        t.Fatal("orders not sorted by CreatedAt descending")
+    }
+}
+
+func TestListOrdersLimitClamped(t *testing.T) {
+    resetOrders(t)
+    seedOrders(t, maxListLimit+5)
+    r := setupRouter()
+
+    list := decodeList(t, doRequest(r, http.MethodGet, "/orders?limit=1000", "").Body.Bytes())
+    if list.Limit != maxListLimit || len(list.Orders) != maxListLimit || list.Total != maxListLimit+5 {
+        t.Fatalf("limit not clamped: limit=%d len=%d total=%d", list.Limit, len(list.Orders), list.Total)
+    }
+}
+
+func TestListOrdersOffsetBeyondEnd(t *testing.T) {
+    resetOrders(t)
+    seedOrders(t, 2)
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodGet, "/orders?offset=10", "")
+    if w.Code != http.StatusOK {
+        t.Fatalf("got status %d", w.Code)
+    }
+    list := decodeList(t, w.Body.Bytes())
+    if list.Total != 2 || list.Orders == nil || len(list.Orders) != 0 {
+        t.Fatalf("unexpected list: %+v", list)
+    }
+}
+
+func TestListOrdersInvalidPagination(t *testing.T) {
+    resetOrders(t)
+    r := setupRouter()
+
+    for _, q := range []string{"limit=0", "limit=abc", "offset=-1"} {
+        if w := doRequest(r, http.MethodGet, "/orders?"+q, ""); w.Code != http.StatusBadRequest {
+            t.Errorf("%s: got status %d, want 400", q, w.Code)
+        }
+    }
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+    "container/list"
+    "fmt"
+    "sync"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+const (
+    defaultListCacheTTL  = 2 * time.Second
+    defaultListCacheSize = 256
+)
+
+// ListCache holds recent list responses so dashboards polling the same
+// query don't have every order loaded and sorted each time. An entry is
+// served only while it is younger than the TTL and no order has been saved
+// or deleted since it was built; the least recently used entry is evicted
+// once the cache is full.
+type ListCache struct {
+    mu      sync.Mutex
+    ttl     time.Duration
+    size    int
+    now     func() time.Time
+    lru     *list.List // of *listCacheEntry, most recently used first
+    entries map[string]*list.Element
+}
+
+type listCacheEntry struct {
+    key     string
+    list    OrderList
+    repo    OrderRepository
+    gen     uint64
+    expires time.Time
+}
+
+func NewListCache(ttl time.Duration, size int) *ListCache {
+    return &ListCache{
+        ttl:     ttl,
+        size:    size,
+        now:     currentTime,
+        lru:     list.New(),
+        entries: make(map[string]*list.Element),
+    }
+}
+
+// listCache caches GET /orders and GET /customers/:customerID/orders; nil
+// disables caching.
+var listCache = NewListCache(defaultListCacheTTL, defaultListCacheSize)
+
+// newListCacheFromEnv reads LIST_CACHE_TTL and LIST_CACHE_SIZE; a size of
+// zero disables the cache.
+func newListCacheFromEnv() (*ListCache, error) {
+    ttl, err := envDuration("LIST_CACHE_TTL", defaultListCacheTTL)
+    if err != nil {
+        return nil, err
+    }
+    size, err := envInt("LIST_CACHE_SIZE", defaultListCacheSize)
+    if err != nil {
+        return nil, err
+    }
+    if size == 0 {
+        return nil, nil
+    }
+    return NewListCache(ttl, size), nil
+}
+
+// listCacheKey identifies a list query once parsed, so that requests
+// asking for the same thing share an entry: "?limit=20" and no limit at
+// all, or parameters in a different order. Who is asking is part of the
+// key, since a customer-scoped key sees fewer orders.
+func listCacheKey(c *gin.Context, customerID, status string, limit, offset int) string {
+    scope, _ := customerScope(c)
+    return fmt.Sprintf("scope=%q customer=%q status=%q tags=%q deleted=%t updated_since=%q after_id=%q limit=%d offset=%d",
+        scope, customerID, status, normalizeTags(c.QueryArray("tag")), includeDeleted(c), c.Query("updated_since"), c.Query("after_id"), limit, offset)
+}
+
+// get returns the cached response for key, if it is still current for
+// repo.
+func (lc *ListCache) get(key string, repo OrderRepository) (OrderList, bool) {
+    if lc == nil {
+        return OrderList{}, false
+    }
+    lc.mu.Lock()
+    defer lc.mu.Unlock()
+
+    el, ok := lc.entries[key]
+    if !ok {
+        return OrderList{}, false
+    }
+    entry := el.Value.(*listCacheEntry)
+    if entry.repo != repo || entry.gen != repo.Generation() || !lc.now().Before(entry.expires) {
+        lc.lru.Remove(el)
+        delete(lc.entries, key)
+        return OrderList{}, false
+    }
+    lc.lru.MoveToFront(el)
+    return entry.list, true
+}
+
+// put caches resp for key. gen is repo's generation from before the orders
+// in resp were loaded, so a write that raced with the load invalidates it.
+func (lc *ListCache) put(key string, resp OrderList, repo OrderRepository, gen uint64) {
+    if lc == nil {
+        return
+    }
+    lc.mu.Lock()
+    defer lc.mu.Unlock()
+
+    entry := &listCacheEntry{key: key, list: resp, repo: repo, gen: gen, expires: lc.now().Add(lc.ttl)}
+    if el, ok := lc.entries[key]; ok {
+        el.Value = entry
+        lc.lru.MoveToFront(el)
+        return
+    }
+    lc.entries[key] = lc.lru.PushFront(entry)
+    for lc.lru.Len() > lc.size {
+        oldest := lc.lru.Back()
+        lc.lru.Remove(oldest)
+        delete(lc.entries, oldest.Value.(*listCacheEntry).key)
+    }
+}
+
+// Len returns the number of cached responses.
+func (lc *ListCache) Len() int {
+    lc.mu.Lock()
+    defer lc.mu.Unlock()
+
+    return lc.lru.Len()
+}
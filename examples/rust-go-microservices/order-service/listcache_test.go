@@ -0,0 +1,167 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// countingRepo counts how often orders are listed.
+type countingRepo struct {
+    OrderRepository
+    lists atomic.Int64
+}
+
+func (r *countingRepo) List() ([]*Order, error) {
+    r.lists.Add(1)
+    return r.OrderRepository.List()
+}
+
+func (r *countingRepo) ListByCustomer(customerID string) ([]*Order, error) {
+    r.lists.Add(1)
+    return r.OrderRepository.ListByCustomer(customerID)
+}
+
+// useListCache installs a fresh cache and a repository that counts lists.
+// It turns off the warning rules, so placing an order doesn't count as a
+// list when the first order rule looks up the customer's.
+func useListCache(t *testing.T, ttl time.Duration, size int) (*ListCache, *countingRepo) {
+    t.Helper()
+
+    resetOrders(t)
+    repo := &countingRepo{OrderRepository: orders}
+    orders = repo
+    prev, prevRules := listCache, warningRules
+    listCache = NewListCache(ttl, size)
+    warningRules = nil
+    t.Cleanup(func() { listCache, warningRules = prev, prevRules })
+    return listCache, repo
+}
+
+func TestListCacheHit(t *testing.T) {
+    _, repo := useListCache(t, time.Minute, 10)
+    seedOrders(t, 3)
+    r := setupRouter()
+
+    first := doRequest(r, http.MethodGet, "/orders?limit=2", "")
+    second := doRequest(r, http.MethodGet, "/orders?limit=2", "")
+    if first.Code != http.StatusOK || first.Body.String() != second.Body.String() {
+        t.Fatalf("cached response differs:\n%s\n%s", first.Body, second.Body)
+    }
+    if n := repo.lists.Load(); n != 1 {
+        t.Fatalf("orders listed %d times, want 1", n)
+    }
+
+    doRequest(r, http.MethodGet, "/customers/cust_123/orders", "")
+    doRequest(r, http.MethodGet, "/customers/cust_123/orders", "")
+    if n := repo.lists.Load(); n != 2 {
+        t.Fatalf("orders listed %d times, want 2", n)
+    }
+}
+
+func TestListCacheInvalidatedByChanges(t *testing.T) {
+    newPaymentServer(t)
+    _, repo := useListCache(t, time.Minute, 10)
+    r := setupRouter()
+
+    if got := decodeList(t, doRequest(r, http.MethodGet, "/orders", "").Body.Bytes()); got.Total != 0 {
+        t.Fatalf("got %d orders, want 0", got.Total)
+    }
+    if w := doRequest(r, http.MethodPost, "/orders", sampleOrder); w.Code != http.StatusCreated {
+        t.Fatalf("create: got status %d", w.Code)
+    }
+    got := decodeList(t, doRequest(r, http.MethodGet, "/orders", "").Body.Bytes())
+    if got.Total != 1 {
+        t.Fatalf("after create got %d orders, want 1", got.Total)
+    }
+
+    // Changes to an order show up too, not only new ones.
+    order := got.Orders[0]
+    if w := doRequestWithHeaders(r, http.MethodPost, "/orders/"+order.OrderID.String()+"/cancel", "", ifMatch(order)); w.Code != http.StatusOK {
+        t.Fatalf("cancel: got status %d: %s", w.Code, w.Body)
+    }
+    if got := decodeList(t, doRequest(r, http.MethodGet, "/orders", "").Body.Bytes()); got.Orders[0].Status != StatusCancelled {
+        t.Fatalf("after cancel got status %q", got.Orders[0].Status)
+    }
+    if n := repo.lists.Load(); n != 3 {
+        t.Fatalf("orders listed %d times, want 3", n)
+    }
+}
+
+func TestListCacheKeyNormalization(t *testing.T) {
+    _, repo := useListCache(t, time.Minute, 10)
+    seedOrders(t, 2)
+    r := setupRouter()
+
+    for _, path := range []string{
+        "/orders",
+        fmt.Sprintf("/orders?limit=%d", defaultListLimit),
+        fmt.Sprintf("/orders?offset=0&limit=%d", defaultListLimit),
+        fmt.Sprintf("/orders?limit=%d&offset=0", defaultListLimit),
+        "/orders?include_deleted=false",
+    } {
+        if w := doRequest(r, http.MethodGet, path, ""); w.Code != http.StatusOK {
+            t.Fatalf("%s: got status %d", path, w.Code)
+        }
+    }
+    if n := repo.lists.Load(); n != 1 {
+        t.Fatalf("equivalent queries listed orders %d times, want 1", n)
+    }
+
+    // Limits over the maximum are clamped to it.
+    doRequest(r, http.MethodGet, fmt.Sprintf("/orders?limit=%d", maxListLimit), "")
+    doRequest(r, http.MethodGet, fmt.Sprintf("/orders?limit=%d", maxListLimit+1), "")
+    if n := repo.lists.Load(); n != 2 {
+        t.Fatalf("clamped limits listed orders %d times, want 2", n)
+    }
+
+    for _, path := range []string{"/orders?limit=1", "/orders?offset=1", "/orders?include_deleted=true"} {
+        doRequest(r, http.MethodGet, path, "")
+    }
+    if n := repo.lists.Load(); n != 5 {
+        t.Fatalf("distinct queries listed orders %d times, want 5", n)
+    }
+}
+
+func TestListCacheSeparatesScopes(t *testing.T) {
+    useListCache(t, time.Minute, 10)
+    useAPIKeys(t, "all,mine:cust_123")
+    seedOrders(t, 1)
+    orders.Save(&Order{CustomerID: "cust_999", Status: StatusConfirmed})
+    r := setupRouter()
+
+    if got := decodeList(t, doRequestWithHeaders(r, http.MethodGet, "/orders", "", bearer("all")).Body.Bytes()); got.Total != 2 {
+        t.Fatalf("unscoped key got %d orders, want 2", got.Total)
+    }
+    if got := decodeList(t, doRequestWithHeaders(r, http.MethodGet, "/orders", "", bearer("mine")).Body.Bytes()); got.Total != 1 {
+        t.Fatalf("scoped key got %d orders, want 1", got.Total)
+    }
+}
+
+func TestListCacheExpiryAndEviction(t *testing.T) {
+    repo := NewOrderStore()
+    now := time.Now()
+    lc := NewListCache(time.Second, 2)
+    lc.now = func() time.Time { return now }
+
+    lc.put("a", OrderList{Total: 1}, repo, repo.Generation())
+    lc.put("b", OrderList{Total: 2}, repo, repo.Generation())
+    lc.get("a", repo) // a is now the most recently used
+    lc.put("c", OrderList{Total: 3}, repo, repo.Generation())
+    if _, ok := lc.get("b", repo); ok {
+        t.Fatal("least recently used entry was not evicted")
+    }
+    if got, ok := lc.get("a", repo); !ok || got.Total != 1 {
+        t.Fatalf("get(a) = %+v, %v", got, ok)
+    }
+
+    now = now.Add(time.Second)
+    if _, ok := lc.get("c", repo); ok {
+        t.Fatal("expired entry was served")
+    }
+    if n := lc.Len(); n != 1 {
+        t.Fatalf("Len() = %d, want 1", n)
+    }
+}
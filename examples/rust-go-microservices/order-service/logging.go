@@ -0,0 +1,85 @@
+package main
+
+import (
+    "context"
+    "log/slog"
+    "net/http"
+    "os"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// logger writes JSON lines to stdout, redacted. Request handlers should use
+// loggerFrom(ctx) instead so their lines carry the request ID.
+var logger = slog.New(newRedactingHandler(slog.NewJSONHandler(os.Stdout, nil)))
+
+type loggerKey struct{}
+
+func withLogger(ctx context.Context, l *slog.Logger) context.Context {
+    return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// loggerFrom returns the request-scoped logger stored in ctx, or the
+// package logger if there is none.
+func loggerFrom(ctx context.Context) *slog.Logger {
+    if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+        return l
+    }
+    return logger
+}
+
+// requestLogger tags each request with an ID, taken from X-Request-ID or
+// generated, echoes it back in the response, stores a logger carrying it in
+// the request context, and writes an access log line for the request if
+// accessLogSampling picks it, with the redacted body if logRequestBodies is
+// set.
+func requestLogger() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        start := time.Now()
+
+        requestID := c.GetHeader(requestIDHeader)
+        if requestID == "" {
+            requestID = uuid.NewString()
+        }
+        c.Header(requestIDHeader, requestID)
+
+        l := logger.With("request_id", requestID)
+        c.Request = c.Request.WithContext(withLogger(c.Request.Context(), l))
+
+        var body *bodyRecorder
+        if logRequestBodies && c.Request.Body != nil && c.Request.Body != http.NoBody {
+            body = &bodyRecorder{ReadCloser: c.Request.Body}
+            c.Request.Body = body
+        }
+
+        c.Next()
+
+        latency := time.Since(start)
+        reason, rate, ok := accessLogSampling.decide(c.Writer.Status(), latency)
+        if !ok {
+            return
+        }
+        attrs := []interface{}{
+            "method", c.Request.Method,
+            "path", c.Request.URL.Path,
+            "status", c.Writer.Status(),
+            "latency_ms", float64(latency.Microseconds()) / 1000,
+            "log_reason", reason,
+            "sample_rate", rate,
+        }
+        if body != nil && body.buf.Len() > 0 {
+            attrs = append(attrs, "body", redactJSON(body.buf.Bytes()))
+        }
+        l.Info("request", attrs...)
+    }
+}
+
+// fatal logs err and exits; used for startup failures.
+func fatal(err error) {
+    logger.Error("startup failed", "error", err)
+    os.Exit(1)
+}
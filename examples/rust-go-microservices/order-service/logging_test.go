@@ -0,0 +1,100 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "log/slog"
+    "net"
+    "net/http"
+    "testing"
+)
+
+// captureLogs redirects the package logger into a buffer for the duration of
+// the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+    t.Helper()
+
+    var buf bytes.Buffer
+    prev := logger
+    logger = slog.New(newRedactingHandler(slog.NewJSONHandler(&buf, nil)))
+    t.Cleanup(func() { logger = prev })
+    return &buf
+}
+
+// logLines decodes every JSON log line in buf.
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+    t.Helper()
+
+    var lines []map[string]interface{}
+    sc := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+    for sc.Scan() {
+        var line map[string]interface{}
+        if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+            t.Fatalf("log line is not JSON: %q", sc.Text())
+        }
+        lines = append(lines, line)
+    }
+    return lines
+}
+
+func TestRequestIDFlowsFromHeaderToResponseAndLogs(t *testing.T) {
+    logs := captureLogs(t)
+    r := setupRouter()
+
+    w := doRequestWithHeaders(r, http.MethodGet, "/health", "", map[string]string{requestIDHeader: "req-abc"})
+    if got := w.Header().Get(requestIDHeader); got != "req-abc" {
+        t.Fatalf("response %s = %q, want req-abc", requestIDHeader, got)
+    }
+
+    lines := logLines(t, logs)
+    if len(lines) != 1 {
+        t.Fatalf("got %d log lines, want 1", len(lines))
+    }
+    line := lines[0]
+    if line["request_id"] != "req-abc" || line["method"] != "GET" || line["path"] != "/health" || line["status"] != float64(200) {
+        t.Fatalf("unexpected access log: %v", line)
+    }
+    if _, ok := line["latency_ms"]; !ok {
+        t.Fatalf("access log missing latency: %v", line)
+    }
+}
+
+func TestRequestIDGeneratedWhenAbsent(t *testing.T) {
+    captureLogs(t)
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodGet, "/health", "")
+    if w.Header().Get(requestIDHeader) == "" {
+        t.Fatal("no request ID generated")
+    }
+}
+
+func TestPaymentFailureLogsRequestID(t *testing.T) {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    addr := ln.Addr().String()
+    ln.Close() // nothing listening: payment calls fail fast
+
+    prev := payments
+    payments = NewPaymentClient("http://" + addr)
+    payments.MaxRetries = 0
+    t.Cleanup(func() { payments = prev })
+    resetOrders(t)
+    logs := captureLogs(t)
+    r := setupRouter()
+
+    doRequestWithHeaders(r, http.MethodPost, "/orders", sampleOrder, map[string]string{requestIDHeader: "req-pay"})
+
+    for _, line := range logLines(t, logs) {
+        if line["msg"] == "payment service call failed" {
+            if line["request_id"] != "req-pay" {
+                t.Fatalf("payment failure logged with request_id %v", line["request_id"])
+            }
+            return
+        }
+    }
+    t.Fatal("payment failure was not logged")
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
-    "bytes"
-    "encoding/json"
+    "context"
+    "errors"
+    "flag"
     "fmt"
+    "net"
     "net/http"
+    "os"
+    "os/signal"
+    "strconv"
+    "syscall"
     "time"
 
     "github.com/gin-gonic/gin"
@@ -13,131 +19,1022 @@ import (
 )
 
 type Order struct {
-    OrderID     uuid.UUID       `json:"order_id"`
-    CustomerID  string          `json:"customer_id"`
-    Items       []OrderItem     `json:"items"`
+    OrderID    uuid.UUID   `json:"order_id"`
+    CustomerID string      `json:"customer_id" binding:"required"`
+    Items      []OrderItem `json:"items" binding:"required,dive"`
+    Currency   string      `json:"currency"`
+    // Destination is where the order ships to, such as a country code; it
+    // picks the tax rate. It is taken from ShippingAddress when there is one.
+    Destination     string   `json:"destination,omitempty"`
+    ShippingAddress *Address `json:"shipping_address,omitempty"`
+    BillingAddress  *Address `json:"billing_address,omitempty"`
+    // SameAsShipping is request-only: it asks for BillingAddress to be a
+    // copy of ShippingAddress.
+    SameAsShipping bool `json:"same_as_shipping,omitempty"`
+    // Discount is optional and comes off the whole order, after any line
+    // item discounts.
+    Discount *Discount `json:"discount,omitempty"`
+    // CouponCode is request-only: a promotional code whose discount
+    // becomes the order discount. Coupon records the one applied.
+    CouponCode string         `json:"coupon_code,omitempty"`
+    Coupon     *AppliedCoupon `json:"coupon,omitempty"`
+    // Metadata and Notes are for integrations and people, respectively;
+    // the service stores them as given and never interprets them.
+    Metadata map[string]string `json:"metadata,omitempty"`
+    Notes    string            `json:"notes,omitempty"`
+    // Tags are labels for operators to find orders by, such as vip, kept
+    // sorted and without duplicates.
+    Tags []string `json:"tags,omitempty"`
+    // TagHistory records every tag added or removed, oldest first.
+    TagHistory []TagChange `json:"tag_history,omitempty"`
+    // Amendments records what each edit of the order changed, oldest first.
+    Amendments []Amendment `json:"amendments,omitempty"`
+    // Channel is the front-end the order came from, given here or in the
+    // X-Channel header. It selects defaults and checks for the order.
+    Channel string `json:"channel,omitempty"`
+    // PaymentMethod is optional; orders without one are paid by card.
+    PaymentMethod *PaymentMethod `json:"payment_method,omitempty"`
+    // PaymentSplits, instead of PaymentMethod, pays the order in several
+    // parts, each charged separately; see PaymentSplit.
+    PaymentSplits []PaymentSplit `json:"payment_splits,omitempty"`
+    // Subtotal is the sum of the line items. TotalAmount adds Tax and
+    // Shipping to it and is what the customer is charged.
+    Subtotal    decimal.Decimal `json:"subtotal"`
+    Tax         decimal.Decimal `json:"tax"`
+    Shipping    decimal.Decimal `json:"shipping"`
     TotalAmount decimal.Decimal `json:"total_amount"`
-    Status      string          `json:"status"`
-    CreatedAt   time.Time       `json:"created_at"`
+    // Settlement is the converted amount charged when the payment processor
+    // settles in a currency other than the order's.
+    Settlement *Settlement `json:"settlement,omitempty"`
+    // RefundedAmount is how much of TotalAmount has been given back.
+    RefundedAmount decimal.Decimal `json:"refunded_amount"`
+    // Refunds are the refunds RefundedAmount is made up of, oldest first.
+    Refunds []Refund `json:"refunds,omitempty"`
+    Status  string   `json:"status"`
+    // Shipments are the packages sent for the order so far.
+    Shipments []Shipment `json:"shipments,omitempty"`
+    // StatusHistory lists every status the order has had, oldest first.
+    StatusHistory []StatusChange `json:"status_history,omitempty"`
+    // Version counts the times the order has been saved. Writes that must
+    // not clobber a concurrent change send it back in If-Match.
+    Version   int64     `json:"version"`
+    CreatedAt time.Time `json:"created_at"`
+    // UpdatedAt is when the order was last saved.
+    UpdatedAt time.Time `json:"updated_at"`
+    // ExpiresAt is when the order expires if it is still pending.
+    ExpiresAt *time.Time `json:"expires_at,omitempty"`
+    // ScheduledFor is optional: a time in the future at which to charge the
+    // order, which waits in status scheduled until then. A time already
+    // past is dropped and the order is charged straight away.
+    ScheduledFor *time.Time `json:"scheduled_for,omitempty"`
+    // DeletedAt is set when the order is soft-deleted; deleted orders are
+    // kept for audit but hidden from the API unless asked for.
+    DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+    // Warnings flag anything unusual about the order when it was placed,
+    // such as a very large quantity, for someone to look at. Unlike
+    // validation errors they don't stop it being placed.
+    Warnings []Warning `json:"warnings,omitempty"`
+    // Fraud is the fraud check's assessment of the order when it was placed.
+    Fraud *FraudAssessment `json:"fraud,omitempty"`
+
+    // ExpectedTotal is an optional, request-only check: when the client
+    // sends it, the order is rejected unless it matches the computed total.
+    ExpectedTotal *decimal.Decimal `json:"expected_total,omitempty"`
+
+    // ReservationIDs are the inventory reservations held for the order,
+    // kept so they can be released if it expires.
+    ReservationIDs []string `json:"-"`
+    // PaymentKey is sent with every charge request for the order, so the
+    // payment service can tell a retry from a second charge.
+    PaymentKey string `json:"-"`
+    // RequestHash fingerprints the body of an order created with an ID its
+    // client picked, so resending it can be told from reusing the ID.
+    RequestHash string `json:"-"`
+
+    // FormattedTotal is TotalAmount written for people in the currency's
+    // locale, such as "$1,234.56". It is populated only when rendering a
+    // response asked for with ?format=display; TotalAmount stays the
+    // authoritative amount.
+    FormattedTotal string `json:"formatted_total,omitempty"`
+    // Links is populated only when rendering a response.
+    Links *Links `json:"_links,omitempty"`
 }
 
 type OrderItem struct {
-    ProductID string          `json:"product_id"`
+    ProductID string          `json:"product_id" binding:"required"`
     Quantity  int             `json:"quantity"`
     Price     decimal.Decimal `json:"price"`
+    // Currency is optional; when given it must match the order's.
+    Currency string `json:"currency,omitempty"`
+    // Discount is optional and comes off this line's total.
+    Discount *Discount `json:"discount,omitempty"`
+    // Status is where the item is in fulfilment; see ItemPending. It is set
+    // by the service and ignored in requests.
+    Status string `json:"status,omitempty"`
+    // RefundedQuantity is how many of the units have been refunded; see
+    // RefundItem. It is set by the service too.
+    RefundedQuantity int `json:"refunded_quantity,omitempty"`
 }
 
-type PaymentRequest struct {
-    OrderID       uuid.UUID       `json:"order_id"`
-    Amount        decimal.Decimal `json:"amount"`
-    Currency      string          `json:"currency"`
-    PaymentMethod string          `json:"payment_method"`
+var (
+    orders          OrderRepository = NewOrderStore()
+    idempotencyKeys                 = NewIdempotencyStore(defaultIdempotencyTTL)
+)
+
+func createOrder(c *gin.Context) {
+    ctx, span := startSpan(c.Request.Context(), "createOrder")
+    defer span.End()
+    c.Request = c.Request.WithContext(ctx)
+
+    if isDryRun(c) {
+        previewOrder(c)
+        return
+    }
+
+    key := c.GetHeader(idempotencyKeyHeader)
+    if key == "" {
+        placeOrder(c)
+        return
+    }
+
+    existingID, found, err := idempotencyKeys.Begin(c.Request.Context(), key)
+    if err != nil && c.Request.Context().Err() != nil {
+        respondError(c, http.StatusServiceUnavailable, CodeRequestCancelled, "Request cancelled")
+        return
+    }
+    if err != nil {
+        loggerFrom(c.Request.Context()).Error("idempotency store failed", "error", err)
+        respondError(c, http.StatusServiceUnavailable, CodeIdempotencyUnavailable, "Idempotency keys are temporarily unavailable")
+        return
+    }
+    if found {
+        existing, err := orders.FindByID(existingID)
+        if errors.Is(err, ErrOrderNotFound) || (err == nil && !canAccess(c, existing.CustomerID)) {
+            respondError(c, http.StatusNotFound, CodeOrderNotFound, "Order not found")
+            return
+        }
+        if err != nil {
+            respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to load order")
+            return
+        }
+        c.JSON(http.StatusOK, withLinks(existing))
+        return
+    }
+
+    // The key is recorded even if the request was cancelled meanwhile.
+    ctx = context.WithoutCancel(c.Request.Context())
+    var created *Order
+    defer func() {
+        if created != nil {
+            idempotencyKeys.Finish(ctx, key, created.OrderID)
+        } else {
+            idempotencyKeys.Abandon(ctx, key)
+        }
+    }()
+    created = placeOrder(c)
 }
 
-type PaymentResponse struct {
-    PaymentID   uuid.UUID `json:"payment_id"`
-    OrderID     uuid.UUID `json:"order_id"`
-    Status      string    `json:"status"`
-    ProcessedAt time.Time `json:"processed_at"`
+// placeOrder creates and charges the order in the request body, writing the
+// response: JSON, or server-sent events if the client asked to follow its
+// progress. It returns the stored order, or nil if none was created.
+func placeOrder(c *gin.Context) *Order {
+    var order Order
+    ctx, ok := bindOrder(c, &order)
+    if !ok {
+        return nil
+    }
+    if rerr := channelFromHeader(c, &order); rerr != nil {
+        rerr.respond(c)
+        return nil
+    }
+    if !canAccess(c, order.CustomerID) {
+        respondError(c, http.StatusForbidden, CodeForbidden, "API key may not create orders for this customer")
+        return nil
+    }
+    existing, rerr := resubmittedOrder(ctx, &order)
+    if rerr != nil {
+        rerr.respond(c)
+        return nil
+    }
+    if existing != nil {
+        c.JSON(http.StatusOK, withLinks(existing))
+        return existing
+    }
+    if wantsEventStream(c) {
+        stream := &eventStream{c: c}
+        rerr := submitOrder(withProgress(ctx, stream.progress), &order)
+        stream.finish(&order, rerr)
+        if rerr != nil {
+            return nil
+        }
+        return &order
+    }
+    if rerr := submitOrder(ctx, &order); rerr != nil {
+        rerr.respond(c)
+        return nil
+    }
+    c.Header("Location", orderPath(order.OrderID))
+    c.JSON(http.StatusCreated, withLinks(&order))
+    return &order
 }
 
-var orders = make(map[uuid.UUID]*Order)
+// prepareOrder validates and prices a new order and gives it an ID, without
+// storing or charging it.
+func prepareOrder(ctx context.Context, order *Order) *requestError {
+    applyChannelDefaults(order)
+    order.Currency = normalizeCurrency(order.Currency)
+    normalizeItemCurrencies(order.Items)
+    normalizeAddresses(order)
+    order.Tags = normalizeTags(order.Tags)
 
-func createOrder(c *gin.Context) {
-    var order Order
-    if err := c.ShouldBindJSON(&order); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-        return
+    _, span := startSpan(ctx, "validateOrder")
+    err := validateOrder(order)
+    span.End()
+    if err != nil {
+        return validationFailed(err.(*ValidationError))
+    }
+    if rerr := applyCoupon(ctx, order); rerr != nil {
+        return rerr
+    }
+
+    if order.OrderID == uuid.Nil {
+        order.OrderID = orderIDs.NewID()
+    }
+    order.Version = 0
+    order.Status = ""
+    order.StatusHistory = nil
+    order.Refunds = nil
+    order.TagHistory = nil
+    order.Amendments = nil
+    order.Fraud = nil
+    order.CreatedAt = clock.Now()
+    for _, tag := range order.Tags {
+        recordTagChange(order, tag, TagAdded)
+    }
+    if order.ScheduledFor != nil && order.ScheduledFor.After(order.CreatedAt) {
+        // Scheduled orders don't expire: they only become pending, and
+        // start their time to be paid, when the scheduler picks them up.
+        transitionStatus(order, StatusScheduled, "order placed for "+order.ScheduledFor.UTC().Format(time.RFC3339))
+        order.ExpiresAt = nil
+    } else {
+        order.ScheduledFor = nil
+        transitionStatus(order, StatusPending, "order placed")
+        expiresAt := order.CreatedAt.Add(pendingOrderTTL)
+        order.ExpiresAt = &expiresAt
+    }
+    resetItemStatuses(order.Items)
+
+    _, span = startSpan(ctx, "calculateTotal")
+    priceOrder(order)
+    span.End()
+
+    if order.ExpectedTotal != nil {
+        if !order.ExpectedTotal.Equal(order.TotalAmount) {
+            rerr := newRequestError(http.StatusConflict, CodeTotalMismatch, "Order total does not match expected_total")
+            rerr.Details = gin.H{
+                "expected_total": *order.ExpectedTotal,
+                "computed_total": order.TotalAmount,
+            }
+            return rerr
+        }
+        order.ExpectedTotal = nil
+    }
+    if rerr := checkSplitTotal(order); rerr != nil {
+        return rerr
+    }
+    order.SameAsShipping = false
+    warnings := amountWarnings(ctx)
+    order.Warnings = append(warnings[:len(warnings):len(warnings)], collectWarnings(ctx, order)...)
+    return nil
+}
+
+// submitOrder validates, prices, reserves stock for and charges a new
+// order, storing it once it is created. On failure it returns the error to
+// report and nothing is left behind, except an order whose payment was
+// declined. An order scheduled for later is only validated, priced and
+// stored; the OrderScheduler charges it when the time comes. So is one the
+// fraud check holds for review, while one it declines is rejected.
+func submitOrder(ctx context.Context, order *Order) *requestError {
+    if rerr := prepareOrder(ctx, order); rerr != nil {
+        return rerr
+    }
+    reportProgress(ctx, ProgressValidated, order)
+    if rerr := screenOrder(ctx, order); rerr != nil {
+        return rerr
+    }
+    if order.Status == StatusScheduled || order.Status == StatusFraudReview {
+        if err := saveAndPublish(ctx, order, EventOrderCreated); errors.Is(err, ErrVersionConflict) {
+            return errOrderIDInUse()
+        } else if err != nil {
+            return newRequestError(http.StatusInternalServerError, CodeInternal, "Failed to save order")
+        }
+        ordersCreated.Inc()
+        return nil
+    }
+
+    // discard undoes everything when the order won't be created after all.
+    discard := func(error) {
+        if err := orders.Delete(order.OrderID); err != nil {
+            loggerFrom(ctx).Error("failed to discard pending order", "order_id", order.OrderID, "error", err)
+        }
+    }
+    // The lock keeps payment callbacks and the reconciler off the order
+    // while it is charged.
+    unlock := orderLocks.lock(order.OrderID)
+    defer unlock()
+    return chargeOrder(ctx, order, discard, EventOrderCreated)
+}
+
+// chargeOrder reserves stock for and charges a pending order, storing it
+// before the charge and again with the result. If the order gets stored but
+// can't be charged, abandon is called with the payment service's error to
+// deal with it. createdEvent, if set, is announced alongside the result.
+// The caller must hold the order's lock.
+func chargeOrder(ctx context.Context, order *Order, abandon func(error), createdEvent string) *requestError {
+    if err := convertForPayment(ctx, order); err != nil {
+        loggerFrom(ctx).Warn("currency conversion failed", "currency", order.Currency, "error", err)
+        return newRequestError(http.StatusServiceUnavailable, CodeExchangeRateUnavailable,
+            fmt.Sprintf("No exchange rate from %s to %s", order.Currency, settlementCurrency))
+    }
+
+    // Reserve stock for every item before charging. Each reservation is
+    // released again if a later step fails.
+    var steps saga
+    if rerr := redeemCoupon(ctx, order, &steps); rerr != nil {
+        return rerr
+    }
+    if inventory != nil {
+        for _, item := range order.Items {
+            reservationID, err := inventory.Reserve(ctx, order.OrderID, item.ProductID, item.Quantity)
+            if err != nil {
+                steps.rollback(ctx)
+                if errors.Is(err, ErrOutOfStock) {
+                    return newRequestError(http.StatusConflict, CodeOutOfStock, "Insufficient stock for product "+item.ProductID)
+                }
+                loggerFrom(ctx).Warn("inventory reservation failed", "error", err)
+                return newRequestError(http.StatusServiceUnavailable, CodeInventoryUnavailable, "Inventory service unavailable")
+            }
+            order.ReservationIDs = append(order.ReservationIDs, reservationID)
+            steps.onRollback(func(ctx context.Context) {
+                if err := inventory.Release(ctx, reservationID); err != nil {
+                    loggerFrom(ctx).Error("failed to release reservation", "reservation_id", reservationID, "error", err)
+                }
+            })
+        }
+        reportProgress(ctx, ProgressReserved, order)
+    }
+
+    // Record the order before charging it, so an order interrupted
+    // mid-payment is left pending for the reconciler rather than lost.
+    if order.PaymentKey == "" {
+        // Kept from a previous attempt, such as a rescheduled order's, so
+        // that attempt's charge isn't repeated if it went through.
+        order.PaymentKey = uuid.NewString()
+    }
+    if err := orders.Save(order); err != nil {
+        steps.rollback(ctx)
+        if errors.Is(err, ErrVersionConflict) && createdEvent != "" {
+            // Another order was created with the client's ID meanwhile.
+            return errOrderIDInUse()
+        }
+        return newRequestError(http.StatusInternalServerError, CodeInternal, "Failed to save order")
+    }
+    discard := func(err error) {
+        steps.rollback(ctx)
+        abandon(err)
     }
 
-    order.OrderID = uuid.New()
-    order.Status = "pending"
-    order.CreatedAt = time.Now()
+    charge := chargeWhole
+    if len(order.PaymentSplits) > 0 {
+        charge = chargeSplits
+    }
+    if rerr := charge(ctx, order, &steps, discard); rerr != nil {
+        return rerr
+    }
 
-    // Calculate total
-    total := decimal.Zero
-    for _, item := range order.Items {
-        total = total.Add(item.Price.Mul(decimal.NewFromInt(int64(item.Quantity))))
+    if err := saveAndPublish(ctx, order, createdEvent, settlementEvent(order)); err != nil {
+        return newRequestError(http.StatusInternalServerError, CodeInternal, "Failed to save order")
     }
-    order.TotalAmount = total
+    if createdEvent != "" {
+        ordersCreated.Inc()
+    }
+    recordSettlement(order)
+    return nil
+}
 
-    // Process payment
+// chargeWhole charges order's total in one payment and moves it to the
+// status the result calls for, leaving it pending if the result isn't final
+// yet. If the payment service can't be asked, it calls discard with the
+// error and returns the error to report.
+func chargeWhole(ctx context.Context, order *Order, steps *saga, discard func(error)) *requestError {
+    amount, currency := order.chargeAmount(order.TotalAmount)
     paymentReq := PaymentRequest{
-        OrderID:       order.OrderID,
-        Amount:        order.TotalAmount,
-        Currency:      "USD",
-        PaymentMethod: "credit_card",
+        OrderID:        order.OrderID,
+        IdempotencyKey: order.PaymentKey,
+        Amount:         amount,
+        Currency:       currency,
+        PaymentMethod:  order.PaymentMethod.methodType(),
+        Details:        order.PaymentMethod,
     }
 
-    paymentResp, err := processPayment(paymentReq)
+    paymentResp, err := payments.processPayment(ctx, paymentReq)
+    if errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrPaymentSaturated) {
+        discard(err)
+        return newRequestError(http.StatusServiceUnavailable, CodePaymentUnavailable, "Payment service unavailable")
+    }
     if err != nil {
-        order.Status = "payment_failed"
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Payment failed"})
+        discard(err)
+        transitionStatus(order, StatusPaymentFailed, "payment request failed")
+        ordersPaymentFailed.Inc()
+        return newRequestError(http.StatusBadRequest, CodePaymentFailed, "Payment failed")
+    }
+
+    target, final := orderStatusForPayment(paymentResp.Status)
+    switch {
+    case !final:
+        // The payment service settles it later by callback, or the
+        // reconciler asks; until then the order stays pending and keeps
+        // its stock.
+        loggerFrom(ctx).Info("payment not yet settled", "order_id", order.OrderID, "payment_status", paymentResp.Status)
+    case target == StatusConfirmed && !paymentAmountMatches(ctx, order, order.TotalAmount, paymentResp):
+        transitionStatus(order, StatusPaymentMismatch, "payment approved for a different amount")
+    case target == StatusConfirmed:
+        reportProgress(ctx, ProgressPaymentApproved, order)
+        transitionStatus(order, StatusConfirmed, "payment approved")
+    default:
+        reportProgress(ctx, ProgressPaymentDeclined, order)
+        // A declined order is kept; only its stock is released.
+        steps.rollback(ctx)
+        order.ReservationIDs = nil
+        transitionStatus(order, StatusPaymentFailed, "payment "+paymentResp.Status)
+    }
+    return nil
+}
+
+// settlementEvent is the event announcing an order whose payment has just
+// been resolved, whether at creation or by a later callback, or "" if it
+// is still pending.
+func settlementEvent(order *Order) string {
+    switch order.Status {
+    case StatusConfirmed:
+        return EventOrderConfirmed
+    case StatusPaymentFailed:
+        return EventOrderPaymentFailed
+    case StatusPaymentMismatch:
+        return EventOrderPaymentMismatch
+    }
+    return ""
+}
+
+// recordSettlement counts an order whose payment has just been resolved.
+func recordSettlement(order *Order) {
+    switch order.Status {
+    case StatusConfirmed:
+        ordersConfirmed.Inc()
+    case StatusPaymentFailed:
+        ordersPaymentFailed.Inc()
+    case StatusPaymentMismatch:
+        ordersPaymentMismatch.Inc()
+    }
+}
+
+// loadOrder fetches the order named by the :id path parameter, treating a
+// soft-deleted order as missing. If it can't, it writes the error response
+// and returns nil.
+func loadOrder(c *gin.Context) *Order {
+    return findOrder(c, false)
+}
+
+// findOrder is loadOrder, optionally returning soft-deleted orders too.
+func findOrder(c *gin.Context, includeDeleted bool) *Order {
+    orderID, err := uuid.Parse(c.Param("id"))
+    if err != nil {
+        respondError(c, http.StatusBadRequest, CodeInvalidOrderID, "Invalid order ID")
+        return nil
+    }
+
+    order, err := orders.FindByID(orderID)
+    if errors.Is(err, ErrOrderNotFound) {
+        respondError(c, http.StatusNotFound, CodeOrderNotFound, "Order not found")
+        return nil
+    }
+    if err != nil {
+        respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to load order")
+        return nil
+    }
+    // An order outside the key's scope is reported missing, so scoped keys
+    // can't probe for other customers' order IDs.
+    if (order.DeletedAt != nil && !includeDeleted) || !canAccess(c, order.CustomerID) {
+        respondError(c, http.StatusNotFound, CodeOrderNotFound, "Order not found")
+        return nil
+    }
+    return order
+}
+
+func getOrder(c *gin.Context) {
+    order := findOrder(c, includeDeleted(c))
+    if order == nil {
         return
     }
 
-    if paymentResp.Status == "approved" {
-        order.Status = "confirmed"
-    } else {
-        order.Status = "payment_failed"
+    // Polling clients send back the ETag and get an empty 304 until the
+    // order changes.
+    etag := orderETag(order)
+    c.Header("ETag", etag)
+    if noneMatch(c.GetHeader("If-None-Match"), etag) {
+        c.Status(http.StatusNotModified)
+        return
     }
+    c.JSON(http.StatusOK, projectOrder(displayOrder(c, withLinks(order)), queryFields(c)))
+}
+
+const (
+    defaultListLimit = 50
+    maxListLimit     = 200
+)
+
+type OrderList struct {
+    Orders []*Order `json:"orders"`
+    Total  int      `json:"total"`
+    Limit  int      `json:"limit"`
+    Offset int      `json:"offset"`
+}
+
+// parsePagination reads ?limit= and ?offset=, applying the default limit and
+// clamping it to maxListLimit.
+func parsePagination(c *gin.Context) (limit, offset int, err error) {
+    limit = defaultListLimit
+    if v := c.Query("limit"); v != "" {
+        limit, err = strconv.Atoi(v)
+        if err != nil || limit < 1 {
+            return 0, 0, fmt.Errorf("limit must be a positive integer")
+        }
+    }
+    if limit > maxListLimit {
+        limit = maxListLimit
+    }
+
+    if v := c.Query("offset"); v != "" {
+        offset, err = strconv.Atoi(v)
+        if err != nil || offset < 0 {
+            return 0, 0, fmt.Errorf("offset must be a non-negative integer")
+        }
+    }
+    return limit, offset, nil
+}
+
+// paginate returns the window of list selected by limit and offset.
+func paginate(list []*Order, limit, offset int) []*Order {
+    if offset >= len(list) {
+        return []*Order{}
+    }
+    end := offset + limit
+    if end > len(list) {
+        end = len(list)
+    }
+    return list[offset:end]
+}
 
-    orders[order.OrderID] = &order
-    c.JSON(http.StatusCreated, order)
+// cancelOrder cancels an order, refunding it if it was paid. Cancelling an
+// order that is already cancelled succeeds without changing it, whatever
+// version If-Match names, so a client retrying after a lost response gets
+// the order rather than an error.
+func cancelOrder(c *gin.Context) {
+    unlock := lockOrderParam(c)
+    defer unlock()
+    order := loadOrder(c)
+    if order == nil {
+        return
+    }
+    if order.Status == StatusCancelled {
+        c.JSON(http.StatusOK, withLinks(order))
+        return
+    }
+    if !checkIfMatch(c, order) {
+        return
+    }
+
+    if !canTransition(order.Status, StatusCancelled, false) {
+        respondError(c, http.StatusConflict, CodeInvalidStatusTransition,
+            fmt.Sprintf("Order cannot be cancelled from status %q", order.Status))
+        return
+    }
+
+    // A confirmed order has already been charged, so give back whatever
+    // hasn't been refunded yet before marking it cancelled.
+    if remaining := order.TotalAmount.Sub(order.RefundedAmount); order.Status == StatusConfirmed && remaining.IsPositive() {
+        if !issueRefund(c, order, remaining) {
+            return
+        }
+    }
+
+    transitionStatus(order, StatusCancelled, "cancelled by request")
+    releaseStock(c.Request.Context(), order)
+    if err := orders.Save(order); err != nil {
+        respondSaveError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, withLinks(order))
 }
 
-func processPayment(req PaymentRequest) (*PaymentResponse, error) {
-    client := &http.Client{Timeout: 5 * time.Second}
-    
-    jsonData, err := json.Marshal(req)
+func listOrders(c *gin.Context) {
+    limit, offset, err := parsePagination(c)
     if err != nil {
-        return nil, err
+        respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+        return
     }
 
-    resp, err := client.Post("http://localhost:8001/process", "application/json", bytes.NewBuffer(jsonData))
+    tags, err := queryTags(c)
     if err != nil {
-        return nil, err
+        respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+        return
+    }
+    cursor, err := queryFeedCursor(c)
+    if err != nil {
+        respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+        return
+    }
+
+    key := listCacheKey(c, "", "", limit, offset)
+    repo := orders
+    // The cache keeps whole lists, whatever fields were asked for.
+    fields := queryFields(c)
+    if resp, ok := listCache.get(key, repo); ok {
+        c.JSON(http.StatusOK, projectList(displayList(c, resp), fields))
+        return
+    }
+    gen := repo.Generation()
+
+    all, err := listedOrders(c, tags, cursor)
+    if err != nil {
+        respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to list orders")
+        return
+    }
+    for _, order := range all {
+        withLinks(order)
     }
-    defer resp.Body.Close()
+    resp := OrderList{
+        Orders: paginate(all, limit, offset),
+        Total:  len(all),
+        Limit:  limit,
+        Offset: offset,
+    }
+    listCache.put(key, resp, repo, gen)
+    c.JSON(http.StatusOK, projectList(displayList(c, resp), fields))
+}
 
-    var paymentResp PaymentResponse
-    if err := json.NewDecoder(resp.Body).Decode(&paymentResp); err != nil {
+// listedOrders returns the orders GET /orders lists: those the API key may
+// see carrying all of tags, without soft-deleted ones unless the request
+// asks for them. With a cursor, only the orders after it in the feed of
+// changes are listed, least recently updated first.
+func listedOrders(c *gin.Context, tags []string, cursor *feedCursor) ([]*Order, error) {
+    var all []*Order
+    var err error
+    scope, scoped := customerScope(c)
+    switch {
+    case scoped:
+        all, err = orders.ListByCustomer(scope)
+        all = filterByTags(all, tags)
+    case len(tags) > 0:
+        all, err = orders.ListByTags(tags)
+    case cursor != nil:
+        all, err = orders.ListUpdatedSince(cursor.Since)
+    default:
+        all, err = orders.List()
+    }
+    if err != nil {
         return nil, err
     }
+    if cursor != nil {
+        all = updatedAfter(all, cursor)
+    }
+    if !includeDeleted(c) {
+        all = excludeDeleted(all)
+    }
+    return all, nil
+}
 
-    return &paymentResp, nil
+// filterByStatus returns the orders in list with the given status, or list
+// unchanged if status is empty.
+func filterByStatus(list []*Order, status string) []*Order {
+    if status == "" {
+        return list
+    }
+    filtered := make([]*Order, 0, len(list))
+    for _, order := range list {
+        if order.Status == status {
+            filtered = append(filtered, order)
+        }
+    }
+    return filtered
 }
 
-func getOrder(c *gin.Context) {
-    orderID, err := uuid.Parse(c.Param("id"))
+func listCustomerOrders(c *gin.Context) {
+    limit, offset, err := parsePagination(c)
     if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+        respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+        return
+    }
+
+    if !canAccess(c, c.Param("customerID")) {
+        respondError(c, http.StatusForbidden, CodeForbidden, "API key may not list this customer's orders")
         return
     }
 
-    order, exists := orders[orderID]
-    if !exists {
-        c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+    customerID, status := c.Param("customerID"), c.Query("status")
+    key := listCacheKey(c, customerID, status, limit, offset)
+    repo := orders
+    // The cache keeps whole lists, whatever fields were asked for.
+    fields := queryFields(c)
+    if resp, ok := listCache.get(key, repo); ok {
+        c.JSON(http.StatusOK, projectList(displayList(c, resp), fields))
         return
     }
+    gen := repo.Generation()
 
-    c.JSON(http.StatusOK, order)
+    all, err := repo.ListByCustomer(customerID)
+    if err != nil {
+        respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to list orders")
+        return
+    }
+    if !includeDeleted(c) {
+        all = excludeDeleted(all)
+    }
+    all = filterByStatus(all, status)
+    for _, order := range all {
+        withLinks(order)
+    }
+
+    resp := OrderList{
+        Orders: paginate(all, limit, offset),
+        Total:  len(all),
+        Limit:  limit,
+        Offset: offset,
+    }
+    listCache.put(key, resp, repo, gen)
+    c.JSON(http.StatusOK, projectList(displayList(c, resp), fields))
 }
 
-func health(c *gin.Context) {
-    c.JSON(http.StatusOK, gin.H{
-        "status":  "healthy",
-        "service": "order-service",
-    })
+func setupRouter() *gin.Engine {
+    r := gin.New()
+    r.Use(requestLogger(), gin.Recovery(), trackInFlight(), extractTraceContext(), gzipResponses(gzipMinSize), limitRequestBody(maxBodyBytes), negotiateMsgpack(), shapeResponses(), limitJSONDepth(maxJSONDepth))
+
+    r.GET("/health", health)
+    r.GET("/health/live", liveness)
+    r.GET("/health/ready", readinessHandler)
+    r.GET("/metrics", metricsHandler())
+    r.GET("/version", versionHandler)
+    // The payment service authenticates with a signature, not an API key.
+    r.POST("/webhooks/payment", paymentWebhook)
+
+    api := r.Group("", authenticate(apiKeys))
+    api.GET("/orders", listOrders)
+    api.GET("/orders/export.csv", exportOrdersCSV)
+    api.GET("/orders/summary", requireUnscoped(), orderSummary)
+    api.POST("/orders", rateLimitBy(channelLimiter, customerKey), queueOrderCreation, createOrder)
+    api.POST("/orders/batch", rateLimitBy(channelLimiter, customerKey), queueOrderCreation, createOrderBatch)
+    api.GET("/orders/:id", getOrder)
+    api.GET("/orders/:id/receipt", orderReceipt)
+    api.GET("/orders/:id/events", getOrderTimeline)
+    api.PATCH("/orders/:id", updateOrder)
+    api.DELETE("/orders/:id", deleteOrder)
+    api.POST("/orders/:id/cancel", cancelOrder)
+    api.POST("/orders/:id/refund", refundOrder)
+    api.POST("/orders/:id/shipments", createShipment)
+    api.POST("/orders/:id/items/:productID/status", updateItemStatus)
+    api.POST("/orders/:id/tags/:tag", requireUnscoped(), addOrderTag)
+    api.DELETE("/orders/:id/tags/:tag", requireUnscoped(), removeOrderTag)
+    api.GET("/customers/:customerID/orders", listCustomerOrders)
+    api.POST("/admin/orders/:id/status", requireAdmin(apiKeys), forceOrderStatus)
+    api.POST("/admin/events/replay", requireAdmin(apiKeys), replayDeadLetters)
+    api.POST("/admin/drain", requireAdmin(apiKeys), drainInstance)
+    if pprofEnabled && pprofAddr == "" {
+        api.Any("/debug/pprof/*profile", requireUnscoped(), gin.WrapH(pprofHandler()))
+    }
+
+    return r
 }
 
 func main() {
-    r := gin.Default()
+    showVersion := flag.Bool("version", false, "print the build version and exit")
+    flag.Parse()
+    if *showVersion {
+        fmt.Println(versionString())
+        return
+    }
 
-    r.GET("/health", health)
-    r.POST("/orders", createOrder)
-    r.GET("/orders/:id", getOrder)
+    cfg, err := configFromEnv()
+    if err != nil {
+        fatal(err)
+    }
+    srv, err := NewServer(cfg)
+    if err != nil {
+        fatal(err)
+    }
+    shutdownTracing, err := setupTracing(context.Background())
+    if err != nil {
+        fatal(err)
+    }
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
 
-    fmt.Println("Starting Order Service on http://localhost:8002")
-    r.Run(":8002")
-}
\ No newline at end of file
+    if err := srv.Start(); err != nil {
+        fatal(err)
+    }
+    select {
+    case err = <-srv.Done():
+    case <-ctx.Done():
+    case <-srv.DrainRequested():
+    }
+    stop()
+    // The drain delay comes before the grace period, not out of it.
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.DrainDelay+cfg.ShutdownGrace)
+    if shutdownErr := srv.Shutdown(shutdownCtx); err == nil {
+        err = shutdownErr
+    }
+    cancel()
+    shutdownTracing(context.Background())
+    if err != nil {
+        fatal(err)
+    }
+}
+
+// configFromEnv reads the service's configuration from the environment.
+// Settings the handlers read directly, such as tax rates and limits, are
+// applied as they are read; the dependencies a Server is built from are
+// returned.
+func configFromEnv() (Config, error) {
+    var cfg Config
+    var err error
+    if cfg.Store, err = openRepository(); err != nil {
+        return cfg, err
+    }
+
+    ttl, err := envDuration("IDEMPOTENCY_TTL", defaultIdempotencyTTL)
+    if err != nil {
+        return cfg, err
+    }
+    if cfg.IdempotencyKeys, err = idempotencyStoreFromEnv(ttl); err != nil {
+        return cfg, err
+    }
+
+    if cfg.Payments, err = newPaymentClientFromEnv(); err != nil {
+        return cfg, err
+    }
+    if err = configureReadinessFromEnv(); err != nil {
+        return cfg, err
+    }
+    if cfg.Inventory, err = newInventoryClientFromEnv(); err != nil {
+        return cfg, err
+    }
+    if cfg.Dependencies, err = dependencyTimeoutsFromEnv(); err != nil {
+        return cfg, err
+    }
+    if cfg.CreationQueue, err = creationQueueFromEnv(); err != nil {
+        return cfg, err
+    }
+    if cfg.Coupons, err = couponsFromEnv(); err != nil {
+        return cfg, err
+    }
+    if cfg.WebhookDeliveries, err = deliveryStoreFromEnv(); err != nil {
+        return cfg, err
+    }
+    cfg.WebhookSecret = []byte(os.Getenv("PAYMENT_WEBHOOK_SECRET"))
+    broker, err := newEventBrokerFromEnv()
+    if err != nil {
+        return cfg, err
+    }
+    if broker != nil {
+        cfg.Broker = broker
+    }
+    cfg.DeadLetters = deadLettersFromEnv()
+    if cfg.Events, err = newEventPublisherFromEnv(broker, cfg.DeadLetters); err != nil {
+        return cfg, err
+    }
+    if cfg.OutboxInterval, err = envDuration("OUTBOX_RELAY_INTERVAL", defaultOutboxInterval); err != nil {
+        return cfg, err
+    }
+
+    if roundingMode, err = roundingModeFromEnv(); err != nil {
+        return cfg, err
+    }
+    if orderIDs, err = idGeneratorFromEnv(); err != nil {
+        return cfg, err
+    }
+    if taxes, err = taxRatesFromEnv(); err != nil {
+        return cfg, err
+    }
+    if shippingRates, err = shippingRatesFromEnv(); err != nil {
+        return cfg, err
+    }
+    if settlementCurrency, exchangeRates, err = exchangeRatesFromEnv(); err != nil {
+        return cfg, err
+    }
+    if maxItemQuantity, err = envInt("MAX_ITEM_QUANTITY", defaultMaxItemQuantity); err != nil {
+        return cfg, err
+    }
+    if maxOrderTotal, err = envDecimal("MAX_ORDER_TOTAL"); err != nil {
+        return cfg, err
+    }
+    if maxOrderItems, err = envInt("MAX_ORDER_ITEMS", defaultMaxOrderItems); err != nil {
+        return cfg, err
+    }
+    if warnItemQuantity, err = envInt("WARN_ITEM_QUANTITY", defaultWarnItemQuantity); err != nil {
+        return cfg, err
+    }
+    if strictPrecision, err = envBool("STRICT_PRICE_PRECISION"); err != nil {
+        return cfg, err
+    }
+    if rejectFloatAmounts, err = envBool("REJECT_FLOAT_AMOUNTS"); err != nil {
+        return cfg, err
+    }
+    if paymentAmountTolerance, err = envDecimal("PAYMENT_AMOUNT_TOLERANCE"); err != nil {
+        return cfg, err
+    }
+
+    if apiKeys, err = newAPIKeysFromEnv(); err != nil {
+        return cfg, err
+    }
+    if pprofEnabled, err = envBool("PPROF_ENABLED"); err != nil {
+        return cfg, err
+    }
+    pprofAddr = os.Getenv("PPROF_ADDR")
+    redactFields = redactFieldsFromEnv()
+    if defaultProfile, err = responseProfileFromEnv(); err != nil {
+        return cfg, err
+    }
+    if logRequestBodies, err = envBool("LOG_REQUEST_BODIES"); err != nil {
+        return cfg, err
+    }
+    if accessLogSampling, err = accessLogSamplingFromEnv(); err != nil {
+        return cfg, err
+    }
+
+    maxBody, err := envInt("MAX_BODY_BYTES", defaultMaxBodyBytes)
+    if err != nil {
+        return cfg, err
+    }
+    if maxBody == 0 {
+        return cfg, fmt.Errorf("MAX_BODY_BYTES must be positive")
+    }
+    maxBodyBytes = int64(maxBody)
+
+    if maxBatchSize, err = envInt("MAX_BATCH_SIZE", defaultMaxBatchSize); err != nil {
+        return cfg, err
+    }
+    if maxBatchSize == 0 {
+        return cfg, fmt.Errorf("MAX_BATCH_SIZE must be positive")
+    }
+
+    if listCache, err = newListCacheFromEnv(); err != nil {
+        return cfg, err
+    }
+
+    perMinute, err := envInt("RATE_LIMIT_PER_MINUTE", defaultRateLimitPerMinute)
+    if err != nil {
+        return cfg, err
+    }
+    if perMinute > 0 {
+        createLimiter = NewRateLimiter(perMinute)
+    }
+    if orderChannels, err = channelsFromEnv(); err != nil {
+        return cfg, err
+    }
+
+    if cfg.ShutdownGrace, err = envDuration("SHUTDOWN_GRACE_PERIOD", defaultShutdownGracePeriod); err != nil {
+        return cfg, err
+    }
+    if cfg.DrainDelay, err = envDuration("DRAIN_DELAY", 0); err != nil {
+        return cfg, err
+    }
+    if cfg.Timeouts, err = serverTimeoutsFromEnv(cfg.Payments.MaxElapsed); err != nil {
+        return cfg, err
+    }
+    reconciler, err := newReconcilerFromEnv()
+    if err != nil {
+        return cfg, err
+    }
+    if pendingOrderTTL, err = envDuration("PENDING_ORDER_TTL", defaultPendingOrderTTL); err != nil {
+        return cfg, err
+    }
+    sweeper, err := newExpirySweeperFromEnv()
+    if err != nil {
+        return cfg, err
+    }
+    scheduler, err := newOrderSchedulerFromEnv()
+    if err != nil {
+        return cfg, err
+    }
+    cfg.Jobs = []backgroundJob{reconciler, sweeper, scheduler}
+    if pprofEnabled && pprofAddr != "" {
+        pprofLn, err := net.Listen("tcp", pprofAddr)
+        if err != nil {
+            return cfg, err
+        }
+        cfg.Jobs = append(cfg.Jobs, &pprofServer{ln: pprofLn})
+        logger.Info("Serving pprof", "addr", pprofLn.Addr().String())
+    }
+    return cfg, nil
+}
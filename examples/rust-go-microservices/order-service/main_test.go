@@ -0,0 +1,273 @@
+package main
+
+import (
+    "encoding/json"
+    "io"
+    "log/slog"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "github.com/shopspring/decimal"
+)
+
+func TestMain(m *testing.M) {
+    gin.SetMode(gin.TestMode)
+    logger = slog.New(slog.NewJSONHandler(io.Discard, nil))
+    os.Exit(m.Run())
+}
+
+// fakePayments is a stand-in for the payment service that approves every
+// charge and refund and counts the calls it receives.
+type fakePayments struct {
+    *httptest.Server
+    charges      atomic.Int64
+    refunds      atomic.Int64
+    healthChecks atomic.Int64
+
+    // lastCharge and lastTraceparent record the latest charge request and
+    // its traceparent header; lastRefund the latest refund request.
+    lastCharge      atomic.Pointer[PaymentRequest]
+    lastRefund      atomic.Pointer[RefundRequest]
+    lastTraceparent atomic.Pointer[string]
+
+    // delay, if set before any request is sent, is applied to every charge;
+    // refundDelay to every refund.
+    delay       time.Duration
+    refundDelay time.Duration
+    // status, if set before any request is sent, replaces "approved" as
+    // the outcome of every charge.
+    status string
+    // amount, if set before any request is sent, is reported as charged
+    // instead of the amount requested.
+    amount *decimal.Decimal
+    // declineMethod, if set before any request is sent, declines every
+    // charge paid with that payment method type; failMethod fails them with
+    // a 500.
+    declineMethod string
+    failMethod    string
+    // pendingMethod, if set before any request is sent, leaves every charge
+    // paid with that payment method type "processing".
+    pendingMethod string
+    // refundStatus, if set before any request is sent, replaces "refunded"
+    // as the outcome of every refund.
+    refundStatus string
+}
+
+// newPaymentServer starts a fakePayments server and points the payment
+// client at it for the duration of the test.
+func newPaymentServer(t testing.TB) *fakePayments {
+    t.Helper()
+
+    fake := &fakePayments{}
+    mux := http.NewServeMux()
+    mux.HandleFunc("/process", func(w http.ResponseWriter, r *http.Request) {
+        var req PaymentRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        fake.charges.Add(1)
+        fake.lastCharge.Store(&req)
+        if fake.failMethod != "" && req.PaymentMethod == fake.failMethod {
+            http.Error(w, "payment processor unavailable", http.StatusInternalServerError)
+            return
+        }
+        traceparent := r.Header.Get("traceparent")
+        fake.lastTraceparent.Store(&traceparent)
+        time.Sleep(fake.delay)
+        status := "approved"
+        if fake.status != "" {
+            status = fake.status
+        }
+        if fake.declineMethod != "" && req.PaymentMethod == fake.declineMethod {
+            status = "declined"
+        }
+        if fake.pendingMethod != "" && req.PaymentMethod == fake.pendingMethod {
+            status = "processing"
+        }
+        amount := req.Amount
+        if fake.amount != nil {
+            amount = *fake.amount
+        }
+        json.NewEncoder(w).Encode(PaymentResponse{
+            PaymentID:   uuid.New(),
+            OrderID:     req.OrderID,
+            Status:      status,
+            Amount:      &amount,
+            ProcessedAt: time.Now(),
+        })
+    })
+    mux.HandleFunc("/refund", func(w http.ResponseWriter, r *http.Request) {
+        var req RefundRequest
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        fake.refunds.Add(1)
+        fake.lastRefund.Store(&req)
+        time.Sleep(fake.refundDelay)
+        status := refundStatusRefunded
+        if fake.refundStatus != "" {
+            status = fake.refundStatus
+        }
+        json.NewEncoder(w).Encode(RefundResponse{
+            RefundID:    uuid.New(),
+            OrderID:     req.OrderID,
+            Amount:      req.Amount,
+            Status:      status,
+            ProcessedAt: time.Now(),
+        })
+    })
+    mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+        fake.healthChecks.Add(1)
+        json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
+    })
+    fake.Server = httptest.NewServer(mux)
+    t.Cleanup(fake.Close)
+
+    prev := payments
+    payments = NewPaymentClient(fake.URL)
+    t.Cleanup(func() { payments = prev })
+
+    return fake
+}
+
+// assertWaitsForOrderLock runs req while order's lock is held, and fails
+// the test if it finishes before the lock is let go.
+func assertWaitsForOrderLock(t *testing.T, order *Order, req func()) {
+    t.Helper()
+
+    unlock := orderLocks.lock(order.OrderID)
+    done := make(chan struct{})
+    go func() {
+        req()
+        close(done)
+    }()
+    select {
+    case <-done:
+        unlock()
+        t.Fatal("request finished while the order was locked")
+    case <-time.After(20 * time.Millisecond):
+    }
+    unlock()
+    <-done
+}
+
+// resetOrders swaps in an empty store for the duration of the test.
+func resetOrders(t testing.TB) {
+    t.Helper()
+
+    prev := orders
+    orders = NewOrderStore()
+    t.Cleanup(func() { orders = prev })
+}
+
+func doRequest(r http.Handler, method, path, body string) *httptest.ResponseRecorder {
+    return doRequestWithHeaders(r, method, path, body, nil)
+}
+
+func doRequestWithHeaders(r http.Handler, method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+    req := httptest.NewRequest(method, path, strings.NewReader(body))
+    if body != "" {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    for k, v := range headers {
+        req.Header.Set(k, v)
+    }
+    w := httptest.NewRecorder()
+    r.ServeHTTP(w, req)
+    return w
+}
+
+const sampleOrder = `{"customer_id":"cust_123","items":[{"product_id":"prod_456","quantity":2,"price":"29.99"}]}`
+
+func TestConcurrentCreateAndGetOrder(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    var wg sync.WaitGroup
+    ids := make(chan uuid.UUID, 100)
+    for i := 0; i < 100; i++ {
+        wg.Add(2)
+        go func() {
+            defer wg.Done()
+            w := doRequest(r, http.MethodPost, "/orders", sampleOrder)
+            if w.Code != http.StatusCreated {
+                t.Errorf("create: got status %d: %s", w.Code, w.Body)
+                return
+            }
+            var order Order
+            if err := json.Unmarshal(w.Body.Bytes(), &order); err != nil {
+                t.Errorf("create: decode: %v", err)
+                return
+            }
+            ids <- order.OrderID
+        }()
+        go func() {
+            defer wg.Done()
+            doRequest(r, http.MethodGet, "/orders/"+uuid.NewString(), "")
+        }()
+    }
+    wg.Wait()
+    close(ids)
+
+    for id := range ids {
+        w := doRequest(r, http.MethodGet, "/orders/"+id.String(), "")
+        if w.Code != http.StatusOK {
+            t.Fatalf("get %s: got status %d", id, w.Code)
+        }
+    }
+}
+
+func TestCreateOrderExpectedTotal(t *testing.T) {
+    tests := []struct {
+        name     string
+        expected string
+        want     int
+    }{
+        {"absent", "", http.StatusCreated},
+        {"matching", `,"expected_total":"59.980"`, http.StatusCreated},
+        {"mismatching", `,"expected_total":"49.98"`, http.StatusConflict},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fake := newPaymentServer(t)
+            resetOrders(t)
+            r := setupRouter()
+
+            body := strings.TrimSuffix(sampleOrder, "}") + tt.expected + "}"
+            w := doRequest(r, http.MethodPost, "/orders", body)
+            if w.Code != tt.want {
+                t.Fatalf("got status %d, want %d: %s", w.Code, tt.want, w.Body)
+            }
+
+            if tt.want == http.StatusConflict {
+                details, _ := decodeError(t, w).Details.(map[string]interface{})
+                if details["expected_total"] != "49.98" || details["computed_total"] != "59.98" {
+                    t.Fatalf("conflict body missing totals: %s", w.Body)
+                }
+                if n := fake.charges.Load(); n != 0 {
+                    t.Fatalf("payment charged %d times for a mismatched total", n)
+                }
+                return
+            }
+            var resp map[string]interface{}
+            json.Unmarshal(w.Body.Bytes(), &resp)
+            if resp["total_amount"] != "59.98" {
+                t.Fatalf("got total_amount %v, want server-computed 59.98", resp["total_amount"])
+            }
+            if _, ok := resp["expected_total"]; ok {
+                t.Fatalf("expected_total echoed in response: %s", w.Body)
+            }
+        })
+    }
+}
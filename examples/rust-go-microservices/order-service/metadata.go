@@ -0,0 +1,78 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "unicode"
+    "unicode/utf8"
+)
+
+// Limits on an order's metadata and notes, which are stored and returned
+// as given, so they can't be used to bloat orders.
+const (
+    maxMetadataKeys     = 50
+    maxMetadataKeyLen   = 40
+    maxMetadataValueLen = 500
+    maxNotesLen         = 2000
+)
+
+// validateMetadata checks an order's metadata and notes against the
+// limits. Lengths count characters, not bytes.
+func validateMetadata(verr *ValidationError, metadata map[string]string, notes string) {
+    if len(metadata) > maxMetadataKeys {
+        verr.add("metadata", "must have at most %d keys, got %d", maxMetadataKeys, len(metadata))
+    }
+    // Sorted, so the errors come out in the same order every time.
+    keys := make([]string, 0, len(metadata))
+    for key := range metadata {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    for _, key := range keys {
+        field := fmt.Sprintf("metadata[%q]", key)
+        switch {
+        case key == "":
+            verr.add("metadata", "keys must not be empty")
+        case utf8.RuneCountInString(key) > maxMetadataKeyLen:
+            verr.add(field, "key must be at most %d characters", maxMetadataKeyLen)
+        case hasControlChars(key):
+            verr.add(field, "key must not contain control characters")
+        }
+        if utf8.RuneCountInString(metadata[key]) > maxMetadataValueLen {
+            verr.add(field, "value must be at most %d characters", maxMetadataValueLen)
+        }
+    }
+    if utf8.RuneCountInString(notes) > maxNotesLen {
+        verr.add("notes", "must be at most %d characters", maxNotesLen)
+    }
+}
+
+func hasControlChars(s string) bool {
+    for _, r := range s {
+        if unicode.IsControl(r) {
+            return true
+        }
+    }
+    return false
+}
+
+// mergeMetadata applies a PATCH to metadata: each key is set to its new
+// value, or removed if the value is null. It returns a new map, leaving
+// metadata untouched.
+func mergeMetadata(metadata map[string]string, patch map[string]*string) map[string]string {
+    merged := make(map[string]string, len(metadata)+len(patch))
+    for key, value := range metadata {
+        merged[key] = value
+    }
+    for key, value := range patch {
+        if value == nil {
+            delete(merged, key)
+        } else {
+            merged[key] = *value
+        }
+    }
+    if len(merged) == 0 {
+        return nil
+    }
+    return merged
+}
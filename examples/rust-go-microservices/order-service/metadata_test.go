@@ -0,0 +1,152 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestValidateMetadata(t *testing.T) {
+    tooMany := map[string]string{}
+    for i := 0; i <= maxMetadataKeys; i++ {
+        tooMany[fmt.Sprintf("key%d", i)] = "v"
+    }
+    tests := []struct {
+        name     string
+        metadata map[string]string
+        notes    string
+        want     string
+    }{
+        {"valid", map[string]string{"channel": "web", "gift_message": "Happy birthday"}, "Leave at the door", ""},
+        {"longest key", map[string]string{strings.Repeat("k", maxMetadataKeyLen): "v"}, "", ""},
+        {"key too long", map[string]string{strings.Repeat("k", maxMetadataKeyLen+1): "v"}, "", `metadata["` + strings.Repeat("k", maxMetadataKeyLen+1) + `"]`},
+        {"value too long", map[string]string{"k": strings.Repeat("v", maxMetadataValueLen+1)}, "", `metadata["k"]`},
+        {"empty key", map[string]string{"": "v"}, "", "metadata"},
+        {"control character", map[string]string{"bad\nkey": "v"}, "", `metadata["bad\nkey"]`},
+        {"too many keys", tooMany, "", "metadata"},
+        {"notes too long", nil, strings.Repeat("n", maxNotesLen+1), "notes"},
+        {"multibyte notes at the limit", nil, strings.Repeat("é", maxNotesLen), ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            verr := &ValidationError{}
+            validateMetadata(verr, tt.metadata, tt.notes)
+            if tt.want == "" {
+                if err := verr.err(); err != nil {
+                    t.Fatalf("got %v, want no error", err)
+                }
+                return
+            }
+            if len(verr.Fields) == 0 || verr.Fields[0].Field != tt.want {
+                t.Fatalf("got fields %+v, want %s", verr.Fields, tt.want)
+            }
+        })
+    }
+}
+
+func TestCreateOrderWithMetadata(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    body := `{"customer_id":"cust_123","items":[{"product_id":"prod_456","quantity":1,"price":"10"}],` +
+        `"metadata":{"channel":"mobile","gift_message":"Happy birthday, Sam! 🎉"},"notes":"Ring twice"}`
+    w := doRequest(r, http.MethodPost, "/orders", body)
+    if w.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    var created Order
+    json.Unmarshal(w.Body.Bytes(), &created)
+
+    w = doRequest(r, http.MethodGet, "/orders/"+created.OrderID.String(), "")
+    var got Order
+    json.Unmarshal(w.Body.Bytes(), &got)
+    if got.Metadata["channel"] != "mobile" || got.Metadata["gift_message"] != "Happy birthday, Sam! 🎉" || got.Notes != "Ring twice" {
+        t.Fatalf("got metadata %v, notes %q", got.Metadata, got.Notes)
+    }
+}
+
+func TestCreateOrderRejectsOversizedMetadata(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    body := `{"customer_id":"cust_123","items":[{"product_id":"prod_456","quantity":1,"price":"10"}],` +
+        `"metadata":{"note":"` + strings.Repeat("x", maxMetadataValueLen+1) + `"}}`
+    if w := doRequest(r, http.MethodPost, "/orders", body); w.Code != http.StatusUnprocessableEntity {
+        t.Fatalf("got status %d, want 422", w.Code)
+    }
+    if n := fake.charges.Load(); n != 0 {
+        t.Fatalf("got %d charges, want none", n)
+    }
+}
+
+func TestPatchMetadataOnly(t *testing.T) {
+    resetOrders(t)
+    order := saveOrderWithStatus(StatusConfirmed)
+    order.Metadata = map[string]string{"channel": "web", "campaign": "spring"}
+    orders.Save(order)
+    r := setupRouter()
+    path := "/orders/" + order.OrderID.String()
+
+    w := doRequestWithHeaders(r, http.MethodPatch, path, `{"metadata":{"channel":"store","campaign":null,"gift":"yes"},"notes":"Fragile"}`, ifMatch(order))
+    if w.Code != http.StatusOK {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    stored, _ := orders.FindByID(order.OrderID)
+    if len(stored.Metadata) != 2 || stored.Metadata["channel"] != "store" || stored.Metadata["gift"] != "yes" || stored.Notes != "Fragile" {
+        t.Fatalf("got metadata %v, notes %q", stored.Metadata, stored.Notes)
+    }
+    if stored.Status != StatusConfirmed || !stored.TotalAmount.Equal(order.TotalAmount) {
+        t.Fatalf("metadata update changed the order: %q %s", stored.Status, stored.TotalAmount)
+    }
+
+    // Items still can't change once the order is confirmed.
+    items := `{"items":[{"product_id":"p","quantity":1,"price":"1"}],"notes":"x"}`
+    if w := doRequestWithHeaders(r, http.MethodPatch, path, items, ifMatch(stored)); w.Code != http.StatusConflict {
+        t.Fatalf("items on a confirmed order: got status %d, want 409", w.Code)
+    }
+}
+
+func TestPatchMetadataRejected(t *testing.T) {
+    resetOrders(t)
+    order := saveOrderWithStatus(StatusPending)
+    r := setupRouter()
+    path := "/orders/" + order.OrderID.String()
+
+    for _, body := range []string{
+        `{"metadata":{"bad\u0007key":"v"}}`,
+        `{"notes":"` + strings.Repeat("n", maxNotesLen+1) + `"}`,
+    } {
+        if w := doRequestWithHeaders(r, http.MethodPatch, path, body, ifMatch(order)); w.Code != http.StatusUnprocessableEntity {
+            t.Errorf("%.40s: got status %d, want 422", body, w.Code)
+        }
+    }
+    if w := doRequestWithHeaders(r, http.MethodPatch, path, `{}`, ifMatch(order)); w.Code != http.StatusBadRequest {
+        t.Errorf("empty patch: got status %d, want 400", w.Code)
+    }
+    if stored, _ := orders.FindByID(order.OrderID); stored.Metadata != nil || stored.Notes != "" || stored.Version != order.Version {
+        t.Fatalf("rejected patches changed the order: %+v", stored)
+    }
+}
+
+func TestSQLiteStoresMetadata(t *testing.T) {
+    repo := openTestSQLite(t, filepath.Join(t.TempDir(), "orders.db"))
+    order := newOutboxOrder()
+    order.Metadata = map[string]string{"channel": "pos"}
+    order.Notes = "Gift wrap"
+    if err := repo.Save(order); err != nil {
+        t.Fatal(err)
+    }
+
+    got, err := repo.FindByID(order.OrderID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got.Metadata["channel"] != "pos" || got.Notes != "Gift wrap" {
+        t.Fatalf("got metadata %v, notes %q", got.Metadata, got.Notes)
+    }
+}
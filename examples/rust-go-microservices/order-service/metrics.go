@@ -0,0 +1,92 @@
+package main
+
+import (
+    "github.com/gin-gonic/gin"
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsRegistry holds the service's own collectors plus the standard Go
+// and process ones. A private registry keeps tests independent of anything
+// registered globally by libraries.
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+    ordersCreated = prometheus.NewCounter(prometheus.CounterOpts{
+        Name: "orders_created_total",
+        Help: "Orders accepted and stored, whatever their payment outcome.",
+    })
+    ordersConfirmed = prometheus.NewCounter(prometheus.CounterOpts{
+        Name: "orders_confirmed_total",
+        Help: "Orders whose payment was approved.",
+    })
+    ordersPaymentFailed = prometheus.NewCounter(prometheus.CounterOpts{
+        Name: "orders_payment_failed_total",
+        Help: "Orders whose payment was declined or could not be processed.",
+    })
+    ordersPaymentMismatch = prometheus.NewCounter(prometheus.CounterOpts{
+        Name: "orders_payment_mismatch_total",
+        Help: "Orders whose payment was approved for a different amount than requested.",
+    })
+    paymentDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+        Name:    "payment_duration_seconds",
+        Help:    "Time spent charging an order, retries included.",
+        Buckets: prometheus.DefBuckets,
+    }, []string{"outcome"})
+    paymentRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Name: "payment_retries_total",
+        Help: "Payment retries made, or suppressed because the retry budget was spent.",
+    }, []string{"result"})
+    paymentRetryBudgetUsed = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+        Name: "payment_retry_budget_used_ratio",
+        Help: "Fraction of the payment retry budget spent in the current window.",
+    }, func() float64 { return payments.Budget.Used() })
+    paymentCallsInFlight = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+        Name: "payment_calls_in_flight",
+        Help: "Charges currently being sent to the payment service, out of PAYMENT_MAX_CONCURRENT.",
+    }, func() float64 { return float64(payments.Concurrency.Held()) })
+    orderQueueDepth = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+        Name: "order_queue_depth",
+        Help: "Order creations waiting for a turn, out of ORDER_QUEUE_DEPTH.",
+    }, func() float64 { return float64(creationQueue.Depth()) })
+    orderQueueWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+        Name:    "order_queue_wait_seconds",
+        Help:    "Time order creations spent queued, by whether they were admitted, timed out or found the queue full.",
+        Buckets: prometheus.DefBuckets,
+    }, []string{"result"})
+)
+
+// Payment outcomes used as the payment_duration_seconds label; a fixed set
+// keeps cardinality bounded.
+const (
+    paymentOutcomeApproved = "approved"
+    paymentOutcomeDeclined = "declined"
+    paymentOutcomeError    = "error"
+)
+
+// Results used as the payment_retries_total label.
+const (
+    retryAttempted  = "attempted"
+    retrySuppressed = "suppressed"
+)
+
+func init() {
+    metricsRegistry.MustRegister(
+        prometheus.NewGoCollector(),
+        prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+        ordersCreated,
+        ordersConfirmed,
+        ordersPaymentFailed,
+        ordersPaymentMismatch,
+        paymentDuration,
+        paymentRetries,
+        paymentRetryBudgetUsed,
+        paymentCallsInFlight,
+        orderQueueDepth,
+        orderQueueWait,
+    )
+}
+
+func metricsHandler() gin.HandlerFunc {
+    return gin.WrapH(promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+    "bufio"
+    "net/http"
+    "strconv"
+    "strings"
+    "testing"
+)
+
+// scrapeMetrics fetches /metrics and returns every sample by its full name,
+// labels included (e.g. `payment_duration_seconds_count{outcome="approved"}`).
+func scrapeMetrics(t *testing.T, r http.Handler) map[string]float64 {
+    t.Helper()
+
+    w := doRequest(r, http.MethodGet, "/metrics", "")
+    if w.Code != http.StatusOK {
+        t.Fatalf("GET /metrics: status %d", w.Code)
+    }
+
+    samples := make(map[string]float64)
+    sc := bufio.NewScanner(w.Body)
+    for sc.Scan() {
+        line := sc.Text()
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        i := strings.LastIndexByte(line, ' ')
+        v, err := strconv.ParseFloat(line[i+1:], 64)
+        if err != nil {
+            t.Fatalf("bad sample %q", line)
+        }
+        samples[line[:i]] = v
+    }
+    return samples
+}
+
+func TestMetricsCountOrders(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    before := scrapeMetrics(t, r)
+    if w := doRequest(r, http.MethodPost, "/orders", sampleOrder); w.Code != http.StatusCreated {
+        t.Fatalf("create: status %d", w.Code)
+    }
+    after := scrapeMetrics(t, r)
+
+    for _, name := range []string{
+        "orders_created_total",
+        "orders_confirmed_total",
+        `payment_duration_seconds_count{outcome="approved"}`,
+    } {
+        if got := after[name] - before[name]; got != 1 {
+            t.Errorf("%s moved by %v, want 1", name, got)
+        }
+    }
+    if got := after["orders_payment_failed_total"] - before["orders_payment_failed_total"]; got != 0 {
+        t.Errorf("orders_payment_failed_total moved by %v, want 0", got)
+    }
+}
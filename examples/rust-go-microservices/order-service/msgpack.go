@@ -0,0 +1,142 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "io"
+    "mime"
+    "reflect"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+    "github.com/gin-gonic/gin/binding"
+    "github.com/ugorji/go/codec"
+)
+
+// contentTypeMsgpack is the media type MessagePack responses are sent as.
+const contentTypeMsgpack = binding.MIMEMSGPACK2
+
+// msgpackHandle encodes and decodes the generic values msgpack bodies are
+// translated through. Maps decode with string keys and strings as strings,
+// so the result marshals straight back to JSON.
+var msgpackHandle = func() *codec.MsgpackHandle {
+    h := &codec.MsgpackHandle{WriteExt: true}
+    h.RawToString = true
+    h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+    h.MaxDepth = maxJSONDepth
+    return h
+}()
+
+// negotiateMsgpack lets clients speak MessagePack instead of JSON. A msgpack
+// request body is translated to JSON before the handlers bind it, and a JSON
+// response is translated to msgpack when the Accept header prefers it.
+//
+// Translating through the JSON form keeps both formats identical field for
+// field: decimals stay decimal strings, IDs and timestamps stay strings, so
+// a msgpack client never sees a Go-specific binary encoding.
+func negotiateMsgpack() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if wantsMsgpack(c) {
+            w := &msgpackWriter{ResponseWriter: c.Writer}
+            c.Writer = w
+            defer w.finish()
+        }
+
+        if isMsgpack(c.ContentType()) && c.Request.Body != nil {
+            body, err := msgpackToJSON(c.Request.Body)
+            if err != nil {
+                respondBindError(c, err)
+                return
+            }
+            c.Request.Body = io.NopCloser(bytes.NewReader(body))
+            c.Request.ContentLength = int64(len(body))
+            c.Request.Header.Set("Content-Type", binding.MIMEJSON)
+        }
+        c.Next()
+    }
+}
+
+// isMsgpack reports whether a media type names MessagePack.
+func isMsgpack(mediaType string) bool {
+    return mediaType == binding.MIMEMSGPACK || mediaType == binding.MIMEMSGPACK2
+}
+
+// wantsMsgpack reports whether the Accept header prefers msgpack over JSON.
+func wantsMsgpack(c *gin.Context) bool {
+    return isMsgpack(c.NegotiateFormat(binding.MIMEJSON, binding.MIMEMSGPACK2, binding.MIMEMSGPACK))
+}
+
+// msgpackToJSON reads a msgpack body and re-encodes it as JSON.
+func msgpackToJSON(r io.Reader) ([]byte, error) {
+    var v interface{}
+    if err := codec.NewDecoder(r, msgpackHandle).Decode(&v); err != nil {
+        return nil, err
+    }
+    return json.Marshal(v)
+}
+
+// jsonToMsgpack re-encodes a JSON document as msgpack. JSON numbers become
+// msgpack integers where they are whole, floats otherwise.
+func jsonToMsgpack(data []byte) ([]byte, error) {
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.UseNumber()
+    var v interface{}
+    if err := dec.Decode(&v); err != nil {
+        return nil, err
+    }
+    var out []byte
+    err := codec.NewEncoderBytes(&out, msgpackHandle).Encode(plainNumbers(v))
+    return out, err
+}
+
+// plainNumbers replaces the json.Numbers in a decoded document with int64 or
+// float64 values, which msgpack encodes as numbers rather than strings.
+func plainNumbers(v interface{}) interface{} {
+    switch v := v.(type) {
+    case map[string]interface{}:
+        for k, e := range v {
+            v[k] = plainNumbers(e)
+        }
+    case []interface{}:
+        for i, e := range v {
+            v[i] = plainNumbers(e)
+        }
+    case json.Number:
+        if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+            return n
+        }
+        f, _ := strconv.ParseFloat(string(v), 64)
+        return f
+    }
+    return v
+}
+
+// msgpackWriter holds a response back until the handler is done, then sends
+// it as msgpack if it was JSON. Anything else is sent unchanged.
+type msgpackWriter struct {
+    gin.ResponseWriter
+    buf bytes.Buffer
+}
+
+func (w *msgpackWriter) Write(p []byte) (int, error) {
+    return w.buf.Write(p)
+}
+
+func (w *msgpackWriter) WriteString(s string) (int, error) {
+    return w.buf.WriteString(s)
+}
+
+// Flush is a no-op: a JSON document can only be translated once it is
+// complete.
+func (w *msgpackWriter) Flush() {}
+
+func (w *msgpackWriter) finish() {
+    body := w.buf.Bytes()
+    if mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type")); mediaType == binding.MIMEJSON && len(body) > 0 {
+        if packed, err := jsonToMsgpack(body); err == nil {
+            w.Header().Set("Content-Type", contentTypeMsgpack)
+            body = packed
+        }
+    }
+    w.ResponseWriter.Write(body)
+}
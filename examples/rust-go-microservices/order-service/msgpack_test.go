@@ -0,0 +1,178 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/shopspring/decimal"
+    "github.com/ugorji/go/codec"
+)
+
+func encodeMsgpack(t *testing.T, v interface{}) []byte {
+    t.Helper()
+
+    var out []byte
+    if err := codec.NewEncoderBytes(&out, msgpackHandle).Encode(v); err != nil {
+        t.Fatal(err)
+    }
+    return out
+}
+
+// decodeMsgpackOrder decodes a msgpack order through its JSON form, the
+// same way a client would map it onto its own types.
+func decodeMsgpackOrder(t *testing.T, w *httptest.ResponseRecorder) Order {
+    t.Helper()
+
+    if got := w.Header().Get("Content-Type"); got != contentTypeMsgpack {
+        t.Fatalf("got Content-Type %q, want %q", got, contentTypeMsgpack)
+    }
+    data, err := msgpackToJSON(w.Body)
+    if err != nil {
+        t.Fatalf("body is not msgpack: %v", err)
+    }
+    var order Order
+    if err := json.Unmarshal(data, &order); err != nil {
+        t.Fatal(err)
+    }
+    return order
+}
+
+func doMsgpackRequest(r http.Handler, method, path string, body []byte) *httptest.ResponseRecorder {
+    req := httptest.NewRequest(method, path, bytes.NewReader(body))
+    if body != nil {
+        req.Header.Set("Content-Type", contentTypeMsgpack)
+    }
+    req.Header.Set("Accept", contentTypeMsgpack)
+    w := httptest.NewRecorder()
+    r.ServeHTTP(w, req)
+    return w
+}
+
+func TestOrderRoundTripJSON(t *testing.T) {
+    resetOrders(t)
+    newPaymentServer(t)
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", sampleOrder)
+    if w.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    if got := w.Header().Get("Content-Type"); got != "application/json; charset=utf-8" {
+        t.Fatalf("got Content-Type %q", got)
+    }
+    var created Order
+    if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+        t.Fatal(err)
+    }
+
+    w = doRequest(r, http.MethodGet, "/orders/"+created.OrderID.String(), "")
+    var fetched Order
+    if err := json.Unmarshal(w.Body.Bytes(), &fetched); err != nil {
+        t.Fatal(err)
+    }
+    if fetched.OrderID != created.OrderID {
+        t.Fatalf("got order %s, want %s", fetched.OrderID, created.OrderID)
+    }
+    if !fetched.TotalAmount.Equal(decimal.RequireFromString("59.98")) {
+        t.Fatalf("got total %s, want 59.98", fetched.TotalAmount)
+    }
+    if !fetched.Items[0].Price.Equal(decimal.RequireFromString("29.99")) {
+        t.Fatalf("got price %s, want 29.99", fetched.Items[0].Price)
+    }
+}
+
+func TestOrderRoundTripMsgpack(t *testing.T) {
+    resetOrders(t)
+    newPaymentServer(t)
+    r := setupRouter()
+
+    body := encodeMsgpack(t, map[string]interface{}{
+        "customer_id": "cust_123",
+        "items": []interface{}{
+            map[string]interface{}{"product_id": "prod_456", "quantity": 2, "price": "29.99"},
+        },
+    })
+    w := doMsgpackRequest(r, http.MethodPost, "/orders", body)
+    if w.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    created := decodeMsgpackOrder(t, w)
+    if !created.TotalAmount.Equal(decimal.RequireFromString("59.98")) {
+        t.Fatalf("got total %s, want 59.98", created.TotalAmount)
+    }
+
+    w = doMsgpackRequest(r, http.MethodGet, "/orders/"+created.OrderID.String(), nil)
+    if w.Code != http.StatusOK {
+        t.Fatalf("got status %d", w.Code)
+    }
+    raw := w.Body.Bytes()
+    fetched := decodeMsgpackOrder(t, w)
+    if fetched.OrderID != created.OrderID {
+        t.Fatalf("got order %s, want %s", fetched.OrderID, created.OrderID)
+    }
+    if !fetched.Items[0].Price.Equal(decimal.RequireFromString("29.99")) {
+        t.Fatalf("got price %s, want 29.99", fetched.Items[0].Price)
+    }
+
+    // Decimals and IDs are plain strings on the wire, not Go's binary
+    // encodings, so any msgpack client can read them.
+    var doc map[string]interface{}
+    if err := codec.NewDecoderBytes(raw, msgpackHandle).Decode(&doc); err != nil {
+        t.Fatal(err)
+    }
+    if got, ok := doc["total_amount"].(string); !ok || got != "59.98" {
+        t.Fatalf("got total_amount %#v, want \"59.98\"", doc["total_amount"])
+    }
+    if got, ok := doc["order_id"].(string); !ok || got != created.OrderID.String() {
+        t.Fatalf("got order_id %#v, want %q", doc["order_id"], created.OrderID)
+    }
+}
+
+func TestMsgpackErrorResponse(t *testing.T) {
+    resetOrders(t)
+    r := setupRouter()
+
+    body := encodeMsgpack(t, map[string]interface{}{"customer_id": "cust_123"})
+    w := doMsgpackRequest(r, http.MethodPost, "/orders", body)
+    if w.Code != http.StatusUnprocessableEntity {
+        t.Fatalf("got status %d", w.Code)
+    }
+    if got := w.Header().Get("Content-Type"); got != contentTypeMsgpack {
+        t.Fatalf("got Content-Type %q", got)
+    }
+    var resp errorResponse
+    data, err := msgpackToJSON(w.Body)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := json.Unmarshal(data, &resp); err != nil {
+        t.Fatal(err)
+    }
+    if resp.Error.Code != CodeValidationFailed {
+        t.Fatalf("got code %q, want %q", resp.Error.Code, CodeValidationFailed)
+    }
+}
+
+func TestMalformedMsgpackBody(t *testing.T) {
+    resetOrders(t)
+    r := setupRouter()
+
+    w := doMsgpackRequest(r, http.MethodPost, "/orders", []byte{0xc1})
+    if w.Code != http.StatusBadRequest {
+        t.Fatalf("got status %d, want 400", w.Code)
+    }
+}
+
+func TestJSONIsDefault(t *testing.T) {
+    r := setupRouter()
+
+    for _, accept := range []string{"", "*/*", "application/json", "application/json, application/msgpack"} {
+        w := doRequestWithHeaders(r, http.MethodGet, "/health/live", "", map[string]string{"Accept": accept})
+        if got := w.Header().Get("Content-Type"); got != "application/json; charset=utf-8" {
+            t.Errorf("Accept %q: got Content-Type %q", accept, got)
+        }
+    }
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+    "sync"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+)
+
+// orderLocker serializes work on a single order across the request handlers
+// and background jobs that read, change and save it. Unused locks are
+// dropped, so memory tracks only orders currently being worked on.
+type orderLocker struct {
+    mu    sync.Mutex
+    locks map[uuid.UUID]*orderLock
+}
+
+type orderLock struct {
+    sync.Mutex
+    waiters int
+}
+
+var orderLocks = &orderLocker{locks: make(map[uuid.UUID]*orderLock)}
+
+// lock blocks until the caller holds id's lock and returns the function
+// that releases it.
+func (l *orderLocker) lock(id uuid.UUID) (unlock func()) {
+    l.mu.Lock()
+    lk, ok := l.locks[id]
+    if !ok {
+        lk = &orderLock{}
+        l.locks[id] = lk
+    }
+    lk.waiters++
+    l.mu.Unlock()
+
+    lk.Lock()
+    return func() {
+        lk.Unlock()
+        l.mu.Lock()
+        lk.waiters--
+        if lk.waiters == 0 {
+            delete(l.locks, id)
+        }
+        l.mu.Unlock()
+    }
+}
+
+// lockOrderParam takes the lock of the order named by the :id path
+// parameter, for handlers that change an order the payment service or a
+// background job may be working on. An invalid ID takes no lock; loadOrder
+// rejects it.
+func lockOrderParam(c *gin.Context) (unlock func()) {
+    orderID, err := uuid.Parse(c.Param("id"))
+    if err != nil {
+        return func() {}
+    }
+    return orderLocks.lock(orderID)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "time"
+)
+
+const (
+    defaultOutboxInterval  = time.Second
+    defaultOutboxBatchSize = 100
+    // outboxDrainTimeout bounds the last relay pass made at shutdown.
+    outboxDrainTimeout = 5 * time.Second
+)
+
+// OutboxEntry is an event waiting in the outbox to be published.
+type OutboxEntry struct {
+    ID    int64
+    Event OrderEvent
+}
+
+// Outbox is implemented by repositories that can record events in the same
+// transaction as the order change they announce, so that a crash between
+// saving an order and publishing its events can't lose them.
+type Outbox interface {
+    // SaveWithEvents saves order as Save does and queues events for
+    // delivery, atomically: either both happen or neither does.
+    SaveWithEvents(order *Order, events []OrderEvent) error
+    // UnsentEvents returns up to limit queued events, oldest first.
+    UnsentEvents(limit int) ([]OutboxEntry, error)
+    // MarkSent records that the entry was delivered. Marking an entry twice
+    // is harmless.
+    MarkSent(id int64) error
+}
+
+// outbox is the repository's outbox when an OutboxRelay delivers its
+// events; nil publishes them straight after the save, best-effort.
+var outbox Outbox
+
+// saveAndPublish saves order and announces it with the given event types,
+// skipping empty ones. Through the outbox, delivery is at least once and
+// left to the relay; otherwise the events are published right away and are
+// lost if the process dies first.
+func saveAndPublish(ctx context.Context, order *Order, eventTypes ...string) error {
+    var queued []OrderEvent
+    for _, eventType := range eventTypes {
+        if eventType != "" {
+            queued = append(queued, newOrderEvent(eventType, order))
+        }
+    }
+
+    if outbox != nil {
+        return outbox.SaveWithEvents(order, queued)
+    }
+    if err := orders.Save(order); err != nil {
+        return err
+    }
+    for _, event := range queued {
+        if err := events.Publish(ctx, event); err != nil {
+            loggerFrom(ctx).Warn("failed to publish event", "type", event.Type, "order_id", event.OrderID, "error", err)
+        }
+    }
+    return nil
+}
+
+// OutboxRelay sends the outbox's events to a broker. An event is marked
+// sent only once the broker has accepted it, so one that was sent but not
+// marked before a crash is sent again: consumers see every event at least
+// once and dedupe by its event_id.
+type OutboxRelay struct {
+    Outbox Outbox
+    Broker Broker
+    // Interval is the time between passes; BatchSize bounds the events read
+    // per query.
+    Interval  time.Duration
+    BatchSize int
+
+    after func(d time.Duration) <-chan time.Time
+}
+
+func NewOutboxRelay(outbox Outbox, broker Broker, interval time.Duration) *OutboxRelay {
+    return &OutboxRelay{
+        Outbox:    outbox,
+        Broker:    broker,
+        Interval:  interval,
+        BatchSize: defaultOutboxBatchSize,
+        after:     time.After,
+    }
+}
+
+// Run relays every Interval until ctx is done, then makes a last, bounded
+// pass for events saved by requests that finished during shutdown. Anything
+// still unsent waits in the outbox for the next start.
+func (r *OutboxRelay) Run(ctx context.Context) {
+    for {
+        select {
+        case <-ctx.Done():
+            drainCtx, cancel := context.WithTimeout(context.Background(), outboxDrainTimeout)
+            defer cancel()
+            if _, err := r.relayOnce(drainCtx); err != nil {
+                logger.Warn("outbox relay stopped with events unsent", "error", err)
+            }
+            return
+        case <-r.after(r.Interval):
+        }
+        if _, err := r.relayOnce(ctx); err != nil {
+            logger.Warn("outbox relay failed", "error", err)
+        }
+    }
+}
+
+// relayOnce sends unsent events, oldest first, until none are left and
+// returns how many it sent. It stops at the first failure, leaving that
+// event and the ones after it for the next pass, so events are delivered in
+// the order they were saved.
+func (r *OutboxRelay) relayOnce(ctx context.Context) (int, error) {
+    sent := 0
+    for {
+        entries, err := r.Outbox.UnsentEvents(r.BatchSize)
+        if err != nil || len(entries) == 0 {
+            return sent, err
+        }
+        for _, entry := range entries {
+            if err := ctx.Err(); err != nil {
+                return sent, err
+            }
+            payload, err := json.Marshal(entry.Event)
+            if err != nil {
+                return sent, err
+            }
+            if err := r.Broker.Send(ctx, entry.Event.Type, payload); err != nil {
+                return sent, err
+            }
+            if err := r.Outbox.MarkSent(entry.ID); err != nil {
+                return sent, err
+            }
+            sent++
+        }
+    }
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "path/filepath"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// recordingBroker keeps sent events; its first failures sends fail.
+type recordingBroker struct {
+    mu       sync.Mutex
+    failures int
+    sent     []OrderEvent
+}
+
+func (b *recordingBroker) Send(_ context.Context, _ string, payload []byte) error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if b.failures > 0 {
+        b.failures--
+        return errors.New("broker unavailable")
+    }
+    var event OrderEvent
+    if err := json.Unmarshal(payload, &event); err != nil {
+        return err
+    }
+    b.sent = append(b.sent, event)
+    return nil
+}
+
+func (b *recordingBroker) Sent() []OrderEvent {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    return append([]OrderEvent(nil), b.sent...)
+}
+
+// useOutbox points orders and the outbox at repo for the duration of the
+// test.
+func useOutbox(t *testing.T, repo *SQLiteRepository) {
+    t.Helper()
+
+    prevOrders, prevOutbox := orders, outbox
+    orders, outbox = repo, repo
+    t.Cleanup(func() { orders, outbox = prevOrders, prevOutbox })
+}
+
+func newOutboxOrder() *Order {
+    return &Order{OrderID: uuid.New(), CustomerID: "cust_123", Currency: "USD", Status: StatusPending, CreatedAt: time.Now()}
+}
+
+func TestOutboxSurvivesCrashBeforePublish(t *testing.T) {
+    newPaymentServer(t)
+    rec := recordEvents(t)
+    path := filepath.Join(t.TempDir(), "orders.db")
+    repo, err := OpenSQLiteRepository(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    useOutbox(t, repo)
+
+    if w := doRequest(setupRouter(), http.MethodPost, "/orders", sampleOrder); w.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    if evs := rec.Events(); len(evs) != 0 {
+        t.Fatalf("events %v were published directly instead of through the outbox", eventTypes(evs))
+    }
+    // Crash: the process dies before any relay pass.
+    repo.Close()
+
+    reopened := openTestSQLite(t, path)
+    broker := &recordingBroker{}
+    relay := NewOutboxRelay(reopened, broker, time.Second)
+    n, err := relay.relayOnce(context.Background())
+    if err != nil || n != 2 {
+        t.Fatalf("relayed %d events, err %v; want 2", n, err)
+    }
+    got := broker.Sent()
+    if got[0].Type != EventOrderCreated || got[1].Type != EventOrderConfirmed || got[0].EventID == got[1].EventID {
+        t.Fatalf("got events %+v", got)
+    }
+
+    if n, err := relay.relayOnce(context.Background()); err != nil || n != 0 {
+        t.Fatalf("second pass relayed %d events, err %v; want none", n, err)
+    }
+}
+
+func TestOutboxRelayRetriesFailedSends(t *testing.T) {
+    repo := openTestSQLite(t, filepath.Join(t.TempDir(), "orders.db"))
+    for i := 0; i < 3; i++ {
+        order := newOutboxOrder()
+        if err := repo.SaveWithEvents(order, []OrderEvent{newOrderEvent(EventOrderCreated, order)}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    broker := &recordingBroker{failures: 1}
+    relay := NewOutboxRelay(repo, broker, time.Second)
+
+    if n, err := relay.relayOnce(context.Background()); err == nil || n != 0 {
+        t.Fatalf("relayed %d events, err %v; want the failure reported", n, err)
+    }
+    if n, err := relay.relayOnce(context.Background()); err != nil || n != 3 {
+        t.Fatalf("relayed %d events, err %v; want 3", n, err)
+    }
+    if got := broker.Sent(); len(got) != 3 {
+        t.Fatalf("broker got %d events, want each of the 3 once", len(got))
+    }
+}
+
+func TestOutboxRollsBackEventsWithFailedSave(t *testing.T) {
+    repo := openTestSQLite(t, filepath.Join(t.TempDir(), "orders.db"))
+    order := newOutboxOrder()
+    repo.Save(order)
+
+    stale := *order
+    stale.Version = 0
+    if err := repo.SaveWithEvents(&stale, []OrderEvent{newOrderEvent(EventOrderConfirmed, &stale)}); !errors.Is(err, ErrVersionConflict) {
+        t.Fatalf("got %v, want ErrVersionConflict", err)
+    }
+    if entries, err := repo.UnsentEvents(10); err != nil || len(entries) != 0 {
+        t.Fatalf("got %d outbox entries, err %v; want none", len(entries), err)
+    }
+}
+
+func TestOutboxRelayDrainsOnShutdown(t *testing.T) {
+    repo := openTestSQLite(t, filepath.Join(t.TempDir(), "orders.db"))
+    order := newOutboxOrder()
+    repo.SaveWithEvents(order, []OrderEvent{newOrderEvent(EventOrderCreated, order)})
+
+    broker := &recordingBroker{}
+    relay := NewOutboxRelay(repo, broker, time.Hour)
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    go func() {
+        relay.Run(ctx)
+        close(done)
+    }()
+    cancel()
+
+    select {
+    case <-done:
+    case <-time.After(time.Second):
+        t.Fatal("relay did not stop after shutdown")
+    }
+    if got := broker.Sent(); len(got) != 1 {
+        t.Fatalf("got %d events sent at shutdown, want 1", len(got))
+    }
+}
@@ -0,0 +1,66 @@
+package main
+
+import "sync"
+
+const (
+    defaultOutcomeWindow = 100
+    // minOutcomesForRate is how many calls an OutcomeWindow must have seen
+    // before its error rate counts for anything; one failure out of two is
+    // noise, not a trend.
+    minOutcomesForRate = 20
+)
+
+// OutcomeWindow remembers whether each of the last size calls to a
+// dependency succeeded, for telling how often it is failing now.
+type OutcomeWindow struct {
+    mu     sync.Mutex
+    failed []bool
+    next   int
+    full   bool
+    errors int
+}
+
+func NewOutcomeWindow(size int) *OutcomeWindow {
+    return &OutcomeWindow{failed: make([]bool, size)}
+}
+
+// Record adds the outcome of a call, forgetting the oldest once the window
+// is full. A nil OutcomeWindow records nothing.
+func (w *OutcomeWindow) Record(success bool) {
+    if w == nil || len(w.failed) == 0 {
+        return
+    }
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    if w.full && w.failed[w.next] {
+        w.errors--
+    }
+    w.failed[w.next] = !success
+    if !success {
+        w.errors++
+    }
+    w.next = (w.next + 1) % len(w.failed)
+    if w.next == 0 {
+        w.full = true
+    }
+}
+
+// ErrorRate returns the fraction of the calls in the window that failed,
+// and false if there have been too few to say.
+func (w *OutcomeWindow) ErrorRate() (float64, bool) {
+    if w == nil {
+        return 0, false
+    }
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    n := w.next
+    if w.full {
+        n = len(w.failed)
+    }
+    if n < minOutcomesForRate {
+        return 0, false
+    }
+    return float64(w.errors) / float64(n), true
+}
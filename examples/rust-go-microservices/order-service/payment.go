@@ -0,0 +1,568 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "math/rand"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/shopspring/decimal"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/propagation"
+    "go.opentelemetry.io/otel/trace"
+)
+
+type PaymentRequest struct {
+    OrderID uuid.UUID `json:"order_id"`
+    // IdempotencyKey is the same for every attempt at charging one order,
+    // retries included, and differs between orders. The payment service
+    // charges each key at most once.
+    IdempotencyKey string          `json:"idempotency_key,omitempty"`
+    Amount         decimal.Decimal `json:"amount"`
+    Currency       string          `json:"currency"`
+    PaymentMethod  string          `json:"payment_method"`
+    // Details carries the method-specific metadata, when the order has it.
+    Details *PaymentMethod `json:"payment_method_details,omitempty"`
+}
+
+type PaymentResponse struct {
+    PaymentID uuid.UUID `json:"payment_id"`
+    OrderID   uuid.UUID `json:"order_id"`
+    Status    string    `json:"status"`
+    // Amount is what the payment service charged, when it says; an approval
+    // for anything other than the amount requested doesn't confirm the
+    // order.
+    Amount      *decimal.Decimal `json:"amount,omitempty"`
+    ProcessedAt time.Time        `json:"processed_at"`
+}
+
+type RefundRequest struct {
+    OrderID uuid.UUID `json:"order_id"`
+    // PaymentID names the charge to refund when the order was paid in
+    // several; otherwise the order's one payment is refunded.
+    PaymentID *uuid.UUID `json:"payment_id,omitempty"`
+    // IdempotencyKey is the same for every attempt at one refund, retries
+    // included. The payment service refunds each key at most once.
+    IdempotencyKey string          `json:"idempotency_key,omitempty"`
+    Amount         decimal.Decimal `json:"amount"`
+    Currency       string          `json:"currency"`
+}
+
+type RefundResponse struct {
+    RefundID    uuid.UUID       `json:"refund_id"`
+    OrderID     uuid.UUID       `json:"order_id"`
+    Amount      decimal.Decimal `json:"amount"`
+    Status      string          `json:"status"`
+    ProcessedAt time.Time       `json:"processed_at"`
+}
+
+const (
+    defaultPaymentServiceURL = "http://localhost:8001"
+    defaultPaymentMaxRetries = 3
+    defaultPaymentRetryDelay = 100 * time.Millisecond
+    defaultPaymentMaxElapsed = 10 * time.Second
+    // paymentAttemptTimeout is the default AttemptTimeout; MaxElapsed
+    // bounds the call as a whole.
+    paymentAttemptTimeout = 5 * time.Second
+)
+
+// ConnPool sizes the pool of keep-alive connections the payment client
+// reuses across calls.
+type ConnPool struct {
+    // MaxIdle bounds idle connections in total and MaxIdlePerHost per host;
+    // the standard library's default of 2 per host would make concurrent
+    // payments open and tear down connections constantly.
+    MaxIdle        int
+    MaxIdlePerHost int
+    // IdleTimeout is how long an unused connection is kept open.
+    IdleTimeout time.Duration
+}
+
+var defaultPaymentConnPool = ConnPool{MaxIdle: 100, MaxIdlePerHost: 32, IdleTimeout: 90 * time.Second}
+
+// newPooledTransport returns a transport with the standard library's dial
+// and TLS settings and pool's connection limits.
+func newPooledTransport(pool ConnPool) *http.Transport {
+    t := http.DefaultTransport.(*http.Transport).Clone()
+    t.MaxIdleConns = pool.MaxIdle
+    t.MaxIdleConnsPerHost = pool.MaxIdlePerHost
+    t.IdleConnTimeout = pool.IdleTimeout
+    return t
+}
+
+// newPaymentHTTPClient is the one http.Client the payment client shares
+// across all calls, so connections and TLS sessions are reused. Deadlines
+// come from each call's context, so it has no timeout of its own.
+func newPaymentHTTPClient(pool ConnPool) *http.Client {
+    return &http.Client{Transport: newPooledTransport(pool)}
+}
+
+// PaymentClient talks to the payment service. Failed calls are retried with
+// exponential backoff and jitter, but only for connection errors and 5xx
+// responses; a 4xx is the payment service telling us the request is wrong,
+// so repeating it cannot help.
+type PaymentClient struct {
+    BaseURL    string
+    HTTPClient *http.Client
+
+    // MaxRetries is the number of attempts made after the first one fails.
+    MaxRetries int
+    // BaseDelay is the wait before the first retry; it doubles each time.
+    BaseDelay time.Duration
+    // MaxElapsed bounds the total time spent on a call, retries included.
+    MaxElapsed time.Duration
+    // AttemptTimeout bounds each attempt; one that runs out is retried.
+    AttemptTimeout time.Duration
+
+    // Breaker fast-fails calls while the payment service is down.
+    Breaker *CircuitBreaker
+    // Budget, if set, caps retries across all calls; see RetryBudget.
+    Budget *RetryBudget
+    // Concurrency, if set, bounds how many charges are in flight at once,
+    // each holding a weight of one. Charges beyond it wait for a slot as
+    // long as their context allows.
+    Concurrency *Semaphore
+    // Outcomes, if set, records whether recent charges reached the payment
+    // service and got an answer, for readiness to judge how it is doing.
+    Outcomes *OutcomeWindow
+
+    // sleep waits for d or until ctx is done. Tests replace it to avoid
+    // real waits.
+    sleep func(ctx context.Context, d time.Duration) error
+}
+
+func NewPaymentClient(baseURL string) *PaymentClient {
+    return &PaymentClient{
+        BaseURL:        baseURL,
+        HTTPClient:     newPaymentHTTPClient(defaultPaymentConnPool),
+        MaxRetries:     defaultPaymentMaxRetries,
+        BaseDelay:      defaultPaymentRetryDelay,
+        MaxElapsed:     defaultPaymentMaxElapsed,
+        AttemptTimeout: paymentAttemptTimeout,
+        Breaker:        NewCircuitBreaker(defaultBreakerThreshold, defaultBreakerCooldown),
+        Budget:         NewRetryBudget(defaultRetryBudgetPercent/100.0, defaultRetryBudgetWindow, defaultRetryBudgetMin),
+        Concurrency:    NewSemaphore(defaultMaxPaymentCalls),
+        Outcomes:       NewOutcomeWindow(defaultOutcomeWindow),
+        sleep:          sleepContext,
+    }
+}
+
+var payments = NewPaymentClient(defaultPaymentServiceURL)
+
+// newPaymentClientFromEnv builds the payment client from PAYMENT_SERVICE_URL
+// and the retry, retry budget, circuit breaker and connection pool settings.
+func newPaymentClientFromEnv() (*PaymentClient, error) {
+    raw := os.Getenv("PAYMENT_SERVICE_URL")
+    if raw == "" {
+        raw = defaultPaymentServiceURL
+    }
+    baseURL, err := parseServiceURL(raw)
+    if err != nil {
+        return nil, fmt.Errorf("PAYMENT_SERVICE_URL: %w", err)
+    }
+    p := NewPaymentClient(baseURL)
+
+    if p.MaxRetries, err = envInt("PAYMENT_MAX_RETRIES", defaultPaymentMaxRetries); err != nil {
+        return nil, err
+    }
+    if p.BaseDelay, err = envDuration("PAYMENT_RETRY_BASE_DELAY", defaultPaymentRetryDelay); err != nil {
+        return nil, err
+    }
+    threshold, err := envInt("PAYMENT_BREAKER_THRESHOLD", defaultBreakerThreshold)
+    if err != nil {
+        return nil, err
+    }
+    cooldown, err := envDuration("PAYMENT_BREAKER_COOLDOWN", defaultBreakerCooldown)
+    if err != nil {
+        return nil, err
+    }
+    p.Breaker = NewCircuitBreaker(threshold, cooldown)
+
+    percent, err := envInt("PAYMENT_RETRY_BUDGET_PERCENT", defaultRetryBudgetPercent)
+    if err != nil {
+        return nil, err
+    }
+    window, err := envDuration("PAYMENT_RETRY_BUDGET_WINDOW", defaultRetryBudgetWindow)
+    if err != nil {
+        return nil, err
+    }
+    minRetries, err := envInt("PAYMENT_RETRY_BUDGET_MIN", defaultRetryBudgetMin)
+    if err != nil {
+        return nil, err
+    }
+    p.Budget = NewRetryBudget(float64(percent)/100, window, minRetries)
+
+    maxCalls, err := envInt("PAYMENT_MAX_CONCURRENT", defaultMaxPaymentCalls)
+    if err != nil {
+        return nil, err
+    }
+    switch {
+    case maxCalls < 0:
+        return nil, fmt.Errorf("PAYMENT_MAX_CONCURRENT must not be negative, got %d", maxCalls)
+    case maxCalls == 0:
+        p.Concurrency = nil
+    default:
+        p.Concurrency = NewSemaphore(int64(maxCalls))
+    }
+
+    pool := defaultPaymentConnPool
+    if pool.MaxIdle, err = envInt("PAYMENT_MAX_IDLE_CONNS", pool.MaxIdle); err != nil {
+        return nil, err
+    }
+    if pool.MaxIdlePerHost, err = envInt("PAYMENT_MAX_IDLE_CONNS_PER_HOST", pool.MaxIdlePerHost); err != nil {
+        return nil, err
+    }
+    if pool.IdleTimeout, err = envDuration("PAYMENT_IDLE_CONN_TIMEOUT", pool.IdleTimeout); err != nil {
+        return nil, err
+    }
+    p.HTTPClient = newPaymentHTTPClient(pool)
+
+    return p, nil
+}
+
+// ping checks the payment service answers its health endpoint. It bypasses
+// retries and the circuit breaker: readiness wants the current answer.
+func (p *PaymentClient) ping(ctx context.Context) error {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.BaseURL+"/health", nil)
+    if err != nil {
+        return err
+    }
+    resp, err := p.HTTPClient.Do(req)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("payment service health returned status %d", resp.StatusCode)
+    }
+    return nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+    timer := time.NewTimer(d)
+    defer timer.Stop()
+
+    select {
+    case <-timer.C:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+const (
+    // maxPaymentResponseBytes caps how much of a payment service response
+    // is read, so a misbehaving service can't make us buffer without end.
+    maxPaymentResponseBytes = 1 << 20
+    // paymentBodySnippetLen is how much of an unusable response body an
+    // error quotes.
+    paymentBodySnippetLen = 256
+)
+
+// statusError is an error status returned by the payment service. body is
+// the start of the response, which often says what went wrong.
+type statusError struct {
+    code int
+    body string
+}
+
+func (e *statusError) Error() string {
+    if e.body == "" {
+        return fmt.Sprintf("payment service returned status %d", e.code)
+    }
+    return fmt.Sprintf("payment service returned status %d: %q", e.code, e.body)
+}
+
+// malformedResponseError is a successful status from the payment service with a body
+// that isn't the response expected, such as an HTML page from a proxy in
+// front of it. It isn't retried: the request may well have been processed.
+type malformedResponseError struct {
+    err  error
+    body string
+}
+
+func (e *malformedResponseError) Error() string {
+    if e.body == "" {
+        return fmt.Sprintf("payment service returned a malformed response: %v", e.err)
+    }
+    return fmt.Sprintf("payment service returned a malformed response: %v: %q", e.err, e.body)
+}
+
+func (e *malformedResponseError) Unwrap() error { return e.err }
+
+// bodySnippet returns the start of body for an error message.
+func bodySnippet(body []byte) string {
+    s := strings.TrimSpace(string(body))
+    if len(s) > paymentBodySnippetLen {
+        s = strings.ToValidUTF8(s[:paymentBodySnippetLen], "") + "..."
+    }
+    return s
+}
+
+// retryableError marks a failure worth trying again.
+type retryableError struct{ err error }
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// backoff returns the jittered delay before retry number attempt (0-based):
+// a random duration in [d/2, d) where d = BaseDelay * 2^attempt.
+func (p *PaymentClient) backoff(attempt int) time.Duration {
+    d := p.BaseDelay << attempt
+    if d <= 1 {
+        return d
+    }
+    return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// post sends body as JSON to path and decodes a successful response into out.
+func (p *PaymentClient) post(ctx context.Context, path string, body, out interface{}) error {
+    jsonData, err := json.Marshal(body)
+    if err != nil {
+        return err
+    }
+    return p.call(ctx, http.MethodPost, path, jsonData, out)
+}
+
+// get fetches path and decodes a successful response into out.
+func (p *PaymentClient) get(ctx context.Context, path string, out interface{}) error {
+    return p.call(ctx, http.MethodGet, path, nil, out)
+}
+
+// call makes a request to the payment service, retrying transient failures.
+// It returns ErrCircuitOpen without calling the payment service while the
+// breaker is open, and gives up as soon as ctx is done.
+func (p *PaymentClient) call(ctx context.Context, method, path string, jsonData []byte, out interface{}) error {
+    if !p.Breaker.Allow() {
+        return ErrCircuitOpen
+    }
+    err := p.callWithRetries(ctx, method, path, jsonData, out)
+    if err != nil {
+        loggerFrom(ctx).Warn("payment service call failed", "path", path, "error", err)
+    }
+    if ctx.Err() != nil {
+        // The caller gave up; that says nothing about the payment service.
+        p.Breaker.Release()
+        return err
+    }
+    // Only transient failures say anything about the payment service's
+    // health; a 4xx or a bad payload means it answered.
+    var retryable *retryableError
+    p.Breaker.Record(!errors.As(err, &retryable) && !errors.Is(err, context.DeadlineExceeded))
+    return err
+}
+
+func (p *PaymentClient) callWithRetries(ctx context.Context, method, path string, jsonData []byte, out interface{}) error {
+    var err error
+
+    ctx, cancel := context.WithTimeout(ctx, p.MaxElapsed)
+    defer cancel()
+
+    p.Budget.RecordRequest()
+    for attempt := 0; ; attempt++ {
+        err = p.callOnce(ctx, method, path, jsonData, out)
+        var retryable *retryableError
+        if err == nil || !errors.As(err, &retryable) || attempt >= p.MaxRetries {
+            return err
+        }
+        if !p.Budget.TryRetry() {
+            paymentRetries.WithLabelValues(retrySuppressed).Inc()
+            return fmt.Errorf("retry budget exhausted after %d attempts: %w", attempt+1, err)
+        }
+        paymentRetries.WithLabelValues(retryAttempted).Inc()
+        if sleepErr := p.sleep(ctx, p.backoff(attempt)); sleepErr != nil {
+            return fmt.Errorf("giving up after %d attempts: %w (last error: %w)", attempt+1, sleepErr, err)
+        }
+    }
+}
+
+func (p *PaymentClient) callOnce(ctx context.Context, method, path string, jsonData []byte, out interface{}) error {
+    var body io.Reader
+    if jsonData != nil {
+        body = bytes.NewReader(jsonData)
+    }
+    // The attempt's own deadline is retryable; ctx running out is not,
+    // which is why the checks below look at ctx rather than attemptCtx.
+    attemptCtx, cancel := callTimeout(ctx, p.AttemptTimeout)
+    defer cancel()
+    req, err := http.NewRequestWithContext(attemptCtx, method, p.BaseURL+path, body)
+    if err != nil {
+        return err
+    }
+    if jsonData != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+    resp, err := p.HTTPClient.Do(req)
+    if err != nil {
+        if ctx.Err() != nil {
+            return err
+        }
+        return &retryableError{err}
+    }
+    defer resp.Body.Close()
+
+    data, err := io.ReadAll(io.LimitReader(resp.Body, maxPaymentResponseBytes+1))
+    if err != nil {
+        if ctx.Err() != nil {
+            return err
+        }
+        return &retryableError{fmt.Errorf("reading payment service response: %w", err)}
+    }
+    if resp.StatusCode >= 500 {
+        return &retryableError{&statusError{resp.StatusCode, bodySnippet(data)}}
+    }
+    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+        return &statusError{resp.StatusCode, bodySnippet(data)}
+    }
+    if len(data) > maxPaymentResponseBytes {
+        return &malformedResponseError{fmt.Errorf("response larger than %d bytes", maxPaymentResponseBytes), bodySnippet(data)}
+    }
+    if err := json.Unmarshal(data, out); err != nil {
+        return &malformedResponseError{err, bodySnippet(data)}
+    }
+    return nil
+}
+
+func (p *PaymentClient) processPayment(ctx context.Context, req PaymentRequest) (*PaymentResponse, error) {
+    start := time.Now()
+    ctx, span := startSpan(ctx, "processPayment", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+        attribute.String("payment.currency", req.Currency),
+        attribute.String("payment.amount", req.Amount.String()),
+    ))
+    defer span.End()
+
+    if err := p.Concurrency.Acquire(ctx, 1); err != nil {
+        span.RecordError(err)
+        span.SetStatus(codes.Error, "payment calls saturated")
+        return nil, fmt.Errorf("%w: %w", ErrPaymentSaturated, err)
+    }
+    defer p.Concurrency.Release(1)
+
+    var paymentResp PaymentResponse
+    err := p.post(ctx, "/process", req, &paymentResp)
+    if err == nil && paymentResp.Status == "" {
+        // Valid JSON without a status, such as {}, would otherwise pass
+        // for a declined payment.
+        err = &malformedResponseError{errors.New("no payment status"), ""}
+    }
+    if !errors.Is(err, ErrCircuitOpen) {
+        // A declined payment is an answer; only errors count against the
+        // service.
+        p.Outcomes.Record(err == nil)
+    }
+    if err != nil {
+        span.RecordError(err)
+        span.SetStatus(codes.Error, "payment failed")
+        if !errors.Is(err, ErrCircuitOpen) {
+            paymentDuration.WithLabelValues(paymentOutcomeError).Observe(time.Since(start).Seconds())
+        }
+        return nil, err
+    }
+
+    span.SetAttributes(attribute.String("payment.status", paymentResp.Status))
+    outcome := paymentOutcomeDeclined
+    if paymentResp.Status == "approved" {
+        outcome = paymentOutcomeApproved
+    }
+    paymentDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+
+    return &paymentResp, nil
+}
+
+// paymentAmountTolerance is how far an approved amount may be from the one
+// requested and still confirm the order. It is zero unless configured: the
+// amounts are decimals on both sides, so any difference is a real one.
+var paymentAmountTolerance decimal.Decimal
+
+// paymentAmountMatches reports whether the payment service approved the
+// amount of order it was asked to charge, logging both amounts when it
+// didn't. A response that doesn't say what it charged can't be checked and
+// is trusted.
+func paymentAmountMatches(ctx context.Context, order *Order, amount decimal.Decimal, resp *PaymentResponse) bool {
+    if resp.Amount == nil {
+        return true
+    }
+    requested, currency := order.chargeAmount(amount)
+    if requested.Sub(*resp.Amount).Abs().LessThanOrEqual(paymentAmountTolerance) {
+        return true
+    }
+    loggerFrom(ctx).Error("payment approved for a different amount",
+        "order_id", order.OrderID,
+        "requested_amount", requested.String(),
+        "approved_amount", resp.Amount.String(),
+        "currency", currency,
+        "payment_id", resp.PaymentID)
+    return false
+}
+
+// paymentUnreachable reports whether err means the payment service couldn't
+// be asked or never answered, as opposed to answering with an error, so a
+// charge that failed with it may be tried again later.
+func paymentUnreachable(err error) bool {
+    if errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrPaymentSaturated) || errors.Is(err, context.DeadlineExceeded) {
+        return true
+    }
+    var retryable *retryableError
+    var status *statusError
+    return errors.As(err, &retryable) && !errors.As(err, &status)
+}
+
+// refundStatusRefunded is the payment service's status for a refund that
+// went through.
+const refundStatusRefunded = "refunded"
+
+func (p *PaymentClient) refundPayment(ctx context.Context, req RefundRequest) (*RefundResponse, error) {
+    ctx, span := startSpan(ctx, "refundPayment", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+        attribute.String("payment.currency", req.Currency),
+        attribute.String("payment.amount", req.Amount.String()),
+    ))
+    defer span.End()
+
+    var refundResp RefundResponse
+    if err := p.post(ctx, "/refund", req, &refundResp); err != nil {
+        span.RecordError(err)
+        span.SetStatus(codes.Error, "refund failed")
+        return nil, err
+    }
+    span.SetAttributes(attribute.String("payment.status", refundResp.Status))
+
+    return &refundResp, nil
+}
+
+// ErrPaymentNotFound is returned by lookupPayment when the payment service
+// has no payment for the order.
+var ErrPaymentNotFound = errors.New("payment not found")
+
+// lookupPayment asks the payment service for the payment made for orderID.
+func (p *PaymentClient) lookupPayment(ctx context.Context, orderID uuid.UUID) (*PaymentResponse, error) {
+    ctx, span := startSpan(ctx, "lookupPayment", trace.WithSpanKind(trace.SpanKindClient))
+    defer span.End()
+
+    var paymentResp PaymentResponse
+    err := p.get(ctx, "/payments/"+orderID.String(), &paymentResp)
+    var status *statusError
+    if errors.As(err, &status) && status.code == http.StatusNotFound {
+        return nil, ErrPaymentNotFound
+    }
+    if err != nil {
+        span.RecordError(err)
+        span.SetStatus(codes.Error, "payment lookup failed")
+        return nil, err
+    }
+    span.SetAttributes(attribute.String("payment.status", paymentResp.Status))
+
+    return &paymentResp, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/shopspring/decimal"
+)
+
+func TestPaymentAmountMatches(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", sampleOrder)
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if w.Code != http.StatusCreated || order.Status != StatusConfirmed {
+        t.Fatalf("got status %d, order %q", w.Code, order.Status)
+    }
+    if charged := fake.lastCharge.Load().Amount; !charged.Equal(order.TotalAmount) {
+        t.Fatalf("charged %s for a %s order", charged, order.TotalAmount)
+    }
+}
+
+func TestPaymentAmountMismatch(t *testing.T) {
+    fake := newPaymentServer(t)
+    approved := decimal.RequireFromString("59.97")
+    fake.amount = &approved
+    resetOrders(t)
+    rec := recordEvents(t)
+    logs := captureLogs(t)
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", sampleOrder)
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if order.Status != StatusPaymentMismatch {
+        t.Fatalf("got status %d, order %q, want %q", w.Code, order.Status, StatusPaymentMismatch)
+    }
+    if stored, _ := orders.FindByID(order.OrderID); stored.Status != StatusPaymentMismatch {
+        t.Fatalf("stored order is %q", stored.Status)
+    }
+    evs := rec.Events()
+    if last := evs[len(evs)-1]; last.Type != EventOrderPaymentMismatch {
+        t.Fatalf("last event %+v, want %s", last, EventOrderPaymentMismatch)
+    }
+
+    var logged map[string]interface{}
+    for _, line := range logLines(t, logs) {
+        if line["msg"] == "payment approved for a different amount" {
+            logged = line
+        }
+    }
+    if logged == nil || logged["requested_amount"] != order.TotalAmount.String() || logged["approved_amount"] != "59.97" {
+        t.Fatalf("mismatch not logged with both amounts: %v", logged)
+    }
+}
+
+func TestPaymentAmountTolerance(t *testing.T) {
+    fake := newPaymentServer(t)
+    approved := decimal.RequireFromString("59.97")
+    fake.amount = &approved
+    resetOrders(t)
+    prev := paymentAmountTolerance
+    paymentAmountTolerance = decimal.RequireFromString("0.01")
+    t.Cleanup(func() { paymentAmountTolerance = prev })
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", sampleOrder)
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if order.Status != StatusConfirmed {
+        t.Fatalf("order %q, want %q within the tolerance", order.Status, StatusConfirmed)
+    }
+}
+
+func TestPaymentWebhookAmountMismatch(t *testing.T) {
+    useWebhookSecret(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    order := saveOrderWithStatus(StatusPending)
+    wrong := order.TotalAmount.Add(decimal.RequireFromString("0.01"))
+    raw, _ := json.Marshal(PaymentResponse{PaymentID: uuid.New(), OrderID: order.OrderID, Status: "approved", Amount: &wrong, ProcessedAt: time.Now()})
+    body := string(raw)
+
+    code, resp := postWebhook(r, body, signWebhook([]byte(testWebhookSecret), raw))
+    if code != http.StatusOK || resp["status"] != StatusPaymentMismatch {
+        t.Fatalf("got %d %v", code, resp)
+    }
+}
+
+func TestAdminResolvesPaymentMismatch(t *testing.T) {
+    for _, to := range []string{StatusConfirmed, StatusCancelled} {
+        if !canTransition(StatusPaymentMismatch, to, true) {
+            t.Errorf("admin cannot move a mismatched order to %s", to)
+        }
+        if canTransition(StatusPaymentMismatch, to, false) {
+            t.Errorf("a mismatched order moves to %s without an admin", to)
+        }
+    }
+}
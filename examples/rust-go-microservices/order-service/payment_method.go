@@ -0,0 +1,132 @@
+package main
+
+import "time"
+
+const (
+    PaymentMethodCard         = "card"
+    PaymentMethodWallet       = "wallet"
+    PaymentMethodBankTransfer = "bank_transfer"
+)
+
+// PaymentMethod is the instrument an order is paid with. Exactly the
+// details matching Type may be set; orders without a payment method are
+// paid by card, as before payment methods could be chosen.
+type PaymentMethod struct {
+    Type         string               `json:"type"`
+    Card         *CardDetails         `json:"card,omitempty"`
+    Wallet       *WalletDetails       `json:"wallet,omitempty"`
+    BankTransfer *BankTransferDetails `json:"bank_transfer,omitempty"`
+}
+
+// CardDetails identifies a card without its number; the payment service
+// holds the card itself.
+type CardDetails struct {
+    Brand    string `json:"brand"`
+    Last4    string `json:"last4"`
+    ExpMonth int    `json:"exp_month"`
+    ExpYear  int    `json:"exp_year"`
+}
+
+type WalletDetails struct {
+    Provider string `json:"provider"`
+}
+
+type BankTransferDetails struct {
+    AccountHolder string `json:"account_holder"`
+    AccountLast4  string `json:"account_last4"`
+}
+
+var (
+    paymentMethodTypes = map[string]bool{PaymentMethodCard: true, PaymentMethodWallet: true, PaymentMethodBankTransfer: true}
+    cardBrands         = map[string]bool{"visa": true, "mastercard": true, "amex": true, "discover": true}
+    walletProviders    = map[string]bool{"apple_pay": true, "google_pay": true, "paypal": true}
+)
+
+// methodType is the payment method type to charge with.
+func (m *PaymentMethod) methodType() string {
+    if m == nil {
+        return PaymentMethodCard
+    }
+    return m.Type
+}
+
+// validatePaymentMethod adds m's problems to verr, reporting them under
+// field. now is used to reject expired cards.
+func validatePaymentMethod(verr *ValidationError, field string, m *PaymentMethod, now time.Time) {
+    if m == nil {
+        return
+    }
+
+    switch m.Type {
+    case PaymentMethodCard:
+        if m.Card == nil {
+            verr.add(field+".card", "is required for card payments")
+        } else {
+            validateCard(verr, field+".card", m.Card, now)
+        }
+    case PaymentMethodWallet:
+        if m.Wallet == nil {
+            verr.add(field+".wallet", "is required for wallet payments")
+        } else if !walletProviders[m.Wallet.Provider] {
+            verr.add(field+".wallet.provider", "%q is not a supported wallet", m.Wallet.Provider)
+        }
+    case PaymentMethodBankTransfer:
+        if m.BankTransfer == nil {
+            verr.add(field+".bank_transfer", "is required for bank transfers")
+        } else {
+            if m.BankTransfer.AccountHolder == "" {
+                verr.add(field+".bank_transfer.account_holder", "must not be empty")
+            }
+            if !isLast4(m.BankTransfer.AccountLast4) {
+                verr.add(field+".bank_transfer.account_last4", "must be 4 digits")
+            }
+        }
+    default:
+        verr.add(field+".type", "%q is not a supported payment method", m.Type)
+        return
+    }
+
+    // Details for another type are a client mistake, not something to
+    // silently ignore.
+    for _, details := range []struct {
+        field string
+        set   bool
+    }{
+        {PaymentMethodCard, m.Card != nil},
+        {PaymentMethodWallet, m.Wallet != nil},
+        {PaymentMethodBankTransfer, m.BankTransfer != nil},
+    } {
+        if details.set && details.field != m.Type {
+            verr.add(field+"."+details.field, "must not be set for %s payments", m.Type)
+        }
+    }
+}
+
+func validateCard(verr *ValidationError, field string, card *CardDetails, now time.Time) {
+    if !cardBrands[card.Brand] {
+        verr.add(field+".brand", "%q is not a supported card brand", card.Brand)
+    }
+    if !isLast4(card.Last4) {
+        verr.add(field+".last4", "must be 4 digits")
+    }
+    if card.ExpMonth < 1 || card.ExpMonth > 12 {
+        verr.add(field+".exp_month", "must be between 1 and 12")
+        return
+    }
+    // A card is valid through the last day of its expiry month.
+    if expires := time.Date(card.ExpYear, time.Month(card.ExpMonth)+1, 1, 0, 0, 0, 0, time.UTC); !now.Before(expires) {
+        verr.add(field, "expired in %02d/%d", card.ExpMonth, card.ExpYear)
+    }
+}
+
+func isLast4(s string) bool {
+    if len(s) != 4 {
+        return false
+    }
+    for _, r := range s {
+        if r < '0' || r > '9' {
+            return false
+        }
+    }
+    return true
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+    "net/http"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+func TestCreateOrderPaymentMethods(t *testing.T) {
+    tests := []struct {
+        name      string
+        method    string
+        wantCode  int
+        wantType  string
+        wantField string
+    }{
+        {"absent defaults to card", "", http.StatusCreated, PaymentMethodCard, ""},
+        {"card", `{"type":"card","card":{"brand":"visa","last4":"4242","exp_month":12,"exp_year":2099}}`, http.StatusCreated, PaymentMethodCard, ""},
+        {"wallet", `{"type":"wallet","wallet":{"provider":"apple_pay"}}`, http.StatusCreated, PaymentMethodWallet, ""},
+        {"bank transfer", `{"type":"bank_transfer","bank_transfer":{"account_holder":"Ada Lovelace","account_last4":"0042"}}`, http.StatusCreated, PaymentMethodBankTransfer, ""},
+        {"unknown type", `{"type":"crypto"}`, http.StatusUnprocessableEntity, "", "payment_method.type"},
+        {"card without details", `{"type":"card"}`, http.StatusUnprocessableEntity, "", "payment_method.card"},
+        {"expired card", `{"type":"card","card":{"brand":"visa","last4":"4242","exp_month":1,"exp_year":2000}}`, http.StatusUnprocessableEntity, "", "payment_method.card"},
+        {"bad last4", `{"type":"card","card":{"brand":"visa","last4":"42","exp_month":12,"exp_year":2099}}`, http.StatusUnprocessableEntity, "", "payment_method.card.last4"},
+        {"unknown wallet", `{"type":"wallet","wallet":{"provider":"venmo"}}`, http.StatusUnprocessableEntity, "", "payment_method.wallet.provider"},
+        {"bank transfer without holder", `{"type":"bank_transfer","bank_transfer":{"account_last4":"0042"}}`, http.StatusUnprocessableEntity, "", "payment_method.bank_transfer.account_holder"},
+        {"mismatched details", `{"type":"wallet","wallet":{"provider":"paypal"},"card":{"brand":"visa","last4":"4242","exp_month":12,"exp_year":2099}}`, http.StatusUnprocessableEntity, "", "payment_method.card"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fake := newPaymentServer(t)
+            resetOrders(t)
+            r := setupRouter()
+
+            body := sampleOrder
+            if tt.method != "" {
+                body = body[:len(body)-1] + `,"payment_method":` + tt.method + `}`
+            }
+            w := doRequest(r, http.MethodPost, "/orders", body)
+            if w.Code != tt.wantCode {
+                t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+            }
+
+            if tt.wantCode != http.StatusCreated {
+                fields := decodeValidationError(w).Fields
+                if len(fields) != 1 || fields[0].Field != tt.wantField {
+                    t.Fatalf("got fields %+v, want %s", fields, tt.wantField)
+                }
+                if n := fake.charges.Load(); n != 0 {
+                    t.Fatalf("invalid payment method charged %d times", n)
+                }
+                return
+            }
+            charge := fake.lastCharge.Load()
+            if charge.PaymentMethod != tt.wantType {
+                t.Fatalf("charged with %q, want %q", charge.PaymentMethod, tt.wantType)
+            }
+            if tt.method != "" && (charge.Details == nil || charge.Details.Type != tt.wantType) {
+                t.Fatalf("payment method details not forwarded: %+v", charge.Details)
+            }
+        })
+    }
+}
+
+func TestCardExpiresAfterItsMonth(t *testing.T) {
+    card := &PaymentMethod{Type: PaymentMethodCard, Card: &CardDetails{Brand: "visa", Last4: "4242", ExpMonth: 3, ExpYear: 2024}}
+
+    verr := &ValidationError{}
+    validatePaymentMethod(verr, "payment_method", card, time.Date(2024, 3, 31, 23, 0, 0, 0, time.UTC))
+    if verr.err() != nil {
+        t.Fatalf("card rejected in its expiry month: %v", verr)
+    }
+    validatePaymentMethod(verr, "payment_method", card, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC))
+    if verr.err() == nil {
+        t.Fatal("card accepted after its expiry month")
+    }
+}
+
+func TestSQLiteRepositoryRoundTripsPaymentMethod(t *testing.T) {
+    repo := openTestSQLite(t, filepath.Join(t.TempDir(), "orders.db"))
+    order := &Order{
+        OrderID:       uuid.New(),
+        CustomerID:    "c",
+        Currency:      "USD",
+        PaymentMethod: &PaymentMethod{Type: PaymentMethodWallet, Wallet: &WalletDetails{Provider: "paypal"}},
+        Status:        StatusConfirmed,
+        CreatedAt:     time.Now(),
+    }
+    if err := repo.Save(order); err != nil {
+        t.Fatal(err)
+    }
+    got, err := repo.FindByID(order.OrderID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got.PaymentMethod == nil || got.PaymentMethod.Wallet == nil || got.PaymentMethod.Wallet.Provider != "paypal" {
+        t.Fatalf("payment method not round-tripped: %+v", got.PaymentMethod)
+    }
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// newTestPaymentClient returns a client for baseURL whose backoff sleeps are
+// recorded instead of waited out.
+func newTestPaymentClient(baseURL string, delays *[]time.Duration) *PaymentClient {
+    p := NewPaymentClient(baseURL)
+    p.sleep = func(ctx context.Context, d time.Duration) error {
+        *delays = append(*delays, d)
+        return ctx.Err()
+    }
+    return p
+}
+
+func TestProcessPaymentRetriesThenSucceeds(t *testing.T) {
+    var calls atomic.Int64
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if calls.Add(1) <= 2 {
+            http.Error(w, "unavailable", http.StatusServiceUnavailable)
+            return
+        }
+        json.NewEncoder(w).Encode(PaymentResponse{Status: "approved"})
+    }))
+    defer srv.Close()
+
+    var delays []time.Duration
+    p := newTestPaymentClient(srv.URL, &delays)
+
+    resp, err := p.processPayment(context.Background(), PaymentRequest{OrderID: uuid.New()})
+    if err != nil {
+        t.Fatalf("processPayment: %v", err)
+    }
+    if resp.Status != "approved" {
+        t.Fatalf("got status %q", resp.Status)
+    }
+    if n := calls.Load(); n != 3 {
+        t.Fatalf("got %d attempts, want 3", n)
+    }
+    if len(delays) != 2 {
+        t.Fatalf("got %d backoff sleeps, want 2", len(delays))
+    }
+    for i, d := range delays {
+        max := p.BaseDelay << i
+        if d < max/2 || d >= max {
+            t.Errorf("delay %d = %v, want in [%v, %v)", i, d, max/2, max)
+        }
+    }
+}
+
+// TestPaymentKeyReusedAcrossRetries has the payment service fail each
+// order's first charge attempt, as a lost response would look, and checks
+// the retry is sent under the same key while other orders get their own.
+func TestPaymentKeyReusedAcrossRetries(t *testing.T) {
+    var (
+        mu   sync.Mutex
+        keys = make(map[uuid.UUID][]string)
+    )
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var req PaymentRequest
+        json.NewDecoder(r.Body).Decode(&req)
+        mu.Lock()
+        keys[req.OrderID] = append(keys[req.OrderID], req.IdempotencyKey)
+        first := len(keys[req.OrderID]) == 1
+        mu.Unlock()
+        if first {
+            http.Error(w, "unavailable", http.StatusServiceUnavailable)
+            return
+        }
+        json.NewEncoder(w).Encode(PaymentResponse{OrderID: req.OrderID, Status: "approved", ProcessedAt: time.Now()})
+    }))
+    defer srv.Close()
+    var delays []time.Duration
+    prev := payments
+    payments = newTestPaymentClient(srv.URL, &delays)
+    t.Cleanup(func() { payments = prev })
+    resetOrders(t)
+    r := setupRouter()
+
+    seen := make(map[string]bool)
+    for i := 0; i < 2; i++ {
+        w := doRequest(r, http.MethodPost, "/orders", sampleOrder)
+        if w.Code != http.StatusCreated {
+            t.Fatalf("got status %d: %s", w.Code, w.Body)
+        }
+        var order Order
+        json.Unmarshal(w.Body.Bytes(), &order)
+        sent := keys[order.OrderID]
+        if len(sent) != 2 || sent[0] == "" || sent[0] != sent[1] {
+            t.Fatalf("order %d: got keys %q, want one key sent twice", i, sent)
+        }
+        if seen[sent[0]] {
+            t.Fatalf("order %d reused key %s of an earlier order", i, sent[0])
+        }
+        seen[sent[0]] = true
+
+        stored, err := orders.FindByID(order.OrderID)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if stored.PaymentKey != sent[0] {
+            t.Fatalf("stored key %q, sent %q", stored.PaymentKey, sent[0])
+        }
+    }
+}
+
+func TestProcessPaymentDoesNotRetry4xx(t *testing.T) {
+    var calls atomic.Int64
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        calls.Add(1)
+        http.Error(w, "bad request", http.StatusBadRequest)
+    }))
+    defer srv.Close()
+
+    var delays []time.Duration
+    p := newTestPaymentClient(srv.URL, &delays)
+
+    if _, err := p.processPayment(context.Background(), PaymentRequest{}); err == nil {
+        t.Fatal("expected error for 4xx response")
+    }
+    if n := calls.Load(); n != 1 {
+        t.Fatalf("got %d attempts, want 1", n)
+    }
+}
+
+func TestProcessPaymentGivesUpAfterMaxRetries(t *testing.T) {
+    srv := httptest.NewServer(http.NotFoundHandler())
+    url := srv.URL
+    srv.Close() // connection refused from here on
+
+    var delays []time.Duration
+    p := newTestPaymentClient(url, &delays)
+
+    if _, err := p.processPayment(context.Background(), PaymentRequest{}); err == nil {
+        t.Fatal("expected error from unreachable payment service")
+    }
+    if len(delays) != p.MaxRetries {
+        t.Fatalf("got %d retries, want %d", len(delays), p.MaxRetries)
+    }
+}
+
+func TestProcessPaymentTotalTimeBounded(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        http.Error(w, "unavailable", http.StatusInternalServerError)
+    }))
+    defer srv.Close()
+
+    p := NewPaymentClient(srv.URL)
+    p.BaseDelay = time.Hour
+    p.MaxElapsed = 50 * time.Millisecond
+
+    start := time.Now()
+    if _, err := p.processPayment(context.Background(), PaymentRequest{}); err == nil {
+        t.Fatal("expected error")
+    }
+    if elapsed := time.Since(start); elapsed > time.Second {
+        t.Fatalf("call took %v despite 50ms bound", elapsed)
+    }
+}
+
+func TestProcessPaymentCancelledMidFlight(t *testing.T) {
+    release := make(chan struct{})
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        <-release
+    }))
+    defer srv.Close()
+    defer close(release)
+
+    p := NewPaymentClient(srv.URL)
+    ctx, cancel := context.WithCancel(context.Background())
+    time.AfterFunc(50*time.Millisecond, cancel)
+
+    start := time.Now()
+    _, err := p.processPayment(ctx, PaymentRequest{})
+    if !errors.Is(err, context.Canceled) {
+        t.Fatalf("got %v, want context.Canceled", err)
+    }
+    if elapsed := time.Since(start); elapsed > time.Second {
+        t.Fatalf("cancelled call took %v to return", elapsed)
+    }
+    if got := p.Breaker.State(); got != BreakerClosed {
+        t.Fatalf("caller cancellation changed breaker state to %q", got)
+    }
+}
+
+func TestPaymentClientFromEnvUsesConfiguredURL(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/process" {
+            t.Errorf("got path %q, want /process", r.URL.Path)
+        }
+        json.NewEncoder(w).Encode(PaymentResponse{Status: "approved"})
+    }))
+    defer srv.Close()
+    t.Setenv("PAYMENT_SERVICE_URL", srv.URL+"/")
+
+    p, err := newPaymentClientFromEnv()
+    if err != nil {
+        t.Fatalf("newPaymentClientFromEnv: %v", err)
+    }
+    resp, err := p.processPayment(context.Background(), PaymentRequest{})
+    if err != nil || resp.Status != "approved" {
+        t.Fatalf("processPayment = %+v, %v", resp, err)
+    }
+}
+
+func TestPaymentClientFromEnvRejectsMalformedURL(t *testing.T) {
+    for _, raw := range []string{"localhost:8001", "ftp://payments", "http://", "://bad"} {
+        t.Setenv("PAYMENT_SERVICE_URL", raw)
+        if _, err := newPaymentClientFromEnv(); err == nil {
+            t.Errorf("%q: expected error", raw)
+        }
+    }
+}
+
+// approvingServer answers every charge with "approved" and counts the
+// connections opened to it.
+func TestProcessPaymentErrorStatusQuotesBody(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/html")
+        w.WriteHeader(http.StatusInternalServerError)
+        w.Write([]byte("<html><body>Internal Server Error</body></html>" + strings.Repeat(" padding", 100)))
+    }))
+    defer srv.Close()
+
+    var delays []time.Duration
+    p := newTestPaymentClient(srv.URL, &delays)
+    p.MaxRetries = 0
+
+    resp, err := p.processPayment(context.Background(), PaymentRequest{})
+    var status *statusError
+    if resp != nil || !errors.As(err, &status) || status.code != http.StatusInternalServerError {
+        t.Fatalf("got %v, %v; want a status 500 error", resp, err)
+    }
+    if !strings.Contains(err.Error(), "Internal Server Error") || !strings.HasSuffix(status.body, "...") {
+        t.Fatalf("error %q should quote the start of the body, truncated", err)
+    }
+    if len(status.body) > paymentBodySnippetLen+len("...") {
+        t.Fatalf("body snippet is %d bytes long", len(status.body))
+    }
+    var malformed *malformedResponseError
+    if errors.As(err, &malformed) {
+        t.Fatal("an error status was reported as a malformed response")
+    }
+}
+
+func TestProcessPaymentMalformedResponse(t *testing.T) {
+    for name, body := range map[string]string{
+        "truncated JSON": `{"status":"appro`,
+        "HTML":           "<html>Gateway</html>",
+        "empty":          "",
+        "no status":      `{}`,
+    } {
+        t.Run(name, func(t *testing.T) {
+            srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                w.Write([]byte(body))
+            }))
+            defer srv.Close()
+
+            var delays []time.Duration
+            p := newTestPaymentClient(srv.URL, &delays)
+            resp, err := p.processPayment(context.Background(), PaymentRequest{})
+            var malformed *malformedResponseError
+            if resp != nil || !errors.As(err, &malformed) {
+                t.Fatalf("got %v, %v; want a malformed response error", resp, err)
+            }
+            if len(delays) != 0 {
+                t.Fatalf("malformed response was retried %d times", len(delays))
+            }
+            if got := p.Breaker.State(); got != BreakerClosed {
+                t.Fatalf("breaker %s after the service answered", got)
+            }
+        })
+    }
+}
+
+func TestProcessPaymentValidResponse(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(`{"payment_id":"` + uuid.NewString() + `","status":"approved","amount":"59.98"}`))
+    }))
+    defer srv.Close()
+
+    var delays []time.Duration
+    resp, err := newTestPaymentClient(srv.URL, &delays).processPayment(context.Background(), PaymentRequest{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if resp.Status != "approved" || resp.Amount == nil || resp.Amount.String() != "59.98" {
+        t.Fatalf("got %+v", resp)
+    }
+}
+
+func approvingServer(tb testing.TB) (*httptest.Server, *atomic.Int64) {
+    tb.Helper()
+
+    var conns atomic.Int64
+    srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        json.NewEncoder(w).Encode(PaymentResponse{Status: "approved"})
+    }))
+    srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+        if state == http.StateNew {
+            conns.Add(1)
+        }
+    }
+    srv.Start()
+    tb.Cleanup(srv.Close)
+    return srv, &conns
+}
+
+func TestPaymentClientReusesConnections(t *testing.T) {
+    srv, conns := approvingServer(t)
+    p := NewPaymentClient(srv.URL)
+
+    var wg sync.WaitGroup
+    for round := 0; round < 5; round++ {
+        for i := 0; i < 8; i++ {
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                if _, err := p.processPayment(context.Background(), PaymentRequest{}); err != nil {
+                    t.Error(err)
+                }
+            }()
+        }
+        wg.Wait()
+    }
+
+    if got := conns.Load(); got > 8 {
+        t.Fatalf("40 payments in rounds of 8 opened %d connections, want at most 8", got)
+    }
+}
+
+func TestPaymentConnPoolFromEnv(t *testing.T) {
+    t.Setenv("PAYMENT_MAX_IDLE_CONNS", "10")
+    t.Setenv("PAYMENT_MAX_IDLE_CONNS_PER_HOST", "5")
+    t.Setenv("PAYMENT_IDLE_CONN_TIMEOUT", "30s")
+
+    p, err := newPaymentClientFromEnv()
+    if err != nil {
+        t.Fatal(err)
+    }
+    tr := p.HTTPClient.Transport.(*http.Transport)
+    if tr.MaxIdleConns != 10 || tr.MaxIdleConnsPerHost != 5 || tr.IdleConnTimeout != 30*time.Second {
+        t.Fatalf("got pool %d/%d/%s", tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tr.IdleConnTimeout)
+    }
+    if p.AttemptTimeout != paymentAttemptTimeout {
+        t.Fatalf("got attempt timeout %s", p.AttemptTimeout)
+    }
+}
+
+// BenchmarkPaymentSharedClient and BenchmarkPaymentPerCallClient compare
+// the shared, pooled client with building a client for every call, which
+// pays for a new connection each time. Run with -benchmem.
+func BenchmarkPaymentSharedClient(b *testing.B) {
+    srv, _ := approvingServer(b)
+    p := NewPaymentClient(srv.URL)
+
+    b.ReportAllocs()
+    b.RunParallel(func(pb *testing.PB) {
+        for pb.Next() {
+            if _, err := p.processPayment(context.Background(), PaymentRequest{}); err != nil {
+                b.Fatal(err)
+            }
+        }
+    })
+}
+
+func BenchmarkPaymentPerCallClient(b *testing.B) {
+    srv, _ := approvingServer(b)
+    p := NewPaymentClient(srv.URL)
+
+    b.ReportAllocs()
+    b.RunParallel(func(pb *testing.PB) {
+        for pb.Next() {
+            tr := newPooledTransport(defaultPaymentConnPool)
+            client := *p
+            client.HTTPClient = &http.Client{Transport: tr}
+            if _, err := client.processPayment(context.Background(), PaymentRequest{}); err != nil {
+                b.Fatal(err)
+            }
+            tr.CloseIdleConnections()
+        }
+    })
+}
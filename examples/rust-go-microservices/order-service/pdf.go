@@ -0,0 +1,92 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "strings"
+)
+
+// Page layout for renderTextPDF, in points: US Letter with a one-inch
+// margin, 10pt Courier on a 12pt leading.
+const (
+    pdfPageWidth    = 612
+    pdfPageHeight   = 792
+    pdfMargin       = 72
+    pdfFontSize     = 10
+    pdfLeading      = 12
+    pdfLinesPerPage = (pdfPageHeight - 2*pdfMargin) / pdfLeading
+)
+
+// renderTextPDF lays lines of plain text out as a PDF document, as many
+// pages as it takes. It writes only what a receipt needs, text in one of
+// the standard fonts, so the service doesn't depend on a PDF library; the
+// fixed-width font keeps columns lined up with spaces.
+func renderTextPDF(lines []string) []byte {
+    var pages [][]string
+    for len(lines) > pdfLinesPerPage {
+        pages = append(pages, lines[:pdfLinesPerPage])
+        lines = lines[pdfLinesPerPage:]
+    }
+    pages = append(pages, lines)
+
+    // Objects 1 to 3 are the catalog, the page tree and the font; each page
+    // is then a page object followed by its content stream.
+    var objects []string
+    kids := make([]string, len(pages))
+    for i := range pages {
+        kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+    }
+    objects = append(objects,
+        "<< /Type /Catalog /Pages 2 0 R >>",
+        fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)),
+        "<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+    )
+    for i, page := range pages {
+        var content bytes.Buffer
+        fmt.Fprintf(&content, "BT /F1 %d Tf %d TL %d %d Td\n", pdfFontSize, pdfLeading, pdfMargin, pdfPageHeight-pdfMargin)
+        for _, line := range page {
+            fmt.Fprintf(&content, "(%s) '\n", pdfString(line))
+        }
+        content.WriteString("ET")
+        objects = append(objects,
+            fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+                pdfPageWidth, pdfPageHeight, 5+2*i),
+            fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.Bytes()),
+        )
+    }
+
+    var doc bytes.Buffer
+    doc.WriteString("%PDF-1.4\n")
+    offsets := make([]int, len(objects))
+    for i, obj := range objects {
+        offsets[i] = doc.Len()
+        fmt.Fprintf(&doc, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+    }
+    xref := doc.Len()
+    fmt.Fprintf(&doc, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+    for _, off := range offsets {
+        fmt.Fprintf(&doc, "%010d 00000 n \n", off)
+    }
+    fmt.Fprintf(&doc, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+    return doc.Bytes()
+}
+
+// pdfString escapes s for a PDF literal string. The standard fonts only
+// cover Latin-1, so anything outside it is replaced with '?'.
+func pdfString(s string) string {
+    var b strings.Builder
+    for _, r := range s {
+        switch {
+        case r == '\\' || r == '(' || r == ')':
+            b.WriteByte('\\')
+            b.WriteRune(r)
+        case r < 0x20 || r > 0xff:
+            b.WriteByte('?')
+        case r < 0x80:
+            b.WriteRune(r)
+        default:
+            fmt.Fprintf(&b, "\\%03o", r)
+        }
+    }
+    return b.String()
+}
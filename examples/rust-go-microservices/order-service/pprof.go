@@ -0,0 +1,48 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "net"
+    "net/http"
+    "net/http/pprof"
+)
+
+// pprofEnabled turns on the net/http/pprof handlers under /debug/pprof.
+// They are mounted on the public router, open only to unrestricted API
+// keys, unless pprofAddr names a listener of their own.
+var (
+    pprofEnabled bool
+    pprofAddr    string
+)
+
+// pprofHandler serves the standard profiling endpoints. It uses its own mux
+// rather than http.DefaultServeMux, which net/http/pprof also registers on.
+func pprofHandler() http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/debug/pprof/", pprof.Index)
+    mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+    mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+    mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+    mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+    return mux
+}
+
+// pprofServer serves pprofHandler on a private listener until its context
+// is done. It has no write timeout, so CPU profiles and traces may run as
+// long as asked; on the public listener they are capped by
+// SERVER_WRITE_TIMEOUT.
+type pprofServer struct {
+    ln net.Listener
+}
+
+func (s *pprofServer) Run(ctx context.Context) {
+    srv := &http.Server{Handler: pprofHandler(), ReadHeaderTimeout: defaultReadHeaderTimeout}
+    go func() {
+        <-ctx.Done()
+        srv.Close()
+    }()
+    if err := srv.Serve(s.ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+        logger.Error("pprof server failed", "addr", s.ln.Addr().String(), "error", err)
+    }
+}
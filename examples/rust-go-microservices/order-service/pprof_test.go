@@ -0,0 +1,89 @@
+package main
+
+import (
+    "context"
+    "net"
+    "net/http"
+    "testing"
+)
+
+func usePprof(t *testing.T, enabled bool, addr string) {
+    t.Helper()
+
+    prevEnabled, prevAddr := pprofEnabled, pprofAddr
+    pprofEnabled, pprofAddr = enabled, addr
+    t.Cleanup(func() { pprofEnabled, pprofAddr = prevEnabled, prevAddr })
+}
+
+func TestPprofDisabledByDefault(t *testing.T) {
+    usePprof(t, false, "")
+    r := setupRouter()
+
+    for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/pprof/heap"} {
+        if w := doRequest(r, http.MethodGet, path, ""); w.Code != http.StatusNotFound {
+            t.Errorf("GET %s: got status %d, want 404", path, w.Code)
+        }
+    }
+}
+
+func TestPprofEnabled(t *testing.T) {
+    usePprof(t, true, "")
+    r := setupRouter()
+
+    for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/pprof/heap"} {
+        if w := doRequest(r, http.MethodGet, path, ""); w.Code != http.StatusOK {
+            t.Errorf("GET %s: got status %d, want 200", path, w.Code)
+        }
+    }
+}
+
+func TestPprofRequiresAPIKey(t *testing.T) {
+    usePprof(t, true, "")
+    useAPIKeys(t, "admin-key,cust-key:cust_123")
+    r := setupRouter()
+
+    for _, tt := range []struct {
+        headers map[string]string
+        want    int
+    }{
+        {nil, http.StatusUnauthorized},
+        {bearer("cust-key"), http.StatusForbidden},
+        {bearer("admin-key"), http.StatusOK},
+    } {
+        if w := doRequestWithHeaders(r, http.MethodGet, "/debug/pprof/", "", tt.headers); w.Code != tt.want {
+            t.Errorf("%v: got status %d, want %d", tt.headers, w.Code, tt.want)
+        }
+    }
+}
+
+func TestPprofOnSeparateListener(t *testing.T) {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    usePprof(t, true, ln.Addr().String())
+
+    if w := doRequest(setupRouter(), http.MethodGet, "/debug/pprof/", ""); w.Code != http.StatusNotFound {
+        t.Fatalf("public router: got status %d, want 404", w.Code)
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    go func() {
+        (&pprofServer{ln: ln}).Run(ctx)
+        close(done)
+    }()
+    defer func() {
+        cancel()
+        <-done
+    }()
+
+    resp, err := http.Get("http://" + ln.Addr().String() + "/debug/pprof/cmdline")
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        t.Fatalf("pprof listener: got status %d, want 200", resp.StatusCode)
+    }
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "strings"
+
+    "github.com/shopspring/decimal"
+)
+
+// TaxCalculator works out the tax due on an order's subtotal for the place
+// it ships to.
+type TaxCalculator interface {
+    Tax(destination string, subtotal decimal.Decimal) decimal.Decimal
+}
+
+// TaxRates taxes a subtotal at a fixed rate per destination, or at Default
+// for destinations without one. Rates are fractions: 0.08 is 8%.
+type TaxRates struct {
+    Default       decimal.Decimal
+    ByDestination map[string]decimal.Decimal
+}
+
+func (r TaxRates) Tax(destination string, subtotal decimal.Decimal) decimal.Decimal {
+    rate, ok := r.ByDestination[normalizeDestination(destination)]
+    if !ok {
+        rate = r.Default
+    }
+    return subtotal.Mul(rate)
+}
+
+// taxes prices the tax of every order. The zero TaxRates charges none.
+var taxes TaxCalculator = TaxRates{}
+
+// ShippingRates charges a flat fee per order, waived once the subtotal
+// reaches FreeOver. A zero FreeOver never waives it. Both are in the order's
+// own currency.
+type ShippingRates struct {
+    Flat     decimal.Decimal
+    FreeOver decimal.Decimal
+}
+
+func (s ShippingRates) cost(subtotal decimal.Decimal) decimal.Decimal {
+    if s.FreeOver.IsPositive() && subtotal.GreaterThanOrEqual(s.FreeOver) {
+        return decimal.Zero
+    }
+    return s.Flat
+}
+
+// shippingRates prices the shipping of every order. The zero value ships
+// for free.
+var shippingRates ShippingRates
+
+// priceOrder fills in the order's subtotal, tax, shipping and total. Each
+// part is rounded to the currency's minor units, so the total is exactly
+// their sum. Tax is for the shipping address's country, if there is one.
+func priceOrder(order *Order) {
+    if order.ShippingAddress != nil {
+        order.Destination = order.ShippingAddress.Country
+    }
+    order.Destination = normalizeDestination(order.Destination)
+    places := minorUnits(order.Currency)
+    order.Subtotal = calculateTotal(order)
+    order.Tax = roundingMode.round(taxes.Tax(order.Destination, order.Subtotal), places)
+    order.Shipping = roundingMode.round(shippingRates.cost(order.Subtotal), places)
+    order.TotalAmount = order.Subtotal.Add(order.Tax).Add(order.Shipping)
+}
+
+func normalizeDestination(destination string) string {
+    return strings.ToUpper(strings.TrimSpace(destination))
+}
+
+// taxRatesFromEnv reads TAX_RATE, the default rate, and TAX_RATES, a list
+// of per-destination overrides such as "US:0.07,DE:0.19".
+func taxRatesFromEnv() (TaxRates, error) {
+    rates := TaxRates{ByDestination: map[string]decimal.Decimal{}}
+    var err error
+    if rates.Default, err = envDecimal("TAX_RATE"); err != nil {
+        return TaxRates{}, err
+    }
+    for _, entry := range strings.Split(os.Getenv("TAX_RATES"), ",") {
+        if entry = strings.TrimSpace(entry); entry == "" {
+            continue
+        }
+        destination, raw, ok := strings.Cut(entry, ":")
+        rate, err := decimal.NewFromString(strings.TrimSpace(raw))
+        if !ok || strings.TrimSpace(destination) == "" || err != nil || rate.IsNegative() {
+            return TaxRates{}, fmt.Errorf("TAX_RATES: want destination:rate, got %q", entry)
+        }
+        rates.ByDestination[normalizeDestination(destination)] = rate
+    }
+    return rates, nil
+}
+
+// shippingRatesFromEnv reads SHIPPING_FLAT_RATE and FREE_SHIPPING_THRESHOLD.
+func shippingRatesFromEnv() (ShippingRates, error) {
+    var (
+        rates ShippingRates
+        err   error
+    )
+    if rates.Flat, err = envDecimal("SHIPPING_FLAT_RATE"); err != nil {
+        return ShippingRates{}, err
+    }
+    if rates.FreeOver, err = envDecimal("FREE_SHIPPING_THRESHOLD"); err != nil {
+        return ShippingRates{}, err
+    }
+    return rates, nil
+}
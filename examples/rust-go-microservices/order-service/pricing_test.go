@@ -0,0 +1,111 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+
+    "github.com/shopspring/decimal"
+)
+
+func usePricing(t *testing.T, tax TaxCalculator, shipping ShippingRates) {
+    t.Helper()
+
+    prevTaxes, prevShipping := taxes, shippingRates
+    taxes, shippingRates = tax, shipping
+    t.Cleanup(func() { taxes, shippingRates = prevTaxes, prevShipping })
+}
+
+func TestPriceOrder(t *testing.T) {
+    d := decimal.RequireFromString
+    tests := []struct {
+        name        string
+        tax         TaxCalculator
+        shipping    ShippingRates
+        destination string
+        price       string
+        wantTax     string
+        wantShip    string
+        wantTotal   string
+    }{
+        {"zero tax", TaxRates{}, ShippingRates{}, "US", "29.99", "0", "0", "59.98"},
+        {"flat rate", TaxRates{Default: d("0.08")}, ShippingRates{}, "US", "29.99", "4.80", "0", "64.78"},
+        {"destination rate", TaxRates{Default: d("0.08"), ByDestination: map[string]decimal.Decimal{"DE": d("0.19")}}, ShippingRates{}, " de ", "50", "19", "0", "119"},
+        {"shipping below threshold", TaxRates{}, ShippingRates{Flat: d("4.99"), FreeOver: d("100")}, "", "49.99", "0", "4.99", "104.97"},
+        {"free shipping at threshold", TaxRates{}, ShippingRates{Flat: d("4.99"), FreeOver: d("100")}, "", "50", "0", "0", "100"},
+        {"no threshold", TaxRates{}, ShippingRates{Flat: d("4.99")}, "", "500", "0", "4.99", "1004.99"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            usePricing(t, tt.tax, tt.shipping)
+            order := &Order{
+                Currency:    "USD",
+                Destination: tt.destination,
+                Items:       []OrderItem{{ProductID: "p", Quantity: 2, Price: d(tt.price)}},
+            }
+            priceOrder(order)
+
+            if !order.Tax.Equal(d(tt.wantTax)) || !order.Shipping.Equal(d(tt.wantShip)) || !order.TotalAmount.Equal(d(tt.wantTotal)) {
+                t.Fatalf("got tax %s, shipping %s, total %s; want %s, %s, %s",
+                    order.Tax, order.Shipping, order.TotalAmount, tt.wantTax, tt.wantShip, tt.wantTotal)
+            }
+            if !order.Subtotal.Add(order.Tax).Add(order.Shipping).Equal(order.TotalAmount) {
+                t.Fatalf("subtotal %s + tax %s + shipping %s != total %s", order.Subtotal, order.Tax, order.Shipping, order.TotalAmount)
+            }
+        })
+    }
+}
+
+func TestTaxIsRoundedToCurrency(t *testing.T) {
+    usePricing(t, TaxRates{Default: decimal.RequireFromString("0.1")}, ShippingRates{})
+    order := &Order{Currency: "JPY", Items: []OrderItem{{ProductID: "p", Quantity: 1, Price: decimal.RequireFromString("1234")}}}
+    priceOrder(order)
+
+    if got := order.Tax.String(); got != "123" {
+        t.Fatalf("got tax %s, want 123", got)
+    }
+}
+
+func TestPaymentChargesGrandTotal(t *testing.T) {
+    resetOrders(t)
+    fake := newPaymentServer(t)
+    usePricing(t, TaxRates{Default: decimal.RequireFromString("0.1")}, ShippingRates{Flat: decimal.RequireFromString("5")})
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", sampleOrder)
+    if w.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    var order Order
+    if err := json.Unmarshal(w.Body.Bytes(), &order); err != nil {
+        t.Fatal(err)
+    }
+    if order.Subtotal.String() != "59.98" || order.Tax.String() != "6" || order.Shipping.String() != "5" || order.TotalAmount.String() != "70.98" {
+        t.Fatalf("got subtotal %s, tax %s, shipping %s, total %s", order.Subtotal, order.Tax, order.Shipping, order.TotalAmount)
+    }
+    if got := fake.lastCharge.Load().Amount; !got.Equal(order.TotalAmount) {
+        t.Fatalf("charged %s, want the grand total %s", got, order.TotalAmount)
+    }
+}
+
+func TestTaxRatesFromEnv(t *testing.T) {
+    t.Setenv("TAX_RATE", "0.08")
+    t.Setenv("TAX_RATES", "us:0.07, DE:0.19")
+    rates, err := taxRatesFromEnv()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := rates.Tax("DE", decimal.NewFromInt(100)); got.String() != "19" {
+        t.Fatalf("DE tax on 100: got %s", got)
+    }
+    if got := rates.Tax("FR", decimal.NewFromInt(100)); got.String() != "8" {
+        t.Fatalf("FR tax on 100: got %s", got)
+    }
+
+    for _, bad := range []string{"US", "US:abc", ":0.1", "US:-0.1"} {
+        t.Setenv("TAX_RATES", bad)
+        if _, err := taxRatesFromEnv(); err == nil {
+            t.Errorf("TAX_RATES=%q: expected an error", bad)
+        }
+    }
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "mime"
+    "net/http"
+    "os"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "github.com/gin-gonic/gin/binding"
+)
+
+const (
+    acceptProfileHeader  = "Accept-Profile"
+    contentProfileHeader = "Content-Profile"
+)
+
+// Profile names, combined with commas in Accept-Profile or RESPONSE_PROFILE:
+// one naming, snake_case or camelCase, and optionally compact.
+const (
+    ProfileSnakeCase = "snake_case"
+    ProfileCamelCase = "camelCase"
+    ProfileCompact   = "compact"
+)
+
+// responseProfile shapes JSON responses for clients that want them
+// differently from the service's own snake_case, fully populated form.
+type responseProfile struct {
+    camelCase bool
+    // compact omits fields that are null, empty strings, or empty arrays or
+    // objects. Zero numbers and false are kept, since they carry meaning.
+    compact bool
+}
+
+// defaultProfile applies to requests without an Accept-Profile header.
+var defaultProfile responseProfile
+
+func parseResponseProfile(s string) (responseProfile, error) {
+    var p responseProfile
+    naming := ""
+    for _, name := range strings.Split(s, ",") {
+        switch name = strings.TrimSpace(name); name {
+        case "":
+        case ProfileSnakeCase, ProfileCamelCase:
+            if naming != "" && naming != name {
+                return p, fmt.Errorf("profile names both %s and %s", naming, name)
+            }
+            naming = name
+            p.camelCase = name == ProfileCamelCase
+        case ProfileCompact:
+            p.compact = true
+        default:
+            return p, fmt.Errorf("unknown profile %q", name)
+        }
+    }
+    return p, nil
+}
+
+func (p responseProfile) String() string {
+    names := []string{ProfileSnakeCase}
+    if p.camelCase {
+        names[0] = ProfileCamelCase
+    }
+    if p.compact {
+        names = append(names, ProfileCompact)
+    }
+    return strings.Join(names, ",")
+}
+
+// responseProfileFromEnv reads RESPONSE_PROFILE, the profile for clients
+// that don't ask for one.
+func responseProfileFromEnv() (responseProfile, error) {
+    p, err := parseResponseProfile(os.Getenv("RESPONSE_PROFILE"))
+    if err != nil {
+        return p, fmt.Errorf("RESPONSE_PROFILE: %w", err)
+    }
+    return p, nil
+}
+
+// shapeResponses rewrites JSON responses to the profile the client asks for
+// in Accept-Profile, or the configured default. The response is decoded and
+// re-encoded generically, so values come out exactly as the handler wrote
+// them: decimals stay decimal strings and IDs stay strings in every profile.
+//
+// Only field names the service chose are renamed; the keys of metadata,
+// which belong to the client, are passed through as stored. Responses that
+// aren't JSON, such as CSV exports or event streams, are sent unchanged.
+func shapeResponses() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        profile := defaultProfile
+        if header := c.GetHeader(acceptProfileHeader); header != "" {
+            var err error
+            if profile, err = parseResponseProfile(header); err != nil {
+                respondError(c, http.StatusNotAcceptable, CodeInvalidRequest, err.Error())
+                return
+            }
+        }
+        c.Writer.Header().Add("Vary", acceptProfileHeader)
+        if profile == (responseProfile{}) {
+            c.Next()
+            return
+        }
+
+        w := &profileWriter{ResponseWriter: c.Writer, profile: profile}
+        c.Writer = w
+        defer w.finish()
+        c.Next()
+    }
+}
+
+// profileWriter holds a JSON response back until the handler is done, then
+// sends it reshaped. Anything else streams straight through.
+type profileWriter struct {
+    gin.ResponseWriter
+    profile responseProfile
+
+    buf bytes.Buffer
+    // json and raw record which kind of response this is, once the first
+    // write tells.
+    json, raw bool
+}
+
+func (w *profileWriter) Write(p []byte) (int, error) {
+    if !w.json && !w.raw {
+        mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+        w.json = mediaType == binding.MIMEJSON
+        w.raw = !w.json
+    }
+    if w.raw {
+        return w.ResponseWriter.Write(p)
+    }
+    return w.buf.Write(p)
+}
+
+func (w *profileWriter) WriteString(s string) (int, error) {
+    return w.Write([]byte(s))
+}
+
+// Flush passes through for streamed responses; a JSON document can only be
+// reshaped once it is complete.
+func (w *profileWriter) Flush() {
+    if w.raw {
+        w.ResponseWriter.Flush()
+    }
+}
+
+func (w *profileWriter) finish() {
+    if !w.json {
+        return
+    }
+    body := w.buf.Bytes()
+    if shaped, err := w.profile.apply(body); err == nil {
+        w.Header().Set(contentProfileHeader, w.profile.String())
+        body = shaped
+    }
+    w.ResponseWriter.Write(body)
+}
+
+// apply reshapes a JSON document.
+func (p responseProfile) apply(data []byte) ([]byte, error) {
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.UseNumber()
+    var v interface{}
+    if err := dec.Decode(&v); err != nil {
+        return nil, err
+    }
+    return json.Marshal(p.shape(v))
+}
+
+func (p responseProfile) shape(v interface{}) interface{} {
+    switch v := v.(type) {
+    case map[string]interface{}:
+        out := make(map[string]interface{}, len(v))
+        for k, field := range v {
+            if k != "metadata" {
+                field = p.shape(field)
+            }
+            if p.compact && isEmptyJSON(field) {
+                continue
+            }
+            if p.camelCase {
+                k = camelCase(k)
+            }
+            out[k] = field
+        }
+        return out
+    case []interface{}:
+        for i := range v {
+            v[i] = p.shape(v[i])
+        }
+    }
+    return v
+}
+
+func isEmptyJSON(v interface{}) bool {
+    switch v := v.(type) {
+    case nil:
+        return true
+    case string:
+        return v == ""
+    case []interface{}:
+        return len(v) == 0
+    case map[string]interface{}:
+        return len(v) == 0
+    }
+    return false
+}
+
+// camelCase turns a snake_case name into camelCase; a leading underscore,
+// as in _links, is kept.
+func camelCase(name string) string {
+    prefix := name[:len(name)-len(strings.TrimLeft(name, "_"))]
+    parts := strings.Split(name[len(prefix):], "_")
+    var b strings.Builder
+    b.WriteString(prefix)
+    for i, part := range parts {
+        if i > 0 && part != "" {
+            part = strings.ToUpper(part[:1]) + part[1:]
+        }
+        b.WriteString(part)
+    }
+    return b.String()
+}
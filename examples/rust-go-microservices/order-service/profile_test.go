@@ -0,0 +1,171 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/shopspring/decimal"
+)
+
+func saveProfileOrder() *Order {
+    order := &Order{
+        OrderID:    uuid.MustParse("3f2a6c1e-8b7d-4e2a-9c1f-5d6e7f8a9b0c"),
+        CustomerID: "cust_123",
+        Items:      []OrderItem{{ProductID: "prod_1", Quantity: 2, Price: decimal.RequireFromString("19.990"), Status: ItemPending}},
+        // Orders stored before currencies were required have none.
+        Currency: "",
+        Metadata: map[string]string{"source_system": "pos", "empty_note": ""},
+        Subtotal: decimal.RequireFromString("39.98"),
+        Tax:      decimal.Zero,
+        Shipping: decimal.Zero,
+        // The total keeps a trailing zero that must survive every profile.
+        TotalAmount: decimal.RequireFromString("39.980"),
+        Status:      StatusConfirmed,
+        CreatedAt:   time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+    }
+    orders.Save(order)
+    return order
+}
+
+func getWithProfile(t *testing.T, profile string) (http.Header, map[string]interface{}) {
+    t.Helper()
+
+    order := saveProfileOrder()
+    headers := map[string]string{}
+    if profile != "" {
+        headers[acceptProfileHeader] = profile
+    }
+    w := doRequestWithHeaders(setupRouter(), http.MethodGet, "/orders/"+order.OrderID.String(), "", headers)
+    if w.Code != http.StatusOK {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    var body map[string]interface{}
+    if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+        t.Fatal(err)
+    }
+    return w.Header(), body
+}
+
+func TestResponseProfiles(t *testing.T) {
+    tests := []struct {
+        profile string
+        // present and absent are top-level fields.
+        present []string
+        absent  []string
+    }{
+        {"", []string{"order_id", "total_amount", "refunded_amount", "_links", "currency"}, []string{"orderId"}},
+        {ProfileSnakeCase, []string{"order_id", "total_amount", "currency"}, []string{"orderId"}},
+        {ProfileCamelCase, []string{"orderId", "totalAmount", "refundedAmount", "customerId", "_links", "currency"}, []string{"order_id"}},
+        {ProfileCamelCase + ", " + ProfileCompact, []string{"orderId", "totalAmount", "version"}, []string{"currency"}},
+        {ProfileCompact, []string{"order_id", "version", "refunded_amount"}, []string{"currency", "orderId"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.profile, func(t *testing.T) {
+            resetOrders(t)
+            _, body := getWithProfile(t, tt.profile)
+
+            for _, f := range tt.present {
+                if _, ok := body[f]; !ok {
+                    t.Errorf("missing %q in %v", f, body)
+                }
+            }
+            for _, f := range tt.absent {
+                if _, ok := body[f]; ok {
+                    t.Errorf("unexpected %q in %v", f, body)
+                }
+            }
+            // Decimals and IDs serialize the same in every profile.
+            for _, f := range []string{"total_amount", "totalAmount"} {
+                if v, ok := body[f]; ok && v != "39.98" {
+                    t.Errorf("%s = %#v, want \"39.98\"", f, v)
+                }
+            }
+            for _, f := range []string{"order_id", "orderId"} {
+                if v, ok := body[f]; ok && v != "3f2a6c1e-8b7d-4e2a-9c1f-5d6e7f8a9b0c" {
+                    t.Errorf("%s = %#v", f, v)
+                }
+            }
+            // Metadata belongs to the client and is never reshaped.
+            meta, _ := body["metadata"].(map[string]interface{})
+            if meta["source_system"] != "pos" || meta["empty_note"] != "" {
+                t.Errorf("metadata = %v", body["metadata"])
+            }
+        })
+    }
+}
+
+func TestResponseProfileNestedFields(t *testing.T) {
+    resetOrders(t)
+    header, body := getWithProfile(t, ProfileCamelCase)
+
+    if got := header.Get(contentProfileHeader); got != ProfileCamelCase {
+        t.Errorf("Content-Profile = %q", got)
+    }
+    items, _ := body["items"].([]interface{})
+    if len(items) != 1 {
+        t.Fatalf("items = %v", body["items"])
+    }
+    item := items[0].(map[string]interface{})
+    if item["productId"] != "prod_1" || item["price"] != "19.99" {
+        t.Fatalf("item = %v", item)
+    }
+}
+
+func TestResponseProfileDefaultFromConfig(t *testing.T) {
+    resetOrders(t)
+    prev := defaultProfile
+    defaultProfile = responseProfile{camelCase: true}
+    t.Cleanup(func() { defaultProfile = prev })
+
+    if _, body := getWithProfile(t, ""); body["orderId"] == nil {
+        t.Fatalf("configured profile not applied: %v", body)
+    }
+    if _, body := getWithProfile(t, ProfileSnakeCase); body["order_id"] == nil {
+        t.Fatalf("Accept-Profile did not override the default: %v", body)
+    }
+}
+
+func TestResponseProfileAppliesToErrors(t *testing.T) {
+    w := doRequestWithHeaders(setupRouter(), http.MethodGet, "/orders/not-a-uuid", "",
+        map[string]string{acceptProfileHeader: ProfileCamelCase})
+    if got := decodeError(t, w).Code; got != CodeInvalidOrderID {
+        t.Fatalf("got code %q", got)
+    }
+}
+
+func TestResponseProfileUnknown(t *testing.T) {
+    for _, profile := range []string{"kebab-case", "snake_case,camelCase"} {
+        w := doRequestWithHeaders(setupRouter(), http.MethodGet, "/health", "",
+            map[string]string{acceptProfileHeader: profile})
+        if w.Code != http.StatusNotAcceptable {
+            t.Errorf("%q: got status %d, want 406", profile, w.Code)
+        }
+    }
+}
+
+func TestResponseProfileLeavesCSVAlone(t *testing.T) {
+    resetOrders(t)
+    saveProfileOrder()
+    w := doRequestWithHeaders(setupRouter(), http.MethodGet, "/orders/export.csv", "",
+        map[string]string{acceptProfileHeader: ProfileCamelCase + "," + ProfileCompact})
+    if !strings.HasPrefix(w.Body.String(), "order_id,customer_id") {
+        t.Fatalf("CSV changed:\n%s", w.Body)
+    }
+}
+
+func TestCamelCase(t *testing.T) {
+    for in, want := range map[string]string{
+        "order_id":        "orderId",
+        "_links":          "_links",
+        "status":          "status",
+        "payment_retry_x": "paymentRetryX",
+    } {
+        if got := camelCase(in); got != want {
+            t.Errorf("camelCase(%q) = %q, want %q", in, got, want)
+        }
+    }
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "io"
+    "math"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+const defaultRateLimitPerMinute = 60
+
+type tokenBucket struct {
+    tokens float64
+    last   time.Time
+}
+
+// RateLimiter is a token-bucket limiter with one bucket per key. Each bucket
+// holds up to perMinute tokens and refills continuously at perMinute a
+// minute. Buckets idle long enough to have refilled are dropped, so memory
+// grows only with the number of recently active keys.
+type RateLimiter struct {
+    mu        sync.Mutex
+    perMinute float64
+    now       func() time.Time
+    buckets   map[string]*tokenBucket
+    lastPrune time.Time
+}
+
+func NewRateLimiter(perMinute int) *RateLimiter {
+    return &RateLimiter{
+        perMinute: float64(perMinute),
+        now:       currentTime,
+        buckets:   make(map[string]*tokenBucket),
+    }
+}
+
+// createLimiter limits POST /orders; nil disables rate limiting.
+var createLimiter *RateLimiter
+
+// Allow takes a token from key's bucket. When the bucket is empty it returns
+// false and how long until a token is available.
+func (l *RateLimiter) Allow(key string) (ok bool, retryAfter time.Duration) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    now := l.now()
+    l.pruneLocked(now)
+
+    b, exists := l.buckets[key]
+    if !exists {
+        b = &tokenBucket{tokens: l.perMinute, last: now}
+        l.buckets[key] = b
+    }
+    b.tokens = math.Min(l.perMinute, b.tokens+now.Sub(b.last).Minutes()*l.perMinute)
+    b.last = now
+
+    if b.tokens >= 1 {
+        b.tokens--
+        return true, 0
+    }
+    return false, time.Duration((1 - b.tokens) / l.perMinute * float64(time.Minute))
+}
+
+// pruneLocked drops buckets that have refilled completely, at most once a
+// minute; a full bucket behaves exactly like a missing one.
+func (l *RateLimiter) pruneLocked(now time.Time) {
+    if now.Sub(l.lastPrune) < time.Minute {
+        return
+    }
+    l.lastPrune = now
+    for key, b := range l.buckets {
+        if b.tokens+now.Sub(b.last).Minutes()*l.perMinute >= l.perMinute {
+            delete(l.buckets, key)
+        }
+    }
+}
+
+// rateLimit rejects requests with 429 once the bucket named by key(c) is
+// empty. A nil limiter lets every request through.
+func rateLimit(limiter *RateLimiter, key func(c *gin.Context) string) gin.HandlerFunc {
+    return rateLimitBy(func(*gin.Context) *RateLimiter { return limiter }, key)
+}
+
+// rateLimitBy is rateLimit with the limiter picked per request.
+func rateLimitBy(pick func(c *gin.Context) *RateLimiter, key func(c *gin.Context) string) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        limiter := pick(c)
+        if limiter == nil {
+            c.Next()
+            return
+        }
+        ok, retryAfter := limiter.Allow(key(c))
+        if !ok {
+            c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+            respondError(c, http.StatusTooManyRequests, CodeRateLimited, "Rate limit exceeded")
+            return
+        }
+        c.Next()
+    }
+}
+
+// customerKey keys rate limits by the customer_id in the JSON body, falling
+// back to the client IP.
+func customerKey(c *gin.Context) string {
+    if body, err := peekBody(c); err == nil {
+        var req struct {
+            CustomerID string `json:"customer_id"`
+        }
+        if json.Unmarshal(body, &req) == nil && req.CustomerID != "" {
+            return "customer:" + req.CustomerID
+        }
+    }
+    return "ip:" + c.ClientIP()
+}
+
+// peekBody reads the request body and puts it back for the handler,
+// including any read error, so the handler still sees e.g. an oversized
+// body.
+func peekBody(c *gin.Context) ([]byte, error) {
+    body, err := io.ReadAll(c.Request.Body)
+    if err != nil {
+        c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), errReader{err}))
+    } else {
+        c.Request.Body = io.NopCloser(bytes.NewReader(body))
+    }
+    return body, err
+}
+
+// errReader fails every read with err.
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
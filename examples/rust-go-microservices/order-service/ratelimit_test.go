@@ -0,0 +1,81 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+    "time"
+)
+
+func newTestRateLimiter(perMinute int) (*RateLimiter, *time.Time) {
+    now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    l := NewRateLimiter(perMinute)
+    l.now = func() time.Time { return now }
+    return l, &now
+}
+
+func TestRateLimiterExhaustsAndRefills(t *testing.T) {
+    l, now := newTestRateLimiter(3)
+
+    for i := 0; i < 3; i++ {
+        if ok, _ := l.Allow("a"); !ok {
+            t.Fatalf("request %d denied within the limit", i+1)
+        }
+    }
+    ok, retryAfter := l.Allow("a")
+    if ok {
+        t.Fatal("request allowed past the limit")
+    }
+    if retryAfter != 20*time.Second {
+        t.Fatalf("got retryAfter %v, want 20s", retryAfter)
+    }
+    if ok, _ := l.Allow("b"); !ok {
+        t.Fatal("another key shares the exhausted bucket")
+    }
+
+    *now = now.Add(20 * time.Second)
+    if ok, _ := l.Allow("a"); !ok {
+        t.Fatal("bucket did not refill")
+    }
+    if ok, _ := l.Allow("a"); ok {
+        t.Fatal("bucket refilled more than one token in 20s")
+    }
+}
+
+func TestRateLimiterEvictsIdleBuckets(t *testing.T) {
+    l, now := newTestRateLimiter(3)
+    l.Allow("a")
+    l.Allow("b")
+
+    *now = now.Add(2 * time.Minute)
+    l.Allow("c")
+    if n := len(l.buckets); n != 1 {
+        t.Fatalf("got %d buckets after the others went idle, want 1", n)
+    }
+}
+
+func TestCreateOrderRateLimited(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    prev := createLimiter
+    createLimiter, _ = newTestRateLimiter(2)
+    t.Cleanup(func() { createLimiter = prev })
+    r := setupRouter()
+
+    for i := 0; i < 2; i++ {
+        if w := doRequest(r, http.MethodPost, "/orders", sampleOrder); w.Code != http.StatusCreated {
+            t.Fatalf("request %d: got status %d: %s", i+1, w.Code, w.Body)
+        }
+    }
+    w := doRequest(r, http.MethodPost, "/orders", sampleOrder)
+    if w.Code != http.StatusTooManyRequests {
+        t.Fatalf("got status %d past the limit, want 429", w.Code)
+    }
+    if got := w.Header().Get("Retry-After"); got != "30" {
+        t.Fatalf("got Retry-After %q, want 30", got)
+    }
+
+    other := `{"customer_id":"cust_other","items":[{"product_id":"p","quantity":1,"price":"1.00"}]}`
+    if w := doRequest(r, http.MethodPost, "/orders", other); w.Code != http.StatusCreated {
+        t.Fatalf("other customer: got status %d, want 201", w.Code)
+    }
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+    "bytes"
+    "embed"
+    "fmt"
+    "html/template"
+    "net/http"
+    "strings"
+    texttemplate "text/template"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/shopspring/decimal"
+)
+
+const (
+    contentTypeHTML = "text/html"
+    contentTypePDF  = "application/pdf"
+)
+
+//go:embed templates/receipt.html templates/receipt.txt
+var receiptTemplates embed.FS
+
+// The HTML receipt is rendered as is; the PDF is the plain-text template laid
+// out in a fixed-width font, one line of text per line of the page.
+var (
+    receiptHTML = template.Must(template.ParseFS(receiptTemplates, "templates/receipt.html"))
+    receiptText = texttemplate.Must(texttemplate.ParseFS(receiptTemplates, "templates/receipt.txt"))
+)
+
+// receiptView is an order as its receipt shows it, with every amount
+// formatted to the currency's minor units.
+type receiptView struct {
+    OrderID     string
+    CustomerID  string
+    Currency    string
+    CreatedAt   string
+    ConfirmedAt string
+    Lines       []receiptLine
+    // ItemsTotal and Discount are only shown when the order has a discount.
+    ItemsTotal string
+    Discount   string
+    Subtotal   string
+    Tax        string
+    Shipping   string
+    Total      string
+    // Refunded is empty unless some of the total has been given back.
+    Refunded string
+}
+
+type receiptLine struct {
+    ProductID string
+    Quantity  int
+    Price     string
+    Discount  string
+    Total     string
+}
+
+// hasReceipt reports whether an order in status has been paid for and not
+// given back, the only orders a receipt is issued for.
+func hasReceipt(status string) bool {
+    return status == StatusConfirmed || status == StatusPartiallyShipped || status == StatusShipped
+}
+
+func newReceiptView(order *Order) receiptView {
+    places := minorUnits(order.Currency)
+    format := func(d decimal.Decimal) string { return d.StringFixed(places) }
+
+    view := receiptView{
+        OrderID:    order.OrderID.String(),
+        CustomerID: order.CustomerID,
+        Currency:   order.Currency,
+        CreatedAt:  order.CreatedAt.UTC().Format(time.RFC3339),
+        Subtotal:   format(order.Subtotal),
+        Tax:        format(order.Tax),
+        Shipping:   format(order.Shipping),
+        Total:      format(order.TotalAmount),
+    }
+    // An order confirmed more than once, say after an admin override, shows
+    // when it was last confirmed.
+    for _, change := range order.StatusHistory {
+        if change.To == StatusConfirmed {
+            view.ConfirmedAt = change.At.UTC().Format(time.RFC3339)
+        }
+    }
+    for _, item := range order.Items {
+        line := receiptLine{
+            ProductID: item.ProductID,
+            Quantity:  item.Quantity,
+            Price:     format(item.Price),
+            Total:     format(item.lineTotal()),
+        }
+        if item.Discount != nil {
+            gross := item.Price.Mul(decimal.NewFromInt(int64(item.Quantity)))
+            line.Discount = format(item.Discount.off(gross))
+        }
+        view.Lines = append(view.Lines, line)
+    }
+    if order.Discount != nil {
+        items := itemsTotal(order.Items)
+        view.ItemsTotal = format(items)
+        view.Discount = format(order.Discount.off(items))
+    }
+    if order.RefundedAmount.IsPositive() {
+        view.Refunded = format(order.RefundedAmount)
+    }
+    return view
+}
+
+// orderReceipt renders a receipt for a paid order: an HTML page by default,
+// or a PDF for clients that ask for application/pdf.
+func orderReceipt(c *gin.Context) {
+    order := loadOrder(c)
+    if order == nil {
+        return
+    }
+
+    if !hasReceipt(order.Status) {
+        respondError(c, http.StatusConflict, CodeInvalidStatusTransition,
+            fmt.Sprintf("No receipt is issued for an order in status %q", order.Status))
+        return
+    }
+
+    view := newReceiptView(order)
+    if c.NegotiateFormat(contentTypeHTML, contentTypePDF) == contentTypePDF {
+        var text bytes.Buffer
+        if err := receiptText.Execute(&text, view); err != nil {
+            respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to render receipt")
+            return
+        }
+        c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="receipt-%s.pdf"`, order.OrderID))
+        c.Data(http.StatusOK, contentTypePDF, renderTextPDF(strings.Split(strings.TrimRight(text.String(), "\n"), "\n")))
+        return
+    }
+
+    var page bytes.Buffer
+    if err := receiptHTML.Execute(&page, view); err != nil {
+        respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to render receipt")
+        return
+    }
+    c.Data(http.StatusOK, contentTypeHTML+"; charset=utf-8", page.Bytes())
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+    "bytes"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/shopspring/decimal"
+)
+
+func saveReceiptOrder(status string) *Order {
+    order := saveOrderWithStatus(status)
+    order.Items = []OrderItem{
+        {ProductID: "widget", Quantity: 2, Price: decimal.RequireFromString("29.99")},
+        {ProductID: "<gadget>", Quantity: 1, Price: decimal.RequireFromString("5"),
+            Discount: &Discount{Type: DiscountFixed, Value: decimal.RequireFromString("1")}},
+    }
+    order.Subtotal = decimal.RequireFromString("63.98")
+    order.Tax = decimal.RequireFromString("5.12")
+    order.Shipping = decimal.Zero
+    order.TotalAmount = decimal.RequireFromString("69.10")
+    order.StatusHistory = []StatusChange{
+        {To: StatusPending, At: order.CreatedAt},
+        {From: StatusPending, To: StatusConfirmed, At: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)},
+    }
+    orders.Save(order)
+    return order
+}
+
+func TestOrderReceiptHTML(t *testing.T) {
+    resetOrders(t)
+    r := setupRouter()
+    order := saveReceiptOrder(StatusConfirmed)
+
+    w := doRequest(r, http.MethodGet, "/orders/"+order.OrderID.String()+"/receipt", "")
+    if w.Code != http.StatusOK {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+        t.Fatalf("Content-Type = %q, want text/html", ct)
+    }
+    body := w.Body.String()
+    for _, want := range []string{"69.10 USD", "59.98", "4.00", "1.00", "2024-03-01T12:00:00Z", "&lt;gadget&gt;"} {
+        if !strings.Contains(body, want) {
+            t.Errorf("receipt is missing %q:\n%s", want, body)
+        }
+    }
+}
+
+func TestOrderReceiptPDF(t *testing.T) {
+    resetOrders(t)
+    r := setupRouter()
+    order := saveReceiptOrder(StatusShipped)
+
+    w := doRequestWithHeaders(r, http.MethodGet, "/orders/"+order.OrderID.String()+"/receipt", "",
+        map[string]string{"Accept": "application/pdf"})
+    if w.Code != http.StatusOK {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    if ct := w.Header().Get("Content-Type"); ct != contentTypePDF {
+        t.Fatalf("Content-Type = %q, want %q", ct, contentTypePDF)
+    }
+    body := w.Body.Bytes()
+    if !bytes.HasPrefix(body, []byte("%PDF-")) || !bytes.HasSuffix(body, []byte("%%EOF\n")) {
+        t.Fatalf("not a PDF document:\n%s", body)
+    }
+    for _, want := range []string{"69.10", "widget"} {
+        if !bytes.Contains(body, []byte(want)) {
+            t.Errorf("PDF is missing %q:\n%s", want, body)
+        }
+    }
+}
+
+func TestOrderReceiptRequiresPaidOrder(t *testing.T) {
+    resetOrders(t)
+    r := setupRouter()
+
+    for _, status := range []string{StatusPending, StatusPaymentFailed, StatusCancelled, StatusRefunded} {
+        t.Run(status, func(t *testing.T) {
+            order := saveReceiptOrder(status)
+            w := doRequest(r, http.MethodGet, "/orders/"+order.OrderID.String()+"/receipt", "")
+            if w.Code != http.StatusConflict {
+                t.Fatalf("got status %d, want 409", w.Code)
+            }
+        })
+    }
+}
+
+func TestRenderTextPDFPaginates(t *testing.T) {
+    lines := make([]string, pdfLinesPerPage*2+1)
+    for i := range lines {
+        lines[i] = "line"
+    }
+    doc := renderTextPDF(lines)
+    if !bytes.Contains(doc, []byte("/Count 3")) {
+        t.Fatalf("want 3 pages:\n%s", doc)
+    }
+}
+
+func TestPDFString(t *testing.T) {
+    if got, want := pdfString(`a (b) \ é €`), `a \(b\) \\ \351 ?`; got != want {
+        t.Fatalf("pdfString = %q, want %q", got, want)
+    }
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+const (
+    defaultReconcileInterval   = time.Minute
+    defaultReconcilePendingAge = 10 * time.Minute
+)
+
+// Reconciler settles orders left pending, e.g. by a crash between storing
+// an order and recording its payment result, by asking the payment service
+// what actually happened.
+type Reconciler struct {
+    // Interval is the time between scans.
+    Interval time.Duration
+    // PendingAge is how long an order must have been pending before it is
+    // reconciled; younger orders may still be mid-payment.
+    PendingAge time.Duration
+
+    now   func() time.Time
+    after func(d time.Duration) <-chan time.Time
+}
+
+func NewReconciler(interval, pendingAge time.Duration) *Reconciler {
+    return &Reconciler{
+        Interval:   interval,
+        PendingAge: pendingAge,
+        now:        currentTime,
+        after:      time.After,
+    }
+}
+
+// newReconcilerFromEnv reads RECONCILE_INTERVAL and RECONCILE_PENDING_AGE.
+func newReconcilerFromEnv() (*Reconciler, error) {
+    interval, err := envDuration("RECONCILE_INTERVAL", defaultReconcileInterval)
+    if err != nil {
+        return nil, err
+    }
+    pendingAge, err := envDuration("RECONCILE_PENDING_AGE", defaultReconcilePendingAge)
+    if err != nil {
+        return nil, err
+    }
+    return NewReconciler(interval, pendingAge), nil
+}
+
+// Run reconciles every Interval until ctx is done.
+func (r *Reconciler) Run(ctx context.Context) {
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-r.after(r.Interval):
+        }
+        if n, err := r.reconcileOnce(ctx); err != nil {
+            logger.Error("reconciliation failed", "error", err)
+        } else if n > 0 {
+            logger.Info("reconciled stuck orders", "settled", n)
+        }
+    }
+}
+
+// reconcileOnce settles every order pending for longer than PendingAge and
+// returns how many it settled. A failure to settle one order is logged and
+// leaves it for the next run.
+func (r *Reconciler) reconcileOnce(ctx context.Context) (int, error) {
+    all, err := orders.List()
+    if err != nil {
+        return 0, err
+    }
+
+    cutoff := r.now().Add(-r.PendingAge)
+    settled := 0
+    for _, order := range all {
+        if ctx.Err() != nil {
+            return settled, nil
+        }
+        if order.Status != StatusPending || order.DeletedAt != nil || order.CreatedAt.After(cutoff) {
+            continue
+        }
+        ok, err := r.reconcile(ctx, order.OrderID)
+        if err != nil {
+            logger.Warn("failed to reconcile order", "order_id", order.OrderID, "error", err)
+            continue
+        }
+        if ok {
+            settled++
+        }
+    }
+    return settled, nil
+}
+
+// reconcile settles one order, reporting whether it changed it. It holds
+// the order's lock and re-reads it first, so an order already settled by a
+// payment callback or request in the meantime is left alone.
+func (r *Reconciler) reconcile(ctx context.Context, id uuid.UUID) (bool, error) {
+    unlock := orderLocks.lock(id)
+    defer unlock()
+
+    order, err := orders.FindByID(id)
+    if err != nil {
+        return false, err
+    }
+    if order.Status != StatusPending || order.DeletedAt != nil {
+        return false, nil
+    }
+
+    payment, err := payments.lookupPayment(ctx, id)
+    if order.pendingSplit() != nil {
+        // As with a callback, only the result for the part the order is
+        // waiting on settles anything.
+        if err != nil || !settleSplit(ctx, order, payment) {
+            return false, err
+        }
+    } else {
+        var target, reason string
+        switch {
+        case errors.Is(err, ErrPaymentNotFound):
+            // The charge never reached the payment service.
+            target, reason = StatusPaymentFailed, "reconciled: payment not found"
+        case err != nil:
+            return false, err
+        default:
+            var final bool
+            if target, final = orderStatusForPayment(payment.Status); !final {
+                return false, nil
+            }
+            if target == StatusConfirmed && !paymentAmountMatches(ctx, order, order.TotalAmount, payment) {
+                target = StatusPaymentMismatch
+            }
+            reason = "reconciled: payment " + payment.Status
+        }
+        transitionStatus(order, target, reason)
+    }
+
+    releaseStock(ctx, order)
+    if err := saveAndPublish(ctx, order, settlementEvent(order)); err != nil {
+        return false, err
+    }
+    logger.Info("reconciled order", "order_id", id, "status", order.Status)
+    recordSettlement(order)
+    return true, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// newPaymentLookupServer points the payment client at a server answering
+// GET /payments/{order_id} with statuses[order_id], or 404 for other orders.
+func newPaymentLookupServer(t *testing.T, statuses map[uuid.UUID]string) *atomic.Int64 {
+    t.Helper()
+
+    var lookups atomic.Int64
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        lookups.Add(1)
+        id, err := uuid.Parse(strings.TrimPrefix(r.URL.Path, "/payments/"))
+        status, ok := statuses[id]
+        if r.Method != http.MethodGet || err != nil || !ok {
+            http.NotFound(w, r)
+            return
+        }
+        json.NewEncoder(w).Encode(PaymentResponse{PaymentID: uuid.New(), OrderID: id, Status: status, ProcessedAt: time.Now()})
+    }))
+    t.Cleanup(srv.Close)
+
+    prev := payments
+    payments = NewPaymentClient(srv.URL)
+    t.Cleanup(func() { payments = prev })
+    return &lookups
+}
+
+func newTestReconciler(now time.Time) *Reconciler {
+    r := NewReconciler(time.Minute, 10*time.Minute)
+    r.now = func() time.Time { return now }
+    return r
+}
+
+func savePendingOrder(createdAt time.Time) *Order {
+    order := saveOrderWithStatus(StatusPending)
+    order.CreatedAt = createdAt
+    orders.Save(order)
+    return order
+}
+
+func TestReconcilerSettlesStuckOrders(t *testing.T) {
+    resetOrders(t)
+    rec := recordEvents(t)
+    now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+    stuck := now.Add(-20 * time.Minute)
+
+    approved := savePendingOrder(stuck)
+    declined := savePendingOrder(stuck)
+    neverCharged := savePendingOrder(stuck)
+    processing := savePendingOrder(stuck)
+    young := savePendingOrder(now.Add(-time.Minute))
+    newPaymentLookupServer(t, map[uuid.UUID]string{
+        approved.OrderID:   "approved",
+        declined.OrderID:   "declined",
+        processing.OrderID: "processing",
+        young.OrderID:      "approved",
+    })
+
+    settled, err := newTestReconciler(now).reconcileOnce(context.Background())
+    if err != nil || settled != 3 {
+        t.Fatalf("got %d settled, %v; want 3", settled, err)
+    }
+
+    want := map[*Order]string{
+        approved:     StatusConfirmed,
+        declined:     StatusPaymentFailed,
+        neverCharged: StatusPaymentFailed,
+        processing:   StatusPending,
+        young:        StatusPending,
+    }
+    for order, status := range want {
+        if stored, _ := orders.FindByID(order.OrderID); stored.Status != status {
+            t.Errorf("order %s: got status %s, want %s", order.OrderID, stored.Status, status)
+        }
+    }
+    if n := len(rec.Events()); n != 3 {
+        t.Fatalf("got %d events, want 3", n)
+    }
+}
+
+func TestReconcilerSkipsOrderSettledConcurrently(t *testing.T) {
+    resetOrders(t)
+    now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+    order := savePendingOrder(now.Add(-time.Hour))
+    lookups := newPaymentLookupServer(t, map[uuid.UUID]string{order.OrderID: "declined"})
+
+    // A callback holds the order while the reconciler reaches it, and
+    // confirms it before letting go.
+    unlock := orderLocks.lock(order.OrderID)
+    done := make(chan bool)
+    go func() {
+        changed, _ := newTestReconciler(now).reconcile(context.Background(), order.OrderID)
+        done <- changed
+    }()
+    time.Sleep(20 * time.Millisecond)
+    order.Status = StatusConfirmed
+    orders.Save(order)
+    unlock()
+
+    if <-done {
+        t.Fatal("reconciler changed an order settled while it waited")
+    }
+    if stored, _ := orders.FindByID(order.OrderID); stored.Status != StatusConfirmed {
+        t.Fatalf("got status %s, want confirmed", stored.Status)
+    }
+    if n := lookups.Load(); n != 0 {
+        t.Fatalf("payment service queried %d times for a settled order", n)
+    }
+}
+
+func TestReconcilerRunStopsOnCancel(t *testing.T) {
+    resetOrders(t)
+    now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+    order := savePendingOrder(now.Add(-time.Hour))
+    newPaymentLookupServer(t, map[uuid.UUID]string{order.OrderID: "approved"})
+
+    r := newTestReconciler(now)
+    ticks := make(chan time.Time)
+    r.after = func(time.Duration) <-chan time.Time { return ticks }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    stopped := make(chan struct{})
+    go func() {
+        defer close(stopped)
+        r.Run(ctx)
+    }()
+
+    ticks <- now
+    ticks <- now // the first scan has finished once the second tick is taken
+    if stored, _ := orders.FindByID(order.OrderID); stored.Status != StatusConfirmed {
+        t.Fatalf("got status %s after a tick, want confirmed", stored.Status)
+    }
+
+    cancel()
+    select {
+    case <-stopped:
+    case <-time.After(time.Second):
+        t.Fatal("Run did not stop after cancel")
+    }
+}
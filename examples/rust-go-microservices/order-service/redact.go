@@ -0,0 +1,214 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "io"
+    "log/slog"
+    "os"
+    "regexp"
+    "strings"
+)
+
+const redacted = "[REDACTED]"
+
+// defaultRedactFields are the fields whose values never reach the logs:
+// payment details and the customer's addresses.
+const defaultRedactFields = "payment_method,card,bank_transfer,wallet,shipping_address,billing_address"
+
+// redactFields names the log attributes and JSON body fields whose values
+// are replaced with [REDACTED], whatever they contain. Card numbers are
+// masked wherever they appear, whether or not the field is listed.
+var redactFields = parseRedactFields(defaultRedactFields)
+
+// logRequestBodies adds each request's body, redacted, to its access log
+// line. It is for debugging and off by default.
+var logRequestBodies bool
+
+// maxLoggedBodyBytes is how much of a request body is logged.
+const maxLoggedBodyBytes = 4 << 10
+
+func parseRedactFields(list string) map[string]bool {
+    fields := make(map[string]bool)
+    for _, f := range strings.Split(list, ",") {
+        if f = strings.ToLower(strings.TrimSpace(f)); f != "" {
+            fields[f] = true
+        }
+    }
+    return fields
+}
+
+// redactFieldsFromEnv reads LOG_REDACT_FIELDS, a comma-separated list that
+// replaces the default.
+func redactFieldsFromEnv() map[string]bool {
+    if v, ok := os.LookupEnv("LOG_REDACT_FIELDS"); ok {
+        return parseRedactFields(v)
+    }
+    return parseRedactFields(defaultRedactFields)
+}
+
+// cardNumberPattern matches 13 to 19 digits, optionally grouped by spaces
+// or dashes; maskCardNumbers only masks those passing the Luhn check.
+var cardNumberPattern = regexp.MustCompile(`\d(?:[ -]?\d){12,18}`)
+
+// maskCardNumbers replaces anything in s that looks like a card number with
+// its last four digits. Digits that are part of a decimal, such as the
+// integer part of an amount, are left alone.
+func maskCardNumbers(s string) string {
+    matches := cardNumberPattern.FindAllStringIndex(s, -1)
+    if matches == nil {
+        return s
+    }
+    var b strings.Builder
+    last := 0
+    for _, m := range matches {
+        start, end := m[0], m[1]
+        if adjacentToNumber(s, start, end) {
+            continue
+        }
+        digits := strings.NewReplacer(" ", "", "-", "").Replace(s[start:end])
+        if !luhnValid(digits) {
+            continue
+        }
+        b.WriteString(s[last:start])
+        b.WriteString(strings.Repeat("*", len(digits)-4) + digits[len(digits)-4:])
+        last = end
+    }
+    b.WriteString(s[last:])
+    return b.String()
+}
+
+// adjacentToNumber reports whether s[start:end] continues a longer number,
+// such as the digits before a decimal point.
+func adjacentToNumber(s string, start, end int) bool {
+    isNum := func(c byte) bool { return c >= '0' && c <= '9' || c == '.' }
+    return (start > 0 && isNum(s[start-1])) || (end < len(s) && isNum(s[end]))
+}
+
+func luhnValid(digits string) bool {
+    sum := 0
+    double := false
+    for i := len(digits) - 1; i >= 0; i-- {
+        d := int(digits[i] - '0')
+        if double {
+            if d *= 2; d > 9 {
+                d -= 9
+            }
+        }
+        sum += d
+        double = !double
+    }
+    return sum%10 == 0
+}
+
+// redactJSON returns body with the values of redactFields replaced and card
+// numbers masked. Amounts are decoded as json.Number, so they come back
+// exactly as sent. A body that isn't JSON only has its card numbers masked.
+func redactJSON(body []byte) string {
+    dec := json.NewDecoder(bytes.NewReader(body))
+    dec.UseNumber()
+    var v interface{}
+    if err := dec.Decode(&v); err != nil {
+        return maskCardNumbers(string(body))
+    }
+    out, err := json.Marshal(redactValue(v))
+    if err != nil {
+        return maskCardNumbers(string(body))
+    }
+    return string(out)
+}
+
+func redactValue(v interface{}) interface{} {
+    switch v := v.(type) {
+    case map[string]interface{}:
+        for k, field := range v {
+            if redactFields[strings.ToLower(k)] {
+                v[k] = redacted
+            } else {
+                v[k] = redactValue(field)
+            }
+        }
+    case []interface{}:
+        for i := range v {
+            v[i] = redactValue(v[i])
+        }
+    case string:
+        return maskCardNumbers(v)
+    case json.Number:
+        if masked := maskCardNumbers(v.String()); masked != v.String() {
+            return masked
+        }
+    }
+    return v
+}
+
+// redactingHandler masks sensitive values in log records before passing
+// them on: attributes named in redactFields are replaced and card numbers
+// in string values are masked.
+type redactingHandler struct {
+    slog.Handler
+}
+
+func newRedactingHandler(h slog.Handler) slog.Handler {
+    return redactingHandler{h}
+}
+
+func (h redactingHandler) Handle(ctx context.Context, r slog.Record) error {
+    out := slog.NewRecord(r.Time, r.Level, maskCardNumbers(r.Message), r.PC)
+    r.Attrs(func(a slog.Attr) bool {
+        out.AddAttrs(redactAttr(a))
+        return true
+    })
+    return h.Handler.Handle(ctx, out)
+}
+
+func (h redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+    redactedAttrs := make([]slog.Attr, len(attrs))
+    for i, a := range attrs {
+        redactedAttrs[i] = redactAttr(a)
+    }
+    return redactingHandler{h.Handler.WithAttrs(redactedAttrs)}
+}
+
+func (h redactingHandler) WithGroup(name string) slog.Handler {
+    return redactingHandler{h.Handler.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+    if redactFields[strings.ToLower(a.Key)] {
+        return slog.String(a.Key, redacted)
+    }
+    v := a.Value.Resolve()
+    switch v.Kind() {
+    case slog.KindString:
+        return slog.String(a.Key, maskCardNumbers(v.String()))
+    case slog.KindGroup:
+        group := v.Group()
+        attrs := make([]slog.Attr, len(group))
+        for i, ga := range group {
+            attrs[i] = redactAttr(ga)
+        }
+        return slog.Attr{Key: a.Key, Value: slog.GroupValue(attrs...)}
+    case slog.KindAny:
+        if err, ok := v.Any().(error); ok {
+            return slog.String(a.Key, maskCardNumbers(err.Error()))
+        }
+    }
+    return slog.Attr{Key: a.Key, Value: v}
+}
+
+// bodyRecorder keeps the first maxLoggedBodyBytes of a request body as the
+// handler reads it, so logging the body doesn't read it a second time.
+type bodyRecorder struct {
+    io.ReadCloser
+    buf bytes.Buffer
+}
+
+func (r *bodyRecorder) Read(p []byte) (int, error) {
+    n, err := r.ReadCloser.Read(p)
+    if room := maxLoggedBodyBytes - r.buf.Len(); room > 0 {
+        r.buf.Write(p[:min(n, room)])
+    }
+    return n, err
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+    "errors"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func TestMaskCardNumbers(t *testing.T) {
+    tests := []struct {
+        in, want string
+    }{
+        {"card 4111111111111111 declined", "card ************1111 declined"},
+        {"4111 1111 1111 1111", "************1111"},
+        {"5500-0000-0000-0004", "************0004"},
+        // Not Luhn-valid, so not a card number.
+        {"order 4111111111111112", "order 4111111111111112"},
+        // Part of a decimal amount.
+        {"4111111111111111.00", "4111111111111111.00"},
+        {"too short 411111111111", "too short 411111111111"},
+        {"no digits", "no digits"},
+    }
+    for _, tt := range tests {
+        if got := maskCardNumbers(tt.in); got != tt.want {
+            t.Errorf("maskCardNumbers(%q) = %q, want %q", tt.in, got, tt.want)
+        }
+    }
+}
+
+func TestRedactJSON(t *testing.T) {
+    body := `{"customer_id":"cust_123","note":"pay with 4242424242424242",` +
+        `"items":[{"product_id":"p","quantity":2,"price":"19.99"}],"total":1234.5678,` +
+        `"payment_method":{"type":"card","card":{"brand":"visa","last4":"4242"}},"legacy_pan":4111111111111111}`
+    got := redactJSON([]byte(body))
+
+    for _, want := range []string{
+        `"payment_method":"[REDACTED]"`,
+        `"note":"pay with ************4242"`,
+        `"legacy_pan":"************1111"`,
+        `"price":"19.99"`,
+        `"total":1234.5678`,
+        `"customer_id":"cust_123"`,
+    } {
+        if !strings.Contains(got, want) {
+            t.Errorf("redacted body missing %s: %s", want, got)
+        }
+    }
+    if strings.Contains(got, "visa") {
+        t.Errorf("payment method leaked: %s", got)
+    }
+
+    if got := redactJSON([]byte("not json 4111111111111111")); got != "not json ************1111" {
+        t.Errorf("non-JSON body: got %q", got)
+    }
+}
+
+func TestRedactFieldsFromEnv(t *testing.T) {
+    t.Setenv("LOG_REDACT_FIELDS", " Notes, customer_id ,")
+    fields := redactFieldsFromEnv()
+    if len(fields) != 2 || !fields["notes"] || !fields["customer_id"] {
+        t.Fatalf("got %v", fields)
+    }
+}
+
+func TestLoggerRedactsAttributes(t *testing.T) {
+    logs := captureLogs(t)
+
+    logger.With("payment_method", "visa 4242").Info("charging 4111111111111111",
+        "error", errors.New("card 5500000000000004 rejected"),
+        "amount", "4111111111111111.00",
+        "shipping_address", map[string]string{"line1": "1 Main St"},
+    )
+    line := logLines(t, logs)[0]
+    want := map[string]interface{}{
+        "msg":              "charging ************1111",
+        "payment_method":   redacted,
+        "error":            "card ************0004 rejected",
+        "amount":           "4111111111111111.00",
+        "shipping_address": redacted,
+    }
+    for k, v := range want {
+        if line[k] != v {
+            t.Errorf("%s = %v, want %v", k, line[k], v)
+        }
+    }
+}
+
+func TestAccessLogIncludesRedactedBody(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    logs := captureLogs(t)
+    logRequestBodies = true
+    t.Cleanup(func() { logRequestBodies = false })
+    r := setupRouter()
+
+    body := `{"customer_id":"cust_123","items":[{"product_id":"prod_456","quantity":1,"price":"10.50"}],` +
+        `"notes":"card is 4111 1111 1111 1111","payment_method":{"type":"card","card":{"brand":"visa","last4":"1111","exp_month":12,"exp_year":2099}}}`
+    if w := doRequest(r, http.MethodPost, "/orders", body); w.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+
+    var logged string
+    for _, line := range logLines(t, logs) {
+        if line["msg"] == "request" {
+            logged, _ = line["body"].(string)
+        }
+    }
+    if logged == "" {
+        t.Fatal("access log has no body")
+    }
+    if strings.Contains(logged, "4111 1111") || strings.Contains(logged, "visa") {
+        t.Fatalf("sensitive data in logged body: %s", logged)
+    }
+    if !strings.Contains(logged, `"price":"10.50"`) || !strings.Contains(logged, `"card is ************1111"`) {
+        t.Fatalf("logged body lost data it should keep: %s", logged)
+    }
+}
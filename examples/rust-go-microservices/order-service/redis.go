@@ -0,0 +1,99 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net"
+    "net/url"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/redis/go-redis/v9"
+)
+
+const defaultRedisTimeout = 2 * time.Second
+
+// RedisStore is a SharedStore backed by Redis, through a go-redis client
+// that pools, dials and reconnects its connections.
+type RedisStore struct {
+    client *redis.Client
+}
+
+// NewRedisStore connects to the Redis at addr lazily, on the first command.
+// Each command is bounded by defaultRedisTimeout, or the caller's context
+// deadline if that comes first.
+func NewRedisStore(addr, password string, db int) *RedisStore {
+    return &RedisStore{client: redis.NewClient(&redis.Options{
+        Addr:                  addr,
+        Password:              password,
+        DB:                    db,
+        DialTimeout:           defaultRedisTimeout,
+        ReadTimeout:           defaultRedisTimeout,
+        WriteTimeout:          defaultRedisTimeout,
+        ContextTimeoutEnabled: true,
+    })}
+}
+
+// parseRedisURL reads a redis://[:password@]host[:port][/db] URL.
+func parseRedisURL(raw string) (*RedisStore, error) {
+    u, err := url.Parse(raw)
+    if err != nil {
+        return nil, err
+    }
+    if u.Scheme != "redis" || u.Host == "" {
+        return nil, fmt.Errorf("%q is not a redis:// URL", raw)
+    }
+    addr := u.Host
+    if u.Port() == "" {
+        addr = net.JoinHostPort(u.Hostname(), "6379")
+    }
+    password, _ := u.User.Password()
+    db := 0
+    if path := strings.TrimPrefix(u.Path, "/"); path != "" {
+        if db, err = strconv.Atoi(path); err != nil || db < 0 {
+            return nil, fmt.Errorf("invalid database %q", path)
+        }
+    }
+    return NewRedisStore(addr, password, db), nil
+}
+
+// idempotencyStoreFromEnv returns an IdempotencyStore shared through the
+// Redis at IDEMPOTENCY_REDIS_URL, or one local to this instance when it is
+// unset.
+func idempotencyStoreFromEnv(ttl time.Duration) (*IdempotencyStore, error) {
+    raw := os.Getenv("IDEMPOTENCY_REDIS_URL")
+    if raw == "" {
+        return NewIdempotencyStore(ttl), nil
+    }
+    store, err := parseRedisURL(raw)
+    if err != nil {
+        return nil, fmt.Errorf("IDEMPOTENCY_REDIS_URL: %w", err)
+    }
+    return NewSharedIdempotencyStore(ttl, store), nil
+}
+
+func (s *RedisStore) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+    return s.client.SetNX(ctx, key, value, ttl).Result()
+}
+
+func (s *RedisStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+    return s.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) (string, bool, error) {
+    value, err := s.client.Get(ctx, key).Result()
+    if errors.Is(err, redis.Nil) {
+        return "", false, nil
+    }
+    if err != nil {
+        return "", false, err
+    }
+    return value, true, nil
+}
+
+func (s *RedisStore) Del(ctx context.Context, key string) error {
+    return s.client.Del(ctx, key).Err()
+}
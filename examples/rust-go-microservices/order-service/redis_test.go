@@ -0,0 +1,171 @@
+package main
+
+import (
+    "context"
+    "net"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/alicebob/miniredis/v2"
+    "github.com/google/uuid"
+)
+
+// newRedisServer starts an in-process Redis, requiring password if it is
+// set, for the duration of the test.
+func newRedisServer(t *testing.T, password string) *miniredis.Miniredis {
+    t.Helper()
+
+    mr := miniredis.RunT(t)
+    if password != "" {
+        mr.RequireAuth(password)
+    }
+    return mr
+}
+
+func TestRedisStoreCommands(t *testing.T) {
+    mr := newRedisServer(t, "secret")
+    store := NewRedisStore(mr.Addr(), "secret", 0)
+    ctx := context.Background()
+
+    if ok, err := store.SetNX(ctx, "k", "pending", time.Minute); !ok || err != nil {
+        t.Fatalf("first SetNX = %v, %v", ok, err)
+    }
+    if ok, err := store.SetNX(ctx, "k", "other", time.Minute); ok || err != nil {
+        t.Fatalf("second SetNX = %v, %v", ok, err)
+    }
+    if err := store.Set(ctx, "k", "done", time.Minute); err != nil {
+        t.Fatal(err)
+    }
+    if v, ok, err := store.Get(ctx, "k"); v != "done" || !ok || err != nil {
+        t.Fatalf("Get = %q, %v, %v", v, ok, err)
+    }
+    if err := store.Del(ctx, "k"); err != nil {
+        t.Fatal(err)
+    }
+    if _, ok, err := store.Get(ctx, "k"); ok || err != nil {
+        t.Fatalf("Get after Del = %v, %v", ok, err)
+    }
+
+    store.Set(ctx, "k", "done", time.Minute)
+    if ttl := mr.TTL("k"); ttl != time.Minute {
+        t.Fatalf("got TTL %s, want 1m", ttl)
+    }
+    mr.FastForward(time.Minute)
+    if _, ok, err := store.Get(ctx, "k"); ok || err != nil {
+        t.Fatalf("Get after expiry = %v, %v", ok, err)
+    }
+}
+
+func TestRedisStoreReportsErrors(t *testing.T) {
+    mr := newRedisServer(t, "secret")
+    ctx := context.Background()
+    if _, err := NewRedisStore(mr.Addr(), "wrong", 0).SetNX(ctx, "k", "v", time.Minute); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+        t.Fatalf("got %v, want an auth error", err)
+    }
+
+    store := NewRedisStore(mr.Addr(), "secret", 0)
+    mr.SetError("LOADING Redis is loading the dataset in memory")
+    if _, _, err := store.Get(ctx, "k"); err == nil || !strings.Contains(err.Error(), "LOADING") {
+        t.Fatalf("got %v, want the server's error", err)
+    }
+    mr.SetError("")
+    if _, ok, err := store.Get(ctx, "k"); ok || err != nil {
+        t.Fatalf("Get once the server recovered = %v, %v", ok, err)
+    }
+
+    ln, _ := net.Listen("tcp", "127.0.0.1:0")
+    addr := ln.Addr().String()
+    ln.Close()
+    if _, _, err := NewRedisStore(addr, "", 0).Get(ctx, "k"); err == nil {
+        t.Fatal("want an error for an unreachable server")
+    }
+}
+
+func TestRedisStoreReconnectsAfterRestart(t *testing.T) {
+    mr := newRedisServer(t, "")
+    store := NewRedisStore(mr.Addr(), "", 0)
+    ctx := context.Background()
+    if err := store.Set(ctx, "k", "v", time.Minute); err != nil {
+        t.Fatal(err)
+    }
+
+    // Restarting drops every connection the store has pooled.
+    mr.Close()
+    if err := mr.Restart(); err != nil {
+        t.Fatal(err)
+    }
+    var err error
+    for i := 0; i < 3; i++ {
+        var v string
+        if v, _, err = store.Get(ctx, "k"); err == nil {
+            if v != "v" {
+                t.Fatalf("Get after restart = %q, want v", v)
+            }
+            return
+        }
+    }
+    t.Fatalf("store never reconnected: %v", err)
+}
+
+func TestRedisStoreHonoursContextDeadline(t *testing.T) {
+    // A listener that accepts connections but never answers.
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { ln.Close() })
+    go func() {
+        for {
+            conn, err := ln.Accept()
+            if err != nil {
+                return
+            }
+            t.Cleanup(func() { conn.Close() })
+        }
+    }()
+
+    ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+    defer cancel()
+    start := time.Now()
+    if _, _, err := NewRedisStore(ln.Addr().String(), "", 0).Get(ctx, "k"); err == nil {
+        t.Fatal("want an error from a server that never answers")
+    }
+    if elapsed := time.Since(start); elapsed > time.Second {
+        t.Fatalf("Get took %s, want it cut off at the context deadline", elapsed)
+    }
+}
+
+func TestSharedIdempotencyOverRedis(t *testing.T) {
+    addr := newRedisServer(t, "").Addr()
+    instances := newInstances(NewRedisStore(addr, "", 0), 1)
+    instances = append(instances, newInstances(NewRedisStore(addr, "", 0), 1)...)
+    ctx := context.Background()
+
+    if _, found, err := instances[0].Begin(ctx, "key-redis"); found || err != nil {
+        t.Fatalf("claim: found=%v err=%v", found, err)
+    }
+    created := uuid.New()
+    instances[0].Finish(ctx, "key-redis", created)
+    if id, found, err := instances[1].Begin(ctx, "key-redis"); !found || err != nil || id != created {
+        t.Fatalf("other instance: id=%s found=%v err=%v", id, found, err)
+    }
+}
+
+func TestParseRedisURL(t *testing.T) {
+    store, err := parseRedisURL("redis://:pw@cache:6380/2")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if opts := store.client.Options(); opts.Addr != "cache:6380" || opts.Password != "pw" || opts.DB != 2 {
+        t.Fatalf("got addr %s, password %q, db %d", opts.Addr, opts.Password, opts.DB)
+    }
+    if store, _ := parseRedisURL("redis://cache"); store == nil || store.client.Options().Addr != "cache:6379" {
+        t.Fatalf("default port: got %+v", store)
+    }
+    for _, raw := range []string{"http://cache", "redis://", "redis://cache/x"} {
+        if _, err := parseRedisURL(raw); err == nil {
+            t.Errorf("%q: want an error", raw)
+        }
+    }
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/gin-gonic/gin/binding"
+    "github.com/google/uuid"
+    "github.com/shopspring/decimal"
+)
+
+// Refund is one refund made on an order.
+type Refund struct {
+    RefundID uuid.UUID       `json:"refund_id"`
+    Amount   decimal.Decimal `json:"amount"`
+    // Items are the units the refund was for, if it was asked for by item.
+    Items []RefundItem `json:"items,omitempty"`
+    // Parts are the refunds of each payment a split order's refund was
+    // spread across; RefundID is the first part's.
+    Parts      []RefundPart `json:"parts,omitempty"`
+    RefundedAt time.Time    `json:"refunded_at"`
+}
+
+// RefundPart is the share of a Refund given back to one payment split.
+type RefundPart struct {
+    PaymentID uuid.UUID       `json:"payment_id"`
+    RefundID  uuid.UUID       `json:"refund_id"`
+    Amount    decimal.Decimal `json:"amount"`
+}
+
+// RefundItem asks for Quantity units of a product to be refunded.
+type RefundItem struct {
+    ProductID string `json:"product_id"`
+    Quantity  int    `json:"quantity"`
+}
+
+// RefundOrderRequest is the optional body of POST /orders/:id/refund. It
+// gives either an Amount or the Items to refund; with neither, everything
+// not yet refunded is.
+type RefundOrderRequest struct {
+    Amount *decimal.Decimal `json:"amount"`
+    Items  []RefundItem     `json:"items"`
+}
+
+func refundOrder(c *gin.Context) {
+    // Held until the refund is saved, so a concurrent refund sees it and
+    // can't give back the same balance twice.
+    unlock := lockOrderParam(c)
+    defer unlock()
+    order := loadOrder(c)
+    if order == nil {
+        return
+    }
+
+    body, err := c.GetRawData()
+    if err != nil {
+        respondBindError(c, err)
+        return
+    }
+    warnings, rerr := checkAmounts(c, body)
+    if rerr != nil {
+        rerr.respond(c)
+        return
+    }
+    var req RefundOrderRequest
+    if err := binding.JSON.BindBody(body, &req); err != nil && !errors.Is(err, io.EOF) {
+        respondBindError(c, err)
+        return
+    }
+
+    if !canTransition(order.Status, StatusRefunded, false) {
+        respondError(c, http.StatusConflict, CodeInvalidStatusTransition,
+            fmt.Sprintf("Order cannot be refunded from status %q", order.Status))
+        return
+    }
+
+    remaining := order.TotalAmount.Sub(order.RefundedAmount)
+    amount := remaining
+    if req.Amount != nil {
+        amount = *req.Amount
+    }
+    var items []OrderItem
+    if len(req.Items) > 0 {
+        if req.Amount != nil {
+            verr := &ValidationError{}
+            verr.add("amount", "must not be given with items")
+            respondValidationError(c, verr)
+            return
+        }
+        var rerr *requestError
+        if items, amount, rerr = refundUnits(order, req.Items); rerr != nil {
+            rerr.respond(c)
+            return
+        }
+    }
+
+    verr := &ValidationError{}
+    if !amount.IsPositive() {
+        verr.add("amount", "must be positive")
+    } else if !amountInRange(amount) {
+        verr.add("amount", "must have at most %d digits before and %d after the decimal point", maxAmountDigits, maxAmountScale)
+    } else if currency, ok := lookupCurrency(order.Currency); ok && !currency.fitsMinorUnits(amount) {
+        verr.add("amount", "has more than %d decimal places for %s", currency.MinorUnits, currency.Code)
+    }
+    if verr.err() != nil {
+        respondValidationError(c, verr)
+        return
+    }
+    if amount.GreaterThan(remaining) {
+        respondError(c, http.StatusUnprocessableEntity, CodeRefundExceedsBalance,
+            fmt.Sprintf("Refund of %s exceeds the %s %s still refundable", amount, remaining, order.Currency))
+        return
+    }
+
+    if !issueRefund(c, order, amount) {
+        return
+    }
+    if items != nil {
+        order.Items = items
+        last := &order.Refunds[len(order.Refunds)-1]
+        last.Items = req.Items
+        rollUp(order, "items refunded")
+    }
+    if order.RefundedAmount.Equal(order.TotalAmount) {
+        refundItems(order)
+        rollUp(order, "fully refunded")
+    }
+    releaseStock(c.Request.Context(), order)
+    if err := orders.Save(order); err != nil {
+        respondSaveError(c, err)
+        return
+    }
+    if len(warnings) > 0 {
+        // Warnings about how the amount was sent come back with the order
+        // but aren't stored with it.
+        shown := *order
+        shown.Warnings = append(order.Warnings[:len(order.Warnings):len(order.Warnings)], warnings...)
+        order = &shown
+    }
+    c.JSON(http.StatusOK, withLinks(order))
+}
+
+// refundUnits works out a refund of units of order's items. It returns a
+// copy of the items with the units counted as refunded, and the amount to
+// give back: the units' share of what their lines cost, scaled by the
+// order's discount, tax and shipping, or all that remains once the last
+// unit goes. A product listed on several lines is refunded from them in
+// order.
+func refundUnits(order *Order, units []RefundItem) ([]OrderItem, decimal.Decimal, *requestError) {
+    verr := &ValidationError{}
+    wanted := make(map[string]int)
+    for i, unit := range units {
+        field := fmt.Sprintf("items[%d]", i)
+        switch {
+        case unit.ProductID == "":
+            verr.add(field+".product_id", "is required")
+        case !hasProduct(order.Items, unit.ProductID):
+            verr.add(field+".product_id", "is not on the order")
+        }
+        if unit.Quantity <= 0 {
+            verr.add(field+".quantity", "must be positive")
+        }
+        wanted[unit.ProductID] += unit.Quantity
+    }
+    if verr.err() != nil {
+        return nil, decimal.Zero, validationFailed(verr)
+    }
+    for _, unit := range units {
+        if want, left := wanted[unit.ProductID], refundableUnits(order.Items, unit.ProductID); want > left {
+            return nil, decimal.Zero, newRequestError(http.StatusUnprocessableEntity, CodeRefundExceedsBalance,
+                fmt.Sprintf("Refund of %d units of %s exceeds the %d still refundable", want, unit.ProductID, left))
+        }
+    }
+
+    // Copy rather than change in place: copies of an order handed out by
+    // the store may share the items' backing array.
+    items := append([]OrderItem(nil), order.Items...)
+    share := decimal.Zero
+    for i := range items {
+        item := &items[i]
+        n := min(wanted[item.ProductID], item.Quantity-item.RefundedQuantity)
+        if n <= 0 {
+            continue
+        }
+        wanted[item.ProductID] -= n
+        item.RefundedQuantity += n
+        share = share.Add(item.lineTotal().Mul(decimal.NewFromInt(int64(n))).Div(decimal.NewFromInt(int64(item.Quantity))))
+        if item.RefundedQuantity == item.Quantity && canTransitionItem(item.status(), ItemRefunded) {
+            item.Status = ItemRefunded
+        }
+    }
+
+    remaining := order.TotalAmount.Sub(order.RefundedAmount)
+    if refundableUnits(items, "") == 0 {
+        return items, remaining, nil
+    }
+    amount := decimal.Zero
+    if total := itemsTotal(order.Items); total.IsPositive() {
+        amount = roundingMode.round(share.Mul(order.TotalAmount).Div(total), minorUnits(order.Currency))
+    }
+    return items, decimal.Min(amount, remaining), nil
+}
+
+func hasProduct(items []OrderItem, productID string) bool {
+    for _, item := range items {
+        if item.ProductID == productID {
+            return true
+        }
+    }
+    return false
+}
+
+// refundableUnits counts the units of productID not yet refunded, or of
+// every product if productID is empty.
+func refundableUnits(items []OrderItem, productID string) int {
+    n := 0
+    for _, item := range items {
+        if productID == "" || item.ProductID == productID {
+            n += item.Quantity - item.RefundedQuantity
+        }
+    }
+    return n
+}
+
+// issueRefund asks the payment service to refund amount of order and records
+// it on order, which the caller must save. A split order's refund is spread
+// across its parts in order, each refunded from its own payment. On failure
+// it writes the error response and returns false; parts of a split refund
+// given back before then are recorded and saved even so, so a retry doesn't
+// give them back again.
+func issueRefund(c *gin.Context, order *Order, amount decimal.Decimal) bool {
+    // A fresh key per refund, sent again with each retry, so a retried
+    // refund the payment service already made isn't made twice.
+    key := uuid.NewString()
+    if len(order.PaymentSplits) == 0 {
+        resp, ok := sendRefund(c, order, RefundRequest{OrderID: order.OrderID, IdempotencyKey: key}, amount)
+        if ok {
+            order.recordRefund(Refund{RefundID: resp.RefundID, Amount: amount})
+        }
+        return ok
+    }
+
+    refund := Refund{Amount: decimal.Zero}
+    left := amount
+    for i := range order.PaymentSplits {
+        split := &order.PaymentSplits[i]
+        share := decimal.Min(left, order.splitRefundable(i))
+        if !share.IsPositive() {
+            continue
+        }
+        resp, ok := sendRefund(c, order, RefundRequest{
+            OrderID:        order.OrderID,
+            PaymentID:      split.PaymentID,
+            IdempotencyKey: fmt.Sprintf("%s-%d", key, i+1),
+        }, share)
+        if !ok {
+            if len(refund.Parts) > 0 {
+                order.recordRefund(refund)
+                if err := orders.Save(order); err != nil {
+                    loggerFrom(c.Request.Context()).Error("failed to save partial refund", "order_id", order.OrderID, "amount", refund.Amount.String(), "error", err)
+                }
+            }
+            return false
+        }
+        if len(refund.Parts) == 0 {
+            refund.RefundID = resp.RefundID
+        }
+        refund.Amount = refund.Amount.Add(share)
+        refund.Parts = append(refund.Parts, RefundPart{PaymentID: *split.PaymentID, RefundID: resp.RefundID, Amount: share})
+        if left = left.Sub(share); !left.IsPositive() {
+            break
+        }
+    }
+    order.recordRefund(refund)
+    return true
+}
+
+// sendRefund asks the payment service to refund amount of order as req,
+// filling in the amount and currency. On failure it writes the error
+// response and returns false.
+func sendRefund(c *gin.Context, order *Order, req RefundRequest, amount decimal.Decimal) (*RefundResponse, bool) {
+    req.Amount, req.Currency = order.chargeAmount(amount)
+    resp, err := payments.refundPayment(c.Request.Context(), req)
+    if errors.Is(err, ErrCircuitOpen) {
+        respondError(c, http.StatusServiceUnavailable, CodePaymentUnavailable, "Payment service unavailable")
+        return nil, false
+    }
+    if err != nil {
+        respondError(c, http.StatusBadGateway, CodeRefundFailed, "Refund failed")
+        return nil, false
+    }
+    if resp.Status != refundStatusRefunded {
+        respondError(c, http.StatusBadGateway, CodeRefundFailed, fmt.Sprintf("Refund %s by payment service", resp.Status))
+        return nil, false
+    }
+    return resp, true
+}
+
+// recordRefund adds refund to the refunds order is made up of.
+func (o *Order) recordRefund(refund Refund) {
+    refund.RefundedAt = clock.Now()
+    o.RefundedAmount = o.RefundedAmount.Add(refund.Amount)
+    o.Refunds = append(o.Refunds[:len(o.Refunds):len(o.Refunds)], refund)
+}
+
+// splitRefundable is how much of order's i'th split can still be refunded:
+// what an approved part was charged, less what has been refunded from it.
+func (o *Order) splitRefundable(i int) decimal.Decimal {
+    split := &o.PaymentSplits[i]
+    if split.Status != SplitApproved || split.PaymentID == nil {
+        return decimal.Zero
+    }
+    left := split.Amount
+    for _, refund := range o.Refunds {
+        for _, part := range refund.Parts {
+            if part.PaymentID == *split.PaymentID {
+                left = left.Sub(part.Amount)
+            }
+        }
+    }
+    return left
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+func refund(t *testing.T, r http.Handler, order *Order, body string) (int, Order) {
+    t.Helper()
+
+    w := doRequest(r, http.MethodPost, "/orders/"+order.OrderID.String()+"/refund", body)
+    var got Order
+    json.Unmarshal(w.Body.Bytes(), &got)
+    return w.Code, got
+}
+
+func TestRefundFull(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    order := saveOrderWithStatus(StatusConfirmed)
+    r := setupRouter()
+
+    code, got := refund(t, r, order, "")
+    if code != http.StatusOK {
+        t.Fatalf("got status %d", code)
+    }
+    if got.Status != StatusRefunded || !got.RefundedAmount.Equal(order.TotalAmount) {
+        t.Fatalf("got status %q refunded %s, want refunded %s", got.Status, got.RefundedAmount, order.TotalAmount)
+    }
+    if n := fake.refunds.Load(); n != 1 {
+        t.Fatalf("got %d refund calls, want 1", n)
+    }
+}
+
+func TestRefundPartialThenRemainder(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    order := saveOrderWithStatus(StatusConfirmed)
+    r := setupRouter()
+
+    code, got := refund(t, r, order, `{"amount":"20.00"}`)
+    if code != http.StatusOK || got.Status != StatusConfirmed || got.RefundedAmount.String() != "20" {
+        t.Fatalf("partial refund: status %d, order %q refunded %s", code, got.Status, got.RefundedAmount)
+    }
+
+    code, got = refund(t, r, order, "")
+    if code != http.StatusOK || got.Status != StatusRefunded || got.RefundedAmount.String() != "59.98" {
+        t.Fatalf("remainder refund: status %d, order %q refunded %s", code, got.Status, got.RefundedAmount)
+    }
+}
+
+func TestRefundRejectsOverRefund(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    order := saveOrderWithStatus(StatusConfirmed)
+    r := setupRouter()
+
+    refund(t, r, order, `{"amount":"50"}`)
+    for _, body := range []string{`{"amount":"10"}`, `{"amount":"0"}`, `{"amount":"1.001"}`} {
+        if code, _ := refund(t, r, order, body); code != http.StatusUnprocessableEntity {
+            t.Errorf("%s: got status %d, want 422", body, code)
+        }
+    }
+    if n := fake.refunds.Load(); n != 1 {
+        t.Fatalf("got %d refund calls, want 1", n)
+    }
+    stored, _ := orders.FindByID(order.OrderID)
+    if stored.RefundedAmount.String() != "50" {
+        t.Fatalf("refunded amount changed to %s", stored.RefundedAmount)
+    }
+}
+
+func TestRefundUnpaidOrder(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    order := saveOrderWithStatus(StatusPending)
+    r := setupRouter()
+
+    if code, _ := refund(t, r, order, ""); code != http.StatusConflict {
+        t.Fatalf("got status %d, want 409", code)
+    }
+}
+
+// createTwoLineOrder places and pays for two prod_a at 10.00 and one prod_b
+// at 5.00, with 10% off: 22.50 in all.
+func createTwoLineOrder(t *testing.T, r http.Handler) *Order {
+    t.Helper()
+
+    body := `{"customer_id":"cust_123","discount":{"type":"percentage","value":"10"},"items":[
+        {"product_id":"prod_a","quantity":2,"price":"10.00"},
+        {"product_id":"prod_b","quantity":1,"price":"5.00"}]}`
+    w := doRequest(r, http.MethodPost, "/orders", body)
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if w.Code != http.StatusCreated || order.TotalAmount.String() != "22.5" {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    return &order
+}
+
+func TestRefundItemsPartial(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+    order := createTwoLineOrder(t, r)
+
+    code, got := refund(t, r, order, `{"items":[{"product_id":"prod_a","quantity":1}]}`)
+    if code != http.StatusOK {
+        t.Fatalf("got status %d", code)
+    }
+    // A tenth of the items' worth, less the order's discount.
+    if got.RefundedAmount.String() != "9" || got.Status != StatusConfirmed {
+        t.Fatalf("got order %q refunded %s, want confirmed with 9.00 refunded", got.Status, got.RefundedAmount)
+    }
+    if item := got.Items[0]; item.RefundedQuantity != 1 || item.Status != ItemPending {
+        t.Fatalf("prod_a = %+v, want one unit refunded and still pending", item)
+    }
+    if refund := got.Refunds[0]; len(refund.Items) != 1 || refund.Items[0].ProductID != "prod_a" {
+        t.Fatalf("refund = %+v, want it to record prod_a", refund)
+    }
+}
+
+func TestRefundItemsFull(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+    order := createTwoLineOrder(t, r)
+
+    refund(t, r, order, `{"items":[{"product_id":"prod_a","quantity":1}]}`)
+    code, got := refund(t, r, order, `{"items":[{"product_id":"prod_b","quantity":1},{"product_id":"prod_a","quantity":1}]}`)
+    if code != http.StatusOK {
+        t.Fatalf("got status %d", code)
+    }
+    if got.Status != StatusRefunded || !got.RefundedAmount.Equal(got.TotalAmount) {
+        t.Fatalf("got order %q refunded %s, want refunded in full", got.Status, got.RefundedAmount)
+    }
+    if got.Refunds[1].Amount.String() != "13.5" {
+        t.Fatalf("second refund was %s, want the remaining 13.50", got.Refunds[1].Amount)
+    }
+    for _, item := range got.Items {
+        if item.Status != ItemRefunded || item.RefundedQuantity != item.Quantity {
+            t.Fatalf("item %+v, want every unit refunded", item)
+        }
+    }
+    if n := fake.refunds.Load(); n != 2 {
+        t.Fatalf("got %d refund calls, want 2", n)
+    }
+}
+
+func TestRefundItemsRejectsOverRefund(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+    order := createTwoLineOrder(t, r)
+    refund(t, r, order, `{"items":[{"product_id":"prod_a","quantity":1}]}`)
+
+    for _, body := range []string{
+        `{"items":[{"product_id":"prod_a","quantity":2}]}`,
+        `{"items":[{"product_id":"prod_a","quantity":1},{"product_id":"prod_a","quantity":1}]}`,
+        `{"items":[{"product_id":"prod_c","quantity":1}]}`,
+        `{"items":[{"product_id":"prod_b","quantity":0}]}`,
+        `{"amount":"1.00","items":[{"product_id":"prod_b","quantity":1}]}`,
+    } {
+        if code, _ := refund(t, r, order, body); code != http.StatusUnprocessableEntity {
+            t.Errorf("%s: got status %d, want 422", body, code)
+        }
+    }
+    if n := fake.refunds.Load(); n != 1 {
+        t.Fatalf("got %d refund calls, want only the first", n)
+    }
+    stored, _ := orders.FindByID(order.OrderID)
+    if stored.Items[0].RefundedQuantity != 1 || stored.RefundedAmount.String() != "9" {
+        t.Fatalf("refunds changed to %d units, %s", stored.Items[0].RefundedQuantity, stored.RefundedAmount)
+    }
+}
+
+func TestConcurrentRefundsRefundOnce(t *testing.T) {
+    fake := newPaymentServer(t)
+    fake.refundDelay = 20 * time.Millisecond
+    resetOrders(t)
+    order := saveOrderWithStatus(StatusConfirmed)
+    r := setupRouter()
+
+    var wg sync.WaitGroup
+    codes := make(chan int, 5)
+    for i := 0; i < cap(codes); i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            code, _ := refund(t, r, order, "")
+            codes <- code
+        }()
+    }
+    wg.Wait()
+    close(codes)
+
+    ok := 0
+    for code := range codes {
+        switch code {
+        case http.StatusOK:
+            ok++
+        case http.StatusConflict:
+        default:
+            t.Errorf("got status %d", code)
+        }
+    }
+    if n := fake.refunds.Load(); ok != 1 || n != 1 {
+        t.Fatalf("%d refunds succeeded with %d refund calls, want 1 of each", ok, n)
+    }
+    if stored, _ := orders.FindByID(order.OrderID); !stored.RefundedAmount.Equal(order.TotalAmount) || len(stored.Refunds) != 1 {
+        t.Fatalf("stored refunded %s in %d refunds", stored.RefundedAmount, len(stored.Refunds))
+    }
+}
+
+// TestRefundKeyReusedAcrossRetries has the payment service fail each
+// refund's first attempt, as a lost response would look, and checks the
+// retry is sent under the same key while the next refund gets its own.
+func TestRefundKeyReusedAcrossRetries(t *testing.T) {
+    var (
+        mu   sync.Mutex
+        keys []string
+    )
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var req RefundRequest
+        json.NewDecoder(r.Body).Decode(&req)
+        mu.Lock()
+        keys = append(keys, req.IdempotencyKey)
+        first := len(keys)%2 == 1
+        mu.Unlock()
+        if first {
+            http.Error(w, "unavailable", http.StatusServiceUnavailable)
+            return
+        }
+        json.NewEncoder(w).Encode(RefundResponse{RefundID: uuid.New(), OrderID: req.OrderID, Amount: req.Amount, Status: refundStatusRefunded})
+    }))
+    defer srv.Close()
+    var delays []time.Duration
+    prev := payments
+    payments = newTestPaymentClient(srv.URL, &delays)
+    t.Cleanup(func() { payments = prev })
+    resetOrders(t)
+    order := saveOrderWithStatus(StatusConfirmed)
+    r := setupRouter()
+
+    for _, body := range []string{`{"amount":"20.00"}`, ""} {
+        if code, _ := refund(t, r, order, body); code != http.StatusOK {
+            t.Fatalf("refund %q: got status %d", body, code)
+        }
+    }
+    if len(keys) != 4 || keys[0] == "" || keys[0] != keys[1] || keys[2] != keys[3] || keys[0] == keys[2] {
+        t.Fatalf("got keys %q, want each refund's key sent twice", keys)
+    }
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// ErrOrderNotFound is returned by an OrderRepository when no order has the
+// requested ID.
+var ErrOrderNotFound = errors.New("order not found")
+
+// ErrVersionConflict is returned by Save when the order was changed since
+// the caller read it.
+var ErrVersionConflict = errors.New("order was modified concurrently")
+
+// OrderRepository persists orders. Implementations must be safe for
+// concurrent use and must not share *Order values with callers.
+type OrderRepository interface {
+    // Save stores order if its Version is the one stored (0 for a new
+    // order) and increments order.Version; otherwise it returns
+    // ErrVersionConflict and stores nothing.
+    Save(order *Order) error
+    FindByID(id uuid.UUID) (*Order, error)
+    // List returns every order, newest first, ties broken by ID.
+    List() ([]*Order, error)
+    // ListByCustomer returns the customer's orders in the same order as List.
+    ListByCustomer(customerID string) ([]*Order, error)
+    // ListByTags returns the orders carrying every one of tags, in the same
+    // order as List.
+    ListByTags(tags []string) ([]*Order, error)
+    // ListUpdatedSince returns the orders saved at or after since, least
+    // recently updated first, ties broken by ID.
+    ListUpdatedSince(since time.Time) ([]*Order, error)
+    Delete(id uuid.UUID) error
+    // Summary counts the orders created between the from and to dates
+    // (YYYY-MM-DD, inclusive; "" leaves a bound open), from counters kept
+    // as orders are saved rather than by reading them.
+    Summary(from, to string) (OrderSummary, error)
+    // Generation returns a number that changes whenever an order is saved
+    // or deleted through this repository, so results derived from it can
+    // be cached until it does.
+    Generation() uint64
+}
+
+// openRepository builds the repository selected by ORDER_STORE: "memory"
+// (the default) or "sqlite", whose database file is ORDER_DB_PATH and
+// which encrypts orders if ORDER_ENCRYPTION_KEY is set.
+func openRepository() (OrderRepository, error) {
+    switch kind := os.Getenv("ORDER_STORE"); kind {
+    case "", "memory":
+        return NewOrderStore(), nil
+    case "sqlite":
+        path := os.Getenv("ORDER_DB_PATH")
+        if path == "" {
+            path = "orders.db"
+        }
+        c, err := fieldCipherFromEnv()
+        if err != nil {
+            return nil, err
+        }
+        return OpenEncryptedSQLiteRepository(path, c)
+    default:
+        return nil, fmt.Errorf("ORDER_STORE must be memory or sqlite, got %q", kind)
+    }
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+    "sync"
+    "time"
+)
+
+const (
+    defaultRetryBudgetPercent = 10
+    defaultRetryBudgetWindow  = 10 * time.Second
+    defaultRetryBudgetMin     = 10
+
+    // retryBudgetBuckets is how finely the window slides.
+    retryBudgetBuckets = 10
+)
+
+// RetryBudget caps retries at a fraction of requests over a sliding window,
+// so that while a dependency is down, retries don't multiply the load on it.
+// Individual calls still retry as usual while the budget lasts; once it is
+// spent they fail on their first error until enough of the window slides
+// by.
+type RetryBudget struct {
+    mu sync.Mutex
+    // ratio is the retries allowed per request; min is allowed per window
+    // regardless, so that a quiet service can still retry.
+    ratio  float64
+    min    int
+    bucket time.Duration
+    now    func() time.Time
+
+    // requests and retries are counted per bucket of the window, oldest
+    // first; start is when the newest bucket began.
+    requests [retryBudgetBuckets]int
+    retries  [retryBudgetBuckets]int
+    start    time.Time
+}
+
+func NewRetryBudget(ratio float64, window time.Duration, min int) *RetryBudget {
+    bucket := window / retryBudgetBuckets
+    if bucket <= 0 {
+        bucket = 1
+    }
+    return &RetryBudget{
+        ratio:  ratio,
+        min:    min,
+        bucket: bucket,
+        now:    currentTime,
+    }
+}
+
+// advance slides the window up to now. The caller holds b.mu.
+func (b *RetryBudget) advance() {
+    now := b.now()
+    if b.start.IsZero() {
+        b.start = now
+        return
+    }
+    n := int(now.Sub(b.start) / b.bucket)
+    if n <= 0 {
+        return
+    }
+    if n > retryBudgetBuckets {
+        n = retryBudgetBuckets
+    }
+    copy(b.requests[:], b.requests[n:])
+    copy(b.retries[:], b.retries[n:])
+    for i := retryBudgetBuckets - n; i < retryBudgetBuckets; i++ {
+        b.requests[i], b.retries[i] = 0, 0
+    }
+    b.start = b.start.Add(now.Sub(b.start).Truncate(b.bucket))
+}
+
+// totals returns the requests and retries in the window and the retries it
+// allows. The caller holds b.mu.
+func (b *RetryBudget) totals() (requests, retries, allowed int) {
+    for i := range b.requests {
+        requests += b.requests[i]
+        retries += b.retries[i]
+    }
+    allowed = int(float64(requests) * b.ratio)
+    if allowed < b.min {
+        allowed = b.min
+    }
+    return requests, retries, allowed
+}
+
+// RecordRequest counts a first attempt, which earns the budget retries.
+func (b *RetryBudget) RecordRequest() {
+    if b == nil {
+        return
+    }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    b.advance()
+    b.requests[retryBudgetBuckets-1]++
+}
+
+// TryRetry reports whether a retry fits in the budget, and if so spends it.
+// A nil budget allows every retry.
+func (b *RetryBudget) TryRetry() bool {
+    if b == nil {
+        return true
+    }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    b.advance()
+    _, retries, allowed := b.totals()
+    if retries >= allowed {
+        return false
+    }
+    b.retries[retryBudgetBuckets-1]++
+    return true
+}
+
+// Used returns the fraction of the budget spent in the current window: 1
+// means retries are being suppressed.
+func (b *RetryBudget) Used() float64 {
+    if b == nil {
+        return 0
+    }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    b.advance()
+    _, retries, allowed := b.totals()
+    if allowed == 0 {
+        return 1
+    }
+    return float64(retries) / float64(allowed)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestRetryBudgetExhaustsAndRecovers(t *testing.T) {
+    now := time.Now()
+    b := NewRetryBudget(0.1, 10*time.Second, 2)
+    b.now = func() time.Time { return now }
+
+    // With no traffic only the minimum is allowed.
+    for i := 0; i < 2; i++ {
+        if !b.TryRetry() {
+            t.Fatalf("retry %d within the minimum was suppressed", i)
+        }
+    }
+    if b.TryRetry() {
+        t.Fatal("retry beyond the minimum was allowed")
+    }
+    if got := b.Used(); got != 1 {
+        t.Fatalf("Used() = %v, want 1", got)
+    }
+
+    // 50 requests earn 5 retries, 3 more than already spent.
+    for i := 0; i < 50; i++ {
+        b.RecordRequest()
+    }
+    for i := 0; i < 3; i++ {
+        if !b.TryRetry() {
+            t.Fatalf("retry %d earned by requests was suppressed", i)
+        }
+    }
+    if b.TryRetry() {
+        t.Fatal("retry beyond 10% of requests was allowed")
+    }
+
+    // Half a window later everything is still counted...
+    now = now.Add(5 * time.Second)
+    if b.TryRetry() {
+        t.Fatal("retry allowed before the window slid past the spent ones")
+    }
+    // ...and once the window has passed, the budget is back to the minimum.
+    now = now.Add(5 * time.Second)
+    if got := b.Used(); got != 0 {
+        t.Fatalf("Used() after the window = %v, want 0", got)
+    }
+    if !b.TryRetry() {
+        t.Fatal("retry suppressed after the window slid")
+    }
+}
+
+func TestNilRetryBudgetAllowsEverything(t *testing.T) {
+    var b *RetryBudget
+    b.RecordRequest()
+    if !b.TryRetry() || b.Used() != 0 {
+        t.Fatal("nil budget limited retries")
+    }
+}
+
+func TestPaymentClientSkipsRetriesWhenBudgetSpent(t *testing.T) {
+    var calls atomic.Int64
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        calls.Add(1)
+        http.Error(w, "unavailable", http.StatusServiceUnavailable)
+    }))
+    defer srv.Close()
+
+    var delays []time.Duration
+    p := newTestPaymentClient(srv.URL, &delays)
+    p.Breaker = NewCircuitBreaker(1000, time.Minute)
+    p.Budget = NewRetryBudget(0.1, time.Minute, 4)
+
+    // The first two calls spend the minimum budget of 4 retries between
+    // them; the rest fail on their first attempt.
+    for i := 0; i < 10; i++ {
+        p.processPayment(context.Background(), PaymentRequest{})
+    }
+    if n := len(delays); n != 4 {
+        t.Fatalf("got %d retries, want 4", n)
+    }
+    if n := calls.Load(); n != 10+4 {
+        t.Fatalf("got %d attempts, want 14", n)
+    }
+
+    _, err := p.processPayment(context.Background(), PaymentRequest{})
+    if err == nil || !strings.Contains(err.Error(), "retry budget exhausted") {
+        t.Fatalf("got error %v, want retry budget exhausted", err)
+    }
+}
+
+func TestRetryBudgetMetrics(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        http.Error(w, "unavailable", http.StatusServiceUnavailable)
+    }))
+    defer srv.Close()
+    prev := payments
+    payments = NewPaymentClient(srv.URL)
+    payments.Budget = NewRetryBudget(0, time.Minute, 0)
+    t.Cleanup(func() { payments = prev })
+    resetOrders(t)
+    r := setupRouter()
+
+    before := scrapeMetrics(t, r)
+    doRequest(r, http.MethodPost, "/orders", sampleOrder)
+
+    after := scrapeMetrics(t, r)
+    suppressed := `payment_retries_total{result="suppressed"}`
+    if got := after[suppressed] - before[suppressed]; got != 1 {
+        t.Fatalf("suppressed retries went up by %v, want 1", got)
+    }
+    if got := after["payment_retry_budget_used_ratio"]; got != 1 {
+        t.Fatalf("payment_retry_budget_used_ratio = %v, want 1", got)
+    }
+}
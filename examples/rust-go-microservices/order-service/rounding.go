@@ -0,0 +1,92 @@
+package main
+
+import (
+    "fmt"
+    "os"
+
+    "github.com/shopspring/decimal"
+)
+
+// RoundingMode says how an order total with more precision than its
+// currency allows is rounded to the currency's minor units.
+type RoundingMode string
+
+const (
+    RoundHalfEven RoundingMode = "half-even"
+    RoundHalfUp   RoundingMode = "half-up"
+    RoundUp       RoundingMode = "up"
+    RoundDown     RoundingMode = "down"
+    RoundCeiling  RoundingMode = "ceiling"
+    RoundFloor    RoundingMode = "floor"
+)
+
+// roundingMode is applied to every order total. Half-even (banker's
+// rounding) avoids the upward bias of half-up across many orders.
+var roundingMode = RoundHalfEven
+
+// round rounds d to places decimal places.
+func (m RoundingMode) round(d decimal.Decimal, places int32) decimal.Decimal {
+    switch m {
+    case RoundHalfUp:
+        return d.Round(places)
+    case RoundUp:
+        return d.RoundUp(places)
+    case RoundDown:
+        return d.RoundDown(places)
+    case RoundCeiling:
+        return d.RoundCeil(places)
+    case RoundFloor:
+        return d.RoundFloor(places)
+    default:
+        return d.RoundBank(places)
+    }
+}
+
+func parseRoundingMode(s string) (RoundingMode, error) {
+    switch m := RoundingMode(s); m {
+    case RoundHalfEven, RoundHalfUp, RoundUp, RoundDown, RoundCeiling, RoundFloor:
+        return m, nil
+    }
+    return "", fmt.Errorf("unknown rounding mode %q", s)
+}
+
+// roundingModeFromEnv reads TOTAL_ROUNDING_MODE, defaulting to half-even.
+func roundingModeFromEnv() (RoundingMode, error) {
+    raw := os.Getenv("TOTAL_ROUNDING_MODE")
+    if raw == "" {
+        return RoundHalfEven, nil
+    }
+    m, err := parseRoundingMode(raw)
+    if err != nil {
+        return "", fmt.Errorf("TOTAL_ROUNDING_MODE: %w", err)
+    }
+    return m, nil
+}
+
+// calculateTotal sums the order's line items and applies its discounts at
+// full precision (see Discount for the order of operations), then rounds
+// the result once to the currency's minor units; rounding each line instead
+// would let the errors add up.
+func calculateTotal(order *Order) decimal.Decimal {
+    total := itemsTotal(order.Items)
+    total = total.Sub(order.Discount.off(total))
+    return roundingMode.round(total, minorUnits(order.Currency))
+}
+
+// itemsTotal sums the discounted totals of items, unrounded.
+func itemsTotal(items []OrderItem) decimal.Decimal {
+    total := decimal.Zero
+    for _, item := range items {
+        total = total.Add(item.lineTotal())
+    }
+    return total
+}
+
+// minorUnits is the number of decimal places amounts in currency are
+// rounded to, falling back to 2 for an unknown currency.
+func minorUnits(currency string) int32 {
+    if c, ok := lookupCurrency(currency); ok {
+        return c.MinorUnits
+    }
+    return 2
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+
+    "github.com/shopspring/decimal"
+)
+
+func itemsAt(currency string, lines ...string) *Order {
+    order := &Order{Currency: currency}
+    for _, price := range lines {
+        order.Items = append(order.Items, OrderItem{ProductID: "p", Quantity: 1, Price: decimal.RequireFromString(price)})
+    }
+    return order
+}
+
+func TestCalculateTotalRounding(t *testing.T) {
+    tests := []struct {
+        name  string
+        mode  RoundingMode
+        order *Order
+        want  string
+    }{
+        {"half-even rounds to even", RoundHalfEven, itemsAt("USD", "0.015"), "0.02"},
+        {"half-even rounds down to even", RoundHalfEven, itemsAt("USD", "0.025"), "0.02"},
+        {"half-up", RoundHalfUp, itemsAt("USD", "0.025"), "0.03"},
+        {"down", RoundDown, itemsAt("USD", "0.019"), "0.01"},
+        {"up", RoundUp, itemsAt("USD", "0.011"), "0.02"},
+        {"JPY has no minor units", RoundHalfEven, itemsAt("JPY", "100.5"), "100"},
+        {"BHD has three", RoundHalfEven, itemsAt("BHD", "1.0005"), "1"},
+        // Rounding each line would give 0.06.
+        {"rounded once on the total", RoundHalfEven, itemsAt("USD", "0.015", "0.015", "0.015"), "0.04"},
+        {"quantity", RoundHalfEven, &Order{Currency: "USD", Items: []OrderItem{
+            {ProductID: "p", Quantity: 7, Price: decimal.RequireFromString("0.015")},
+        }}, "0.1"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            prev := roundingMode
+            roundingMode = tt.mode
+            t.Cleanup(func() { roundingMode = prev })
+
+            if got := calculateTotal(tt.order); !got.Equal(decimal.RequireFromString(tt.want)) {
+                t.Fatalf("got %s, want %s", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestParseRoundingMode(t *testing.T) {
+    if m, err := parseRoundingMode("half-up"); err != nil || m != RoundHalfUp {
+        t.Fatalf("got %q, %v", m, err)
+    }
+    if _, err := parseRoundingMode("nearest"); err == nil {
+        t.Fatal("accepted an unknown rounding mode")
+    }
+}
+
+func TestCreateOrderChargesRoundedTotal(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    body := `{"customer_id":"c","items":[{"product_id":"p","quantity":3,"price":"0.015"}]}`
+    if w := doRequest(r, http.MethodPost, "/orders", body); w.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    if got := fake.lastCharge.Load().Amount; !got.Equal(decimal.RequireFromString("0.04")) {
+        t.Fatalf("charged %s, want 0.04", got)
+    }
+}
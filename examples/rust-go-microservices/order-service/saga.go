@@ -0,0 +1,26 @@
+package main
+
+import "context"
+
+// saga tracks the compensating actions for the steps of a multi-service
+// operation that have already succeeded, so that a later failure can undo
+// them in reverse order.
+type saga struct {
+    compensations []func(context.Context)
+}
+
+// onRollback registers the compensation for a step that just succeeded.
+func (s *saga) onRollback(undo func(context.Context)) {
+    s.compensations = append(s.compensations, undo)
+}
+
+// rollback runs the registered compensations, newest first. They run even if
+// ctx has been cancelled, since the client going away is often why we're
+// rolling back.
+func (s *saga) rollback(ctx context.Context) {
+    ctx = context.WithoutCancel(ctx)
+    for i := len(s.compensations) - 1; i >= 0; i-- {
+        s.compensations[i](ctx)
+    }
+    s.compensations = nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+const (
+    defaultSchedulerInterval    = time.Minute
+    defaultSchedulerMaxAttempts = 5
+    // maxSchedulerBackoff caps the wait between attempts at charging a
+    // rescheduled order.
+    maxSchedulerBackoff = time.Hour
+)
+
+// OrderScheduler charges scheduled orders once their ScheduledFor time has
+// come, just as a new order would be charged: stock is reserved, then the
+// payment service is asked for the money.
+type OrderScheduler struct {
+    Interval time.Duration
+    // MaxAttempts is how many times an order is tried while the payment
+    // service can't be reached before it fails.
+    MaxAttempts int
+
+    now   func() time.Time
+    after func(d time.Duration) <-chan time.Time
+}
+
+func NewOrderScheduler(interval time.Duration, maxAttempts int) *OrderScheduler {
+    return &OrderScheduler{Interval: interval, MaxAttempts: maxAttempts, now: currentTime, after: time.After}
+}
+
+// newOrderSchedulerFromEnv reads SCHEDULER_INTERVAL and
+// SCHEDULER_MAX_ATTEMPTS.
+func newOrderSchedulerFromEnv() (*OrderScheduler, error) {
+    interval, err := envDuration("SCHEDULER_INTERVAL", defaultSchedulerInterval)
+    if err != nil {
+        return nil, err
+    }
+    maxAttempts, err := envInt("SCHEDULER_MAX_ATTEMPTS", defaultSchedulerMaxAttempts)
+    if err != nil {
+        return nil, err
+    }
+    if maxAttempts < 1 {
+        return nil, fmt.Errorf("SCHEDULER_MAX_ATTEMPTS must be at least 1, got %d", maxAttempts)
+    }
+    return NewOrderScheduler(interval, maxAttempts), nil
+}
+
+// Run processes due orders every Interval until ctx is done.
+func (s *OrderScheduler) Run(ctx context.Context) {
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-s.after(s.Interval):
+        }
+        if n, err := s.runOnce(ctx); err != nil {
+            logger.Error("order scheduler failed", "error", err)
+        } else if n > 0 {
+            logger.Info("processed scheduled orders", "processed", n)
+        }
+    }
+}
+
+// runOnce charges every scheduled order that is due and returns how many it
+// processed, whatever the outcome of their payment. It stops early once ctx
+// is done, leaving the rest for the next run.
+func (s *OrderScheduler) runOnce(ctx context.Context) (int, error) {
+    all, err := orders.List()
+    if err != nil {
+        return 0, err
+    }
+
+    processed := 0
+    for _, order := range all {
+        if ctx.Err() != nil {
+            return processed, nil
+        }
+        if !s.due(order) {
+            continue
+        }
+        ok, err := s.process(ctx, order.OrderID)
+        if err != nil {
+            logger.Warn("failed to process scheduled order", "order_id", order.OrderID, "error", err)
+            continue
+        }
+        if ok {
+            processed++
+        }
+    }
+    return processed, nil
+}
+
+func (s *OrderScheduler) due(order *Order) bool {
+    if order.Status != StatusScheduled || order.DeletedAt != nil {
+        return false
+    }
+    now := s.now()
+    if at, ok := s.retryAt(order); ok && now.Before(at) {
+        return false
+    }
+    return order.ScheduledFor == nil || !now.Before(*order.ScheduledFor)
+}
+
+// process charges one due order under its lock, re-reading it first in
+// case it was cancelled in the meantime, and reports whether it did. An
+// order that couldn't be charged because the payment service couldn't be
+// reached is put back to be tried again after a backoff, until MaxAttempts
+// is used up; one the payment service rejected fails, and one whose items
+// are out of stock is cancelled.
+func (s *OrderScheduler) process(ctx context.Context, id uuid.UUID) (bool, error) {
+    unlock := orderLocks.lock(id)
+    defer unlock()
+
+    order, err := orders.FindByID(id)
+    if err != nil {
+        return false, err
+    }
+    if !s.due(order) {
+        return false, nil
+    }
+
+    // A charge, once started, runs to the end even if the service is
+    // shutting down, so the order isn't left not knowing if it was paid.
+    ctx = context.WithoutCancel(ctx)
+    now := s.now()
+    expiresAt := now.Add(pendingOrderTTL)
+    order.ExpiresAt = &expiresAt
+    transitionStatus(order, StatusPending, "scheduled time reached")
+    var chargeErr error
+    abandon := func(err error) { chargeErr = err }
+
+    rerr := chargeOrder(ctx, order, abandon, "")
+    switch {
+    case rerr == nil:
+        return true, nil
+    case rerr.Code == CodeOutOfStock:
+        return true, s.cancel(id, rerr.Message)
+    case chargeErr == nil:
+        // Nothing was stored or charged, so the order is still scheduled
+        // and is tried again next run.
+        return false, fmt.Errorf("%s: %s", rerr.Code, rerr.Message)
+    }
+
+    // The order was stored as pending before the charge; start again from
+    // that rather than whatever the failed charge left in memory.
+    order, err = orders.FindByID(id)
+    if err != nil {
+        return false, err
+    }
+    n, _ := reschedules(order)
+    attempts := n + 1
+    if paymentUnreachable(chargeErr) && attempts < s.MaxAttempts {
+        order.ExpiresAt = nil
+        transitionStatus(order, StatusScheduled, "payment service unavailable")
+        if err := orders.Save(order); err != nil {
+            return false, err
+        }
+        return false, fmt.Errorf("attempt %d of %d: %w", attempts, s.MaxAttempts, chargeErr)
+    }
+
+    logger.Warn("scheduled order payment failed", "order_id", id, "attempts", attempts, "error", chargeErr)
+    transitionStatus(order, StatusPaymentFailed, fmt.Sprintf("scheduled payment failed after %d attempts", attempts))
+    releaseStock(ctx, order)
+    if err := saveAndPublish(ctx, order, settlementEvent(order)); err != nil {
+        return false, err
+    }
+    recordSettlement(order)
+    return true, nil
+}
+
+// reschedules counts the times order was put back to scheduled after a
+// charge attempt couldn't reach the payment service, and returns when it
+// last was.
+func reschedules(order *Order) (n int, last time.Time) {
+    for _, change := range order.StatusHistory {
+        if change.From == StatusPending && change.To == StatusScheduled {
+            n++
+            last = change.At
+        }
+    }
+    return n, last
+}
+
+// retryAt returns when a rescheduled order may next be tried: Interval
+// after the first time it was put back, doubling each time after that, up
+// to maxSchedulerBackoff.
+func (s *OrderScheduler) retryAt(order *Order) (time.Time, bool) {
+    n, last := reschedules(order)
+    if n == 0 {
+        return time.Time{}, false
+    }
+    backoff := s.Interval
+    for i := 1; i < n && backoff < maxSchedulerBackoff; i++ {
+        backoff *= 2
+    }
+    return last.Add(min(backoff, maxSchedulerBackoff)), true
+}
+
+// cancel cancels a scheduled order that can't be fulfilled. The caller
+// holds the order's lock.
+func (s *OrderScheduler) cancel(id uuid.UUID, reason string) error {
+    order, err := orders.FindByID(id)
+    if err != nil {
+        return err
+    }
+    if order.Status != StatusScheduled {
+        return nil
+    }
+    transitionStatus(order, StatusCancelled, reason)
+    return orders.Save(order)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func newTestOrderScheduler(now time.Time) *OrderScheduler {
+    s := NewOrderScheduler(time.Minute, defaultSchedulerMaxAttempts)
+    s.now = func() time.Time { return now }
+    return s
+}
+
+func scheduledOrderBody(at time.Time) string {
+    return fmt.Sprintf(`{"customer_id":"cust_123","items":[{"product_id":"prod_456","quantity":2,"price":"29.99"}],"scheduled_for":%q}`,
+        at.Format(time.RFC3339Nano))
+}
+
+func TestUnscheduledOrderIsChargedImmediately(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    var order Order
+    json.Unmarshal(doRequest(r, http.MethodPost, "/orders", sampleOrder).Body.Bytes(), &order)
+    if order.Status != StatusConfirmed || order.ScheduledFor != nil {
+        t.Fatalf("got status %s, scheduled_for %v; want confirmed and unscheduled", order.Status, order.ScheduledFor)
+    }
+    if fake.charges.Load() != 1 {
+        t.Fatalf("got %d charges, want 1", fake.charges.Load())
+    }
+}
+
+func TestFutureScheduledOrderIsChargedByScheduler(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+    at := time.Now().Add(time.Hour)
+
+    w := doRequest(r, http.MethodPost, "/orders", scheduledOrderBody(at))
+    if w.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if order.Status != StatusScheduled || order.ExpiresAt != nil {
+        t.Fatalf("got status %s, expires_at %v; want scheduled and not expiring", order.Status, order.ExpiresAt)
+    }
+    if order.ScheduledFor == nil || !order.ScheduledFor.Equal(at) {
+        t.Fatalf("got scheduled_for %v, want %v", order.ScheduledFor, at)
+    }
+    if fake.charges.Load() != 0 {
+        t.Fatalf("scheduled order was charged on creation")
+    }
+
+    if n, err := newTestOrderScheduler(at.Add(-time.Minute)).runOnce(context.Background()); err != nil || n != 0 {
+        t.Fatalf("before its time: got %d processed, %v; want 0", n, err)
+    }
+    if n, err := newTestOrderScheduler(at).runOnce(context.Background()); err != nil || n != 1 {
+        t.Fatalf("at its time: got %d processed, %v; want 1", n, err)
+    }
+    if fake.charges.Load() != 1 {
+        t.Fatalf("got %d charges, want 1", fake.charges.Load())
+    }
+
+    var got Order
+    json.Unmarshal(doRequest(r, http.MethodGet, "/orders/"+order.OrderID.String(), "").Body.Bytes(), &got)
+    if got.Status != StatusConfirmed {
+        t.Fatalf("got status %s, want confirmed", got.Status)
+    }
+    var path []string
+    for _, change := range got.StatusHistory {
+        path = append(path, change.To)
+    }
+    if fmt.Sprint(path) != fmt.Sprint([]string{StatusScheduled, StatusPending, StatusConfirmed}) {
+        t.Fatalf("got status history %v", path)
+    }
+
+    if n, _ := newTestOrderScheduler(at.Add(time.Hour)).runOnce(context.Background()); n != 0 || fake.charges.Load() != 1 {
+        t.Fatalf("processed order charged again: %d processed, %d charges", n, fake.charges.Load())
+    }
+}
+
+func TestPastScheduledOrderIsChargedImmediately(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    var order Order
+    json.Unmarshal(doRequest(r, http.MethodPost, "/orders", scheduledOrderBody(time.Now().Add(-time.Hour))).Body.Bytes(), &order)
+    if order.Status != StatusConfirmed || order.ScheduledFor != nil {
+        t.Fatalf("got status %s, scheduled_for %v; want confirmed and unscheduled", order.Status, order.ScheduledFor)
+    }
+    if fake.charges.Load() != 1 {
+        t.Fatalf("got %d charges, want 1", fake.charges.Load())
+    }
+}
+
+func TestScheduledOrderCanBeCancelled(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+    at := time.Now().Add(time.Hour)
+
+    var order Order
+    json.Unmarshal(doRequest(r, http.MethodPost, "/orders", scheduledOrderBody(at)).Body.Bytes(), &order)
+    if w := doRequestWithHeaders(r, http.MethodPost, "/orders/"+order.OrderID.String()+"/cancel", "", ifMatch(&order)); w.Code != http.StatusOK {
+        t.Fatalf("cancel: got status %d: %s", w.Code, w.Body)
+    }
+
+    if n, err := newTestOrderScheduler(at).runOnce(context.Background()); err != nil || n != 0 {
+        t.Fatalf("got %d processed, %v; want 0", n, err)
+    }
+    if fake.charges.Load() != 0 {
+        t.Fatalf("cancelled order was charged")
+    }
+}
+
+func TestSchedulerRetriesWhenPaymentUnavailable(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+    at := time.Now().Add(time.Hour)
+
+    var order Order
+    json.Unmarshal(doRequest(r, http.MethodPost, "/orders", scheduledOrderBody(at)).Body.Bytes(), &order)
+    payments.Breaker = NewCircuitBreaker(1, time.Hour)
+    payments.Breaker.Allow()
+    payments.Breaker.Record(false)
+
+    if n, _ := newTestOrderScheduler(at).runOnce(context.Background()); n != 0 {
+        t.Fatalf("got %d processed, want 0", n)
+    }
+    got, err := orders.FindByID(order.OrderID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got.Status != StatusScheduled || got.ExpiresAt != nil {
+        t.Fatalf("got status %s, expires_at %v; want scheduled again", got.Status, got.ExpiresAt)
+    }
+    if fake.charges.Load() != 0 {
+        t.Fatalf("got %d charges with the breaker open", fake.charges.Load())
+    }
+}
+
+func TestSchedulerRunStopsOnCancel(t *testing.T) {
+    s := newTestOrderScheduler(time.Now())
+    s.after = func(time.Duration) <-chan time.Time { return make(chan time.Time) }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    stopped := make(chan struct{})
+    go func() {
+        defer close(stopped)
+        s.Run(ctx)
+    }()
+    cancel()
+    select {
+    case <-stopped:
+    case <-time.After(time.Second):
+        t.Fatal("Run did not stop after cancel")
+    }
+}
+
+func TestRescheduledOrderKeepsPaymentKey(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+    at := time.Now().Add(time.Hour)
+
+    var order Order
+    json.Unmarshal(doRequest(r, http.MethodPost, "/orders", scheduledOrderBody(at)).Body.Bytes(), &order)
+    payments.Breaker = NewCircuitBreaker(1, time.Hour)
+    payments.Breaker.Allow()
+    payments.Breaker.Record(false)
+    newTestOrderScheduler(at).runOnce(context.Background())
+    rescheduled, err := orders.FindByID(order.OrderID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if rescheduled.PaymentKey == "" {
+        t.Fatal("rescheduled order has no payment key")
+    }
+
+    payments.Breaker = NewCircuitBreaker(1, time.Hour)
+    if n, err := newTestOrderScheduler(at).runOnce(context.Background()); err != nil || n != 1 {
+        t.Fatalf("got %d processed, %v; want 1", n, err)
+    }
+    if got := fake.lastCharge.Load(); got == nil || got.IdempotencyKey != rescheduled.PaymentKey {
+        t.Fatalf("charged with %+v, want key %s", got, rescheduled.PaymentKey)
+    }
+}
+
+func TestSchedulerFailsOrderThePaymentServiceRejects(t *testing.T) {
+    fake := newPaymentServer(t)
+    fake.failMethod = PaymentMethodCard
+    payments.sleep = func(ctx context.Context, d time.Duration) error { return ctx.Err() }
+    resetOrders(t)
+    r := setupRouter()
+    at := time.Now().Add(time.Hour)
+
+    var order Order
+    json.Unmarshal(doRequest(r, http.MethodPost, "/orders", scheduledOrderBody(at)).Body.Bytes(), &order)
+    s := newTestOrderScheduler(at)
+    if n, err := s.runOnce(context.Background()); err != nil || n != 1 {
+        t.Fatalf("got %d processed, %v; want 1", n, err)
+    }
+    charges := fake.charges.Load()
+    s.now = func() time.Time { return at.Add(24 * time.Hour) }
+    s.runOnce(context.Background())
+
+    got, _ := orders.FindByID(order.OrderID)
+    if got.Status != StatusPaymentFailed {
+        t.Fatalf("got status %s, want payment_failed", got.Status)
+    }
+    if n, _ := reschedules(got); n != 0 {
+        t.Fatalf("order rescheduled %d times after the payment service rejected it", n)
+    }
+    if fake.charges.Load() != charges {
+        t.Fatal("failed order was charged again")
+    }
+}
+
+func TestSchedulerBacksOffAndGivesUpWhenPaymentUnreachable(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+    at := time.Now().Add(time.Second)
+
+    var order Order
+    json.Unmarshal(doRequest(r, http.MethodPost, "/orders", scheduledOrderBody(at)).Body.Bytes(), &order)
+    unreachable := httptest.NewServer(http.NotFoundHandler())
+    unreachable.Close()
+    var delays []time.Duration
+    payments = newTestPaymentClient(unreachable.URL, &delays)
+
+    s := newTestOrderScheduler(at)
+    s.MaxAttempts = 3
+    status := func() string {
+        got, _ := orders.FindByID(order.OrderID)
+        return got.Status
+    }
+    // Tried at the scheduled time, then a minute after being put back, then
+    // two minutes after that.
+    for i, offset := range []time.Duration{0, 90 * time.Second, 5 * time.Minute} {
+        s.now = func() time.Time { return at.Add(offset) }
+        s.runOnce(context.Background())
+        want := StatusScheduled
+        if i == 2 {
+            want = StatusPaymentFailed
+        }
+        if got := status(); got != want {
+            t.Fatalf("attempt %d: got status %s, want %s", i+1, got, want)
+        }
+        if i == 0 {
+            // Too soon for the next attempt.
+            s.now = func() time.Time { return at.Add(30 * time.Second) }
+            if n, _ := s.runOnce(context.Background()); n != 0 {
+                t.Fatalf("rescheduled order tried again before its backoff")
+            }
+        }
+    }
+    got, _ := orders.FindByID(order.OrderID)
+    if n, _ := reschedules(got); n != 2 {
+        t.Fatalf("got %d reschedules, want 2", n)
+    }
+}
+
+func TestSchedulerHoldsOrderLock(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+    at := time.Now().Add(time.Hour)
+
+    var order Order
+    json.Unmarshal(doRequest(r, http.MethodPost, "/orders", scheduledOrderBody(at)).Body.Bytes(), &order)
+    unlock := orderLocks.lock(order.OrderID)
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        newTestOrderScheduler(at).runOnce(context.Background())
+    }()
+    time.Sleep(20 * time.Millisecond)
+    if fake.charges.Load() != 0 {
+        t.Fatal("scheduler charged an order another request holds")
+    }
+    unlock()
+    <-done
+    if fake.charges.Load() != 1 {
+        t.Fatalf("got %d charges, want 1 once the lock was free", fake.charges.Load())
+    }
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+    "container/list"
+    "context"
+    "errors"
+    "fmt"
+    "sync"
+)
+
+// defaultMaxPaymentCalls bounds concurrent charges to the payment service
+// unless PAYMENT_MAX_CONCURRENT says otherwise.
+const defaultMaxPaymentCalls = 64
+
+// ErrPaymentSaturated is returned instead of calling the payment service
+// when no slot frees up before the caller gives up waiting.
+var ErrPaymentSaturated = errors.New("too many concurrent payment calls")
+
+// Semaphore is a weighted semaphore: callers acquire a weight, and the
+// weights held at once never add up to more than its size. Waiters are
+// served in the order they arrived, so a heavy caller isn't starved by
+// light ones.
+type Semaphore struct {
+    mu      sync.Mutex
+    size    int64
+    held    int64
+    waiters list.List
+}
+
+type semaphoreWaiter struct {
+    n     int64
+    ready chan struct{}
+}
+
+func NewSemaphore(size int64) *Semaphore {
+    return &Semaphore{size: size}
+}
+
+// Acquire waits for n of the semaphore's weight, or until ctx is done. A
+// nil Semaphore never makes anyone wait.
+func (s *Semaphore) Acquire(ctx context.Context, n int64) error {
+    if s == nil {
+        return nil
+    }
+    if n > s.size {
+        return fmt.Errorf("acquiring %d of a semaphore of size %d", n, s.size)
+    }
+    s.mu.Lock()
+    if s.waiters.Len() == 0 && s.held+n <= s.size {
+        s.held += n
+        s.mu.Unlock()
+        return nil
+    }
+    w := &semaphoreWaiter{n: n, ready: make(chan struct{})}
+    elem := s.waiters.PushBack(w)
+    s.mu.Unlock()
+
+    select {
+    case <-w.ready:
+        return nil
+    case <-ctx.Done():
+        s.mu.Lock()
+        select {
+        case <-w.ready:
+            // Granted just as ctx finished; give it back.
+            s.held -= n
+        default:
+            s.waiters.Remove(elem)
+        }
+        s.notifyLocked()
+        s.mu.Unlock()
+        return ctx.Err()
+    }
+}
+
+// Release gives back n acquired by Acquire.
+func (s *Semaphore) Release(n int64) {
+    if s == nil {
+        return
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.held -= n
+    if s.held < 0 {
+        panic("semaphore released more than was acquired")
+    }
+    s.notifyLocked()
+}
+
+// notifyLocked grants waiters, oldest first, for as long as the next one
+// fits.
+func (s *Semaphore) notifyLocked() {
+    for {
+        front := s.waiters.Front()
+        if front == nil {
+            return
+        }
+        w := front.Value.(*semaphoreWaiter)
+        if s.held+w.n > s.size {
+            return
+        }
+        s.held += w.n
+        s.waiters.Remove(front)
+        close(w.ready)
+    }
+}
+
+// Held returns the weight currently acquired.
+func (s *Semaphore) Held() int64 {
+    if s == nil {
+        return 0
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return s.held
+}
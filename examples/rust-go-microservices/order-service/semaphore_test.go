@@ -0,0 +1,168 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// blockingPaymentServer approves charges only once release is closed, and
+// records the most charges it was handling at once.
+type blockingPaymentServer struct {
+    *httptest.Server
+    release  chan struct{}
+    arrived  chan struct{}
+    inFlight atomic.Int64
+    peak     atomic.Int64
+}
+
+func newBlockingPaymentServer(t *testing.T) *blockingPaymentServer {
+    t.Helper()
+
+    s := &blockingPaymentServer{release: make(chan struct{}), arrived: make(chan struct{}, 100)}
+    s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        n := s.inFlight.Add(1)
+        defer s.inFlight.Add(-1)
+        for {
+            peak := s.peak.Load()
+            if n <= peak || s.peak.CompareAndSwap(peak, n) {
+                break
+            }
+        }
+        s.arrived <- struct{}{}
+        <-s.release
+        json.NewEncoder(w).Encode(PaymentResponse{Status: "approved", ProcessedAt: time.Now()})
+    }))
+    t.Cleanup(s.Close)
+    return s
+}
+
+func TestPaymentConcurrencyCapped(t *testing.T) {
+    srv := newBlockingPaymentServer(t)
+    p := NewPaymentClient(srv.URL)
+    p.Concurrency = NewSemaphore(2)
+
+    var wg sync.WaitGroup
+    errs := make(chan error, 5)
+    for i := 0; i < 5; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            _, err := p.processPayment(context.Background(), PaymentRequest{})
+            errs <- err
+        }()
+    }
+    <-srv.arrived
+    <-srv.arrived
+    // Give the other three time to get past the semaphore if it let them.
+    time.Sleep(50 * time.Millisecond)
+    if n := srv.inFlight.Load(); n != 2 {
+        t.Fatalf("got %d charges in flight, want 2", n)
+    }
+    if n := p.Concurrency.Held(); n != 2 {
+        t.Fatalf("semaphore reports %d held, want 2", n)
+    }
+
+    close(srv.release)
+    wg.Wait()
+    close(errs)
+    for err := range errs {
+        if err != nil {
+            t.Fatalf("queued charge failed: %v", err)
+        }
+    }
+    if peak := srv.peak.Load(); peak != 2 {
+        t.Fatalf("peak concurrency %d, want 2", peak)
+    }
+    if n := p.Concurrency.Held(); n != 0 {
+        t.Fatalf("semaphore still holds %d", n)
+    }
+}
+
+func TestPaymentSaturatedAnswers503(t *testing.T) {
+    srv := newBlockingPaymentServer(t)
+    prev := payments
+    payments = NewPaymentClient(srv.URL)
+    payments.Concurrency = NewSemaphore(1)
+    t.Cleanup(func() { payments = prev })
+    resetOrders(t)
+    r := setupRouter()
+
+    first := make(chan struct{})
+    go func() {
+        defer close(first)
+        doRequest(r, http.MethodPost, "/orders", sampleOrder)
+    }()
+    <-srv.arrived
+    defer func() {
+        close(srv.release)
+        <-first
+    }()
+
+    // The second waits for the slot until its deadline, then gives up
+    // without reaching the payment service.
+    ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+    defer cancel()
+    req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(sampleOrder)).WithContext(ctx)
+    req.Header.Set("Content-Type", "application/json")
+    w := httptest.NewRecorder()
+    r.ServeHTTP(w, req)
+    if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), CodePaymentUnavailable) {
+        t.Fatalf("got status %d: %s; want 503 %s", w.Code, w.Body, CodePaymentUnavailable)
+    }
+    if n := srv.inFlight.Load(); n != 1 {
+        t.Fatalf("got %d charges in flight, want only the first", n)
+    }
+
+    _, err := payments.processPayment(ctx, PaymentRequest{})
+    if !errors.Is(err, ErrPaymentSaturated) || !errors.Is(err, context.DeadlineExceeded) {
+        t.Fatalf("got %v, want ErrPaymentSaturated after the deadline", err)
+    }
+}
+
+func TestSemaphoreWeighted(t *testing.T) {
+    s := NewSemaphore(3)
+    ctx := context.Background()
+    if err := s.Acquire(ctx, 2); err != nil {
+        t.Fatal(err)
+    }
+
+    // A waiter for 2 queues; a later one for 1 waits behind it even though
+    // it would fit.
+    heavy, light := make(chan struct{}), make(chan struct{})
+    go func() { s.Acquire(ctx, 2); close(heavy) }()
+    time.Sleep(20 * time.Millisecond)
+    go func() { s.Acquire(ctx, 1); close(light) }()
+    select {
+    case <-light:
+        t.Fatal("light waiter jumped the queue")
+    case <-time.After(20 * time.Millisecond):
+    }
+
+    s.Release(2)
+    <-heavy
+    <-light
+    if n := s.Held(); n != 3 {
+        t.Fatalf("held %d, want 3", n)
+    }
+
+    short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+    defer cancel()
+    if err := s.Acquire(short, 1); !errors.Is(err, context.DeadlineExceeded) {
+        t.Fatalf("got %v, want DeadlineExceeded", err)
+    }
+    if err := s.Acquire(ctx, 4); err == nil {
+        t.Fatal("acquired more than the semaphore's size")
+    }
+    s.Release(3)
+    if n := s.Held(); n != 0 {
+        t.Fatalf("held %d after releasing all, want 0", n)
+    }
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "net/http"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+const (
+    defaultShutdownGracePeriod = 15 * time.Second
+
+    defaultReadHeaderTimeout = 5 * time.Second
+    defaultReadTimeout       = 15 * time.Second
+    defaultWriteTimeout      = 30 * time.Second
+    defaultIdleTimeout       = 60 * time.Second
+)
+
+// ServerTimeouts bound how long a client may hold a connection, so slow or
+// stalled clients can't tie the server up indefinitely.
+type ServerTimeouts struct {
+    // ReadHeader bounds reading the request headers and Read the whole
+    // request, body included.
+    ReadHeader time.Duration
+    Read       time.Duration
+    // Write runs from the end of the request headers to the end of the
+    // response, so it covers the handler and every payment call it makes.
+    Write time.Duration
+    // Idle bounds how long a keep-alive connection waits for its next
+    // request.
+    Idle time.Duration
+    // Request bounds the handler itself; past it the client gets a 504.
+    // Zero disables it.
+    Request time.Duration
+}
+
+// serverTimeoutsFromEnv reads the SERVER_*_TIMEOUT settings. The write
+// timeout must outlast paymentBudget, the most a payment call can take with
+// retries: otherwise the connection could be cut while the customer is
+// being charged, leaving them without a response for a charge that went
+// through.
+func serverTimeoutsFromEnv(paymentBudget time.Duration) (ServerTimeouts, error) {
+    var t ServerTimeouts
+    var err error
+    if t.ReadHeader, err = envDuration("SERVER_READ_HEADER_TIMEOUT", defaultReadHeaderTimeout); err != nil {
+        return t, err
+    }
+    if t.Read, err = envDuration("SERVER_READ_TIMEOUT", defaultReadTimeout); err != nil {
+        return t, err
+    }
+    if t.Write, err = envDuration("SERVER_WRITE_TIMEOUT", defaultWriteTimeout); err != nil {
+        return t, err
+    }
+    if t.Idle, err = envDuration("SERVER_IDLE_TIMEOUT", defaultIdleTimeout); err != nil {
+        return t, err
+    }
+    if t.Request, err = envDuration("REQUEST_TIMEOUT", defaultRequestTimeout); err != nil {
+        return t, err
+    }
+    if err := t.check(paymentBudget); err != nil {
+        return t, fmt.Errorf("SERVER_WRITE_TIMEOUT and REQUEST_TIMEOUT: %w", err)
+    }
+    return t, nil
+}
+
+// defaultServerTimeouts are the timeouts when none are configured.
+func defaultServerTimeouts() ServerTimeouts {
+    return ServerTimeouts{
+        ReadHeader: defaultReadHeaderTimeout,
+        Read:       defaultReadTimeout,
+        Write:      defaultWriteTimeout,
+        Idle:       defaultIdleTimeout,
+        Request:    defaultRequestTimeout,
+    }
+}
+
+// check reports timeouts that would cut off requests the service could
+// still answer.
+func (t ServerTimeouts) check(paymentBudget time.Duration) error {
+    if t.Write <= paymentBudget {
+        return fmt.Errorf("write timeout (%s) must be longer than the payment call budget (%s)", t.Write, paymentBudget)
+    }
+    // The 504 for a timed-out request has to be written before the
+    // connection's own deadline.
+    if t.Request >= t.Write {
+        return fmt.Errorf("request timeout (%s) must be shorter than the write timeout (%s)", t.Request, t.Write)
+    }
+    return nil
+}
+
+func newHTTPServer(handler http.Handler, t ServerTimeouts) *http.Server {
+    return &http.Server{
+        Handler:           withRequestTimeout(handler, t.Request, routeTimeouts),
+        ReadHeaderTimeout: t.ReadHeader,
+        ReadTimeout:       t.Read,
+        WriteTimeout:      t.Write,
+        IdleTimeout:       t.Idle,
+    }
+}
+
+// inFlight counts requests currently being handled, so shutdown can report
+// how many it drained.
+var inFlight atomic.Int64
+
+func trackInFlight() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        inFlight.Add(1)
+        defer inFlight.Add(-1)
+        c.Next()
+    }
+}
+
+// drain stops srv accepting connections and waits until ctx is done for
+// in-flight requests to finish.
+func drain(ctx context.Context, srv *http.Server) error {
+    draining := inFlight.Load()
+    grace := "none"
+    if deadline, ok := ctx.Deadline(); ok {
+        grace = time.Until(deadline).Round(time.Millisecond).String()
+    }
+    logger.Info("Shutting down", "in_flight", draining, "grace_period", grace)
+
+    if err := srv.Shutdown(ctx); err != nil {
+        logger.Error("Shutdown grace period expired", "in_flight", inFlight.Load())
+        return err
+    }
+    logger.Info("Drained in-flight requests", "drained", draining)
+    return nil
+}
+
+const defaultListenAddr = ":8002"
+
+// Config holds what a Server is built from. Zero fields take the defaults
+// the service runs with when nothing is configured; configFromEnv fills
+// them from the environment.
+type Config struct {
+    // Addr is the address to listen on; ":0" picks a free port.
+    Addr string
+
+    Store           OrderRepository
+    IdempotencyKeys *IdempotencyStore
+    Payments        *PaymentClient
+    // Inventory is optional; without it no stock is reserved.
+    Inventory *InventoryClient
+    Events    EventPublisher
+    Coupons   CouponValidator
+    // WarningRules flag unusual orders; nil means DefaultWarningRules.
+    WarningRules []WarningRule
+    // FraudScorer checks new orders before they are charged; nil means
+    // NoopFraudScorer.
+    FraudScorer FraudScorer
+    // Clock is what timestamps are read from; nil means the system clock.
+    Clock Clock
+    // WebhookDeliveries catches repeated payment webhook deliveries; nil
+    // means a MemoryDeliveryStore with the default window.
+    WebhookDeliveries DeliveryStore
+    // WebhookSecret verifies payment webhook signatures; while it is empty
+    // every callback is rejected.
+    WebhookSecret []byte
+    // CreationQueue is optional; without it order creations aren't queued.
+    CreationQueue *CreationQueue
+    // DeadLetters keeps the events Events couldn't deliver, for
+    // POST /admin/events/replay; nil means they are only logged.
+    DeadLetters DeadLetterStore
+    // Broker is optional. With a Store that is an Outbox, events are kept
+    // with the orders and relayed to Broker every OutboxInterval.
+    Broker         Broker
+    OutboxInterval time.Duration
+
+    Timeouts ServerTimeouts
+    // Dependencies are the timeouts of the calls to the payment, inventory
+    // and exchange rate services.
+    Dependencies DependencyTimeouts
+    // DrainDelay is how long Shutdown fails readiness for before it stops
+    // accepting connections, for load balancers to stop sending requests.
+    DrainDelay time.Duration
+    // ShutdownGrace is how long main lets in-flight requests finish.
+    ShutdownGrace time.Duration
+    // Jobs run in the background from Start until Shutdown.
+    Jobs []backgroundJob
+}
+
+// Server is the order service: its HTTP server and background jobs.
+//
+// The handlers reach their dependencies through package variables, so
+// NewServer installs cfg's there and only one Server should run at a time.
+type Server struct {
+    cfg     Config
+    http    *http.Server
+    drainer *Drainer
+
+    ln      net.Listener
+    stop    context.CancelFunc
+    jobs    sync.WaitGroup
+    served  chan error
+    stopped bool
+}
+
+// NewServer builds a Server from cfg. Nothing listens or runs until Start.
+func NewServer(cfg Config) (*Server, error) {
+    if cfg.Addr == "" {
+        cfg.Addr = defaultListenAddr
+    }
+    if cfg.Store == nil {
+        cfg.Store = NewOrderStore()
+    }
+    if cfg.IdempotencyKeys == nil {
+        cfg.IdempotencyKeys = NewIdempotencyStore(defaultIdempotencyTTL)
+    }
+    if cfg.Payments == nil {
+        cfg.Payments = NewPaymentClient(defaultPaymentServiceURL)
+    }
+    if cfg.Events == nil {
+        cfg.Events = NoopPublisher{}
+    }
+    if cfg.Coupons == nil {
+        cfg.Coupons = NewCouponTable(nil)
+    }
+    if cfg.WarningRules == nil {
+        cfg.WarningRules = DefaultWarningRules()
+    }
+    if cfg.FraudScorer == nil {
+        cfg.FraudScorer = NoopFraudScorer{}
+    }
+    if cfg.Clock == nil {
+        cfg.Clock = systemClock{}
+    }
+    if cfg.WebhookDeliveries == nil {
+        cfg.WebhookDeliveries = NewMemoryDeliveryStore(defaultWebhookDedupWindow)
+    }
+    if cfg.OutboxInterval == 0 {
+        cfg.OutboxInterval = defaultOutboxInterval
+    }
+    if cfg.Timeouts == (ServerTimeouts{}) {
+        cfg.Timeouts = defaultServerTimeouts()
+    }
+    if err := cfg.Timeouts.check(cfg.Payments.MaxElapsed); err != nil {
+        return nil, err
+    }
+    if cfg.ShutdownGrace == 0 {
+        cfg.ShutdownGrace = defaultShutdownGracePeriod
+    }
+    cfg.Dependencies = cfg.Dependencies.withDefaults()
+    if err := cfg.Dependencies.check(); err != nil {
+        return nil, err
+    }
+    cfg.Payments.AttemptTimeout = cfg.Dependencies.Payment
+    if cfg.Inventory != nil {
+        cfg.Inventory.Timeout = cfg.Dependencies.Inventory
+    }
+    if rates, ok := exchangeRates.(*RateClient); ok {
+        rates.Timeout = cfg.Dependencies.ExchangeRates
+    }
+
+    orders = cfg.Store
+    idempotencyKeys = cfg.IdempotencyKeys
+    payments = cfg.Payments
+    inventory = cfg.Inventory
+    events = cfg.Events
+    coupons = cfg.Coupons
+    warningRules = cfg.WarningRules
+    fraudScorer = cfg.FraudScorer
+    creationQueue = cfg.CreationQueue
+    drainer = NewDrainer(cfg.DrainDelay)
+    deadLetters = cfg.DeadLetters
+    clock = cfg.Clock
+    webhookDeliveries = cfg.WebhookDeliveries
+    webhookSecret = cfg.WebhookSecret
+    // Copy rather than append to the caller's slice.
+    cfg.Jobs = append([]backgroundJob(nil), cfg.Jobs...)
+    outbox = nil
+    if o, ok := cfg.Store.(Outbox); ok && cfg.Broker != nil {
+        outbox = o
+        cfg.Jobs = append(cfg.Jobs, NewOutboxRelay(o, cfg.Broker, cfg.OutboxInterval))
+    }
+
+    return &Server{cfg: cfg, http: newHTTPServer(setupRouter(), cfg.Timeouts), drainer: drainer}, nil
+}
+
+// Start listens on the configured address and starts serving and running
+// the background jobs. It returns once the server is accepting connections.
+func (s *Server) Start() error {
+    ln, err := net.Listen("tcp", s.cfg.Addr)
+    if err != nil {
+        return err
+    }
+    s.ln = ln
+
+    ctx, stop := context.WithCancel(context.Background())
+    s.stop = stop
+    for _, job := range s.cfg.Jobs {
+        s.jobs.Add(1)
+        go func(job backgroundJob) {
+            defer s.jobs.Done()
+            job.Run(ctx)
+        }(job)
+    }
+
+    s.served = make(chan error, 1)
+    go func() { s.served <- s.http.Serve(ln) }()
+    logger.Info("Starting Order Service", "addr", ln.Addr().String())
+    return nil
+}
+
+// Addr is the address the server is listening on, once started.
+func (s *Server) Addr() net.Addr {
+    return s.ln.Addr()
+}
+
+// Done receives the error the server stopped with, should it stop serving
+// before Shutdown.
+func (s *Server) Done() <-chan error {
+    return s.served
+}
+
+// DrainRequested is closed when draining is asked for with POST
+// /admin/drain, for main to call Shutdown.
+func (s *Server) DrainRequested() <-chan struct{} {
+    return s.drainer.Requested()
+}
+
+// Shutdown fails readiness for the drain delay, counted from when draining
+// began if it already had, then stops accepting connections and waits until
+// ctx is done for in-flight requests to finish. Finally it stops the
+// background jobs and waits for them, so none is cut off mid-update.
+func (s *Server) Shutdown(ctx context.Context) error {
+    if s.ln == nil || s.stopped {
+        return nil
+    }
+    s.stopped = true
+
+    s.drainer.Wait(ctx)
+    err := drain(ctx, s.http)
+    s.stop()
+    s.jobs.Wait()
+    if p, ok := s.cfg.Events.(*BrokerPublisher); ok {
+        p.Close()
+    }
+    return err
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+    "context"
+    "net"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+
+    "order-service/client"
+
+    "github.com/google/uuid"
+)
+
+// startTestServer starts a Server on a free port from cfg, shutting it down
+// when the test ends.
+func startTestServer(t *testing.T, cfg Config) *Server {
+    t.Helper()
+
+    useServerGlobals(t)
+    cfg.Addr = "127.0.0.1:0"
+    srv, err := NewServer(cfg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := srv.Start(); err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { srv.Shutdown(context.Background()) })
+    return srv
+}
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+    fake := newPaymentServer(t)
+    fake.delay = 200 * time.Millisecond
+    srv := startTestServer(t, Config{Payments: NewPaymentClient(fake.URL)})
+    addr := srv.Addr().String()
+
+    status := make(chan int, 1)
+    go func() {
+        resp, err := http.Post("http://"+addr+"/orders", "application/json", strings.NewReader(sampleOrder))
+        if err != nil {
+            t.Errorf("in-flight request failed: %v", err)
+            status <- 0
+            return
+        }
+        resp.Body.Close()
+        status <- resp.StatusCode
+    }()
+
+    // Wait until the order is mid-payment before shutting down.
+    deadline := time.Now().Add(2 * time.Second)
+    for fake.charges.Load() == 0 {
+        if time.Now().After(deadline) {
+            t.Fatal("request never reached the payment service")
+        }
+        time.Sleep(5 * time.Millisecond)
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if err := srv.Shutdown(ctx); err != nil {
+        t.Fatalf("Shutdown returned %v", err)
+    }
+
+    if code := <-status; code != http.StatusCreated {
+        t.Fatalf("in-flight request got status %d, want 201", code)
+    }
+    if conn, err := net.DialTimeout("tcp", addr, 200*time.Millisecond); err == nil {
+        conn.Close()
+        t.Fatal("server still accepting connections after shutdown")
+    }
+}
+
+func TestSlowHeadersCutOffByReadHeaderTimeout(t *testing.T) {
+    timeouts := ServerTimeouts{ReadHeader: 100 * time.Millisecond, Read: time.Minute, Write: time.Minute, Idle: time.Minute}
+    srv := startTestServer(t, Config{Timeouts: timeouts})
+
+    conn, err := net.Dial("tcp", srv.Addr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+
+    // Send a partial request and never finish the headers.
+    start := time.Now()
+    if _, err := conn.Write([]byte("GET /health HTTP/1.1\r\nHost: localhost\r\n")); err != nil {
+        t.Fatal(err)
+    }
+    conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+    _, err = conn.Read(make([]byte, 1))
+    if ne, ok := err.(net.Error); ok && ne.Timeout() {
+        t.Fatal("server kept the connection open for a client that never finished its headers")
+    }
+    if elapsed := time.Since(start); elapsed > 2*time.Second {
+        t.Fatalf("connection closed after %s, want about 100ms", elapsed)
+    }
+}
+
+func TestServerTimeoutsMustOutlastPaymentBudget(t *testing.T) {
+    t.Setenv("SERVER_WRITE_TIMEOUT", "10s")
+    if _, err := serverTimeoutsFromEnv(10 * time.Second); err == nil {
+        t.Fatal("accepted a write timeout no longer than the payment budget")
+    }
+
+    t.Setenv("SERVER_WRITE_TIMEOUT", "11s")
+    timeouts, err := serverTimeoutsFromEnv(10 * time.Second)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if timeouts.Write != 11*time.Second || timeouts.ReadHeader != defaultReadHeaderTimeout {
+        t.Fatalf("got %+v", timeouts)
+    }
+}
+
+// useServerGlobals restores the package variables NewServer installs.
+func useServerGlobals(t *testing.T) {
+    t.Helper()
+
+    prevOrders, prevKeys, prevPayments := orders, idempotencyKeys, payments
+    prevInventory, prevEvents, prevOutbox, prevCoupons := inventory, events, outbox, coupons
+    prevRules, prevClock, prevDeliveries, prevScorer := warningRules, clock, webhookDeliveries, fraudScorer
+    prevQueue, prevDeadLetters, prevDrainer, prevSecret := creationQueue, deadLetters, drainer, webhookSecret
+    t.Cleanup(func() {
+        orders, idempotencyKeys, payments = prevOrders, prevKeys, prevPayments
+        inventory, events, outbox, coupons = prevInventory, prevEvents, prevOutbox, prevCoupons
+        warningRules, clock, webhookDeliveries, fraudScorer = prevRules, prevClock, prevDeliveries, prevScorer
+        creationQueue, deadLetters, drainer, webhookSecret = prevQueue, prevDeadLetters, prevDrainer, prevSecret
+    })
+}
+
+// runJob is a background job that records it ran until cancelled.
+type runJob struct{ started, stopped chan struct{} }
+
+func (j *runJob) Run(ctx context.Context) {
+    close(j.started)
+    <-ctx.Done()
+    close(j.stopped)
+}
+
+func TestNewServerEndToEnd(t *testing.T) {
+    fake := newPaymentServer(t)
+    useServerGlobals(t)
+    job := &runJob{started: make(chan struct{}), stopped: make(chan struct{})}
+    store := NewOrderStore()
+
+    srv, err := NewServer(Config{
+        Addr:     "127.0.0.1:0",
+        Store:    store,
+        Payments: NewPaymentClient(fake.URL),
+        Jobs:     []backgroundJob{job},
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := srv.Start(); err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { srv.Shutdown(context.Background()) })
+    <-job.started
+
+    c := client.New("http://" + srv.Addr().String())
+    ctx := context.Background()
+    created, err := c.CreateOrder(ctx, sampleCreateRequest())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if created.Status != client.StatusConfirmed || fake.charges.Load() != 1 {
+        t.Fatalf("created order %q after %d charges", created.Status, fake.charges.Load())
+    }
+    if _, err := store.FindByID(created.OrderID); err != nil {
+        t.Fatalf("order not in the configured store: %v", err)
+    }
+    refunded, err := c.RefundOrder(ctx, created.OrderID, nil)
+    if err != nil || refunded.Status != client.StatusRefunded {
+        t.Fatalf("RefundOrder: got %+v, %v", refunded, err)
+    }
+    got, err := c.GetOrder(ctx, created.OrderID)
+    if err != nil || got.Status != client.StatusRefunded {
+        t.Fatalf("GetOrder: got %+v, %v", got, err)
+    }
+
+    shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+    defer cancel()
+    if err := srv.Shutdown(shutdownCtx); err != nil {
+        t.Fatal(err)
+    }
+    select {
+    case <-job.stopped:
+    default:
+        t.Fatal("Shutdown returned before the background job stopped")
+    }
+    if _, err := c.GetOrder(ctx, created.OrderID); err == nil {
+        t.Fatal("server still answering after Shutdown")
+    }
+}
+
+func TestNewServerRejectsTimeoutsShorterThanPaymentBudget(t *testing.T) {
+    useServerGlobals(t)
+    timeouts := defaultServerTimeouts()
+    timeouts.Write = time.Second
+    timeouts.Request = 0
+    if _, err := NewServer(Config{Timeouts: timeouts}); err == nil {
+        t.Fatal("accepted a write timeout shorter than the payment budget")
+    }
+}
+
+func TestNewServerInstallsWebhookSecret(t *testing.T) {
+    useServerGlobals(t)
+    if _, err := NewServer(Config{WebhookSecret: []byte(testWebhookSecret)}); err != nil {
+        t.Fatal(err)
+    }
+
+    // Verified with the configured secret, a callback for an unknown order
+    // gets as far as looking it up.
+    body := paymentCallback(uuid.New(), "approved")
+    if code, resp := postWebhook(setupRouter(), body, signWebhook([]byte(testWebhookSecret), []byte(body))); code != http.StatusNotFound {
+        t.Fatalf("got %d %v, want 404", code, resp)
+    }
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+)
+
+// Shipment is one package sent for an order, covering some quantity of
+// some of its items.
+type Shipment struct {
+    ShipmentID     uuid.UUID      `json:"shipment_id"`
+    Items          []ShipmentItem `json:"items"`
+    TrackingNumber string         `json:"tracking_number,omitempty"`
+    ShippedAt      time.Time      `json:"shipped_at"`
+}
+
+type ShipmentItem struct {
+    ProductID string `json:"product_id" binding:"required"`
+    Quantity  int    `json:"quantity"`
+}
+
+// CreateShipmentRequest is the body of POST /orders/:id/shipments.
+type CreateShipmentRequest struct {
+    Items          []ShipmentItem `json:"items" binding:"required,dive"`
+    TrackingNumber string         `json:"tracking_number"`
+}
+
+// createShipment records a package shipped for a confirmed order. The order
+// becomes partially_shipped, or shipped once every ordered unit is in some
+// shipment.
+func createShipment(c *gin.Context) {
+    unlock := lockOrderParam(c)
+    defer unlock()
+    order := loadOrder(c)
+    if order == nil {
+        return
+    }
+
+    var req CreateShipmentRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        respondBindError(c, err)
+        return
+    }
+
+    if order.Status != StatusConfirmed && order.Status != StatusPartiallyShipped {
+        respondError(c, http.StatusConflict, CodeInvalidStatusTransition,
+            fmt.Sprintf("Order cannot be shipped from status %q", order.Status))
+        return
+    }
+
+    outstanding := unshippedQuantities(order)
+    verr := &ValidationError{}
+    if len(req.Items) == 0 {
+        verr.add("items", "must contain at least one item")
+    }
+    for i, item := range req.Items {
+        field := fmt.Sprintf("items[%d]", i)
+        left, ordered := outstanding[item.ProductID]
+        switch {
+        case item.Quantity < 1:
+            verr.add(field+".quantity", "must be at least 1")
+        case !ordered:
+            verr.add(field+".product_id", "%q is not in the order", item.ProductID)
+        case item.Quantity > left:
+            verr.add(field+".quantity", "%d exceeds the %d of %s not yet shipped", item.Quantity, left, item.ProductID)
+        }
+        // A product listed twice counts against the same outstanding total.
+        if ordered && item.Quantity > 0 {
+            outstanding[item.ProductID] = left - item.Quantity
+        }
+    }
+    if verr.err() != nil {
+        respondValidationError(c, verr)
+        return
+    }
+
+    order.Shipments = append(order.Shipments[:len(order.Shipments):len(order.Shipments)], Shipment{
+        ShipmentID:     uuid.New(),
+        Items:          req.Items,
+        TrackingNumber: req.TrackingNumber,
+        ShippedAt:      clock.Now(),
+    })
+    markShippedItems(order)
+    reason := "first shipment sent"
+    if rollupStatus(order.Status, order.Items) == StatusShipped {
+        reason = "all items shipped"
+    }
+    rollUp(order, reason)
+
+    if err := orders.Save(order); err != nil {
+        respondSaveError(c, err)
+        return
+    }
+    c.JSON(http.StatusCreated, withLinks(order))
+}
+
+// unshippedQuantities returns, per product in order, how many units no
+// shipment covers yet.
+func unshippedQuantities(order *Order) map[string]int {
+    left := make(map[string]int)
+    for _, item := range order.Items {
+        left[item.ProductID] += item.Quantity
+    }
+    for _, shipment := range order.Shipments {
+        for _, item := range shipment.Items {
+            left[item.ProductID] -= item.Quantity
+        }
+    }
+    return left
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+
+    "github.com/shopspring/decimal"
+)
+
+func saveShippableOrder() *Order {
+    order := saveOrderWithStatus(StatusConfirmed)
+    order.Items = []OrderItem{
+        {ProductID: "prod_1", Quantity: 2, Price: decimal.RequireFromString("19.99")},
+        {ProductID: "prod_2", Quantity: 1, Price: decimal.RequireFromString("20.00")},
+    }
+    orders.Save(order)
+    return order
+}
+
+func ship(t *testing.T, r http.Handler, order *Order, body string) (int, Order) {
+    t.Helper()
+
+    w := doRequest(r, http.MethodPost, "/orders/"+order.OrderID.String()+"/shipments", body)
+    var got Order
+    json.Unmarshal(w.Body.Bytes(), &got)
+    return w.Code, got
+}
+
+func TestShipmentPartial(t *testing.T) {
+    resetOrders(t)
+    order := saveShippableOrder()
+    r := setupRouter()
+
+    code, got := ship(t, r, order, `{"items":[{"product_id":"prod_1","quantity":1}],"tracking_number":"1Z999"}`)
+    if code != http.StatusCreated {
+        t.Fatalf("got status %d", code)
+    }
+    if got.Status != StatusPartiallyShipped {
+        t.Fatalf("got status %q, want %q", got.Status, StatusPartiallyShipped)
+    }
+    if len(got.Shipments) != 1 || got.Shipments[0].TrackingNumber != "1Z999" || got.Shipments[0].ShippedAt.IsZero() {
+        t.Fatalf("got shipments %+v", got.Shipments)
+    }
+}
+
+func TestShipmentFullAcrossTwoPackages(t *testing.T) {
+    resetOrders(t)
+    order := saveShippableOrder()
+    r := setupRouter()
+
+    if code, got := ship(t, r, order, `{"items":[{"product_id":"prod_1","quantity":1},{"product_id":"prod_2","quantity":1}]}`); code != http.StatusCreated || got.Status != StatusPartiallyShipped {
+        t.Fatalf("first shipment: status %d, order %q", code, got.Status)
+    }
+    code, got := ship(t, r, order, `{"items":[{"product_id":"prod_1","quantity":1}]}`)
+    if code != http.StatusCreated || got.Status != StatusShipped {
+        t.Fatalf("second shipment: status %d, order %q", code, got.Status)
+    }
+    if len(got.Shipments) != 2 {
+        t.Fatalf("got %d shipments, want 2", len(got.Shipments))
+    }
+
+    stored, _ := orders.FindByID(order.OrderID)
+    if stored.Status != StatusShipped || len(stored.Shipments) != 2 {
+        t.Fatalf("stored order %q with %d shipments", stored.Status, len(stored.Shipments))
+    }
+    if code, _ := ship(t, r, order, `{"items":[{"product_id":"prod_1","quantity":1}]}`); code != http.StatusConflict {
+        t.Fatalf("shipping a shipped order: got status %d, want 409", code)
+    }
+}
+
+func TestShipmentRejectsOverShipment(t *testing.T) {
+    resetOrders(t)
+    order := saveShippableOrder()
+    r := setupRouter()
+
+    ship(t, r, order, `{"items":[{"product_id":"prod_1","quantity":1}]}`)
+    for _, body := range []string{
+        `{"items":[{"product_id":"prod_1","quantity":2}]}`,
+        `{"items":[{"product_id":"prod_1","quantity":1},{"product_id":"prod_1","quantity":1}]}`,
+        `{"items":[{"product_id":"prod_3","quantity":1}]}`,
+        `{"items":[{"product_id":"prod_2","quantity":0}]}`,
+        `{"items":[]}`,
+    } {
+        if code, _ := ship(t, r, order, body); code != http.StatusUnprocessableEntity {
+            t.Errorf("%s: got status %d, want 422", body, code)
+        }
+    }
+    stored, _ := orders.FindByID(order.OrderID)
+    if len(stored.Shipments) != 1 || stored.Status != StatusPartiallyShipped {
+        t.Fatalf("stored order %q with %d shipments", stored.Status, len(stored.Shipments))
+    }
+}
+
+func TestShipmentWaitsForOrderLock(t *testing.T) {
+    resetOrders(t)
+    order := saveShippableOrder()
+    r := setupRouter()
+
+    assertWaitsForOrderLock(t, order, func() {
+        doRequest(r, http.MethodPost, "/orders/"+order.OrderID.String()+"/shipments", `{"items":[{"product_id":"prod_1","quantity":1}]}`)
+    })
+    if stored, _ := orders.FindByID(order.OrderID); len(stored.Shipments) != 1 {
+        t.Fatalf("got %d shipments, want 1", len(stored.Shipments))
+    }
+}
+
+func TestShipmentRequiresConfirmedOrder(t *testing.T) {
+    resetOrders(t)
+    order := saveOrderWithStatus(StatusPending)
+    r := setupRouter()
+
+    if code, _ := ship(t, r, order, `{"items":[{"product_id":"prod_1","quantity":1}]}`); code != http.StatusConflict {
+        t.Fatalf("got status %d, want 409", code)
+    }
+}
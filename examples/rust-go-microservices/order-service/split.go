@@ -0,0 +1,259 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/shopspring/decimal"
+)
+
+// maxPaymentSplits bounds how many parts an order's payment may be split
+// into.
+const maxPaymentSplits = 5
+
+// Statuses of a PaymentSplit once the service has tried to charge it.
+const (
+    SplitApproved     = "approved"
+    SplitPending      = "pending"
+    SplitDeclined     = "declined"
+    SplitFailed       = "failed"
+    SplitRefunded     = "refunded"
+    SplitRefundFailed = "refund_failed"
+)
+
+// PaymentSplit is one part of an order's total paid with its own payment
+// method, such as the part a gift card covers with a card paying the rest.
+// The parts must add up to the total exactly.
+type PaymentSplit struct {
+    Amount decimal.Decimal `json:"amount"`
+    // PaymentMethod is optional; a part without one is paid by card.
+    PaymentMethod *PaymentMethod `json:"payment_method,omitempty"`
+    // PaymentID and Status are set by the service once it has tried to
+    // charge the part. A part charged and then refunded because a later
+    // one failed is SplitRefunded, or SplitRefundFailed if the refund
+    // didn't go through and someone has to give the money back. A part
+    // the payment service is still processing is SplitPending.
+    PaymentID *uuid.UUID `json:"payment_id,omitempty"`
+    Status    string     `json:"status,omitempty"`
+}
+
+// validatePaymentSplits adds the problems with order's payment splits that
+// can be seen before it is priced to verr; checkSplitTotal compares them
+// with the total afterwards.
+func validatePaymentSplits(verr *ValidationError, order *Order, currency Currency, known bool, now time.Time) {
+    if len(order.PaymentSplits) == 0 {
+        return
+    }
+    if order.PaymentMethod != nil {
+        verr.add("payment_method", "must not be set with payment_splits; give each split its own")
+    }
+    if len(order.PaymentSplits) > maxPaymentSplits {
+        verr.add("payment_splits", "must have at most %d parts, got %d", maxPaymentSplits, len(order.PaymentSplits))
+        return
+    }
+    for i, split := range order.PaymentSplits {
+        field := fmt.Sprintf("payment_splits[%d]", i)
+        // Each part is charged as given, so unlike a unit price it must
+        // fit the currency's minor units whatever strictPrecision says.
+        if !split.Amount.IsPositive() {
+            verr.add(field+".amount", "must be positive")
+        } else if known && !currency.fitsMinorUnits(split.Amount) {
+            verr.add(field+".amount", "has more than %d decimal places for %s", currency.MinorUnits, currency.Code)
+        }
+        validatePaymentMethod(verr, field+".payment_method", split.PaymentMethod, now)
+    }
+}
+
+// checkSplitTotal rejects a priced order whose payment splits don't add up
+// to its total.
+func checkSplitTotal(order *Order) *requestError {
+    if len(order.PaymentSplits) == 0 {
+        return nil
+    }
+    sum := decimal.Zero
+    for i := range order.PaymentSplits {
+        order.PaymentSplits[i].PaymentID = nil
+        order.PaymentSplits[i].Status = ""
+        sum = sum.Add(order.PaymentSplits[i].Amount)
+    }
+    if sum.Equal(order.TotalAmount) {
+        return nil
+    }
+    verr := &ValidationError{}
+    verr.add("payment_splits", "add up to %s, not the order total of %s", sum, order.TotalAmount)
+    return validationFailed(verr)
+}
+
+// paymentMethodTypes returns the payment method types order is paid with.
+func (o *Order) paymentMethodTypes() []string {
+    if len(o.PaymentSplits) == 0 {
+        return []string{o.PaymentMethod.methodType()}
+    }
+    types := make([]string, len(o.PaymentSplits))
+    for i, split := range o.PaymentSplits {
+        types[i] = split.PaymentMethod.methodType()
+    }
+    return types
+}
+
+// chargeSplits charges each of order's payment splits in turn, and
+// confirms it only once every part is approved. When a part fails, the
+// parts already charged are refunded and the order fails as it would have
+// with a single charge. Once money has moved, the failed order is kept with
+// its splits' statuses rather than discarded, even if the payment service
+// couldn't be asked about a later part. A part the payment service hasn't
+// settled yet leaves the order pending, with the parts after it uncharged,
+// until settleSplit hears how it went.
+func chargeSplits(ctx context.Context, order *Order, steps *saga, discard func(error)) *requestError {
+    return continueSplits(ctx, order, nil, steps, discard)
+}
+
+// continueSplits is chargeSplits starting from the first split not yet
+// approved. settled, if not nil, is the payment service's final result for
+// the pending split, used instead of charging it.
+func continueSplits(ctx context.Context, order *Order, settled *PaymentResponse, steps *saga, discard func(error)) *requestError {
+    var charged saga
+    for i := range order.PaymentSplits {
+        split := &order.PaymentSplits[i]
+        part := i
+        if split.Status == SplitApproved {
+            // Approved before the order was left waiting on a later part.
+            charged.onRollback(func(ctx context.Context) {
+                refundSplit(ctx, order, part)
+            })
+            continue
+        }
+
+        resp := settled
+        if split.Status != SplitPending || settled == nil {
+            amount, currency := order.chargeAmount(split.Amount)
+            var err error
+            resp, err = payments.processPayment(ctx, PaymentRequest{
+                OrderID: order.OrderID,
+                // Each part is a charge of its own to the payment service.
+                IdempotencyKey: fmt.Sprintf("%s-%d", order.PaymentKey, i+1),
+                Amount:         amount,
+                Currency:       currency,
+                PaymentMethod:  split.PaymentMethod.methodType(),
+                Details:        split.PaymentMethod,
+            })
+            if err != nil && i > 0 {
+                // Earlier parts were charged, so keep the order to show
+                // they were refunded.
+                loggerFrom(ctx).Warn("payment request for split failed", "order_id", order.OrderID, "split", i+1, "error", err)
+                split.Status = SplitFailed
+                charged.rollback(ctx)
+                steps.rollback(ctx)
+                order.ReservationIDs = nil
+                transitionStatus(order, StatusPaymentFailed, fmt.Sprintf("payment request for split %d failed", i+1))
+                return nil
+            }
+            if err != nil {
+                discard(err)
+                if errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrPaymentSaturated) {
+                    return newRequestError(http.StatusServiceUnavailable, CodePaymentUnavailable, "Payment service unavailable")
+                }
+                transitionStatus(order, StatusPaymentFailed, "payment request for split 1 failed")
+                ordersPaymentFailed.Inc()
+                return newRequestError(http.StatusBadRequest, CodePaymentFailed, "Payment failed")
+            }
+        }
+
+        split.PaymentID = &resp.PaymentID
+        target, final := orderStatusForPayment(resp.Status)
+        if !final {
+            // The later parts wait for this one, so none of them has to be
+            // refunded if it doesn't go through.
+            loggerFrom(ctx).Info("payment for split not yet settled", "order_id", order.OrderID, "split", i+1, "payment_status", resp.Status)
+            split.Status = SplitPending
+            return nil
+        }
+        if target == StatusPaymentFailed {
+            reportProgress(ctx, ProgressPaymentDeclined, order)
+            split.Status = SplitDeclined
+            charged.rollback(ctx)
+            // A declined order is kept; only its stock is released.
+            steps.rollback(ctx)
+            order.ReservationIDs = nil
+            transitionStatus(order, StatusPaymentFailed, fmt.Sprintf("payment for split %d %s", i+1, resp.Status))
+            return nil
+        }
+        split.Status = SplitApproved
+        if !paymentAmountMatches(ctx, order, split.Amount, resp) {
+            // What has been charged is left for whoever resolves the
+            // mismatch, as with a single charge.
+            transitionStatus(order, StatusPaymentMismatch, fmt.Sprintf("payment for split %d approved for a different amount", i+1))
+            return nil
+        }
+        charged.onRollback(func(ctx context.Context) {
+            refundSplit(ctx, order, part)
+        })
+    }
+
+    reportProgress(ctx, ProgressPaymentApproved, order)
+    transitionStatus(order, StatusConfirmed, fmt.Sprintf("payment approved in %d splits", len(order.PaymentSplits)))
+    return nil
+}
+
+// pendingSplit returns the split order is waiting on the payment service to
+// settle, or nil.
+func (o *Order) pendingSplit() *PaymentSplit {
+    for i := range o.PaymentSplits {
+        if o.PaymentSplits[i].Status == SplitPending {
+            return &o.PaymentSplits[i]
+        }
+    }
+    return nil
+}
+
+// settleSplit applies payment, a payment callback or lookup, to the split
+// order is waiting on, charging the parts after it if that one was
+// approved. It reports whether order changed: a result for another of the
+// order's payments, or one still not final, is ignored. The caller holds
+// the order's lock and saves it.
+func settleSplit(ctx context.Context, order *Order, payment *PaymentResponse) bool {
+    split := order.pendingSplit()
+    if split == nil || split.PaymentID == nil || *split.PaymentID != payment.PaymentID {
+        return false
+    }
+    if _, final := orderStatusForPayment(payment.Status); !final {
+        return false
+    }
+    var steps saga
+    steps.onRollback(func(ctx context.Context) {
+        releaseReservations(ctx, order)
+    })
+    // Only a first part is ever discarded, and that was charged already.
+    continueSplits(ctx, order, payment, &steps, func(error) {})
+    return true
+}
+
+// refundSplit gives back the approved i'th split of an order that won't be
+// confirmed, recording on the split whether that worked. The refund names
+// the split's payment, since the order has several.
+func refundSplit(ctx context.Context, order *Order, i int) {
+    split := &order.PaymentSplits[i]
+    amount, currency := order.chargeAmount(split.Amount)
+    resp, err := payments.refundPayment(ctx, RefundRequest{
+        OrderID:        order.OrderID,
+        PaymentID:      split.PaymentID,
+        IdempotencyKey: fmt.Sprintf("%s-%d-refund", order.PaymentKey, i+1),
+        Amount:         amount,
+        Currency:       currency,
+    })
+    if err == nil && resp.Status == refundStatusRefunded {
+        split.Status = SplitRefunded
+        return
+    }
+    split.Status = SplitRefundFailed
+    log := loggerFrom(ctx).With("order_id", order.OrderID, "payment_id", split.PaymentID, "amount", amount.String(), "currency", currency)
+    if err != nil {
+        log.Error("failed to refund payment split", "error", err)
+    } else {
+        log.Error("failed to refund payment split", "status", resp.Status)
+    }
+}
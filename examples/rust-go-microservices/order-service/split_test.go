@@ -0,0 +1,295 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "testing"
+    "time"
+)
+
+// splitOrder is sampleOrder, totalling 59.98, paid by card and by wallet.
+func splitOrder(card, wallet string) string {
+    return `{"customer_id":"cust_123","items":[{"product_id":"prod_456","quantity":2,"price":"29.99"}],
+        "payment_splits":[{"amount":"` + card + `"},
+            {"amount":"` + wallet + `","payment_method":{"type":"wallet","wallet":{"provider":"apple_pay"}}}]}`
+}
+
+func TestSplitPaymentConfirmsWhenEveryPartIsApproved(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", splitOrder("20.00", "39.98"))
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if w.Code != http.StatusCreated || order.Status != StatusConfirmed {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    if n := fake.charges.Load(); n != 2 {
+        t.Fatalf("got %d charges, want one per split", n)
+    }
+    if last := fake.lastCharge.Load(); last.Amount.String() != "39.98" || last.PaymentMethod != PaymentMethodWallet {
+        t.Fatalf("last charge %+v, want 39.98 by wallet", last)
+    }
+    stored, _ := orders.FindByID(order.OrderID)
+    for i, split := range stored.PaymentSplits {
+        if split.Status != SplitApproved || split.PaymentID == nil {
+            t.Fatalf("split %d is %+v, want approved with its payment ID", i, split)
+        }
+    }
+}
+
+func TestSplitPaymentRefundsEarlierPartsWhenOneIsDeclined(t *testing.T) {
+    fake := newPaymentServer(t)
+    fake.declineMethod = PaymentMethodWallet
+    resetOrders(t)
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", splitOrder("20.00", "39.98"))
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if order.Status != StatusPaymentFailed {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    if n := fake.refunds.Load(); n != 1 {
+        t.Fatalf("got %d refunds, want the card part refunded", n)
+    }
+    if refund := fake.lastRefund.Load(); refund.Amount.String() != "20" || refund.OrderID != order.OrderID {
+        t.Fatalf("refunded %+v, want the 20.00 card part", refund)
+    }
+    stored, _ := orders.FindByID(order.OrderID)
+    if got := []string{stored.PaymentSplits[0].Status, stored.PaymentSplits[1].Status}; got[0] != SplitRefunded || got[1] != SplitDeclined {
+        t.Fatalf("got split statuses %v, want refunded and declined", got)
+    }
+}
+
+func TestSplitPaymentKeepsOrderWhenALaterPartErrors(t *testing.T) {
+    fake := newPaymentServer(t)
+    fake.failMethod = PaymentMethodWallet
+    payments.sleep = func(ctx context.Context, d time.Duration) error { return ctx.Err() }
+    resetOrders(t)
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", splitOrder("20.00", "39.98"))
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if w.Code != http.StatusCreated || order.Status != StatusPaymentFailed {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    stored, err := orders.FindByID(order.OrderID)
+    if err != nil {
+        t.Fatalf("failed order not kept: %v", err)
+    }
+    if got := []string{stored.PaymentSplits[0].Status, stored.PaymentSplits[1].Status}; got[0] != SplitRefunded || got[1] != SplitFailed {
+        t.Fatalf("got split statuses %v, want refunded and failed", got)
+    }
+    refund := fake.lastRefund.Load()
+    if n := fake.refunds.Load(); n != 1 || refund.PaymentID == nil || *refund.PaymentID != *stored.PaymentSplits[0].PaymentID {
+        t.Fatalf("got %d refunds, last %+v; want the card part's payment %s refunded", n, refund, stored.PaymentSplits[0].PaymentID)
+    }
+    if refund.IdempotencyKey == "" {
+        t.Fatal("split refund sent without an idempotency key")
+    }
+}
+
+func TestSplitPaymentRecordsFailedRefund(t *testing.T) {
+    fake := newPaymentServer(t)
+    fake.declineMethod = PaymentMethodWallet
+    fake.refundStatus = "failed"
+    resetOrders(t)
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", splitOrder("20.00", "39.98"))
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if order.Status != StatusPaymentFailed {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    stored, _ := orders.FindByID(order.OrderID)
+    if got := []string{stored.PaymentSplits[0].Status, stored.PaymentSplits[1].Status}; got[0] != SplitRefundFailed || got[1] != SplitDeclined {
+        t.Fatalf("got split statuses %v, want refund_failed and declined", got)
+    }
+}
+
+// splitCallback is a signed payment callback for the i'th split of order.
+func splitCallback(order *Order, i int, status string) (body, signature string) {
+    data, _ := json.Marshal(PaymentResponse{
+        PaymentID:   *order.PaymentSplits[i].PaymentID,
+        OrderID:     order.OrderID,
+        Status:      status,
+        ProcessedAt: time.Now(),
+    })
+    return string(data), signWebhook([]byte(testWebhookSecret), data)
+}
+
+func TestPendingSplitChargesTheRestOnceApproved(t *testing.T) {
+    useWebhookSecret(t)
+    fake := newPaymentServer(t)
+    fake.pendingMethod = PaymentMethodCard
+    resetOrders(t)
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", splitOrder("20.00", "39.98"))
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if w.Code != http.StatusCreated || order.Status != StatusPending {
+        t.Fatalf("got status %d, order %q; want a pending order: %s", w.Code, order.Status, w.Body)
+    }
+    if n := fake.charges.Load(); n != 1 || order.PaymentSplits[0].Status != SplitPending || order.PaymentSplits[1].Status != "" {
+        t.Fatalf("got %d charges with splits %+v; want only the card part charged, pending", n, order.PaymentSplits)
+    }
+
+    stale := paymentCallback(order.OrderID, "approved")
+    if code, resp := postWebhook(r, stale, signWebhook([]byte(testWebhookSecret), []byte(stale))); code != http.StatusOK || resp["applied"] != false {
+        t.Fatalf("callback for another payment: got %d %v, want it ignored", code, resp)
+    }
+    body, signature := splitCallback(&order, 0, "approved")
+    if code, resp := postWebhook(r, body, signature); code != http.StatusOK || resp["applied"] != true {
+        t.Fatalf("got %d %v", code, resp)
+    }
+    stored, _ := orders.FindByID(order.OrderID)
+    if stored.Status != StatusConfirmed || fake.charges.Load() != 2 {
+        t.Fatalf("order %s after %d charges, want confirmed after the wallet part is charged", stored.Status, fake.charges.Load())
+    }
+    for i, split := range stored.PaymentSplits {
+        if split.Status != SplitApproved {
+            t.Fatalf("split %d is %+v, want approved", i, split)
+        }
+    }
+}
+
+func TestPendingSplitDeclinedRefundsEarlierParts(t *testing.T) {
+    useWebhookSecret(t)
+    fake := newPaymentServer(t)
+    fake.pendingMethod = PaymentMethodWallet
+    resetOrders(t)
+    inv := newInventoryServer(t, map[string]int{"prod_456": 5})
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", splitOrder("20.00", "39.98"))
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if order.Status != StatusPending || fake.refunds.Load() != 0 {
+        t.Fatalf("got order %q after %d refunds, want it pending with nothing refunded", order.Status, fake.refunds.Load())
+    }
+
+    body, signature := splitCallback(&order, 1, "declined")
+    if code, resp := postWebhook(r, body, signature); code != http.StatusOK || resp["applied"] != true {
+        t.Fatalf("got %d %v", code, resp)
+    }
+    stored, _ := orders.FindByID(order.OrderID)
+    if stored.Status != StatusPaymentFailed {
+        t.Fatalf("order status %s, want payment_failed", stored.Status)
+    }
+    if got := []string{stored.PaymentSplits[0].Status, stored.PaymentSplits[1].Status}; got[0] != SplitRefunded || got[1] != SplitDeclined {
+        t.Fatalf("got split statuses %v, want refunded and declined", got)
+    }
+    if refund := fake.lastRefund.Load(); refund == nil || *refund.PaymentID != *stored.PaymentSplits[0].PaymentID {
+        t.Fatalf("refunded %+v, want the card part", refund)
+    }
+    if _, held, released := inv.snapshot(); held != 0 || released != 1 {
+        t.Fatalf("failed order has %d reservations held, %d released", held, released)
+    }
+}
+
+func TestSplitPaymentMustAddUpToTotal(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", splitOrder("20.00", "39.97"))
+    if w.Code != http.StatusUnprocessableEntity {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    if fields := decodeValidationError(w).Fields; len(fields) != 1 || fields[0].Field != "payment_splits" {
+        t.Fatalf("got fields %+v, want payment_splits", fields)
+    }
+    if n := fake.charges.Load(); n != 0 {
+        t.Fatalf("got %d charges for a rejected order", n)
+    }
+}
+
+func TestSplitPaymentValidation(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    body := `{"customer_id":"cust_123","items":[{"product_id":"prod_456","quantity":2,"price":"29.99"}],
+        "payment_method":{"type":"wallet","wallet":{"provider":"apple_pay"}},
+        "payment_splits":[{"amount":"0"},{"amount":"59.985","payment_method":{"type":"wallet","wallet":{"provider":"nope"}}}]}`
+    w := doRequest(r, http.MethodPost, "/orders", body)
+    got := map[string]bool{}
+    for _, f := range decodeValidationError(w).Fields {
+        got[f.Field] = true
+    }
+    for _, field := range []string{"payment_method", "payment_splits[0].amount", "payment_splits[1].amount", "payment_splits[1].payment_method.wallet.provider"} {
+        if !got[field] {
+            t.Errorf("no error for %s; got %v", field, got)
+        }
+    }
+}
+
+// createSplitOrder creates a confirmed order paid 20.00 by card and 39.98
+// by wallet.
+func createSplitOrder(t *testing.T, r http.Handler) *Order {
+    t.Helper()
+
+    w := doRequest(r, http.MethodPost, "/orders", splitOrder("20.00", "39.98"))
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if order.Status != StatusConfirmed {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    return &order
+}
+
+func TestSplitOrderRefundedFromEachPayment(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+    order := createSplitOrder(t, r)
+    card, wallet := *order.PaymentSplits[0].PaymentID, *order.PaymentSplits[1].PaymentID
+
+    code, got := refund(t, r, order, `{"amount":"30.00"}`)
+    if code != http.StatusOK || fake.refunds.Load() != 2 {
+        t.Fatalf("got status %d after %d refunds, want one refund per part", code, fake.refunds.Load())
+    }
+    parts := got.Refunds[0].Parts
+    if len(parts) != 2 || parts[0].PaymentID != card || parts[0].Amount.String() != "20" || parts[1].PaymentID != wallet || parts[1].Amount.String() != "10" {
+        t.Fatalf("got parts %+v, want 20 from the card and 10 from the wallet", parts)
+    }
+    if last := fake.lastRefund.Load(); last.PaymentID == nil || *last.PaymentID != wallet || last.IdempotencyKey == "" {
+        t.Fatalf("last refund %+v, want the wallet's payment under a key", last)
+    }
+
+    code, got = refund(t, r, order, "")
+    if code != http.StatusOK || got.Status != StatusRefunded || fake.refunds.Load() != 3 {
+        t.Fatalf("got status %d, order %q after %d refunds; want the rest refunded from the wallet alone", code, got.Status, fake.refunds.Load())
+    }
+    if parts := got.Refunds[1].Parts; len(parts) != 1 || parts[0].PaymentID != wallet || parts[0].Amount.String() != "29.98" {
+        t.Fatalf("got parts %+v, want 29.98 from the wallet", parts)
+    }
+}
+
+func TestCancelSplitOrderRefundsEachPayment(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+    order := createSplitOrder(t, r)
+
+    w := doRequestWithHeaders(r, http.MethodPost, "/orders/"+order.OrderID.String()+"/cancel", "", ifMatch(order))
+    var got Order
+    json.Unmarshal(w.Body.Bytes(), &got)
+    if w.Code != http.StatusOK || got.Status != StatusCancelled || got.RefundedAmount.String() != "59.98" {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    if n := fake.refunds.Load(); n != 2 || len(got.Refunds) != 1 || len(got.Refunds[0].Parts) != 2 {
+        t.Fatalf("got %d refunds recorded as %+v, want both parts refunded", n, got.Refunds)
+    }
+    for i, part := range got.Refunds[0].Parts {
+        if part.PaymentID != *order.PaymentSplits[i].PaymentID || !part.Amount.Equal(order.PaymentSplits[i].Amount) {
+            t.Fatalf("part %d is %+v, want split %d refunded in full", i, part, i)
+        }
+    }
+}
@@ -0,0 +1,749 @@
+package main
+
+import (
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "reflect"
+    "strings"
+    "sync/atomic"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/shopspring/decimal"
+    _ "modernc.org/sqlite"
+)
+
+// sqliteTimeLayout is a fixed-width UTC layout, so timestamps stored as TEXT
+// sort chronologically.
+const sqliteTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// sqliteMigrations are applied in order; PRAGMA user_version records how
+// many have run. Only ever append to this list.
+var sqliteMigrations = []string{
+    `CREATE TABLE orders (
+        order_id     TEXT PRIMARY KEY,
+        customer_id  TEXT NOT NULL,
+        items        TEXT NOT NULL,
+        total_amount TEXT NOT NULL,
+        status       TEXT NOT NULL,
+        created_at   TEXT NOT NULL
+    )`,
+    `CREATE INDEX orders_created_at ON orders (created_at DESC, order_id)`,
+    `ALTER TABLE orders ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD'`,
+    `CREATE INDEX orders_customer ON orders (customer_id, created_at DESC, order_id)`,
+    `ALTER TABLE orders ADD COLUMN refunded_amount TEXT NOT NULL DEFAULT '0'`,
+    `ALTER TABLE orders ADD COLUMN deleted_at TEXT`,
+    `ALTER TABLE orders ADD COLUMN payment_method TEXT`,
+    `ALTER TABLE orders ADD COLUMN expires_at TEXT`,
+    `ALTER TABLE orders ADD COLUMN reservation_ids TEXT NOT NULL DEFAULT '[]'`,
+    `ALTER TABLE orders ADD COLUMN destination TEXT NOT NULL DEFAULT ''`,
+    `ALTER TABLE orders ADD COLUMN subtotal TEXT NOT NULL DEFAULT '0'`,
+    // Orders from before the breakdown were charged their subtotal.
+    `UPDATE orders SET subtotal = total_amount`,
+    `ALTER TABLE orders ADD COLUMN tax TEXT NOT NULL DEFAULT '0'`,
+    `ALTER TABLE orders ADD COLUMN shipping TEXT NOT NULL DEFAULT '0'`,
+    `ALTER TABLE orders ADD COLUMN version INTEGER NOT NULL DEFAULT 0`,
+    `ALTER TABLE orders ADD COLUMN discount TEXT`,
+    `ALTER TABLE orders ADD COLUMN status_history TEXT NOT NULL DEFAULT '[]'`,
+    `ALTER TABLE orders ADD COLUMN shipments TEXT NOT NULL DEFAULT '[]'`,
+    `ALTER TABLE orders ADD COLUMN settlement TEXT`,
+    `CREATE TABLE outbox (
+        id         INTEGER PRIMARY KEY AUTOINCREMENT,
+        event      TEXT NOT NULL,
+        created_at TEXT NOT NULL,
+        sent_at    TEXT
+    )`,
+    `CREATE INDEX outbox_unsent ON outbox (id) WHERE sent_at IS NULL`,
+    `ALTER TABLE orders ADD COLUMN metadata TEXT NOT NULL DEFAULT '{}'`,
+    `ALTER TABLE orders ADD COLUMN notes TEXT NOT NULL DEFAULT ''`,
+    `ALTER TABLE orders ADD COLUMN shipping_address TEXT`,
+    `ALTER TABLE orders ADD COLUMN billing_address TEXT`,
+    `ALTER TABLE orders ADD COLUMN scheduled_for TEXT`,
+    `ALTER TABLE orders ADD COLUMN channel TEXT NOT NULL DEFAULT ''`,
+    `ALTER TABLE orders ADD COLUMN coupon TEXT`,
+    `ALTER TABLE orders ADD COLUMN payment_key TEXT NOT NULL DEFAULT ''`,
+    `ALTER TABLE orders ADD COLUMN customer_index TEXT`,
+    `CREATE INDEX orders_customer_index ON orders (customer_index)`,
+    `ALTER TABLE orders ADD COLUMN tags TEXT NOT NULL DEFAULT '[]'`,
+    `CREATE TABLE order_tags (
+        tag      TEXT NOT NULL,
+        order_id TEXT NOT NULL,
+        PRIMARY KEY (tag, order_id)
+    )`,
+    `ALTER TABLE orders ADD COLUMN refunds TEXT NOT NULL DEFAULT '[]'`,
+    `ALTER TABLE orders ADD COLUMN tag_history TEXT NOT NULL DEFAULT '[]'`,
+    `ALTER TABLE orders ADD COLUMN warnings TEXT NOT NULL DEFAULT '[]'`,
+    `ALTER TABLE orders ADD COLUMN updated_at TEXT NOT NULL DEFAULT ''`,
+    `UPDATE orders SET updated_at = created_at`,
+    `CREATE INDEX orders_updated_at ON orders (updated_at, order_id)`,
+    `ALTER TABLE orders ADD COLUMN fraud TEXT`,
+    `ALTER TABLE orders ADD COLUMN request_hash TEXT NOT NULL DEFAULT ''`,
+    `ALTER TABLE orders ADD COLUMN amendments TEXT NOT NULL DEFAULT '[]'`,
+    `ALTER TABLE orders ADD COLUMN payment_splits TEXT NOT NULL DEFAULT '[]'`,
+}
+
+// SQLiteRepository is an OrderRepository backed by a SQLite database. Items
+// are stored as a JSON column and money as decimal TEXT, never as floats.
+type SQLiteRepository struct {
+    db      *sql.DB
+    gen     atomic.Uint64
+    summary *SummaryCounters
+    // cipher, if set, encrypts the sensitive columns of every order saved.
+    cipher *FieldCipher
+}
+
+func OpenSQLiteRepository(path string) (*SQLiteRepository, error) {
+    return OpenEncryptedSQLiteRepository(path, nil)
+}
+
+// OpenEncryptedSQLiteRepository opens a repository that encrypts orders
+// with c as it saves them. Orders saved unencrypted are still read, and
+// are encrypted the next time they are saved.
+func OpenEncryptedSQLiteRepository(path string, c *FieldCipher) (*SQLiteRepository, error) {
+    db, err := sql.Open("sqlite", path)
+    if err != nil {
+        return nil, err
+    }
+    // SQLite allows a single writer; serialize access rather than fight
+    // SQLITE_BUSY.
+    db.SetMaxOpenConns(1)
+
+    if err := migrateSQLite(db); err != nil {
+        db.Close()
+        return nil, err
+    }
+    r := &SQLiteRepository{db: db, summary: NewSummaryCounters(), cipher: c}
+    if err := r.loadSummary(); err != nil {
+        db.Close()
+        return nil, err
+    }
+    return r, nil
+}
+
+// summaryColumns are what SummaryCounters needs of an order.
+const summaryColumns = `SELECT status, currency, total_amount, created_at, deleted_at FROM orders`
+
+// loadSummary counts the orders already in the database, once at open;
+// from then on Save and Delete keep the counters current. Writes by other
+// processes sharing the file aren't seen.
+func (r *SQLiteRepository) loadSummary() error {
+    rows, err := r.db.Query(summaryColumns)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        order, err := scanSummary(rows)
+        if err != nil {
+            return err
+        }
+        r.summary.apply(nil, order)
+    }
+    return rows.Err()
+}
+
+// scanSummary reads a row of summaryColumns into a partial Order.
+func scanSummary(row rowScanner) (*Order, error) {
+    var (
+        order            Order
+        total, createdAt string
+        deletedAt        sql.NullString
+    )
+    if err := row.Scan(&order.Status, &order.Currency, &total, &createdAt, &deletedAt); err != nil {
+        return nil, err
+    }
+    var err error
+    if order.TotalAmount, err = decimal.NewFromString(total); err != nil {
+        return nil, err
+    }
+    if order.CreatedAt, err = time.Parse(sqliteTimeLayout, createdAt); err != nil {
+        return nil, err
+    }
+    if order.DeletedAt, err = parseNullTime(deletedAt); err != nil {
+        return nil, err
+    }
+    return &order, nil
+}
+
+// storedSummary returns the stored version of the order with id as
+// scanSummary reads it, or nil if there is none.
+func storedSummary(tx *sql.Tx, id uuid.UUID) (*Order, error) {
+    order, err := scanSummary(tx.QueryRow(summaryColumns+` WHERE order_id = ?`, id.String()))
+    if errors.Is(err, sql.ErrNoRows) {
+        return nil, nil
+    }
+    return order, err
+}
+
+func migrateSQLite(db *sql.DB) error {
+    var version int
+    if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+        return err
+    }
+    for i := version; i < len(sqliteMigrations); i++ {
+        tx, err := db.Begin()
+        if err != nil {
+            return err
+        }
+        if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+            tx.Rollback()
+            return fmt.Errorf("migration %d: %w", i+1, err)
+        }
+        if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+            tx.Rollback()
+            return err
+        }
+        if err := tx.Commit(); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (r *SQLiteRepository) Close() error {
+    return r.db.Close()
+}
+
+// nullJSON encodes v for a nullable JSON column: NULL when v is a nil
+// pointer.
+func nullJSON(v interface{}) (sql.NullString, error) {
+    if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && rv.IsNil() {
+        return sql.NullString{}, nil
+    }
+    b, err := json.Marshal(v)
+    if err != nil {
+        return sql.NullString{}, err
+    }
+    return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+// execer is what saveOrder needs of a *sql.DB or *sql.Tx.
+type execer interface {
+    Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func (r *SQLiteRepository) Save(order *Order) error {
+    return r.SaveWithEvents(order, nil)
+}
+
+// SaveWithEvents saves order and adds events to the outbox in one
+// transaction.
+func (r *SQLiteRepository) SaveWithEvents(order *Order, events []OrderEvent) error {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    // The stored version is read in the same transaction as the write, so
+    // the summary moves the order from exactly where it was.
+    prev, err := storedSummary(tx, order.OrderID)
+    if err != nil {
+        return err
+    }
+    if err := saveOrder(tx, order, r.cipher); err != nil {
+        return err
+    }
+    for _, event := range events {
+        payload, err := json.Marshal(event)
+        if err != nil {
+            return err
+        }
+        if _, err := tx.Exec(`INSERT INTO outbox (event, created_at) VALUES (?, ?)`,
+            string(payload), event.Timestamp.UTC().Format(sqliteTimeLayout)); err != nil {
+            return err
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        return err
+    }
+    order.Version++
+    r.summary.apply(prev, order)
+    r.gen.Add(1)
+    return nil
+}
+
+func (r *SQLiteRepository) UnsentEvents(limit int) ([]OutboxEntry, error) {
+    rows, err := r.db.Query(`SELECT id, event FROM outbox WHERE sent_at IS NULL ORDER BY id LIMIT ?`, limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var entries []OutboxEntry
+    for rows.Next() {
+        var (
+            entry   OutboxEntry
+            payload string
+        )
+        if err := rows.Scan(&entry.ID, &payload); err != nil {
+            return nil, err
+        }
+        if err := json.Unmarshal([]byte(payload), &entry.Event); err != nil {
+            return nil, fmt.Errorf("outbox entry %d: %w", entry.ID, err)
+        }
+        entries = append(entries, entry)
+    }
+    return entries, rows.Err()
+}
+
+func (r *SQLiteRepository) MarkSent(id int64) error {
+    _, err := r.db.Exec(`UPDATE outbox SET sent_at = ? WHERE id = ? AND sent_at IS NULL`,
+        clock.Now().UTC().Format(sqliteTimeLayout), id)
+    return err
+}
+
+// saveOrder upserts order through db, encrypted with c if it is set,
+// leaving order.Version for the caller to bump once the write is committed.
+func saveOrder(db execer, order *Order, c *FieldCipher) error {
+    items, err := json.Marshal(order.Items)
+    if err != nil {
+        return err
+    }
+    var paymentMethod sql.NullString
+    if order.PaymentMethod != nil {
+        b, err := json.Marshal(order.PaymentMethod)
+        if err != nil {
+            return err
+        }
+        paymentMethod = sql.NullString{String: string(b), Valid: true}
+    }
+    var discount sql.NullString
+    if order.Discount != nil {
+        b, err := json.Marshal(order.Discount)
+        if err != nil {
+            return err
+        }
+        discount = sql.NullString{String: string(b), Valid: true}
+    }
+    settlement, err := nullJSON(order.Settlement)
+    if err != nil {
+        return err
+    }
+    shippingAddress, err := nullJSON(order.ShippingAddress)
+    if err != nil {
+        return err
+    }
+    billingAddress, err := nullJSON(order.BillingAddress)
+    if err != nil {
+        return err
+    }
+    coupon, err := nullJSON(order.Coupon)
+    if err != nil {
+        return err
+    }
+    fraud, err := nullJSON(order.Fraud)
+    if err != nil {
+        return err
+    }
+    reservationIDs, err := json.Marshal(order.ReservationIDs)
+    if err != nil {
+        return err
+    }
+    statusHistory, err := json.Marshal(order.StatusHistory)
+    if err != nil {
+        return err
+    }
+    shipments, err := json.Marshal(order.Shipments)
+    if err != nil {
+        return err
+    }
+    metadata, err := json.Marshal(order.Metadata)
+    if err != nil {
+        return err
+    }
+    tags, err := json.Marshal(order.Tags)
+    if err != nil {
+        return err
+    }
+    refunds, err := json.Marshal(order.Refunds)
+    if err != nil {
+        return err
+    }
+    tagHistory, err := json.Marshal(order.TagHistory)
+    if err != nil {
+        return err
+    }
+    warnings, err := json.Marshal(order.Warnings)
+    if err != nil {
+        return err
+    }
+    amendments, err := json.Marshal(order.Amendments)
+    if err != nil {
+        return err
+    }
+    paymentSplits, err := json.Marshal(order.PaymentSplits)
+    if err != nil {
+        return err
+    }
+
+    updatedAt := clock.Now()
+
+    // The upsert only overwrites the row still at the version the caller
+    // read, so a stale save changes nothing and is reported as a conflict.
+    res, err := db.Exec(`
+        INSERT INTO orders (order_id, customer_id, items, currency, total_amount, refunded_amount, status, created_at, deleted_at, payment_method, expires_at, reservation_ids,
+            destination, subtotal, tax, shipping, version, discount, status_history, shipments, settlement, metadata, notes,
+            shipping_address, billing_address, scheduled_for, channel, coupon, payment_key, customer_index, tags, refunds, tag_history, warnings, updated_at, fraud, request_hash, amendments, payment_splits)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (order_id) DO UPDATE SET
+            customer_id     = excluded.customer_id,
+            items           = excluded.items,
+            currency        = excluded.currency,
+            total_amount    = excluded.total_amount,
+            refunded_amount = excluded.refunded_amount,
+            status          = excluded.status,
+            created_at      = excluded.created_at,
+            deleted_at      = excluded.deleted_at,
+            payment_method  = excluded.payment_method,
+            expires_at      = excluded.expires_at,
+            reservation_ids = excluded.reservation_ids,
+            destination     = excluded.destination,
+            subtotal        = excluded.subtotal,
+            tax             = excluded.tax,
+            shipping        = excluded.shipping,
+            version         = excluded.version,
+            discount        = excluded.discount,
+            status_history  = excluded.status_history,
+            shipments       = excluded.shipments,
+            settlement      = excluded.settlement,
+            metadata        = excluded.metadata,
+            notes           = excluded.notes,
+            shipping_address = excluded.shipping_address,
+            billing_address = excluded.billing_address,
+            scheduled_for   = excluded.scheduled_for,
+            channel         = excluded.channel,
+            coupon          = excluded.coupon,
+            payment_key     = excluded.payment_key,
+            customer_index  = excluded.customer_index,
+            tags            = excluded.tags,
+            refunds         = excluded.refunds,
+            tag_history     = excluded.tag_history,
+            warnings        = excluded.warnings,
+            updated_at      = excluded.updated_at,
+            fraud           = excluded.fraud,
+            request_hash    = excluded.request_hash,
+            amendments      = excluded.amendments,
+            payment_splits  = excluded.payment_splits
+        WHERE orders.version = ?`,
+        order.OrderID.String(),
+        c.seal("customer_id", order.CustomerID),
+        string(items),
+        order.Currency,
+        order.TotalAmount.String(),
+        order.RefundedAmount.String(),
+        order.Status,
+        order.CreatedAt.UTC().Format(sqliteTimeLayout),
+        formatNullTime(order.DeletedAt),
+        c.sealNull("payment_method", paymentMethod),
+        formatNullTime(order.ExpiresAt),
+        string(reservationIDs),
+        order.Destination,
+        order.Subtotal.String(),
+        order.Tax.String(),
+        order.Shipping.String(),
+        order.Version+1,
+        discount,
+        string(statusHistory),
+        string(shipments),
+        settlement,
+        c.seal("metadata", string(metadata)),
+        c.seal("notes", order.Notes),
+        c.sealNull("shipping_address", shippingAddress),
+        c.sealNull("billing_address", billingAddress),
+        formatNullTime(order.ScheduledFor),
+        order.Channel,
+        coupon,
+        order.PaymentKey,
+        c.customerIndex(order.CustomerID),
+        string(tags),
+        string(refunds),
+        string(tagHistory),
+        string(warnings),
+        updatedAt.UTC().Format(sqliteTimeLayout),
+        fraud,
+        order.RequestHash,
+        string(amendments),
+        c.seal("payment_splits", string(paymentSplits)),
+        order.Version,
+    )
+    if err != nil {
+        return err
+    }
+    n, err := res.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if n == 0 {
+        return ErrVersionConflict
+    }
+    order.UpdatedAt = updatedAt
+
+    // order_tags indexes each order under each of its tags.
+    if _, err := db.Exec(`DELETE FROM order_tags WHERE order_id = ?`, order.OrderID.String()); err != nil {
+        return err
+    }
+    for _, tag := range order.Tags {
+        if _, err := db.Exec(`INSERT INTO order_tags (tag, order_id) VALUES (?, ?)`, tag, order.OrderID.String()); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+const selectOrderColumns = `SELECT order_id, customer_id, items, currency, total_amount, refunded_amount, status, created_at, deleted_at, payment_method, expires_at, reservation_ids,
+    destination, subtotal, tax, shipping, version, discount, status_history, shipments, settlement, metadata, notes,
+    shipping_address, billing_address, scheduled_for, channel, coupon, payment_key, tags, refunds, tag_history, warnings, updated_at, fraud, request_hash, amendments, payment_splits FROM orders`
+
+type rowScanner interface {
+    Scan(dest ...interface{}) error
+}
+
+// scanOrder reads a row of selectOrderColumns, decrypting it with c.
+func scanOrder(row rowScanner, c *FieldCipher) (*Order, error) {
+    var (
+        order                                                 Order
+        id, items, total, refunded, createdAt, reservationIDs string
+        subtotal, tax, shipping, statusHistory, shipments     string
+        customerID, metadata, notes, tags                     string
+        refunds, tagHistory, warnings, updatedAt, amendments  string
+        paymentSplits                                         string
+        deletedAt, paymentMethod, expiresAt, discount         sql.NullString
+        settlement, shippingAddress, billingAddress           sql.NullString
+        scheduledFor, coupon, fraud                           sql.NullString
+    )
+    if err := row.Scan(&id, &customerID, &items, &order.Currency, &total, &refunded, &order.Status, &createdAt,
+        &deletedAt, &paymentMethod, &expiresAt, &reservationIDs, &order.Destination, &subtotal, &tax, &shipping, &order.Version, &discount, &statusHistory, &shipments, &settlement, &metadata, &notes,
+        &shippingAddress, &billingAddress, &scheduledFor, &order.Channel, &coupon, &order.PaymentKey, &tags, &refunds, &tagHistory, &warnings, &updatedAt, &fraud, &order.RequestHash, &amendments, &paymentSplits); err != nil {
+        return nil, err
+    }
+
+    var err error
+    if order.OrderID, err = uuid.Parse(id); err != nil {
+        return nil, err
+    }
+    if order.CustomerID, err = c.open("customer_id", customerID); err != nil {
+        return nil, err
+    }
+    if metadata, err = c.open("metadata", metadata); err != nil {
+        return nil, err
+    }
+    if order.Notes, err = c.open("notes", notes); err != nil {
+        return nil, err
+    }
+    if paymentMethod, err = c.openNull("payment_method", paymentMethod); err != nil {
+        return nil, err
+    }
+    if shippingAddress, err = c.openNull("shipping_address", shippingAddress); err != nil {
+        return nil, err
+    }
+    if billingAddress, err = c.openNull("billing_address", billingAddress); err != nil {
+        return nil, err
+    }
+    if err := json.Unmarshal([]byte(items), &order.Items); err != nil {
+        return nil, err
+    }
+    if order.TotalAmount, err = decimal.NewFromString(total); err != nil {
+        return nil, err
+    }
+    if order.RefundedAmount, err = decimal.NewFromString(refunded); err != nil {
+        return nil, err
+    }
+    if order.Subtotal, err = decimal.NewFromString(subtotal); err != nil {
+        return nil, err
+    }
+    if order.Tax, err = decimal.NewFromString(tax); err != nil {
+        return nil, err
+    }
+    if order.Shipping, err = decimal.NewFromString(shipping); err != nil {
+        return nil, err
+    }
+    if order.CreatedAt, err = time.Parse(sqliteTimeLayout, createdAt); err != nil {
+        return nil, err
+    }
+    if order.UpdatedAt, err = time.Parse(sqliteTimeLayout, updatedAt); err != nil {
+        return nil, err
+    }
+    if order.DeletedAt, err = parseNullTime(deletedAt); err != nil {
+        return nil, err
+    }
+    if order.ExpiresAt, err = parseNullTime(expiresAt); err != nil {
+        return nil, err
+    }
+    if order.ScheduledFor, err = parseNullTime(scheduledFor); err != nil {
+        return nil, err
+    }
+    if err := json.Unmarshal([]byte(reservationIDs), &order.ReservationIDs); err != nil {
+        return nil, err
+    }
+    if err := json.Unmarshal([]byte(statusHistory), &order.StatusHistory); err != nil {
+        return nil, err
+    }
+    if err := json.Unmarshal([]byte(shipments), &order.Shipments); err != nil {
+        return nil, err
+    }
+    if err := json.Unmarshal([]byte(metadata), &order.Metadata); err != nil {
+        return nil, err
+    }
+    if err := json.Unmarshal([]byte(tags), &order.Tags); err != nil {
+        return nil, err
+    }
+    if err := json.Unmarshal([]byte(refunds), &order.Refunds); err != nil {
+        return nil, err
+    }
+    if err := json.Unmarshal([]byte(tagHistory), &order.TagHistory); err != nil {
+        return nil, err
+    }
+    if err := json.Unmarshal([]byte(warnings), &order.Warnings); err != nil {
+        return nil, err
+    }
+    if err := json.Unmarshal([]byte(amendments), &order.Amendments); err != nil {
+        return nil, err
+    }
+    if paymentSplits, err = c.open("payment_splits", paymentSplits); err != nil {
+        return nil, err
+    }
+    if err := json.Unmarshal([]byte(paymentSplits), &order.PaymentSplits); err != nil {
+        return nil, err
+    }
+    if paymentMethod.Valid {
+        if err := json.Unmarshal([]byte(paymentMethod.String), &order.PaymentMethod); err != nil {
+            return nil, err
+        }
+    }
+    for _, col := range []struct {
+        value sql.NullString
+        dest  interface{}
+    }{
+        {settlement, &order.Settlement},
+        {shippingAddress, &order.ShippingAddress},
+        {billingAddress, &order.BillingAddress},
+        {coupon, &order.Coupon},
+        {fraud, &order.Fraud},
+    } {
+        if col.value.Valid {
+            if err := json.Unmarshal([]byte(col.value.String), col.dest); err != nil {
+                return nil, err
+            }
+        }
+    }
+    if discount.Valid {
+        if err := json.Unmarshal([]byte(discount.String), &order.Discount); err != nil {
+            return nil, err
+        }
+    }
+    return &order, nil
+}
+
+func formatNullTime(t *time.Time) sql.NullString {
+    if t == nil {
+        return sql.NullString{}
+    }
+    return sql.NullString{String: t.UTC().Format(sqliteTimeLayout), Valid: true}
+}
+
+func parseNullTime(s sql.NullString) (*time.Time, error) {
+    if !s.Valid {
+        return nil, nil
+    }
+    t, err := time.Parse(sqliteTimeLayout, s.String)
+    if err != nil {
+        return nil, err
+    }
+    return &t, nil
+}
+
+func (r *SQLiteRepository) FindByID(id uuid.UUID) (*Order, error) {
+    order, err := scanOrder(r.db.QueryRow(selectOrderColumns+` WHERE order_id = ?`, id.String()), r.cipher)
+    if errors.Is(err, sql.ErrNoRows) {
+        return nil, ErrOrderNotFound
+    }
+    return order, err
+}
+
+func (r *SQLiteRepository) List() ([]*Order, error) {
+    return r.query(selectOrderColumns + ` ORDER BY created_at DESC, order_id`)
+}
+
+// ListByCustomer matches encrypted orders by their customer index and
+// unencrypted ones by customer_id; ciphertext never equals a plain ID.
+func (r *SQLiteRepository) ListByCustomer(customerID string) ([]*Order, error) {
+    return r.query(selectOrderColumns+` WHERE (customer_id = ? OR customer_index = ?) ORDER BY created_at DESC, order_id`,
+        customerID, r.cipher.customerIndex(customerID))
+}
+
+func (r *SQLiteRepository) ListByTags(tags []string) ([]*Order, error) {
+    if len(tags) == 0 {
+        return []*Order{}, nil
+    }
+    placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(tags)), ", ")
+    args := make([]interface{}, 0, len(tags)+1)
+    for _, tag := range tags {
+        args = append(args, tag)
+    }
+    args = append(args, len(tags))
+    return r.query(selectOrderColumns+` WHERE order_id IN (
+        SELECT order_id FROM order_tags WHERE tag IN (`+placeholders+`) GROUP BY order_id HAVING COUNT(*) = ?
+    ) ORDER BY created_at DESC, order_id`, args...)
+}
+
+func (r *SQLiteRepository) ListUpdatedSince(since time.Time) ([]*Order, error) {
+    return r.query(selectOrderColumns+` WHERE updated_at >= ? ORDER BY updated_at, order_id`,
+        since.UTC().Format(sqliteTimeLayout))
+}
+
+func (r *SQLiteRepository) query(query string, args ...interface{}) ([]*Order, error) {
+    rows, err := r.db.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    list := []*Order{}
+    for rows.Next() {
+        order, err := scanOrder(rows, r.cipher)
+        if err != nil {
+            return nil, err
+        }
+        list = append(list, order)
+    }
+    return list, rows.Err()
+}
+
+func (r *SQLiteRepository) Delete(id uuid.UUID) error {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    prev, err := storedSummary(tx, id)
+    if err != nil {
+        return err
+    }
+    if _, err := tx.Exec(`DELETE FROM orders WHERE order_id = ?`, id.String()); err != nil {
+        return err
+    }
+    if _, err := tx.Exec(`DELETE FROM order_tags WHERE order_id = ?`, id.String()); err != nil {
+        return err
+    }
+    if err := tx.Commit(); err != nil {
+        return err
+    }
+    r.summary.apply(prev, nil)
+    r.gen.Add(1)
+    return nil
+}
+
+func (r *SQLiteRepository) Summary(from, to string) (OrderSummary, error) {
+    return r.summary.summary(from, to), nil
+}
+
+// Generation only sees writes made through r, not by other processes
+// sharing the database file.
+func (r *SQLiteRepository) Generation() uint64 {
+    return r.gen.Load()
+}
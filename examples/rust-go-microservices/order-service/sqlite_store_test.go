@@ -0,0 +1,143 @@
+package main
+
+import (
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/shopspring/decimal"
+)
+
+func openTestSQLite(t *testing.T, path string) *SQLiteRepository {
+    t.Helper()
+
+    repo, err := OpenSQLiteRepository(path)
+    if err != nil {
+        t.Fatalf("open sqlite: %v", err)
+    }
+    t.Cleanup(func() { repo.Close() })
+    return repo
+}
+
+func TestSQLiteRepositorySurvivesRestart(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "orders.db")
+    order := &Order{
+        OrderID:    uuid.New(),
+        CustomerID: "cust_123",
+        Items: []OrderItem{
+            {ProductID: "prod_456", Quantity: 3, Price: decimal.RequireFromString("0.10")},
+        },
+        Currency:    "EUR",
+        TotalAmount: decimal.RequireFromString("12345678901234567890.30"),
+        Status:      StatusConfirmed,
+        CreatedAt:   time.Now(),
+        PaymentKey:  uuid.NewString(),
+    }
+
+    first := openTestSQLite(t, path)
+    if err := first.Save(order); err != nil {
+        t.Fatalf("Save: %v", err)
+    }
+    first.Close()
+
+    repo := openTestSQLite(t, path)
+    got, err := repo.FindByID(order.OrderID)
+    if err != nil {
+        t.Fatalf("FindByID after restart: %v", err)
+    }
+    if got.CustomerID != order.CustomerID || got.Currency != "EUR" || got.Status != order.Status || !got.CreatedAt.Equal(order.CreatedAt) ||
+        got.PaymentKey != order.PaymentKey {
+        t.Fatalf("got %+v, want %+v", got, order)
+    }
+    if got.TotalAmount.String() != "12345678901234567890.3" {
+        t.Fatalf("total lost precision: %s", got.TotalAmount)
+    }
+    if len(got.Items) != 1 || got.Items[0].Price.String() != "0.1" || got.Items[0].Quantity != 3 {
+        t.Fatalf("items not round-tripped: %+v", got.Items)
+    }
+}
+
+func TestSQLiteRepositoryListAndDelete(t *testing.T) {
+    repo := openTestSQLite(t, filepath.Join(t.TempDir(), "orders.db"))
+    base := time.Now()
+    older := &Order{OrderID: uuid.New(), CustomerID: "alice", Status: StatusPending, CreatedAt: base}
+    newer := &Order{OrderID: uuid.New(), CustomerID: "bob", Status: StatusPending, CreatedAt: base.Add(time.Second)}
+    for _, o := range []*Order{older, newer} {
+        if err := repo.Save(o); err != nil {
+            t.Fatalf("Save: %v", err)
+        }
+    }
+
+    newer.Status = StatusConfirmed
+    if err := repo.Save(newer); err != nil {
+        t.Fatalf("Save update: %v", err)
+    }
+
+    list, err := repo.List()
+    if err != nil {
+        t.Fatalf("List: %v", err)
+    }
+    if len(list) != 2 || list[0].OrderID != newer.OrderID || list[0].Status != StatusConfirmed {
+        t.Fatalf("unexpected list: %+v", list)
+    }
+
+    byCustomer, err := repo.ListByCustomer("alice")
+    if err != nil || len(byCustomer) != 1 || byCustomer[0].OrderID != older.OrderID {
+        t.Fatalf("ListByCustomer = %+v, %v", byCustomer, err)
+    }
+
+    if err := repo.Delete(older.OrderID); err != nil {
+        t.Fatalf("Delete: %v", err)
+    }
+    if _, err := repo.FindByID(older.OrderID); err != ErrOrderNotFound {
+        t.Fatalf("FindByID after Delete: got %v, want ErrOrderNotFound", err)
+    }
+}
+
+func TestSQLiteRepositoryRoundTripsDeletedAt(t *testing.T) {
+    repo := openTestSQLite(t, filepath.Join(t.TempDir(), "orders.db"))
+    order := &Order{OrderID: uuid.New(), CustomerID: "c", Currency: "USD", Status: StatusCancelled, CreatedAt: time.Now()}
+    if err := repo.Save(order); err != nil {
+        t.Fatal(err)
+    }
+    if got, _ := repo.FindByID(order.OrderID); got.DeletedAt != nil {
+        t.Fatalf("new order has DeletedAt %v", got.DeletedAt)
+    }
+
+    deletedAt := time.Now()
+    order.DeletedAt = &deletedAt
+    if err := repo.Save(order); err != nil {
+        t.Fatal(err)
+    }
+    if got, _ := repo.FindByID(order.OrderID); got.DeletedAt == nil || !got.DeletedAt.Equal(deletedAt) {
+        t.Fatalf("got DeletedAt %v, want %v", got.DeletedAt, deletedAt)
+    }
+}
+
+func TestSQLiteRepositoryRoundTripsPriceBreakdown(t *testing.T) {
+    repo := openTestSQLite(t, filepath.Join(t.TempDir(), "orders.db"))
+    order := &Order{
+        OrderID:     uuid.New(),
+        CustomerID:  "c",
+        Currency:    "USD",
+        Destination: "DE",
+        Subtotal:    decimal.RequireFromString("100"),
+        Tax:         decimal.RequireFromString("19"),
+        Shipping:    decimal.RequireFromString("4.99"),
+        TotalAmount: decimal.RequireFromString("123.99"),
+        Status:      StatusConfirmed,
+        CreatedAt:   time.Now(),
+    }
+    if err := repo.Save(order); err != nil {
+        t.Fatal(err)
+    }
+
+    got, err := repo.FindByID(order.OrderID)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got.Destination != "DE" || !got.Subtotal.Equal(order.Subtotal) || !got.Tax.Equal(order.Tax) || !got.Shipping.Equal(order.Shipping) {
+        t.Fatalf("got destination %q, subtotal %s, tax %s, shipping %s", got.Destination, got.Subtotal, got.Tax, got.Shipping)
+    }
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+    "context"
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+    "github.com/gin-gonic/gin/binding"
+)
+
+const contentTypeEventStream = "text/event-stream"
+
+// Steps of order creation reported to a client streaming its progress, in
+// the order they happen. "reserved" is only sent when inventory is
+// configured.
+const (
+    ProgressValidated       = "validated"
+    ProgressReserved        = "reserved"
+    ProgressPaymentApproved = "payment_approved"
+    ProgressPaymentDeclined = "payment_declined"
+)
+
+type progressKey struct{}
+
+// withProgress returns a context that has submitOrder report each step it
+// completes to report.
+func withProgress(ctx context.Context, report func(step string, order *Order)) context.Context {
+    return context.WithValue(ctx, progressKey{}, report)
+}
+
+// reportProgress tells the client, if it is streaming, that step is done.
+func reportProgress(ctx context.Context, step string, order *Order) {
+    if report, ok := ctx.Value(progressKey{}).(func(string, *Order)); ok {
+        report(step, order)
+    }
+}
+
+// wantsEventStream reports whether the Accept header asks for server-sent
+// events rather than JSON.
+func wantsEventStream(c *gin.Context) bool {
+    return c.NegotiateFormat(binding.MIMEJSON, contentTypeEventStream) == contentTypeEventStream
+}
+
+// eventStream writes the progress of order creation as server-sent events.
+// The stream starts with the first event, so a request that fails before
+// any step completes, such as one that doesn't validate, still gets an
+// ordinary error response with its status code.
+//
+// Once the client disconnects nothing more is written; the request's
+// context is cancelled too, which stops the work as it would for a JSON
+// request.
+type eventStream struct {
+    c       *gin.Context
+    started bool
+}
+
+func (s *eventStream) send(event string, data interface{}) {
+    if s.c.Request.Context().Err() != nil {
+        return
+    }
+    if !s.started {
+        s.started = true
+        s.c.Header("Cache-Control", "no-cache")
+        s.c.Header("X-Accel-Buffering", "no")
+        s.c.Status(http.StatusOK)
+    }
+    s.c.SSEvent(event, data)
+    s.c.Writer.Flush()
+}
+
+func (s *eventStream) progress(step string, order *Order) {
+    s.send(step, gin.H{"order_id": order.OrderID, "status": order.Status})
+}
+
+// finish sends the final event: the order, named after its status, or the
+// error that stopped it.
+func (s *eventStream) finish(order *Order, rerr *requestError) {
+    switch {
+    case rerr != nil && !s.started:
+        rerr.respond(s.c)
+    case rerr != nil:
+        s.send("error", errorResponse{Error: rerr.APIError})
+    default:
+        s.send(order.Status, withLinks(order))
+    }
+}
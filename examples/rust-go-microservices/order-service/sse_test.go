@@ -0,0 +1,173 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+type sseEvent struct {
+    name, data string
+}
+
+// readEvents reads server-sent events from body until it ends.
+func readEvents(t *testing.T, body *bufio.Scanner) []sseEvent {
+    t.Helper()
+
+    var events []sseEvent
+    var cur sseEvent
+    for body.Scan() {
+        line := body.Text()
+        switch {
+        case line == "":
+            if cur.name != "" {
+                events = append(events, cur)
+            }
+            cur = sseEvent{}
+        case strings.HasPrefix(line, "event:"):
+            cur.name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+        case strings.HasPrefix(line, "data:"):
+            cur.data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+        }
+    }
+    return events
+}
+
+func postEventStream(t *testing.T, url, body string) *http.Response {
+    t.Helper()
+
+    req, _ := http.NewRequest(http.MethodPost, url+"/orders", strings.NewReader(body))
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("Accept", contentTypeEventStream)
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { resp.Body.Close() })
+    return resp
+}
+
+func eventNames(events []sseEvent) string {
+    names := make([]string, len(events))
+    for i, e := range events {
+        names[i] = e.name
+    }
+    return strings.Join(names, ",")
+}
+
+func TestCreateOrderStreamsProgress(t *testing.T) {
+    newPaymentServer(t)
+    newInventoryServer(t, map[string]int{"prod_456": 5})
+    resetOrders(t)
+    srv := httptest.NewServer(setupRouter())
+    defer srv.Close()
+
+    resp := postEventStream(t, srv.URL, sampleOrder)
+    if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), contentTypeEventStream) {
+        t.Fatalf("got status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+    }
+    events := readEvents(t, bufio.NewScanner(resp.Body))
+    if got, want := eventNames(events), "validated,reserved,payment_approved,confirmed"; got != want {
+        t.Fatalf("got events %s, want %s", got, want)
+    }
+
+    var order Order
+    if err := json.Unmarshal([]byte(events[len(events)-1].data), &order); err != nil {
+        t.Fatalf("final event: %v", err)
+    }
+    if order.Status != StatusConfirmed {
+        t.Fatalf("got status %q", order.Status)
+    }
+    if stored, err := orders.FindByID(order.OrderID); err != nil || stored.Status != StatusConfirmed {
+        t.Fatalf("stored order: %+v, %v", stored, err)
+    }
+}
+
+func TestCreateOrderStreamReportsDecline(t *testing.T) {
+    fake := newPaymentServer(t)
+    fake.status = "declined"
+    resetOrders(t)
+    srv := httptest.NewServer(setupRouter())
+    defer srv.Close()
+
+    events := readEvents(t, bufio.NewScanner(postEventStream(t, srv.URL, sampleOrder).Body))
+    if got, want := eventNames(events), "validated,payment_declined,payment_failed"; got != want {
+        t.Fatalf("got events %s, want %s", got, want)
+    }
+}
+
+func TestCreateOrderStreamErrors(t *testing.T) {
+    newPaymentServer(t)
+    newInventoryServer(t, map[string]int{})
+    resetOrders(t)
+    srv := httptest.NewServer(setupRouter())
+    defer srv.Close()
+
+    // An invalid order fails before the stream starts.
+    resp := postEventStream(t, srv.URL, `{"customer_id":"","items":[]}`)
+    if resp.StatusCode != http.StatusUnprocessableEntity {
+        t.Fatalf("invalid order: got status %d, want 422", resp.StatusCode)
+    }
+
+    // Running out of stock fails after validation, inside the stream.
+    events := readEvents(t, bufio.NewScanner(postEventStream(t, srv.URL, sampleOrder).Body))
+    if got, want := eventNames(events), "validated,error"; got != want {
+        t.Fatalf("got events %s, want %s", got, want)
+    }
+    var body errorResponse
+    json.Unmarshal([]byte(events[1].data), &body)
+    if body.Error.Code != CodeOutOfStock {
+        t.Fatalf("got error code %q", body.Error.Code)
+    }
+}
+
+func TestCreateOrderStreamClientDisconnect(t *testing.T) {
+    fake := newPaymentServer(t)
+    fake.delay = 500 * time.Millisecond
+    resetOrders(t)
+    srv := httptest.NewServer(setupRouter())
+    defer srv.Close()
+
+    ctx, cancel := context.WithCancel(context.Background())
+    req, _ := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+"/orders", strings.NewReader(sampleOrder))
+    req.Header.Set("Accept", contentTypeEventStream)
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    sc := bufio.NewScanner(resp.Body)
+    if !sc.Scan() || !strings.HasPrefix(sc.Text(), "event:validated") {
+        t.Fatalf("got first line %q", sc.Text())
+    }
+    cancel()
+    resp.Body.Close()
+
+    // The handler gives up on the payment and leaves nothing behind.
+    deadline := time.Now().Add(2 * time.Second)
+    for {
+        list, _ := orders.List()
+        if len(list) == 0 {
+            break
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("abandoned order left behind: %+v", list[0])
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+func TestJSONCreateOrderUnchangedByStreaming(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    w := doRequestWithHeaders(r, http.MethodPost, "/orders", sampleOrder, map[string]string{"Accept": "application/json"})
+    if w.Code != http.StatusCreated || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+        t.Fatalf("got status %d, content type %q", w.Code, w.Header().Get("Content-Type"))
+    }
+}
@@ -0,0 +1,101 @@
+package main
+
+import "time"
+
+const (
+    StatusPending       = "pending"
+    StatusConfirmed     = "confirmed"
+    StatusPaymentFailed = "payment_failed"
+    StatusCancelled     = "cancelled"
+    StatusShipped       = "shipped"
+    // StatusPartiallyShipped means some, but not all, items have shipped.
+    StatusPartiallyShipped = "partially_shipped"
+    StatusRefunded         = "refunded"
+    StatusExpired          = "expired"
+    // StatusPaymentMismatch means the payment service approved a different
+    // amount than was requested. The order waits for someone to look into
+    // it; an admin can then confirm or cancel it.
+    StatusPaymentMismatch = "payment_mismatch"
+    // StatusScheduled means the order is waiting for its ScheduledFor time
+    // to be charged. It hasn't reserved stock or been charged yet.
+    StatusScheduled = "scheduled"
+    // StatusFraudReview means the fraud check held the order for someone
+    // to look at before it is charged. Nothing has been reserved or
+    // charged; the order can only be cancelled.
+    StatusFraudReview = "fraud_review"
+)
+
+// transitions lists, for each status, the statuses an order may move to.
+// Statuses without an entry are terminal.
+var transitions = map[string][]string{
+    StatusScheduled:        {StatusPending, StatusCancelled},
+    StatusFraudReview:      {StatusCancelled},
+    StatusPending:          {StatusConfirmed, StatusPaymentFailed, StatusPaymentMismatch, StatusCancelled, StatusExpired},
+    StatusConfirmed:        {StatusPartiallyShipped, StatusShipped, StatusCancelled, StatusRefunded},
+    StatusPartiallyShipped: {StatusShipped, StatusRefunded},
+    StatusShipped:          {StatusRefunded},
+}
+
+// ActorAdmin marks a status change forced by an administrator.
+const ActorAdmin = "admin"
+
+// StatusChange records one status transition of an order.
+type StatusChange struct {
+    // From is empty for the order's first status.
+    From   string    `json:"from,omitempty"`
+    To     string    `json:"to"`
+    At     time.Time `json:"at"`
+    Reason string    `json:"reason,omitempty"`
+    // Actor is who made the change, if not the service itself.
+    Actor string `json:"actor,omitempty"`
+}
+
+// transitionStatus moves order to status to, recording why in its history.
+// Every status change goes through here so the history is complete; it is
+// up to the caller to check the transition is allowed.
+func transitionStatus(order *Order, to, reason string) {
+    transitionStatusBy(order, to, reason, "")
+}
+
+// transitionStatusBy is transitionStatus for a change made by actor.
+func transitionStatusBy(order *Order, to, reason, actor string) {
+    change := StatusChange{From: order.Status, To: to, At: clock.Now(), Reason: reason, Actor: actor}
+    // Copy rather than append in place: copies of an order handed out by
+    // the store may share the history's backing array.
+    history := order.StatusHistory
+    order.StatusHistory = append(history[:len(history):len(history)], change)
+    order.Status = to
+}
+
+// canTransition reports whether an order may move from one status to
+// another. An admin may also force the moves in adminTransitions.
+func canTransition(from, to string, admin bool) bool {
+    for _, allowed := range transitions[from] {
+        if allowed == to {
+            return true
+        }
+    }
+    if admin {
+        for _, allowed := range adminTransitions[from] {
+            if allowed == to {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// adminTransitions are the extra moves an admin may force to fix an order
+// the normal flow left in the wrong status, such as one whose payment
+// succeeded after it was marked failed or expired. Nothing goes back to
+// pending, which would have the order charged again, and nothing leaves
+// refunded or cancelled, since a cancelled paid order has been refunded and
+// its stock released.
+var adminTransitions = map[string][]string{
+    StatusConfirmed:        {StatusPaymentFailed},
+    StatusPaymentFailed:    {StatusConfirmed, StatusCancelled},
+    StatusExpired:          {StatusConfirmed, StatusCancelled},
+    StatusPaymentMismatch:  {StatusConfirmed, StatusCancelled},
+    StatusPartiallyShipped: {StatusConfirmed},
+    StatusShipped:          {StatusConfirmed, StatusPartiallyShipped},
+}
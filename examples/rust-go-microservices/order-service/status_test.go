@@ -0,0 +1,184 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/shopspring/decimal"
+)
+
+func TestCanTransition(t *testing.T) {
+    tests := []struct {
+        from, to string
+        want     bool
+    }{
+        {StatusPending, StatusConfirmed, true},
+        {StatusPending, StatusPaymentFailed, true},
+        {StatusPending, StatusCancelled, true},
+        {StatusConfirmed, StatusShipped, true},
+        {StatusConfirmed, StatusCancelled, true},
+        {StatusConfirmed, StatusPending, false},
+        {StatusCancelled, StatusCancelled, false},
+        {StatusShipped, StatusCancelled, false},
+        {StatusPaymentFailed, StatusCancelled, false},
+        {"unknown", StatusCancelled, false},
+    }
+    for _, tt := range tests {
+        if got := canTransition(tt.from, tt.to, false); got != tt.want {
+            t.Errorf("canTransition(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+        }
+    }
+}
+
+func saveOrderWithStatus(status string) *Order {
+    order := &Order{
+        OrderID:     uuid.New(),
+        CustomerID:  "cust_123",
+        Currency:    "USD",
+        TotalAmount: decimal.RequireFromString("59.98"),
+        Status:      status,
+        CreatedAt:   time.Now(),
+    }
+    orders.Save(order)
+    return order
+}
+
+func TestCancelOrder(t *testing.T) {
+    tests := []struct {
+        status      string
+        wantCode    int
+        wantRefunds int64
+    }{
+        {StatusPending, http.StatusOK, 0},
+        {StatusConfirmed, http.StatusOK, 1},
+        // Already cancelled: nothing to do, so it succeeds.
+        {StatusCancelled, http.StatusOK, 0},
+        {StatusShipped, http.StatusConflict, 0},
+        {StatusPaymentFailed, http.StatusConflict, 0},
+        {StatusRefunded, http.StatusConflict, 0},
+    }
+    for _, tt := range tests {
+        t.Run(tt.status, func(t *testing.T) {
+            fake := newPaymentServer(t)
+            resetOrders(t)
+            order := saveOrderWithStatus(tt.status)
+            r := setupRouter()
+
+            w := doRequestWithHeaders(r, http.MethodPost, "/orders/"+order.OrderID.String()+"/cancel", "", ifMatch(order))
+            if w.Code != tt.wantCode {
+                t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+            }
+            if got := fake.refunds.Load(); got != tt.wantRefunds {
+                t.Errorf("got %d refunds, want %d", got, tt.wantRefunds)
+            }
+
+            stored, _ := orders.FindByID(order.OrderID)
+            if tt.wantCode == http.StatusOK {
+                var resp Order
+                json.Unmarshal(w.Body.Bytes(), &resp)
+                if resp.Status != StatusCancelled || stored.Status != StatusCancelled {
+                    t.Fatalf("order not cancelled: response %q, stored %q", resp.Status, stored.Status)
+                }
+            } else if stored.Status != tt.status {
+                t.Fatalf("rejected cancel changed status to %q", stored.Status)
+            }
+        })
+    }
+}
+
+func TestCancelUnknownOrder(t *testing.T) {
+    resetOrders(t)
+    r := setupRouter()
+
+    if w := doRequest(r, http.MethodPost, "/orders/"+uuid.NewString()+"/cancel", ""); w.Code != http.StatusNotFound {
+        t.Fatalf("got status %d, want 404", w.Code)
+    }
+}
+
+func TestStatusHistoryAccumulates(t *testing.T) {
+    resetOrders(t)
+    newPaymentServer(t)
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", sampleOrder)
+    if w.Code != http.StatusCreated {
+        t.Fatalf("create: got status %d: %s", w.Code, w.Body)
+    }
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    path := "/orders/" + order.OrderID.String()
+
+    if w := doRequest(r, http.MethodPost, path+"/refund", `{"amount":"10"}`); w.Code != http.StatusOK {
+        t.Fatalf("partial refund: got status %d: %s", w.Code, w.Body)
+    }
+    if w := doRequest(r, http.MethodPost, path+"/refund", ""); w.Code != http.StatusOK {
+        t.Fatalf("full refund: got status %d: %s", w.Code, w.Body)
+    }
+
+    var got Order
+    json.Unmarshal(doRequest(r, http.MethodGet, path, "").Body.Bytes(), &got)
+    want := []StatusChange{
+        {From: "", To: StatusPending, Reason: "order placed"},
+        {From: StatusPending, To: StatusConfirmed, Reason: "payment approved"},
+        {From: StatusConfirmed, To: StatusRefunded, Reason: "fully refunded"},
+    }
+    if len(got.StatusHistory) != len(want) {
+        t.Fatalf("got history %+v, want %d entries", got.StatusHistory, len(want))
+    }
+    for i, change := range got.StatusHistory {
+        if change.From != want[i].From || change.To != want[i].To || change.Reason != want[i].Reason {
+            t.Errorf("entry %d: got %+v, want %+v", i, change, want[i])
+        }
+        if i > 0 && change.At.Before(got.StatusHistory[i-1].At) {
+            t.Errorf("entry %d is older than the one before it", i)
+        }
+    }
+}
+
+func TestTransitionStatusDoesNotShareHistory(t *testing.T) {
+    order := &Order{}
+    transitionStatus(order, StatusPending, "")
+    transitionStatus(order, StatusConfirmed, "")
+    a, b := *order, *order
+
+    transitionStatus(&a, StatusShipped, "")
+    transitionStatus(&b, StatusCancelled, "")
+    if a.StatusHistory[2].To != StatusShipped || b.StatusHistory[2].To != StatusCancelled {
+        t.Fatalf("copies overwrote each other's history: %+v, %+v", a.StatusHistory, b.StatusHistory)
+    }
+}
+
+func TestCancelIsIdempotent(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    order := saveOrderWithStatus(StatusConfirmed)
+    r := setupRouter()
+    path := "/orders/" + order.OrderID.String() + "/cancel"
+
+    first := doRequestWithHeaders(r, http.MethodPost, path, "", ifMatch(order))
+    if first.Code != http.StatusOK {
+        t.Fatalf("first cancel: got status %d: %s", first.Code, first.Body)
+    }
+    var cancelled Order
+    json.Unmarshal(first.Body.Bytes(), &cancelled)
+
+    // The retry carries the If-Match of the original attempt.
+    second := doRequestWithHeaders(r, http.MethodPost, path, "", ifMatch(order))
+    if second.Code != http.StatusOK {
+        t.Fatalf("repeat cancel: got status %d: %s", second.Code, second.Body)
+    }
+    var repeated Order
+    json.Unmarshal(second.Body.Bytes(), &repeated)
+    if repeated.Status != StatusCancelled || repeated.Version != cancelled.Version {
+        t.Fatalf("repeat cancel changed the order: %+v", repeated)
+    }
+    if n := fake.refunds.Load(); n != 1 {
+        t.Fatalf("got %d refunds, want 1", n)
+    }
+    if n := len(repeated.StatusHistory); n != len(cancelled.StatusHistory) {
+        t.Fatalf("repeat cancel added history: %d entries, want %d", n, len(cancelled.StatusHistory))
+    }
+}
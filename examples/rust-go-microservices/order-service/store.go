@@ -0,0 +1,213 @@
+package main
+
+import (
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// OrderStore is an in-memory, concurrency-safe OrderRepository used for
+// tests and local development. It stores and hands out copies so callers
+// never share an *Order across goroutines.
+type OrderStore struct {
+    mu     sync.RWMutex
+    orders map[uuid.UUID]*Order
+    // byCustomer indexes order IDs by customer so per-customer lookups
+    // don't scan every order.
+    byCustomer map[string][]uuid.UUID
+    // byTag indexes order IDs by each of their tags.
+    byTag   map[string]map[uuid.UUID]struct{}
+    gen     uint64
+    summary *SummaryCounters
+}
+
+func NewOrderStore() *OrderStore {
+    return &OrderStore{
+        orders:     make(map[uuid.UUID]*Order),
+        byCustomer: make(map[string][]uuid.UUID),
+        byTag:      make(map[string]map[uuid.UUID]struct{}),
+        summary:    NewSummaryCounters(),
+    }
+}
+
+func (s *OrderStore) FindByID(id uuid.UUID) (*Order, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    order, exists := s.orders[id]
+    if !exists {
+        return nil, ErrOrderNotFound
+    }
+    copied := *order
+    return &copied, nil
+}
+
+func (s *OrderStore) Save(order *Order) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    prev, exists := s.orders[order.OrderID]
+    if (exists && prev.Version != order.Version) || (!exists && order.Version != 0) {
+        return ErrVersionConflict
+    }
+    if !exists || prev.CustomerID != order.CustomerID {
+        if exists {
+            s.unindexLocked(prev)
+        }
+        s.byCustomer[order.CustomerID] = append(s.byCustomer[order.CustomerID], order.OrderID)
+    }
+    if exists {
+        s.untagLocked(prev)
+    }
+    s.tagLocked(order)
+
+    order.Version++
+    order.UpdatedAt = clock.Now()
+    copied := *order
+    s.orders[order.OrderID] = &copied
+    s.summary.apply(prev, &copied)
+    s.gen++
+    return nil
+}
+
+func (s *OrderStore) Delete(id uuid.UUID) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if prev, exists := s.orders[id]; exists {
+        s.unindexLocked(prev)
+        s.untagLocked(prev)
+        delete(s.orders, id)
+        s.summary.apply(prev, nil)
+        s.gen++
+    }
+    return nil
+}
+
+func (s *OrderStore) Summary(from, to string) (OrderSummary, error) {
+    return s.summary.summary(from, to), nil
+}
+
+func (s *OrderStore) Generation() uint64 {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    return s.gen
+}
+
+// unindexLocked removes order from the customer index.
+func (s *OrderStore) unindexLocked(order *Order) {
+    ids := s.byCustomer[order.CustomerID]
+    for i, id := range ids {
+        if id == order.OrderID {
+            ids = append(ids[:i], ids[i+1:]...)
+            break
+        }
+    }
+    if len(ids) == 0 {
+        delete(s.byCustomer, order.CustomerID)
+    } else {
+        s.byCustomer[order.CustomerID] = ids
+    }
+}
+
+func (s *OrderStore) tagLocked(order *Order) {
+    for _, tag := range order.Tags {
+        ids := s.byTag[tag]
+        if ids == nil {
+            ids = make(map[uuid.UUID]struct{})
+            s.byTag[tag] = ids
+        }
+        ids[order.OrderID] = struct{}{}
+    }
+}
+
+func (s *OrderStore) untagLocked(order *Order) {
+    for _, tag := range order.Tags {
+        delete(s.byTag[tag], order.OrderID)
+        if len(s.byTag[tag]) == 0 {
+            delete(s.byTag, tag)
+        }
+    }
+}
+
+// List returns every order, newest first. Orders created at the same instant
+// are ordered by ID so the result is deterministic.
+func (s *OrderStore) List() ([]*Order, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    list := make([]*Order, 0, len(s.orders))
+    for _, order := range s.orders {
+        copied := *order
+        list = append(list, &copied)
+    }
+    sortNewestFirst(list)
+    return list, nil
+}
+
+func (s *OrderStore) ListByCustomer(customerID string) ([]*Order, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    ids := s.byCustomer[customerID]
+    list := make([]*Order, 0, len(ids))
+    for _, id := range ids {
+        copied := *s.orders[id]
+        list = append(list, &copied)
+    }
+    sortNewestFirst(list)
+    return list, nil
+}
+
+// ListByTags starts from the tag on the fewest orders and checks the
+// others against each of those.
+func (s *OrderStore) ListByTags(tags []string) ([]*Order, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    if len(tags) == 0 {
+        return []*Order{}, nil
+    }
+    smallest := s.byTag[tags[0]]
+    for _, tag := range tags[1:] {
+        if len(s.byTag[tag]) < len(smallest) {
+            smallest = s.byTag[tag]
+        }
+    }
+    list := make([]*Order, 0, len(smallest))
+    for id := range smallest {
+        if order := s.orders[id]; hasTags(order, tags) {
+            copied := *order
+            list = append(list, &copied)
+        }
+    }
+    sortNewestFirst(list)
+    return list, nil
+}
+
+func (s *OrderStore) ListUpdatedSince(since time.Time) ([]*Order, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    list := []*Order{}
+    for _, order := range s.orders {
+        if !order.UpdatedAt.Before(since) {
+            copied := *order
+            list = append(list, &copied)
+        }
+    }
+    sortByUpdate(list)
+    return list, nil
+}
+
+func sortNewestFirst(list []*Order) {
+    sort.Slice(list, func(i, j int) bool {
+        if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+            return list[i].CreatedAt.After(list[j].CreatedAt)
+        }
+        return list[i].OrderID.String() < list[j].OrderID.String()
+    })
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+    "testing"
+
+    "github.com/google/uuid"
+)
+
+func TestOrderStoreSaveGetDelete(t *testing.T) {
+    s := NewOrderStore()
+    order := &Order{OrderID: uuid.New(), Status: "pending"}
+
+    s.Save(order)
+    got, err := s.FindByID(order.OrderID)
+    if err != nil || got.Status != "pending" {
+        t.Fatalf("FindByID after Save = %+v, %v", got, err)
+    }
+
+    got.Status = "mutated"
+    if again, _ := s.FindByID(order.OrderID); again.Status != "pending" {
+        t.Fatalf("store shares memory with callers: status %q", again.Status)
+    }
+
+    s.Delete(order.OrderID)
+    if _, err := s.FindByID(order.OrderID); err != ErrOrderNotFound {
+        t.Fatalf("FindByID after Delete: got %v, want ErrOrderNotFound", err)
+    }
+}
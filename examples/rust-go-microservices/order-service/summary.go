@@ -0,0 +1,133 @@
+package main
+
+import (
+    "net/http"
+    "sync"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/shopspring/decimal"
+)
+
+// summaryDateLayout is how GET /orders/summary takes its date range.
+const summaryDateLayout = "2006-01-02"
+
+// OrderSummary is the body of GET /orders/summary.
+type OrderSummary struct {
+    // From and To echo the date range asked for, if any.
+    From string `json:"from,omitempty"`
+    To   string `json:"to,omitempty"`
+    // Total counts every order; Counts breaks it down by status.
+    Total  int            `json:"total"`
+    Counts map[string]int `json:"counts"`
+    // ConfirmedTotals sums TotalAmount per currency over the orders in
+    // status confirmed.
+    ConfirmedTotals map[string]decimal.Decimal `json:"confirmed_totals"`
+}
+
+// summaryDay holds the counters for the orders created on one UTC day.
+type summaryDay struct {
+    counts    map[string]int
+    confirmed map[string]decimal.Decimal
+}
+
+// SummaryCounters keeps aggregate numbers up to date as orders are saved,
+// so a summary costs a pass over days rather than over every order. A
+// repository calls apply for every change it stores. Soft-deleted orders
+// aren't counted.
+type SummaryCounters struct {
+    mu   sync.Mutex
+    days map[string]*summaryDay
+}
+
+func NewSummaryCounters() *SummaryCounters {
+    return &SummaryCounters{days: make(map[string]*summaryDay)}
+}
+
+// apply records that an order changed from prev to next; prev is nil for a
+// new order and next is nil for a deleted one.
+func (s *SummaryCounters) apply(prev, next *Order) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.addLocked(prev, -1)
+    s.addLocked(next, 1)
+}
+
+func (s *SummaryCounters) addLocked(order *Order, sign int) {
+    if order == nil || order.DeletedAt != nil {
+        return
+    }
+    key := order.CreatedAt.UTC().Format(summaryDateLayout)
+    day, ok := s.days[key]
+    if !ok {
+        day = &summaryDay{counts: make(map[string]int), confirmed: make(map[string]decimal.Decimal)}
+        s.days[key] = day
+    }
+    if day.counts[order.Status] += sign; day.counts[order.Status] == 0 {
+        delete(day.counts, order.Status)
+    }
+    if order.Status == StatusConfirmed {
+        total := day.confirmed[order.Currency].Add(order.TotalAmount.Mul(decimal.NewFromInt(int64(sign))))
+        day.confirmed[order.Currency] = total
+        if total.IsZero() {
+            delete(day.confirmed, order.Currency)
+        }
+    }
+    if len(day.counts) == 0 {
+        delete(s.days, key)
+    }
+}
+
+// summary adds up the days from from to to, inclusive; an empty bound is
+// open.
+func (s *SummaryCounters) summary(from, to string) OrderSummary {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    out := OrderSummary{From: from, To: to, Counts: map[string]int{}, ConfirmedTotals: map[string]decimal.Decimal{}}
+    // Dates in the layout sort as strings.
+    for key, day := range s.days {
+        if (from != "" && key < from) || (to != "" && key > to) {
+            continue
+        }
+        for status, n := range day.counts {
+            out.Counts[status] += n
+            out.Total += n
+        }
+        for currency, total := range day.confirmed {
+            out.ConfirmedTotals[currency] = out.ConfirmedTotals[currency].Add(total)
+        }
+    }
+    return out
+}
+
+// orderSummary returns the counts of orders per status and the value of
+// confirmed orders per currency, optionally only for orders created between
+// the from and to dates (YYYY-MM-DD, UTC, inclusive).
+func orderSummary(c *gin.Context) {
+    from, to := c.Query("from"), c.Query("to")
+    verr := &ValidationError{}
+    for _, bound := range []struct{ field, value string }{{"from", from}, {"to", to}} {
+        if bound.value == "" {
+            continue
+        }
+        if _, err := time.Parse(summaryDateLayout, bound.value); err != nil {
+            verr.add(bound.field, "must be a date like 2024-01-31")
+        }
+    }
+    if verr.err() == nil && from != "" && to != "" && to < from {
+        verr.add("to", "must not be before from")
+    }
+    if verr.err() != nil {
+        respondValidationError(c, verr)
+        return
+    }
+
+    summary, err := orders.Summary(from, to)
+    if err != nil {
+        respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to summarize orders")
+        return
+    }
+    c.JSON(http.StatusOK, summary)
+}
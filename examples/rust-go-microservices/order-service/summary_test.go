@@ -0,0 +1,147 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/shopspring/decimal"
+)
+
+func getSummary(t *testing.T, r http.Handler, query string) OrderSummary {
+    t.Helper()
+
+    w := doRequest(r, http.MethodGet, "/orders/summary"+query, "")
+    if w.Code != http.StatusOK {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    var summary OrderSummary
+    if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+        t.Fatal(err)
+    }
+    return summary
+}
+
+func checkSummary(t *testing.T, got OrderSummary, counts map[string]int, confirmedUSD string) {
+    t.Helper()
+
+    total := 0
+    for status, n := range counts {
+        total += n
+        if got.Counts[status] != n {
+            t.Errorf("%s: got %d, want %d (counts %v)", status, got.Counts[status], n, got.Counts)
+        }
+    }
+    if len(got.Counts) != len(counts) || got.Total != total {
+        t.Errorf("counts %v total %d, want %v", got.Counts, got.Total, counts)
+    }
+    if want := decimal.RequireFromString(confirmedUSD); !got.ConfirmedTotals["USD"].Equal(want) {
+        t.Errorf("confirmed USD = %s, want %s", got.ConfirmedTotals["USD"], want)
+    }
+}
+
+func TestOrderSummaryFollowsOrders(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    checkSummary(t, getSummary(t, r, ""), map[string]int{}, "0")
+
+    var created []Order
+    for i := 0; i < 2; i++ {
+        w := doRequest(r, http.MethodPost, "/orders", sampleOrder)
+        var order Order
+        json.Unmarshal(w.Body.Bytes(), &order)
+        created = append(created, order)
+    }
+    pending := saveOrderWithStatus(StatusPending)
+    checkSummary(t, getSummary(t, r, ""), map[string]int{StatusConfirmed: 2, StatusPending: 1}, "119.96")
+
+    // Confirming the pending order moves it between counts and adds it to
+    // the confirmed total.
+    pending.Status = StatusConfirmed
+    orders.Save(pending)
+    checkSummary(t, getSummary(t, r, ""), map[string]int{StatusConfirmed: 3}, "179.94")
+
+    doRequestWithHeaders(r, http.MethodPost, "/orders/"+created[0].OrderID.String()+"/cancel", "", ifMatch(&created[0]))
+    checkSummary(t, getSummary(t, r, ""), map[string]int{StatusConfirmed: 2, StatusCancelled: 1}, "119.96")
+
+    orders.Delete(created[1].OrderID)
+    checkSummary(t, getSummary(t, r, ""), map[string]int{StatusConfirmed: 1, StatusCancelled: 1}, "59.98")
+}
+
+func TestOrderSummaryDateRange(t *testing.T) {
+    resetOrders(t)
+    r := setupRouter()
+
+    for _, day := range []int{1, 2, 2, 5} {
+        orders.Save(&Order{
+            OrderID:     uuid.New(),
+            CustomerID:  "cust_123",
+            Currency:    "USD",
+            TotalAmount: decimal.RequireFromString("10.00"),
+            Status:      StatusConfirmed,
+            CreatedAt:   time.Date(2024, 3, day, 23, 30, 0, 0, time.UTC),
+        })
+    }
+    deleted := time.Now()
+    orders.Save(&Order{OrderID: uuid.New(), Status: StatusConfirmed, DeletedAt: &deleted,
+        CreatedAt: time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)})
+
+    tests := []struct {
+        query string
+        want  int
+    }{
+        {"", 4},
+        {"?from=2024-03-02", 3},
+        {"?to=2024-03-02", 3},
+        {"?from=2024-03-02&to=2024-03-02", 2},
+        {"?from=2024-03-03&to=2024-03-04", 0},
+    }
+    for _, tt := range tests {
+        got := getSummary(t, r, tt.query)
+        if got.Total != tt.want || !got.ConfirmedTotals["USD"].Equal(decimal.NewFromInt(int64(10*tt.want))) {
+            t.Errorf("%q: got %+v, want %d orders", tt.query, got, tt.want)
+        }
+    }
+}
+
+func TestOrderSummaryRejectsBadDates(t *testing.T) {
+    r := setupRouter()
+    for _, query := range []string{"?from=03/01/2024", "?to=2024-02-30", "?from=2024-03-02&to=2024-03-01"} {
+        if w := doRequest(r, http.MethodGet, "/orders/summary"+query, ""); w.Code != http.StatusUnprocessableEntity {
+            t.Errorf("%q: got status %d, want 422", query, w.Code)
+        }
+    }
+}
+
+func TestOrderSummaryNeedsUnscopedKey(t *testing.T) {
+    useAPIKeys(t, "scoped:cust_123")
+    w := doRequestWithHeaders(setupRouter(), http.MethodGet, "/orders/summary", "", bearer("scoped"))
+    if w.Code != http.StatusForbidden {
+        t.Fatalf("got status %d, want 403", w.Code)
+    }
+}
+
+func TestSQLiteSummarySurvivesRestart(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "orders.db")
+    repo := openTestSQLite(t, path)
+    order := newOutboxOrder()
+    order.TotalAmount = decimal.RequireFromString("12.50")
+    repo.Save(order)
+    order.Status = StatusConfirmed
+    if err := repo.Save(order); err != nil {
+        t.Fatal(err)
+    }
+    repo.Save(newOutboxOrder())
+    repo.Close()
+
+    summary, _ := openTestSQLite(t, path).Summary("", "")
+    if summary.Counts[StatusConfirmed] != 1 || summary.Counts[StatusPending] != 1 ||
+        !summary.ConfirmedTotals["USD"].Equal(decimal.RequireFromString("12.50")) {
+        t.Fatalf("got %+v", summary)
+    }
+}
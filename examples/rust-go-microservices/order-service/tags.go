@@ -0,0 +1,201 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "regexp"
+    "sort"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+// Limits on the labels operators put on orders, such as fraud_review.
+const (
+    maxTags   = 20
+    maxTagLen = 32
+)
+
+// Actions of a TagChange.
+const (
+    TagAdded   = "added"
+    TagRemoved = "removed"
+)
+
+// TagChange records a tag being added to or removed from an order.
+type TagChange struct {
+    Tag    string    `json:"tag"`
+    Action string    `json:"action"`
+    At     time.Time `json:"at"`
+}
+
+// recordTagChange notes on order that tag was added or removed.
+func recordTagChange(order *Order, tag, action string) {
+    order.TagHistory = append(order.TagHistory[:len(order.TagHistory):len(order.TagHistory)], TagChange{
+        Tag:    tag,
+        Action: action,
+        At:     clock.Now(),
+    })
+}
+
+// tagPattern is what a tag may look like: lowercase letters, digits,
+// underscores and hyphens, starting with a letter or digit.
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// tagProblem says what is wrong with tag, or "" if it is a valid tag.
+func tagProblem(tag string) string {
+    switch {
+    case len(tag) > maxTagLen:
+        return fmt.Sprintf("must be at most %d characters", maxTagLen)
+    case !tagPattern.MatchString(tag):
+        return "must be lowercase letters, digits, '_' or '-', starting with a letter or digit"
+    }
+    return ""
+}
+
+func validateTags(verr *ValidationError, tags []string) {
+    if len(tags) > maxTags {
+        verr.add("tags", "must have at most %d tags, got %d", maxTags, len(tags))
+    }
+    for i, tag := range tags {
+        if problem := tagProblem(tag); problem != "" {
+            verr.add(fmt.Sprintf("tags[%d]", i), "%s", problem)
+        }
+    }
+}
+
+// normalizeTags sorts tags and drops duplicates, in a new slice.
+func normalizeTags(tags []string) []string {
+    if len(tags) == 0 {
+        return nil
+    }
+    sorted := append([]string(nil), tags...)
+    sort.Strings(sorted)
+    out := sorted[:1]
+    for _, tag := range sorted[1:] {
+        if tag != out[len(out)-1] {
+            out = append(out, tag)
+        }
+    }
+    return out
+}
+
+func hasTag(order *Order, tag string) bool {
+    i := sort.SearchStrings(order.Tags, tag)
+    return i < len(order.Tags) && order.Tags[i] == tag
+}
+
+// hasTags reports whether order carries every one of tags.
+func hasTags(order *Order, tags []string) bool {
+    for _, tag := range tags {
+        if !hasTag(order, tag) {
+            return false
+        }
+    }
+    return true
+}
+
+// filterByTags returns the orders in list carrying all of tags.
+func filterByTags(list []*Order, tags []string) []*Order {
+    if len(tags) == 0 {
+        return list
+    }
+    filtered := make([]*Order, 0, len(list))
+    for _, order := range list {
+        if hasTags(order, tags) {
+            filtered = append(filtered, order)
+        }
+    }
+    return filtered
+}
+
+// queryTags reads the ?tag= filter of a list request. Each tag given must
+// be on an order for it to be listed.
+func queryTags(c *gin.Context) ([]string, error) {
+    tags := normalizeTags(c.QueryArray("tag"))
+    for _, tag := range tags {
+        if problem := tagProblem(tag); problem != "" {
+            return nil, fmt.Errorf("tag %q %s", tag, problem)
+        }
+    }
+    return tags, nil
+}
+
+// tagParam validates the :tag of a tag endpoint, responding with a 422 if
+// it isn't a valid tag.
+func tagParam(c *gin.Context) (string, bool) {
+    tag := c.Param("tag")
+    if problem := tagProblem(tag); problem != "" {
+        verr := &ValidationError{}
+        verr.add("tag", "%s", problem)
+        respondValidationError(c, verr)
+        return "", false
+    }
+    return tag, true
+}
+
+// addOrderTag handles POST /orders/:id/tags/:tag. Adding a tag the order
+// already has changes nothing.
+func addOrderTag(c *gin.Context) {
+    tag, ok := tagParam(c)
+    if !ok {
+        return
+    }
+    unlock := lockOrderParam(c)
+    defer unlock()
+    order := loadOrder(c)
+    if order == nil {
+        return
+    }
+    if hasTag(order, tag) {
+        c.JSON(http.StatusOK, withLinks(order))
+        return
+    }
+    if len(order.Tags) >= maxTags {
+        verr := &ValidationError{}
+        verr.add("tags", "must have at most %d tags", maxTags)
+        respondValidationError(c, verr)
+        return
+    }
+
+    order.Tags = normalizeTags(append(append([]string(nil), order.Tags...), tag))
+    recordTagChange(order, tag, TagAdded)
+    if err := orders.Save(order); err != nil {
+        respondSaveError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, withLinks(order))
+}
+
+// removeOrderTag handles DELETE /orders/:id/tags/:tag. Removing a tag the
+// order doesn't have changes nothing.
+func removeOrderTag(c *gin.Context) {
+    tag, ok := tagParam(c)
+    if !ok {
+        return
+    }
+    unlock := lockOrderParam(c)
+    defer unlock()
+    order := loadOrder(c)
+    if order == nil {
+        return
+    }
+    if !hasTag(order, tag) {
+        c.JSON(http.StatusOK, withLinks(order))
+        return
+    }
+
+    kept := make([]string, 0, len(order.Tags)-1)
+    for _, t := range order.Tags {
+        if t != tag {
+            kept = append(kept, t)
+        }
+    }
+    order.Tags = normalizeTags(kept)
+    recordTagChange(order, tag, TagRemoved)
+    if err := orders.Save(order); err != nil {
+        respondSaveError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, withLinks(order))
+}
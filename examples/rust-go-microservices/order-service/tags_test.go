@@ -0,0 +1,159 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+    "github.com/shopspring/decimal"
+)
+
+func tagOrder(t *testing.T, r http.Handler, method string, order *Order, tag string) *Order {
+    t.Helper()
+
+    w := doRequest(r, method, "/orders/"+order.OrderID.String()+"/tags/"+tag, "")
+    if w.Code != http.StatusOK {
+        t.Fatalf("%s tag %s: got status %d: %s", method, tag, w.Code, w.Body)
+    }
+    var got Order
+    json.Unmarshal(w.Body.Bytes(), &got)
+    return &got
+}
+
+func listTagged(t *testing.T, r http.Handler, query string) []string {
+    t.Helper()
+
+    w := doRequest(r, http.MethodGet, "/orders?"+query, "")
+    if w.Code != http.StatusOK {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    var list OrderList
+    json.Unmarshal(w.Body.Bytes(), &list)
+    ids := make([]string, 0, len(list.Orders))
+    for _, order := range list.Orders {
+        ids = append(ids, order.OrderID.String())
+    }
+    return ids
+}
+
+func TestTagAndUntagOrder(t *testing.T) {
+    resetOrders(t)
+    r := setupRouter()
+    order := saveOrderWithStatus(StatusConfirmed)
+
+    tagOrder(t, r, http.MethodPost, order, "vip")
+    tagOrder(t, r, http.MethodPost, order, "fraud_review")
+    got := tagOrder(t, r, http.MethodPost, order, "vip")
+    if fmt.Sprint(got.Tags) != "[fraud_review vip]" {
+        t.Fatalf("got tags %v, want [fraud_review vip]", got.Tags)
+    }
+
+    tagOrder(t, r, http.MethodDelete, order, "fraud_review")
+    got = tagOrder(t, r, http.MethodDelete, order, "never-added")
+    if fmt.Sprint(got.Tags) != "[vip]" {
+        t.Fatalf("got tags %v, want [vip]", got.Tags)
+    }
+    stored, _ := orders.FindByID(order.OrderID)
+    if fmt.Sprint(stored.Tags) != "[vip]" {
+        t.Fatalf("stored tags %v, want [vip]", stored.Tags)
+    }
+}
+
+func TestTagChangesWaitForOrderLock(t *testing.T) {
+    resetOrders(t)
+    r := setupRouter()
+    order := saveOrderWithStatus(StatusPending)
+    path := "/orders/" + order.OrderID.String() + "/tags/vip"
+
+    assertWaitsForOrderLock(t, order, func() { doRequest(r, http.MethodPost, path, "") })
+    assertWaitsForOrderLock(t, order, func() { doRequest(r, http.MethodDelete, path, "") })
+}
+
+func TestTagFormatValidated(t *testing.T) {
+    resetOrders(t)
+    r := setupRouter()
+    order := saveOrderWithStatus(StatusConfirmed)
+
+    for _, tag := range []string{"VIP", "-vip", "vip%20review", "a.b", "abcdefghijklmnopqrstuvwxyz0123456789"} {
+        if w := doRequest(r, http.MethodPost, "/orders/"+order.OrderID.String()+"/tags/"+tag, ""); w.Code != http.StatusUnprocessableEntity {
+            t.Errorf("%q: got status %d, want 422", tag, w.Code)
+        }
+    }
+    if w := doRequest(r, http.MethodGet, "/orders?tag=VIP", ""); w.Code != http.StatusBadRequest {
+        t.Errorf("filter by invalid tag: got status %d, want 400", w.Code)
+    }
+}
+
+func TestListOrdersByTags(t *testing.T) {
+    resetOrders(t)
+    r := setupRouter()
+    both := saveOrderWithStatus(StatusConfirmed)
+    vip := saveOrderWithStatus(StatusConfirmed)
+    saveOrderWithStatus(StatusConfirmed)
+    tagOrder(t, r, http.MethodPost, both, "vip")
+    tagOrder(t, r, http.MethodPost, both, "fraud_review")
+    tagOrder(t, r, http.MethodPost, vip, "vip")
+
+    if ids := listTagged(t, r, "tag=vip"); len(ids) != 2 {
+        t.Fatalf("tag=vip: got %v, want both vip orders", ids)
+    }
+    if ids := listTagged(t, r, "tag=vip&tag=fraud_review"); fmt.Sprint(ids) != fmt.Sprint([]string{both.OrderID.String()}) {
+        t.Fatalf("tag=vip&tag=fraud_review: got %v, want only %s", ids, both.OrderID)
+    }
+    if ids := listTagged(t, r, "tag=fraud_review&tag=unused"); len(ids) != 0 {
+        t.Fatalf("tag with no orders: got %v, want none", ids)
+    }
+    if ids := listTagged(t, r, ""); len(ids) != 3 {
+        t.Fatalf("no filter: got %d orders, want 3", len(ids))
+    }
+
+    tagOrder(t, r, http.MethodDelete, both, "fraud_review")
+    if ids := listTagged(t, r, "tag=vip&tag=fraud_review"); len(ids) != 0 {
+        t.Fatalf("after untagging: got %v, want none", ids)
+    }
+}
+
+func TestSQLiteRepositoryListByTags(t *testing.T) {
+    repo := openTestSQLite(t, filepath.Join(t.TempDir(), "orders.db"))
+    tagged := func(tags ...string) *Order {
+        order := &Order{
+            OrderID:     uuid.New(),
+            CustomerID:  "cust_123",
+            Currency:    "USD",
+            TotalAmount: decimal.RequireFromString("59.98"),
+            Status:      StatusConfirmed,
+            CreatedAt:   time.Now(),
+            Tags:        tags,
+        }
+        if err := repo.Save(order); err != nil {
+            t.Fatal(err)
+        }
+        return order
+    }
+    both := tagged("fraud_review", "vip")
+    vip := tagged("vip")
+    tagged()
+
+    if list, err := repo.ListByTags([]string{"vip"}); err != nil || len(list) != 2 {
+        t.Fatalf("vip: got %d orders, %v; want 2", len(list), err)
+    }
+    list, err := repo.ListByTags([]string{"fraud_review", "vip"})
+    if err != nil || len(list) != 1 || list[0].OrderID != both.OrderID || fmt.Sprint(list[0].Tags) != "[fraud_review vip]" {
+        t.Fatalf("fraud_review+vip: got %v, %v; want only %s", list, err, both.OrderID)
+    }
+
+    vip.Tags = nil
+    if err := repo.Save(vip); err != nil {
+        t.Fatal(err)
+    }
+    if err := repo.Delete(both.OrderID); err != nil {
+        t.Fatal(err)
+    }
+    if list, err := repo.ListByTags([]string{"vip"}); err != nil || len(list) != 0 {
+        t.Fatalf("after untag and delete: got %d orders, %v; want none", len(list), err)
+    }
+}
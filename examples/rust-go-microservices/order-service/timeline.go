@@ -0,0 +1,94 @@
+package main
+
+import (
+    "net/http"
+    "sort"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+)
+
+// Types of TimelineEntry.
+const (
+    TimelineStatusChanged = "status_changed"
+    TimelineShipped       = "shipped"
+    TimelineRefunded      = "refunded"
+    TimelineTagAdded      = "tag_added"
+    TimelineTagRemoved    = "tag_removed"
+    TimelineAmended       = "amended"
+)
+
+// TimelineEntry is one event in an order's life. Type says which of the
+// other fields is set.
+type TimelineEntry struct {
+    Type         string        `json:"type"`
+    At           time.Time     `json:"at"`
+    StatusChange *StatusChange `json:"status_change,omitempty"`
+    Shipment     *Shipment     `json:"shipment,omitempty"`
+    Refund       *Refund       `json:"refund,omitempty"`
+    Tag          string        `json:"tag,omitempty"`
+    Amendment    *Amendment    `json:"amendment,omitempty"`
+}
+
+// OrderTimeline is the body of GET /orders/:id/events.
+type OrderTimeline struct {
+    OrderID uuid.UUID       `json:"order_id"`
+    Events  []TimelineEntry `json:"events"`
+}
+
+// orderTimeline merges the histories the order keeps, oldest first.
+// Events at the same instant keep the order of the lists they come from:
+// status changes, then shipments, refunds, tag changes and amendments.
+func orderTimeline(order *Order) []TimelineEntry {
+    events := make([]TimelineEntry, 0, len(order.StatusHistory)+len(order.Shipments)+len(order.Refunds)+len(order.TagHistory)+len(order.Amendments))
+    for i := range order.StatusHistory {
+        change := &order.StatusHistory[i]
+        events = append(events, TimelineEntry{Type: TimelineStatusChanged, At: change.At, StatusChange: change})
+    }
+    for i := range order.Shipments {
+        shipment := &order.Shipments[i]
+        events = append(events, TimelineEntry{Type: TimelineShipped, At: shipment.ShippedAt, Shipment: shipment})
+    }
+    for i := range order.Refunds {
+        refund := &order.Refunds[i]
+        events = append(events, TimelineEntry{Type: TimelineRefunded, At: refund.RefundedAt, Refund: refund})
+    }
+    for _, change := range order.TagHistory {
+        kind := TimelineTagAdded
+        if change.Action == TagRemoved {
+            kind = TimelineTagRemoved
+        }
+        events = append(events, TimelineEntry{Type: kind, At: change.At, Tag: change.Tag})
+    }
+    for i := range order.Amendments {
+        amendment := &order.Amendments[i]
+        events = append(events, TimelineEntry{Type: TimelineAmended, At: amendment.At, Amendment: amendment})
+    }
+    sort.SliceStable(events, func(i, j int) bool { return events[i].At.Before(events[j].At) })
+    return events
+}
+
+// getOrderTimeline handles GET /orders/:id/events. ?since= (RFC 3339)
+// leaves out events at or before that time.
+func getOrderTimeline(c *gin.Context) {
+    var since time.Time
+    if raw := c.Query("since"); raw != "" {
+        var err error
+        if since, err = time.Parse(time.RFC3339Nano, raw); err != nil {
+            respondError(c, http.StatusBadRequest, CodeInvalidRequest, "since must be an RFC 3339 timestamp")
+            return
+        }
+    }
+    order := loadOrder(c)
+    if order == nil {
+        return
+    }
+
+    events := orderTimeline(order)
+    if !since.IsZero() {
+        i := sort.Search(len(events), func(i int) bool { return events[i].At.After(since) })
+        events = events[i:]
+    }
+    c.JSON(http.StatusOK, OrderTimeline{OrderID: order.OrderID, Events: events})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "testing"
+    "time"
+)
+
+func getTimeline(t *testing.T, r http.Handler, order *Order, query string) []TimelineEntry {
+    t.Helper()
+
+    w := doRequest(r, http.MethodGet, "/orders/"+order.OrderID.String()+"/events"+query, "")
+    if w.Code != http.StatusOK {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    var timeline OrderTimeline
+    json.Unmarshal(w.Body.Bytes(), &timeline)
+    return timeline.Events
+}
+
+func timelineTypes(events []TimelineEntry) string {
+    types := make([]string, 0, len(events))
+    for _, event := range events {
+        types = append(types, event.Type)
+    }
+    return fmt.Sprint(types)
+}
+
+func TestOrderTimelineInterleavesByTime(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    var order Order
+    json.Unmarshal(doRequest(r, http.MethodPost, "/orders", sampleOrder).Body.Bytes(), &order)
+    path := "/orders/" + order.OrderID.String()
+    step := func(method, suffix, body string) {
+        t.Helper()
+        // Keeps every step at a distinct instant, so the expected order
+        // doesn't rest on how ties are broken.
+        time.Sleep(time.Millisecond)
+        if w := doRequest(r, method, path+suffix, body); w.Code >= 300 {
+            t.Fatalf("%s %s: got status %d: %s", method, suffix, w.Code, w.Body)
+        }
+    }
+    step(http.MethodPost, "/tags/vip", "")
+    step(http.MethodPost, "/shipments", `{"items":[{"product_id":"prod_456","quantity":1}]}`)
+    step(http.MethodPost, "/tags/fraud_review", "")
+    step(http.MethodPost, "/refund", `{"amount":"10.00"}`)
+    step(http.MethodDelete, "/tags/vip", "")
+
+    events := getTimeline(t, r, &order, "")
+    want := fmt.Sprint([]string{
+        TimelineStatusChanged, TimelineStatusChanged, // pending, confirmed
+        TimelineTagAdded,
+        TimelineShipped, TimelineStatusChanged, // partially_shipped
+        TimelineTagAdded,
+        TimelineRefunded,
+        TimelineTagRemoved,
+    })
+    if got := timelineTypes(events); got != want {
+        t.Fatalf("got events %s, want %s", got, want)
+    }
+    for i := 1; i < len(events); i++ {
+        if events[i].At.Before(events[i-1].At) {
+            t.Fatalf("event %d at %v is before event %d at %v", i, events[i].At, i-1, events[i-1].At)
+        }
+    }
+    if e := events[4]; e.StatusChange == nil || e.StatusChange.To != StatusPartiallyShipped {
+        t.Fatalf("event 4 = %+v, want the change to partially_shipped", e)
+    }
+    if e := events[6]; e.Refund == nil || e.Refund.Amount.String() != "10" {
+        t.Fatalf("event 6 = %+v, want the 10.00 refund", e)
+    }
+    if e := events[7]; e.Tag != "vip" {
+        t.Fatalf("event 7 = %+v, want vip removed", e)
+    }
+
+    since := url.QueryEscape(events[5].At.Format(time.RFC3339Nano))
+    recent := getTimeline(t, r, &order, "?since="+since)
+    if got := timelineTypes(recent); got != fmt.Sprint([]string{TimelineRefunded, TimelineTagRemoved}) {
+        t.Fatalf("since %s: got %s, want the refund and tag removal", events[5].At, got)
+    }
+}
+
+func TestOrderTimelineRejectsBadSince(t *testing.T) {
+    resetOrders(t)
+    r := setupRouter()
+    order := saveOrderWithStatus(StatusConfirmed)
+
+    if w := doRequest(r, http.MethodGet, "/orders/"+order.OrderID.String()+"/events?since=yesterday", ""); w.Code != http.StatusBadRequest {
+        t.Fatalf("got status %d, want 400", w.Code)
+    }
+}
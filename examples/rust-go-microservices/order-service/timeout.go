@@ -0,0 +1,168 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    defaultRequestTimeout = 10 * time.Second
+    // batchRequestTimeout gives a batch, which charges many orders, longer
+    // than a single request. It must stay under the server write timeout.
+    batchRequestTimeout = 25 * time.Second
+)
+
+// RouteTimeout overrides the request timeout for one route. Path is a gin
+// route pattern such as "/orders/:id"; a Timeout of zero disables the
+// timeout for the route. Accept, if set, limits the override to requests
+// whose Accept header names that media type, for routes that only stream
+// when asked to.
+type RouteTimeout struct {
+    Method  string
+    Path    string
+    Accept  string
+    Timeout time.Duration
+}
+
+// routeTimeouts are the routes allowed a different timeout than the rest.
+var routeTimeouts = []RouteTimeout{
+    {Method: http.MethodPost, Path: "/orders/batch", Timeout: batchRequestTimeout},
+    // The export is streamed; a timeout would hold it all in memory.
+    {Method: http.MethodGet, Path: "/orders/export.csv", Timeout: 0},
+    // CPU profiles and traces run for as long as the client asks.
+    {Method: http.MethodGet, Path: "/debug/pprof/*profile", Timeout: 0},
+    // Order creation streams its progress when asked; the steps themselves
+    // are bounded by the payment and inventory clients.
+    {Method: http.MethodPost, Path: "/orders", Accept: contentTypeEventStream, Timeout: 0},
+}
+
+// withRequestTimeout bounds how long h may take over a request. The
+// request's context gets a deadline, so context-aware work such as payment
+// calls is cancelled when it passes, and the client gets a 504 at the
+// deadline even if h is still running. Whatever h writes after that is
+// discarded.
+//
+// Like http.TimeoutHandler it runs h on its own goroutine with a private
+// writer, so the gin context inside h is never touched after the timeout.
+// A timeout of zero disables it.
+func withRequestTimeout(h http.Handler, timeout time.Duration, routes []RouteTimeout) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        d := timeout
+        for _, rt := range routes {
+            if rt.Method == r.Method && matchRoute(rt.Path, r.URL.Path) &&
+                (rt.Accept == "" || strings.Contains(r.Header.Get("Accept"), rt.Accept)) {
+                d = rt.Timeout
+                break
+            }
+        }
+        if d <= 0 {
+            h.ServeHTTP(w, r)
+            return
+        }
+
+        ctx, cancel := context.WithTimeout(r.Context(), d)
+        defer cancel()
+        tw := &timeoutWriter{header: make(http.Header)}
+        done := make(chan struct{})
+        panicked := make(chan interface{}, 1)
+        go func() {
+            defer func() {
+                if p := recover(); p != nil {
+                    panicked <- p
+                }
+            }()
+            h.ServeHTTP(tw, r.WithContext(ctx))
+            close(done)
+        }()
+
+        select {
+        case p := <-panicked:
+            panic(p)
+        case <-done:
+            tw.mu.Lock()
+            defer tw.mu.Unlock()
+            for k, v := range tw.header {
+                w.Header()[k] = v
+            }
+            if tw.code == 0 {
+                tw.code = http.StatusOK
+            }
+            w.WriteHeader(tw.code)
+            w.Write(tw.buf.Bytes())
+        case <-ctx.Done():
+            tw.mu.Lock()
+            defer tw.mu.Unlock()
+            tw.timedOut = true
+            loggerFrom(r.Context()).Warn("request timed out", "method", r.Method, "path", r.URL.Path, "timeout", d.String())
+            w.Header().Set("Content-Type", "application/json; charset=utf-8")
+            w.WriteHeader(http.StatusGatewayTimeout)
+            json.NewEncoder(w).Encode(errorResponse{Error: APIError{Code: CodeTimeout, Message: "Request timed out"}})
+        }
+    })
+}
+
+// matchRoute reports whether path matches a gin route pattern, where a
+// ":name" segment matches any single segment and a final "*name" segment
+// matches the rest of the path, if any.
+func matchRoute(pattern, path string) bool {
+    want := strings.Split(strings.Trim(pattern, "/"), "/")
+    got := strings.Split(strings.Trim(path, "/"), "/")
+    if last := want[len(want)-1]; strings.HasPrefix(last, "*") {
+        want = want[:len(want)-1]
+        if len(got) > len(want) {
+            got = got[:len(want)]
+        }
+    }
+    if len(want) != len(got) {
+        return false
+    }
+    for i, seg := range want {
+        if !strings.HasPrefix(seg, ":") && seg != got[i] {
+            return false
+        }
+    }
+    return true
+}
+
+// timeoutWriter holds a response until the handler finishes in time.
+// Once the request has timed out, writes fail with http.ErrHandlerTimeout.
+type timeoutWriter struct {
+    header http.Header
+
+    mu       sync.Mutex
+    buf      bytes.Buffer
+    code     int
+    timedOut bool
+}
+
+func (w *timeoutWriter) Header() http.Header { return w.header }
+
+func (w *timeoutWriter) Write(p []byte) (int, error) {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    if w.timedOut {
+        return 0, http.ErrHandlerTimeout
+    }
+    if w.code == 0 {
+        w.code = http.StatusOK
+    }
+    return w.buf.Write(p)
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    if !w.timedOut && w.code == 0 {
+        w.code = code
+    }
+}
+
+// Flush is a no-op: nothing reaches the client until the handler is done.
+func (w *timeoutWriter) Flush() {}
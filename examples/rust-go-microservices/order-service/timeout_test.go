@@ -0,0 +1,129 @@
+package main
+
+import (
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gin-gonic/gin"
+)
+
+func TestSlowHandlerTimesOut(t *testing.T) {
+    r := gin.New()
+    finished := make(chan struct{})
+    r.GET("/slow", func(c *gin.Context) {
+        defer close(finished)
+        time.Sleep(300 * time.Millisecond)
+        c.JSON(http.StatusOK, gin.H{"late": true})
+    })
+    h := withRequestTimeout(r, 50*time.Millisecond, nil)
+
+    start := time.Now()
+    w := doRequest(h, http.MethodGet, "/slow", "")
+    if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+        t.Fatalf("response took %s, want it at the 50ms deadline", elapsed)
+    }
+    if w.Code != http.StatusGatewayTimeout {
+        t.Fatalf("got status %d, want 504", w.Code)
+    }
+    if got := decodeError(t, w).Code; got != CodeTimeout {
+        t.Fatalf("got code %s, want %s", got, CodeTimeout)
+    }
+
+    <-finished
+    if body := w.Body.String(); len(body) == 0 || strings.Contains(body, "late") {
+        t.Fatalf("late response leaked into %q", body)
+    }
+}
+
+func TestFastHandlerUnaffectedByTimeout(t *testing.T) {
+    h := withRequestTimeout(setupRouter(), time.Second, nil)
+
+    w := doRequest(h, http.MethodGet, "/health/live", "")
+    if w.Code != http.StatusOK {
+        t.Fatalf("got status %d", w.Code)
+    }
+    if got := w.Header().Get("Content-Type"); got != "application/json; charset=utf-8" {
+        t.Fatalf("got Content-Type %q", got)
+    }
+}
+
+func TestTimeoutCancelsPayment(t *testing.T) {
+    resetOrders(t)
+    cancelled := make(chan struct{})
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        // The server only notices the client hanging up once the body has
+        // been read.
+        io.ReadAll(r.Body)
+        select {
+        case <-r.Context().Done():
+            close(cancelled)
+        case <-time.After(5 * time.Second):
+        }
+    }))
+    t.Cleanup(srv.Close)
+    prev := payments
+    payments = NewPaymentClient(srv.URL)
+    t.Cleanup(func() { payments = prev })
+    r := setupRouter()
+    handlerDone := make(chan struct{})
+    h := withRequestTimeout(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+        defer close(handlerDone)
+        r.ServeHTTP(w, req)
+    }), 50*time.Millisecond, nil)
+
+    if w := doRequest(h, http.MethodPost, "/orders", sampleOrder); w.Code != http.StatusGatewayTimeout {
+        t.Fatalf("got status %d, want 504", w.Code)
+    }
+    select {
+    case <-cancelled:
+    case <-time.After(2 * time.Second):
+        t.Fatal("payment call was not cancelled at the deadline")
+    }
+    <-handlerDone
+}
+
+func TestRouteTimeoutOverride(t *testing.T) {
+    r := gin.New()
+    r.POST("/orders/:id/slow", func(c *gin.Context) {
+        time.Sleep(100 * time.Millisecond)
+        c.Status(http.StatusNoContent)
+    })
+    routes := []RouteTimeout{{Method: http.MethodPost, Path: "/orders/:id/slow", Timeout: time.Second}}
+    h := withRequestTimeout(r, 10*time.Millisecond, routes)
+
+    if w := doRequest(h, http.MethodPost, "/orders/123/slow", ""); w.Code != http.StatusNoContent {
+        t.Fatalf("got status %d, want the route's longer timeout to let it finish", w.Code)
+    }
+}
+
+func TestMatchRoute(t *testing.T) {
+    tests := []struct {
+        pattern, path string
+        want          bool
+    }{
+        {"/orders/:id", "/orders/123", true},
+        {"/orders/:id", "/orders/123/cancel", false},
+        {"/orders/batch", "/orders/export.csv", false},
+        {"/debug/pprof/*profile", "/debug/pprof/", true},
+        {"/debug/pprof/*profile", "/debug/pprof/profile", true},
+        {"/debug/pprof/*profile", "/debug/pprof/a/b", true},
+        {"/debug/pprof/*profile", "/debug/other", false},
+    }
+    for _, tt := range tests {
+        if got := matchRoute(tt.pattern, tt.path); got != tt.want {
+            t.Errorf("matchRoute(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+        }
+    }
+}
+
+func TestRequestTimeoutMustBeShorterThanWriteTimeout(t *testing.T) {
+    t.Setenv("SERVER_WRITE_TIMEOUT", "20s")
+    t.Setenv("REQUEST_TIMEOUT", "20s")
+    if _, err := serverTimeoutsFromEnv(10 * time.Second); err == nil {
+        t.Fatal("accepted a request timeout as long as the write timeout")
+    }
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+    "context"
+    "os"
+
+    "github.com/gin-gonic/gin"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+    "go.opentelemetry.io/otel/propagation"
+    "go.opentelemetry.io/otel/sdk/resource"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+    "go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "order-service"
+
+func init() {
+    otel.SetTextMapPropagator(propagation.TraceContext{})
+}
+
+// startSpan starts a span from the global tracer provider, which is a no-op
+// unless setupTracing installed an exporter.
+func startSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+    return otel.Tracer(tracerName).Start(ctx, name, opts...)
+}
+
+// setupTracing exports spans over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT
+// (or the traces-specific variant) is set; the exporter reads its other
+// settings from the standard OTEL_* variables. When unset, tracing stays a
+// no-op so local runs and tests need no collector. The returned function
+// flushes pending spans.
+func setupTracing(ctx context.Context) (shutdown func(context.Context) error, err error) {
+    if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+        return func(context.Context) error { return nil }, nil
+    }
+
+    exporter, err := otlptracehttp.New(ctx)
+    if err != nil {
+        return nil, err
+    }
+    tp := sdktrace.NewTracerProvider(
+        sdktrace.WithBatcher(exporter),
+        sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(tracerName))),
+    )
+    otel.SetTracerProvider(tp)
+    return tp.Shutdown, nil
+}
+
+// extractTraceContext continues any trace the caller started, as carried in
+// the W3C traceparent header.
+func extractTraceContext() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+        c.Request = c.Request.WithContext(ctx)
+        c.Next()
+    }
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/attribute"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    "go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs an in-memory span recorder as the global tracer
+// provider for the duration of the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+    t.Helper()
+
+    sr := tracetest.NewSpanRecorder()
+    prev := otel.GetTracerProvider()
+    otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+    t.Cleanup(func() { otel.SetTracerProvider(prev) })
+    return sr
+}
+
+func spanAttrs(s sdktrace.ReadOnlySpan) map[attribute.Key]string {
+    attrs := make(map[attribute.Key]string)
+    for _, kv := range s.Attributes() {
+        attrs[kv.Key] = kv.Value.Emit()
+    }
+    return attrs
+}
+
+func TestCreateOrderTracesPayment(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    sr := recordSpans(t)
+    r := setupRouter()
+
+    const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+    w := doRequestWithHeaders(r, http.MethodPost, "/orders", sampleOrder, map[string]string{"traceparent": parent})
+    if w.Code != http.StatusCreated {
+        t.Fatalf("create: status %d", w.Code)
+    }
+
+    spans := make(map[string]sdktrace.ReadOnlySpan)
+    for _, s := range sr.Ended() {
+        spans[s.Name()] = s
+    }
+    for _, name := range []string{"createOrder", "validateOrder", "calculateTotal", "processPayment"} {
+        s, ok := spans[name]
+        if !ok {
+            t.Fatalf("no %s span recorded", name)
+        }
+        if got := s.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+            t.Errorf("%s span in trace %s, want the caller's trace", name, got)
+        }
+    }
+
+    payment := spans["processPayment"]
+    if payment.Parent().SpanID() != spans["createOrder"].SpanContext().SpanID() {
+        t.Error("processPayment is not a child of createOrder")
+    }
+    attrs := spanAttrs(payment)
+    if attrs["payment.currency"] != "USD" || attrs["payment.amount"] != "59.98" || attrs["payment.status"] != "approved" {
+        t.Errorf("unexpected payment span attributes: %v", attrs)
+    }
+
+    sent := fake.lastTraceparent.Load()
+    want := "00-" + payment.SpanContext().TraceID().String() + "-" + payment.SpanContext().SpanID().String() + "-01"
+    if sent == nil || *sent != want {
+        t.Errorf("payment request traceparent = %v, want %s", sent, want)
+    }
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+)
+
+// UpdateOrderRequest is the body of PATCH /orders/:id. Each field is
+// optional and only the ones sent change. Items replaces the order's items
+// wholesale; Metadata is merged into the order's, with a null value
+// removing its key.
+type UpdateOrderRequest struct {
+    Items    []OrderItem        `json:"items" binding:"omitempty,dive"`
+    Metadata map[string]*string `json:"metadata"`
+    Notes    *string            `json:"notes"`
+}
+
+// updateOrder applies a PATCH. New items are only accepted while the order
+// is pending, and reprice it; it is not charged again, whatever the new
+// total is. Metadata and notes may change in any status. What changed is
+// recorded as one of the order's Amendments.
+//
+// A dry run (see isDryRun) validates and prices the change the same way
+// and returns the order as it would be saved, without saving it, so a
+// client can show the new total before committing to it.
+func updateOrder(c *gin.Context) {
+    // Held so an edit can't land while the scheduler is charging the order.
+    unlock := lockOrderParam(c)
+    defer unlock()
+    order := loadOrder(c)
+    if order == nil {
+        return
+    }
+
+    var req UpdateOrderRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        respondBindError(c, err)
+        return
+    }
+    if req.Items == nil && req.Metadata == nil && req.Notes == nil {
+        respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Nothing to update: send items, metadata or notes")
+        return
+    }
+
+    // A dry run writes nothing, so it needs no If-Match; one sent anyway is
+    // still checked, so a stale preview is noticed.
+    dryRun := isDryRun(c)
+    if (!dryRun || c.GetHeader("If-Match") != "") && !checkIfMatch(c, order) {
+        return
+    }
+    if req.Items != nil && order.Status != StatusPending {
+        respondError(c, http.StatusConflict, CodeInvalidStatusTransition,
+            fmt.Sprintf("Order items cannot be modified in status %q", order.Status))
+        return
+    }
+
+    before := *order
+    if req.Metadata != nil {
+        order.Metadata = mergeMetadata(order.Metadata, req.Metadata)
+    }
+    if req.Notes != nil {
+        order.Notes = *req.Notes
+    }
+    if req.Items != nil {
+        normalizeItemCurrencies(req.Items)
+        resetItemStatuses(req.Items)
+        order.Items = req.Items
+        if err := validateOrder(order); err != nil {
+            respondValidationError(c, err.(*ValidationError))
+            return
+        }
+        priceOrder(order)
+    } else {
+        verr := &ValidationError{}
+        validateMetadata(verr, order.Metadata, order.Notes)
+        if verr.err() != nil {
+            respondValidationError(c, verr)
+            return
+        }
+    }
+
+    recordAmendment(&before, order)
+    if dryRun {
+        respondPreview(c, withLinks(order))
+        return
+    }
+    if err := orders.Save(order); err != nil {
+        respondSaveError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, withLinks(order))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+
+    "github.com/shopspring/decimal"
+)
+
+func TestUpdatePendingOrder(t *testing.T) {
+    tests := []struct {
+        name      string
+        items     string
+        wantTotal string
+    }{
+        {"same total", `[{"product_id":"prod_789","quantity":1,"price":"59.98"}]`, "59.98"},
+        {"changed total", `[{"product_id":"prod_456","quantity":3,"price":"29.99"}]`, "89.97"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fake := newPaymentServer(t)
+            resetOrders(t)
+            r := setupRouter()
+            order := saveOrderWithStatus(StatusPending)
+
+            w := doRequestWithHeaders(r, http.MethodPatch, "/orders/"+order.OrderID.String(), `{"items":`+tt.items+`}`, ifMatch(order))
+            if w.Code != http.StatusOK {
+                t.Fatalf("got status %d: %s", w.Code, w.Body)
+            }
+            var got Order
+            json.Unmarshal(w.Body.Bytes(), &got)
+            if !got.TotalAmount.Equal(decimal.RequireFromString(tt.wantTotal)) || got.Status != StatusPending {
+                t.Fatalf("got total %s status %s, want %s pending", got.TotalAmount, got.Status, tt.wantTotal)
+            }
+
+            stored, _ := orders.FindByID(order.OrderID)
+            if len(stored.Items) != 1 || !stored.TotalAmount.Equal(got.TotalAmount) {
+                t.Fatalf("update not saved: %+v", stored)
+            }
+            if n := fake.charges.Load(); n != 0 {
+                t.Fatalf("update charged the order %d times", n)
+            }
+        })
+    }
+}
+
+func TestUpdateOrderRejected(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+    items := `{"items":[{"product_id":"p","quantity":1,"price":"1.00"}]}`
+
+    confirmed := saveOrderWithStatus(StatusConfirmed)
+    if w := doRequestWithHeaders(r, http.MethodPatch, "/orders/"+confirmed.OrderID.String(), items, ifMatch(confirmed)); w.Code != http.StatusConflict {
+        t.Fatalf("confirmed order: got status %d, want 409", w.Code)
+    }
+    if stored, _ := orders.FindByID(confirmed.OrderID); len(stored.Items) != 0 {
+        t.Fatalf("confirmed order was modified: %+v", stored.Items)
+    }
+
+    pending := saveOrderWithStatus(StatusPending)
+    invalid := `{"items":[{"product_id":"p","quantity":0,"price":"1.00"}]}`
+    if w := doRequestWithHeaders(r, http.MethodPatch, "/orders/"+pending.OrderID.String(), invalid, ifMatch(pending)); w.Code != http.StatusUnprocessableEntity {
+        t.Fatalf("invalid items: got status %d, want 422", w.Code)
+    }
+    if w := doRequestWithHeaders(r, http.MethodPatch, "/orders/"+pending.OrderID.String(), `{"items":[]}`, ifMatch(pending)); w.Code != http.StatusUnprocessableEntity {
+        t.Fatalf("no items: got status %d, want 422", w.Code)
+    }
+}
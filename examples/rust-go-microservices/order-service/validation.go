@@ -0,0 +1,252 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "reflect"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "github.com/gin-gonic/gin/binding"
+    "github.com/go-playground/validator/v10"
+    "github.com/shopspring/decimal"
+)
+
+// FieldError describes one invalid field, named by its JSON path
+// (e.g. "items[0].quantity").
+type FieldError struct {
+    Field   string `json:"field"`
+    Message string `json:"message"`
+}
+
+// ValidationError collects every problem found in a request body so clients
+// can fix them all at once.
+type ValidationError struct {
+    Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+    msgs := make([]string, len(e.Fields))
+    for i, f := range e.Fields {
+        msgs[i] = f.Field + ": " + f.Message
+    }
+    return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) add(field, format string, args ...interface{}) {
+    e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// err returns e as an error, or nil if no problems were recorded.
+func (e *ValidationError) err() error {
+    if len(e.Fields) == 0 {
+        return nil
+    }
+    return e
+}
+
+func init() {
+    // Report binding failures by JSON name rather than Go field name.
+    if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+        v.RegisterTagNameFunc(func(f reflect.StructField) string {
+            name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+            if name == "-" {
+                return ""
+            }
+            return name
+        })
+    }
+}
+
+const (
+    defaultMaxItemQuantity = 10000
+    defaultMaxOrderItems   = 1000
+)
+
+// maxItemQuantity and maxOrderTotal cap what a single order may ask for,
+// so a typo can't charge for a billion units. Zero disables a cap. The
+// total cap is in the order's own currency and is off by default, since no
+// one figure suits every currency.
+var (
+    maxItemQuantity = defaultMaxItemQuantity
+    maxOrderTotal   decimal.Decimal
+)
+
+// maxOrderItems caps how many line items one order may have, so an order
+// can't make pricing it and storing it arbitrarily expensive. Many small
+// items fit under the body size limit, so that doesn't bound it. Zero
+// disables the cap.
+var maxOrderItems = defaultMaxOrderItems
+
+// strictPrecision rejects item prices, fixed discounts and expected totals
+// with more decimal places than the order's currency has, instead of
+// rounding the total. It is off by default, since some catalogues price
+// below the minor unit (e.g. 0.015 USD per screw).
+var strictPrecision bool
+
+// validatePrecision records field if strictPrecision is on and amount is
+// finer than currency's minor units.
+func validatePrecision(verr *ValidationError, field string, amount decimal.Decimal, currency Currency, known bool) {
+    if strictPrecision && known && !currency.fitsMinorUnits(amount) {
+        verr.add(field, "has more than %d decimal places for %s", currency.MinorUnits, currency.Code)
+    }
+}
+
+// maxAmountDigits and maxAmountScale bound every amount a client sends:
+// at most that many digits before and after the decimal point. They are far
+// beyond any real price, but without them a value like "1e1000000000"
+// decodes fine and then takes forever to compare or round.
+const (
+    maxAmountDigits = 18
+    maxAmountScale  = 18
+)
+
+// amountInRange reports whether d is within maxAmountDigits and
+// maxAmountScale.
+func amountInRange(d decimal.Decimal) bool {
+    exp := int(d.Exponent())
+    return exp >= -maxAmountScale && exp+d.NumDigits() <= maxAmountDigits
+}
+
+// validateAmounts checks that every amount in the order is in range, and
+// reports whether they all are.
+func validateAmounts(verr *ValidationError, order *Order) bool {
+    ok := true
+    check := func(field string, d decimal.Decimal) {
+        if !amountInRange(d) {
+            verr.add(field, "must have at most %d digits before and %d after the decimal point", maxAmountDigits, maxAmountScale)
+            ok = false
+        }
+    }
+    for i, item := range order.Items {
+        check(fmt.Sprintf("items[%d].price", i), item.Price)
+        if item.Discount != nil {
+            check(fmt.Sprintf("items[%d].discount.value", i), item.Discount.Value)
+        }
+    }
+    if order.Discount != nil {
+        check("discount.value", order.Discount.Value)
+    }
+    if order.ExpectedTotal != nil {
+        check("expected_total", *order.ExpectedTotal)
+    }
+    for i, split := range order.PaymentSplits {
+        check(fmt.Sprintf("payment_splits[%d].amount", i), split.Amount)
+    }
+    return ok
+}
+
+// validateOrder enforces the business rules for a new order.
+func validateOrder(order *Order) error {
+    verr := &ValidationError{}
+
+    // Everything below goes over every item, so an order with too many is
+    // turned away first.
+    if maxOrderItems > 0 && len(order.Items) > maxOrderItems {
+        verr.add("items", "must contain at most %d items, got %d", maxOrderItems, len(order.Items))
+        return verr.err()
+    }
+    // Nothing below is safe to work out from an amount out of range.
+    if !validateAmounts(verr, order) {
+        return verr.err()
+    }
+
+    if strings.TrimSpace(order.CustomerID) == "" {
+        verr.add("customer_id", "must not be empty")
+    }
+    currency, known := lookupCurrency(order.Currency)
+    if !known {
+        verr.add("currency", "%q is not a supported ISO 4217 currency", order.Currency)
+    }
+    if len(order.Items) == 0 {
+        verr.add("items", "must contain at least one item")
+    }
+    for i, item := range order.Items {
+        field := fmt.Sprintf("items[%d]", i)
+        if strings.TrimSpace(item.ProductID) == "" {
+            verr.add(field+".product_id", "must not be empty")
+        }
+        if item.Quantity < 1 {
+            verr.add(field+".quantity", "must be at least 1")
+        }
+        if maxItemQuantity > 0 && item.Quantity > maxItemQuantity {
+            verr.add(field+".quantity", "must be at most %d", maxItemQuantity)
+        }
+        // Unless strictPrecision is on, unit prices may be finer than the
+        // currency's minor units (e.g. 0.015 USD); calculateTotal rounds
+        // the total instead.
+        if item.Price.IsNegative() {
+            verr.add(field+".price", "must not be negative")
+        }
+        validatePrecision(verr, field+".price", item.Price, currency, known)
+        if item.Discount != nil && item.Discount.Type == DiscountFixed {
+            validatePrecision(verr, field+".discount.value", item.Discount.Value, currency, known)
+        }
+        if item.Currency != "" && item.Currency != order.Currency {
+            verr.add(field+".currency", "%s does not match order currency %s", item.Currency, order.Currency)
+        }
+        validateDiscount(verr, field+".discount", item.Discount, item.Price.Mul(decimal.NewFromInt(int64(item.Quantity))))
+    }
+    validateDiscount(verr, "discount", order.Discount, itemsTotal(order.Items))
+    if order.Discount != nil && order.Discount.Type == DiscountFixed {
+        validatePrecision(verr, "discount.value", order.Discount.Value, currency, known)
+    }
+    if order.ExpectedTotal != nil {
+        validatePrecision(verr, "expected_total", *order.ExpectedTotal, currency, known)
+    }
+
+    validatePaymentMethod(verr, "payment_method", order.PaymentMethod, clock.Now())
+    validatePaymentSplits(verr, order, currency, known, clock.Now())
+    validateChannel(verr, order)
+    validateMetadata(verr, order.Metadata, order.Notes)
+    validateTags(verr, order.Tags)
+    validateAddresses(verr, order)
+
+    // The cap applies to what would be charged, tax and shipping included,
+    // which is only worth working out for an otherwise valid order.
+    if maxOrderTotal.IsPositive() && verr.err() == nil {
+        priced := *order
+        priceOrder(&priced)
+        if priced.TotalAmount.GreaterThan(maxOrderTotal) {
+            verr.add("total_amount", "%s exceeds the maximum order total of %s", priced.TotalAmount, maxOrderTotal)
+        }
+    }
+
+    return verr.err()
+}
+
+// bindingFieldErrors converts gin binding tag failures into FieldErrors.
+// It reports false if err is not a tag validation failure (e.g. bad JSON).
+func bindingFieldErrors(err error) (*ValidationError, bool) {
+    var verrs validator.ValidationErrors
+    if !errors.As(err, &verrs) {
+        return nil, false
+    }
+
+    out := &ValidationError{}
+    for _, fe := range verrs {
+        // Namespace is "Order.items[0].product_id"; drop the struct name.
+        field := fe.Namespace()
+        if i := strings.IndexByte(field, '.'); i >= 0 {
+            field = field[i+1:]
+        }
+        switch fe.Tag() {
+        case "required":
+            out.add(field, "is required")
+        default:
+            out.add(field, "failed %q validation", fe.Tag())
+        }
+    }
+    return out, true
+}
+
+func respondValidationError(c *gin.Context, verr *ValidationError) {
+    validationFailed(verr).respond(c)
+}
+
+func validationFailed(verr *ValidationError) *requestError {
+    rerr := newRequestError(http.StatusUnprocessableEntity, CodeValidationFailed, "Validation failed")
+    rerr.Details = gin.H{"fields": verr.Fields}
+    return rerr
+}
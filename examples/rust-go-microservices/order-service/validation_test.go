@@ -0,0 +1,261 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "strings"
+    "testing"
+
+    "github.com/shopspring/decimal"
+)
+
+func validOrder() *Order {
+    return &Order{
+        CustomerID: "cust_123",
+        Currency:   "USD",
+        Items: []OrderItem{
+            {ProductID: "prod_456", Quantity: 2, Price: decimal.RequireFromString("29.99")},
+        },
+    }
+}
+
+func fieldsOf(err error) []string {
+    verr, ok := err.(*ValidationError)
+    if !ok {
+        return nil
+    }
+    fields := make([]string, len(verr.Fields))
+    for i, f := range verr.Fields {
+        fields[i] = f.Field
+    }
+    return fields
+}
+
+func TestValidateOrder(t *testing.T) {
+    tests := []struct {
+        name   string
+        mutate func(*Order)
+        want   []string
+    }{
+        {"valid", func(o *Order) {}, nil},
+        {"free item", func(o *Order) { o.Items[0].Price = decimal.Zero }, nil},
+        {"missing customer", func(o *Order) { o.CustomerID = " " }, []string{"customer_id"}},
+        {"no items", func(o *Order) { o.Items = nil }, []string{"items"}},
+        {"empty product", func(o *Order) { o.Items[0].ProductID = "" }, []string{"items[0].product_id"}},
+        {"zero quantity", func(o *Order) { o.Items[0].Quantity = 0 }, []string{"items[0].quantity"}},
+        {"negative price", func(o *Order) { o.Items[0].Price = decimal.NewFromInt(-1) }, []string{"items[0].price"}},
+        {"several problems", func(o *Order) {
+            o.CustomerID = ""
+            o.Items = append(o.Items, OrderItem{ProductID: "p", Quantity: -1})
+        }, []string{"customer_id", "items[1].quantity"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            order := validOrder()
+            tt.mutate(order)
+            err := validateOrder(order)
+            got := fieldsOf(err)
+            if len(got) != len(tt.want) {
+                t.Fatalf("validateOrder = %v, want fields %v", err, tt.want)
+            }
+            for i := range got {
+                if got[i] != tt.want[i] {
+                    t.Fatalf("validateOrder fields = %v, want %v", got, tt.want)
+                }
+            }
+        })
+    }
+}
+
+func TestCreateOrderRejectsInvalidPayload(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    tests := []struct {
+        body string
+        want string
+    }{
+        {`{"customer_id":"c","items":[]}`, "items"},
+        {`{"items":[{"product_id":"p","quantity":1,"price":"1"}]}`, "customer_id"},
+        {`{"customer_id":"c","items":[{"quantity":1,"price":"1"}]}`, "items[0].product_id"},
+        {`{"customer_id":"c","items":[{"product_id":"p","quantity":0,"price":"1"}]}`, "items[0].quantity"},
+    }
+    for _, tt := range tests {
+        w := doRequest(r, http.MethodPost, "/orders", tt.body)
+        if w.Code != http.StatusUnprocessableEntity {
+            t.Errorf("%s: got status %d, want 422", tt.body, w.Code)
+            continue
+        }
+        resp := decodeValidationError(w)
+        if len(resp.Fields) != 1 || resp.Fields[0].Field != tt.want {
+            t.Errorf("%s: got fields %+v, want %s", tt.body, resp.Fields, tt.want)
+        }
+    }
+    if n := fake.charges.Load(); n != 0 {
+        t.Fatalf("invalid orders were charged %d times", n)
+    }
+}
+
+func TestCreateOrderMalformedJSONIsBadRequest(t *testing.T) {
+    resetOrders(t)
+    r := setupRouter()
+
+    if w := doRequest(r, http.MethodPost, "/orders", `{"customer_id":`); w.Code != http.StatusBadRequest {
+        t.Fatalf("got status %d, want 400", w.Code)
+    }
+}
+
+func useLimits(t *testing.T, quantity int, total string) {
+    t.Helper()
+
+    prevQuantity, prevTotal := maxItemQuantity, maxOrderTotal
+    maxItemQuantity, maxOrderTotal = quantity, decimal.RequireFromString(total)
+    t.Cleanup(func() { maxItemQuantity, maxOrderTotal = prevQuantity, prevTotal })
+}
+
+func TestValidateOrderQuantityCap(t *testing.T) {
+    useLimits(t, 100, "0")
+    for quantity, want := range map[int][]string{
+        99:  nil,
+        100: nil,
+        101: {"items[0].quantity"},
+    } {
+        order := validOrder()
+        order.Items[0].Quantity = quantity
+        if got := fieldsOf(validateOrder(order)); len(got) != len(want) || (len(want) > 0 && got[0] != want[0]) {
+            t.Errorf("quantity %d: got fields %v, want %v", quantity, got, want)
+        }
+    }
+}
+
+func TestValidateOrderTotalCap(t *testing.T) {
+    // validOrder totals 59.98.
+    for limit, want := range map[string][]string{
+        "59.99": nil,
+        "59.98": nil,
+        "59.97": {"total_amount"},
+    } {
+        useLimits(t, 0, limit)
+        if got := fieldsOf(validateOrder(validOrder())); len(got) != len(want) || (len(want) > 0 && got[0] != want[0]) {
+            t.Errorf("cap %s: got fields %v, want %v", limit, got, want)
+        }
+    }
+}
+
+func TestValidateOrderTotalCapIncludesTaxAndShipping(t *testing.T) {
+    useLimits(t, 0, "60")
+    usePricing(t, TaxRates{}, ShippingRates{Flat: decimal.RequireFromString("4.99")})
+
+    if got := fieldsOf(validateOrder(validOrder())); len(got) != 1 || got[0] != "total_amount" {
+        t.Fatalf("got fields %v, want total_amount", got)
+    }
+}
+
+func TestCreateOrderOverLimitIsRejected(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    useLimits(t, 1000, "10000")
+    r := setupRouter()
+
+    for _, body := range []string{
+        `{"customer_id":"c","items":[{"product_id":"p","quantity":1000000000,"price":"1"}]}`,
+        `{"customer_id":"c","items":[{"product_id":"p","quantity":2,"price":"5000.01"}]}`,
+    } {
+        if w := doRequest(r, http.MethodPost, "/orders", body); w.Code != http.StatusUnprocessableEntity {
+            t.Errorf("%s: got status %d, want 422", body, w.Code)
+        }
+    }
+    if n := fake.charges.Load(); n != 0 {
+        t.Fatalf("orders over the limits were charged %d times", n)
+    }
+}
+
+func useMaxOrderItems(t *testing.T, n int) {
+    t.Helper()
+
+    prev := maxOrderItems
+    maxOrderItems = n
+    t.Cleanup(func() { maxOrderItems = prev })
+}
+
+// manyItems is an order body with n one-unit line items.
+func manyItems(n int) string {
+    items := make([]string, n)
+    for i := range items {
+        items[i] = fmt.Sprintf(`{"product_id":"prod_%d","quantity":1,"price":"1.00"}`, i)
+    }
+    return `{"customer_id":"cust_123","items":[` + strings.Join(items, ",") + `]}`
+}
+
+func TestValidateOrderItemCountCap(t *testing.T) {
+    useMaxOrderItems(t, 3)
+    for n, want := range map[int][]string{
+        3: nil,
+        4: {"items"},
+    } {
+        order := validOrder()
+        for len(order.Items) < n {
+            order.Items = append(order.Items, order.Items[0])
+        }
+        if got := fieldsOf(validateOrder(order)); len(got) != len(want) || (len(want) > 0 && got[0] != want[0]) {
+            t.Errorf("%d items: got fields %v, want %v", n, got, want)
+        }
+    }
+}
+
+func TestCreateOrderItemCountCap(t *testing.T) {
+    fake := newPaymentServer(t)
+    resetOrders(t)
+    useMaxOrderItems(t, 50)
+    r := setupRouter()
+
+    if w := doRequest(r, http.MethodPost, "/orders", manyItems(50)); w.Code != http.StatusCreated {
+        t.Fatalf("at the cap: got status %d: %s", w.Code, w.Body)
+    }
+    w := doRequest(r, http.MethodPost, "/orders", manyItems(51))
+    if w.Code != http.StatusUnprocessableEntity {
+        t.Fatalf("over the cap: got status %d: %s", w.Code, w.Body)
+    }
+    // The one error is the count, not a complaint about every item.
+    if verr := decodeValidationError(w); len(verr.Fields) != 1 || verr.Fields[0].Field != "items" {
+        t.Fatalf("got fields %+v, want only items", verr.Fields)
+    }
+    if n := fake.charges.Load(); n != 1 {
+        t.Fatalf("charged %d times, want only the order at the cap", n)
+    }
+}
+
+func TestValidateOrderRejectsOutOfRangeAmounts(t *testing.T) {
+    d := decimal.RequireFromString
+    tests := []struct {
+        name   string
+        modify func(o *Order)
+        want   string
+    }{
+        {"huge exponent", func(o *Order) { o.Items[0].Price = d("1e1000000000") }, "items[0].price"},
+        {"tiny exponent", func(o *Order) { o.Items[0].Price = d("1e-1000000000") }, "items[0].price"},
+        {"too many digits", func(o *Order) { o.Items[0].Price = d("1234567890123456789") }, "items[0].price"},
+        {"item discount", func(o *Order) {
+            o.Items[0].Discount = &Discount{Type: DiscountFixed, Value: d("9e999999")}
+        }, "items[0].discount.value"},
+        {"order discount", func(o *Order) { o.Discount = &Discount{Type: DiscountPercentage, Value: d("1e-99")} }, "discount.value"},
+        {"expected total", func(o *Order) { total := d("5e50"); o.ExpectedTotal = &total }, "expected_total"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            order := validOrder()
+            tt.modify(order)
+            got := fieldsOf(validateOrder(order))
+            if len(got) != 1 || got[0] != tt.want {
+                t.Fatalf("got fields %v, want [%s]", got, tt.want)
+            }
+        })
+    }
+
+    order := validOrder()
+    order.Items[0].Price = d("999999999999999999.999999999999999999")
+    if err := validateOrder(order); err != nil {
+        t.Fatalf("largest amount in range: %v", err)
+    }
+}
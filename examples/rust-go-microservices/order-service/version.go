@@ -0,0 +1,71 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+)
+
+// checkIfMatch enforces the If-Match precondition on a write: the header
+// must carry the order's current version, as returned in its "version"
+// field or as the ETag from getOrder. A missing header gets 428 and a stale
+// one 409. It reports whether the write may go ahead.
+func checkIfMatch(c *gin.Context, order *Order) bool {
+    header := c.GetHeader("If-Match")
+    if header == "" {
+        respondError(c, http.StatusPreconditionRequired, CodePreconditionRequired,
+            "If-Match header with the order version is required")
+        return false
+    }
+    version, err := strconv.ParseInt(etagValue(header), 10, 64)
+    if err != nil {
+        respondError(c, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("Invalid If-Match version %q", header))
+        return false
+    }
+    if version != order.Version {
+        respondVersionConflict(c)
+        return false
+    }
+    return true
+}
+
+// orderETag is a weak entity tag for the order's current state. Every save
+// bumps the version, so the tag changes whenever the order does. It is weak
+// because the same version may be rendered as JSON or msgpack.
+func orderETag(order *Order) string {
+    return fmt.Sprintf(`W/"%d"`, order.Version)
+}
+
+// etagValue strips the weak prefix and quotes from an entity tag.
+func etagValue(tag string) string {
+    return strings.Trim(strings.TrimPrefix(strings.TrimSpace(tag), "W/"), `"`)
+}
+
+// noneMatch reports whether an If-None-Match header lists etag, or is "*",
+// comparing weakly as RFC 9110 requires for that header.
+func noneMatch(header, etag string) bool {
+    for _, tag := range strings.Split(header, ",") {
+        if tag = strings.TrimSpace(tag); tag == "*" || (tag != "" && etagValue(tag) == etagValue(etag)) {
+            return true
+        }
+    }
+    return false
+}
+
+// respondSaveError reports a failed Save: 409 if the order changed since it
+// was read, 500 otherwise.
+func respondSaveError(c *gin.Context, err error) {
+    if errors.Is(err, ErrVersionConflict) {
+        respondVersionConflict(c)
+        return
+    }
+    respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to save order")
+}
+
+func respondVersionConflict(c *gin.Context) {
+    respondError(c, http.StatusConflict, CodeVersionConflict, "Order has been modified; fetch it and retry")
+}
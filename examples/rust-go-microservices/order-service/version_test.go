@@ -0,0 +1,162 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "path/filepath"
+    "strconv"
+    "sync"
+    "sync/atomic"
+    "testing"
+)
+
+// ifMatch is the If-Match header naming order's current version.
+func ifMatch(order *Order) map[string]string {
+    return map[string]string{"If-Match": strconv.FormatInt(order.Version, 10)}
+}
+
+func TestSaveIncrementsVersion(t *testing.T) {
+    for name, repo := range map[string]OrderRepository{
+        "memory": NewOrderStore(),
+        "sqlite": openTestSQLite(t, filepath.Join(t.TempDir(), "orders.db")),
+    } {
+        t.Run(name, func(t *testing.T) {
+            order := &Order{OrderID: orderIDs.NewID(), CustomerID: "c", Currency: "USD", Status: StatusPending}
+            if err := repo.Save(order); err != nil || order.Version != 1 {
+                t.Fatalf("first save: version %d, err %v", order.Version, err)
+            }
+
+            stale, _ := repo.FindByID(order.OrderID)
+            order.Status = StatusConfirmed
+            if err := repo.Save(order); err != nil || order.Version != 2 {
+                t.Fatalf("second save: version %d, err %v", order.Version, err)
+            }
+
+            stale.Status = StatusCancelled
+            if err := repo.Save(stale); !errors.Is(err, ErrVersionConflict) {
+                t.Fatalf("stale save: got %v, want ErrVersionConflict", err)
+            }
+            if got, _ := repo.FindByID(order.OrderID); got.Status != StatusConfirmed || got.Version != 2 {
+                t.Fatalf("stale save changed the order: status %s version %d", got.Status, got.Version)
+            }
+        })
+    }
+}
+
+func TestConcurrentSavesOnlyOneWins(t *testing.T) {
+    resetOrders(t)
+    order := saveOrderWithStatus(StatusPending)
+
+    var wins atomic.Int64
+    var wg sync.WaitGroup
+    for i := 0; i < 20; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            copied := *order
+            if orders.Save(&copied) == nil {
+                wins.Add(1)
+            }
+        }()
+    }
+    wg.Wait()
+
+    if got := wins.Load(); got != 1 {
+        t.Fatalf("%d saves of the same version succeeded, want 1", got)
+    }
+}
+
+func TestVersionedUpdate(t *testing.T) {
+    resetOrders(t)
+    newPaymentServer(t)
+    r := setupRouter()
+    order := saveOrderWithStatus(StatusPending)
+    items := `{"items":[{"product_id":"p","quantity":1,"price":"1.00"}]}`
+
+    w := doRequestWithHeaders(r, http.MethodPatch, "/orders/"+order.OrderID.String(), items, map[string]string{"If-Match": `"1"`})
+    if w.Code != http.StatusOK {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    var updated Order
+    json.Unmarshal(w.Body.Bytes(), &updated)
+    if updated.Version != 2 {
+        t.Fatalf("got version %d after update, want 2", updated.Version)
+    }
+
+    w = doRequestWithHeaders(r, http.MethodPost, "/orders/"+order.OrderID.String()+"/cancel", "", ifMatch(&updated))
+    if w.Code != http.StatusOK {
+        t.Fatalf("cancel with the new version: got status %d: %s", w.Code, w.Body)
+    }
+}
+
+func TestStaleVersionRejected(t *testing.T) {
+    resetOrders(t)
+    newPaymentServer(t)
+    r := setupRouter()
+    order := saveOrderWithStatus(StatusPending)
+    stale := ifMatch(order)
+    items := `{"items":[{"product_id":"p","quantity":1,"price":"1.00"}]}`
+
+    if w := doRequestWithHeaders(r, http.MethodPatch, "/orders/"+order.OrderID.String(), items, stale); w.Code != http.StatusOK {
+        t.Fatalf("first update: got status %d", w.Code)
+    }
+    w := doRequestWithHeaders(r, http.MethodPost, "/orders/"+order.OrderID.String()+"/cancel", "", stale)
+    if w.Code != http.StatusConflict {
+        t.Fatalf("got status %d, want 409", w.Code)
+    }
+    if got := decodeError(t, w).Code; got != CodeVersionConflict {
+        t.Fatalf("got code %s, want %s", got, CodeVersionConflict)
+    }
+    if stored, _ := orders.FindByID(order.OrderID); stored.Status != StatusPending {
+        t.Fatalf("stale cancel applied: status %s", stored.Status)
+    }
+
+    if w := doRequestWithHeaders(r, http.MethodPost, "/orders/"+order.OrderID.String()+"/cancel", "", map[string]string{"If-Match": "abc"}); w.Code != http.StatusBadRequest {
+        t.Fatalf("malformed If-Match: got status %d, want 400", w.Code)
+    }
+}
+
+func TestGetOrderETag(t *testing.T) {
+    resetOrders(t)
+    order := saveOrderWithStatus(StatusPending)
+    r := setupRouter()
+    path := "/orders/" + order.OrderID.String()
+
+    w := doRequest(r, http.MethodGet, path, "")
+    etag := w.Header().Get("ETag")
+    if w.Code != http.StatusOK || etag == "" {
+        t.Fatalf("got status %d, ETag %q", w.Code, etag)
+    }
+
+    for _, header := range []string{etag, `"1"`, `W/"0", ` + etag, "*"} {
+        w = doRequestWithHeaders(r, http.MethodGet, path, "", map[string]string{"If-None-Match": header})
+        if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+            t.Errorf("If-None-Match %s: got status %d with %d body bytes, want an empty 304", header, w.Code, w.Body.Len())
+        }
+        if got := w.Header().Get("ETag"); got != etag {
+            t.Errorf("If-None-Match %s: 304 carries ETag %q, want %q", header, got, etag)
+        }
+    }
+}
+
+func TestETagChangesWhenOrderChanges(t *testing.T) {
+    resetOrders(t)
+    order := saveOrderWithStatus(StatusPending)
+    r := setupRouter()
+    path := "/orders/" + order.OrderID.String()
+
+    etag := doRequest(r, http.MethodGet, path, "").Header().Get("ETag")
+    // The ETag is accepted as an If-Match precondition too.
+    if w := doRequestWithHeaders(r, http.MethodPost, path+"/cancel", "", map[string]string{"If-Match": etag}); w.Code != http.StatusOK {
+        t.Fatalf("cancel: got status %d: %s", w.Code, w.Body)
+    }
+
+    w := doRequestWithHeaders(r, http.MethodGet, path, "", map[string]string{"If-None-Match": etag})
+    if w.Code != http.StatusOK {
+        t.Fatalf("got status %d after the order changed, want 200", w.Code)
+    }
+    if got := w.Header().Get("ETag"); got == etag {
+        t.Fatalf("ETag %q did not change", got)
+    }
+}
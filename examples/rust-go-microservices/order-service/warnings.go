@@ -0,0 +1,83 @@
+package main
+
+import (
+    "context"
+    "fmt"
+)
+
+// Codes of the warnings the default rules give.
+const (
+    WarningHighQuantity = "HIGH_QUANTITY"
+    WarningFirstOrder   = "FIRST_ORDER"
+)
+
+// Warning is a soft finding about an order: worth a look, but not a reason
+// to turn it down.
+type Warning struct {
+    Code string `json:"code"`
+    // Field is the path of the field the warning is about, e.g.
+    // items[0].quantity, or empty if it is about the whole order.
+    Field   string `json:"field,omitempty"`
+    Message string `json:"message"`
+}
+
+// WarningRule looks at an order that has passed validation and been priced,
+// but not yet stored, and returns what is unusual about it. A rule that
+// can't tell, e.g. because the store is down, returns nothing rather than
+// holding the order up.
+type WarningRule func(ctx context.Context, order *Order) []Warning
+
+const defaultWarnItemQuantity = 100
+
+// warnItemQuantity is the quantity of one line item above which it is
+// flagged, well below the hard maxItemQuantity. Zero turns the rule off.
+var warnItemQuantity = defaultWarnItemQuantity
+
+// warningRules are checked against every new order.
+var warningRules = DefaultWarningRules()
+
+// DefaultWarningRules returns the rules the service runs with unless given
+// others.
+func DefaultWarningRules() []WarningRule {
+    return []WarningRule{highQuantityRule, firstOrderRule}
+}
+
+// highQuantityRule flags line items with a quantity above warnItemQuantity.
+func highQuantityRule(_ context.Context, order *Order) []Warning {
+    if warnItemQuantity <= 0 {
+        return nil
+    }
+    var warnings []Warning
+    for i, item := range order.Items {
+        if item.Quantity > warnItemQuantity {
+            warnings = append(warnings, Warning{
+                Code:    WarningHighQuantity,
+                Field:   fmt.Sprintf("items[%d].quantity", i),
+                Message: fmt.Sprintf("quantity %d is unusually high", item.Quantity),
+            })
+        }
+    }
+    return warnings
+}
+
+// firstOrderRule flags the first order a customer places.
+func firstOrderRule(_ context.Context, order *Order) []Warning {
+    previous, err := orders.ListByCustomer(order.CustomerID)
+    if err != nil || len(previous) > 0 {
+        return nil
+    }
+    return []Warning{{
+        Code:    WarningFirstOrder,
+        Field:   "customer_id",
+        Message: "this is the customer's first order",
+    }}
+}
+
+// collectWarnings runs warningRules against order, in order.
+func collectWarnings(ctx context.Context, order *Order) []Warning {
+    var warnings []Warning
+    for _, rule := range warningRules {
+        warnings = append(warnings, rule(ctx, order)...)
+    }
+    return warnings
+}
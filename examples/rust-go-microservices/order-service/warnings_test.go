@@ -0,0 +1,90 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func warningCodes(warnings []Warning) []string {
+    codes := make([]string, 0, len(warnings))
+    for _, w := range warnings {
+        codes = append(codes, w.Code)
+    }
+    return codes
+}
+
+func TestCreateOrderWithWarnings(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    body := `{"customer_id":"cust_new","items":[
+        {"product_id":"prod_1","quantity":1,"price":"1.00"},
+        {"product_id":"prod_2","quantity":500,"price":"0.10"}]}`
+    w := doRequest(r, http.MethodPost, "/orders", body)
+    if w.Code != http.StatusCreated {
+        t.Fatalf("got status %d: %s", w.Code, w.Body)
+    }
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if got := strings.Join(warningCodes(order.Warnings), ","); got != WarningHighQuantity+","+WarningFirstOrder {
+        t.Fatalf("got warnings %+v, want high quantity then first order", order.Warnings)
+    }
+    if field := order.Warnings[0].Field; field != "items[1].quantity" {
+        t.Fatalf("high quantity warning is about %q, want items[1].quantity", field)
+    }
+
+    // The warnings are kept with the order.
+    var fetched Order
+    json.Unmarshal(doRequest(r, http.MethodGet, "/orders/"+order.OrderID.String(), "").Body.Bytes(), &fetched)
+    if len(fetched.Warnings) != 2 {
+        t.Fatalf("fetched order has warnings %+v, want both", fetched.Warnings)
+    }
+
+    // A customer's second, ordinary order has nothing to flag.
+    w = doRequest(r, http.MethodPost, "/orders", `{"customer_id":"cust_new","items":[{"product_id":"prod_1","quantity":2,"price":"1.00"}]}`)
+    var second Order
+    json.Unmarshal(w.Body.Bytes(), &second)
+    if w.Code != http.StatusCreated || len(second.Warnings) != 0 || strings.Contains(w.Body.String(), `"warnings"`) {
+        t.Fatalf("got status %d: %s; want 201 without warnings", w.Code, w.Body)
+    }
+}
+
+func TestInvalidOrderGetsErrorsNotWarnings(t *testing.T) {
+    newPaymentServer(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", `{"customer_id":"cust_new","items":[{"product_id":"prod_1","quantity":0,"price":"1.00"}]}`)
+    if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "items[0].quantity") {
+        t.Fatalf("got status %d: %s; want 422 about items[0].quantity", w.Code, w.Body)
+    }
+    if strings.Contains(w.Body.String(), WarningFirstOrder) {
+        t.Fatalf("rejected order came with warnings: %s", w.Body)
+    }
+}
+
+func TestWarningRulesFromConfig(t *testing.T) {
+    newPaymentServer(t)
+    useServerGlobals(t)
+    rule := func(_ context.Context, order *Order) []Warning {
+        if order.Notes == "" {
+            return nil
+        }
+        return []Warning{{Code: "HAS_NOTES", Field: "notes", Message: "read the notes"}}
+    }
+    if _, err := NewServer(Config{Store: NewOrderStore(), Payments: payments, WarningRules: []WarningRule{rule}}); err != nil {
+        t.Fatal(err)
+    }
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", `{"customer_id":"cust_new","notes":"leave at the door","items":[{"product_id":"prod_1","quantity":1,"price":"1.00"}]}`)
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if w.Code != http.StatusCreated || len(order.Warnings) != 1 || order.Warnings[0].Code != "HAS_NOTES" {
+        t.Fatalf("got status %d: %s; want only the configured rule's warning", w.Code, w.Body)
+    }
+}
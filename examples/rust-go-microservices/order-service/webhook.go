@@ -0,0 +1,143 @@
+package main
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+)
+
+// webhookSignatureHeader carries "sha256=" followed by the hex HMAC-SHA256
+// of the raw request body, keyed with the shared webhook secret.
+const webhookSignatureHeader = "X-Payment-Signature"
+
+// webhookSecret is shared with the payment service, installed by NewServer.
+// While it is empty every callback is rejected, since none can be verified.
+var webhookSecret []byte
+
+// signWebhook returns the signature header value for body.
+func signWebhook(secret, body []byte) string {
+    mac := hmac.New(sha256.New, secret)
+    mac.Write(body)
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func validWebhookSignature(secret, body []byte, header string) bool {
+    if len(secret) == 0 || !strings.HasPrefix(header, "sha256=") {
+        return false
+    }
+    return hmac.Equal([]byte(header), []byte(signWebhook(secret, body)))
+}
+
+// orderStatusForPayment maps a payment service status to the order status
+// it settles the order in. Other payment statuses (e.g. "processing")
+// settle nothing.
+func orderStatusForPayment(paymentStatus string) (string, bool) {
+    switch paymentStatus {
+    case "approved":
+        return StatusConfirmed, true
+    case "declined", "failed":
+        return StatusPaymentFailed, true
+    }
+    return "", false
+}
+
+// paymentWebhook receives the payment service's asynchronous result for an
+// order. Callbacks may be retried and may arrive out of order, so one that
+// doesn't move the order forward is acknowledged and ignored rather than
+// treated as an error the payment service would keep retrying. A delivery
+// whose X-Payment-Delivery-ID was handled within the dedup window is
+// acknowledged without being looked at again.
+func paymentWebhook(c *gin.Context) {
+    body, err := io.ReadAll(c.Request.Body)
+    if err != nil {
+        respondBindError(c, err)
+        return
+    }
+    if !validWebhookSignature(webhookSecret, body, c.GetHeader(webhookSignatureHeader)) {
+        respondError(c, http.StatusUnauthorized, CodeInvalidSignature, "Missing or invalid webhook signature")
+        return
+    }
+    if deliveryID := c.GetHeader(webhookDeliveryHeader); deliveryID != "" {
+        ctx := c.Request.Context()
+        claimed, err := webhookDeliveries.Claim(ctx, deliveryID)
+        switch {
+        case err != nil:
+            // Handling a callback twice is safe, so carry on without the
+            // dedup store rather than have the payment service retry.
+            loggerFrom(ctx).Warn("webhook dedup store failed", "delivery_id", deliveryID, "error", err)
+        case !claimed:
+            c.JSON(http.StatusOK, gin.H{"delivery_id": deliveryID, "duplicate": true, "applied": false})
+            return
+        default:
+            defer func() {
+                if c.Writer.Status() >= http.StatusBadRequest {
+                    webhookDeliveries.Forget(context.WithoutCancel(ctx), deliveryID)
+                }
+            }()
+        }
+    }
+
+    var callback PaymentResponse
+    if err := json.Unmarshal(body, &callback); err != nil {
+        respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+        return
+    }
+    target, ok := orderStatusForPayment(callback.Status)
+    if !ok {
+        verr := &ValidationError{}
+        verr.add("status", "%q is not a final payment status", callback.Status)
+        respondValidationError(c, verr)
+        return
+    }
+
+    unlock := orderLocks.lock(callback.OrderID)
+    defer unlock()
+    order, err := orders.FindByID(callback.OrderID)
+    if errors.Is(err, ErrOrderNotFound) || (err == nil && order.DeletedAt != nil) {
+        respondError(c, http.StatusNotFound, CodeOrderNotFound, "Order not found")
+        return
+    }
+    if err != nil {
+        respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to load order")
+        return
+    }
+
+    var applied bool
+    if order.pendingSplit() != nil {
+        // Each part of a split order is a payment of its own; only the
+        // result for the part the order is waiting on moves it.
+        applied = settleSplit(c.Request.Context(), order, &callback)
+    } else {
+        if target == StatusConfirmed && !paymentAmountMatches(c.Request.Context(), order, order.TotalAmount, &callback) {
+            target = StatusPaymentMismatch
+        }
+        if applied = canTransition(order.Status, target, false); applied {
+            transitionStatus(order, target, "payment callback: "+callback.Status)
+        }
+    }
+    if applied {
+        releaseStock(c.Request.Context(), order)
+        if err := saveAndPublish(c.Request.Context(), order, settlementEvent(order)); err != nil {
+            respondError(c, http.StatusInternalServerError, CodeInternal, "Failed to save order")
+            return
+        }
+        recordSettlement(order)
+    } else {
+        loggerFrom(c.Request.Context()).Info("ignoring payment callback",
+            "order_id", order.OrderID, "order_status", order.Status, "payment_status", callback.Status)
+    }
+
+    c.JSON(http.StatusOK, gin.H{
+        "order_id": order.OrderID,
+        "status":   order.Status,
+        "applied":  applied,
+    })
+}
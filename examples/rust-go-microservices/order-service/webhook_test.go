@@ -0,0 +1,260 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+const testWebhookSecret = "test-webhook-secret"
+
+func useWebhookSecret(t *testing.T) {
+    t.Helper()
+
+    prev := webhookSecret
+    webhookSecret = []byte(testWebhookSecret)
+    t.Cleanup(func() { webhookSecret = prev })
+}
+
+func paymentCallback(orderID uuid.UUID, status string) string {
+    body, _ := json.Marshal(PaymentResponse{PaymentID: uuid.New(), OrderID: orderID, Status: status, ProcessedAt: time.Now()})
+    return string(body)
+}
+
+func postWebhook(r http.Handler, body, signature string) (int, map[string]interface{}) {
+    headers := map[string]string{}
+    if signature != "" {
+        headers[webhookSignatureHeader] = signature
+    }
+    w := doRequestWithHeaders(r, http.MethodPost, "/webhooks/payment", body, headers)
+    var resp map[string]interface{}
+    json.Unmarshal(w.Body.Bytes(), &resp)
+    return w.Code, resp
+}
+
+func TestPaymentWebhookSettlesOrder(t *testing.T) {
+    useWebhookSecret(t)
+    resetOrders(t)
+    rec := recordEvents(t)
+    r := setupRouter()
+
+    tests := []struct {
+        paymentStatus string
+        want          string
+        wantEvent     string
+    }{
+        {"approved", StatusConfirmed, EventOrderConfirmed},
+        {"declined", StatusPaymentFailed, EventOrderPaymentFailed},
+    }
+    for _, tt := range tests {
+        order := saveOrderWithStatus(StatusPending)
+        body := paymentCallback(order.OrderID, tt.paymentStatus)
+
+        code, resp := postWebhook(r, body, signWebhook([]byte(testWebhookSecret), []byte(body)))
+        if code != http.StatusOK || resp["applied"] != true {
+            t.Fatalf("%s: got %d %v", tt.paymentStatus, code, resp)
+        }
+        if stored, _ := orders.FindByID(order.OrderID); stored.Status != tt.want {
+            t.Fatalf("%s: order status %s, want %s", tt.paymentStatus, stored.Status, tt.want)
+        }
+        evs := rec.Events()
+        if last := evs[len(evs)-1]; last.Type != tt.wantEvent || last.OrderID != order.OrderID {
+            t.Fatalf("%s: got event %+v, want %s", tt.paymentStatus, last, tt.wantEvent)
+        }
+    }
+}
+
+func TestPendingPaymentSettledByWebhook(t *testing.T) {
+    useWebhookSecret(t)
+    fake := newPaymentServer(t)
+    fake.status = "pending"
+    resetOrders(t)
+    inv := newInventoryServer(t, map[string]int{"apple": 5, "pear": 5})
+    r := setupRouter()
+
+    w := doRequest(r, http.MethodPost, "/orders", twoItemOrder)
+    var order Order
+    json.Unmarshal(w.Body.Bytes(), &order)
+    if w.Code != http.StatusCreated || order.Status != StatusPending {
+        t.Fatalf("got status %d, order %q, want a pending order: %s", w.Code, order.Status, w.Body)
+    }
+    if _, held, released := inv.snapshot(); held != 2 || released != 0 {
+        t.Fatalf("pending order has %d reservations held, %d released", held, released)
+    }
+
+    body := paymentCallback(order.OrderID, "approved")
+    code, resp := postWebhook(r, body, signWebhook([]byte(testWebhookSecret), []byte(body)))
+    if code != http.StatusOK || resp["applied"] != true {
+        t.Fatalf("got %d %v", code, resp)
+    }
+    if stored, _ := orders.FindByID(order.OrderID); stored.Status != StatusConfirmed {
+        t.Fatalf("order status %s, want confirmed", stored.Status)
+    }
+    if _, held, _ := inv.snapshot(); held != 2 {
+        t.Fatalf("confirmed order has %d reservations held, want 2", held)
+    }
+}
+
+func TestPaymentWebhookIgnoresDuplicateAndStaleCallbacks(t *testing.T) {
+    useWebhookSecret(t)
+    resetOrders(t)
+    rec := recordEvents(t)
+    r := setupRouter()
+    order := saveOrderWithStatus(StatusPending)
+    sign := func(body string) string { return signWebhook([]byte(testWebhookSecret), []byte(body)) }
+
+    approved := paymentCallback(order.OrderID, "approved")
+    postWebhook(r, approved, sign(approved))
+
+    code, resp := postWebhook(r, approved, sign(approved))
+    if code != http.StatusOK || resp["applied"] != false {
+        t.Fatalf("duplicate: got %d %v, want 200 not applied", code, resp)
+    }
+    declined := paymentCallback(order.OrderID, "declined")
+    code, resp = postWebhook(r, declined, sign(declined))
+    if code != http.StatusOK || resp["applied"] != false {
+        t.Fatalf("stale: got %d %v, want 200 not applied", code, resp)
+    }
+
+    if stored, _ := orders.FindByID(order.OrderID); stored.Status != StatusConfirmed {
+        t.Fatalf("order status %s, want confirmed", stored.Status)
+    }
+    if n := len(rec.Events()); n != 1 {
+        t.Fatalf("got %d events, want 1", n)
+    }
+}
+
+func TestPaymentWebhookRejectsBadSignatures(t *testing.T) {
+    useWebhookSecret(t)
+    resetOrders(t)
+    r := setupRouter()
+    order := saveOrderWithStatus(StatusPending)
+    body := paymentCallback(order.OrderID, "approved")
+
+    tests := []struct {
+        name      string
+        body      string
+        signature string
+    }{
+        {"unsigned", body, ""},
+        {"wrong secret", body, signWebhook([]byte("other-secret"), []byte(body))},
+        {"tampered", paymentCallback(order.OrderID, "declined"), signWebhook([]byte(testWebhookSecret), []byte(body))},
+        {"malformed", body, "deadbeef"},
+    }
+    for _, tt := range tests {
+        if code, _ := postWebhook(r, tt.body, tt.signature); code != http.StatusUnauthorized {
+            t.Fatalf("%s: got status %d, want 401", tt.name, code)
+        }
+    }
+    if stored, _ := orders.FindByID(order.OrderID); stored.Status != StatusPending {
+        t.Fatalf("order status %s after rejected callbacks, want pending", stored.Status)
+    }
+}
+
+func TestPaymentWebhookUnknownOrder(t *testing.T) {
+    useWebhookSecret(t)
+    resetOrders(t)
+    r := setupRouter()
+
+    body := paymentCallback(uuid.New(), "approved")
+    if code, _ := postWebhook(r, body, signWebhook([]byte(testWebhookSecret), []byte(body))); code != http.StatusNotFound {
+        t.Fatalf("got status %d, want 404", code)
+    }
+}
+
+func TestPaymentWebhookRejectsEverythingWithoutSecret(t *testing.T) {
+    prev := webhookSecret
+    webhookSecret = nil
+    t.Cleanup(func() { webhookSecret = prev })
+    resetOrders(t)
+    r := setupRouter()
+
+    body := paymentCallback(saveOrderWithStatus(StatusPending).OrderID, "approved")
+    if code, _ := postWebhook(r, body, signWebhook(nil, []byte(body))); code != http.StatusUnauthorized {
+        t.Fatalf("got status %d, want 401", code)
+    }
+}
+
+// useDeliveryStore installs a MemoryDeliveryStore with the given window and
+// a clock the test moves.
+func useDeliveryStore(t *testing.T, window time.Duration) *time.Time {
+    t.Helper()
+
+    now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    store := NewMemoryDeliveryStore(window)
+    store.now = func() time.Time { return now }
+    prev := webhookDeliveries
+    webhookDeliveries = store
+    t.Cleanup(func() { webhookDeliveries = prev })
+    return &now
+}
+
+func postDelivery(r http.Handler, body, deliveryID string) (int, map[string]interface{}) {
+    w := doRequestWithHeaders(r, http.MethodPost, "/webhooks/payment", body, map[string]string{
+        webhookSignatureHeader: signWebhook([]byte(testWebhookSecret), []byte(body)),
+        webhookDeliveryHeader:  deliveryID,
+    })
+    var resp map[string]interface{}
+    json.Unmarshal(w.Body.Bytes(), &resp)
+    return w.Code, resp
+}
+
+func TestPaymentWebhookDuplicateDeliveryWithinWindow(t *testing.T) {
+    useWebhookSecret(t)
+    resetOrders(t)
+    rec := recordEvents(t)
+    useDeliveryStore(t, time.Minute)
+    r := setupRouter()
+    order := saveOrderWithStatus(StatusPending)
+    approved := paymentCallback(order.OrderID, "approved")
+
+    if code, resp := postDelivery(r, approved, "dlv_1"); code != http.StatusOK || resp["applied"] != true {
+        t.Fatalf("first delivery: got %d %v", code, resp)
+    }
+    code, resp := postDelivery(r, approved, "dlv_1")
+    if code != http.StatusOK || resp["duplicate"] != true || resp["status"] != nil {
+        t.Fatalf("duplicate delivery: got %d %v, want 200 without looking at the order", code, resp)
+    }
+    if n := len(rec.Events()); n != 1 {
+        t.Fatalf("got %d events, want 1", n)
+    }
+
+    // A new event for another order is processed as usual.
+    other := saveOrderWithStatus(StatusPending)
+    if code, resp := postDelivery(r, paymentCallback(other.OrderID, "approved"), "dlv_2"); code != http.StatusOK || resp["applied"] != true {
+        t.Fatalf("new delivery: got %d %v, want it applied", code, resp)
+    }
+}
+
+func TestPaymentWebhookResendAfterWindow(t *testing.T) {
+    useWebhookSecret(t)
+    resetOrders(t)
+    now := useDeliveryStore(t, time.Minute)
+    r := setupRouter()
+    order := saveOrderWithStatus(StatusPending)
+    approved := paymentCallback(order.OrderID, "approved")
+
+    postDelivery(r, approved, "dlv_1")
+    *now = now.Add(time.Minute)
+    code, resp := postDelivery(r, approved, "dlv_1")
+    if code != http.StatusOK || resp["duplicate"] != nil || resp["status"] != StatusConfirmed || resp["applied"] != false {
+        t.Fatalf("resend: got %d %v, want it processed and found already confirmed", code, resp)
+    }
+}
+
+func TestPaymentWebhookFailedDeliveryIsRetried(t *testing.T) {
+    useWebhookSecret(t)
+    resetOrders(t)
+    useDeliveryStore(t, time.Minute)
+    r := setupRouter()
+    missing := paymentCallback(uuid.New(), "approved")
+
+    for i := 0; i < 2; i++ {
+        if code, resp := postDelivery(r, missing, "dlv_1"); code != http.StatusNotFound {
+            t.Fatalf("attempt %d: got %d %v, want 404 each time", i, code, resp)
+        }
+    }
+}